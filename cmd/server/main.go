@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -9,8 +10,12 @@ import (
 	"github.com/forfire912/virServer/internal/config"
 	"github.com/forfire912/virServer/pkg/adapters"
 	"github.com/forfire912/virServer/pkg/api"
+	"github.com/forfire912/virServer/pkg/catalog"
+	"github.com/forfire912/virServer/pkg/dap"
+	"github.com/forfire912/virServer/pkg/job"
 	"github.com/forfire912/virServer/pkg/models"
 	"github.com/forfire912/virServer/pkg/session"
+	"github.com/forfire912/virServer/pkg/template"
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -63,11 +68,14 @@ func main() {
 		&models.Session{},
 		&models.Program{},
 		&models.Snapshot{},
+		&models.Artifact{},
 		&models.Job{},
 		&models.Processor{},
 		&models.Peripheral{},
+		&models.Device{},
 		&models.Bus{},
 		&models.BoardTemplate{},
+		&models.BoardTemplateVersion{},
 		&models.User{},
 		&models.AuditLog{},
 	); err != nil {
@@ -78,19 +86,27 @@ func main() {
 	createDirectories(cfg)
 	
 	// Initialize services
-	sessionService := session.NewService(db)
+	templateService := template.NewService(db)
+	sessionService := session.NewService(db, templateService, cfg.Storage.ArtifactPath)
+	catalogService := catalog.NewService(db)
+	jobService := job.NewService(db)
+	jobService.Register(models.JobVerify, session.NewVerifyRunner(sessionService))
+	jobService.Register(models.JobCoverage, session.NewCoverageRunner(sessionService))
+	jobService.Register(models.JobTrace, session.NewTraceRunner(sessionService))
+	jobService.Register(models.JobProfile, session.NewProfileRunner(sessionService))
 	
 	// Initialize and register backend adapters
 	qemuAdapter := adapters.NewQEMUAdapter(filepath.Join(cfg.Storage.WorkDir, "qemu"))
 	renodeAdapter := adapters.NewRenodeAdapter(filepath.Join(cfg.Storage.WorkDir, "renode"))
 	skyeyeAdapter := adapters.NewSkyEyeAdapter(filepath.Join(cfg.Storage.WorkDir, "skyeye"))
+	qemuAdapter.SetPluginDir(cfg.Backends.QEMUPluginDir)
 	
 	sessionService.RegisterAdapter(adapters.BackendQEMU, qemuAdapter)
 	sessionService.RegisterAdapter(adapters.BackendRenode, renodeAdapter)
 	sessionService.RegisterAdapter(adapters.BackendSkyEye, skyeyeAdapter)
 	
 	// Initialize API handler
-	apiHandler := api.NewHandler(sessionService)
+	apiHandler := api.NewHandler(sessionService, templateService, catalogService, jobService)
 	apiHandler.RegisterAdapter(adapters.BackendQEMU, qemuAdapter)
 	apiHandler.RegisterAdapter(adapters.BackendRenode, renodeAdapter)
 	apiHandler.RegisterAdapter(adapters.BackendSkyEye, skyeyeAdapter)
@@ -101,6 +117,26 @@ func main() {
 	// Seed initial data
 	seedInitialData(db)
 	
+	// Start the Debug Adapter Protocol listener for IDEs
+	if cfg.Server.DAPAddr != "" {
+		go func() {
+			log.Printf("DAP server listening on %s", cfg.Server.DAPAddr)
+			if err := dap.NewServer(sessionService).ListenAndServe(context.Background(), cfg.Server.DAPAddr); err != nil {
+				log.Printf("Warning: DAP server stopped: %v", err)
+			}
+		}()
+	}
+	
+	// Start the GDB proxy listener
+	if cfg.Server.GDBAddr != "" {
+		go func() {
+			log.Printf("GDB proxy listening on %s", cfg.Server.GDBAddr)
+			if err := sessionService.ListenAndServeGDB(context.Background(), cfg.Server.GDBAddr); err != nil {
+				log.Printf("Warning: GDB proxy stopped: %v", err)
+			}
+		}()
+	}
+	
 	// Start server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	log.Printf("Server starting on %s", addr)
@@ -191,6 +227,18 @@ func seedInitialData(db *gorm.DB) {
 		db.FirstOrCreate(&proc, models.Processor{ID: proc.ID})
 	}
 	
+	// Seed buses
+	buses := []models.Bus{
+		{ID: "ahb", Name: "AHB", Type: "AMBA", Description: "AMBA Advanced High-performance Bus", Width: 32, Backends: "qemu,renode,skyeye"},
+		{ID: "apb", Name: "APB", Type: "AMBA", Description: "AMBA Advanced Peripheral Bus", Width: 32, Backends: "qemu,renode"},
+		{ID: "axi", Name: "AXI", Type: "AMBA", Description: "AMBA Advanced eXtensible Interface", Width: 64, Backends: "qemu,renode"},
+		{ID: "pcie", Name: "PCIe", Type: "PCI", Description: "PCI Express", Width: 32, Backends: "qemu"},
+	}
+	
+	for _, bus := range buses {
+		db.FirstOrCreate(&bus, models.Bus{ID: bus.ID})
+	}
+	
 	// Seed board templates
 	templates := []models.BoardTemplate{
 		{
@@ -198,6 +246,7 @@ func seedInitialData(db *gorm.DB) {
 			Name:        "STM32F4 Discovery",
 			Description: "STM32F4 Discovery board with Cortex-M4",
 			Backend:     "qemu",
+			Version:     1,
 			Config:      `{"system_id":"stm32f4-disco","name":"STM32F4 Discovery","nodes":[{"id":"mcu","backend":"qemu","processor":{"type":"ARM Cortex-M4","cores":1,"frequency":168000000},"memory":[{"type":"Flash","address":134217728,"size":1048576,"access":"RX"},{"type":"RAM","address":536870912,"size":196608,"access":"RW"}],"peripherals":[{"type":"UART","name":"USART2","address":1073759232},{"type":"GPIO","name":"GPIOD","address":1073889280}]}]}`,
 			Tags:        "stm32,arm,cortex-m4",
 		},
@@ -11,13 +11,16 @@ type Config struct {
 	Database DatabaseConfig
 	Storage  StorageConfig
 	Auth     AuthConfig
+	Backends BackendsConfig
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
-	Host string
-	Port int
-	Mode string
+	Host    string
+	Port    int
+	Mode    string
+	DAPAddr string // TCP address of the Debug Adapter Protocol server; disabled when empty
+	GDBAddr string // TCP address of the GDB proxy; disabled when empty
 }
 
 // DatabaseConfig holds database configuration
@@ -41,6 +44,11 @@ type StorageConfig struct {
 	S3SecretKey   string
 }
 
+// BackendsConfig holds simulator backend configuration
+type BackendsConfig struct {
+	QEMUPluginDir string // Directory of the QEMU TCG plugins; instruction counting is disabled when empty
+}
+
 // AuthConfig holds auth configuration
 type AuthConfig struct {
 	JWTSecret  string
@@ -52,9 +60,11 @@ type AuthConfig struct {
 func LoadConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Host: getEnv("SERVER_HOST", "0.0.0.0"),
-			Port: getEnvInt("SERVER_PORT", 8080),
-			Mode: getEnv("SERVER_MODE", "debug"),
+			Host:    getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:    getEnvInt("SERVER_PORT", 8080),
+			Mode:    getEnv("SERVER_MODE", "debug"),
+			DAPAddr: getEnv("DAP_ADDR", ""),
+			GDBAddr: getEnv("GDB_PROXY_ADDR", ""),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -78,6 +88,9 @@ func LoadConfig() *Config {
 			APIKeyAuth: getEnvBool("API_KEY_AUTH", true),
 			OAuthURL:   getEnv("OAUTH_URL", ""),
 		},
+		Backends: BackendsConfig{
+			QEMUPluginDir: getEnv("QEMU_PLUGIN_DIR", ""),
+		},
 	}
 }
 
@@ -2,7 +2,15 @@ package adapters
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/coverage"
+	"github.com/forfire912/virServer/pkg/trace"
 )
 
 func TestQEMUAdapter_CreateInstance(t *testing.T) {
@@ -84,6 +92,38 @@ func TestQEMUAdapter_BackendType(t *testing.T) {
 	}
 }
 
+func TestRecordReplayArgs(t *testing.T) {
+	dir := t.TempDir()
+	qemuImg = "true" // Creates no image
+	defer func() { qemuImg = "qemu-img" }()
+
+	args, err := recordReplayArgs(&StartOptions{Record: true, ReplayDir: dir}, "shift=auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-icount", "shift=auto,rr=record,rrfile=" + dir + "/replay.bin,rrsnapshot=init",
+		"-drive", "file=" + dir + "/snapshots.qcow2,if=none,id=rr",
+	}
+	if strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Errorf("record args %q, want %q", args, want)
+	}
+
+	args, err = recordReplayArgs(&StartOptions{Replay: true, ReplayDir: dir}, "shift=auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(args[1], "rr=replay,") {
+		t.Errorf("replay args %q", args)
+	}
+
+	for _, options := range []*StartOptions{{Record: true}, {Record: true, Replay: true, ReplayDir: dir}} {
+		if _, err := recordReplayArgs(options, "shift=auto"); err == nil {
+			t.Errorf("recordReplayArgs(%+v) succeeded", options)
+		}
+	}
+}
+
 func TestRenodeAdapter_CreateInstance(t *testing.T) {
 	adapter := NewRenodeAdapter("/tmp/test-renode")
 	
@@ -141,3 +181,382 @@ func TestSkyEyeAdapter_CreateInstance(t *testing.T) {
 	// Clean up
 	adapter.DestroyInstance(ctx, instanceID)
 }
+
+func TestQEMUDeterministicArgs(t *testing.T) {
+	dir := t.TempDir()
+	adapter := NewQEMUAdapter(dir)
+	adapter.SetPluginDir("/usr/lib/qemu/plugins")
+	ctx := context.Background()
+	instanceID, err := adapter.CreateInstance(ctx, "det", &BoardConfig{}, &ResourceConfig{Deterministic: true, Seed: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := adapter.instances[instanceID]
+
+	args, err := adapter.launchArgs(instance, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	icount, err := icountArgs(instance, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Join(append(args, icount...), " ")
+	for _, want := range []string{
+		"-rtc base=2000-01-01T00:00:00,clock=vm",
+		"-seed 42",
+		"-plugin /usr/lib/qemu/plugins/libinsn.so -d plugin -D " + dir + "/" + instanceID + "/qemu.log",
+		"-icount shift=3,sleep=off",
+		"logfile=" + dir + "/" + instanceID + "/console.log",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("command line %q lacks %q", line, want)
+		}
+	}
+
+	// Recording keeps the fixed rate
+	qemuImg = "true"
+	defer func() { qemuImg = "qemu-img" }()
+	icount, err = icountArgs(instance, &StartOptions{Record: true, ReplayDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(icount[1], "shift=3,sleep=off,rr=record,") {
+		t.Errorf("record icount %q", icount[1])
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, instanceID, "qemu.log"), []byte("cpu 0 insns: 10\ntotal insns: 12345\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := adapter.InstructionCount(ctx, instanceID); err != nil || count != 12345 {
+		t.Errorf("InstructionCount = %d, %v", count, err)
+	}
+}
+
+func TestParseInsnCount(t *testing.T) {
+	if count, err := parseInsnCount([]byte("insns: 77\n")); err != nil || count != 77 {
+		t.Errorf("parseInsnCount = %d, %v", count, err)
+	}
+	if _, err := parseInsnCount([]byte("no plugin output\n")); err == nil {
+		t.Error("parseInsnCount succeeded without a count")
+	}
+}
+
+func TestRenodeDeterministicScript(t *testing.T) {
+	dir := t.TempDir()
+	adapter := NewRenodeAdapter(dir)
+	config := &BoardConfig{Nodes: []NodeConfig{{
+		ID:          "mcu",
+		Processor:   &ProcessorConfig{Type: "ARM Cortex-M4", Cores: 1},
+		Peripherals: []PeripheralConfig{{Name: "usart1", Type: "UART", Address: 0x40011000}},
+	}}}
+	instanceID, err := adapter.CreateInstance(context.Background(), "det", config, &ResourceConfig{Deterministic: true, Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := adapter.writePlatform(adapter.instances[instanceID])
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"emulation SetSeed 7\nemulation SetGlobalQuantum \"0.00001\"\nemulation SetGlobalSerialExecution true\nmach create",
+		"sysbus.usart1 CreateFileBackend @" + filepath.Join(dir, instanceID, "console.log") + " true\n",
+	} {
+		if !strings.Contains(string(script), want) {
+			t.Errorf("script %q lacks %q", script, want)
+		}
+	}
+}
+
+func TestQEMUCoverageArgs(t *testing.T) {
+	dir := t.TempDir()
+	adapter := NewQEMUAdapter(dir)
+	instanceID, err := adapter.CreateInstance(context.Background(), "cov", &BoardConfig{}, &ResourceConfig{Deterministic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := adapter.instances[instanceID]
+	instanceDir := filepath.Join(dir, instanceID)
+
+	// Without the drcov plugin the exec log shares QEMU's log with the insn plugin
+	plugins := t.TempDir()
+	adapter.SetPluginDir(plugins)
+	if collector := adapter.coverageCollector(); collector != qemuCoverageExecLog {
+		t.Fatalf("collector %q", collector)
+	}
+	args, err := adapter.launchArgs(instance, qemuCoverageExecLog, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-d plugin,in_asm,exec,nochain -D " + instanceDir + "/qemu.log"; !strings.Contains(strings.Join(args, " "), want) {
+		t.Errorf("args %q lack %q", args, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(plugins, "libdrcov.so"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if collector := adapter.coverageCollector(); collector != qemuCoveragePlugin {
+		t.Fatalf("collector %q", collector)
+	}
+	args, err = adapter.launchArgs(instance, qemuCoveragePlugin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-plugin " + plugins + "/libdrcov.so,filename=" + instanceDir + "/coverage.drcov"; !strings.Contains(strings.Join(args, " "), want) {
+		t.Errorf("args %q lack %q", args, want)
+	}
+}
+
+func TestQEMUExportCoverage(t *testing.T) {
+	dir := t.TempDir()
+	adapter := NewQEMUAdapter(dir)
+	ctx := context.Background()
+	instanceID, err := adapter.CreateInstance(ctx, "cov", &BoardConfig{}, &ResourceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adapter.ExportCoverage(ctx, instanceID); err == nil {
+		t.Error("coverage exported without collecting it")
+	}
+
+	log := `----------------
+IN: main
+0x08000100:  b580       push     {r7, lr}
+0x08000102:  af00       add      r7, sp, #0
+0x08000104:  d001       beq.n    #0x800010a
+
+Trace 0: 0x7f3c8c000100 [00000000/0000000008000100/00000000/ff200000] main
+----------------
+IN: main
+0x08000106:  2001       movs     r0, #1
+
+Trace 0: 0x7f3c8c000140 [00000000/0000000008000106/00000000/ff200000] main
+Trace 0: 0x7f3c8c000100 [00000000/0000000008000100/00000000/ff200000] main
+`
+	if err := os.MkdirAll(filepath.Join(dir, instanceID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, instanceID, "qemu.log"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	adapter.instances[instanceID].coverage = qemuCoverageExecLog
+	path, err := adapter.ExportCoverage(ctx, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	blocks, err := coverage.ReadDrcov(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []coverage.Block{{Start: 0x08000100, Size: 5}, {Start: 0x08000106, Size: 1}}
+	if len(blocks) != 2 || blocks[0] != want[0] || blocks[1] != want[1] {
+		t.Errorf("blocks %+v, want %+v", blocks, want)
+	}
+}
+
+func TestQEMUTraceArgs(t *testing.T) {
+	dir := t.TempDir()
+	adapter := NewQEMUAdapter(dir)
+	instanceID, err := adapter.CreateInstance(context.Background(), "trace", &BoardConfig{}, &ResourceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := adapter.instances[instanceID]
+
+	// Coverage and tracing share the exec log
+	args, err := adapter.launchArgs(instance, qemuCoverageExecLog, adapter.traceCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-d in_asm,exec,nochain,int -D"; !strings.Contains(strings.Join(args, " "), want) {
+		t.Errorf("args %q lack %q", args, want)
+	}
+
+	plugins := t.TempDir()
+	adapter.SetPluginDir(plugins)
+	if err := os.WriteFile(filepath.Join(plugins, "libexeclog.so"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	args, err = adapter.launchArgs(instance, "", adapter.traceCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-plugin " + plugins + "/libexeclog.so -d plugin,int -D"; !strings.Contains(strings.Join(args, " "), want) {
+		t.Errorf("args %q lack %q", args, want)
+	}
+}
+
+func TestQEMUExportTrace(t *testing.T) {
+	dir := t.TempDir()
+	adapter := NewQEMUAdapter(dir)
+	ctx := context.Background()
+	instanceID, err := adapter.CreateInstance(ctx, "trace", &BoardConfig{}, &ResourceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adapter.ExportTrace(ctx, instanceID); err == nil {
+		t.Error("trace exported without collecting it")
+	}
+
+	log := `----------------
+IN: main
+0x08000100:  b580       push     {r7, lr}
+0x08000102:  af00       add      r7, sp, #0
+
+Trace 0: 0x7f3c8c000100 [00000000/0000000008000100/00000000/ff200000] main
+Taking exception 5 [IRQ] on CPU 0
+...taking pending nonsecure exception 15
+Trace 0: 0x7f3c8c000180 [00000000/0000000008000200/00000000/ff200000] SysTick_Handler
+`
+	if err := os.MkdirAll(filepath.Join(dir, instanceID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, instanceID, "qemu.log"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	adapter.instances[instanceID].trace = qemuTraceExecLog
+	path, err := adapter.ExportTrace(ctx, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []trace.Record
+	if err := trace.ScanRecords(file, func(record trace.Record) bool {
+		records = append(records, record)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []trace.Record{
+		{Kind: trace.BlockRecord, PC: 0x08000100, Instructions: 2},
+		{Kind: trace.ExceptionRecord, Number: 15, Name: "IRQ"},
+		{Kind: trace.BlockRecord, PC: 0x08000200, Instructions: 1},
+	}
+	if len(records) != 3 || records[0] != want[0] || records[1] != want[1] || records[2] != want[2] {
+		t.Errorf("records %+v, want %+v", records, want)
+	}
+}
+
+func TestParseTraceLogExeclog(t *testing.T) {
+	log := `0, 0x8000100, 0xb580, "push {r7, lr}"
+0, 0x8000102, 0xaf00, "add r7, sp, #0"
+0, 0x8000104, 0xf000f802, "bl #0x800010c"
+0, 0x800010c, 0x4770, "bx lr"
+riscv_cpu_do_interrupt: hart:0, async:1, cause:0000000b, epc:0x80000010, tval:0x00000000, desc=m_external
+`
+	var records []trace.Record
+	if err := parseTraceLog(strings.NewReader(log), func(record trace.Record) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []trace.Record{
+		{Kind: trace.BlockRecord, PC: 0x08000100, Instructions: 3},
+		{Kind: trace.BlockRecord, PC: 0x0800010c, Instructions: 1},
+		{Kind: trace.ExceptionRecord, Number: 11, Name: "m_external"},
+	}
+	if len(records) != 3 || records[0] != want[0] || records[1] != want[1] || records[2] != want[2] {
+		t.Errorf("records %+v, want %+v", records, want)
+	}
+}
+
+func TestParseExeclogAccesses(t *testing.T) {
+	accesses := parseExeclogAccesses(`0, 0x8000104, 0xf8c10000, "str.w r0, [r1]", store, 0x20000400, RAM`)
+	if len(accesses) != 1 || accesses[0] != (MemoryAccess{PC: 0x8000104, Address: 0x20000400, Size: 4, Write: true}) {
+		t.Errorf("store %+v", accesses)
+	}
+	accesses = parseExeclogAccesses(`0, 0x8000108, 0xbc03, "pop {r0, r1}", load, 0x20000ff8, load, 0x20000ffc`)
+	if len(accesses) != 2 || accesses[1].Address != 0x20000ffc || accesses[1].Write {
+		t.Errorf("pop %+v", accesses)
+	}
+	if accesses := parseExeclogAccesses(`0, 0x800010c, 0x3001, "adds r0, #1"`); len(accesses) != 0 {
+		t.Errorf("accesses of adds %+v", accesses)
+	}
+
+	sizes := map[string]int{
+		"ldrb r0, [r1]":           1,
+		"strbeq r0, [r1]":         1,
+		"ldrsh r0, [r1, #2]":      2,
+		"strhhi r0, [r1]":         2,
+		"ldrhi r0, [r1]":          4,
+		"ldmib r0, {r1, r2}":      4,
+		"ldrd r0, r1, [r2]":       8,
+		"strdeq r0, r1, [r2]":     8,
+		"ldrexd r0, r1, [r2]":     8,
+		"strexd r3, r0, r1, [r2]": 8,
+		"ldadd w0, w1, [x2]":      4,
+		"ldr x0, [sp, #8]":        8,
+		"ldr w0, [x1]":            4,
+		"ldrsw x0, [x1]":          4,
+		"c.lw a0, 0(a1)":          4,
+		"sd ra, 8(sp)":            8,
+		"amoadd.d a0, a1, (a2)":   8,
+		"vldr d0, [r0]":           0,
+	}
+	for disassembly, want := range sizes {
+		if got := accessSize(disassembly); got != want {
+			t.Errorf("accessSize(%q) = %d, want %d", disassembly, got, want)
+		}
+	}
+}
+
+func TestFollowAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qemu.log")
+	var accesses []MemoryAccess
+	m := monitorMemory(path, func(access MemoryAccess) { accesses = append(accesses, access) })
+
+	// The monitor waits for QEMU to create the log and reads it as it grows
+	time.Sleep(2 * qemuLogPoll)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fmt.Fprintln(f, `0, 0x8000104, 0x6008, "str r0, [r1]", store, 0x40000000`)
+	time.Sleep(2 * qemuLogPoll)
+	fmt.Fprint(f, `0, 0x8000106, 0x7808, "ldrb r0, [r1]", load, 0x40000004`)
+	time.Sleep(2 * qemuLogPoll)
+	fmt.Fprintln(f, `, RAM`)
+	m.close()
+
+	want := []MemoryAccess{
+		{PC: 0x8000104, Address: 0x40000000, Size: 4, Write: true},
+		{PC: 0x8000106, Address: 0x40000004, Size: 1},
+	}
+	if fmt.Sprint(accesses) != fmt.Sprint(want) {
+		t.Errorf("accesses %+v, want %+v", accesses, want)
+	}
+}
+
+func TestQEMUMonitorMemory(t *testing.T) {
+	adapter := NewQEMUAdapter(t.TempDir())
+	ctx := context.Background()
+	instanceID, err := adapter.CreateInstance(ctx, "memory", &BoardConfig{}, &ResourceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter.instances[instanceID].Running = true
+
+	// The exec log has no memory accesses
+	options := &StartOptions{Program: "firmware.elf", MonitorMemory: true, OnMemoryAccess: func(MemoryAccess) {}}
+	if err := adapter.StartProgram(ctx, instanceID, "program-1", options); err == nil || !strings.Contains(err.Error(), "libexeclog.so") {
+		t.Errorf("monitoring without the execlog plugin: %v", err)
+	}
+	if !adapter.GetCapabilities().Features["memory_monitor"] {
+		t.Error("memory monitoring not advertised")
+	}
+}
@@ -0,0 +1,259 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormat identifies the serialization of a board configuration
+type ConfigFormat string
+
+const (
+	FormatJSON ConfigFormat = "json"
+	FormatYAML ConfigFormat = "yaml"
+)
+
+// FormatFromContentType maps a MIME type to a config format
+func FormatFromContentType(contentType string) (ConfigFormat, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	switch mediaType {
+	case "application/json", "text/json":
+		return FormatJSON, true
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return FormatYAML, true
+	}
+	return "", false
+}
+
+// FormatFromFilename maps a file extension to a config format
+func FormatFromFilename(name string) (ConfigFormat, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON, true
+	case ".yaml", ".yml":
+		return FormatYAML, true
+	}
+	return "", false
+}
+
+// DetectFormat guesses the format of a document: JSON documents start with an
+// object or array, anything else is treated as YAML
+func DetectFormat(data []byte) ConfigFormat {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// ParseBoardConfig decodes a board configuration. YAML documents are first
+// converted to JSON so that both formats produce identical BoardConfig values,
+// including the untyped Features and Properties maps.
+func ParseBoardConfig(data []byte, format ConfigFormat) (*BoardConfig, error) {
+	if format == FormatYAML {
+		converted, err := YAMLToJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		data = converted
+	}
+
+	var config BoardConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid board config: %w", err)
+	}
+	return &config, nil
+}
+
+// MarshalBoardConfig encodes a board configuration in the requested format
+func MarshalBoardConfig(config *BoardConfig, format ConfigFormat) ([]byte, error) {
+	if format == FormatYAML {
+		return yaml.Marshal(config)
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// YAMLToJSON converts a YAML document to its JSON equivalent
+func YAMLToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	normalized, err := normalizeYAML(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalized)
+}
+
+// normalizeYAML rewrites the generic maps produced by the YAML decoder into
+// string-keyed maps that encoding/json can marshal
+func normalizeYAML(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			normalized, err := normalizeYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = normalized
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized, err := normalizeYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(key)] = normalized
+		}
+		return out, nil
+	case []interface{}:
+		for i, item := range v {
+			normalized, err := normalizeYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalized
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// RawBoardConfig is a board configuration embedded in a request, given either
+// as an object or as a string holding a JSON or YAML document
+type RawBoardConfig []byte
+
+// UnmarshalJSON accepts both a nested object and a document string
+func (r *RawBoardConfig) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(trimmed, []byte("null")):
+		*r = nil
+	case len(trimmed) > 0 && trimmed[0] == '"':
+		var text string
+		if err := json.Unmarshal(trimmed, &text); err != nil {
+			return err
+		}
+		*r = RawBoardConfig(text)
+	default:
+		*r = append((*r)[:0], trimmed...)
+	}
+	return nil
+}
+
+// MarshalJSON emits the configuration as a nested object when it is JSON and
+// as a document string otherwise
+func (r RawBoardConfig) MarshalJSON() ([]byte, error) {
+	if len(r) == 0 {
+		return []byte("null"), nil
+	}
+	if DetectFormat(r) == FormatJSON && json.Valid(r) {
+		return r, nil
+	}
+	return json.Marshal(string(r))
+}
+
+// Parse decodes the embedded configuration, detecting its format
+func (r RawBoardConfig) Parse() (*BoardConfig, error) {
+	return ParseBoardConfig(r, DetectFormat(r))
+}
+
+// JSON returns the embedded document as JSON without decoding it into a
+// BoardConfig, so that partial or parameterized documents are preserved
+func (r RawBoardConfig) JSON() ([]byte, error) {
+	if DetectFormat(r) == FormatYAML {
+		return YAMLToJSON(r)
+	}
+	if !json.Valid(r) {
+		return nil, fmt.Errorf("invalid JSON document")
+	}
+	return r, nil
+}
+
+// Validate checks the structural consistency of a board configuration: node
+// and peripheral identities, memory layout and interconnect references
+func (c *BoardConfig) Validate() error {
+	var problems []string
+	if len(c.Nodes) == 0 {
+		problems = append(problems, "at least one node is required")
+	}
+
+	nodes := make(map[string]bool, len(c.Nodes))
+	for i, node := range c.Nodes {
+		if node.ID == "" {
+			problems = append(problems, fmt.Sprintf("nodes[%d]: id required", i))
+			continue
+		}
+		if nodes[node.ID] {
+			problems = append(problems, fmt.Sprintf("node %s: duplicate id", node.ID))
+		}
+		nodes[node.ID] = true
+
+		if node.Processor != nil && node.Processor.Cores < 0 {
+			problems = append(problems, fmt.Sprintf("node %s: cores must not be negative", node.ID))
+		}
+
+		for j, mem := range node.Memory {
+			if mem.Size == 0 {
+				problems = append(problems, fmt.Sprintf("node %s: memory[%d] has zero size", node.ID, j))
+				continue
+			}
+			for k := 0; k < j; k++ {
+				other := node.Memory[k]
+				if other.Size != 0 && mem.Address < other.Address+other.Size && other.Address < mem.Address+mem.Size {
+					problems = append(problems, fmt.Sprintf("node %s: memory[%d] overlaps memory[%d]", node.ID, j, k))
+				}
+			}
+		}
+
+		peripherals := make(map[string]bool, len(node.Peripherals))
+		for j, periph := range node.Peripherals {
+			if periph.Name == "" || periph.Type == "" {
+				problems = append(problems, fmt.Sprintf("node %s: peripherals[%d] requires name and type", node.ID, j))
+				continue
+			}
+			if peripherals[periph.Name] {
+				problems = append(problems, fmt.Sprintf("node %s: duplicate peripheral %s", node.ID, periph.Name))
+			}
+			peripherals[periph.Name] = true
+		}
+	}
+
+	if c.Interconnect != nil {
+		checkNode := func(context, id string) {
+			if !nodes[id] {
+				problems = append(problems, fmt.Sprintf("%s: unknown node %s", context, id))
+			}
+		}
+		for _, shm := range c.Interconnect.SharedMemory {
+			for _, id := range shm.Nodes {
+				checkNode("shared memory "+shm.ID, id)
+			}
+		}
+		for _, mapping := range c.Interconnect.MMIOMap {
+			checkNode("mmio map", mapping.SourceNode)
+			checkNode("mmio map", mapping.TargetNode)
+		}
+		for _, route := range c.Interconnect.IRQRoutes {
+			checkNode("irq route", route.SourceNode)
+			checkNode("irq route", route.TargetNode)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid board config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
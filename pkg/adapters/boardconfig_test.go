@@ -0,0 +1,89 @@
+package adapters
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBoardConfig_YAMLMatchesJSON(t *testing.T) {
+	for _, name := range []string{"stm32f4-disco", "multi-node-system"} {
+		t.Run(name, func(t *testing.T) {
+			fromJSON := loadExampleConfig(t, name+".json", FormatJSON)
+			fromYAML := loadExampleConfig(t, name+".yaml", FormatYAML)
+
+			if !reflect.DeepEqual(fromJSON, fromYAML) {
+				t.Fatalf("YAML and JSON configs differ:\njson: %+v\nyaml: %+v", fromJSON, fromYAML)
+			}
+		})
+	}
+}
+
+func TestBoardConfig_RoundTrip(t *testing.T) {
+	original := loadExampleConfig(t, "stm32f4-disco.json", FormatJSON)
+
+	for _, format := range []ConfigFormat{FormatJSON, FormatYAML} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := MarshalBoardConfig(original, format)
+			if err != nil {
+				t.Fatalf("Failed to marshal config: %v", err)
+			}
+			if detected := DetectFormat(data); detected != format {
+				t.Errorf("Expected format %s to be detected, got %s", format, detected)
+			}
+
+			decoded, err := ParseBoardConfig(data, format)
+			if err != nil {
+				t.Fatalf("Failed to parse config: %v", err)
+			}
+			if !reflect.DeepEqual(original, decoded) {
+				t.Fatalf("Round trip changed config:\nbefore: %+v\nafter:  %+v", original, decoded)
+			}
+		})
+	}
+}
+
+func TestRawBoardConfig_Unmarshal(t *testing.T) {
+	var req struct {
+		Config RawBoardConfig `json:"config"`
+	}
+
+	inputs := []string{
+		`{"config": {"system_id": "s1", "nodes": [{"id": "n1", "memory": [{"address": 536870912}]}]}}`,
+		`{"config": "{\"system_id\": \"s1\", \"nodes\": [{\"id\": \"n1\", \"memory\": [{\"address\": 536870912}]}]}"}`,
+		`{"config": "system_id: s1\nnodes:\n  - id: n1\n    memory:\n      - address: 0x20000000\n"}`,
+	}
+
+	for _, input := range inputs {
+		data, err := YAMLToJSON([]byte(input))
+		if err != nil {
+			t.Fatalf("Failed to convert request: %v", err)
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatalf("Failed to decode request %s: %v", input, err)
+		}
+		config, err := req.Config.Parse()
+		if err != nil {
+			t.Fatalf("Failed to parse config from %s: %v", input, err)
+		}
+		if config.SystemID != "s1" || len(config.Nodes) != 1 || config.Nodes[0].Memory[0].Address != 0x20000000 {
+			t.Errorf("Unexpected config from %s: %+v", input, config)
+		}
+	}
+}
+
+func loadExampleConfig(t *testing.T, name string, format ConfigFormat) *BoardConfig {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "examples", "configs", name))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	config, err := ParseBoardConfig(data, format)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", name, err)
+	}
+	return config
+}
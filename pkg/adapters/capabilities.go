@@ -0,0 +1,211 @@
+package adapters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BackendAuto asks the session layer to pick the best registered backend for a node
+const BackendAuto BackendType = "auto"
+
+// backendPreference breaks ties between equally scored backends in SelectBackend
+var backendPreference = []BackendType{BackendQEMU, BackendRenode, BackendSkyEye}
+
+// CompatibilityError reports why a node cannot run on a backend
+type CompatibilityError struct {
+	NodeID  string      `json:"node_id"`
+	Backend BackendType `json:"backend"`
+	Reasons []string    `json:"reasons"`
+}
+
+func (e *CompatibilityError) Error() string {
+	if e.Backend == BackendAuto {
+		return fmt.Sprintf("node %s: no compatible backend: %s", e.NodeID, strings.Join(e.Reasons, "; "))
+	}
+	return fmt.Sprintf("node %s is not compatible with backend %s: %s", e.NodeID, e.Backend, strings.Join(e.Reasons, "; "))
+}
+
+// CompatibilityErrors collects the incompatibilities of every node in a BoardConfig
+type CompatibilityErrors []*CompatibilityError
+
+func (e CompatibilityErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// CheckCompatibility compares a node against the capabilities of a backend and
+// returns one reason per unsupported processor, peripheral, bus, feature or limit.
+// An empty result means the node can be instantiated on that backend.
+func CheckCompatibility(node *NodeConfig, interconnect *InterconnectConfig, caps *BackendCapabilities) []string {
+	if caps == nil {
+		return []string{"backend reports no capabilities"}
+	}
+
+	var reasons []string
+
+	if node.Processor != nil {
+		if node.Processor.Type != "" && !containsFold(caps.Processors, node.Processor.Type) {
+			reasons = append(reasons, fmt.Sprintf("processor %q is not supported", node.Processor.Type))
+		}
+		if node.Processor.Cores > 1 && !caps.Features["multicore"] {
+			reasons = append(reasons, fmt.Sprintf("%d cores requested but multicore is not supported", node.Processor.Cores))
+		}
+		if max, ok := caps.Limits["max_cores"]; ok && node.Processor.Cores > max {
+			reasons = append(reasons, fmt.Sprintf("%d cores exceeds max_cores %d", node.Processor.Cores, max))
+		}
+	}
+
+	if max, ok := caps.Limits["max_memory_gb"]; ok {
+		var total uint64
+		for _, mem := range node.Memory {
+			total += mem.Size
+		}
+		if limit := uint64(max) << 30; total > limit {
+			reasons = append(reasons, fmt.Sprintf("%d bytes of memory exceeds max_memory_gb %d", total, max))
+		}
+	}
+
+	if max, ok := caps.Limits["max_peripherals"]; ok && len(node.Peripherals) > max {
+		reasons = append(reasons, fmt.Sprintf("%d peripherals exceeds max_peripherals %d", len(node.Peripherals), max))
+	}
+
+	for _, periph := range node.Peripherals {
+		if !containsFold(caps.Peripherals, periph.Type) {
+			reasons = append(reasons, fmt.Sprintf("peripheral %s: type %q is not supported", periph.Name, periph.Type))
+		}
+		if bus, ok := periph.Properties["bus"].(string); ok && bus != "" && !containsFold(caps.Buses, bus) {
+			reasons = append(reasons, fmt.Sprintf("peripheral %s: bus %q is not supported", periph.Name, bus))
+		}
+	}
+
+	if interconnect != nil {
+		for _, shm := range interconnect.SharedMemory {
+			if containsFold(shm.Nodes, node.ID) && !caps.Features["shared_memory"] {
+				reasons = append(reasons, fmt.Sprintf("shared memory %s requires shared_memory support", shm.ID))
+			}
+		}
+	}
+
+	return reasons
+}
+
+// SelectBackend picks the best backend for a node from the given adapters.
+// Compatible backends are ranked by the number of features they support, with
+// ties broken in QEMU, Renode, SkyEye order. When no backend is compatible the
+// returned CompatibilityError lists the reasons reported by every candidate.
+func SelectBackend(node *NodeConfig, interconnect *InterconnectConfig, candidates map[BackendType]BackendAdapter) (BackendType, error) {
+	backends := rankedBackends(candidates)
+
+	var (
+		best      BackendType
+		bestScore = -1
+		reasons   []string
+	)
+	for _, backend := range backends {
+		caps := candidates[backend].GetCapabilities()
+		if problems := CheckCompatibility(node, interconnect, caps); len(problems) > 0 {
+			for _, problem := range problems {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", backend, problem))
+			}
+			continue
+		}
+		if score := featureScore(caps); score > bestScore {
+			best, bestScore = backend, score
+		}
+	}
+
+	if bestScore < 0 {
+		if len(backends) == 0 {
+			reasons = append(reasons, "no backends registered")
+		}
+		return "", &CompatibilityError{NodeID: node.ID, Backend: BackendAuto, Reasons: reasons}
+	}
+	return best, nil
+}
+
+// SelectBoardBackend picks the best backend to host every node of a board
+// from the given adapters, ranked as SelectBackend ranks them. When no
+// backend can host them all, the errors list for each node the reasons of
+// the backends that cannot run it. There must be at least one node.
+func SelectBoardBackend(nodes []NodeConfig, interconnect *InterconnectConfig, candidates map[BackendType]BackendAdapter) (BackendType, error) {
+	var (
+		best      BackendType
+		bestScore = -1
+		reasons   = make([][]string, len(nodes))
+	)
+	for _, backend := range rankedBackends(candidates) {
+		caps := candidates[backend].GetCapabilities()
+		hosts := true
+		for i := range nodes {
+			for _, problem := range CheckCompatibility(&nodes[i], interconnect, caps) {
+				reasons[i] = append(reasons[i], fmt.Sprintf("%s: %s", backend, problem))
+				hosts = false
+			}
+		}
+		if score := featureScore(caps); hosts && score > bestScore {
+			best, bestScore = backend, score
+		}
+	}
+	if bestScore >= 0 {
+		return best, nil
+	}
+
+	var problems CompatibilityErrors
+	for i := range nodes {
+		if len(candidates) == 0 {
+			reasons[i] = []string{"no backends registered"}
+		}
+		if len(reasons[i]) > 0 {
+			problems = append(problems, &CompatibilityError{NodeID: nodes[i].ID, Backend: BackendAuto, Reasons: reasons[i]})
+		}
+	}
+	return "", problems
+}
+
+// rankedBackends orders backends by preference, for ties of featureScore
+func rankedBackends(candidates map[BackendType]BackendAdapter) []BackendType {
+	var backends []BackendType
+	for backend := range candidates {
+		backends = append(backends, backend)
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		ri, rj := preferenceRank(backends[i]), preferenceRank(backends[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return backends[i] < backends[j]
+	})
+	return backends
+}
+
+func featureScore(caps *BackendCapabilities) int {
+	score := 0
+	for _, enabled := range caps.Features {
+		if enabled {
+			score++
+		}
+	}
+	return score
+}
+
+func preferenceRank(backend BackendType) int {
+	for i, preferred := range backendPreference {
+		if backend == preferred {
+			return i
+		}
+	}
+	return len(backendPreference)
+}
+
+func containsFold(values []string, want string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package adapters
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	caps := NewSkyEyeAdapter("/tmp/test-skyeye").GetCapabilities()
+
+	tests := []struct {
+		name    string
+		node    NodeConfig
+		reasons []string
+	}{
+		{
+			name: "compatible",
+			node: NodeConfig{
+				ID:          "mcu",
+				Processor:   &ProcessorConfig{Type: "ARM Cortex-M3", Cores: 1},
+				Peripherals: []PeripheralConfig{{Type: "UART", Name: "UART0"}},
+			},
+		},
+		{
+			name: "too many cores",
+			node: NodeConfig{
+				ID:        "mcu",
+				Processor: &ProcessorConfig{Type: "ARM Cortex-A8", Cores: 4},
+			},
+			reasons: []string{"multicore is not supported", "exceeds max_cores 1"},
+		},
+		{
+			name: "unsupported processor and peripheral",
+			node: NodeConfig{
+				ID:          "mcu",
+				Processor:   &ProcessorConfig{Type: "RISC-V RV32", Cores: 1},
+				Peripherals: []PeripheralConfig{{Type: "CAN", Name: "CAN1"}},
+			},
+			reasons: []string{`processor "RISC-V RV32"`, `peripheral CAN1: type "CAN"`},
+		},
+		{
+			name: "memory limit",
+			node: NodeConfig{
+				ID:     "mcu",
+				Memory: []MemoryRegion{{Type: "RAM", Size: 5 << 30}},
+			},
+			reasons: []string{"exceeds max_memory_gb 4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := CheckCompatibility(&tt.node, nil, caps)
+			if len(reasons) != len(tt.reasons) {
+				t.Fatalf("Expected %d reasons, got %v", len(tt.reasons), reasons)
+			}
+			for i, want := range tt.reasons {
+				if !strings.Contains(reasons[i], want) {
+					t.Errorf("Reason %q should contain %q", reasons[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectBackend(t *testing.T) {
+	candidates := map[BackendType]BackendAdapter{
+		BackendQEMU:   NewQEMUAdapter("/tmp/test-qemu"),
+		BackendRenode: NewRenodeAdapter("/tmp/test-renode"),
+		BackendSkyEye: NewSkyEyeAdapter("/tmp/test-skyeye"),
+	}
+
+	node := &NodeConfig{
+		ID:        "legacy",
+		Processor: &ProcessorConfig{Type: "ARM7TDMI", Cores: 1},
+	}
+	backend, err := SelectBackend(node, nil, candidates)
+	if err != nil {
+		t.Fatalf("Failed to select backend: %v", err)
+	}
+	if backend != BackendSkyEye {
+		t.Errorf("Expected skyeye for ARM7TDMI, got %s", backend)
+	}
+
+	node = &NodeConfig{
+		ID:        "mcu",
+		Processor: &ProcessorConfig{Type: "ARM Cortex-M3", Cores: 1},
+	}
+	backend, err = SelectBackend(node, nil, candidates)
+	if err != nil {
+		t.Fatalf("Failed to select backend: %v", err)
+	}
+	if backend != BackendQEMU {
+		t.Errorf("Expected qemu for Cortex-M3, got %s", backend)
+	}
+
+	node = &NodeConfig{
+		ID:        "dsp",
+		Processor: &ProcessorConfig{Type: "TI C6000", Cores: 1},
+	}
+	if _, err := SelectBackend(node, nil, candidates); err == nil {
+		t.Error("Expected an error for an unsupported processor")
+	}
+}
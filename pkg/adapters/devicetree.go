@@ -0,0 +1,473 @@
+package adapters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/devicetree"
+)
+
+// CompatibleMapping associates a device tree compatible string with a
+// peripheral type
+type CompatibleMapping struct {
+	Compatible string
+	Type       string
+}
+
+// CompatibleMappings lists known device tree bindings. The first entry for a
+// type is the binding used when generating device trees for that type.
+var CompatibleMappings = []CompatibleMapping{
+	{"arm,pl011", "UART"},
+	{"ns16550a", "UART"},
+	{"ns16550", "UART"},
+	{"snps,dw-apb-uart", "UART"},
+	{"st,stm32-uart", "UART"},
+	{"st,stm32-usart", "UART"},
+	{"st,stm32f4-usart", "UART"},
+	{"sifive,uart0", "UART"},
+	{"xlnx,xuartps", "UART"},
+	{"cdns,uart-r1p12", "UART"},
+	{"arm,pl061", "GPIO"},
+	{"st,stm32-gpio", "GPIO"},
+	{"sifive,gpio0", "GPIO"},
+	{"snps,dw-apb-gpio", "GPIO"},
+	{"arm,pl022", "SPI"},
+	{"st,stm32f4-spi", "SPI"},
+	{"sifive,spi0", "SPI"},
+	{"snps,dw-apb-ssi", "SPI"},
+	{"arm,versatile-i2c", "I2C"},
+	{"st,stm32f4-i2c", "I2C"},
+	{"snps,designware-i2c", "I2C"},
+	{"arm,sp804", "Timer"},
+	{"st,stm32-timers", "Timer"},
+	{"arm,pl031", "RTC"},
+	{"st,stm32-rtc", "RTC"},
+	{"google,goldfish-rtc", "RTC"},
+	{"smsc,lan9118", "Ethernet"},
+	{"cdns,macb", "Ethernet"},
+	{"st,stm32-dwmac", "Ethernet"},
+	{"snps,dwmac", "Ethernet"},
+	{"generic-ehci", "USB"},
+	{"generic-ohci", "USB"},
+	{"snps,dwc2", "USB"},
+	{"bosch,m_can", "CAN"},
+	{"st,stm32-bxcan", "CAN"},
+	{"st,stm32f4-adc", "ADC"},
+	{"st,stm32-dac", "DAC"},
+}
+
+// genericCompatibles covers vendor bindings not listed in CompatibleMappings
+// by the device class that usually appears in the compatible string
+var genericCompatibles = []CompatibleMapping{
+	{"uart", "UART"},
+	{"usart", "UART"},
+	{"serial", "UART"},
+	{"gpio", "GPIO"},
+	{"spi", "SPI"},
+	{"i2c", "I2C"},
+	{"timer", "Timer"},
+	{"rtc", "RTC"},
+	{"ethernet", "Ethernet"},
+	{"ehci", "USB"},
+	{"ohci", "USB"},
+	{"usb", "USB"},
+	{"can", "CAN"},
+	{"adc", "ADC"},
+	{"dac", "DAC"},
+}
+
+// processorProvided are bindings for functions of the processor itself,
+// which every backend models along with the CPU
+var processorProvided = map[string]bool{
+	"arm,armv7-timer": true,
+	"arm,armv8-timer": true,
+	"arm,psci":        true,
+	"arm,psci-0.2":    true,
+	"arm,psci-1.0":    true,
+	"riscv,clint0":    true,
+	"sifive,clint0":   true,
+	"fixed-clock":     true,
+}
+
+// cpuCompatibles maps CPU compatible strings to processor names
+var cpuCompatibles = map[string]string{
+	"arm,cortex-m0":  "ARM Cortex-M0",
+	"arm,cortex-m3":  "ARM Cortex-M3",
+	"arm,cortex-m4":  "ARM Cortex-M4",
+	"arm,cortex-m4f": "ARM Cortex-M4",
+	"arm,cortex-m7":  "ARM Cortex-M7",
+	"arm,cortex-a9":  "ARM Cortex-A9",
+	"arm,cortex-a53": "ARM Cortex-A53",
+	"arm,cortex-a72": "ARM Cortex-A72",
+}
+
+// PeripheralTypeForCompatible maps a list of compatible strings, most specific
+// first, to a peripheral type
+func PeripheralTypeForCompatible(compatibles []string) (string, bool) {
+	for _, compatible := range compatibles {
+		for _, mapping := range CompatibleMappings {
+			if strings.EqualFold(mapping.Compatible, compatible) {
+				return mapping.Type, true
+			}
+		}
+	}
+	for _, compatible := range compatibles {
+		_, device, _ := strings.Cut(strings.ToLower(compatible), ",")
+		if device == "" {
+			device = strings.ToLower(compatible)
+		}
+		for _, mapping := range genericCompatibles {
+			if strings.Contains(device, mapping.Compatible) {
+				return mapping.Type, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CompatibleForType returns the device tree binding used for a peripheral type
+func CompatibleForType(peripheralType string) (string, bool) {
+	for _, mapping := range CompatibleMappings {
+		if strings.EqualFold(mapping.Type, peripheralType) {
+			return mapping.Compatible, true
+		}
+	}
+	return "", false
+}
+
+// processorForCPU derives a processor name from a cpu node
+func processorForCPU(cpu *devicetree.Node) (string, bool) {
+	compatibles, _ := cpu.Strings("compatible")
+	for _, compatible := range compatibles {
+		if name, ok := cpuCompatibles[strings.ToLower(compatible)]; ok {
+			return name, true
+		}
+	}
+	if isa, ok := cpu.String("riscv,isa"); ok {
+		if strings.HasPrefix(strings.ToLower(isa), "rv64") {
+			return "RISC-V RV64", true
+		}
+		return "RISC-V RV32", true
+	}
+	for _, compatible := range compatibles {
+		if strings.HasPrefix(compatible, "riscv") {
+			return "RISC-V RV64", true
+		}
+	}
+	return "", false
+}
+
+// UnmappedNode is a device tree node that has no counterpart in the board
+// configuration
+type UnmappedNode struct {
+	Path       string   `json:"path"`
+	Compatible []string `json:"compatible,omitempty"`
+	Reason     string   `json:"reason"`
+}
+
+// DeviceTreeImport is the result of converting a device tree
+type DeviceTreeImport struct {
+	Config   *BoardConfig   `json:"config"`
+	Unmapped []UnmappedNode `json:"unmapped"`
+}
+
+// structuralNodes carry no devices themselves
+var structuralNodes = map[string]bool{
+	"chosen": true, "aliases": true, "cpus": true, "memory": true,
+	"reserved-memory": true, "__symbols__": true, "__fixups__": true,
+	"__local_fixups__": true, "clocks": true, "soc": true, "pinctrl": true,
+}
+
+// ImportDeviceTree converts a device tree into a single-node board
+// configuration. Nodes that cannot be represented are listed in Unmapped.
+func ImportDeviceTree(tree *devicetree.Tree, nodeID string) (*DeviceTreeImport, error) {
+	if tree == nil || tree.Root == nil {
+		return nil, fmt.Errorf("empty device tree")
+	}
+	if nodeID == "" {
+		nodeID = "node0"
+	}
+
+	result := &DeviceTreeImport{Unmapped: []UnmappedNode{}}
+	node := NodeConfig{ID: nodeID}
+	unmapped := func(n *devicetree.Node, reason string) {
+		compatibles, _ := n.Strings("compatible")
+		result.Unmapped = append(result.Unmapped, UnmappedNode{Path: n.Path(), Compatible: compatibles, Reason: reason})
+	}
+
+	if model, ok := tree.Root.String("model"); ok {
+		result.Config = &BoardConfig{Name: model}
+	} else {
+		result.Config = &BoardConfig{}
+	}
+
+	node.Processor = importProcessor(tree, unmapped)
+
+	names := make(map[string]int)
+	var walk func(n *devicetree.Node)
+	walk = func(n *devicetree.Node) {
+		for _, child := range n.Children {
+			base := child.BaseName()
+			deviceType, _ := child.String("device_type")
+
+			switch {
+			case deviceType == "memory" || (n == tree.Root && base == "memory"):
+				regions, err := importMemory(child)
+				if err != nil {
+					unmapped(child, err.Error())
+				}
+				node.Memory = append(node.Memory, regions...)
+				continue
+			case deviceType == "cpu" || base == "cpus" || base == "cpu-map":
+				continue
+			}
+
+			if status, ok := child.String("status"); ok && status != "okay" && status != "ok" {
+				unmapped(child, "disabled")
+				continue
+			}
+
+			if _, ok := child.Property("compatible"); !ok {
+				if !structuralNodes[base] && len(child.Children) == 0 {
+					unmapped(child, "no compatible property")
+				}
+				walk(child)
+				continue
+			}
+			if isBus(child) {
+				walk(child)
+				continue
+			}
+			if _, ok := child.Property("interrupt-controller"); ok {
+				unmapped(child, "interrupt controllers are provided by the backend")
+				continue
+			}
+
+			compatibles, _ := child.Strings("compatible")
+			if processorProvided[compatibles[0]] {
+				unmapped(child, "provided by the processor")
+				continue
+			}
+			if region, ok := compatibleMemory(child); ok {
+				regions, err := importMemory(child)
+				if err != nil {
+					unmapped(child, err.Error())
+					continue
+				}
+				for i := range regions {
+					regions[i].Type, regions[i].Access = region, accessFor(region)
+				}
+				node.Memory = append(node.Memory, regions...)
+				continue
+			}
+
+			periphType, ok := PeripheralTypeForCompatible(compatibles)
+			if !ok {
+				unmapped(child, "unknown compatible")
+				walk(child)
+				continue
+			}
+
+			periph, err := importPeripheral(tree, child, periphType, names)
+			if err != nil {
+				unmapped(child, err.Error())
+				continue
+			}
+			node.Peripherals = append(node.Peripherals, periph)
+		}
+	}
+	walk(tree.Root)
+
+	sort.SliceStable(node.Memory, func(i, j int) bool { return node.Memory[i].Address < node.Memory[j].Address })
+	result.Config.Nodes = []NodeConfig{node}
+	return result, nil
+}
+
+func importProcessor(tree *devicetree.Tree, unmapped func(*devicetree.Node, string)) *ProcessorConfig {
+	cpus := tree.Lookup("/cpus")
+	if cpus == nil {
+		return nil
+	}
+
+	var processor *ProcessorConfig
+	for _, cpu := range cpus.Children {
+		if deviceType, _ := cpu.String("device_type"); deviceType != "cpu" && cpu.BaseName() != "cpu" {
+			continue
+		}
+		name, ok := processorForCPU(cpu)
+		if !ok {
+			unmapped(cpu, "unknown processor")
+			continue
+		}
+		if processor == nil {
+			processor = &ProcessorConfig{Type: name}
+			if frequency, ok := cpu.Uint32("clock-frequency"); ok {
+				processor.Frequency = uint64(frequency)
+			} else if frequency, ok := cpus.Uint32("timebase-frequency"); ok {
+				processor.Frequency = uint64(frequency)
+			}
+		}
+		processor.Cores++
+	}
+	return processor
+}
+
+// isBus reports whether a node is a transparent bus whose children are devices
+func isBus(n *devicetree.Node) bool {
+	compatibles, _ := n.Strings("compatible")
+	for _, compatible := range compatibles {
+		switch compatible {
+		case "simple-bus", "simple-mfd", "arm,amba-bus":
+			return true
+		}
+	}
+	return false
+}
+
+// compatibleMemory detects on-chip memories described as devices
+func compatibleMemory(n *devicetree.Node) (string, bool) {
+	compatibles, _ := n.Strings("compatible")
+	for _, compatible := range compatibles {
+		switch {
+		case compatible == "mmio-sram" || strings.HasSuffix(compatible, "-sram"):
+			return "RAM", true
+		case compatible == "cfi-flash" || compatible == "soc-nv-flash" || strings.HasSuffix(compatible, "-flash"):
+			return "Flash", true
+		}
+	}
+	return "", false
+}
+
+func accessFor(memoryType string) string {
+	if memoryType == "Flash" || memoryType == "ROM" {
+		return "RO"
+	}
+	return "RW"
+}
+
+func importMemory(n *devicetree.Node) ([]MemoryRegion, error) {
+	regs, err := n.Reg()
+	if err != nil {
+		return nil, err
+	}
+	if len(regs) == 0 {
+		return nil, fmt.Errorf("memory node without reg")
+	}
+	regions := make([]MemoryRegion, 0, len(regs))
+	for _, reg := range regs {
+		address, ok := n.TranslateAddress(reg.Address)
+		if !ok {
+			return nil, fmt.Errorf("address %#x is not memory mapped", reg.Address)
+		}
+		if reg.Size == 0 {
+			continue
+		}
+		regions = append(regions, MemoryRegion{Type: "RAM", Address: address, Size: reg.Size, Access: "RW"})
+	}
+	return regions, nil
+}
+
+func importPeripheral(tree *devicetree.Tree, n *devicetree.Node, periphType string, names map[string]int) (PeripheralConfig, error) {
+	compatibles, _ := n.Strings("compatible")
+	periph := PeripheralConfig{
+		Type:       periphType,
+		Name:       peripheralName(n, names),
+		Properties: map[string]interface{}{"compatible": compatibles[0]},
+	}
+
+	regs, err := n.Reg()
+	if err != nil {
+		return periph, err
+	}
+	if len(regs) > 0 {
+		address, ok := n.TranslateAddress(regs[0].Address)
+		if !ok {
+			return periph, fmt.Errorf("address %#x is not memory mapped", regs[0].Address)
+		}
+		periph.Address = address
+		periph.Properties["size"] = regs[0].Size
+	}
+
+	irqs, err := interruptNumbers(tree, n)
+	if err != nil {
+		return periph, err
+	}
+	periph.IRQ = irqs
+
+	if frequency, ok := n.Uint32("clock-frequency"); ok {
+		periph.Properties["clock_frequency"] = frequency
+	}
+	if len(n.Labels) > 0 {
+		periph.Properties["label"] = n.Labels[0]
+	}
+	return periph, nil
+}
+
+// peripheralName prefers the node label, then the node name, and
+// disambiguates duplicates with a numeric suffix
+func peripheralName(n *devicetree.Node, names map[string]int) string {
+	name := n.BaseName()
+	if len(n.Labels) > 0 {
+		name = n.Labels[0]
+	}
+	names[name]++
+	if count := names[name]; count > 1 {
+		return fmt.Sprintf("%s%d", name, count-1)
+	}
+	return name
+}
+
+// interruptNumbers resolves the interrupts property against the interrupt
+// parent. GIC specifiers (type, number, flags) are converted to interrupt IDs:
+// SPIs start at 32 and PPIs at 16.
+func interruptNumbers(tree *devicetree.Tree, n *devicetree.Node) ([]int, error) {
+	cells, ok := n.Cells("interrupts")
+	if !ok || len(cells) == 0 {
+		return nil, nil
+	}
+
+	parent := interruptParent(tree, n)
+	width := 1
+	gic := false
+	if parent != nil {
+		if value, ok := parent.Uint32("#interrupt-cells"); ok && value > 0 {
+			width = int(value)
+		}
+		compatibles, _ := parent.Strings("compatible")
+		for _, compatible := range compatibles {
+			if strings.Contains(compatible, "gic") || strings.HasPrefix(compatible, "arm,cortex-a") {
+				gic = true
+			}
+		}
+	}
+	if len(cells)%width != 0 {
+		return nil, fmt.Errorf("interrupts has %d cells, not a multiple of %d", len(cells), width)
+	}
+
+	irqs := make([]int, 0, len(cells)/width)
+	for i := 0; i < len(cells); i += width {
+		irq := int(cells[i])
+		if gic && width >= 3 {
+			switch cells[i] {
+			case 0:
+				irq = int(cells[i+1]) + 32
+			case 1:
+				irq = int(cells[i+1]) + 16
+			default:
+				irq = int(cells[i+1])
+			}
+		}
+		irqs = append(irqs, irq)
+	}
+	return irqs, nil
+}
+
+func interruptParent(tree *devicetree.Tree, n *devicetree.Node) *devicetree.Node {
+	for node := n; node != nil; node = node.Parent {
+		if handle, ok := node.Uint32("interrupt-parent"); ok {
+			return tree.PHandle(handle)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package adapters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/devicetree"
+)
+
+// deviceTreeNodeNames are the generic node names per peripheral type
+var deviceTreeNodeNames = map[string]string{
+	"UART":     "serial",
+	"GPIO":     "gpio",
+	"SPI":      "spi",
+	"I2C":      "i2c",
+	"Timer":    "timer",
+	"RTC":      "rtc",
+	"Ethernet": "ethernet",
+	"USB":      "usb",
+	"CAN":      "can",
+	"ADC":      "adc",
+	"DAC":      "dac",
+}
+
+// deviceTreeAliases are the /aliases stems per peripheral type
+var deviceTreeAliases = map[string]string{
+	"UART":     "serial",
+	"GPIO":     "gpio",
+	"SPI":      "spi",
+	"I2C":      "i2c",
+	"Ethernet": "ethernet",
+	"CAN":      "can",
+}
+
+// riscvCompatibles override CompatibleMappings on RISC-V boards, where the
+// ARM PrimeCell devices are unusual
+var riscvCompatibles = map[string]string{
+	"UART": "ns16550a",
+	"GPIO": "sifive,gpio0",
+	"SPI":  "sifive,spi0",
+}
+
+// defaultPeripheralRegSize is used when a peripheral has no size property
+const defaultPeripheralRegSize = 0x1000
+
+// Fixed phandles of the generated infrastructure nodes
+const (
+	phandleIntc     = 1
+	phandleClock    = 2
+	phandleCPUIntc0 = 16
+)
+
+// Interrupt controller layout, matching the QEMU virt machines
+const (
+	gicDistributorBase = 0x08000000
+	gicCPUIfaceBase    = 0x08010000
+	plicBase           = 0x0c000000
+	clintBase          = 0x02000000
+)
+
+// SupportsDeviceTree reports whether a processor boots from a device tree:
+// A-class ARM cores and RISC-V harts
+func SupportsDeviceTree(processor *ProcessorConfig) bool {
+	if processor == nil {
+		return false
+	}
+	family := processorFamily(processor.Type)
+	return family == "arm-a" || family == "riscv"
+}
+
+func processorFamily(processorType string) string {
+	lower := strings.ToLower(processorType)
+	switch {
+	case strings.Contains(lower, "cortex-a"):
+		return "arm-a"
+	case strings.Contains(lower, "cortex-m"):
+		return "arm-m"
+	case strings.Contains(lower, "risc-v"), strings.Contains(lower, "riscv"):
+		return "riscv"
+	}
+	return ""
+}
+
+// cpuCompatible returns the compatible string of a processor
+func cpuCompatible(processorType string) string {
+	for compatible, name := range cpuCompatibles {
+		if name == processorType && !strings.HasSuffix(compatible, "f") {
+			return compatible
+		}
+	}
+	return "riscv"
+}
+
+// GenerateDeviceTree builds a device tree describing a node of the board:
+// cpus, memory, an interrupt controller, the peripherals and /chosen with the
+// boot arguments. Peripherals take their compatible from the compatible
+// property when present and from CompatibleMappings otherwise.
+func GenerateDeviceTree(config *BoardConfig, node *NodeConfig) (*devicetree.Tree, error) {
+	if node.Processor == nil {
+		return nil, fmt.Errorf("node %s: processor required", node.ID)
+	}
+	family := processorFamily(node.Processor.Type)
+	if family != "arm-a" && family != "riscv" {
+		return nil, fmt.Errorf("node %s: %s does not boot from a device tree", node.ID, node.Processor.Type)
+	}
+
+	root := devicetree.NewNode("")
+	tree := &devicetree.Tree{Root: root}
+	root.SetCells("#address-cells", 2)
+	root.SetCells("#size-cells", 2)
+	model := config.Name
+	if model == "" {
+		model = node.ID
+	}
+	root.SetString("model", model)
+	if family == "riscv" {
+		root.SetString("compatible", "riscv-virtio")
+	} else {
+		root.SetString("compatible", "linux,dummy-virt")
+	}
+	root.SetCells("interrupt-parent", phandleIntc)
+
+	cores := node.Processor.Cores
+	if cores < 1 {
+		cores = 1
+	}
+	generateCPUs(root, node.Processor, family, cores)
+
+	for _, mem := range node.Memory {
+		switch mem.Type {
+		case "Flash":
+			flash := root.AddChild(devicetree.NewNode(fmt.Sprintf("flash@%x", mem.Address)))
+			flash.SetString("compatible", "cfi-flash")
+			flash.SetCells("reg", regCells(mem.Address, mem.Size)...)
+			flash.SetCells("bank-width", 4)
+		case "ROM":
+			continue
+		default:
+			memory := root.AddChild(devicetree.NewNode(fmt.Sprintf("memory@%x", mem.Address)))
+			memory.SetString("device_type", "memory")
+			memory.SetCells("reg", regCells(mem.Address, mem.Size)...)
+		}
+	}
+
+	if family == "riscv" {
+		generatePLIC(root, cores)
+	} else {
+		generateGIC(root, node.Processor.Type)
+	}
+
+	clock := root.AddChild(devicetree.NewNode("apb-pclk"))
+	clock.SetString("compatible", "fixed-clock")
+	clock.SetCells("#clock-cells", 0)
+	clock.SetCells("clock-frequency", 24000000)
+	clock.SetString("clock-output-names", "clk24mhz")
+	clock.SetCells("phandle", phandleClock)
+
+	soc := root.AddChild(devicetree.NewNode("soc"))
+	soc.SetString("compatible", "simple-bus")
+	soc.SetCells("#address-cells", 2)
+	soc.SetCells("#size-cells", 2)
+	soc.SetProperty("ranges", nil)
+
+	aliases := make(map[string]string)
+	aliasCounts := make(map[string]int)
+	for _, periph := range node.Peripherals {
+		child, err := generatePeripheral(soc, periph, family)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		if stem, ok := deviceTreeAliases[periph.Type]; ok {
+			aliases[fmt.Sprintf("%s%d", stem, aliasCounts[stem])] = child.Path()
+			aliasCounts[stem]++
+		}
+	}
+
+	if len(aliases) > 0 {
+		aliasNode := root.AddChild(devicetree.NewNode("aliases"))
+		names := make([]string, 0, len(aliases))
+		for name := range aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			aliasNode.SetString(name, aliases[name])
+		}
+	}
+
+	chosen := root.AddChild(devicetree.NewNode("chosen"))
+	if config.Boot != nil && len(config.Boot.BootArgs) > 0 {
+		chosen.SetString("bootargs", strings.Join(config.Boot.BootArgs, " "))
+	}
+	if _, ok := aliases["serial0"]; ok {
+		chosen.SetString("stdout-path", "serial0")
+	}
+	return tree, nil
+}
+
+func generateCPUs(root *devicetree.Node, processor *ProcessorConfig, family string, cores int) {
+	cpus := root.AddChild(devicetree.NewNode("cpus"))
+	cpus.SetCells("#address-cells", 1)
+	cpus.SetCells("#size-cells", 0)
+	if family == "riscv" {
+		frequency := processor.Frequency
+		if frequency == 0 {
+			frequency = 10000000
+		}
+		cpus.SetCells("timebase-frequency", uint32(frequency))
+	}
+
+	for i := 0; i < cores; i++ {
+		cpu := cpus.AddChild(devicetree.NewNode(fmt.Sprintf("cpu@%d", i)))
+		cpu.SetString("device_type", "cpu")
+		cpu.SetCells("reg", uint32(i))
+		if family == "riscv" {
+			isa := "rv32imafdc"
+			mmu := "riscv,sv32"
+			if strings.Contains(strings.ToLower(processor.Type), "64") {
+				isa, mmu = "rv64imafdc", "riscv,sv48"
+			}
+			cpu.SetString("compatible", "riscv")
+			cpu.SetString("riscv,isa", isa)
+			cpu.SetString("mmu-type", mmu)
+			cpu.SetString("status", "okay")
+
+			intc := cpu.AddChild(devicetree.NewNode("interrupt-controller"))
+			intc.SetString("compatible", "riscv,cpu-intc")
+			intc.SetCells("#interrupt-cells", 1)
+			intc.SetProperty("interrupt-controller", nil)
+			intc.SetCells("phandle", uint32(phandleCPUIntc0+i))
+			continue
+		}
+
+		cpu.SetString("compatible", cpuCompatible(processor.Type))
+		if cores > 1 {
+			cpu.SetString("enable-method", "psci")
+		}
+		if processor.Frequency > 0 {
+			cpu.SetCells("clock-frequency", uint32(processor.Frequency))
+		}
+	}
+
+	if family == "arm-a" && cores > 1 {
+		psci := root.AddChild(devicetree.NewNode("psci"))
+		psci.SetStrings("compatible", "arm,psci-1.0", "arm,psci-0.2", "arm,psci")
+		psci.SetString("method", "hvc")
+	}
+}
+
+func generateGIC(root *devicetree.Node, processorType string) {
+	gic := root.AddChild(devicetree.NewNode(fmt.Sprintf("intc@%x", gicDistributorBase)))
+	compatible := "arm,cortex-a15-gic"
+	if strings.Contains(processorType, "A9") {
+		compatible = "arm,cortex-a9-gic"
+	}
+	gic.SetString("compatible", compatible)
+	gic.SetCells("#interrupt-cells", 3)
+	gic.SetProperty("interrupt-controller", nil)
+	gic.SetCells("reg", append(regCells(gicDistributorBase, 0x10000), regCells(gicCPUIfaceBase, 0x10000)...)...)
+	gic.SetCells("phandle", phandleIntc)
+
+	timer := root.AddChild(devicetree.NewNode("timer"))
+	if strings.Contains(processorType, "A53") || strings.Contains(processorType, "A72") {
+		timer.SetString("compatible", "arm,armv8-timer")
+	} else {
+		timer.SetString("compatible", "arm,armv7-timer")
+	}
+	timer.SetProperty("always-on", nil)
+	// Secure, non-secure, virtual and hypervisor PPIs, active low level
+	timer.SetCells("interrupts", 1, 13, 0x104, 1, 14, 0x104, 1, 11, 0x104, 1, 10, 0x104)
+}
+
+func generatePLIC(root *devicetree.Node, cores int) {
+	plic := root.AddChild(devicetree.NewNode(fmt.Sprintf("plic@%x", plicBase)))
+	plic.SetStrings("compatible", "sifive,plic-1.0.0", "riscv,plic0")
+	plic.SetCells("#interrupt-cells", 1)
+	plic.SetCells("#address-cells", 0)
+	plic.SetProperty("interrupt-controller", nil)
+	plic.SetCells("reg", regCells(plicBase, 0x600000)...)
+	plic.SetCells("riscv,ndev", 127)
+	var extended []uint32
+	for i := 0; i < cores; i++ {
+		// Machine and supervisor external interrupts of each hart
+		extended = append(extended, uint32(phandleCPUIntc0+i), 11, uint32(phandleCPUIntc0+i), 9)
+	}
+	plic.SetCells("interrupts-extended", extended...)
+	plic.SetCells("phandle", phandleIntc)
+
+	clint := root.AddChild(devicetree.NewNode(fmt.Sprintf("clint@%x", clintBase)))
+	clint.SetStrings("compatible", "sifive,clint0", "riscv,clint0")
+	clint.SetCells("reg", regCells(clintBase, 0x10000)...)
+	extended = extended[:0]
+	for i := 0; i < cores; i++ {
+		// Machine software and timer interrupts
+		extended = append(extended, uint32(phandleCPUIntc0+i), 3, uint32(phandleCPUIntc0+i), 7)
+	}
+	clint.SetCells("interrupts-extended", extended...)
+}
+
+func generatePeripheral(soc *devicetree.Node, periph PeripheralConfig, family string) (*devicetree.Node, error) {
+	compatible, _ := periph.Properties["compatible"].(string)
+	if compatible == "" && family == "riscv" {
+		compatible = riscvCompatibles[periph.Type]
+	}
+	if compatible == "" {
+		var ok bool
+		if compatible, ok = CompatibleForType(periph.Type); !ok {
+			return nil, fmt.Errorf("peripheral %s: no device tree binding for type %s", periph.Name, periph.Type)
+		}
+	}
+	name, ok := deviceTreeNodeNames[periph.Type]
+	if !ok {
+		name = strings.ToLower(periph.Type)
+	}
+
+	child := soc.AddChild(devicetree.NewNode(fmt.Sprintf("%s@%x", name, periph.Address)))
+	if existing := soc.Child(child.Name); existing != child {
+		return nil, fmt.Errorf("peripheral %s: another peripheral is mapped at %#x", periph.Name, periph.Address)
+	}
+
+	// AMBA PrimeCell devices are probed through the bus and need apb_pclk
+	if strings.HasPrefix(compatible, "arm,pl") {
+		child.SetStrings("compatible", compatible, "arm,primecell")
+		if compatible == "arm,pl011" {
+			child.SetCells("clocks", phandleClock, phandleClock)
+			child.SetStrings("clock-names", "uartclk", "apb_pclk")
+		} else {
+			child.SetCells("clocks", phandleClock)
+			child.SetStrings("clock-names", "apb_pclk")
+		}
+	} else {
+		child.SetString("compatible", compatible)
+	}
+
+	size := uint64(defaultPeripheralRegSize)
+	if value, ok := propertyUint(periph.Properties["size"]); ok && value > 0 {
+		size = value
+	}
+	child.SetCells("reg", regCells(periph.Address, size)...)
+
+	if len(periph.IRQ) > 0 {
+		var cells []uint32
+		for _, irq := range periph.IRQ {
+			if family == "riscv" {
+				cells = append(cells, uint32(irq))
+				continue
+			}
+			// GIC interrupt IDs: SPIs from 32, PPIs from 16; level high
+			switch {
+			case irq >= 32:
+				cells = append(cells, 0, uint32(irq-32), 4)
+			case irq >= 16:
+				cells = append(cells, 1, uint32(irq-16), 4)
+			default:
+				return nil, fmt.Errorf("peripheral %s: IRQ %d is a software generated interrupt", periph.Name, irq)
+			}
+		}
+		child.SetCells("interrupts", cells...)
+	}
+
+	if frequency, ok := propertyUint(periph.Properties["clock_frequency"]); ok {
+		child.SetCells("clock-frequency", uint32(frequency))
+	}
+	child.SetString("status", "okay")
+	return child, nil
+}
+
+// regCells encodes an address and size for #address-cells = #size-cells = 2
+func regCells(address, size uint64) []uint32 {
+	return append(devicetree.SplitCells(address, 2), devicetree.SplitCells(size, 2)...)
+}
@@ -0,0 +1,186 @@
+package adapters
+
+import (
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/devicetree"
+)
+
+const virtDTS = `/dts-v1/;
+
+/ {
+	model = "virt";
+	#address-cells = <2>;
+	#size-cells = <2>;
+	interrupt-parent = <&gic>;
+
+	cpus {
+		#address-cells = <1>;
+		#size-cells = <0>;
+		cpu@0 {
+			device_type = "cpu";
+			compatible = "arm,cortex-a53";
+			reg = <0>;
+		};
+		cpu@1 {
+			device_type = "cpu";
+			compatible = "arm,cortex-a53";
+			reg = <1>;
+		};
+	};
+
+	memory@40000000 {
+		device_type = "memory";
+		reg = <0x0 0x40000000 0x0 0x8000000>;
+	};
+
+	gic: intc@8000000 {
+		compatible = "arm,cortex-a15-gic";
+		interrupt-controller;
+		#interrupt-cells = <3>;
+		reg = <0x0 0x8000000 0x0 0x10000>;
+	};
+
+	pl011@9000000 {
+		compatible = "arm,pl011", "arm,primecell";
+		reg = <0x0 0x9000000 0x0 0x1000>;
+		interrupts = <0 1 4>;
+	};
+
+	pl061@9030000 {
+		compatible = "arm,pl061", "arm,primecell";
+		reg = <0x0 0x9030000 0x0 0x1000>;
+		interrupts = <0 7 4>;
+		status = "disabled";
+	};
+
+	fw-cfg@9020000 {
+		compatible = "qemu,fw-cfg-mmio";
+		reg = <0x0 0x9020000 0x0 0x18>;
+	};
+};
+`
+
+func TestImportDeviceTree(t *testing.T) {
+	tree, err := devicetree.ParseDTS(virtDTS)
+	if err != nil {
+		t.Fatalf("ParseDTS: %v", err)
+	}
+
+	result, err := ImportDeviceTree(tree, "")
+	if err != nil {
+		t.Fatalf("ImportDeviceTree: %v", err)
+	}
+	if err := result.Config.Validate(); err != nil {
+		t.Fatalf("imported config is invalid: %v", err)
+	}
+
+	node := result.Config.Nodes[0]
+	if node.Processor == nil || node.Processor.Type != "ARM Cortex-A53" || node.Processor.Cores != 2 {
+		t.Errorf("unexpected processor %+v", node.Processor)
+	}
+	if len(node.Memory) != 1 || node.Memory[0].Address != 0x40000000 || node.Memory[0].Size != 0x8000000 {
+		t.Errorf("unexpected memory %+v", node.Memory)
+	}
+	if len(node.Peripherals) != 1 {
+		t.Fatalf("expected 1 peripheral, got %+v", node.Peripherals)
+	}
+	uart := node.Peripherals[0]
+	if uart.Type != "UART" || uart.Address != 0x9000000 || len(uart.IRQ) != 1 || uart.IRQ[0] != 33 {
+		t.Errorf("unexpected uart %+v", uart)
+	}
+
+	reasons := make(map[string]string)
+	for _, unmapped := range result.Unmapped {
+		reasons[unmapped.Path] = unmapped.Reason
+	}
+	for path, reason := range map[string]string{
+		"/intc@8000000":   "interrupt controllers are provided by the backend",
+		"/pl061@9030000":  "disabled",
+		"/fw-cfg@9020000": "unknown compatible",
+	} {
+		if reasons[path] != reason {
+			t.Errorf("%s: reason %q, want %q", path, reasons[path], reason)
+		}
+	}
+}
+
+func TestPeripheralTypeForCompatible(t *testing.T) {
+	for compatible, want := range map[string]string{
+		"ns16550a":              "UART",
+		"st,stm32f7-uart":       "UART",
+		"vendor,foo-i2c":        "I2C",
+		"arm,pl031":             "RTC",
+		"qemu,fw-cfg-mmio":      "",
+		"allwinner,sun4i-a10-x": "",
+	} {
+		got, _ := PeripheralTypeForCompatible([]string{compatible})
+		if got != want {
+			t.Errorf("%s: got %q, want %q", compatible, got, want)
+		}
+	}
+}
+
+func TestGenerateDeviceTree(t *testing.T) {
+	config := &BoardConfig{
+		Name: "a53-board",
+		Nodes: []NodeConfig{{
+			ID:        "soc",
+			Processor: &ProcessorConfig{Type: "ARM Cortex-A53", Cores: 2},
+			Memory:    []MemoryRegion{{Type: "RAM", Address: 0x40000000, Size: 0x20000000, Access: "RW"}},
+			Peripherals: []PeripheralConfig{
+				{Type: "UART", Name: "uart0", Address: 0x9000000, IRQ: []int{33}},
+				{Type: "RTC", Name: "rtc", Address: 0x9010000, IRQ: []int{34}},
+			},
+		}},
+		Boot: &BootConfig{BootArgs: []string{"console=ttyAMA0", "earlycon"}},
+	}
+
+	tree, err := GenerateDeviceTree(config, &config.Nodes[0])
+	if err != nil {
+		t.Fatalf("GenerateDeviceTree: %v", err)
+	}
+	decoded, err := devicetree.Parse(devicetree.Encode(tree))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if bootargs, _ := decoded.Lookup("/chosen").String("bootargs"); bootargs != "console=ttyAMA0 earlycon" {
+		t.Errorf("bootargs %q", bootargs)
+	}
+	if uart, _ := decoded.Lookup("/aliases").String("serial0"); uart != "/soc/serial@9000000" {
+		t.Errorf("serial0 alias %q", uart)
+	}
+
+	// Importing the generated tree gives back the node
+	result, err := ImportDeviceTree(decoded, "soc")
+	if err != nil {
+		t.Fatalf("ImportDeviceTree: %v", err)
+	}
+	node := result.Config.Nodes[0]
+	want := config.Nodes[0]
+	if node.Processor == nil || node.Processor.Type != want.Processor.Type || node.Processor.Cores != 2 {
+		t.Errorf("processor %+v", node.Processor)
+	}
+	if len(node.Memory) != 1 || node.Memory[0] != want.Memory[0] {
+		t.Errorf("memory %+v", node.Memory)
+	}
+	if len(node.Peripherals) != 2 {
+		t.Fatalf("peripherals %+v", node.Peripherals)
+	}
+	for i, periph := range want.Peripherals {
+		got := node.Peripherals[i]
+		if got.Type != periph.Type || got.Address != periph.Address || len(got.IRQ) != 1 || got.IRQ[0] != periph.IRQ[0] {
+			t.Errorf("peripheral %+v, want %+v", got, periph)
+		}
+	}
+	for _, unmapped := range result.Unmapped {
+		if unmapped.Reason == "unknown compatible" {
+			t.Errorf("generated node %s has an unknown compatible", unmapped.Path)
+		}
+	}
+
+	m4 := &NodeConfig{ID: "mcu", Processor: &ProcessorConfig{Type: "ARM Cortex-M4"}}
+	if _, err := GenerateDeviceTree(config, m4); err == nil {
+		t.Error("expected an error for a Cortex-M node")
+	}
+}
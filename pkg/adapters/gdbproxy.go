@@ -0,0 +1,249 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/gdb"
+)
+
+// gdbProxyFeatures is the qSupported reply of the proxy. Features that
+// would change the mode of the shared stub connection, such as non-stop
+// mode, are not offered.
+const gdbProxyFeatures = "PacketSize=4000;qXfer:features:read+;swbreak+;hwbreak+;QStartNoAckMode+"
+
+// gdbReverseFeatures are offered when the backend replays a recording
+const gdbReverseFeatures = "ReverseStep+;ReverseContinue+"
+
+// errReadOnly is reported to observers for packets that change the target
+var errReadOnly = errors.New("read-only GDB connection")
+
+// gdbProxy serves one GDB client on the shared connection of a gdbTarget.
+// Packets of the client are forwarded between the commands of the REST API
+// and other clients; resumes go through the target so that its stops reach
+// step, resume and the stop handler as usual.
+type gdbProxy struct {
+	target   *gdbTarget
+	client   *gdb.Client
+	conn     *gdb.ServerConn
+	readOnly bool
+	messages bool // The client accepts E.text error replies
+
+	packets     chan string
+	inserted    map[string]bool // Z packets of the client not removed yet
+	interrupted bool
+}
+
+// serve proxies a GDB client until it detaches or disconnects. Observers
+// may read registers and memory but not resume or modify the target.
+func (t *gdbTarget) serve(ctx context.Context, rwc io.ReadWriteCloser, readOnly bool) error {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	conn := gdb.NewServerConn(rwc)
+	defer conn.Close()
+
+	p := &gdbProxy{
+		target:   t,
+		client:   client,
+		conn:     conn,
+		readOnly: readOnly,
+		packets:  make(chan string),
+		inserted: make(map[string]bool),
+	}
+	defer p.removeBreakpoints()
+
+	done := make(chan struct{})
+	defer close(done)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			packet, err := conn.ReadPacket()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case p.packets <- packet:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case packet := <-p.packets:
+			detached, err := p.handle(ctx, packet)
+			if err != nil || detached {
+				return err
+			}
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-client.Done():
+			return fmt.Errorf("gdbstub connection lost: %w", client.Err())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handle answers a packet and reports whether the client detached
+func (p *gdbProxy) handle(ctx context.Context, packet string) (bool, error) {
+	switch {
+	case packet == "":
+		// Empty packets are not commands; the empty reply means unsupported
+		return false, p.conn.WritePacket("")
+	case packet == gdb.InterruptPacket:
+		if !p.readOnly {
+			return false, p.client.Interrupt()
+		}
+		return false, nil
+	case strings.HasPrefix(packet, "qSupported"):
+		p.messages = strings.Contains(packet, "error-message+")
+		if p.target.isReversible() {
+			return false, p.conn.WritePacket(gdbProxyFeatures + ";" + gdbReverseFeatures)
+		}
+		return false, p.conn.WritePacket(gdbProxyFeatures)
+	case packet == "QStartNoAckMode":
+		return false, p.conn.StartNoAck()
+	case packet == "?":
+		if stop := p.client.LastStop(); stop != nil && stop.Packet != "" {
+			return false, p.conn.WritePacket(stop.Packet)
+		}
+		return false, p.conn.WritePacket("S05")
+	case packet == "D" || strings.HasPrefix(packet, "D;"):
+		// The target keeps its state; it is shared with the REST API
+		return true, p.conn.WritePacket("OK")
+	case packet == "k" || strings.HasPrefix(packet, "vKill"):
+		return true, nil
+	case packet == "!", packet == "vCont?", strings.HasPrefix(packet, "QNonStop"), strings.HasPrefix(packet, "vMustReplyEmpty"):
+		// Unsupported: GDB falls back to Hc with c and s
+		return false, p.conn.WritePacket("")
+	}
+
+	if !p.allowed(packet) {
+		return false, p.reject(errReadOnly)
+	}
+	if packet == "bs" || packet == "bc" {
+		return false, p.resume(ctx, packet == "bs", true)
+	}
+	switch packet[0] {
+	case 'c', 's', 'C', 'S':
+		return false, p.resume(ctx, packet[0] == 's' || packet[0] == 'S', false)
+	case 'Z', 'z':
+		return false, p.breakpoint(packet)
+	}
+
+	replies, err := p.client.Forward(packet)
+	if err != nil {
+		return false, p.reject(err)
+	}
+	for _, reply := range replies {
+		if err := p.conn.WritePacket(reply); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// allowed reports whether the client may send a packet. Observers are
+// limited to packets that read the target; they cannot select threads with
+// H, as the selection belongs to the stub connection the controller uses.
+func (p *gdbProxy) allowed(packet string) bool {
+	if !p.readOnly {
+		return true
+	}
+	if packet == "" {
+		return false
+	}
+	switch packet[0] {
+	case 'g', 'p', 'm', 'x', 'T':
+		return true
+	case 'q':
+		return !strings.HasPrefix(packet, "qRcmd,")
+	}
+	return false
+}
+
+// reject sends an error reply, with its text when the client accepts it
+func (p *gdbProxy) reject(err error) error {
+	if p.messages {
+		return p.conn.WritePacket("E." + err.Error())
+	}
+	return p.conn.WritePacket("E01")
+}
+
+// resume steps or continues the target, forwards or backwards, and relays
+// its stop reply. A target that was resumed over the REST API is not
+// resumed again; the client waits for the same stop as the REST API.
+func (p *gdbProxy) resume(ctx context.Context, step, reverse bool) error {
+	p.interrupted = false
+	next := p.target.nextStop()
+	if !p.client.Running() {
+		var err error
+		if _, next, err = p.target.start(ctx, step, reverse); err != nil {
+			return p.reject(err)
+		}
+	}
+
+	for {
+		select {
+		case <-next.done:
+			stop := p.client.LastStop()
+			if stop == nil || stop.Packet == "" {
+				return p.conn.WritePacket("S05")
+			}
+			return p.conn.WritePacket(stop.Packet)
+		case packet := <-p.packets:
+			if packet == gdb.InterruptPacket && !p.interrupted {
+				p.interrupted = true
+				if err := p.client.Interrupt(); err != nil {
+					return err
+				}
+			}
+			// GDB sends nothing else while the target runs in all-stop mode
+		case <-p.client.Done():
+			return fmt.Errorf("gdbstub connection lost: %w", p.client.Err())
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// breakpoint forwards Z and z packets, remembering the insertions so that
+// they can be undone when the client goes away without removing them
+func (p *gdbProxy) breakpoint(packet string) error {
+	replies, err := p.client.Forward(packet)
+	if err != nil {
+		return p.reject(err)
+	}
+	if len(replies) == 1 && replies[0] == "OK" {
+		if packet[0] == 'Z' {
+			p.inserted[packet[1:]] = true
+		} else {
+			delete(p.inserted, packet[1:])
+		}
+	}
+	for _, reply := range replies {
+		if err := p.conn.WritePacket(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBreakpoints removes the breakpoints the client left behind. They
+// stay inserted when the target is running.
+func (p *gdbProxy) removeBreakpoints() {
+	for args := range p.inserted {
+		p.client.Forward("z" + args)
+	}
+}
@@ -0,0 +1,180 @@
+package adapters
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/gdb"
+)
+
+// proxyClient connects a GDB client to the proxy of a target
+func proxyClient(t *testing.T, target *gdbTarget, readOnly bool) (*gdb.Client, <-chan error) {
+	t.Helper()
+	local, remote := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- target.serve(context.Background(), remote, readOnly) }()
+	client, err := gdb.NewClient(local)
+	if err != nil {
+		t.Fatalf("connect to proxy: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, done
+}
+
+func TestGDBProxy(t *testing.T) {
+	stub := newBreakpointStub(t)
+	target := newGDBTarget(stub.listener.Addr().String(), &BoardConfig{
+		Nodes: []NodeConfig{{Processor: &ProcessorConfig{Type: "ARM Cortex-M4"}}},
+	})
+	defer target.close()
+	events := make(chan *StopEvent, 4)
+	target.watchStops(func(event *StopEvent) { events <- event })
+
+	controller, controllerDone := proxyClient(t, target, false)
+	observer, observerDone := proxyClient(t, target, true)
+
+	// Reads are forwarded for both
+	for _, client := range []*gdb.Client{controller, observer} {
+		if data, err := client.ReadRegister(15); err != nil || len(data) != 4 || data[1] != 0x01 {
+			t.Errorf("ReadRegister = %x, %v", data, err)
+		}
+	}
+
+	// Observers cannot change the target
+	if err := observer.InsertBreakpoint(gdb.SoftwareBreakpoint, 0x08000300, 2); err == nil {
+		t.Error("observer inserted a breakpoint")
+	}
+	if err := observer.Step(); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if stop, err := observer.Wait(waitCtx); err == nil {
+		t.Errorf("observer resumed the target: %+v", stop)
+	}
+
+	// The controller's stops come from the shared connection and reach the
+	// stop handler like those of the REST API
+	if err := controller.InsertBreakpoint(gdb.SoftwareBreakpoint, 0x08000200, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := controller.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != "swbreak" || stop.Packet == "" {
+		t.Errorf("unexpected stop %+v", stop)
+	}
+	select {
+	case event := <-events:
+		if event.Reason != StopBreakpoint || event.PC != 0x08000200 {
+			t.Errorf("unexpected stop event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stop handler not called")
+	}
+
+	// Breakpoints left behind are removed when the controller goes away
+	controller.Close()
+	if err := <-controllerDone; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+	removed := false
+	stub.mu.Lock()
+	for _, packet := range stub.packets {
+		removed = removed || strings.HasPrefix(packet, "z0,8000200")
+	}
+	stub.mu.Unlock()
+	if !removed {
+		t.Error("breakpoint of the disconnected client was not removed")
+	}
+
+	observer.Close()
+	<-observerDone
+}
+
+func TestGDBProxyPackets(t *testing.T) {
+	stub := newBreakpointStub(t)
+	target := newGDBTarget(stub.listener.Addr().String(), nil)
+	defer target.close()
+	controller, _ := proxyClient(t, target, false)
+	observer, _ := proxyClient(t, target, true)
+
+	// Empty packets get the empty reply
+	for _, client := range []*gdb.Client{controller, observer} {
+		if replies, err := client.Forward(""); err != nil || len(replies) != 1 || replies[0] != "" {
+			t.Errorf("empty packet: %q, %v", replies, err)
+		}
+	}
+
+	// Only the controller selects threads on the shared connection
+	if replies, err := controller.Forward("Hg1"); err != nil || len(replies) != 1 || replies[0] != "" {
+		t.Errorf("controller Hg1: %q, %v", replies, err)
+	}
+	if replies, err := observer.Forward("Hg1"); err != nil || len(replies) != 1 || replies[0] != "E01" {
+		t.Errorf("observer Hg1: %q, %v", replies, err)
+	}
+}
+
+func TestGDBProxySharedStop(t *testing.T) {
+	stub := newBreakpointStub(t)
+	target := newGDBTarget(stub.listener.Addr().String(), &BoardConfig{
+		Nodes: []NodeConfig{{Processor: &ProcessorConfig{Type: "ARM Cortex-M4"}}},
+	})
+	defer target.close()
+	controller, _ := proxyClient(t, target, false)
+
+	// A resume of the REST API runs until interrupted
+	resumed := make(chan *StopEvent, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		event, err := target.resume(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		resumed <- event
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		client, err := target.connect(context.Background())
+		if err == nil && client.Running() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("target not resumed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The client continuing meanwhile waits for the same stop
+	if err := controller.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Interrupt(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stop, err := controller.Wait(ctx)
+	if err != nil {
+		t.Fatalf("client did not see the stop: %v", err)
+	}
+	if stop.Signal != 2 {
+		t.Errorf("unexpected stop %+v", stop)
+	}
+	select {
+	case event := <-resumed:
+		if event.Reason != StopSignal || event.Signal != 2 {
+			t.Errorf("unexpected stop event %+v", event)
+		}
+	case <-ctx.Done():
+		t.Fatal("REST resume did not see the stop")
+	}
+}
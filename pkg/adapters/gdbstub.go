@@ -0,0 +1,649 @@
+package adapters
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/gdb"
+)
+
+// gdbRegister is a register of the gdbstub register file
+type gdbRegister struct {
+	Name   string
+	Number int
+	Size   int // Bytes
+}
+
+// gdbArch describes the gdbstub register layout of a processor family
+type gdbArch struct {
+	Name           string
+	Registers      []gdbRegister
+	PC             int // Register number of the program counter
+	BreakpointKind int // Kind argument of Z0/Z1 packets
+	BigEndian      bool
+}
+
+// InstructionSet returns the instruction set a processor executes: "thumb"
+// for M-profile cores, otherwise "arm", "arm64", "riscv32" or "riscv64"
+func InstructionSet(processor *ProcessorConfig) string {
+	arch := gdbArchFor(processor)
+	switch {
+	case arch.Name == "aarch64":
+		return "arm64"
+	case arch.Name == "arm" && arch.BreakpointKind == 2:
+		return "thumb"
+	}
+	return arch.Name
+}
+
+// gdbArchFor selects the register layout for a processor type
+func gdbArchFor(processor *ProcessorConfig) *gdbArch {
+	processorType := ""
+	if processor != nil {
+		processorType = processor.Type
+	}
+
+	switch {
+	case strings.Contains(processorType, "RV64"):
+		return riscvArch("riscv64", 8)
+	case strings.Contains(processorType, "RV32"), processorFamily(processorType) == "riscv":
+		return riscvArch("riscv32", 4)
+	case strings.Contains(processorType, "A53"), strings.Contains(processorType, "A57"), strings.Contains(processorType, "A72"):
+		arch := &gdbArch{Name: "aarch64", PC: 32, BreakpointKind: 4}
+		for i := 0; i <= 30; i++ {
+			arch.Registers = append(arch.Registers, gdbRegister{Name: fmt.Sprintf("x%d", i), Number: i, Size: 8})
+		}
+		arch.Registers = append(arch.Registers,
+			gdbRegister{Name: "sp", Number: 31, Size: 8},
+			gdbRegister{Name: "pc", Number: 32, Size: 8},
+			gdbRegister{Name: "cpsr", Number: 33, Size: 4},
+		)
+		return arch
+	}
+
+	// 32-bit ARM; M-profile cores execute Thumb only
+	arch := &gdbArch{Name: "arm", PC: 15, BreakpointKind: 4}
+	status := "cpsr"
+	if processorFamily(processorType) == "arm-m" {
+		arch.BreakpointKind = 2
+		status = "xpsr"
+	}
+	for i := 0; i <= 12; i++ {
+		arch.Registers = append(arch.Registers, gdbRegister{Name: fmt.Sprintf("r%d", i), Number: i, Size: 4})
+	}
+	arch.Registers = append(arch.Registers,
+		gdbRegister{Name: "sp", Number: 13, Size: 4},
+		gdbRegister{Name: "lr", Number: 14, Size: 4},
+		gdbRegister{Name: "pc", Number: 15, Size: 4},
+		gdbRegister{Name: status, Number: 25, Size: 4},
+	)
+	return arch
+}
+
+var riscvABINames = []string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+func riscvArch(name string, size int) *gdbArch {
+	arch := &gdbArch{Name: name, PC: 32, BreakpointKind: 4}
+	for i, reg := range riscvABINames {
+		arch.Registers = append(arch.Registers, gdbRegister{Name: reg, Number: i, Size: size})
+	}
+	arch.Registers = append(arch.Registers, gdbRegister{Name: "pc", Number: 32, Size: size})
+	return arch
+}
+
+// register looks up a register by name or alias (x0-x31 on RISC-V)
+func (a *gdbArch) register(name string) (gdbRegister, bool) {
+	name = strings.ToLower(name)
+	for _, reg := range a.Registers {
+		if reg.Name == name {
+			return reg, true
+		}
+	}
+	if strings.HasPrefix(a.Name, "riscv") && strings.HasPrefix(name, "x") {
+		if n, err := strconv.Atoi(name[1:]); err == nil && n >= 0 && n < 32 {
+			return a.Registers[n], true
+		}
+	}
+	switch name {
+	case "fp":
+		if strings.HasPrefix(a.Name, "riscv") {
+			return a.Registers[8], true
+		}
+		if a.Name == "aarch64" {
+			return a.Registers[29], true
+		}
+	}
+	return gdbRegister{}, false
+}
+
+func (a *gdbArch) decode(data []byte) uint64 {
+	var buf [8]byte
+	if a.BigEndian {
+		copy(buf[8-len(data):], data)
+		return binary.BigEndian.Uint64(buf[:])
+	}
+	copy(buf[:], data)
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+func (a *gdbArch) encode(value uint64, size int) []byte {
+	var buf [8]byte
+	if a.BigEndian {
+		binary.BigEndian.PutUint64(buf[:], value)
+		return buf[8-size:]
+	}
+	binary.LittleEndian.PutUint64(buf[:], value)
+	return buf[:size]
+}
+
+// gdbTarget is the debug connection of an instance to the gdbstub of its
+// backend. The connection is opened on first use and re-established after
+// the backend restarts; breakpoints are reinserted on every new connection.
+type gdbTarget struct {
+	mu          sync.Mutex
+	address     string
+	arch        *gdbArch
+	client      *gdb.Client
+	breakpoints map[string]*Breakpoint
+	order       []string // Breakpoint IDs in insertion order
+	onStop      func(*StopEvent)
+	reversible  bool // The backend replays a recording and can run backwards
+
+	// run serialises resuming the target with converting its stop replies,
+	// so the PC is read before the target can be resumed again
+	run      sync.Mutex
+	stepping bool
+	next     *pendingStop // Guarded by mu
+}
+
+// pendingStop is the next stop of a target. Every resume waiting for it,
+// over the REST API or from GDB clients, receives it.
+type pendingStop struct {
+	done  chan struct{} // Closed once event is set
+	event *StopEvent
+}
+
+func newPendingStop() *pendingStop {
+	return &pendingStop{done: make(chan struct{})}
+}
+
+func newGDBTarget(address string, config *BoardConfig) *gdbTarget {
+	var processor *ProcessorConfig
+	if config != nil && len(config.Nodes) > 0 {
+		processor = config.Nodes[0].Processor
+	}
+	return &gdbTarget{
+		address:     address,
+		arch:        gdbArchFor(processor),
+		breakpoints: make(map[string]*Breakpoint),
+		next:        newPendingStop(),
+	}
+}
+
+// nextStop returns the next stop of the target
+func (t *gdbTarget) nextStop() *pendingStop {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.next
+}
+
+// watchStops registers the handler for every stop of the target, including
+// those of resumes nobody waits for. The handler must not block.
+func (t *gdbTarget) watchStops(handler func(*StopEvent)) {
+	t.mu.Lock()
+	t.onStop = handler
+	t.mu.Unlock()
+}
+
+// setReversible tells whether the backend replays a recording, so that
+// reverse execution is offered
+func (t *gdbTarget) setReversible(reversible bool) {
+	t.mu.Lock()
+	t.reversible = reversible
+	t.mu.Unlock()
+}
+
+func (t *gdbTarget) isReversible() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reversible
+}
+
+// gdbDialTimeout bounds connecting to a gdbstub
+const gdbDialTimeout = 5 * time.Second
+
+// connect returns the current connection, dialling a new one when needed
+func (t *gdbTarget) connect(ctx context.Context) (*gdb.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		select {
+		case <-t.client.Done():
+			t.client = nil
+		default:
+			return t.client, nil
+		}
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, gdbDialTimeout)
+	defer cancel()
+	client, err := gdb.Dial(dialCtx, t.address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to gdbstub at %s: %w", t.address, err)
+	}
+	for _, id := range t.order {
+		bp := t.breakpoints[id]
+		if !bp.Enabled {
+			continue
+		}
+		if err := client.InsertBreakpoint(t.breakpointType(bp), bp.Address, t.breakpointKind(bp)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("reinsert breakpoint %s: %w", id, err)
+		}
+	}
+	replies := make(chan *gdb.StopReply, 1)
+	client.OnStop(func(stop *gdb.StopReply) {
+		select {
+		case replies <- stop:
+		default:
+		}
+	})
+	go t.forwardStops(client, replies)
+
+	t.client = client
+	return client, nil
+}
+
+// forwardStops converts the stop replies of a connection to stop events
+// for step, resume and the stop handler. The callback of the client cannot
+// do this itself as reading the PC needs a command.
+func (t *gdbTarget) forwardStops(client *gdb.Client, replies <-chan *gdb.StopReply) {
+	for {
+		select {
+		case stop := <-replies:
+			t.run.Lock()
+			event, _ := t.stopEvent(context.Background(), client, stop)
+			if t.stepping && event.Reason == StopSignal && stop.Signal == 5 {
+				event.Reason = StopStep
+			}
+			// The handler sees the stop before step and resume return it
+			t.mu.Lock()
+			handler := t.onStop
+			next := t.next
+			t.next = newPendingStop()
+			t.mu.Unlock()
+			if handler != nil {
+				handler(event)
+			}
+			next.event = event
+			close(next.done)
+			t.run.Unlock()
+		case <-client.Done():
+			return
+		}
+	}
+}
+
+// close drops the connection; breakpoints are kept for the next one
+func (t *gdbTarget) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+}
+
+func (t *gdbTarget) breakpointType(bp *Breakpoint) gdb.BreakpointType {
+	switch bp.Type {
+	case BreakpointHardware:
+		return gdb.HardwareBreakpoint
+	case WatchWrite:
+		return gdb.WriteWatchpoint
+	case WatchRead:
+		return gdb.ReadWatchpoint
+	case WatchAccess:
+		return gdb.AccessWatchpoint
+	}
+	return gdb.SoftwareBreakpoint
+}
+
+// breakpointKind returns the kind argument of Z packets: the instruction
+// size for breakpoints, the number of bytes watched for watchpoints
+func (t *gdbTarget) breakpointKind(bp *Breakpoint) int {
+	if !bp.IsWatchpoint() {
+		return t.arch.BreakpointKind
+	}
+	if bp.Length == 0 {
+		return DefaultWatchLength
+	}
+	return int(bp.Length)
+}
+
+// halted returns a connection to a stopped target
+func (t *gdbTarget) halted(ctx context.Context) (*gdb.Client, error) {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if client.Running() {
+		return nil, fmt.Errorf("target is running; interrupt it first")
+	}
+	return client, nil
+}
+
+func (t *gdbTarget) setBreakpoint(ctx context.Context, bp *Breakpoint) error {
+	if bp.ID == "" {
+		return fmt.Errorf("breakpoint id required")
+	}
+	client, err := t.connect(ctx)
+	if err != nil {
+		// The stub is not up yet, e.g. right after power on; the breakpoint
+		// is inserted when the connection is established
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.store(bp)
+		return nil
+	}
+	if client.Running() {
+		return fmt.Errorf("target is running; interrupt it first")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, exists := t.breakpoints[bp.ID]; exists && old.Enabled {
+		if err := client.RemoveBreakpoint(t.breakpointType(old), old.Address, t.breakpointKind(old)); err != nil {
+			return err
+		}
+	}
+	if bp.Enabled {
+		if err := client.InsertBreakpoint(t.breakpointType(bp), bp.Address, t.breakpointKind(bp)); err != nil {
+			return err
+		}
+	}
+	t.store(bp)
+	return nil
+}
+
+func (t *gdbTarget) store(bp *Breakpoint) {
+	if _, exists := t.breakpoints[bp.ID]; !exists {
+		t.order = append(t.order, bp.ID)
+	}
+	copied := *bp
+	t.breakpoints[bp.ID] = &copied
+}
+
+func (t *gdbTarget) removeBreakpoint(ctx context.Context, id string) error {
+	t.mu.Lock()
+	bp, exists := t.breakpoints[id]
+	t.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("breakpoint not found: %s", id)
+	}
+
+	if bp.Enabled {
+		client, err := t.halted(ctx)
+		if err != nil {
+			return err
+		}
+		if err := client.RemoveBreakpoint(t.breakpointType(bp), bp.Address, t.breakpointKind(bp)); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.breakpoints, id)
+	for i, other := range t.order {
+		if other == id {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// breakpointAt returns the ID of the enabled breakpoint at an address
+func (t *gdbTarget) breakpointAt(address uint64) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range t.order {
+		if bp := t.breakpoints[id]; bp.Enabled && !bp.IsWatchpoint() && bp.Address == address {
+			return id
+		}
+	}
+	return ""
+}
+
+// watchpointAt returns the ID of the enabled watchpoint whose range holds
+// an address
+func (t *gdbTarget) watchpointAt(address uint64) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range t.order {
+		bp := t.breakpoints[id]
+		if bp.Enabled && bp.IsWatchpoint() && address >= bp.Address && address-bp.Address < uint64(t.breakpointKind(bp)) {
+			return id
+		}
+	}
+	return ""
+}
+
+// step executes one instruction and reports where the target stopped
+func (t *gdbTarget) step(ctx context.Context) (*StopEvent, error) {
+	client, next, err := t.start(ctx, true, false)
+	if err != nil {
+		return nil, err
+	}
+	return t.wait(ctx, client, next, true)
+}
+
+// resume continues the target and waits for it to stop until ctx is done,
+// in which case the target keeps running and a running event is returned
+func (t *gdbTarget) resume(ctx context.Context) (*StopEvent, error) {
+	client, next, err := t.start(ctx, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return t.wait(ctx, client, next, false)
+}
+
+// reverseStep executes one instruction backwards
+func (t *gdbTarget) reverseStep(ctx context.Context) (*StopEvent, error) {
+	client, next, err := t.start(ctx, true, true)
+	if err != nil {
+		return nil, err
+	}
+	return t.wait(ctx, client, next, true)
+}
+
+// reverseResume runs the target backwards like resume
+func (t *gdbTarget) reverseResume(ctx context.Context) (*StopEvent, error) {
+	client, next, err := t.start(ctx, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return t.wait(ctx, client, next, false)
+}
+
+// wait waits for the stop of a resume. Steps fail when ctx is done, other
+// resumes report that the target is running.
+func (t *gdbTarget) wait(ctx context.Context, client *gdb.Client, next *pendingStop, step bool) (*StopEvent, error) {
+	select {
+	case <-next.done:
+		return next.event, nil
+	case <-ctx.Done():
+		if step {
+			return nil, ctx.Err()
+		}
+		return &StopEvent{Reason: StopRunning}, nil
+	case <-client.Done():
+		return nil, client.Err()
+	}
+}
+
+// start resumes the halted target, backwards when reverse is set, and
+// returns the stop the resume ends with. Stops of earlier resumes that
+// nobody waited for are not returned.
+func (t *gdbTarget) start(ctx context.Context, step, reverse bool) (*gdb.Client, *pendingStop, error) {
+	if reverse && !t.isReversible() {
+		return nil, nil, fmt.Errorf("reverse execution needs a replay session")
+	}
+	client, err := t.halted(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t.run.Lock()
+	defer t.run.Unlock()
+	next := t.nextStop()
+	t.stepping = step
+	switch {
+	case step && reverse:
+		err = client.ReverseStep()
+	case reverse:
+		err = client.ReverseContinue()
+	case step:
+		err = client.Step()
+	default:
+		err = client.Continue()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, next, nil
+}
+
+// stopEvent converts a stop reply, reading the PC when it was not expedited
+func (t *gdbTarget) stopEvent(ctx context.Context, client *gdb.Client, stop *gdb.StopReply) (*StopEvent, error) {
+	event := &StopEvent{Reason: StopSignal}
+	if stop == nil {
+		return event, nil
+	}
+	event.Signal = stop.Signal
+	event.Thread = stop.Thread
+	event.Core = stop.Core
+	if stop.Exited() {
+		event.Reason = StopExited
+		return event, nil
+	}
+
+	if pc, ok := stop.Registers[t.arch.PC]; ok {
+		event.PC = t.arch.decode(pc)
+	} else if data, err := client.ReadRegister(t.arch.PC); err == nil {
+		event.PC = t.arch.decode(data)
+	}
+
+	switch stop.Reason {
+	case "watch", "rwatch", "awatch":
+		event.Reason = StopWatchpoint
+		event.WatchAddress = stop.WatchAddress
+		event.BreakpointID = t.watchpointAt(stop.WatchAddress)
+	case "swbreak", "hwbreak":
+		event.Reason = StopBreakpoint
+	case "replaylog":
+		event.Reason = StopReplayEnd
+		return event, nil
+	}
+	if id := t.breakpointAt(event.PC); id != "" && event.Reason != StopWatchpoint && (event.Reason == StopBreakpoint || stop.Signal == 5) {
+		event.Reason = StopBreakpoint
+		event.BreakpointID = id
+	}
+	return event, nil
+}
+
+func (t *gdbTarget) readRegisters(ctx context.Context, scope string) (map[string]interface{}, error) {
+	if scope != "" && scope != "general" && scope != "all" {
+		return nil, fmt.Errorf("unsupported register scope: %s", scope)
+	}
+	client, err := t.halted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	regs := make(map[string]interface{}, len(t.arch.Registers))
+	for _, reg := range t.arch.Registers {
+		data, err := client.ReadRegister(reg.Number)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", reg.Name, err)
+		}
+		regs[reg.Name] = t.arch.decode(data)
+	}
+	return regs, nil
+}
+
+func (t *gdbTarget) writeRegister(ctx context.Context, name string, value interface{}) error {
+	reg, ok := t.arch.register(name)
+	if !ok {
+		return fmt.Errorf("unknown register %s for %s", name, t.arch.Name)
+	}
+	v, err := registerValue(value)
+	if err != nil {
+		return err
+	}
+	client, err := t.halted(ctx)
+	if err != nil {
+		return err
+	}
+	return client.WriteRegister(reg.Number, t.arch.encode(v, reg.Size))
+}
+
+func (t *gdbTarget) readMemory(ctx context.Context, address uint64, size uint32) ([]byte, error) {
+	client, err := t.halted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ReadMemory(address, int(size))
+}
+
+func (t *gdbTarget) writeMemory(ctx context.Context, address uint64, data []byte) error {
+	client, err := t.halted(ctx)
+	if err != nil {
+		return err
+	}
+	return client.WriteMemory(address, data)
+}
+
+// registerValue converts a JSON register value: a number or a decimal or
+// 0x-prefixed string
+func registerValue(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v < 0 {
+			return uint64(int64(v)), nil
+		}
+		return uint64(v), nil
+	case int:
+		return uint64(v), nil
+	case int64:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case string:
+		parsed, err := strconv.ParseUint(strings.TrimSpace(v), 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid register value %q", v)
+		}
+		return parsed, nil
+	}
+	return 0, fmt.Errorf("invalid register value %v", value)
+}
+
+// monitor runs a monitor command of the backend on the halted target
+func (t *gdbTarget) monitor(ctx context.Context, command string) (string, error) {
+	client, err := t.halted(ctx)
+	if err != nil {
+		return "", err
+	}
+	return client.Monitor(command)
+}
@@ -0,0 +1,332 @@
+package adapters
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/gdb"
+)
+
+func TestGDBArchFor(t *testing.T) {
+	tests := []struct {
+		processor string
+		name      string
+		pc        int
+		kind      int
+		isa       string
+	}{
+		{"ARM Cortex-M4", "arm", 15, 2, "thumb"},
+		{"ARM Cortex-A9", "arm", 15, 4, "arm"},
+		{"ARM Cortex-A53", "aarch64", 32, 4, "arm64"},
+		{"RISC-V RV64GC", "riscv64", 32, 4, "riscv64"},
+		{"RISC-V RV32IMAC", "riscv32", 32, 4, "riscv32"},
+	}
+	for _, tt := range tests {
+		arch := gdbArchFor(&ProcessorConfig{Type: tt.processor})
+		if arch.Name != tt.name || arch.PC != tt.pc || arch.BreakpointKind != tt.kind {
+			t.Errorf("%s: got %s pc=%d kind=%d", tt.processor, arch.Name, arch.PC, arch.BreakpointKind)
+		}
+		if reg, ok := arch.register("pc"); !ok || reg.Number != tt.pc {
+			t.Errorf("%s: pc register not found", tt.processor)
+		}
+		if isa := InstructionSet(&ProcessorConfig{Type: tt.processor}); isa != tt.isa {
+			t.Errorf("%s: instruction set %s, want %s", tt.processor, isa, tt.isa)
+		}
+	}
+
+	arch := gdbArchFor(&ProcessorConfig{Type: "ARM Cortex-M4"})
+	if _, ok := arch.register("xpsr"); !ok {
+		t.Error("M-profile core should have xpsr")
+	}
+	if got := arch.decode(arch.encode(0x08000101, 4)); got != 0x08000101 {
+		t.Errorf("encode/decode round trip gave %#x", got)
+	}
+}
+
+func TestRegisterValue(t *testing.T) {
+	for _, value := range []interface{}{float64(0x20001000), "0x20001000", "536875008"} {
+		got, err := registerValue(value)
+		if err != nil || got != 0x20001000 {
+			t.Errorf("registerValue(%v) = %#x, %v", value, got, err)
+		}
+	}
+	if _, err := registerValue("pc"); err == nil {
+		t.Error("expected error for a non-numeric value")
+	}
+}
+
+func TestGDBTarget_ReinsertsBreakpoints(t *testing.T) {
+	stub := newBreakpointStub(t)
+	target := newGDBTarget(stub.listener.Addr().String(), &BoardConfig{
+		Nodes: []NodeConfig{{Processor: &ProcessorConfig{Type: "ARM Cortex-M4"}}},
+	})
+	ctx := context.Background()
+
+	if err := target.setBreakpoint(ctx, &Breakpoint{ID: "bp-1", Address: 0x08000100, Type: "software", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.setBreakpoint(ctx, &Breakpoint{ID: "bp-2", Address: 0x08000200, Type: "hardware", Enabled: false}); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.inserted(); len(got) != 1 || got[0] != "Z0,8000100,2" {
+		t.Fatalf("unexpected insertions %v", got)
+	}
+
+	// A new connection gets the enabled breakpoints again
+	target.close()
+	stub.reset()
+	if _, err := target.connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.inserted(); len(got) != 1 || got[0] != "Z0,8000100,2" {
+		t.Errorf("unexpected reinsertions %v", got)
+	}
+	if id := target.breakpointAt(0x08000100); id != "bp-1" {
+		t.Errorf("breakpointAt = %q", id)
+	}
+
+	if err := target.removeBreakpoint(ctx, "bp-1"); err != nil {
+		t.Fatal(err)
+	}
+	target.close()
+	stub.reset()
+	if _, err := target.connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.inserted(); len(got) != 0 {
+		t.Errorf("removed breakpoint was reinserted: %v", got)
+	}
+	target.close()
+}
+
+func TestGDBTarget_StopEvents(t *testing.T) {
+	stub := newBreakpointStub(t)
+	target := newGDBTarget(stub.listener.Addr().String(), &BoardConfig{
+		Nodes: []NodeConfig{{Processor: &ProcessorConfig{Type: "ARM Cortex-M4"}}},
+	})
+	defer target.close()
+	events := make(chan *StopEvent, 4)
+	target.watchStops(func(event *StopEvent) { events <- event })
+	ctx := context.Background()
+
+	event, err := target.step(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != StopStep || event.PC != 0x08000100 {
+		t.Errorf("unexpected step event %+v", event)
+	}
+
+	// Without breakpoints the target keeps running
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	event, err = target.resume(waitCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != StopRunning {
+		t.Errorf("expected running, got %+v", event)
+	}
+	target.close()
+
+	if err := target.setBreakpoint(ctx, &Breakpoint{ID: "bp-1", Address: 0x08000200, Type: "software", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	event, err = target.resume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != StopBreakpoint || event.BreakpointID != "bp-1" || event.PC != 0x08000200 {
+		t.Errorf("unexpected breakpoint event %+v", event)
+	}
+
+	// The handler sees every stop
+	for _, want := range []string{StopStep, StopBreakpoint} {
+		select {
+		case event := <-events:
+			if event.Reason != want {
+				t.Errorf("handler got %s, want %s", event.Reason, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("handler did not get the %s event", want)
+		}
+	}
+}
+
+func TestGDBTarget_Watchpoints(t *testing.T) {
+	stub := newBreakpointStub(t)
+	target := newGDBTarget(stub.listener.Addr().String(), &BoardConfig{
+		Nodes: []NodeConfig{{Processor: &ProcessorConfig{Type: "ARM Cortex-M4"}}},
+	})
+	defer target.close()
+	ctx := context.Background()
+
+	if err := target.setBreakpoint(ctx, &Breakpoint{ID: "bp-1", Address: 0x08000300, Type: BreakpointHardware, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.setBreakpoint(ctx, &Breakpoint{ID: "wp-1", Address: 0x20000000, Type: WatchWrite, Length: 8, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.setBreakpoint(ctx, &Breakpoint{ID: "wp-2", Address: 0x20000100, Type: WatchAccess, Enabled: false}); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.inserted(); len(got) != 2 || got[0] != "Z1,8000300,2" || got[1] != "Z2,20000000,8" {
+		t.Fatalf("unexpected insertions %v", got)
+	}
+
+	// A watchpoint hit is not taken for the breakpoint at the pc
+	event, err := target.resume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != StopWatchpoint || event.BreakpointID != "wp-1" || event.WatchAddress != 0x20000004 || event.PC != 0x08000300 {
+		t.Errorf("unexpected watchpoint event %+v", event)
+	}
+	if id := target.breakpointAt(0x20000000); id != "" {
+		t.Errorf("breakpointAt matched watchpoint %q", id)
+	}
+	if id := target.watchpointAt(0x20000008); id != "" {
+		t.Errorf("watchpointAt matched past the range: %q", id)
+	}
+}
+
+func TestGDBTarget_Reverse(t *testing.T) {
+	stub := newBreakpointStub(t)
+	target := newGDBTarget(stub.listener.Addr().String(), &BoardConfig{
+		Nodes: []NodeConfig{{Processor: &ProcessorConfig{Type: "ARM Cortex-M4"}}},
+	})
+	defer target.close()
+	ctx := context.Background()
+
+	if _, err := target.reverseStep(ctx); err == nil {
+		t.Fatal("reverse step succeeded without a replay")
+	}
+	target.setReversible(true)
+	event, err := target.reverseStep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != StopStep {
+		t.Errorf("unexpected reverse step event %+v", event)
+	}
+	event, err = target.reverseResume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != StopReplayEnd || event.PC != 0x08000000 {
+		t.Errorf("unexpected reverse continue event %+v", event)
+	}
+}
+
+// breakpointStub accepts gdb connections and records inserted breakpoints
+type breakpointStub struct {
+	listener net.Listener
+	mu       sync.Mutex
+	packets  []string
+}
+
+func newBreakpointStub(t *testing.T) *breakpointStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	stub := &breakpointStub{listener: listener}
+	go stub.accept()
+	return stub
+}
+
+func (s *breakpointStub) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *breakpointStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		packet, err := gdb.ReadPacket(r)
+		if err != nil {
+			return
+		}
+		reply := ""
+		switch {
+		case packet == "QStartNoAckMode":
+			reply = "OK"
+		case packet == "?":
+			reply = "S05"
+		case packet == "pf":
+			reply = "00010008"
+		case packet == "s", packet == "bs":
+			reply = "S05"
+		case packet == gdb.InterruptPacket:
+			reply = "S02"
+		case packet == "bc":
+			reply = "T05replaylog:begin;0f:" + leAddress("8000000") + ";"
+		case packet == "c":
+			// Run into the most recent breakpoint, or write the middle
+			// of the most recent watchpoint at 0x08000300
+			s.mu.Lock()
+			for _, p := range s.packets {
+				fields := strings.Split(p, ",")
+				switch fields[0] {
+				case "Z0":
+					reply = "T05swbreak:;0f:" + leAddress(fields[1]) + ";"
+				case "Z2":
+					address, _ := strconv.ParseUint(fields[1], 16, 64)
+					length, _ := strconv.ParseUint(fields[2], 16, 64)
+					reply = fmt.Sprintf("T05watch:%x;0f:%s;", address+length/2, leAddress("8000300"))
+				}
+			}
+			s.mu.Unlock()
+			if reply == "" {
+				continue // Keeps running
+			}
+		case strings.HasPrefix(packet, "Z"), strings.HasPrefix(packet, "z"):
+			s.mu.Lock()
+			s.packets = append(s.packets, packet)
+			s.mu.Unlock()
+			reply = "OK"
+		}
+		if _, err := conn.Write(gdb.Frame(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// leAddress converts a hex address to a little-endian 32-bit register value
+func leAddress(address string) string {
+	value, _ := strconv.ParseUint(address, 16, 32)
+	return fmt.Sprintf("%02x%02x%02x%02x", byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+func (s *breakpointStub) inserted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var inserted []string
+	for _, packet := range s.packets {
+		if packet[0] == 'Z' {
+			inserted = append(inserted, packet)
+		}
+	}
+	return inserted
+}
+
+func (s *breakpointStub) reset() {
+	s.mu.Lock()
+	s.packets = nil
+	s.mu.Unlock()
+}
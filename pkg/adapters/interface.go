@@ -26,8 +26,11 @@ type BackendAdapter interface {
 	// Debug Operations
 	SetBreakpoint(ctx context.Context, instanceID string, bp *Breakpoint) error
 	RemoveBreakpoint(ctx context.Context, instanceID string, bpID string) error
-	StepInstruction(ctx context.Context, instanceID string) error
-	Continue(ctx context.Context, instanceID string) error
+	StepInstruction(ctx context.Context, instanceID string) (*StopEvent, error)
+	Continue(ctx context.Context, instanceID string) (*StopEvent, error) // Waits for a stop until ctx is done
+	WatchStops(ctx context.Context, instanceID string, handler func(*StopEvent)) error // Handler runs on a backend goroutine for every stop and must not block
+	ReverseStep(ctx context.Context, instanceID string) (*StopEvent, error) // Only while replaying a recording
+	ReverseContinue(ctx context.Context, instanceID string) (*StopEvent, error) // Waits for a stop like Continue
 	
 	// State Inspection
 	ReadRegisters(ctx context.Context, instanceID string, scope string) (map[string]interface{}, error)
@@ -42,12 +45,15 @@ type BackendAdapter interface {
 	// Analysis
 	ExportCoverage(ctx context.Context, instanceID string) (string, error)
 	ExportTrace(ctx context.Context, instanceID string) (string, error)
+	InstructionCount(ctx context.Context, instanceID string) (uint64, error) // Instructions executed by the last launch of a deterministic instance, once powered off
 	
 	// GDB Bridge
 	GetGDBServerAddress(ctx context.Context, instanceID string) (string, error)
+	ServeGDB(ctx context.Context, instanceID string, conn io.ReadWriteCloser, readOnly bool) error // Proxies a GDB client onto the debug connection until it detaches
 	
 	// Console/Logs
 	GetConsoleStream(ctx context.Context, instanceID string) (io.ReadCloser, error)
+	ReadConsoleLog(ctx context.Context, instanceID string) ([]byte, error) // Console output of the last launch
 	
 	// Backend Info
 	GetBackendType() BackendType
@@ -144,6 +150,7 @@ type IRQRoute struct {
 type BootConfig struct {
 	BootROM  string            `json:"bootrom,omitempty" yaml:"bootrom,omitempty"`
 	BootArgs []string          `json:"bootargs,omitempty" yaml:"bootargs,omitempty"`
+	DTB      string            `json:"dtb,omitempty" yaml:"dtb,omitempty"` // Prebuilt blob path, "none", or empty to generate one
 	Env      map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
 }
 
@@ -153,6 +160,12 @@ type ResourceConfig struct {
 	MemoryMB   int    `json:"memory_mb,omitempty" yaml:"memory_mb,omitempty"`
 	DiskGB     int    `json:"disk_gb,omitempty" yaml:"disk_gb,omitempty"`
 	TimeoutSec int    `json:"timeout_sec,omitempty" yaml:"timeout_sec,omitempty"`
+	
+	// Deterministic instances run reproducibly: virtual time follows the
+	// executed instructions, clocks start at a fixed date and random
+	// sources use Seed
+	Deterministic bool   `json:"deterministic,omitempty" yaml:"deterministic,omitempty"`
+	Seed          uint64 `json:"seed,omitempty" yaml:"seed,omitempty"`
 }
 
 // ProgramMetadata represents metadata for uploaded programs
@@ -166,19 +179,93 @@ type ProgramMetadata struct {
 
 // StartOptions represents program start options
 type StartOptions struct {
-	Args        []string          `json:"args,omitempty"`
-	Env         map[string]string `json:"env,omitempty"`
-	WaitForGDB  bool              `json:"wait_for_gdb,omitempty"`
-	EnableTrace bool              `json:"enable_trace,omitempty"`
+	Args          []string          `json:"args,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	WaitForGDB    bool              `json:"wait_for_gdb,omitempty"`
+	EnableTrace   bool              `json:"enable_trace,omitempty"`
+	Record        bool              `json:"record,omitempty"`         // Record execution for replay sessions (QEMU)
+	Coverage      bool              `json:"coverage,omitempty"`       // Collect the executed basic blocks (QEMU)
+	MonitorMemory bool              `json:"monitor_memory,omitempty"` // Report the memory accesses of the program to OnMemoryAccess (QEMU)
+
+	// Set by the session service
+	Program        string             `json:"-"` // Program file
+	Replay         bool               `json:"-"` // Replay the recording in ReplayDir instead of running live
+	ReplayDir      string             `json:"-"` // Directory of the recording to write or replay
+	OnMemoryAccess func(MemoryAccess) `json:"-"` // Runs on a backend goroutine for every access and must not block
 }
 
-// Breakpoint represents a debug breakpoint
+// MemoryAccess is a load or store of a program
+type MemoryAccess struct {
+	PC      uint64 `json:"pc"`
+	Address uint64 `json:"address"`
+	Size    int    `json:"size"` // In bytes, 0 when unknown
+	Write   bool   `json:"write"`
+}
+
+// Files of a QEMU record/replay recording in its directory
+const (
+	ReplayLogFile      = "replay.bin"      // Event log of the nondeterministic inputs
+	ReplaySnapshotFile = "snapshots.qcow2" // Initial snapshot, from which replays seek backwards
+)
+
+// Breakpoint represents a debug breakpoint, or a watchpoint on Length bytes
+// at Address when its type is write, read or access
 type Breakpoint struct {
-	ID        string `json:"id,omitempty"`
-	Address   uint64 `json:"address"`
-	Type      string `json:"type"`      // "hardware", "software"
-	Condition string `json:"condition,omitempty"`
-	Enabled   bool   `json:"enabled"`
+	ID          string `json:"id,omitempty"`
+	Address     uint64 `json:"address"`
+	Location    string `json:"location,omitempty"`     // Function, file:line or address of the session's program; sets Address. For watchpoints, a variable or address
+	Type        string `json:"type"`                   // "software" (default), "hardware", or a watchpoint type
+	Length      uint64 `json:"length,omitempty"`       // Bytes watched by watchpoints, default 4
+	Condition   string `json:"condition,omitempty"`    // Expression over registers and memory that must hold for a stop, see package condition
+	IgnoreCount int    `json:"ignore_count,omitempty"` // Hits that resume the target before one stops it
+	HitCount    int    `json:"hit_count"`              // Hits with the condition holding, counted by the session
+	Enabled     bool   `json:"enabled"`
+}
+
+// Breakpoint types. Watchpoints stop the target after it writes, reads or
+// accesses the watched range.
+const (
+	BreakpointSoftware = "software"
+	BreakpointHardware = "hardware"
+	WatchWrite         = "write"
+	WatchRead          = "read"
+	WatchAccess        = "access"
+)
+
+// DefaultWatchLength is the number of bytes a watchpoint watches unless
+// told otherwise
+const DefaultWatchLength = 4
+
+// IsWatchpoint reports whether the breakpoint watches data rather than code
+func (bp *Breakpoint) IsWatchpoint() bool {
+	switch bp.Type {
+	case WatchWrite, WatchRead, WatchAccess:
+		return true
+	}
+	return false
+}
+
+// Stop reasons of a StopEvent
+const (
+	StopBreakpoint = "breakpoint"
+	StopWatchpoint = "watchpoint"
+	StopStep       = "step"
+	StopSignal     = "signal"
+	StopExited     = "exited"
+	StopRunning    = "running"    // The target did not stop before the wait ended
+	StopReplayEnd  = "replay_end" // A replay reached the beginning or the end of its recording
+)
+
+// StopEvent describes where and why the target halted
+type StopEvent struct {
+	Reason         string `json:"reason"`
+	PC             uint64 `json:"pc"`
+	Signal         int    `json:"signal,omitempty"`
+	BreakpointID   string `json:"breakpoint_id,omitempty"`
+	WatchAddress   uint64 `json:"watch_address,omitempty"`
+	ConditionError string `json:"condition_error,omitempty"` // Why the breakpoint condition could not be evaluated
+	Thread         string `json:"thread,omitempty"`
+	Core           int    `json:"core"` // -1 when not reported
 }
 
 // BackendCapabilities represents what a backend supports
@@ -0,0 +1,93 @@
+package adapters
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Violations of the memory map by an access
+const (
+	ViolationUnmapped  = "unmapped"   // No region holds the whole access
+	ViolationReadOnly  = "read_only"  // Write to a region without W access
+	ViolationWriteOnly = "write_only" // Read from a region without R access
+)
+
+// MappedRegion is an address range of a board
+type MappedRegion struct {
+	Name   string `json:"name"`
+	Start  uint64 `json:"start"`
+	End    uint64 `json:"end"`    // Address after the region
+	Access string `json:"access"` // "RW", "RO", "RX" or "WO"
+}
+
+// MemoryMap is the address space a board config declares: the memory
+// regions, peripherals and shared memory of its nodes. Peripherals without
+// a size property span defaultPeripheralRegSize bytes.
+type MemoryMap struct {
+	Regions []MappedRegion
+}
+
+// NewMemoryMap returns the memory map of a board config
+func NewMemoryMap(config *BoardConfig) *MemoryMap {
+	m := &MemoryMap{}
+	for _, node := range config.Nodes {
+		for _, mem := range node.Memory {
+			access := mem.Access
+			if access == "" {
+				access = accessFor(mem.Type)
+			}
+			m.add(fmt.Sprintf("%s@%#x", mem.Type, mem.Address), mem.Address, mem.Size, access)
+		}
+		for _, periph := range node.Peripherals {
+			size := uint64(defaultPeripheralRegSize)
+			if value, ok := propertyUint(periph.Properties["size"]); ok && value > 0 {
+				size = value
+			}
+			name := periph.Name
+			if name == "" {
+				name = fmt.Sprintf("%s@%#x", periph.Type, periph.Address)
+			}
+			m.add(name, periph.Address, size, "RW")
+		}
+	}
+	if config.Interconnect != nil {
+		for _, shared := range config.Interconnect.SharedMemory {
+			m.add(shared.ID, shared.Address, shared.Size, "RW")
+		}
+	}
+	return m
+}
+
+func (m *MemoryMap) add(name string, address, size uint64, access string) {
+	if size == 0 {
+		return
+	}
+	m.Regions = append(m.Regions, MappedRegion{Name: name, Start: address, End: address + size, Access: strings.ToUpper(access)})
+}
+
+// Check returns how an access violates the memory map, and the region it
+// violates, or "" when the map allows it. Accesses of unknown size are
+// taken as one byte. Of overlapping regions, the first allowing the access
+// wins.
+func (m *MemoryMap) Check(access MemoryAccess) (string, *MappedRegion) {
+	size := uint64(access.Size)
+	if size == 0 {
+		size = 1
+	}
+	violation, violated := ViolationUnmapped, (*MappedRegion)(nil)
+	for i := range m.Regions {
+		region := &m.Regions[i]
+		if access.Address < region.Start || access.Address+size > region.End {
+			continue
+		}
+		switch {
+		case access.Write && !strings.Contains(region.Access, "W"):
+			violation, violated = ViolationReadOnly, region
+		case !access.Write && region.Access == "WO":
+			violation, violated = ViolationWriteOnly, region
+		default:
+			return "", nil
+		}
+	}
+	return violation, violated
+}
@@ -0,0 +1,50 @@
+package adapters
+
+import "testing"
+
+func TestMemoryMap(t *testing.T) {
+	config := &BoardConfig{
+		Nodes: []NodeConfig{{
+			Memory: []MemoryRegion{
+				{Type: "Flash", Address: 0x08000000, Size: 0x100000, Access: "RX"},
+				{Type: "RAM", Address: 0x20000000, Size: 0x20000, Access: "RW"},
+				{Type: "ROM", Address: 0x1fff0000, Size: 0x1000},
+				{Type: "RAM", Address: 0x30000000, Size: 0x100, Access: "WO"},
+			},
+			Peripherals: []PeripheralConfig{
+				{Type: "uart", Name: "usart1", Address: 0x40011000, Properties: map[string]interface{}{"size": 0x400}},
+				{Type: "gpio", Address: 0x40020000},
+			},
+		}},
+		Interconnect: &InterconnectConfig{SharedMemory: []SharedMemoryConfig{{ID: "mailbox", Address: 0x38000000, Size: 0x1000}}},
+	}
+	m := NewMemoryMap(config)
+
+	tests := []struct {
+		access    MemoryAccess
+		violation string
+		region    string
+	}{
+		{MemoryAccess{Address: 0x20000010, Size: 4, Write: true}, "", ""},
+		{MemoryAccess{Address: 0x08000100, Size: 4}, "", ""},
+		{MemoryAccess{Address: 0x08000100, Size: 4, Write: true}, ViolationReadOnly, "Flash@0x8000000"},
+		{MemoryAccess{Address: 0x1fff0000, Size: 1, Write: true}, ViolationReadOnly, "ROM@0x1fff0000"},
+		{MemoryAccess{Address: 0x30000000, Size: 4}, ViolationWriteOnly, "RAM@0x30000000"},
+		{MemoryAccess{Address: 0x2001fffe, Size: 4}, ViolationUnmapped, ""},
+		{MemoryAccess{Address: 0x10000000}, ViolationUnmapped, ""},
+		{MemoryAccess{Address: 0x400113fc, Size: 4, Write: true}, "", ""},
+		{MemoryAccess{Address: 0x40011400, Size: 4, Write: true}, ViolationUnmapped, ""},
+		{MemoryAccess{Address: 0x40020ffc, Size: 4}, "", ""},
+		{MemoryAccess{Address: 0x38000000, Size: 8, Write: true}, "", ""},
+	}
+	for _, tt := range tests {
+		violation, region := m.Check(tt.access)
+		name := ""
+		if region != nil {
+			name = region.Name
+		}
+		if violation != tt.violation || name != tt.region {
+			t.Errorf("Check(%+v) = %q, %q; want %q, %q", tt.access, violation, name, tt.violation, tt.region)
+		}
+	}
+}
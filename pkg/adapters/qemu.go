@@ -4,8 +4,17 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/devicetree"
 )
 
 // QEMUAdapter implements BackendAdapter for QEMU
@@ -13,6 +22,7 @@ type QEMUAdapter struct {
 	mu         sync.RWMutex
 	instances  map[string]*QEMUInstance
 	workDir    string
+	pluginDir  string
 }
 
 // QEMUInstance represents a running QEMU instance
@@ -26,7 +36,43 @@ type QEMUInstance struct {
 	MonitorPort int
 	Running     bool
 	Programs    map[string]*ProgramInfo
-}
+	debug       *gdbTarget
+	
+	deterministic bool
+	seed          uint64
+	coverage      string         // Collector of the executed blocks of the last launch, if any
+	trace         string         // Collector of the execution trace of the last launch, if any
+	memory        *memoryMonitor // Follows the memory accesses of the launch, if monitored
+}
+
+// Settings of deterministic QEMU instances
+const (
+	qemuICount     = "shift=3,sleep=off"   // 8 ns of virtual time per instruction, idle time skipped
+	qemuRTCBase    = "2000-01-01T00:00:00" // Start of the guest RTC
+	qemuInsnPlugin = "libinsn.so"          // TCG plugin reporting the executed instructions at exit
+)
+
+// Collectors of the executed basic blocks
+const (
+	qemuCoveragePlugin  = "drcov"    // The drcov TCG plugin writes the blocks at exit
+	qemuCoverageExecLog = "exec_log" // The blocks are taken from the in_asm and exec logs
+	qemuDrcovPlugin     = "libdrcov.so"
+)
+
+// Collectors of the execution trace
+const (
+	qemuTracePlugin   = "execlog"  // The execlog TCG plugin logs every executed instruction
+	qemuTraceExecLog  = "exec_log" // The blocks are taken from the in_asm and exec logs
+	qemuExeclogPlugin = "libexeclog.so"
+)
+
+// Files of an instance in its work directory
+const (
+	qemuConsoleLog   = "console.log"
+	qemuLog          = "qemu.log" // Plugin output and -d logs
+	qemuCoverageFile = "coverage.drcov"
+	qemuTraceFile    = "trace.records"
+)
 
 // ProgramInfo stores information about loaded programs
 type ProgramInfo struct {
@@ -44,6 +90,14 @@ func NewQEMUAdapter(workDir string) *QEMUAdapter {
 	}
 }
 
+// SetPluginDir sets the directory of the QEMU TCG plugins. Instructions
+// are counted only when it is set.
+func (a *QEMUAdapter) SetPluginDir(dir string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pluginDir = dir
+}
+
 // CreateInstance creates a new QEMU instance
 func (a *QEMUAdapter) CreateInstance(ctx context.Context, sessionID string, config *BoardConfig, resources *ResourceConfig) (string, error) {
 	a.mu.Lock()
@@ -60,6 +114,12 @@ func (a *QEMUAdapter) CreateInstance(ctx context.Context, sessionID string, conf
 		ConsolePort: allocatePort(),
 		MonitorPort: allocatePort(),
 	}
+	if resources != nil {
+		instance.deterministic = resources.Deterministic
+		instance.seed = resources.Seed
+	}
+	
+	instance.debug = newGDBTarget(fmt.Sprintf("127.0.0.1:%d", instance.GDBPort), config)
 	
 	a.instances[instanceID] = instance
 	return instanceID, nil
@@ -75,9 +135,7 @@ func (a *QEMUAdapter) DestroyInstance(ctx context.Context, instanceID string) er
 		return fmt.Errorf("instance not found: %s", instanceID)
 	}
 	
-	if instance.Process != nil {
-		instance.Process.Process.Kill()
-	}
+	a.terminate(instance)
 	
 	delete(a.instances, instanceID)
 	return nil
@@ -98,13 +156,24 @@ func (a *QEMUAdapter) PowerOn(ctx context.Context, instanceID string) error {
 	}
 	
 	// Build QEMU command line
-	args := a.buildQEMUArgs(instance)
-	instance.Process = exec.Command("qemu-system-arm", args...)
+	args, err := a.launchArgs(instance, "", "")
+	if err != nil {
+		return err
+	}
+	icount, err := icountArgs(instance, nil)
+	if err != nil {
+		return err
+	}
+	args = append(args, icount...)
+	instance.Process = exec.Command(qemuBinary(instance.Config), args...)
 	
 	if err := instance.Process.Start(); err != nil {
 		return fmt.Errorf("failed to start QEMU: %w", err)
 	}
 	
+	instance.debug.setReversible(false)
+	instance.coverage = ""
+	instance.trace = ""
 	instance.Running = true
 	return nil
 }
@@ -119,9 +188,7 @@ func (a *QEMUAdapter) PowerOff(ctx context.Context, instanceID string) error {
 		return fmt.Errorf("instance not found: %s", instanceID)
 	}
 	
-	if instance.Process != nil {
-		instance.Process.Process.Kill()
-	}
+	a.terminate(instance)
 	
 	instance.Running = false
 	return nil
@@ -139,9 +206,83 @@ func (a *QEMUAdapter) UploadProgram(ctx context.Context, instanceID string, prog
 	return "", fmt.Errorf("not implemented")
 }
 
-// StartProgram starts a loaded program
+// StartProgram relaunches the powered instance with the program, as QEMU
+// loads programs at launch. With Record the execution is recorded for
+// replay; a replay starts halted for the debugger to drive it. With
+// Coverage the executed blocks are collected by the drcov plugin when the
+// plugin directory has it, from the exec log otherwise. With EnableTrace
+// the executed instructions and the exceptions are logged, by the execlog
+// plugin when the plugin directory has it. With MonitorMemory the execlog
+// plugin logs the memory accesses too, which are reported as QEMU runs.
 func (a *QEMUAdapter) StartProgram(ctx context.Context, instanceID string, programID string, options *StartOptions) error {
-	return fmt.Errorf("not implemented")
+	if options == nil || options.Program == "" {
+		return fmt.Errorf("program file required")
+	}
+	
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return fmt.Errorf("instance not found: %s", instanceID)
+	}
+	if !instance.Running {
+		return fmt.Errorf("instance not running: %s", instanceID)
+	}
+	
+	collector := ""
+	if options.Coverage {
+		collector = a.coverageCollector()
+	}
+	tracer := ""
+	if options.EnableTrace {
+		tracer = a.traceCollector()
+	}
+	if options.MonitorMemory {
+		if options.OnMemoryAccess == nil {
+			return fmt.Errorf("memory monitoring requires an access handler")
+		}
+		if a.traceCollector() != qemuTracePlugin {
+			return fmt.Errorf("memory monitoring requires %s in the QEMU plugin directory", qemuExeclogPlugin)
+		}
+		tracer = qemuTracePlugin
+	}
+	args, err := a.launchArgs(instance, collector, tracer)
+	if err != nil {
+		return err
+	}
+	args = append(args, "-kernel", options.Program)
+	if len(options.Args) > 0 {
+		args = append(args, "-append", strings.Join(options.Args, " "))
+	}
+	icount, err := icountArgs(instance, options)
+	if err != nil {
+		return err
+	}
+	args = append(args, icount...)
+	if options.WaitForGDB || options.Replay {
+		args = append(args, "-S")
+	}
+	
+	a.terminate(instance)
+	instance.Process = exec.Command(qemuBinary(instance.Config), args...)
+	if err := instance.Process.Start(); err != nil {
+		instance.Process = nil
+		instance.Running = false
+		return fmt.Errorf("failed to start QEMU: %w", err)
+	}
+	
+	instance.debug.setReversible(options.Replay)
+	instance.coverage = collector
+	instance.trace = tracer
+	if options.MonitorMemory {
+		instance.memory = monitorMemory(filepath.Join(a.workDir, instance.ID, qemuLog), options.OnMemoryAccess)
+	}
+	for _, program := range instance.Programs {
+		program.Running = false
+	}
+	instance.Programs[programID] = &ProgramInfo{ID: programID, Path: options.Program, Running: true}
+	return nil
 }
 
 // PauseProgram pauses a running program
@@ -156,42 +297,106 @@ func (a *QEMUAdapter) StopProgram(ctx context.Context, instanceID string, progra
 
 // SetBreakpoint sets a breakpoint
 func (a *QEMUAdapter) SetBreakpoint(ctx context.Context, instanceID string, bp *Breakpoint) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.setBreakpoint(ctx, bp)
 }
 
 // RemoveBreakpoint removes a breakpoint
 func (a *QEMUAdapter) RemoveBreakpoint(ctx context.Context, instanceID string, bpID string) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.removeBreakpoint(ctx, bpID)
 }
 
 // StepInstruction steps one instruction
-func (a *QEMUAdapter) StepInstruction(ctx context.Context, instanceID string) error {
-	return fmt.Errorf("not implemented")
+func (a *QEMUAdapter) StepInstruction(ctx context.Context, instanceID string) (*StopEvent, error) {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.step(ctx)
 }
 
 // Continue continues execution
-func (a *QEMUAdapter) Continue(ctx context.Context, instanceID string) error {
-	return fmt.Errorf("not implemented")
+func (a *QEMUAdapter) Continue(ctx context.Context, instanceID string) (*StopEvent, error) {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.resume(ctx)
+}
+
+// ReverseStep steps one instruction backwards in a replay
+func (a *QEMUAdapter) ReverseStep(ctx context.Context, instanceID string) (*StopEvent, error) {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.reverseStep(ctx)
+}
+
+// ReverseContinue runs a replay backwards to the previous breakpoint
+func (a *QEMUAdapter) ReverseContinue(ctx context.Context, instanceID string) (*StopEvent, error) {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.reverseResume(ctx)
+}
+
+// WatchStops registers a handler for the stops of an instance. It stays
+// registered across power cycles.
+func (a *QEMUAdapter) WatchStops(ctx context.Context, instanceID string, handler func(*StopEvent)) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return fmt.Errorf("instance not found: %s", instanceID)
+	}
+	instance.debug.watchStops(handler)
+	return nil
 }
 
 // ReadRegisters reads register values
 func (a *QEMUAdapter) ReadRegisters(ctx context.Context, instanceID string, scope string) (map[string]interface{}, error) {
-	return nil, fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.readRegisters(ctx, scope)
 }
 
 // WriteRegister writes a register value
 func (a *QEMUAdapter) WriteRegister(ctx context.Context, instanceID string, register string, value interface{}) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.writeRegister(ctx, register, value)
 }
 
 // ReadMemory reads memory
 func (a *QEMUAdapter) ReadMemory(ctx context.Context, instanceID string, address uint64, size uint32) ([]byte, error) {
-	return nil, fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.readMemory(ctx, address, size)
 }
 
 // WriteMemory writes memory
 func (a *QEMUAdapter) WriteMemory(ctx context.Context, instanceID string, address uint64, data []byte) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.writeMemory(ctx, address, data)
 }
 
 // CreateSnapshot creates a snapshot
@@ -204,14 +409,94 @@ func (a *QEMUAdapter) RestoreSnapshot(ctx context.Context, instanceID string, sn
 	return fmt.Errorf("not implemented")
 }
 
-// ExportCoverage exports coverage data
+// ExportCoverage returns the DrCov file of the blocks executed by the last
+// launch, which started a program with coverage. The drcov plugin writes it
+// when QEMU exits; the exec log is converted.
 func (a *QEMUAdapter) ExportCoverage(ctx context.Context, instanceID string) (string, error) {
-	return "", fmt.Errorf("not implemented")
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return "", fmt.Errorf("instance not found: %s", instanceID)
+	}
+	if instance.coverage == "" {
+		return "", fmt.Errorf("the program was not started with coverage")
+	}
+	if instance.Running {
+		return "", fmt.Errorf("coverage is complete once the instance is powered off")
+	}
+	dir := filepath.Join(a.workDir, instance.ID)
+	path := filepath.Join(dir, qemuCoverageFile)
+	if instance.coverage == qemuCoverageExecLog {
+		if err := convertExecLog(filepath.Join(dir, qemuLog), path); err != nil {
+			return "", err
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("read coverage: %w", err)
+	}
+	return path, nil
 }
 
-// ExportTrace exports trace data
+// ExportTrace returns the execution records of the last launch, which
+// started a program with tracing, converted from the QEMU log. They are
+// complete once the instance is powered off.
 func (a *QEMUAdapter) ExportTrace(ctx context.Context, instanceID string) (string, error) {
-	return "", fmt.Errorf("not implemented")
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return "", fmt.Errorf("instance not found: %s", instanceID)
+	}
+	if instance.trace == "" {
+		return "", fmt.Errorf("the program was not started with tracing")
+	}
+	if instance.Running {
+		return "", fmt.Errorf("the trace is complete once the instance is powered off")
+	}
+	dir := filepath.Join(a.workDir, instance.ID)
+	path := filepath.Join(dir, qemuTraceFile)
+	if err := convertTraceLog(filepath.Join(dir, qemuLog), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// InstructionCount returns the instructions executed by the last launch of
+// a deterministic instance, which the insn plugin reports when QEMU exits
+func (a *QEMUAdapter) InstructionCount(ctx context.Context, instanceID string) (uint64, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return 0, fmt.Errorf("instance not found: %s", instanceID)
+	}
+	if !instance.deterministic || a.pluginDir == "" {
+		return 0, fmt.Errorf("instructions are only counted for deterministic instances with the QEMU plugin directory set")
+	}
+	if instance.Running {
+		return 0, fmt.Errorf("instruction count is known once the instance is powered off")
+	}
+	data, err := os.ReadFile(filepath.Join(a.workDir, instance.ID, qemuLog))
+	if err != nil {
+		return 0, fmt.Errorf("read instruction count: %w", err)
+	}
+	return parseInsnCount(data)
+}
+
+// insnCount matches the total of the insn plugin, "insns: N" in older
+// releases and "total insns: N" in newer ones
+var insnCount = regexp.MustCompile(`(?m)^(?:total )?insns: (\d+)$`)
+
+func parseInsnCount(log []byte) (uint64, error) {
+	matches := insnCount.FindAllSubmatch(log, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("no instruction count in the plugin log")
+	}
+	return strconv.ParseUint(string(matches[len(matches)-1][1]), 10, 64)
 }
 
 // GetGDBServerAddress returns the GDB server address
@@ -227,11 +512,37 @@ func (a *QEMUAdapter) GetGDBServerAddress(ctx context.Context, instanceID string
 	return fmt.Sprintf("localhost:%d", instance.GDBPort), nil
 }
 
+// ServeGDB serves a GDB client through the debug connection of a powered
+// instance, sharing it with the other debug methods
+func (a *QEMUAdapter) ServeGDB(ctx context.Context, instanceID string, conn io.ReadWriteCloser, readOnly bool) error {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.serve(ctx, conn, readOnly)
+}
+
 // GetConsoleStream returns console output stream
 func (a *QEMUAdapter) GetConsoleStream(ctx context.Context, instanceID string) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("not implemented")
 }
 
+// ReadConsoleLog returns what the serial console wrote in the last launch
+func (a *QEMUAdapter) ReadConsoleLog(ctx context.Context, instanceID string) ([]byte, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return nil, fmt.Errorf("instance not found: %s", instanceID)
+	}
+	data, err := os.ReadFile(filepath.Join(a.workDir, instance.ID, qemuConsoleLog))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
 // GetBackendType returns the backend type
 func (a *QEMUAdapter) GetBackendType() BackendType {
 	return BackendQEMU
@@ -260,6 +571,10 @@ func (a *QEMUAdapter) GetCapabilities() *BackendCapabilities {
 			"multicore":         true,
 			"shared_memory":     true,
 			"peripheral_model":  true,
+			"record_replay":     true,
+			"deterministic":     true,
+			"trace":             true,
+			"memory_monitor":    true,
 		},
 		Limits: map[string]int{
 			"max_cores":       16,
@@ -269,13 +584,211 @@ func (a *QEMUAdapter) GetCapabilities() *BackendCapabilities {
 	}
 }
 
+// debugTarget returns the gdbstub connection of a powered instance
+func (a *QEMUAdapter) debugTarget(instanceID string) (*gdbTarget, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return nil, fmt.Errorf("instance not found: %s", instanceID)
+	}
+	if !instance.Running {
+		return nil, fmt.Errorf("instance not running: %s", instanceID)
+	}
+	return instance.debug, nil
+}
+
+// launchArgs returns the command line of an instance without a program
+// and the instruction counter, collecting coverage with collector and the
+// execution trace with tracer if set
+func (a *QEMUAdapter) launchArgs(instance *QEMUInstance, collector, tracer string) ([]string, error) {
+	dir := filepath.Join(a.workDir, instance.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// Coverage, traces and logs of an earlier launch must not be taken for this one's
+	os.Remove(filepath.Join(dir, qemuCoverageFile))
+	os.Remove(filepath.Join(dir, qemuTraceFile))
+	os.Remove(filepath.Join(dir, qemuLog))
+	
+	args := a.buildQEMUArgs(instance)
+	dtb, err := a.deviceTreePath(instance)
+	if err != nil {
+		return nil, err
+	}
+	if dtb != "" {
+		args = append(args, "-dtb", dtb)
+	}
+	
+	// QEMU has one log, shared by the plugins and the -d items
+	var logItems []string
+	if instance.deterministic {
+		args = append(args, deterministicArgs(instance)...)
+		if a.pluginDir != "" {
+			args = append(args, "-plugin", filepath.Join(a.pluginDir, qemuInsnPlugin))
+			logItems = addLogItems(logItems, "plugin")
+		}
+	}
+	switch collector {
+	case qemuCoveragePlugin:
+		args = append(args, "-plugin", fmt.Sprintf("%s,filename=%s", filepath.Join(a.pluginDir, qemuDrcovPlugin), filepath.Join(dir, qemuCoverageFile)))
+	case qemuCoverageExecLog:
+		// nochain logs every execution of a block, not only its first
+		logItems = addLogItems(logItems, "in_asm", "exec", "nochain")
+	}
+	switch tracer {
+	case qemuTracePlugin:
+		args = append(args, "-plugin", filepath.Join(a.pluginDir, qemuExeclogPlugin))
+		logItems = addLogItems(logItems, "plugin", "int")
+	case qemuTraceExecLog:
+		logItems = addLogItems(logItems, "in_asm", "exec", "nochain", "int")
+	}
+	if len(logItems) > 0 {
+		args = append(args, "-d", strings.Join(logItems, ","), "-D", filepath.Join(dir, qemuLog))
+	}
+	return args, nil
+}
+
+// deterministicArgs starts the guest RTC at a fixed date on the virtual
+// clock and seeds the guest random generator
+func deterministicArgs(instance *QEMUInstance) []string {
+	return []string{
+		"-rtc", fmt.Sprintf("base=%s,clock=vm", qemuRTCBase),
+		"-seed", strconv.FormatUint(instance.seed, 10),
+	}
+}
+
+// addLogItems adds -d items not yet in items
+func addLogItems(items []string, add ...string) []string {
+	for _, item := range add {
+		found := false
+		for _, existing := range items {
+			found = found || existing == item
+		}
+		if !found {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// traceCollector returns how the execution trace is collected: by the
+// execlog plugin when the plugin directory has it
+func (a *QEMUAdapter) traceCollector() string {
+	if a.pluginDir != "" {
+		if _, err := os.Stat(filepath.Join(a.pluginDir, qemuExeclogPlugin)); err == nil {
+			return qemuTracePlugin
+		}
+	}
+	return qemuTraceExecLog
+}
+
+// coverageCollector returns how executed blocks are collected: by the
+// drcov plugin when the plugin directory has it
+func (a *QEMUAdapter) coverageCollector() string {
+	if a.pluginDir != "" {
+		if _, err := os.Stat(filepath.Join(a.pluginDir, qemuDrcovPlugin)); err == nil {
+			return qemuCoveragePlugin
+		}
+	}
+	return qemuCoverageExecLog
+}
+
+// icountArgs returns the -icount option of a launch, if any. Deterministic
+// instances run at a fixed rate; recording and replaying require the
+// counter too and adapt the rate otherwise.
+func icountArgs(instance *QEMUInstance, options *StartOptions) ([]string, error) {
+	icount := ""
+	if instance.deterministic {
+		icount = qemuICount
+	}
+	if options != nil && (options.Record || options.Replay) {
+		if icount == "" {
+			icount = "shift=auto"
+		}
+		return recordReplayArgs(options, icount)
+	}
+	if icount == "" {
+		return nil, nil
+	}
+	return []string{"-icount", icount}, nil
+}
+
+// qemuShutdownTimeout bounds waiting for QEMU to exit on SIGTERM
+const qemuShutdownTimeout = 5 * time.Second
+
+// terminate stops the QEMU process of an instance. QEMU shuts down cleanly
+// on SIGTERM, which completes a recording; it is killed when it does not
+// exit in time.
+func (a *QEMUAdapter) terminate(instance *QEMUInstance) {
+	instance.debug.close()
+	// The monitor reads the accesses logged until QEMU exited
+	if memory := instance.memory; memory != nil {
+		instance.memory = nil
+		defer memory.close()
+	}
+	process := instance.Process
+	instance.Process = nil
+	if process == nil || process.Process == nil {
+		return
+	}
+	exited := make(chan struct{})
+	go func() {
+		process.Wait()
+		close(exited)
+	}()
+	if err := process.Process.Signal(syscall.SIGTERM); err != nil {
+		process.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(qemuShutdownTimeout):
+		process.Process.Kill()
+		<-exited
+	}
+}
+
+// qemuImg is the QEMU disk image tool
+var qemuImg = "qemu-img"
+
+// recordReplayArgs returns the arguments recording or replaying execution
+// in options.ReplayDir with the icount settings. -icount makes execution
+// deterministic, the event log holds the inputs from outside the guest,
+// and the snapshot taken at start lets a replay seek backwards for reverse
+// execution.
+func recordReplayArgs(options *StartOptions, icount string) ([]string, error) {
+	if options.ReplayDir == "" {
+		return nil, fmt.Errorf("recording directory required")
+	}
+	if options.Record && options.Replay {
+		return nil, fmt.Errorf("cannot record while replaying")
+	}
+	mode := "replay"
+	snapshots := filepath.Join(options.ReplayDir, ReplaySnapshotFile)
+	if options.Record {
+		mode = "record"
+		if err := os.MkdirAll(options.ReplayDir, 0755); err != nil {
+			return nil, err
+		}
+		if output, err := exec.Command(qemuImg, "create", "-f", "qcow2", snapshots, "1G").CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to create snapshot image: %v: %s", err, output)
+		}
+	}
+	return []string{
+		"-icount", fmt.Sprintf("%s,rr=%s,rrfile=%s,rrsnapshot=init", icount, mode, filepath.Join(options.ReplayDir, ReplayLogFile)),
+		"-drive", fmt.Sprintf("file=%s,if=none,id=rr", snapshots),
+	}, nil
+}
+
 // Helper function to build QEMU command line arguments
 func (a *QEMUAdapter) buildQEMUArgs(instance *QEMUInstance) []string {
 	args := []string{
 		"-machine", "virt",
 		"-nographic",
 		"-gdb", fmt.Sprintf("tcp::%d", instance.GDBPort),
-		"-serial", fmt.Sprintf("tcp::%d,server,nowait", instance.ConsolePort),
+		"-chardev", fmt.Sprintf("socket,id=console,port=%d,server=on,wait=off,logfile=%s", instance.ConsolePort, filepath.Join(a.workDir, instance.ID, qemuConsoleLog)),
+		"-serial", "chardev:console",
 		"-monitor", fmt.Sprintf("tcp::%d,server,nowait", instance.MonitorPort),
 	}
 	
@@ -304,12 +817,67 @@ func (a *QEMUAdapter) buildQEMUArgs(instance *QEMUInstance) []string {
 	return args
 }
 
+// deviceTreePath returns the DTB to pass with -dtb, generating one from the
+// board config for machines that boot from a device tree. It returns an
+// empty path when no DTB applies.
+func (a *QEMUAdapter) deviceTreePath(instance *QEMUInstance) (string, error) {
+	config := instance.Config
+	if config == nil || len(config.Nodes) == 0 || !SupportsDeviceTree(config.Nodes[0].Processor) {
+		return "", nil
+	}
+	if config.Boot != nil && config.Boot.DTB != "" {
+		if config.Boot.DTB == "none" {
+			return "", nil
+		}
+		return config.Boot.DTB, nil
+	}
+	
+	tree, err := GenerateDeviceTree(config, &config.Nodes[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to generate device tree: %w", err)
+	}
+	
+	dir := filepath.Join(a.workDir, instance.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "board.dtb")
+	if err := os.WriteFile(path, devicetree.Encode(tree), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// qemuBinary selects the system emulator for the first node's processor
+func qemuBinary(config *BoardConfig) string {
+	if config == nil || len(config.Nodes) == 0 || config.Nodes[0].Processor == nil {
+		return "qemu-system-arm"
+	}
+	processor := config.Nodes[0].Processor.Type
+	switch {
+	case strings.Contains(processor, "RV64"):
+		return "qemu-system-riscv64"
+	case strings.Contains(processor, "RV32"):
+		return "qemu-system-riscv32"
+	case strings.Contains(processor, "A53"), strings.Contains(processor, "A72"):
+		return "qemu-system-aarch64"
+	case processor == "x86_64":
+		return "qemu-system-x86_64"
+	case processor == "x86":
+		return "qemu-system-i386"
+	}
+	return "qemu-system-arm"
+}
+
 // Helper function to map processor type to QEMU CPU type
 func getCPUType(procType string) string {
 	mapping := map[string]string{
 		"ARM Cortex-M3": "cortex-m3",
 		"ARM Cortex-M4": "cortex-m4",
+		"ARM Cortex-M7": "cortex-m7",
 		"ARM Cortex-A9": "cortex-a9",
+		"ARM Cortex-A53": "cortex-a53",
+		"ARM Cortex-A72": "cortex-a72",
 		"RISC-V RV32":   "rv32",
 		"RISC-V RV64":   "rv64",
 	}
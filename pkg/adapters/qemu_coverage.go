@@ -0,0 +1,97 @@
+package adapters
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/forfire912/virServer/pkg/coverage"
+)
+
+var (
+	// execTrace matches a block execution of the exec log, whose second
+	// bracketed field is the guest pc in all QEMU releases
+	execTrace = regexp.MustCompile(`^Trace \d+: 0x[0-9a-f]+ \[[0-9a-f]+/([0-9a-f]+)/`)
+	// asmInstruction matches an instruction of a block of the in_asm log
+	asmInstruction = regexp.MustCompile(`^0x([0-9a-f]+):`)
+)
+
+// parseExecLog returns the executed blocks of a QEMU log with the in_asm,
+// exec and nochain items. The in_asm log gives the instructions of each
+// translated block, so a block spans up to the start of its last
+// instruction; blocks executed without one logged have a size of 1.
+func parseExecLog(r io.Reader) ([]coverage.Block, error) {
+	sizes := make(map[uint64]uint32)  // Of the translated blocks by start
+	executed := make(map[uint64]bool) // Starts of the executed blocks
+	var start, last uint64
+	inBlock := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := asmInstruction.FindStringSubmatch(line); m != nil {
+			address, err := strconv.ParseUint(m[1], 16, 64)
+			if err != nil {
+				continue
+			}
+			if !inBlock {
+				start, inBlock = address, true
+			}
+			last = address
+			continue
+		}
+		if inBlock {
+			if size := uint32(last - start + 1); size > sizes[start] {
+				sizes[start] = size
+			}
+			inBlock = false
+		}
+		if m := execTrace.FindStringSubmatch(line); m != nil {
+			if pc, err := strconv.ParseUint(m[1], 16, 64); err == nil {
+				executed[pc] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read exec log: %w", err)
+	}
+	if inBlock && uint32(last-start+1) > sizes[start] {
+		sizes[start] = uint32(last - start + 1)
+	}
+
+	blocks := make([]coverage.Block, 0, len(executed))
+	for pc := range executed {
+		size := sizes[pc]
+		if size == 0 {
+			size = 1
+		}
+		blocks = append(blocks, coverage.Block{Start: pc, Size: size})
+	}
+	return blocks, nil
+}
+
+// convertExecLog writes the executed blocks of a QEMU log as a DrCov file
+func convertExecLog(logPath, drcovPath string) error {
+	log, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("read exec log: %w", err)
+	}
+	defer log.Close()
+	blocks, err := parseExecLog(log)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(drcovPath)
+	if err != nil {
+		return err
+	}
+	if err := coverage.WriteDrcov(out, "qemu", blocks); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,181 @@
+package adapters
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// qemuLogPoll is how often the QEMU log is read for new memory accesses
+const qemuLogPoll = 50 * time.Millisecond
+
+var (
+	// execlogLine splits an instruction logged by the execlog plugin into
+	// its pc, its disassembly and the memory accesses following it
+	execlogLine = regexp.MustCompile(`^\d+, 0x([0-9a-f]+), 0x[0-9a-f]+, "([^"]*)"(.*)$`)
+	// execlogAccess matches a memory access of an execlog instruction
+	execlogAccess = regexp.MustCompile(`, (load|store), 0x([0-9a-f]+)`)
+)
+
+// armConditions are the condition suffixes of ARM mnemonics
+var armConditions = []string{"eq", "ne", "cs", "hs", "cc", "lo", "mi", "pl", "vs", "vc", "hi", "ls", "ge", "lt", "gt", "le", "al"}
+
+// armDoublewords are the ARM loads and stores of a register pair
+var armDoublewords = map[string]bool{
+	"ldrd": true, "strd": true, "ldrexd": true, "strexd": true, "ldaexd": true, "stlexd": true,
+}
+
+// riscvAccessSizes are the access sizes of the RISC-V loads and stores
+var riscvAccessSizes = map[string]int{
+	"lb": 1, "lbu": 1, "sb": 1,
+	"lh": 2, "lhu": 2, "sh": 2,
+	"lw": 4, "lwu": 4, "sw": 4, "flw": 4, "fsw": 4, "lwsp": 4, "swsp": 4, "flwsp": 4, "fswsp": 4,
+	"ld": 8, "sd": 8, "fld": 8, "fsd": 8, "ldsp": 8, "sdsp": 8, "fldsp": 8, "fsdsp": 8,
+}
+
+// parseExeclogAccesses returns the memory accesses of a line of the execlog
+// plugin, if any
+func parseExeclogAccesses(line string) []MemoryAccess {
+	m := execlogLine.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	pc, err := strconv.ParseUint(m[1], 16, 64)
+	if err != nil {
+		return nil
+	}
+	var accesses []MemoryAccess
+	for _, a := range execlogAccess.FindAllStringSubmatch(m[3], -1) {
+		address, err := strconv.ParseUint(a[2], 16, 64)
+		if err != nil {
+			continue
+		}
+		accesses = append(accesses, MemoryAccess{PC: pc, Address: address, Size: accessSize(m[2]), Write: a[1] == "store"})
+	}
+	return accesses
+}
+
+// accessSize infers the size of each memory access of an instruction from
+// its disassembly, as the execlog plugin does not log it. 0 when unknown.
+func accessSize(disassembly string) int {
+	fields := strings.Fields(strings.ToLower(disassembly))
+	if len(fields) == 0 {
+		return 0
+	}
+	mnemonic := strings.TrimPrefix(fields[0], "c.")
+	if size, ok := riscvAccessSizes[mnemonic]; ok {
+		return size
+	}
+	switch {
+	case strings.HasPrefix(mnemonic, "lr.") || strings.HasPrefix(mnemonic, "sc.") || strings.HasPrefix(mnemonic, "amo"):
+		if strings.Contains(mnemonic, ".d") {
+			return 8
+		}
+		return 4
+	case strings.HasPrefix(mnemonic, "ld") || strings.HasPrefix(mnemonic, "st"):
+	case mnemonic == "push" || mnemonic == "pop":
+		return 4
+	default:
+		return 0
+	}
+
+	// ARM and AArch64: ldr, strb, ldrsh, ldmia, ldrhi, ldr.w...
+	if i := strings.IndexByte(mnemonic, '.'); i >= 0 {
+		mnemonic = mnemonic[:i]
+	}
+	for _, cond := range armConditions {
+		if len(mnemonic) > 3+len(cond) && strings.HasSuffix(mnemonic, cond) {
+			mnemonic = strings.TrimSuffix(mnemonic, cond)
+			break
+		}
+	}
+	switch {
+	case strings.HasPrefix(mnemonic, "ldm") || strings.HasPrefix(mnemonic, "stm"):
+		return 4
+	case armDoublewords[mnemonic]:
+		return 8
+	case strings.HasSuffix(mnemonic, "b"):
+		return 1
+	case strings.HasSuffix(mnemonic, "h"):
+		return 2
+	case strings.HasSuffix(mnemonic, "sw"):
+		return 4
+	}
+	// AArch64 loads and stores of X registers access 8 bytes
+	if len(fields) > 1 && strings.HasPrefix(fields[1], "x") {
+		return 8
+	}
+	return 4
+}
+
+// memoryMonitor follows the memory accesses of a QEMU launch
+type memoryMonitor struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// monitorMemory reports the accesses the execlog plugin writes to the QEMU
+// log at path as QEMU runs, until the monitor is stopped
+func monitorMemory(path string, handler func(MemoryAccess)) *memoryMonitor {
+	m := &memoryMonitor{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		followAccessLog(path, m.stop, handler)
+	}()
+	return m
+}
+
+// close stops the monitor once it has read the rest of the log
+func (m *memoryMonitor) close() {
+	close(m.stop)
+	<-m.done
+}
+
+// followAccessLog reads the QEMU log at path as it grows until stop is
+// closed, and then to its end
+func followAccessLog(path string, stop <-chan struct{}, handler func(MemoryAccess)) {
+	stopped := false
+	wait := func() {
+		select {
+		case <-stop:
+			stopped = true
+		case <-time.After(qemuLogPoll):
+		}
+	}
+
+	// QEMU creates the log once it starts
+	var f *os.File
+	for {
+		var err error
+		if f, err = os.Open(path); err == nil {
+			break
+		}
+		if stopped {
+			return
+		}
+		wait()
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var line string
+	for {
+		s, err := r.ReadString('\n')
+		line += s
+		if err == nil {
+			for _, access := range parseExeclogAccesses(strings.TrimSuffix(line, "\n")) {
+				handler(access)
+			}
+			line = ""
+			continue
+		}
+		if err != io.EOF || stopped {
+			return
+		}
+		wait()
+	}
+}
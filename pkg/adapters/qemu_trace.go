@@ -0,0 +1,132 @@
+package adapters
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/forfire912/virServer/pkg/trace"
+)
+
+var (
+	// execlogInstruction matches an instruction logged by the execlog
+	// plugin: cpu, pc, opcode and disassembly
+	execlogInstruction = regexp.MustCompile(`^\d+, 0x([0-9a-f]+), 0x[0-9a-f]+, "`)
+	// armException matches an exception of the int log of ARM cores
+	armException = regexp.MustCompile(`^Taking exception (\d+) \[([^\]]+)\]`)
+	// armVector follows armException on M-profile cores with the vector
+	armVector = regexp.MustCompile(`^\.\.\.taking pending (?:non)?secure exception (\d+)`)
+	// riscvException matches a trap of the int log of RISC-V cores
+	riscvException = regexp.MustCompile(`^riscv_cpu_do_interrupt: hart:\d+, async:\d, cause:([0-9a-f]+),.*desc=(\w+)`)
+)
+
+// parseTraceLog passes the execution records of a QEMU log with the int
+// item, and either the output of the execlog plugin or the in_asm, exec and
+// nochain items, to fn in order. Instructions of the execlog plugin that
+// follow each other are merged into blocks; blocks of the exec log are as
+// long as the in_asm log translated them.
+func parseTraceLog(r io.Reader, fn func(trace.Record) error) error {
+	counts := make(map[uint64]uint32) // Instructions of the translated blocks by start
+	var start uint64
+	var count uint32 // Of the in_asm block being read
+	var last uint64  // Address of the last execlog instruction
+	// The last record is held back while later lines can still extend it;
+	// its Kind is 0 before the first one
+	var pending trace.Record
+	emit := func(record trace.Record) error {
+		var err error
+		if pending.Kind != 0 {
+			err = fn(pending)
+		}
+		pending = record
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := asmInstruction.FindStringSubmatch(line); m != nil {
+			if address, err := strconv.ParseUint(m[1], 16, 64); err == nil {
+				if count == 0 {
+					start = address
+				}
+				count++
+			}
+			continue
+		}
+		if count > 0 {
+			counts[start] = count
+			count = 0
+		}
+
+		var err error
+		if m := execTrace.FindStringSubmatch(line); m != nil {
+			pc, perr := strconv.ParseUint(m[1], 16, 64)
+			if perr != nil {
+				continue
+			}
+			n := counts[pc]
+			if n == 0 {
+				n = 1
+			}
+			err = emit(trace.Record{Kind: trace.BlockRecord, PC: pc, Instructions: n})
+		} else if m := execlogInstruction.FindStringSubmatch(line); m != nil {
+			pc, perr := strconv.ParseUint(m[1], 16, 64)
+			if perr != nil {
+				continue
+			}
+			if pending.Kind == trace.BlockRecord && (pc == last+2 || pc == last+4) {
+				pending.Instructions++
+			} else {
+				err = emit(trace.Record{Kind: trace.BlockRecord, PC: pc, Instructions: 1})
+			}
+			last = pc
+		} else if m := armException.FindStringSubmatch(line); m != nil {
+			number, _ := strconv.Atoi(m[1])
+			err = emit(trace.Record{Kind: trace.ExceptionRecord, Number: number, Name: m[2]})
+		} else if m := armVector.FindStringSubmatch(line); m != nil {
+			if pending.Kind == trace.ExceptionRecord {
+				pending.Number, _ = strconv.Atoi(m[1])
+			}
+		} else if m := riscvException.FindStringSubmatch(line); m != nil {
+			cause, _ := strconv.ParseUint(m[1], 16, 32)
+			err = emit(trace.Record{Kind: trace.ExceptionRecord, Number: int(cause), Name: m[2]})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read trace log: %w", err)
+	}
+	return emit(trace.Record{})
+}
+
+// convertTraceLog writes the execution records of a QEMU log to a file as
+// it reads the log
+func convertTraceLog(logPath, tracePath string) error {
+	log, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("read trace log: %w", err)
+	}
+	defer log.Close()
+
+	out, err := os.Create(tracePath)
+	if err != nil {
+		return err
+	}
+	w := trace.NewRecordWriter(out)
+	if err := parseTraceLog(log, w.Write); err != nil {
+		out.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
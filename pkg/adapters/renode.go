@@ -4,7 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // RenodeAdapter implements BackendAdapter for Renode
@@ -20,10 +26,26 @@ type RenodeInstance struct {
 	SessionID string
 	Config    *BoardConfig
 	Port      int
+	Process   *exec.Cmd
 	Running   bool
 	Programs  map[string]*ProgramInfo
+	debug     *gdbTarget
+	
+	deterministic bool
+	seed          uint64
+	instructions  uint64 // Executed by the last launch, read at power off
+	counted       bool
 }
 
+// Settings of deterministic Renode instances
+const (
+	renodeQuantum        = "0.00001" // Seconds of virtual time the cores run between synchronizations
+	renodeMonitorTimeout = 5 * time.Second
+)
+
+// renodeConsoleLog is the file the console UART writes to
+const renodeConsoleLog = "console.log"
+
 // NewRenodeAdapter creates a new Renode adapter
 func NewRenodeAdapter(workDir string) *RenodeAdapter {
 	return &RenodeAdapter{
@@ -45,6 +67,11 @@ func (a *RenodeAdapter) CreateInstance(ctx context.Context, sessionID string, co
 		Programs:  make(map[string]*ProgramInfo),
 		Port:      allocatePort(),
 	}
+	if resources != nil {
+		instance.deterministic = resources.Deterministic
+		instance.seed = resources.Seed
+	}
+	instance.debug = newGDBTarget(fmt.Sprintf("127.0.0.1:%d", instance.Port), config)
 	
 	a.instances[instanceID] = instance
 	return instanceID, nil
@@ -54,18 +81,136 @@ func (a *RenodeAdapter) CreateInstance(ctx context.Context, sessionID string, co
 func (a *RenodeAdapter) DestroyInstance(ctx context.Context, instanceID string) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	
+	if instance, exists := a.instances[instanceID]; exists {
+		instance.debug.close()
+		if instance.Process != nil {
+			instance.Process.Process.Kill()
+		}
+	}
+	
 	delete(a.instances, instanceID)
 	return nil
 }
 
 // PowerOn starts the Renode instance
 func (a *RenodeAdapter) PowerOn(ctx context.Context, instanceID string) error {
-	return fmt.Errorf("not implemented")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return fmt.Errorf("instance not found: %s", instanceID)
+	}
+	
+	if instance.Running {
+		return fmt.Errorf("instance already running")
+	}
+	
+	script, err := a.writePlatform(instance)
+	if err != nil {
+		return err
+	}
+	
+	instance.Process = exec.Command("renode", "--disable-xwt", "--console", script)
+	if err := instance.Process.Start(); err != nil {
+		return fmt.Errorf("failed to start Renode: %w", err)
+	}
+	
+	instance.counted = false
+	instance.Running = true
+	return nil
 }
 
 // PowerOff stops the Renode instance
 func (a *RenodeAdapter) PowerOff(ctx context.Context, instanceID string) error {
-	return fmt.Errorf("not implemented")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return fmt.Errorf("instance not found: %s", instanceID)
+	}
+	
+	if instance.Running && instance.deterministic {
+		instance.instructions, instance.counted = a.countInstructions(ctx, instance)
+	}
+	instance.debug.close()
+	if instance.Process != nil {
+		instance.Process.Process.Kill()
+	}
+	
+	instance.Running = false
+	return nil
+}
+
+// countInstructions asks the monitor how many instructions the first core
+// executed. The target must be halted.
+func (a *RenodeAdapter) countInstructions(ctx context.Context, instance *RenodeInstance) (uint64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, renodeMonitorTimeout)
+	defer cancel()
+	output, err := instance.debug.monitor(ctx, "sysbus.cpu ExecutedInstructions")
+	if err != nil {
+		return 0, false
+	}
+	count, err := strconv.ParseUint(strings.TrimSpace(output), 0, 64)
+	return count, err == nil
+}
+
+// writePlatform writes the .repl platform generated from the board config and
+// the .resc script that loads it, returning the script path
+func (a *RenodeAdapter) writePlatform(instance *RenodeInstance) (string, error) {
+	if instance.Config == nil || len(instance.Config.Nodes) == 0 {
+		return "", fmt.Errorf("instance %s has no board configuration", instance.ID)
+	}
+	
+	repl, err := GenerateREPL(&instance.Config.Nodes[0])
+	if err != nil {
+		return "", err
+	}
+	
+	dir := filepath.Join(a.workDir, instance.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	
+	replPath := filepath.Join(dir, "platform.repl")
+	if err := os.WriteFile(replPath, []byte(repl), 0644); err != nil {
+		return "", err
+	}
+	
+	script := fmt.Sprintf("mach create %q\nmachine LoadPlatformDescription @%s\nmachine StartGdbServer %d\n",
+		instance.SessionID, replPath, instance.Port)
+	// The log of the last launch is replaced
+	os.Remove(filepath.Join(dir, renodeConsoleLog))
+	if console := consoleUART(&instance.Config.Nodes[0]); console != "" {
+		script += fmt.Sprintf("sysbus.%s CreateFileBackend @%s true\n", console, filepath.Join(dir, renodeConsoleLog))
+	}
+	if instance.deterministic {
+		script = renodeDeterministicScript(instance.seed) + script
+	}
+	scriptPath := filepath.Join(dir, "platform.resc")
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		return "", err
+	}
+	return scriptPath, nil
+}
+
+// renodeDeterministicScript fixes the seed of the random sources and runs
+// the cores one at a time with a fixed quantum, so that their interleaving
+// does not depend on the host
+func renodeDeterministicScript(seed uint64) string {
+	return fmt.Sprintf("emulation SetSeed %d\nemulation SetGlobalQuantum %q\nemulation SetGlobalSerialExecution true\n", seed, renodeQuantum)
+}
+
+// consoleUART returns the Renode name of the first UART of a node
+func consoleUART(node *NodeConfig) string {
+	for _, periph := range node.Peripherals {
+		if periph.Type == "UART" {
+			return replIdentifier(periph.Name)
+		}
+	}
+	return ""
 }
 
 // Reset resets the Renode instance
@@ -95,42 +240,113 @@ func (a *RenodeAdapter) StopProgram(ctx context.Context, instanceID string, prog
 
 // SetBreakpoint sets a breakpoint
 func (a *RenodeAdapter) SetBreakpoint(ctx context.Context, instanceID string, bp *Breakpoint) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.setBreakpoint(ctx, bp)
 }
 
 // RemoveBreakpoint removes a breakpoint
 func (a *RenodeAdapter) RemoveBreakpoint(ctx context.Context, instanceID string, bpID string) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.removeBreakpoint(ctx, bpID)
 }
 
 // StepInstruction steps one instruction
-func (a *RenodeAdapter) StepInstruction(ctx context.Context, instanceID string) error {
-	return fmt.Errorf("not implemented")
+func (a *RenodeAdapter) StepInstruction(ctx context.Context, instanceID string) (*StopEvent, error) {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.step(ctx)
 }
 
 // Continue continues execution
-func (a *RenodeAdapter) Continue(ctx context.Context, instanceID string) error {
-	return fmt.Errorf("not implemented")
+func (a *RenodeAdapter) Continue(ctx context.Context, instanceID string) (*StopEvent, error) {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.resume(ctx)
+}
+
+// ReverseStep is not supported: Renode does not record execution
+func (a *RenodeAdapter) ReverseStep(ctx context.Context, instanceID string) (*StopEvent, error) {
+	return nil, fmt.Errorf("reverse execution is not supported by Renode")
+}
+
+// ReverseContinue is not supported: Renode does not record execution
+func (a *RenodeAdapter) ReverseContinue(ctx context.Context, instanceID string) (*StopEvent, error) {
+	return nil, fmt.Errorf("reverse execution is not supported by Renode")
+}
+
+// WatchStops registers a handler for the stops of an instance. It stays
+// registered across power cycles.
+func (a *RenodeAdapter) WatchStops(ctx context.Context, instanceID string, handler func(*StopEvent)) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return fmt.Errorf("instance not found: %s", instanceID)
+	}
+	instance.debug.watchStops(handler)
+	return nil
 }
 
 // ReadRegisters reads registers
 func (a *RenodeAdapter) ReadRegisters(ctx context.Context, instanceID string, scope string) (map[string]interface{}, error) {
-	return nil, fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.readRegisters(ctx, scope)
 }
 
 // WriteRegister writes a register
 func (a *RenodeAdapter) WriteRegister(ctx context.Context, instanceID string, register string, value interface{}) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.writeRegister(ctx, register, value)
 }
 
 // ReadMemory reads memory
 func (a *RenodeAdapter) ReadMemory(ctx context.Context, instanceID string, address uint64, size uint32) ([]byte, error) {
-	return nil, fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return debug.readMemory(ctx, address, size)
 }
 
 // WriteMemory writes memory
 func (a *RenodeAdapter) WriteMemory(ctx context.Context, instanceID string, address uint64, data []byte) error {
-	return fmt.Errorf("not implemented")
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.writeMemory(ctx, address, data)
+}
+
+// debugTarget returns the gdbstub connection of a powered instance
+func (a *RenodeAdapter) debugTarget(instanceID string) (*gdbTarget, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return nil, fmt.Errorf("instance not found: %s", instanceID)
+	}
+	if !instance.Running {
+		return nil, fmt.Errorf("instance not running: %s", instanceID)
+	}
+	return instance.debug, nil
 }
 
 // CreateSnapshot creates a snapshot
@@ -153,6 +369,26 @@ func (a *RenodeAdapter) ExportTrace(ctx context.Context, instanceID string) (str
 	return "", fmt.Errorf("not implemented")
 }
 
+// InstructionCount returns the instructions the first core executed in the
+// last launch, which the monitor reports when a deterministic instance is
+// powered off halted
+func (a *RenodeAdapter) InstructionCount(ctx context.Context, instanceID string) (uint64, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return 0, fmt.Errorf("instance not found: %s", instanceID)
+	}
+	if !instance.deterministic {
+		return 0, fmt.Errorf("instructions are only counted for deterministic instances")
+	}
+	if instance.Running || !instance.counted {
+		return 0, fmt.Errorf("instruction count is known once the halted instance is powered off")
+	}
+	return instance.instructions, nil
+}
+
 // GetGDBServerAddress returns GDB server address
 func (a *RenodeAdapter) GetGDBServerAddress(ctx context.Context, instanceID string) (string, error) {
 	a.mu.RLock()
@@ -166,11 +402,37 @@ func (a *RenodeAdapter) GetGDBServerAddress(ctx context.Context, instanceID stri
 	return fmt.Sprintf("localhost:%d", instance.Port), nil
 }
 
+// ServeGDB serves a GDB client through the debug connection of a powered
+// instance, sharing it with the other debug methods
+func (a *RenodeAdapter) ServeGDB(ctx context.Context, instanceID string, conn io.ReadWriteCloser, readOnly bool) error {
+	debug, err := a.debugTarget(instanceID)
+	if err != nil {
+		return err
+	}
+	return debug.serve(ctx, conn, readOnly)
+}
+
 // GetConsoleStream returns console stream
 func (a *RenodeAdapter) GetConsoleStream(ctx context.Context, instanceID string) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("not implemented")
 }
 
+// ReadConsoleLog returns what the first UART wrote in the last launch
+func (a *RenodeAdapter) ReadConsoleLog(ctx context.Context, instanceID string) ([]byte, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	
+	instance, exists := a.instances[instanceID]
+	if !exists {
+		return nil, fmt.Errorf("instance not found: %s", instanceID)
+	}
+	data, err := os.ReadFile(filepath.Join(a.workDir, instance.ID, renodeConsoleLog))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
 // GetBackendType returns backend type
 func (a *RenodeAdapter) GetBackendType() BackendType {
 	return BackendRenode
@@ -195,6 +457,7 @@ func (a *RenodeAdapter) GetCapabilities() *BackendCapabilities {
 			"coverage":         false,
 			"multicore":        true,
 			"python_scripting": true,
+			"deterministic":    true,
 		},
 		Limits: map[string]int{
 			"max_cores":       8,
@@ -0,0 +1,985 @@
+package adapters
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// REPLPlatform is a parsed Renode platform description (.repl)
+type REPLPlatform struct {
+	Entries []*REPLEntry
+}
+
+// REPLEntry is a single "name: Class @ registration" declaration
+type REPLEntry struct {
+	Name          string
+	Class         string
+	Registrations []REPLRegistration
+	Attributes    []REPLAttribute
+	Connections   []REPLConnection
+}
+
+// REPLRegistration describes where an entry is attached, e.g. "sysbus 0x40000000"
+type REPLRegistration struct {
+	Bus     string
+	Address uint64
+	Size    uint64
+	// Raw holds the registration text when it is not a plain address or range
+	Raw string
+}
+
+// REPLAttribute is a constructor argument or property; Value is kept verbatim
+type REPLAttribute struct {
+	Key   string
+	Value string
+}
+
+// REPLConnection is a GPIO connection such as "-> nvic@37" or "0 -> gic@5"
+type REPLConnection struct {
+	Source string
+	Target string
+	Pins   []int
+}
+
+// REPLIncludeResolver loads a file referenced by a using directive
+type REPLIncludeResolver func(name string) (string, error)
+
+// maxREPLIncludeDepth bounds nested using directives
+const maxREPLIncludeDepth = 16
+
+// Entry returns the entry with the given name
+func (p *REPLPlatform) Entry(name string) *REPLEntry {
+	for _, entry := range p.Entries {
+		if entry.Name == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Attribute returns the verbatim value of an attribute
+func (e *REPLEntry) Attribute(key string) (string, bool) {
+	for _, attr := range e.Attributes {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func (e *REPLEntry) setAttribute(key, value string) {
+	for i := range e.Attributes {
+		if e.Attributes[i].Key == key {
+			e.Attributes[i].Value = value
+			return
+		}
+	}
+	e.Attributes = append(e.Attributes, REPLAttribute{Key: key, Value: value})
+}
+
+// sysbusAddress returns the first system bus registration
+func (e *REPLEntry) sysbusAddress() (REPLRegistration, bool) {
+	for _, reg := range e.Registrations {
+		if reg.Bus == "sysbus" && (reg.Raw == "" || reg.Address != 0) {
+			return reg, true
+		}
+	}
+	return REPLRegistration{}, false
+}
+
+// ParseREPL parses a Renode platform description. using "file" directives
+// are loaded through include, which may be nil when the source has none.
+// Entries declared again later, in the same file or after an include, update
+// the earlier declaration as Renode does.
+func ParseREPL(source string, include REPLIncludeResolver) (*REPLPlatform, error) {
+	platform := &REPLPlatform{}
+	if err := parseREPLInto(platform, source, "", include, 0); err != nil {
+		return nil, err
+	}
+	return platform, nil
+}
+
+func parseREPLInto(platform *REPLPlatform, source, prefix string, include REPLIncludeResolver, depth int) error {
+	lines := strings.Split(stripREPLComments(source), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return fmt.Errorf("repl line %d: unexpected indentation", i+1)
+		}
+
+		if strings.HasPrefix(line, "using ") {
+			if err := parseREPLUsing(platform, line, prefix, include, depth); err != nil {
+				return fmt.Errorf("repl line %d: %w", i+1, err)
+			}
+			continue
+		}
+
+		// Join continuation lines while braces or brackets are open
+		header := line
+		for !balanced(header) && i+1 < len(lines) {
+			i++
+			header += "\n" + lines[i]
+		}
+
+		// The body is every following indented line
+		var body []string
+		for i+1 < len(lines) {
+			next := strings.TrimRight(lines[i+1], " \t\r")
+			if next != "" && next[0] != ' ' && next[0] != '\t' {
+				break
+			}
+			i++
+			if next != "" {
+				body = append(body, next)
+			}
+		}
+
+		entry, err := parseREPLEntry(header, body, prefix)
+		if err != nil {
+			return fmt.Errorf("repl line %d: %w", i+1, err)
+		}
+		mergeREPLEntry(platform, entry)
+	}
+	return nil
+}
+
+// parseREPLUsing handles `using "file"` and `using "file" prefixed "p_"`
+func parseREPLUsing(platform *REPLPlatform, line, prefix string, include REPLIncludeResolver, depth int) error {
+	rest := strings.TrimSpace(strings.TrimPrefix(line, "using "))
+	if !strings.HasPrefix(rest, "\"") {
+		return nil // e.g. "using sysbus", which has no effect on the layout
+	}
+	name, rest, err := unquoteREPL(rest)
+	if err != nil {
+		return err
+	}
+	if rest = strings.TrimSpace(rest); strings.HasPrefix(rest, "prefixed") {
+		added, _, err := unquoteREPL(strings.TrimSpace(strings.TrimPrefix(rest, "prefixed")))
+		if err != nil {
+			return err
+		}
+		prefix += added
+	}
+
+	if include == nil {
+		return fmt.Errorf("cannot resolve include %q", name)
+	}
+	if depth >= maxREPLIncludeDepth {
+		return fmt.Errorf("includes nested deeper than %d", maxREPLIncludeDepth)
+	}
+	source, err := include(name)
+	if err != nil {
+		return fmt.Errorf("include %q: %w", name, err)
+	}
+	if err := parseREPLInto(platform, source, prefix, include, depth+1); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// parseREPLEntry parses "name: [Class] [@ registration] [{ inline body }]"
+// followed by its indented body lines
+func parseREPLEntry(header string, body []string, prefix string) (*REPLEntry, error) {
+	name, rest, ok := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t\"") {
+		return nil, fmt.Errorf("expected \"name:\" declaration, got %q", firstLine(header))
+	}
+	entry := &REPLEntry{Name: prefix + name}
+	rest = strings.TrimSpace(rest)
+
+	// Inline body in braces at the end of the header
+	if strings.HasSuffix(rest, "}") {
+		if open := matchingOpen(rest); open >= 0 && !strings.Contains(rest[:open], "new ") && !strings.HasSuffix(strings.TrimSpace(rest[:open]), "@") {
+			for _, item := range splitTopLevel(rest[open+1:len(rest)-1], ';') {
+				if item = strings.TrimSpace(item); item != "" {
+					body = append(body, "    "+item)
+				}
+			}
+			rest = strings.TrimSpace(rest[:open])
+		}
+	}
+
+	class, registration, hasRegistration := strings.Cut(rest, "@")
+	entry.Class = strings.TrimSpace(class)
+	if hasRegistration {
+		registrations, err := parseREPLRegistrations(strings.TrimSpace(registration))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		entry.Registrations = registrations
+	}
+
+	if err := parseREPLBody(entry, body, prefix); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return entry, nil
+}
+
+func parseREPLRegistrations(text string) ([]REPLRegistration, error) {
+	if text == "none" {
+		return nil, nil
+	}
+	if strings.HasPrefix(text, "{") && strings.HasSuffix(text, "}") {
+		var out []REPLRegistration
+		for _, item := range splitTopLevel(text[1:len(text)-1], ';') {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			reg, err := parseREPLRegistration(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, reg)
+		}
+		return out, nil
+	}
+	reg, err := parseREPLRegistration(text)
+	if err != nil {
+		return nil, err
+	}
+	return []REPLRegistration{reg}, nil
+}
+
+func parseREPLRegistration(text string) (REPLRegistration, error) {
+	bus, point, _ := strings.Cut(text, " ")
+	reg := REPLRegistration{Bus: bus}
+	point = strings.TrimSpace(point)
+
+	switch {
+	case point == "":
+	case strings.HasPrefix(point, "<") && strings.HasSuffix(point, ">"):
+		start, size, ok := strings.Cut(point[1:len(point)-1], ",")
+		address, err := parseREPLInt(start)
+		if err != nil || !ok {
+			return reg, fmt.Errorf("invalid range %q", point)
+		}
+		size = strings.TrimSpace(size)
+		if strings.HasPrefix(size, "+") {
+			reg.Size, err = parseREPLInt(size[1:])
+		} else {
+			var end uint64
+			end, err = parseREPLInt(size)
+			reg.Size = end - address + 1
+		}
+		if err != nil {
+			return reg, fmt.Errorf("invalid range %q", point)
+		}
+		reg.Address = address
+	case strings.HasPrefix(point, "new Bus.BusPointRegistration") || strings.HasPrefix(point, "new Bus.BusRangeRegistration"):
+		// Keep the text verbatim but expose the address when it is literal
+		reg.Raw = point
+		if open := strings.IndexByte(point, '{'); open >= 0 && strings.HasSuffix(point, "}") {
+			for _, item := range splitTopLevel(point[open+1:len(point)-1], ';') {
+				key, value, _ := strings.Cut(item, ":")
+				switch strings.TrimSpace(key) {
+				case "address":
+					reg.Address, _ = parseREPLInt(value)
+				case "size":
+					reg.Size, _ = parseREPLInt(value)
+				}
+			}
+		}
+	default:
+		address, err := parseREPLInt(point)
+		if err != nil {
+			reg.Raw = point
+			break
+		}
+		reg.Address = address
+	}
+	return reg, nil
+}
+
+// String formats the registration as it appears after '@'
+func (r REPLRegistration) String() string {
+	switch {
+	case r.Raw != "":
+		return r.Bus + " " + r.Raw
+	case r.Size > 0:
+		return fmt.Sprintf("%s <%#x, +%#x>", r.Bus, r.Address, r.Size)
+	case r.Address != 0:
+		return fmt.Sprintf("%s %#x", r.Bus, r.Address)
+	}
+	return r.Bus
+}
+
+// parseREPLBody parses indented attribute, init and connection lines
+func parseREPLBody(entry *REPLEntry, body []string, prefix string) error {
+	for i := 0; i < len(body); i++ {
+		line := strings.TrimSpace(body[i])
+
+		if strings.Contains(line, "->") && !strings.Contains(line, "\"") {
+			conns, err := parseREPLConnections(line, prefix)
+			if err != nil {
+				return err
+			}
+			entry.Connections = mergeREPLConnections(entry.Connections, conns)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("expected attribute or connection, got %q", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		// init blocks hold monitor commands on deeper indented lines
+		if key == "init" || key == "init add" || key == "reset" {
+			indent := indentation(body[i])
+			var block []string
+			if value != "" {
+				block = append(block, value)
+			}
+			for i+1 < len(body) && indentation(body[i+1]) > indent {
+				i++
+				block = append(block, strings.TrimSpace(body[i]))
+			}
+			entry.setAttribute(key, strings.Join(block, "\n"))
+			continue
+		}
+
+		for !balanced(value) && i+1 < len(body) {
+			i++
+			value += "\n" + body[i]
+		}
+		entry.setAttribute(key, value)
+	}
+	return nil
+}
+
+// parseREPLConnections parses "[src] -> target@pin" including the range and
+// list forms "[0-1] -> nvic@[5-6]"
+func parseREPLConnections(line, prefix string) ([]REPLConnection, error) {
+	source, destination, _ := strings.Cut(line, "->")
+	source = strings.TrimSpace(source)
+
+	var conns []REPLConnection
+	for _, target := range splitTopLevel(destination, '&') {
+		name, pinsText, ok := strings.Cut(strings.TrimSpace(target), "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid connection %q", line)
+		}
+		pins, err := parseREPLPins(pinsText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connection %q: %w", line, err)
+		}
+		conns = append(conns, REPLConnection{Source: source, Target: prefix + strings.TrimSpace(name), Pins: pins})
+	}
+	return conns, nil
+}
+
+func parseREPLPins(text string) ([]int, error) {
+	text = strings.Trim(strings.TrimSpace(text), "[]")
+	var pins []int
+	for _, item := range strings.Split(text, ",") {
+		item = strings.TrimSpace(item)
+		if start, end, ok := strings.Cut(item, "-"); ok {
+			from, err := parseREPLInt(start)
+			if err != nil {
+				return nil, err
+			}
+			to, err := parseREPLInt(end)
+			if err != nil || to < from {
+				return nil, fmt.Errorf("invalid pin range %q", item)
+			}
+			for pin := from; pin <= to; pin++ {
+				pins = append(pins, int(pin))
+			}
+			continue
+		}
+		pin, err := parseREPLInt(item)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, int(pin))
+	}
+	return pins, nil
+}
+
+// mergeREPLConnections replaces connections from the same source
+func mergeREPLConnections(existing, added []REPLConnection) []REPLConnection {
+	for _, conn := range added {
+		replaced := false
+		for i := range existing {
+			if existing[i].Source == conn.Source && existing[i].Target == conn.Target {
+				existing[i] = conn
+				replaced = true
+			}
+		}
+		if !replaced {
+			existing = append(existing, conn)
+		}
+	}
+	return existing
+}
+
+// mergeREPLEntry adds an entry or updates an earlier one with the same name
+func mergeREPLEntry(platform *REPLPlatform, entry *REPLEntry) {
+	existing := platform.Entry(entry.Name)
+	if existing == nil {
+		platform.Entries = append(platform.Entries, entry)
+		return
+	}
+	if entry.Class != "" {
+		existing.Class = entry.Class
+	}
+	if entry.Registrations != nil {
+		existing.Registrations = entry.Registrations
+	}
+	for _, attr := range entry.Attributes {
+		existing.setAttribute(attr.Key, attr.Value)
+	}
+	existing.Connections = mergeREPLConnections(existing.Connections, entry.Connections)
+}
+
+// stripREPLComments removes // and /* */ comments outside string literals
+func stripREPLComments(source string) string {
+	var out strings.Builder
+	inString := false
+	for i := 0; i < len(source); i++ {
+		c := source[i]
+		switch {
+		case inString:
+			if c == '\\' && i+1 < len(source) {
+				out.WriteByte(c)
+				i++
+				c = source[i]
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case strings.HasPrefix(source[i:], "//"):
+			end := strings.IndexByte(source[i:], '\n')
+			if end < 0 {
+				return out.String()
+			}
+			i += end - 1
+			continue
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				return out.String()
+			}
+			// Keep line numbering stable
+			out.WriteString(strings.Repeat("\n", strings.Count(source[i:i+2+end], "\n")))
+			i += end + 3
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// balanced reports whether all braces, brackets and quotes are closed
+func balanced(text string) bool {
+	depth := 0
+	inString := false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return depth <= 0 && !inString
+}
+
+// matchingOpen returns the index of the brace matching the final '}'
+func matchingOpen(text string) int {
+	depth := 0
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case '}':
+			depth++
+		case '{':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitTopLevel splits on sep outside braces, brackets and strings
+func splitTopLevel(text string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	inString := false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[' || c == '<':
+			depth++
+		case c == '}' || c == ']' || c == '>' && (i == 0 || text[i-1] != '-'):
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+func unquoteREPL(text string) (string, string, error) {
+	end := strings.IndexByte(text[1:], '"')
+	if !strings.HasPrefix(text, "\"") || end < 0 {
+		return "", "", fmt.Errorf("expected quoted string, got %q", text)
+	}
+	return text[1 : end+1], text[end+2:], nil
+}
+
+func parseREPLInt(text string) (uint64, error) {
+	text = strings.TrimSpace(text)
+	return strconv.ParseUint(strings.ReplaceAll(text, "_", ""), 0, 64)
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
+
+// Renode class namespaces mapped to peripheral types
+var replPeripheralNamespaces = []CompatibleMapping{
+	{"UART.", "UART"},
+	{"GPIOPort.", "GPIO"},
+	{"SPI.", "SPI"},
+	{"I2C.", "I2C"},
+	{"Timers.", "Timer"},
+	{"CAN.", "CAN"},
+	{"Network.", "Ethernet"},
+	{"USB.", "USB"},
+	{"Analog.", "ADC"},
+}
+
+// replDefaultClasses are the classes used when generating a .repl for a
+// peripheral without a renode_class property
+var replDefaultClasses = map[string]string{
+	"UART":     "UART.STM32_UART",
+	"GPIO":     "GPIOPort.STM32_GPIOPort",
+	"SPI":      "SPI.STM32SPI",
+	"I2C":      "I2C.STM32F4_I2C",
+	"Timer":    "Timers.STM32_Timer",
+	"CAN":      "CAN.STMCAN",
+	"Ethernet": "Network.SynopsysEthernetMAC",
+	"RTC":      "Timers.STM32F4_RTC",
+	"ADC":      "Analog.STM32_ADC",
+	"DAC":      "Analog.STM32_DAC",
+	"USB":      "USB.STM32F4_USB",
+}
+
+// Properties used to preserve Renode specific details across an import and
+// a later generation
+const (
+	replClassProperty        = "renode_class"
+	replAttributesProperty   = "renode_attributes"
+	replConnectionsProperty  = "renode_connections"
+	replRegistrationProperty = "renode_registration"
+)
+
+// replProcessor maps a CPU entry to a processor name
+func replProcessor(entry *REPLEntry) (string, bool) {
+	cpuType, _ := entry.Attribute("cpuType")
+	cpuType = strings.ToLower(strings.Trim(cpuType, "\""))
+	class := strings.ToLower(entry.Class)
+
+	if strings.Contains(class, "riscv") {
+		if strings.Contains(class, "64") || strings.HasPrefix(cpuType, "rv64") {
+			return "RISC-V RV64", true
+		}
+		return "RISC-V RV32", true
+	}
+	if name, ok := cpuCompatibles["arm,"+cpuType]; ok {
+		return name, true
+	}
+	return "", false
+}
+
+// isREPLInterruptController reports whether an entry is an interrupt
+// controller; connections to it are peripheral IRQ lines
+func isREPLInterruptController(entry *REPLEntry) bool {
+	return entry != nil && strings.HasPrefix(entry.Class, "IRQControllers.")
+}
+
+// ImportREPL converts a Renode platform into a single-node board
+// configuration targeting the Renode backend. Entries that cannot be
+// represented are listed in Unmapped with their class as compatible.
+func ImportREPL(platform *REPLPlatform, nodeID string) (*DeviceTreeImport, error) {
+	if platform == nil || len(platform.Entries) == 0 {
+		return nil, fmt.Errorf("empty platform description")
+	}
+	if nodeID == "" {
+		nodeID = "node0"
+	}
+
+	result := &DeviceTreeImport{Config: &BoardConfig{}, Unmapped: []UnmappedNode{}}
+	node := NodeConfig{ID: nodeID, Backend: BackendRenode}
+	unmapped := func(entry *REPLEntry, reason string) {
+		var classes []string
+		if entry.Class != "" {
+			classes = []string{entry.Class}
+		}
+		result.Unmapped = append(result.Unmapped, UnmappedNode{Path: entry.Name, Compatible: classes, Reason: reason})
+	}
+
+	for _, entry := range platform.Entries {
+		switch {
+		case entry.Class == "":
+			unmapped(entry, "entry updates an undeclared peripheral")
+
+		case strings.HasPrefix(entry.Class, "CPU."):
+			name, ok := replProcessor(entry)
+			if !ok {
+				unmapped(entry, "unknown processor")
+				continue
+			}
+			if node.Processor == nil {
+				node.Processor = &ProcessorConfig{Type: name}
+			}
+			node.Processor.Cores++
+
+		case isREPLInterruptController(entry):
+			unmapped(entry, "interrupt controllers are provided by the backend")
+
+		case entry.Class == "Memory.MappedMemory" || entry.Class == "Memory.ArrayMemory":
+			region, err := replMemory(entry)
+			if err != nil {
+				unmapped(entry, err.Error())
+				continue
+			}
+			node.Memory = append(node.Memory, region)
+
+		default:
+			periph, ok := replPeripheral(platform, entry)
+			if !ok {
+				unmapped(entry, "unknown class")
+				continue
+			}
+			node.Peripherals = append(node.Peripherals, periph)
+		}
+	}
+
+	sort.SliceStable(node.Memory, func(i, j int) bool { return node.Memory[i].Address < node.Memory[j].Address })
+	result.Config.Nodes = []NodeConfig{node}
+	return result, nil
+}
+
+func replMemory(entry *REPLEntry) (MemoryRegion, error) {
+	reg, ok := entry.sysbusAddress()
+	if !ok {
+		return MemoryRegion{}, fmt.Errorf("memory is not mapped on sysbus")
+	}
+	sizeText, ok := entry.Attribute("size")
+	if !ok {
+		return MemoryRegion{}, fmt.Errorf("memory without size")
+	}
+	size, err := parseREPLInt(sizeText)
+	if err != nil {
+		return MemoryRegion{}, fmt.Errorf("invalid memory size %q", sizeText)
+	}
+
+	memoryType := "RAM"
+	name := strings.ToLower(entry.Name)
+	switch {
+	case strings.Contains(name, "flash"):
+		memoryType = "Flash"
+	case strings.Contains(name, "rom"):
+		memoryType = "ROM"
+	}
+	return MemoryRegion{Type: memoryType, Address: reg.Address, Size: size, Access: accessFor(memoryType)}, nil
+}
+
+func replPeripheral(platform *REPLPlatform, entry *REPLEntry) (PeripheralConfig, bool) {
+	periphType := ""
+	for _, mapping := range replPeripheralNamespaces {
+		if strings.HasPrefix(entry.Class, mapping.Compatible) {
+			periphType = mapping.Type
+			break
+		}
+	}
+	switch {
+	case strings.Contains(entry.Class, "RTC"):
+		periphType = "RTC"
+	case strings.HasPrefix(entry.Class, "Analog.") && strings.Contains(entry.Class, "DAC"):
+		periphType = "DAC"
+	}
+	if periphType == "" {
+		return PeripheralConfig{}, false
+	}
+
+	periph := PeripheralConfig{
+		Type:       periphType,
+		Name:       entry.Name,
+		Properties: map[string]interface{}{replClassProperty: entry.Class},
+	}
+	if reg, ok := entry.sysbusAddress(); ok {
+		periph.Address = reg.Address
+		if reg.Size > 0 {
+			periph.Properties["size"] = reg.Size
+		}
+	} else if len(entry.Registrations) > 0 {
+		periph.Properties[replRegistrationProperty] = entry.Registrations[0].String()
+	}
+
+	var others []string
+	for _, conn := range entry.Connections {
+		if isREPLInterruptController(platform.Entry(conn.Target)) {
+			periph.IRQ = append(periph.IRQ, conn.Pins...)
+			continue
+		}
+		others = append(others, formatREPLConnection(conn))
+	}
+	if len(others) > 0 {
+		periph.Properties[replConnectionsProperty] = others
+	}
+
+	if len(entry.Attributes) > 0 {
+		attributes := make(map[string]interface{}, len(entry.Attributes))
+		for _, attr := range entry.Attributes {
+			attributes[attr.Key] = attr.Value
+		}
+		periph.Properties[replAttributesProperty] = attributes
+	}
+	return periph, true
+}
+
+func formatREPLConnection(conn REPLConnection) string {
+	pins := make([]string, len(conn.Pins))
+	for i, pin := range conn.Pins {
+		pins[i] = strconv.Itoa(pin)
+	}
+	target := conn.Target + "@" + strings.Join(pins, ",")
+	if len(pins) > 1 {
+		target = conn.Target + "@[" + strings.Join(pins, ", ") + "]"
+	}
+	if conn.Source == "" {
+		return "-> " + target
+	}
+	return conn.Source + " -> " + target
+}
+
+// replIdentifier turns a name into a valid Renode identifier
+func replIdentifier(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// replCPU describes the CPU and interrupt controller emitted for a processor
+type replCPU struct {
+	class      string
+	cpuType    string
+	controller string
+	entry      string
+}
+
+func replCPUFor(processor string) replCPU {
+	lower := strings.ToLower(processor)
+	switch {
+	case strings.Contains(lower, "cortex-m"):
+		return replCPU{
+			class:      "CPU.CortexM",
+			cpuType:    strings.TrimPrefix(lower, "arm "),
+			controller: "nvic",
+			entry:      "nvic: IRQControllers.NVIC @ sysbus 0xE000E000\n    -> cpu@0\n",
+		}
+	case strings.Contains(lower, "cortex-a53") || strings.Contains(lower, "cortex-a57") || strings.Contains(lower, "cortex-a72"):
+		return replCPU{
+			class:      "CPU.ARMv8A",
+			cpuType:    strings.TrimPrefix(lower, "arm "),
+			controller: "gic",
+			entry:      "gic: IRQControllers.ARM_GenericInterruptController @ sysbus 0x8000000\n    0 -> cpu@0\n",
+		}
+	case strings.Contains(lower, "cortex-a"):
+		return replCPU{
+			class:      "CPU.ARMv7A",
+			cpuType:    strings.TrimPrefix(lower, "arm "),
+			controller: "gic",
+			entry:      "gic: IRQControllers.ARM_GenericInterruptController @ sysbus 0xF8F01000\n    0 -> cpu@0\n",
+		}
+	case strings.Contains(lower, "rv64"):
+		return replCPU{
+			class:      "CPU.RiscV64",
+			cpuType:    "rv64gc",
+			controller: "plic",
+			entry:      "plic: IRQControllers.PlatformLevelInterruptController @ sysbus 0x0C000000\n    0 -> cpu@11\n    numberOfSources: 127\n",
+		}
+	default:
+		return replCPU{
+			class:      "CPU.RiscV32",
+			cpuType:    "rv32imac",
+			controller: "plic",
+			entry:      "plic: IRQControllers.PlatformLevelInterruptController @ sysbus 0x0C000000\n    0 -> cpu@11\n    numberOfSources: 127\n",
+		}
+	}
+}
+
+// GenerateREPL renders a node as a Renode platform description. Peripheral
+// classes come from the renode_class property, falling back to a default per
+// type; renode_attributes and renode_connections are emitted verbatim.
+func GenerateREPL(node *NodeConfig) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Generated by virServer for node %s\n\n", node.ID)
+
+	controller := ""
+	if node.Processor != nil {
+		cpu := replCPUFor(node.Processor.Type)
+		controller = cpu.controller
+		cores := node.Processor.Cores
+		if cores < 1 {
+			cores = 1
+		}
+		for i := 0; i < cores; i++ {
+			name := "cpu"
+			if i > 0 {
+				name = fmt.Sprintf("cpu%d", i)
+			}
+			fmt.Fprintf(&b, "%s: %s @ sysbus\n    cpuType: %q\n", name, cpu.class, cpu.cpuType)
+			if cpu.controller == "nvic" {
+				b.WriteString("    nvic: nvic\n")
+			}
+			if cores > 1 {
+				fmt.Fprintf(&b, "    cpuId: %d\n", i)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(cpu.entry)
+		b.WriteString("\n")
+	}
+
+	counts := make(map[string]int)
+	for _, mem := range node.Memory {
+		base := "sram"
+		switch mem.Type {
+		case "Flash":
+			base = "flash"
+		case "ROM":
+			base = "rom"
+		}
+		name := base
+		if counts[base] > 0 {
+			name = fmt.Sprintf("%s%d", base, counts[base])
+		}
+		counts[base]++
+		fmt.Fprintf(&b, "%s: Memory.MappedMemory @ sysbus %#x\n    size: %#x\n\n", name, mem.Address, mem.Size)
+	}
+
+	for _, periph := range node.Peripherals {
+		class, _ := periph.Properties[replClassProperty].(string)
+		if class == "" {
+			class = replDefaultClasses[periph.Type]
+		}
+		if class == "" {
+			return "", fmt.Errorf("peripheral %s: no Renode model for type %s", periph.Name, periph.Type)
+		}
+
+		registration := fmt.Sprintf("sysbus %#x", periph.Address)
+		if raw, ok := periph.Properties[replRegistrationProperty].(string); ok && raw != "" {
+			registration = raw
+		} else if size, ok := propertyUint(periph.Properties["size"]); ok && size > 0 {
+			registration = fmt.Sprintf("sysbus <%#x, +%#x>", periph.Address, size)
+		}
+		fmt.Fprintf(&b, "%s: %s @ %s\n", replIdentifier(periph.Name), class, registration)
+
+		if attributes, ok := periph.Properties[replAttributesProperty].(map[string]interface{}); ok {
+			keys := make([]string, 0, len(attributes))
+			for key := range attributes {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				value := fmt.Sprint(attributes[key])
+				if strings.Contains(value, "\n") && (key == "init" || key == "init add" || key == "reset") {
+					value = "\n        " + strings.ReplaceAll(value, "\n", "\n        ")
+				}
+				fmt.Fprintf(&b, "    %s: %s\n", key, value)
+			}
+		}
+
+		if len(periph.IRQ) > 0 {
+			if controller == "" {
+				return "", fmt.Errorf("peripheral %s: IRQs require a processor", periph.Name)
+			}
+			for i, irq := range periph.IRQ {
+				if i == 0 {
+					fmt.Fprintf(&b, "    -> %s@%d\n", controller, irq)
+				} else {
+					fmt.Fprintf(&b, "    %d -> %s@%d\n", i, controller, irq)
+				}
+			}
+		}
+		if connections, ok := periph.Properties[replConnectionsProperty].([]interface{}); ok {
+			for _, conn := range connections {
+				fmt.Fprintf(&b, "    %v\n", conn)
+			}
+		} else if connections, ok := periph.Properties[replConnectionsProperty].([]string); ok {
+			for _, conn := range connections {
+				fmt.Fprintf(&b, "    %s\n", conn)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// propertyUint reads an integer property that may have been decoded from
+// JSON as a float
+func propertyUint(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint64:
+		return v, true
+	case int:
+		return uint64(v), v >= 0
+	case float64:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
+
+// REPLIncludeFiles resolves includes from a set of named files, matching the
+// full path first and then the base name
+func REPLIncludeFiles(files map[string]string) REPLIncludeResolver {
+	return func(name string) (string, error) {
+		if source, ok := files[name]; ok {
+			return source, nil
+		}
+		for fileName, source := range files {
+			if path.Base(fileName) == path.Base(name) {
+				return source, nil
+			}
+		}
+		return "", fmt.Errorf("file not provided")
+	}
+}
@@ -0,0 +1,153 @@
+package adapters
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestREPL_RoundTrip(t *testing.T) {
+	config := loadExampleConfig(t, "stm32f4-disco.json", FormatJSON)
+	node := &config.Nodes[0]
+
+	repl, err := GenerateREPL(node)
+	if err != nil {
+		t.Fatalf("GenerateREPL: %v", err)
+	}
+	platform, err := ParseREPL(repl, nil)
+	if err != nil {
+		t.Fatalf("ParseREPL: %v\n%s", err, repl)
+	}
+	result, err := ImportREPL(platform, node.ID)
+	if err != nil {
+		t.Fatalf("ImportREPL: %v", err)
+	}
+	imported := result.Config.Nodes[0]
+
+	if imported.Processor == nil || imported.Processor.Type != node.Processor.Type || imported.Processor.Cores != node.Processor.Cores {
+		t.Errorf("processor %+v, want %+v", imported.Processor, node.Processor)
+	}
+	if len(imported.Memory) != len(node.Memory) {
+		t.Fatalf("memory %+v, want %+v", imported.Memory, node.Memory)
+	}
+	for i, mem := range node.Memory {
+		got := imported.Memory[i]
+		if got.Type != mem.Type || got.Address != mem.Address || got.Size != mem.Size {
+			t.Errorf("memory[%d] = %+v, want %+v", i, got, mem)
+		}
+	}
+	if len(imported.Peripherals) != len(node.Peripherals) {
+		t.Fatalf("peripherals %+v, want %+v", imported.Peripherals, node.Peripherals)
+	}
+	for i, periph := range node.Peripherals {
+		got := imported.Peripherals[i]
+		if got.Type != periph.Type || got.Name != periph.Name || got.Address != periph.Address || !reflect.DeepEqual(got.IRQ, periph.IRQ) {
+			t.Errorf("peripheral[%d] = %+v, want %+v", i, got, periph)
+		}
+	}
+	if len(result.Unmapped) != 1 || result.Unmapped[0].Path != "nvic" {
+		t.Errorf("unexpected unmapped entries %+v", result.Unmapped)
+	}
+
+	// A second generation from the imported node is stable
+	again, err := GenerateREPL(&imported)
+	if err != nil {
+		t.Fatalf("GenerateREPL: %v", err)
+	}
+	if again != repl {
+		t.Errorf("regenerated platform differs:\n%s\nwant:\n%s", again, repl)
+	}
+}
+
+const replCortexM4 = `
+cpu: CPU.CortexM @ sysbus
+    cpuType: "cortex-m4"
+    nvic: nvic
+
+nvic: IRQControllers.NVIC @ sysbus 0xE000E000
+    priorityMask: 0xF0
+    -> cpu@0
+`
+
+const replBoard = `// Board file
+using "platforms/cpus/cortex-m4.repl"
+
+flash: Memory.MappedMemory @ sysbus 0x08000000
+    size: 0x100000
+
+/* on-chip SRAM */
+sram: Memory.MappedMemory @ sysbus 0x20000000 { size: 0x20000 }
+
+usart1: UART.STM32_UART @ sysbus <0x40011000, +0x400>
+    frequency: 84000000
+    -> nvic@37
+
+gpioPortA: GPIOPort.STM32_GPIOPort @ sysbus 0x40020000
+    numberOfAFs: 16
+    [0-1] -> nvic@[6-7]
+    init:
+        Tag <0x40020010 4> "IDR"
+
+usart1:
+    -> nvic@38
+
+led: Miscellaneous.LED @ gpioPortA 5
+
+ext: Peripherals.Unknown @ sysbus 0x60000000
+`
+
+func TestParseREPL(t *testing.T) {
+	include := REPLIncludeFiles(map[string]string{"cortex-m4.repl": replCortexM4})
+	platform, err := ParseREPL(replBoard, include)
+	if err != nil {
+		t.Fatalf("ParseREPL: %v", err)
+	}
+
+	usart := platform.Entry("usart1")
+	if usart == nil || len(usart.Registrations) != 1 || usart.Registrations[0].Size != 0x400 {
+		t.Fatalf("unexpected usart1 %+v", usart)
+	}
+	if len(usart.Connections) != 1 || !reflect.DeepEqual(usart.Connections[0].Pins, []int{38}) {
+		t.Errorf("later declaration did not update usart1 connections: %+v", usart.Connections)
+	}
+	if init, _ := platform.Entry("gpioPortA").Attribute("init"); init != `Tag <0x40020010 4> "IDR"` {
+		t.Errorf("init block %q", init)
+	}
+
+	result, err := ImportREPL(platform, "mcu")
+	if err != nil {
+		t.Fatalf("ImportREPL: %v", err)
+	}
+	node := result.Config.Nodes[0]
+	if node.Backend != BackendRenode || node.Processor == nil || node.Processor.Type != "ARM Cortex-M4" {
+		t.Errorf("unexpected node %+v", node)
+	}
+	if len(node.Memory) != 2 || node.Memory[0].Type != "Flash" || node.Memory[1].Size != 0x20000 {
+		t.Errorf("unexpected memory %+v", node.Memory)
+	}
+	if len(node.Peripherals) != 2 {
+		t.Fatalf("unexpected peripherals %+v", node.Peripherals)
+	}
+	if gpio := node.Peripherals[1]; gpio.Type != "GPIO" || !reflect.DeepEqual(gpio.IRQ, []int{6, 7}) {
+		t.Errorf("unexpected gpio %+v", gpio)
+	}
+
+	unmapped := make(map[string]string)
+	for _, entry := range result.Unmapped {
+		unmapped[entry.Path] = entry.Reason
+	}
+	if unmapped["led"] != "unknown class" || unmapped["ext"] != "unknown class" || unmapped["nvic"] == "" {
+		t.Errorf("unexpected unmapped entries %+v", result.Unmapped)
+	}
+}
+
+func TestParseREPLErrors(t *testing.T) {
+	for name, source := range map[string]string{
+		"missing include": `using "other.repl"`,
+		"bad indentation": "    size: 0x100",
+		"bad connection":  "uart: UART.PL011 @ sysbus 0x1000\n    -> nvic",
+	} {
+		if _, err := ParseREPL(source, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -104,12 +104,27 @@ func (a *SkyEyeAdapter) RemoveBreakpoint(ctx context.Context, instanceID string,
 }
 
 // StepInstruction steps one instruction
-func (a *SkyEyeAdapter) StepInstruction(ctx context.Context, instanceID string) error {
-	return fmt.Errorf("not implemented")
+func (a *SkyEyeAdapter) StepInstruction(ctx context.Context, instanceID string) (*StopEvent, error) {
+	return nil, fmt.Errorf("not implemented")
 }
 
 // Continue continues execution
-func (a *SkyEyeAdapter) Continue(ctx context.Context, instanceID string) error {
+func (a *SkyEyeAdapter) Continue(ctx context.Context, instanceID string) (*StopEvent, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// ReverseStep steps one instruction backwards
+func (a *SkyEyeAdapter) ReverseStep(ctx context.Context, instanceID string) (*StopEvent, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// ReverseContinue continues execution backwards
+func (a *SkyEyeAdapter) ReverseContinue(ctx context.Context, instanceID string) (*StopEvent, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// WatchStops registers a handler for the stops of an instance
+func (a *SkyEyeAdapter) WatchStops(ctx context.Context, instanceID string, handler func(*StopEvent)) error {
 	return fmt.Errorf("not implemented")
 }
 
@@ -153,6 +168,11 @@ func (a *SkyEyeAdapter) ExportTrace(ctx context.Context, instanceID string) (str
 	return "", fmt.Errorf("not implemented")
 }
 
+// InstructionCount returns the instructions executed by the last launch
+func (a *SkyEyeAdapter) InstructionCount(ctx context.Context, instanceID string) (uint64, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
 // GetGDBServerAddress returns GDB server address
 func (a *SkyEyeAdapter) GetGDBServerAddress(ctx context.Context, instanceID string) (string, error) {
 	a.mu.RLock()
@@ -166,11 +186,21 @@ func (a *SkyEyeAdapter) GetGDBServerAddress(ctx context.Context, instanceID stri
 	return fmt.Sprintf("localhost:%d", instance.Port), nil
 }
 
+// ServeGDB serves a GDB client
+func (a *SkyEyeAdapter) ServeGDB(ctx context.Context, instanceID string, conn io.ReadWriteCloser, readOnly bool) error {
+	return fmt.Errorf("not implemented")
+}
+
 // GetConsoleStream returns console stream
 func (a *SkyEyeAdapter) GetConsoleStream(ctx context.Context, instanceID string) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("not implemented")
 }
 
+// ReadConsoleLog returns the console output of the last launch
+func (a *SkyEyeAdapter) ReadConsoleLog(ctx context.Context, instanceID string) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
 // GetBackendType returns backend type
 func (a *SkyEyeAdapter) GetBackendType() BackendType {
 	return BackendSkyEye
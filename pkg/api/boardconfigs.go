@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/devicetree"
+	"github.com/gin-gonic/gin"
+)
+
+// ImportBoardConfig converts a device tree or Renode platform into a board configuration
+// @Summary Import board config
+// @Description Convert a Linux device tree (binary DTB or preprocessed DTS) or a Renode .repl platform into a board configuration. Nodes that cannot be represented are listed under unmapped.
+// @Tags board-configs
+// @Accept octet-stream
+// @Accept multipart/form-data
+// @Produce json
+// @Param format query string false "Input format (dtb|dts|repl), detected when omitted"
+// @Param node_id query string false "ID of the generated node"
+// @Param system_id query string false "System ID of the generated config"
+// @Param file formData file false "Board description file (multipart uploads)"
+// @Param include formData file false "Files referenced by using directives of a .repl (repeatable)"
+// @Success 200 {object} adapters.DeviceTreeImport
+// @Failure 400 {object} ErrorResponse
+// @Router /board-configs/import [post]
+func (h *Handler) ImportBoardConfig(c *gin.Context) {
+	result, ok := importBoardDocument(c)
+	if !ok {
+		return
+	}
+	result.Config.SystemID = c.Query("system_id")
+
+	c.JSON(http.StatusOK, result)
+}
+
+// importBoardDocument reads an uploaded device tree or Renode platform and
+// converts it into a board configuration, writing an error response on failure
+func importBoardDocument(c *gin.Context) (*adapters.DeviceTreeImport, bool) {
+	data, filename, err := readUpload(c, "file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "empty board description"})
+		return nil, false
+	}
+
+	var result *adapters.DeviceTreeImport
+	nodeID := c.Query("node_id")
+	switch format := boardDocumentFormat(c.Query("format"), filename, data); format {
+	case "dtb", "dts":
+		var tree *devicetree.Tree
+		if format == "dtb" {
+			tree, err = devicetree.Parse(data)
+		} else {
+			tree, err = devicetree.ParseDTS(string(data))
+		}
+		if err == nil {
+			result, err = adapters.ImportDeviceTree(tree, nodeID)
+		}
+	case "repl":
+		var includes map[string]string
+		if includes, err = readIncludes(c); err != nil {
+			break
+		}
+		var platform *adapters.REPLPlatform
+		platform, err = adapters.ParseREPL(string(data), adapters.REPLIncludeFiles(includes))
+		if err == nil {
+			result, err = adapters.ImportREPL(platform, nodeID)
+		}
+	default:
+		err = errors.New("format must be dtb, dts or repl")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	return result, true
+}
+
+// fdtMagic starts every flattened device tree blob
+var fdtMagic = []byte{0xd0, 0x0d, 0xfe, 0xed}
+
+// boardDocumentFormat picks dtb, dts or repl from the query, the file
+// extension or the FDT magic number
+func boardDocumentFormat(format, filename string, data []byte) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".dtb", ".dtbo":
+		return "dtb"
+	case ".dts", ".dtsi":
+		return "dts"
+	case ".repl":
+		return "repl"
+	}
+	if bytes.HasPrefix(data, fdtMagic) {
+		return "dtb"
+	}
+	return "dts"
+}
+
+// readIncludes collects the files uploaded as "include" parts, used to
+// resolve using directives of Renode platforms
+func readIncludes(c *gin.Context) (map[string]string, error) {
+	includes := make(map[string]string)
+	if !isMultipart(c) {
+		return includes, nil
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range form.File["include"] {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		includes[header.Filename] = string(data)
+	}
+	return includes, nil
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/catalog"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/svd"
+	"github.com/gin-gonic/gin"
+)
+
+// ListProcessors lists processor models
+// @Summary List processors
+// @Description List processor models, filtered by backend and type
+// @Tags models
+// @Produce json
+// @Param backend query string false "Backend supporting the model"
+// @Param type query string false "Processor type (ARM, RISC-V, ...)"
+// @Success 200 {array} models.Processor
+// @Router /models/processors [get]
+func (h *Handler) ListProcessors(c *gin.Context) {
+	processors, err := h.catalogService.ListProcessors(c.Request.Context(), catalogListOptions(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, processors)
+}
+
+// ListPeripherals lists peripheral models
+// @Summary List peripherals
+// @Description List peripheral models, filtered by backend, type and device
+// @Tags models
+// @Produce json
+// @Param backend query string false "Backend supporting the model"
+// @Param type query string false "Peripheral type (UART, GPIO, ...)"
+// @Param device query string false "Device ID"
+// @Success 200 {array} models.Peripheral
+// @Router /models/peripherals [get]
+func (h *Handler) ListPeripherals(c *gin.Context) {
+	opts := catalogListOptions(c)
+	opts.DeviceID = c.Query("device")
+
+	peripherals, err := h.catalogService.ListPeripherals(c.Request.Context(), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, peripherals)
+}
+
+// GetPeripheral retrieves a peripheral model
+// @Summary Get peripheral
+// @Description Get a peripheral model with its registers
+// @Tags models
+// @Produce json
+// @Param id path string true "Peripheral ID"
+// @Success 200 {object} models.Peripheral
+// @Failure 404 {object} ErrorResponse
+// @Router /models/peripherals/{id} [get]
+func (h *Handler) GetPeripheral(c *gin.Context) {
+	peripheral, err := h.catalogService.GetPeripheral(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, catalog.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, peripheral)
+}
+
+// ImportPeripherals imports a CMSIS-SVD file into the peripheral models
+// @Summary Import SVD
+// @Description Parse a CMSIS-SVD device description and store its peripherals with registers, fields, reset values and access types. Importing a device again replaces its peripherals.
+// @Tags models
+// @Accept xml
+// @Accept multipart/form-data
+// @Produce json
+// @Param device query string false "Device ID (lower-case device name when omitted)"
+// @Param file formData file false "SVD file (multipart uploads)"
+// @Success 201 {object} SVDImportResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /models/peripherals/import [post]
+func (h *Handler) ImportPeripherals(c *gin.Context) {
+	data, _, err := readUpload(c, "file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "empty SVD document"})
+		return
+	}
+
+	device, err := svd.Parse(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	backends := make(map[string][]string)
+	for backendType, adapter := range h.adapters {
+		for _, periph := range adapter.GetCapabilities().Peripherals {
+			backends[periph] = append(backends[periph], string(backendType))
+		}
+	}
+
+	record, peripherals, err := h.catalogService.ImportSVD(c.Request.Context(), device, catalog.ImportOptions{
+		DeviceID: strings.TrimSpace(c.Query("device")),
+		Backends: backends,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response := SVDImportResponse{Device: *record, Peripherals: make([]SVDPeripheralSummary, len(peripherals))}
+	for i, p := range peripherals {
+		response.Peripherals[i] = SVDPeripheralSummary{
+			ID:          p.ID,
+			Name:        p.Name,
+			Type:        p.Type,
+			BaseAddress: p.BaseAddress,
+			Registers:   len(device.Peripherals[i].Registers),
+		}
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListDevices lists imported devices
+// @Summary List devices
+// @Description List the devices imported from SVD files
+// @Tags models
+// @Produce json
+// @Success 200 {array} models.Device
+// @Router /models/devices [get]
+func (h *Handler) ListDevices(c *gin.Context) {
+	devices, err := h.catalogService.ListDevices(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// ListBuses lists bus models
+// @Summary List buses
+// @Description List bus models, filtered by backend and type
+// @Tags models
+// @Produce json
+// @Param backend query string false "Backend supporting the model"
+// @Param type query string false "Bus type"
+// @Success 200 {array} models.Bus
+// @Router /models/buses [get]
+func (h *Handler) ListBuses(c *gin.Context) {
+	buses, err := h.catalogService.ListBuses(c.Request.Context(), catalogListOptions(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, buses)
+}
+
+func catalogListOptions(c *gin.Context) catalog.ListOptions {
+	return catalog.ListOptions{
+		Backend: c.Query("backend"),
+		Type:    c.Query("type"),
+	}
+}
+
+// SVDImportResponse represents the result of an SVD import
+type SVDImportResponse struct {
+	Device      models.Device          `json:"device"`
+	Peripherals []SVDPeripheralSummary `json:"peripherals"`
+}
+
+// SVDPeripheralSummary summarizes an imported peripheral
+type SVDPeripheralSummary struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	BaseAddress uint64 `json:"base_address"`
+	Registers   int    `json:"registers"`
+}
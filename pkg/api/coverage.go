@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/gin-gonic/gin"
+)
+
+// MergeCoverage merges the coverage of several jobs
+// @Summary Merge coverage
+// @Description Merge the reports of coverage jobs that ran the same build, matched by the build ID of the ELF program, into an aggregated report saved as a coverage artifact. Without job_ids all coverage jobs of the build are merged.
+// @Tags coverage
+// @Accept json
+// @Produce json
+// @Param request body session.MergeCoverageRequest true "Build and jobs"
+// @Success 201 {object} session.MergedCoverage
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /coverage/merge [post]
+func (h *Handler) MergeCoverage(c *gin.Context) {
+	var req session.MergeCoverageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	merged, err := h.sessionService.MergeCoverage(c.Request.Context(), &req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, session.ErrCoverageNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, merged)
+}
+
+// GetCoverageDelta compares the coverage of two builds
+// @Summary Coverage delta
+// @Description Compare the coverage of two builds, each merged over all its coverage jobs: the change of the line, function and branch coverage in total and per source file, and the functions whose coverage changed
+// @Tags coverage
+// @Produce json
+// @Param base query string true "Build ID of the base build"
+// @Param head query string true "Build ID of the head build"
+// @Success 200 {object} coverage.Delta
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /coverage/delta [get]
+func (h *Handler) GetCoverageDelta(c *gin.Context) {
+	base, head := c.Query("base"), c.Query("head")
+	if base == "" || head == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "base and head build IDs are required"})
+		return
+	}
+	delta, err := h.sessionService.CoverageDelta(c.Request.Context(), base, head)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, session.ErrCoverageNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, delta)
+}
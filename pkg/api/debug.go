@@ -0,0 +1,522 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Limits of the debug endpoints
+const (
+	maxMemoryTransfer = 64 * 1024
+	defaultResumeWait = 10 * time.Second
+	maxResumeWait     = 5 * time.Minute
+)
+
+// ListBreakpoints lists the breakpoints of a session
+// @Summary List breakpoints
+// @Description List the breakpoints of a session in creation order
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {array} adapters.Breakpoint
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/breakpoints [get]
+func (h *Handler) ListBreakpoints(c *gin.Context) {
+	breakpoints, err := h.sessionService.ListBreakpoints(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, breakpoints)
+}
+
+// GetBreakpoint retrieves a breakpoint
+// @Summary Get breakpoint
+// @Description Get a breakpoint of a session
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param bpid path string true "Breakpoint ID"
+// @Success 200 {object} adapters.Breakpoint
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/breakpoints/{bpid} [get]
+func (h *Handler) GetBreakpoint(c *gin.Context) {
+	bp, err := h.sessionService.GetBreakpoint(c.Param("id"), c.Param("bpid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, bp)
+}
+
+// DeleteBreakpoint removes a breakpoint
+// @Summary Delete breakpoint
+// @Description Remove a breakpoint from the session and its instance
+// @Tags debug
+// @Param id path string true "Session ID"
+// @Param bpid path string true "Breakpoint ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/breakpoints/{bpid} [delete]
+func (h *Handler) DeleteBreakpoint(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.sessionService.RemoveBreakpoint(c.Request.Context(), sessionID, c.Param("bpid")); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, session.ErrBreakpointNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// WriteRegister writes a CPU register
+// @Summary Write register
+// @Description Write a CPU register of the halted target
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param reg path string true "Register name"
+// @Param request body RegisterWriteRequest true "Value"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /sessions/{id}/debug/registers/{reg} [post]
+func (h *Handler) WriteRegister(c *gin.Context) {
+	var req RegisterWriteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.Value == nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "value required"})
+		return
+	}
+
+	adapter, instanceID, err := h.sessionService.GetAdapter(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := adapter.WriteRegister(c.Request.Context(), instanceID, c.Param("reg"), req.Value); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Message: "register written"})
+}
+
+// ReadMemory reads target memory
+// @Summary Read memory
+// @Description Read target memory of the halted target
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param address query string true "Start address (decimal or 0x-prefixed)"
+// @Param size query int true "Number of bytes (at most 65536)"
+// @Param encoding query string false "Data encoding (hex|base64)"
+// @Success 200 {object} MemoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /sessions/{id}/debug/memory [get]
+func (h *Handler) ReadMemory(c *gin.Context) {
+	address, err := strconv.ParseUint(c.Query("address"), 0, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid address"})
+		return
+	}
+	size, err := strconv.ParseUint(c.Query("size"), 0, 32)
+	if err != nil || size == 0 || size > maxMemoryTransfer {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("size must be between 1 and %d", maxMemoryTransfer)})
+		return
+	}
+	encoding := c.DefaultQuery("encoding", "hex")
+	if encoding != "hex" && encoding != "base64" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "encoding must be hex or base64"})
+		return
+	}
+
+	adapter, instanceID, err := h.sessionService.GetAdapter(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	data, err := adapter.ReadMemory(c.Request.Context(), instanceID, address, uint32(size))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, MemoryResponse{
+		Address:  address,
+		Size:     len(data),
+		Encoding: encoding,
+		Data:     encodeMemory(data, encoding),
+	})
+}
+
+// WriteMemory writes target memory
+// @Summary Write memory
+// @Description Write target memory of the halted target
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param request body MemoryWriteRequest true "Memory write"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /sessions/{id}/debug/memory [post]
+func (h *Handler) WriteMemory(c *gin.Context) {
+	var req MemoryWriteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var data []byte
+	var err error
+	switch req.Encoding {
+	case "", "hex":
+		data, err = hex.DecodeString(req.Data)
+	case "base64":
+		data, err = base64.StdEncoding.DecodeString(req.Data)
+	default:
+		err = errors.New("encoding must be hex or base64")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("invalid data: %v", err)})
+		return
+	}
+	if len(data) == 0 || len(data) > maxMemoryTransfer {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("data must be between 1 and %d bytes", maxMemoryTransfer)})
+		return
+	}
+
+	adapter, instanceID, err := h.sessionService.GetAdapter(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := adapter.WriteMemory(c.Request.Context(), instanceID, uint64(req.Address), data); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Message: fmt.Sprintf("%d bytes written", len(data))})
+}
+
+// StepInstruction executes a single instruction
+// @Summary Step instruction
+// @Description Execute one instruction and report where the target stopped
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} adapters.StopEvent
+// @Failure 400 {object} ErrorResponse
+// @Router /sessions/{id}/debug/step [post]
+func (h *Handler) StepInstruction(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	event, err := h.sessionService.StepInstruction(c.Request.Context(), sessionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// Continue resumes the target
+// @Summary Continue
+// @Description Resume the target and wait for it to stop. Breakpoint hits whose condition does not hold, or that are ignored, resume the target again. When it is still running after the wait, the reason is "running" and the target keeps running.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param wait query string false "How long to wait for a stop (Go duration, default 10s, at most 5m)"
+// @Success 200 {object} adapters.StopEvent
+// @Failure 400 {object} ErrorResponse
+// @Router /sessions/{id}/debug/continue [post]
+func (h *Handler) Continue(c *gin.Context) {
+	wait, ok := resumeWait(c)
+	if !ok {
+		return
+	}
+
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+	defer cancel()
+	event, err := h.sessionService.Continue(ctx, sessionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// resumeWait parses the wait query parameter of resuming endpoints,
+// answering the request when it is invalid
+func resumeWait(c *gin.Context) (time.Duration, bool) {
+	text := c.Query("wait")
+	if text == "" {
+		return defaultResumeWait, true
+	}
+	wait, err := time.ParseDuration(text)
+	if err != nil || wait < 0 || wait > maxResumeWait {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid wait"})
+		return 0, false
+	}
+	return wait, true
+}
+
+// DebugEvents streams the debug events of a session
+// @Summary Debug events
+// @Description Stream stop events (breakpoint and watchpoint hits, steps, signals) and the memory violations of programs started with monitor_memory as Server-Sent Events, or as JSON messages when the request is a WebSocket upgrade. Each event carries a per-session sequence number; reconnecting clients pass the last one they saw as since (or Last-Event-ID) and receive the events they missed, as far as they are still kept.
+// @Tags debug
+// @Produce text/event-stream
+// @Param id path string true "Session ID"
+// @Param since query int false "Sequence number of the last event seen"
+// @Success 200 {object} session.DebugEvent
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/events [get]
+func (h *Handler) DebugEvents(c *gin.Context) {
+	var since uint64
+	text := c.Query("since")
+	if text == "" {
+		text = c.GetHeader("Last-Event-ID")
+	}
+	if text != "" {
+		parsed, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid since"})
+			return
+		}
+		since = parsed
+	}
+
+	backlog, events, cancel, err := h.sessionService.SubscribeEvents(c.Param("id"), since)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	defer cancel()
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		streamEventsWebSocket(c, backlog, events)
+		return
+	}
+	streamEventsSSE(c, backlog, events)
+}
+
+// eventKeepalive is the interval of keepalives on idle event streams
+const eventKeepalive = 15 * time.Second
+
+func streamEventsSSE(c *gin.Context, backlog []session.DebugEvent, events <-chan session.DebugEvent) {
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	write := func(event session.DebugEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	for _, event := range backlog {
+		if err := write(event); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return // Fell behind or session deleted; clients reconnect with Last-Event-ID
+			}
+			if err := write(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func streamEventsWebSocket(c *gin.Context, backlog []session.DebugEvent, events <-chan session.DebugEvent) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Detect the client going away; incoming messages are ignored
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, event := range backlog {
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+	}
+	ticker := time.NewTicker(eventKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "event stream ended"))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// DebugAdapter godoc
+// @Summary Debug Adapter Protocol
+// @Description Debug the session from an IDE: the WebSocket carries Debug Adapter Protocol messages, one JSON message per text frame. Source and function breakpoints, stack traces and disassembly use the symbols of the most recently uploaded ELF program, or of the program named in the attach request. A DAP client is the controlling client of the session; attach fails while a GDB or DAP client controls it.
+// @Tags debug
+// @Param id path string true "Session ID"
+// @Success 101
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/dap [get]
+func (h *Handler) DebugAdapter(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	h.dapServer.Serve(c.Request.Context(), &dapWebSocket{conn: conn}, sessionID)
+}
+
+// dapWebSocket carries DAP messages in WebSocket text frames
+type dapWebSocket struct {
+	conn *websocket.Conn
+	wmu  sync.Mutex
+}
+
+func (w *dapWebSocket) ReadMessage() ([]byte, error) {
+	for {
+		kind, data, err := w.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if kind == websocket.TextMessage || kind == websocket.BinaryMessage {
+			return data, nil
+		}
+	}
+}
+
+func (w *dapWebSocket) WriteMessage(data []byte) error {
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	return w.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (w *dapWebSocket) Close() error {
+	return w.conn.Close()
+}
+
+func encodeMemory(data []byte, encoding string) string {
+	if encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	return hex.EncodeToString(data)
+}
+
+// TargetAddress is a target address given as a JSON number or as a decimal
+// or 0x-prefixed string, which keeps 64-bit addresses exact
+type TargetAddress uint64
+
+// UnmarshalJSON accepts numbers and strings
+func (a *TargetAddress) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(text), 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid address %s", data)
+	}
+	*a = TargetAddress(value)
+	return nil
+}
+
+// RegisterWriteRequest represents a register write
+type RegisterWriteRequest struct {
+	Value interface{} `json:"value" swaggertype:"string"` // Number or decimal/0x string
+}
+
+// MemoryResponse represents a memory read
+type MemoryResponse struct {
+	Address  uint64 `json:"address"`
+	Size     int    `json:"size"`
+	Encoding string `json:"encoding"`
+	Data     string `json:"data"`
+}
+
+// MemoryWriteRequest represents a memory write
+type MemoryWriteRequest struct {
+	Address  TargetAddress `json:"address" swaggertype:"string"`
+	Data     string        `json:"data" binding:"required"`
+	Encoding string        `json:"encoding,omitempty"` // hex (default) or base64
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// GDBTokenRequest requests a GDB proxy token
+type GDBTokenRequest struct {
+	Mode       string `json:"mode" example:"control"` // control or observe
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`  // Default 3600, at most 86400
+}
+
+// GDBTokenResponse is a GDB proxy token with the ways to connect with it
+type GDBTokenResponse struct {
+	session.GDBToken
+	TCPAddress   string `json:"tcp_address,omitempty"` // Empty when the TCP proxy is disabled
+	WebSocketURL string `json:"websocket_url"`
+}
+
+// CreateGDBToken godoc
+// @Summary Create GDB proxy token
+// @Description Issue a token for connecting GDB to the session through the virServer GDB proxy, over TCP or WebSocket. A session has at most one controlling client; observers can read registers and memory only. Tokens can be used for any number of connections until they expire.
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param request body GDBTokenRequest false "Mode and lifetime"
+// @Success 201 {object} GDBTokenResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/gdb/tokens [post]
+func (h *Handler) CreateGDBToken(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var req GDBTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	token, err := h.sessionService.CreateGDBToken(sessionID, req.Mode, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := GDBTokenResponse{GDBToken: *token}
+	host := c.Request.Host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if address := h.sessionService.GDBProxyAddress(); address != "" {
+		if _, port, err := net.SplitHostPort(address); err == nil {
+			resp.TCPAddress = net.JoinHostPort(host, port)
+		}
+	}
+	scheme := "ws"
+	if c.Request.TLS != nil {
+		scheme = "wss"
+	}
+	resp.WebSocketURL = scheme + "://" + c.Request.Host + "/api/v1/sessions/" + sessionID + "/debug/gdb?token=" + token.Token
+	c.JSON(http.StatusCreated, resp)
+}
+
+// GDBProxy godoc
+// @Summary GDB over WebSocket
+// @Description Connect GDB to the session: the WebSocket carries the GDB Remote Serial Protocol byte stream in binary frames. The token comes from the token query parameter or an Authorization Bearer header. When the session already has a controlling client, a control token's connection is closed with a policy violation.
+// @Tags debug
+// @Param id path string true "Session ID"
+// @Param token query string false "GDB proxy token"
+// @Success 101
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/gdb [get]
+func (h *Handler) GDBProxy(c *gin.Context) {
+	value := c.Query("token")
+	if value == "" {
+		value = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	token, err := h.sessionService.ResolveGDBToken(value)
+	if err != nil || token.SessionID != c.Param("id") {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: session.ErrGDBTokenInvalid.Error()})
+		return
+	}
+	if _, _, err := h.sessionService.GetAdapter(token.SessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	stream := &webSocketStream{conn: conn}
+	err = h.sessionService.ServeGDB(c.Request.Context(), token, stream)
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err != nil {
+		message = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+	}
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// webSocketStream is a byte stream over WebSocket binary frames
+type webSocketStream struct {
+	conn   *websocket.Conn
+	reader io.Reader
+	wmu    sync.Mutex
+}
+
+func (s *webSocketStream) Read(p []byte) (int, error) {
+	for {
+		if s.reader != nil {
+			n, err := s.reader.Read(p)
+			if err != io.EOF {
+				return n, err
+			}
+			s.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+		}
+		_, reader, err := s.conn.NextReader()
+		if err != nil {
+			return 0, err
+		}
+		s.reader = reader
+	}
+}
+
+func (s *webSocketStream) Write(p []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close is left to the handler, which reports why the proxy ended
+func (s *webSocketStream) Close() error {
+	return nil
+}
@@ -1,24 +1,41 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/catalog"
+	"github.com/forfire912/virServer/pkg/dap"
+	"github.com/forfire912/virServer/pkg/job"
 	"github.com/forfire912/virServer/pkg/session"
+	"github.com/forfire912/virServer/pkg/template"
 	"github.com/gin-gonic/gin"
 )
 
 // Handler handles API requests
 type Handler struct {
-	sessionService *session.Service
-	adapters       map[adapters.BackendType]adapters.BackendAdapter
+	sessionService  *session.Service
+	templateService *template.Service
+	catalogService  *catalog.Service
+	jobService      *job.Service
+	dapServer       *dap.Server
+	adapters        map[adapters.BackendType]adapters.BackendAdapter
 }
 
 // NewHandler creates a new API handler
-func NewHandler(sessionService *session.Service) *Handler {
+func NewHandler(sessionService *session.Service, templateService *template.Service, catalogService *catalog.Service, jobService *job.Service) *Handler {
 	return &Handler{
-		sessionService: sessionService,
-		adapters:       make(map[adapters.BackendType]adapters.BackendAdapter),
+		sessionService:  sessionService,
+		templateService: templateService,
+		catalogService:  catalogService,
+		jobService:      jobService,
+		dapServer:       dap.NewServer(sessionService),
+		adapters:        make(map[adapters.BackendType]adapters.BackendAdapter),
 	}
 }
 
@@ -78,20 +95,33 @@ func (h *Handler) GetCapabilities(c *gin.Context) {
 // @Description Create a new simulation session with specified configuration
 // @Tags sessions
 // @Accept json
+// @Accept x-yaml
+// @Accept multipart/form-data
 // @Produce json
 // @Param request body session.CreateSessionRequest true "Session creation request"
 // @Success 201 {object} models.Session
 // @Failure 400 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Router /sessions [post]
 func (h *Handler) CreateSession(c *gin.Context) {
 	var req session.CreateSessionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if isMultipart(c) {
+		if err := bindSessionForm(c, &req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	} else if err := bindRequest(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
 	
 	sess, err := h.sessionService.CreateSession(c.Request.Context(), &req)
 	if err != nil {
+		var incompatible adapters.CompatibilityErrors
+		if errors.As(err, &incompatible) {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "board config is not compatible with the selected backends", Details: incompatible})
+			return
+		}
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -188,36 +218,83 @@ func (h *Handler) PowerControl(c *gin.Context) {
 
 // UploadProgram uploads a program to a session
 // @Summary Upload program
-// @Description Upload a program (ELF/BIN/HEX) to a session
+// @Description Upload a program (ELF/BIN/HEX) to a session. The file is stored with the session; entry point and build ID of ELF files are read from the file, and their symbols are used by the debugger.
 // @Tags programs
 // @Accept multipart/form-data
 // @Produce json
 // @Param id path string true "Session ID"
 // @Param file formData file true "Program file"
-// @Param name formData string true "Program name"
-// @Param type formData string true "Program type (ELF/BIN/HEX)"
-// @Success 200 {object} ProgramResponse
+// @Param name formData string false "Program name (defaults to the file name)"
+// @Param type formData string false "Program type (ELF/BIN/HEX, detected when omitted)"
+// @Param load_addr formData string false "Load address of BIN files (decimal or 0x-prefixed)"
+// @Success 200 {object} models.Program
 // @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
 // @Router /sessions/{id}/programs [post]
 func (h *Handler) UploadProgram(c *gin.Context) {
 	sessionID := c.Param("id")
 	
-	file, err := c.FormFile("file")
+	fileHeader, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "file required"})
 		return
 	}
 	
-	// TODO: Implement program upload logic
-	c.JSON(http.StatusOK, ProgramResponse{
-		ID:   "prog-" + sessionID,
-		Name: file.Filename,
-	})
+	upload := session.ProgramUpload{
+		Name: c.DefaultPostForm("name", fileHeader.Filename),
+		Type: c.PostForm("type"),
+	}
+	if text := c.PostForm("load_addr"); text != "" {
+		upload.LoadAddr, err = strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid load_addr"})
+			return
+		}
+	}
+	
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	defer file.Close()
+	
+	program, err := h.sessionService.UploadProgram(c.Request.Context(), sessionID, upload, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, program)
+}
+
+// ListPrograms lists the programs of a session
+// @Summary List programs
+// @Description List the programs uploaded to a session, newest first
+// @Tags programs
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {array} models.Program
+// @Failure 500 {object} ErrorResponse
+// @Router /sessions/{id}/programs [get]
+func (h *Handler) ListPrograms(c *gin.Context) {
+	programs, err := h.sessionService.ListPrograms(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, programs)
 }
 
 // StartProgram starts a program
 // @Summary Start program
-// @Description Start execution of an uploaded program
+// @Description Start execution of an uploaded program. With record, QEMU sessions record the run for replay sessions; the recording is an artifact of the session, ready once the program is restarted, the session powered off or deleted. Replay sessions replay their recording and stay halted at its beginning. With monitor_memory, QEMU sessions report loads and stores outside the memory map of the board, or against the access attribute of a region, as memory_violation debug events.
 // @Tags programs
 // @Accept json
 // @Produce json
@@ -236,21 +313,26 @@ func (h *Handler) StartProgram(c *gin.Context) {
 		req = StartProgramRequest{} // Use defaults
 	}
 	
-	adapter, instanceID, err := h.sessionService.GetAdapter(sessionID)
-	if err != nil {
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 		return
 	}
 	
 	options := &adapters.StartOptions{
-		Args:        req.Args,
-		Env:         req.Env,
-		WaitForGDB:  req.WaitForGDB,
-		EnableTrace: req.EnableTrace,
+		Args:          req.Args,
+		Env:           req.Env,
+		WaitForGDB:    req.WaitForGDB,
+		EnableTrace:   req.EnableTrace,
+		Record:        req.Record,
+		MonitorMemory: req.MonitorMemory,
 	}
 	
-	if err := adapter.StartProgram(c.Request.Context(), instanceID, programID, options); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	if err := h.sessionService.StartProgram(c.Request.Context(), sessionID, programID, options); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, session.ErrProgramNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
 		return
 	}
 	
@@ -259,7 +341,7 @@ func (h *Handler) StartProgram(c *gin.Context) {
 
 // SetBreakpoint sets a breakpoint
 // @Summary Set breakpoint
-// @Description Set a debug breakpoint
+// @Description Set a debug breakpoint, or a watchpoint with type write, read or access on length bytes. Breakpoints without an ID get one assigned; an existing ID replaces that breakpoint. Breakpoints set while the session is powered off are installed at power on. A condition over registers and memory, such as "r0 == 5 && *(u32 *)0x20000000 > 3", is evaluated on each hit and the target resumes when it does not hold; the first ignore_count hits resume the target as well.
 // @Tags debug
 // @Accept json
 // @Produce json
@@ -277,13 +359,12 @@ func (h *Handler) SetBreakpoint(c *gin.Context) {
 		return
 	}
 	
-	adapter, instanceID, err := h.sessionService.GetAdapter(sessionID)
-	if err != nil {
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 		return
 	}
 	
-	if err := adapter.SetBreakpoint(c.Request.Context(), instanceID, &bp); err != nil {
+	if err := h.sessionService.SetBreakpoint(c.Request.Context(), sessionID, &bp); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -320,6 +401,76 @@ func (h *Handler) ReadRegisters(c *gin.Context) {
 	c.JSON(http.StatusOK, regs)
 }
 
+// sessionFormFields are the fields of a session request sent as a
+// multipart form, the same as those of a JSON body
+var sessionFormFields = map[string]bool{
+	"name": true, "backend": true, "board_config": true, "board_template": true, "template_version": true,
+	"template_params": true, "replay": true, "deterministic": true, "seed": true, "resources": true,
+}
+
+// bindSessionForm fills a session request from a multipart form whose
+// board_config field is an uploaded JSON or YAML file. template_params and
+// resources are JSON or YAML documents.
+func bindSessionForm(c *gin.Context, req *session.CreateSessionRequest) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return err
+	}
+	req.Name = c.PostForm("name")
+	req.Backend = c.PostForm("backend")
+	req.BoardTemplate = c.PostForm("board_template")
+	req.Replay = c.PostForm("replay")
+	if req.Name == "" {
+		return errors.New("name required")
+	}
+	
+	// Fields the form cannot carry must not be dropped silently
+	var unknown []string
+	for field := range form.Value {
+		if !sessionFormFields[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	for field := range form.File {
+		if field != "board_config" {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown form fields: %s", strings.Join(unknown, ", "))
+	}
+	
+	if text := c.PostForm("template_version"); text != "" {
+		if req.TemplateVersion, err = strconv.Atoi(text); err != nil {
+			return fmt.Errorf("invalid template_version: %s", text)
+		}
+	}
+	if text := c.PostForm("deterministic"); text != "" {
+		if req.Deterministic, err = strconv.ParseBool(text); err != nil {
+			return fmt.Errorf("invalid deterministic: %s", text)
+		}
+	}
+	if text := c.PostForm("seed"); text != "" {
+		if req.Seed, err = strconv.ParseUint(text, 0, 64); err != nil {
+			return fmt.Errorf("invalid seed: %s", text)
+		}
+	}
+	if err := readDocumentField(c, "template_params", &req.TemplateParams); err != nil {
+		return err
+	}
+	if err := readDocumentField(c, "resources", &req.Resources); err != nil {
+		return err
+	}
+	
+	config, err := readConfigField(c, "board_config")
+	if err != nil {
+		return err
+	}
+	req.BoardConfig = config
+	return nil
+}
+
 // Helper function to get user ID from context
 func getUserID(c *gin.Context) string {
 	userID, exists := c.Get("user_id")
@@ -349,21 +500,26 @@ type PowerRequest struct {
 }
 
 type StartProgramRequest struct {
-	Args        []string          `json:"args"`
-	Env         map[string]string `json:"env"`
-	WaitForGDB  bool              `json:"wait_for_gdb"`
-	EnableTrace bool              `json:"enable_trace"`
-}
-
-type ProgramResponse struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	Args          []string          `json:"args"`
+	Env           map[string]string `json:"env"`
+	WaitForGDB    bool              `json:"wait_for_gdb"`
+	EnableTrace   bool              `json:"enable_trace"`
+	Record        bool              `json:"record"`         // Record the run for replay sessions (QEMU)
+	MonitorMemory bool              `json:"monitor_memory"` // Report accesses outside the memory map as debug events (QEMU)
 }
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error   string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 type SuccessResponse struct {
 	Message string `json:"message"`
 }
+
+type PagedResponse struct {
+	Items    interface{} `json:"items"`
+	Total    int64       `json:"total"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+}
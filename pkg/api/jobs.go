@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/forfire912/virServer/pkg/job"
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateJob starts an asynchronous job
+// @Summary Create job
+// @Description Start a job on a session. A session runs one job at a time. The "verify" job runs a program several times from power on and compares the console output, the stop, the registers and the instruction count of each run with the first. The "coverage" job runs an ELF program once and saves its line, function and branch coverage as LCOV, Cobertura and HTML reports in an artifact. The "trace" job runs an ELF program once and saves its function calls and exceptions as Chrome and Perfetto traces in an artifact. The "profile" job runs an ELF program once and saves the instructions it executed by function and call stack as a pprof profile in an artifact.
+// @Tags jobs
+// @Accept json
+// @Produce json
+// @Param request body job.CreateRequest true "Job type and options"
+// @Success 202 {object} models.Job
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /jobs [post]
+func (h *Handler) CreateJob(c *gin.Context) {
+	var req job.CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if _, _, err := h.sessionService.GetAdapter(req.SessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	created, err := h.jobService.Create(c.Request.Context(), &req)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, job.ErrBusy):
+			status = http.StatusConflict
+		case errors.Is(err, session.ErrProgramNotFound):
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, created)
+}
+
+// GetJob retrieves a job
+// @Summary Get job
+// @Description Get the status, progress and, once finished, the result or error of a job
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.Job
+// @Failure 404 {object} ErrorResponse
+// @Router /jobs/{id} [get]
+func (h *Handler) GetJob(c *gin.Context) {
+	found, err := h.jobService.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, job.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, found)
+}
+
+// ListJobs lists jobs
+// @Summary List jobs
+// @Description List jobs newest first
+// @Tags jobs
+// @Produce json
+// @Param session_id query string false "Only the jobs of this session"
+// @Success 200 {array} models.Job
+// @Failure 500 {object} ErrorResponse
+// @Router /jobs [get]
+func (h *Handler) ListJobs(c *gin.Context) {
+	jobs, err := h.jobService.List(c.Request.Context(), c.Query("session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// CancelJob cancels a job
+// @Summary Cancel job
+// @Description Cancel a job that has not finished. Its status becomes "cancelled" once it has stopped.
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /jobs/{id} [delete]
+func (h *Handler) CancelJob(c *gin.Context) {
+	if err := h.jobService.Cancel(c.Request.Context(), c.Param("id")); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, job.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Message: "job cancelled"})
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// bindRequest decodes a JSON or YAML request body, selected by Content-Type,
+// and runs the binding validators on the result
+func bindRequest(c *gin.Context, obj interface{}) error {
+	format, ok := adapters.FormatFromContentType(c.GetHeader("Content-Type"))
+	if !ok || format != adapters.FormatYAML {
+		return c.ShouldBindJSON(obj)
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	data, err := adapters.YAMLToJSON(body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// isMultipart reports whether the request carries a multipart form
+func isMultipart(c *gin.Context) bool {
+	return strings.HasPrefix(c.GetHeader("Content-Type"), "multipart/form-data")
+}
+
+// readConfigField reads a board configuration from a multipart form, either as
+// an uploaded file or as a plain text field. Uploaded YAML files, recognized by
+// extension or part Content-Type, are converted to JSON.
+func readConfigField(c *gin.Context, field string) (adapters.RawBoardConfig, error) {
+	header, err := c.FormFile(field)
+	if err != nil {
+		if text := c.PostForm(field); text != "" {
+			return adapters.RawBoardConfig(text), nil
+		}
+		return nil, nil
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	format, ok := adapters.FormatFromFilename(header.Filename)
+	if !ok {
+		format, ok = adapters.FormatFromContentType(header.Header.Get("Content-Type"))
+	}
+	if !ok {
+		format = adapters.DetectFormat(data)
+	}
+	if format == adapters.FormatYAML {
+		if data, err = adapters.YAMLToJSON(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", header.Filename, err)
+		}
+	}
+	return adapters.RawBoardConfig(data), nil
+}
+
+// readDocumentField decodes a JSON or YAML text field of a multipart form
+// into obj, leaving obj unchanged when the field is missing
+func readDocumentField(c *gin.Context, field string, obj interface{}) error {
+	text := c.PostForm(field)
+	if text == "" {
+		return nil
+	}
+	data := []byte(text)
+	if adapters.DetectFormat(data) == adapters.FormatYAML {
+		converted, err := adapters.YAMLToJSON(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		data = converted
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	return nil
+}
+
+// responseFormat picks the response format from the format query parameter or
+// the first recognized media type in the Accept header, defaulting to JSON
+func responseFormat(c *gin.Context) adapters.ConfigFormat {
+	switch strings.ToLower(c.Query("format")) {
+	case "yaml", "yml":
+		return adapters.FormatYAML
+	case "json":
+		return adapters.FormatJSON
+	}
+
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if format, ok := adapters.FormatFromContentType(mediaType); ok {
+			return format
+		}
+	}
+	return adapters.FormatJSON
+}
+
+// readUpload returns the uploaded document: the named file of a multipart
+// form, or the raw request body otherwise
+func readUpload(c *gin.Context, field string) ([]byte, string, error) {
+	if !isMultipart(c) {
+		data, err := io.ReadAll(c.Request.Body)
+		return data, "", err
+	}
+
+	header, err := c.FormFile(field)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s file required", field)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	return data, header.Filename, err
+}
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/catalog"
+	"github.com/forfire912/virServer/pkg/svd"
+	"github.com/gin-gonic/gin"
+)
+
+// ReadPeripheral reads and decodes the registers of a board peripheral
+// @Summary Read peripheral registers
+// @Description Read the registers of a peripheral of the session's board config and decode their fields using the register definitions of the model database. Write-only registers and registers whose reads have side effects are skipped unless side_effects=true.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param name path string true "Peripheral name from the board config"
+// @Param node query string false "Node ID (first node with the peripheral when omitted)"
+// @Param register query string false "Read only this register"
+// @Param side_effects query bool false "Also read registers whose reads modify the peripheral"
+// @Success 200 {object} PeripheralView
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/peripherals/{name} [get]
+func (h *Handler) ReadPeripheral(c *gin.Context) {
+	target, ok := h.resolvePeripheral(c)
+	if !ok {
+		return
+	}
+
+	registerName := c.Query("register")
+	sideEffects := c.Query("side_effects") == "true"
+
+	view := PeripheralView{
+		Name:        target.config.Name,
+		Type:        target.config.Type,
+		Node:        target.node,
+		Model:       target.model,
+		BaseAddress: target.base,
+		Registers:   []RegisterView{},
+		Values:      make(map[string]uint64),
+	}
+	for i := range target.registers {
+		reg := &target.registers[i]
+		if registerName != "" && !strings.EqualFold(reg.Name, registerName) {
+			continue
+		}
+
+		regView := target.registerView(reg)
+		switch {
+		case !svd.Readable(reg.Access):
+			regView.Skipped = "write-only"
+		case reg.HasReadSideEffects() && !sideEffects:
+			regView.Skipped = "read has side effects (" + readAction(reg) + ")"
+		default:
+			value, err := target.read(c.Request.Context(), reg)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+				return
+			}
+			regView.setValue(reg, value)
+			view.Values[view.Name+"."+reg.Name] = value
+			for _, f := range regView.Fields {
+				view.Values[view.Name+"."+reg.Name+"."+f.Name] = f.Value
+			}
+		}
+		view.Registers = append(view.Registers, regView)
+	}
+	if registerName != "" && len(view.Registers) == 0 {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("register %s not found in %s", registerName, target.model)})
+		return
+	}
+
+	c.JSON(http.StatusOK, view)
+}
+
+// WritePeripheral writes a peripheral register or a single field of it
+// @Summary Write peripheral register
+// @Description Write a peripheral register, or read-modify-write a single field. The value is given as a number or as the name of an enumerated value.
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param name path string true "Peripheral name from the board config"
+// @Param node query string false "Node ID (first node with the peripheral when omitted)"
+// @Param request body PeripheralWriteRequest true "Register write"
+// @Success 200 {object} RegisterView
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/peripherals/{name} [post]
+func (h *Handler) WritePeripheral(c *gin.Context) {
+	var req PeripheralWriteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if (req.Value == nil) == (req.Enum == "") {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "exactly one of value and enum required"})
+		return
+	}
+
+	target, ok := h.resolvePeripheral(c)
+	if !ok {
+		return
+	}
+
+	var reg *svd.Register
+	for i := range target.registers {
+		if strings.EqualFold(target.registers[i].Name, req.Register) {
+			reg = &target.registers[i]
+			break
+		}
+	}
+	if reg == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("register %s not found in %s", req.Register, target.model)})
+		return
+	}
+	if !svd.Writable(reg.Access) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("register %s is %s", reg.Name, reg.Access)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	value, err := target.compose(ctx, reg, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := svd.EncodeBytes(value, reg.Bytes(), target.bigEndian)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("register %s: %v", reg.Name, err)})
+		return
+	}
+	address := target.base + reg.AddressOffset
+	if err := target.adapter.WriteMemory(ctx, target.instanceID, address, data); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Report the register as the hardware sees it where reading is harmless
+	if svd.Readable(reg.Access) && !reg.HasReadSideEffects() {
+		if value, err = target.read(ctx, reg); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	view := target.registerView(reg)
+	view.setValue(reg, value)
+	c.JSON(http.StatusOK, view)
+}
+
+// peripheralTarget is a board peripheral bound to its model and backend
+type peripheralTarget struct {
+	config     adapters.PeripheralConfig
+	node       string
+	model      string
+	base       uint64
+	bigEndian  bool
+	registers  []svd.Register
+	adapter    adapters.BackendAdapter
+	instanceID string
+}
+
+// resolvePeripheral finds the peripheral named in the request path in the
+// session's board config together with its register definitions, writing an
+// error response on failure
+func (h *Handler) resolvePeripheral(c *gin.Context) (*peripheralTarget, bool) {
+	sessionID := c.Param("id")
+	name := c.Param("name")
+	ctx := c.Request.Context()
+
+	adapter, instanceID, err := h.sessionService.GetAdapter(sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	config, err := h.sessionService.GetBoardConfig(ctx, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+
+	target := &peripheralTarget{adapter: adapter, instanceID: instanceID}
+	found := false
+	for _, node := range config.Nodes {
+		if nodeID := c.Query("node"); nodeID != "" && node.ID != nodeID {
+			continue
+		}
+		for _, p := range node.Peripherals {
+			if strings.EqualFold(p.Name, name) {
+				target.config, target.node, found = p, node.ID, true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("peripheral %s not found in board config", name)})
+		return nil, false
+	}
+
+	model, _ := target.config.Properties["model"].(string)
+	device, _ := target.config.Properties["device"].(string)
+	record, err := h.catalogService.FindPeripheral(ctx, model, device, target.config.Name, target.config.Address)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, catalog.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if target.registers, err = catalog.Registers(record); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+
+	target.model = record.ID
+	target.base = target.config.Address
+	if target.base == 0 {
+		target.base = record.BaseAddress
+	}
+	if record.DeviceID != "" {
+		device, _ := h.catalogService.GetDevice(ctx, record.DeviceID)
+		target.bigEndian = catalog.BigEndian(device)
+	}
+	return target, true
+}
+
+// read reads the current value of a register
+func (t *peripheralTarget) read(ctx context.Context, reg *svd.Register) (uint64, error) {
+	address := t.base + reg.AddressOffset
+	data, err := t.adapter.ReadMemory(ctx, t.instanceID, address, uint32(reg.Bytes()))
+	if err != nil {
+		return 0, fmt.Errorf("read %s at %#x: %w", reg.Name, address, err)
+	}
+	if len(data) < reg.Bytes() {
+		return 0, fmt.Errorf("read %s at %#x: short read of %d bytes", reg.Name, address, len(data))
+	}
+	value, err := svd.DecodeBytes(data[:reg.Bytes()], t.bigEndian)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", reg.Name, err)
+	}
+	return value, nil
+}
+
+// compose computes the register value of a write request. Field writes
+// start from the current register value, or from the reset value when the
+// register cannot be read without side effects.
+func (t *peripheralTarget) compose(ctx context.Context, reg *svd.Register, req PeripheralWriteRequest) (uint64, error) {
+	if req.Field == "" {
+		if req.Enum != "" {
+			return 0, fmt.Errorf("enum requires a field")
+		}
+		return *req.Value, nil
+	}
+
+	field, ok := reg.Field(req.Field)
+	if !ok {
+		return 0, fmt.Errorf("field %s not found in register %s", req.Field, reg.Name)
+	}
+	if !svd.Writable(field.Access) {
+		return 0, fmt.Errorf("field %s.%s is %s", reg.Name, field.Name, field.Access)
+	}
+
+	var value uint64
+	if req.Enum != "" {
+		if value, ok = field.EnumValue(req.Enum); !ok {
+			return 0, fmt.Errorf("field %s.%s has no value named %s", reg.Name, field.Name, req.Enum)
+		}
+	} else {
+		value = *req.Value
+	}
+
+	current := reg.ResetValue
+	if svd.Readable(reg.Access) && !reg.HasReadSideEffects() {
+		var err error
+		if current, err = t.read(ctx, reg); err != nil {
+			return 0, err
+		}
+	}
+	return field.Insert(current, value)
+}
+
+func (t *peripheralTarget) registerView(reg *svd.Register) RegisterView {
+	return RegisterView{
+		Name:       reg.Name,
+		Address:    t.base + reg.AddressOffset,
+		Size:       reg.Size,
+		Access:     reg.Access,
+		ResetValue: reg.ResetValue,
+	}
+}
+
+func (v *RegisterView) setValue(reg *svd.Register, value uint64) {
+	v.Value = &value
+	v.Fields = reg.Decode(value)
+}
+
+func readAction(reg *svd.Register) string {
+	if reg.ReadAction != "" {
+		return reg.ReadAction
+	}
+	for _, f := range reg.Fields {
+		if f.ReadAction != "" {
+			return f.Name + ": " + f.ReadAction
+		}
+	}
+	return ""
+}
+
+// PeripheralView represents the decoded registers of a peripheral
+type PeripheralView struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Node        string            `json:"node"`
+	Model       string            `json:"model"`
+	BaseAddress uint64            `json:"base_address"`
+	Registers   []RegisterView    `json:"registers"`
+	Values      map[string]uint64 `json:"values"` // Flat PERIPH.REG[.FIELD] values
+}
+
+// RegisterView represents a decoded register
+type RegisterView struct {
+	Name       string           `json:"name"`
+	Address    uint64           `json:"address"`
+	Size       int              `json:"size"`
+	Access     string           `json:"access,omitempty"`
+	ResetValue uint64           `json:"reset_value"`
+	Value      *uint64          `json:"value,omitempty"`
+	Fields     []svd.FieldValue `json:"fields,omitempty"`
+	Skipped    string           `json:"skipped,omitempty"` // Why the register was not read
+}
+
+// PeripheralWriteRequest represents a peripheral register write
+type PeripheralWriteRequest struct {
+	Register string  `json:"register" binding:"required"`
+	Field    string  `json:"field,omitempty"` // Read-modify-write of a single field
+	Value    *uint64 `json:"value,omitempty"`
+	Enum     string  `json:"enum,omitempty"` // Enumerated value name instead of value
+}
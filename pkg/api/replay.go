@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/gin-gonic/gin"
+)
+
+// ReverseStep executes one instruction backwards
+// @Summary Reverse step
+// @Description Execute one instruction backwards in a replay session and report where the target stopped. At the beginning of the recording the reason is "replay_end".
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} adapters.StopEvent
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/reverse-step [post]
+func (h *Handler) ReverseStep(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	event, err := h.sessionService.ReverseStep(c.Request.Context(), sessionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// ReverseContinue runs the target backwards
+// @Summary Reverse continue
+// @Description Run a replay session backwards until a breakpoint or watchpoint whose condition holds, or the beginning of the recording ("replay_end"). Hits are not counted and ignore counts do not apply. When it is still running after the wait, the reason is "running".
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param wait query string false "How long to wait for a stop (Go duration, default 10s, at most 5m)"
+// @Success 200 {object} adapters.StopEvent
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/reverse-continue [post]
+func (h *Handler) ReverseContinue(c *gin.Context) {
+	wait, ok := resumeWait(c)
+	if !ok {
+		return
+	}
+
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+	defer cancel()
+	event, err := h.sessionService.ReverseContinue(ctx, sessionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// ListArtifacts lists the artifacts of a session
+// @Summary List artifacts
+// @Description List the artifacts a session produced, such as recordings of programs started with record, newest first
+// @Tags sessions
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {array} models.Artifact
+// @Failure 500 {object} ErrorResponse
+// @Router /sessions/{id}/artifacts [get]
+func (h *Handler) ListArtifacts(c *gin.Context) {
+	artifacts, err := h.sessionService.ListArtifacts(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, artifacts)
+}
+
+// GetArtifact retrieves an artifact
+// @Summary Get artifact
+// @Description Get an artifact by ID. Artifacts remain after their session is deleted.
+// @Tags sessions
+// @Produce json
+// @Param aid path string true "Artifact ID"
+// @Success 200 {object} models.Artifact
+// @Failure 404 {object} ErrorResponse
+// @Router /artifacts/{aid} [get]
+func (h *Handler) GetArtifact(c *gin.Context) {
+	artifact, err := h.sessionService.GetArtifact(c.Request.Context(), c.Param("aid"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, session.ErrArtifactNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, artifact)
+}
+
+// GetArtifactFile downloads a file of an artifact
+// @Summary Download artifact file
+// @Description Download a file of a ready artifact, such as the lcov.info, coverage.xml or index.html report of a coverage artifact
+// @Tags sessions
+// @Produce octet-stream
+// @Param aid path string true "Artifact ID"
+// @Param name path string true "File name"
+// @Success 200 {file} file
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /artifacts/{aid}/files/{name} [get]
+func (h *Handler) GetArtifactFile(c *gin.Context) {
+	path, err := h.sessionService.ArtifactFile(c.Request.Context(), c.Param("aid"), c.Param("name"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, session.ErrArtifactNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.File(path)
+}
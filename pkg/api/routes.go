@@ -41,6 +41,7 @@ func SetupRouter(handler *Handler) *gin.Engine {
 			
 			// Programs
 			sessions.POST("/:id/programs", handler.UploadProgram)
+			sessions.GET("/:id/programs", handler.ListPrograms)
 			sessions.POST("/:id/programs/:pid/start", handler.StartProgram)
 			sessions.POST("/:id/programs/:pid/pause", handler.PauseProgram)
 			sessions.POST("/:id/programs/:pid/stop", handler.StopProgram)
@@ -49,12 +50,29 @@ func SetupRouter(handler *Handler) *gin.Engine {
 			debug := sessions.Group("/:id/debug")
 			{
 				debug.POST("/breakpoints", handler.SetBreakpoint)
+				debug.GET("/breakpoints", handler.ListBreakpoints)
+				debug.GET("/breakpoints/:bpid", handler.GetBreakpoint)
+				debug.DELETE("/breakpoints/:bpid", handler.DeleteBreakpoint)
 				debug.GET("/registers", handler.ReadRegisters)
 				debug.POST("/registers/:reg", handler.WriteRegister)
 				debug.GET("/memory", handler.ReadMemory)
+				debug.GET("/symbols", handler.LookupSymbols)
+				debug.GET("/variables", handler.ReadVariables)
+				debug.GET("/backtrace", handler.Backtrace)
+				debug.GET("/disassemble", handler.Disassemble)
+				debug.GET("/threads", handler.ListThreads)
+				debug.GET("/threads/:tid", handler.GetThread)
 				debug.POST("/memory", handler.WriteMemory)
 				debug.POST("/step", handler.StepInstruction)
 				debug.POST("/continue", handler.Continue)
+				debug.POST("/reverse-step", handler.ReverseStep)
+				debug.POST("/reverse-continue", handler.ReverseContinue)
+				debug.GET("/events", handler.DebugEvents)
+				debug.GET("/dap", handler.DebugAdapter)
+				debug.POST("/gdb/tokens", handler.CreateGDBToken)
+				debug.GET("/gdb", handler.GDBProxy)
+				debug.GET("/peripherals/:name", handler.ReadPeripheral)
+				debug.POST("/peripherals/:name", handler.WritePeripheral)
 			}
 			
 			// Snapshot
@@ -62,10 +80,24 @@ func SetupRouter(handler *Handler) *gin.Engine {
 			sessions.POST("/:id/snapshot/:sid/restore", handler.RestoreSnapshot)
 			sessions.GET("/:id/snapshots", handler.ListSnapshots)
 			
+			// Artifacts
+			sessions.GET("/:id/artifacts", handler.ListArtifacts)
+			
 			// Console/Logs stream (WebSocket)
 			sessions.GET("/:id/stream", handler.StreamConsole)
 		}
 		
+		// Artifacts outlive their sessions
+		v1.GET("/artifacts/:aid", handler.GetArtifact)
+		v1.GET("/artifacts/:aid/files/:name", handler.GetArtifactFile)
+		
+		// Coverage across jobs and builds
+		coverage := v1.Group("/coverage")
+		{
+			coverage.POST("/merge", handler.MergeCoverage)
+			coverage.GET("/delta", handler.GetCoverageDelta)
+		}
+		
 		// Jobs
 		jobs := v1.Group("/jobs")
 		{
@@ -80,16 +112,27 @@ func SetupRouter(handler *Handler) *gin.Engine {
 		{
 			templates.GET("", handler.ListTemplates)
 			templates.GET("/:id", handler.GetTemplate)
+			templates.GET("/:id/versions", handler.ListTemplateVersions)
 			templates.POST("", handler.CreateTemplate)
+			templates.POST("/import", handler.ImportTemplate)
 			templates.PUT("/:id", handler.UpdateTemplate)
 			templates.DELETE("/:id", handler.DeleteTemplate)
 		}
 		
+		// Board configurations
+		boardConfigs := v1.Group("/board-configs")
+		{
+			boardConfigs.POST("/import", handler.ImportBoardConfig)
+		}
+		
 		// Model database (processors, peripherals, buses)
 		models := v1.Group("/models")
 		{
 			models.GET("/processors", handler.ListProcessors)
 			models.GET("/peripherals", handler.ListPeripherals)
+			models.GET("/peripherals/:id", handler.GetPeripheral)
+			models.POST("/peripherals/import", handler.ImportPeripherals)
+			models.GET("/devices", handler.ListDevices)
 			models.GET("/buses", handler.ListBuses)
 		}
 	}
@@ -107,26 +150,6 @@ func (h *Handler) StopProgram(c *gin.Context) {
 	c.JSON(200, SuccessResponse{Message: "not implemented"})
 }
 
-func (h *Handler) WriteRegister(c *gin.Context) {
-	c.JSON(200, SuccessResponse{Message: "not implemented"})
-}
-
-func (h *Handler) ReadMemory(c *gin.Context) {
-	c.JSON(200, gin.H{})
-}
-
-func (h *Handler) WriteMemory(c *gin.Context) {
-	c.JSON(200, SuccessResponse{Message: "not implemented"})
-}
-
-func (h *Handler) StepInstruction(c *gin.Context) {
-	c.JSON(200, SuccessResponse{Message: "not implemented"})
-}
-
-func (h *Handler) Continue(c *gin.Context) {
-	c.JSON(200, SuccessResponse{Message: "not implemented"})
-}
-
 func (h *Handler) CreateSnapshot(c *gin.Context) {
 	c.JSON(200, gin.H{"id": "snapshot-1"})
 }
@@ -154,51 +177,3 @@ func (h *Handler) StreamConsole(c *gin.Context) {
 		}
 	}
 }
-
-func (h *Handler) CreateJob(c *gin.Context) {
-	c.JSON(200, gin.H{"id": "job-1"})
-}
-
-func (h *Handler) GetJob(c *gin.Context) {
-	c.JSON(200, gin.H{"id": c.Param("id"), "status": "pending"})
-}
-
-func (h *Handler) ListJobs(c *gin.Context) {
-	c.JSON(200, []interface{}{})
-}
-
-func (h *Handler) CancelJob(c *gin.Context) {
-	c.JSON(200, SuccessResponse{Message: "job cancelled"})
-}
-
-func (h *Handler) ListTemplates(c *gin.Context) {
-	c.JSON(200, []interface{}{})
-}
-
-func (h *Handler) GetTemplate(c *gin.Context) {
-	c.JSON(200, gin.H{"id": c.Param("id")})
-}
-
-func (h *Handler) CreateTemplate(c *gin.Context) {
-	c.JSON(201, gin.H{"id": "template-1"})
-}
-
-func (h *Handler) UpdateTemplate(c *gin.Context) {
-	c.JSON(200, SuccessResponse{Message: "template updated"})
-}
-
-func (h *Handler) DeleteTemplate(c *gin.Context) {
-	c.Status(204)
-}
-
-func (h *Handler) ListProcessors(c *gin.Context) {
-	c.JSON(200, []interface{}{})
-}
-
-func (h *Handler) ListPeripherals(c *gin.Context) {
-	c.JSON(200, []interface{}{})
-}
-
-func (h *Handler) ListBuses(c *gin.Context) {
-	c.JSON(200, []interface{}{})
-}
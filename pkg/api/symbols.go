@@ -0,0 +1,245 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/forfire912/virServer/pkg/disasm"
+	"github.com/forfire912/virServer/pkg/rtos"
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/forfire912/virServer/pkg/symbols"
+	"github.com/gin-gonic/gin"
+)
+
+// SymbolsResponse describes the symbols of a program
+type SymbolsResponse struct {
+	ProgramID string             `json:"program_id"`
+	BuildID   string             `json:"build_id"`
+	Machine   string             `json:"machine"`
+	Functions []symbols.Function `json:"functions"`
+	Globals   []symbols.Variable `json:"globals"`
+	Files     []string           `json:"files"`
+}
+
+// LocationResponse is a breakpoint location resolved to code addresses
+type LocationResponse struct {
+	Location  string             `json:"location"`
+	Addresses []symbols.Location `json:"addresses"`
+}
+
+// LookupSymbols godoc
+// @Summary Look up symbols
+// @Description Resolve an address to its function and source line, or a location (function name, file:line or address) to code addresses. Without either, list the functions, global variables and source files of the program. The session's latest ELF program is used unless program is given.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param program query string false "Program ID"
+// @Param address query string false "Address to resolve (decimal or 0x-prefixed)"
+// @Param location query string false "Function name, file:line or address"
+// @Success 200 {object} SymbolsResponse "Without address or location; symbols.Location for address, LocationResponse for location"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/symbols [get]
+func (h *Handler) LookupSymbols(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	table, program, err := h.sessionService.Symbols(c.Request.Context(), sessionID, c.Query("program"))
+	if err != nil {
+		c.JSON(symbolsErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if text := c.Query("address"); text != "" {
+		address, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid address"})
+			return
+		}
+		c.JSON(http.StatusOK, table.Lookup(address))
+		return
+	}
+	if location := c.Query("location"); location != "" {
+		addresses, err := table.Resolve(location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		resp := LocationResponse{Location: location}
+		for _, address := range addresses {
+			resp.Addresses = append(resp.Addresses, table.Lookup(address))
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, SymbolsResponse{
+		ProgramID: program.ID,
+		BuildID:   table.BuildID,
+		Machine:   table.Machine.String(),
+		Functions: table.Functions(),
+		Globals:   table.Globals(),
+		Files:     table.Files(),
+	})
+}
+
+// ReadVariables godoc
+// @Summary Read variables
+// @Description Read typed variables of the halted target using the program's debug information. With name, read one variable or a part of it: a name followed by .field, ->field and [index] selectors, optionally prefixed with *. Otherwise read the locals in scope at the current pc, or the globals. Variables that cannot be read carry an error.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param program query string false "Program ID"
+// @Param name query string false "Variable expression, e.g. sensors[0].samples[2]"
+// @Param scope query string false "locals (default) or globals"
+// @Param frame query int false "Backtrace level of the frame whose locals are read (default 0, the innermost)"
+// @Param thread query string false "RTOS thread ID whose locals are read (default the running thread)"
+// @Success 200 {array} symbols.Value "A single symbols.Value with name"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/variables [get]
+func (h *Handler) ReadVariables(c *gin.Context) {
+	sessionID := c.Param("id")
+	scope := c.DefaultQuery("scope", "locals")
+	if scope != "locals" && scope != "globals" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "scope must be locals or globals"})
+		return
+	}
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	level, err := strconv.Atoi(c.DefaultQuery("frame", "0"))
+	if err != nil || level < 0 || level >= symbols.MaxBacktraceDepth {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid frame"})
+		return
+	}
+	table, frame, err := h.threadFrame(c, sessionID)
+	if err != nil {
+		c.JSON(symbolsErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if level > 0 {
+		trace := table.Backtrace(frame, level+1)
+		if len(trace.Frames) <= level {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("no frame %d: %s", level, trace.Stop)})
+			return
+		}
+		frame = trace.Frames[level].Frame
+	}
+
+	if name := c.Query("name"); name != "" {
+		value, err := table.ReadVariable(frame, name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, value)
+		return
+	}
+	if scope == "globals" {
+		c.JSON(http.StatusOK, table.ReadGlobals(frame))
+		return
+	}
+	c.JSON(http.StatusOK, table.ReadLocals(frame))
+}
+
+// Backtrace godoc
+// @Summary Backtrace
+// @Description Unwind the call stack of the halted target using the call frame information (.debug_frame or .eh_frame) of the program. On Cortex-M, exception handlers are unwound through the exception stack frame into the interrupted code, so a hard fault shows where it happened. Frames list the pc (the return address for callers), function, source line, stack pointer and canonical frame address; stop tells why unwinding ended.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param program query string false "Program ID"
+// @Param depth query int false "Maximum number of frames (default 64, at most 256)"
+// @Param thread query string false "RTOS thread ID to unwind from its saved registers (default the running thread)"
+// @Success 200 {object} symbols.Backtrace
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/backtrace [get]
+func (h *Handler) Backtrace(c *gin.Context) {
+	sessionID := c.Param("id")
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", strconv.Itoa(symbols.DefaultBacktraceDepth)))
+	if err != nil || depth <= 0 || depth > symbols.MaxBacktraceDepth {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("depth must be between 1 and %d", symbols.MaxBacktraceDepth)})
+		return
+	}
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	table, frame, err := h.threadFrame(c, sessionID)
+	if err != nil {
+		c.JSON(symbolsErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, table.Backtrace(frame, depth))
+}
+
+// Disassemble godoc
+// @Summary Disassemble
+// @Description Read code from the target and decode it. The instruction set follows the processor of the session's node (Thumb on Cortex-M, ARM, AArch64, RV32 or RV64) unless isa is given. When the session has an ELF program, instructions carry their function, source line and the symbol of branch targets.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param addr query string false "Start address (decimal or 0x-prefixed, default the pc)"
+// @Param count query int false "Number of instructions (default 16, at most 256)"
+// @Param isa query string false "Instruction set: arm, thumb, arm64, riscv32 or riscv64"
+// @Param program query string false "Program ID"
+// @Success 200 {object} session.Disassembly
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/disassemble [get]
+func (h *Handler) Disassemble(c *gin.Context) {
+	sessionID := c.Param("id")
+	req := session.DisassembleRequest{ISA: c.Query("isa"), ProgramID: c.Query("program")}
+	if text := c.Query("addr"); text != "" {
+		address, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid address"})
+			return
+		}
+		req.Address = &address
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(defaultDisassembleCount)))
+	if err != nil || count <= 0 || count > maxDisassembleCount {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("count must be between 1 and %d", maxDisassembleCount)})
+		return
+	}
+	req.Count = count
+	if req.ISA != "" && !disasm.Valid(req.ISA) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "isa must be arm, thumb, arm64, riscv32 or riscv64"})
+		return
+	}
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	result, err := h.sessionService.Disassemble(c.Request.Context(), sessionID, req)
+	if err != nil {
+		c.JSON(symbolsErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Limits of the number of instructions disassembled at once
+const (
+	defaultDisassembleCount = 16
+	maxDisassembleCount     = 256
+)
+
+// errInvalidThread is returned for thread query parameters that are not numbers
+var errInvalidThread = errors.New("invalid thread ID")
+
+func symbolsErrorStatus(err error) int {
+	if errors.Is(err, session.ErrProgramNotFound) || errors.Is(err, session.ErrThreadNotFound) || errors.Is(err, rtos.ErrNoRTOS) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
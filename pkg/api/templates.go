@@ -0,0 +1,434 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/template"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListTemplates lists board templates
+// @Summary List templates
+// @Description List board templates, filtered by tags, backend or a search string, with pagination
+// @Tags templates
+// @Produce json
+// @Param tag query []string false "Required tag (repeatable, or comma-separated)"
+// @Param backend query string false "Backend"
+// @Param q query string false "Search in name and description"
+// @Param page query int false "Page number (1-based)"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} PagedResponse
+// @Router /templates [get]
+func (h *Handler) ListTemplates(c *gin.Context) {
+	var tags []string
+	for _, tag := range c.QueryArray("tag") {
+		tags = append(tags, strings.Split(tag, ",")...)
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	page, pageSize = template.NormalizePage(page, pageSize)
+
+	templates, total, err := h.templateService.ListTemplates(c.Request.Context(), template.ListOptions{
+		Tags:     tags,
+		Backend:  c.Query("backend"),
+		Query:    c.Query("q"),
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, PagedResponse{
+		Items:    templates,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+// GetTemplate retrieves a board template
+// @Summary Get template
+// @Description Get a board template as JSON, or as YAML with Accept: application/yaml or ?format=yaml
+// @Tags templates
+// @Produce json
+// @Produce x-yaml
+// @Param id path string true "Template ID"
+// @Param version query int false "Template version (latest when omitted)"
+// @Param format query string false "Response format (json|yaml)"
+// @Success 200 {object} models.BoardTemplate
+// @Failure 404 {object} ErrorResponse
+// @Router /templates/{id} [get]
+func (h *Handler) GetTemplate(c *gin.Context) {
+	version, err := strconv.Atoi(c.DefaultQuery("version", "0"))
+	if err != nil || version < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid version"})
+		return
+	}
+
+	tmpl, err := h.templateService.GetTemplateVersion(c.Request.Context(), c.Param("id"), version)
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	respondTemplate(c, http.StatusOK, tmpl)
+}
+
+// ListTemplateVersions lists the versions of a board template
+// @Summary List template versions
+// @Description List all immutable versions of a board template, newest first
+// @Tags templates
+// @Produce json
+// @Param id path string true "Template ID"
+// @Success 200 {array} models.BoardTemplateVersion
+// @Failure 404 {object} ErrorResponse
+// @Router /templates/{id}/versions [get]
+func (h *Handler) ListTemplateVersions(c *gin.Context) {
+	versions, err := h.templateService.ListVersions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// CreateTemplate creates a board template
+// @Summary Create template
+// @Description Create version 1 of a board template from a JSON or YAML body, or from a multipart form with an uploaded config file
+// @Tags templates
+// @Accept json
+// @Accept x-yaml
+// @Accept multipart/form-data
+// @Produce json
+// @Produce x-yaml
+// @Param request body TemplateRequest true "Template"
+// @Success 201 {object} models.BoardTemplate
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /templates [post]
+func (h *Handler) CreateTemplate(c *gin.Context) {
+	req, ok := bindTemplateRequest(c)
+	if !ok {
+		return
+	}
+
+	tmpl, err := req.toModel()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.templateService.CreateTemplate(c.Request.Context(), tmpl); err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	respondTemplate(c, http.StatusCreated, tmpl)
+}
+
+// UpdateTemplate creates a new version of a board template
+// @Summary Update template
+// @Description Create a new immutable version of a board template; sessions keep referencing the version they were created from
+// @Tags templates
+// @Accept json
+// @Accept x-yaml
+// @Accept multipart/form-data
+// @Produce json
+// @Produce x-yaml
+// @Param id path string true "Template ID"
+// @Param request body TemplateRequest true "Template"
+// @Success 200 {object} models.BoardTemplate
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /templates/{id} [put]
+func (h *Handler) UpdateTemplate(c *gin.Context) {
+	req, ok := bindTemplateRequest(c)
+	if !ok {
+		return
+	}
+	req.ID = c.Param("id")
+
+	tmpl, err := req.toModel()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	updated, err := h.templateService.UpdateTemplate(c.Request.Context(), tmpl)
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	respondTemplate(c, http.StatusOK, updated)
+}
+
+// DeleteTemplate deletes a board template
+// @Summary Delete template
+// @Description Delete a board template and all of its versions
+// @Tags templates
+// @Param id path string true "Template ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /templates/{id} [delete]
+func (h *Handler) DeleteTemplate(c *gin.Context) {
+	if err := h.templateService.DeleteTemplate(c.Request.Context(), c.Param("id")); err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ImportTemplate creates a board template from a device tree or Renode platform
+// @Summary Import template
+// @Description Create a board template from a Renode .repl platform or a Linux device tree (DTB/DTS). Template metadata is taken from form fields for multipart uploads and from query parameters otherwise.
+// @Tags templates
+// @Accept octet-stream
+// @Accept multipart/form-data
+// @Produce json
+// @Param format query string false "Input format (repl|dtb|dts), detected when omitted"
+// @Param id query string false "Template ID"
+// @Param name query string false "Template name"
+// @Param description query string false "Template description"
+// @Param tags query string false "Comma-separated tags"
+// @Param node_id query string false "ID of the generated node"
+// @Param file formData file false "Board description file (multipart uploads)"
+// @Param include formData file false "Files referenced by using directives of a .repl (repeatable)"
+// @Success 201 {object} TemplateImportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /templates/import [post]
+func (h *Handler) ImportTemplate(c *gin.Context) {
+	field := c.Query
+	if isMultipart(c) {
+		field = func(key string) string {
+			if value := c.PostForm(key); value != "" {
+				return value
+			}
+			return c.Query(key)
+		}
+	}
+
+	result, ok := importBoardDocument(c)
+	if !ok {
+		return
+	}
+
+	id := field("id")
+	result.Config.SystemID = id
+	name := field("name")
+	if name == "" {
+		name = result.Config.Name
+	}
+	if name == "" {
+		name = id
+	}
+	if name == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "name required"})
+		return
+	}
+	result.Config.Name = name
+
+	config, err := json.Marshal(result.Config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	req := TemplateRequest{
+		ID:          id,
+		Name:        name,
+		Description: field("description"),
+		Backend:     string(result.Config.Nodes[0].Backend),
+		Tags:        field("tags"),
+		Config:      config,
+	}
+	tmpl, err := req.toModel()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.templateService.CreateTemplate(c.Request.Context(), tmpl); err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, TemplateImportResponse{Template: tmpl, Unmapped: result.Unmapped})
+}
+
+// bindTemplateRequest decodes a template from a JSON, YAML or multipart body
+func bindTemplateRequest(c *gin.Context) (*TemplateRequest, bool) {
+	var req TemplateRequest
+	var err error
+	if isMultipart(c) {
+		err = bindTemplateForm(c, &req)
+	} else {
+		err = bindRequest(c, &req)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	return &req, true
+}
+
+// respondTemplateError maps template service errors to status codes
+func respondTemplateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, template.ErrNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, template.ErrConflict):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	}
+}
+
+// bindTemplateForm fills a template request from a multipart form whose
+// config field is an uploaded JSON or YAML file
+func bindTemplateForm(c *gin.Context, req *TemplateRequest) error {
+	req.ID = c.PostForm("id")
+	req.Name = c.PostForm("name")
+	req.Description = c.PostForm("description")
+	req.Backend = c.PostForm("backend")
+	req.Tags = c.PostForm("tags")
+	req.Extends = c.PostForm("extends")
+	if req.Name == "" {
+		return errors.New("name required")
+	}
+
+	if err := readDocumentField(c, "parameters", &req.Parameters); err != nil {
+		return err
+	}
+
+	config, err := readConfigField(c, "config")
+	if err != nil {
+		return err
+	}
+	req.Config = config
+	return nil
+}
+
+// respondTemplate writes a template in the negotiated format. YAML responses
+// embed the configuration and parameters as nested documents instead of JSON
+// strings.
+func respondTemplate(c *gin.Context, status int, tmpl *models.BoardTemplate) {
+	if responseFormat(c) != adapters.FormatYAML {
+		c.JSON(status, tmpl)
+		return
+	}
+
+	var config interface{}
+	if err := json.Unmarshal([]byte(tmpl.Config), &config); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "invalid template config: " + err.Error()})
+		return
+	}
+	params, err := template.ParseParameters(tmpl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.YAML(status, TemplateDocument{
+		ID:          tmpl.ID,
+		Name:        tmpl.Name,
+		Description: tmpl.Description,
+		Backend:     tmpl.Backend,
+		Tags:        tmpl.Tags,
+		Extends:     tmpl.Extends,
+		Parameters:  params,
+		Version:     tmpl.Version,
+		Config:      config,
+		CreatedAt:   tmpl.CreatedAt,
+		UpdatedAt:   tmpl.UpdatedAt,
+	})
+}
+
+// TemplateRequest is the body of a template create request. Config may be a
+// nested object or a JSON/YAML document string; templates that extend another
+// template only need to contain the overrides.
+type TemplateRequest struct {
+	ID          string                  `json:"id"`
+	Name        string                  `json:"name" binding:"required"`
+	Description string                  `json:"description"`
+	Backend     string                  `json:"backend"`
+	Tags        string                  `json:"tags"`
+	Extends     string                  `json:"extends"`
+	Parameters  []template.Parameter    `json:"parameters"`
+	Config      adapters.RawBoardConfig `json:"config" swaggertype:"object"`
+}
+
+// toModel converts the request into a template record
+func (r *TemplateRequest) toModel() (*models.BoardTemplate, error) {
+	if len(r.Config) == 0 {
+		return nil, errors.New("config required")
+	}
+	config, err := r.Config.JSON()
+	if err != nil {
+		return nil, fmt.Errorf("invalid template config: %w", err)
+	}
+
+	tmpl := &models.BoardTemplate{
+		ID:          r.ID,
+		Name:        r.Name,
+		Description: r.Description,
+		Backend:     r.Backend,
+		Tags:        r.Tags,
+		Extends:     r.Extends,
+		Config:      string(config),
+	}
+	if len(r.Parameters) > 0 {
+		params, err := json.Marshal(r.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.Parameters = string(params)
+	}
+
+	if tmpl.ID == "" {
+		var doc struct {
+			SystemID string `json:"system_id"`
+		}
+		json.Unmarshal(config, &doc)
+		tmpl.ID = doc.SystemID
+	}
+	if tmpl.ID == "" {
+		tmpl.ID = uuid.New().String()
+	}
+	return tmpl, nil
+}
+
+// TemplateImportResponse is the result of importing a template
+type TemplateImportResponse struct {
+	Template *models.BoardTemplate   `json:"template"`
+	Unmapped []adapters.UnmappedNode `json:"unmapped"`
+}
+
+// TemplateDocument is the YAML representation of a template
+type TemplateDocument struct {
+	ID          string               `yaml:"id"`
+	Name        string               `yaml:"name"`
+	Description string               `yaml:"description,omitempty"`
+	Backend     string               `yaml:"backend,omitempty"`
+	Tags        string               `yaml:"tags,omitempty"`
+	Extends     string               `yaml:"extends,omitempty"`
+	Parameters  []template.Parameter `yaml:"parameters,omitempty"`
+	Config      interface{}          `yaml:"config"`
+	Version     int                  `yaml:"version"`
+	CreatedAt   time.Time            `yaml:"created_at"`
+	UpdatedAt   time.Time            `yaml:"updated_at"`
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/forfire912/virServer/pkg/symbols"
+	"github.com/gin-gonic/gin"
+)
+
+// ListThreads godoc
+// @Summary List RTOS threads
+// @Description List the threads of the FreeRTOS or Zephyr kernel the program runs, read from the kernel's task lists in target memory while it is halted. Threads carry their name, state, priority, stack usage and registers: those of the CPU for the running thread, otherwise the context the thread saved when it was switched out (Cortex-M and RISC-V). Zephyr lists all threads only with CONFIG_THREAD_MONITOR.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param program query string false "Program ID"
+// @Success 200 {object} rtos.Threads
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/threads [get]
+func (h *Handler) ListThreads(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	threads, err := h.sessionService.Threads(c.Request.Context(), sessionID, c.Query("program"))
+	if err != nil {
+		c.JSON(symbolsErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, threads)
+}
+
+// GetThread godoc
+// @Summary Get RTOS thread
+// @Description Get one thread of the RTOS by its ID, the address of its control block
+// @Tags debug
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param tid path string true "Thread ID (decimal or 0x-prefixed)"
+// @Param program query string false "Program ID"
+// @Success 200 {object} rtos.Thread
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /sessions/{id}/debug/threads/{tid} [get]
+func (h *Handler) GetThread(c *gin.Context) {
+	sessionID := c.Param("id")
+	threadID, err := strconv.ParseUint(c.Param("tid"), 0, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid thread ID"})
+		return
+	}
+	if _, _, err := h.sessionService.GetAdapter(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	threads, err := h.sessionService.Threads(c.Request.Context(), sessionID, c.Query("program"))
+	if err != nil {
+		c.JSON(symbolsErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	thread, ok := threads.Find(threadID)
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: session.ErrThreadNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, thread)
+}
+
+// threadFrame returns the innermost frame of the CPU, or of the RTOS thread
+// given by the thread query parameter
+func (h *Handler) threadFrame(c *gin.Context, sessionID string) (*symbols.Table, *symbols.Frame, error) {
+	text := c.Query("thread")
+	if text == "" {
+		return h.sessionService.Frame(c.Request.Context(), sessionID, c.Query("program"))
+	}
+	threadID, err := strconv.ParseUint(text, 0, 64)
+	if err != nil {
+		return nil, nil, errInvalidThread
+	}
+	return h.sessionService.ThreadFrame(c.Request.Context(), sessionID, c.Query("program"), threadID)
+}
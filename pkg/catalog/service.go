@@ -0,0 +1,329 @@
+// Package catalog manages the model database of processors, peripherals,
+// buses and the devices imported from vendor descriptions.
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/svd"
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned when a model does not exist
+var ErrNotFound = errors.New("model not found")
+
+// likeEscaper escapes the wildcards of user input in LIKE patterns, which
+// declare \ as their escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Service manages the model database
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService creates a new catalog service
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// ListOptions filters model listings
+type ListOptions struct {
+	Backend  string // Backend listed in the comma-separated backends column
+	Type     string // Case-insensitive model type
+	DeviceID string // Peripherals only
+}
+
+func (o ListOptions) apply(query *gorm.DB) *gorm.DB {
+	if backend := strings.ToLower(strings.TrimSpace(o.Backend)); backend != "" {
+		query = query.Where(`(',' || LOWER(REPLACE(backends, ' ', '')) || ',') LIKE ? ESCAPE '\'`, "%,"+likeEscaper.Replace(backend)+",%")
+	}
+	if o.Type != "" {
+		query = query.Where("LOWER(type) = ?", strings.ToLower(o.Type))
+	}
+	return query
+}
+
+// ListProcessors returns the processor models matching the options
+func (s *Service) ListProcessors(ctx context.Context, opts ListOptions) ([]models.Processor, error) {
+	processors := []models.Processor{}
+	err := opts.apply(s.db.WithContext(ctx)).Order("id").Find(&processors).Error
+	return processors, err
+}
+
+// ListBuses returns the bus models matching the options
+func (s *Service) ListBuses(ctx context.Context, opts ListOptions) ([]models.Bus, error) {
+	buses := []models.Bus{}
+	err := opts.apply(s.db.WithContext(ctx)).Order("id").Find(&buses).Error
+	return buses, err
+}
+
+// ListPeripherals returns the peripheral models matching the options
+func (s *Service) ListPeripherals(ctx context.Context, opts ListOptions) ([]models.Peripheral, error) {
+	query := opts.apply(s.db.WithContext(ctx))
+	if opts.DeviceID != "" {
+		query = query.Where("device_id = ?", opts.DeviceID)
+	}
+	peripherals := []models.Peripheral{}
+	err := query.Order("device_id, base_address, id").Find(&peripherals).Error
+	return peripherals, err
+}
+
+// GetPeripheral retrieves a peripheral model
+func (s *Service) GetPeripheral(ctx context.Context, id string) (*models.Peripheral, error) {
+	var peripheral models.Peripheral
+	if err := s.db.WithContext(ctx).First(&peripheral, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: peripheral %s", ErrNotFound, id)
+		}
+		return nil, err
+	}
+	return &peripheral, nil
+}
+
+// FindPeripheral looks up the model of a board peripheral. An explicit
+// model ID wins; otherwise the peripheral is matched by name among the
+// models of device, or of all devices when device is empty. Of those, the
+// model whose base address equals the configured address is taken, or the
+// only one of the given device. Models of other hardware are never taken
+// by name alone: without such a match the error lists the candidates.
+func (s *Service) FindPeripheral(ctx context.Context, model, device, name string, address uint64) (*models.Peripheral, error) {
+	if model != "" {
+		return s.GetPeripheral(ctx, model)
+	}
+
+	query := s.db.WithContext(ctx).Where("LOWER(name) = ?", strings.ToLower(name))
+	if device != "" {
+		query = query.Where("LOWER(device_id) = ?", strings.ToLower(device))
+	}
+	var candidates []models.Peripheral
+	if err := query.Order("device_id, id").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		if device != "" {
+			return nil, fmt.Errorf("%w: no peripheral model named %s in device %s", ErrNotFound, name, device)
+		}
+		return nil, fmt.Errorf("%w: no peripheral model named %s", ErrNotFound, name)
+	}
+	for i := range candidates {
+		if address != 0 && candidates[i].BaseAddress == address {
+			return &candidates[i], nil
+		}
+	}
+	if device != "" && len(candidates) == 1 {
+		return &candidates[0], nil
+	}
+
+	listed := make([]string, len(candidates))
+	for i, c := range candidates {
+		listed[i] = fmt.Sprintf("%s at %#x", c.ID, c.BaseAddress)
+	}
+	return nil, fmt.Errorf("%w: no peripheral model named %s at %#x, candidates are %s; set the model or device property of the peripheral",
+		ErrNotFound, name, address, strings.Join(listed, ", "))
+}
+
+// Registers decodes the register definitions of a peripheral model
+func Registers(p *models.Peripheral) ([]svd.Register, error) {
+	var registers []svd.Register
+	if p.Registers == "" {
+		return registers, nil
+	}
+	if err := json.Unmarshal([]byte(p.Registers), &registers); err != nil {
+		return nil, fmt.Errorf("peripheral %s: invalid register definitions: %w", p.ID, err)
+	}
+	return registers, nil
+}
+
+// GetDevice retrieves an imported device
+func (s *Service) GetDevice(ctx context.Context, id string) (*models.Device, error) {
+	var device models.Device
+	if err := s.db.WithContext(ctx).First(&device, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: device %s", ErrNotFound, id)
+		}
+		return nil, err
+	}
+	return &device, nil
+}
+
+// BigEndian reports whether the CPU of a device is big-endian
+func BigEndian(device *models.Device) bool {
+	if device == nil || device.CPU == "" {
+		return false
+	}
+	var cpu svd.CPU
+	if err := json.Unmarshal([]byte(device.CPU), &cpu); err != nil {
+		return false
+	}
+	return cpu.Endian == "big"
+}
+
+// ListDevices returns all imported devices
+func (s *Service) ListDevices(ctx context.Context) ([]models.Device, error) {
+	devices := []models.Device{}
+	err := s.db.WithContext(ctx).Order("id").Find(&devices).Error
+	return devices, err
+}
+
+// ImportOptions control how an SVD device is stored
+type ImportOptions struct {
+	DeviceID string              // Defaults to the lower-case device name
+	Backends map[string][]string // Peripheral type to the backends that model it
+}
+
+// ImportSVD stores a parsed SVD device and its peripherals. Importing a
+// device again replaces its peripherals.
+func (s *Service) ImportSVD(ctx context.Context, device *svd.Device, opts ImportOptions) (*models.Device, []models.Peripheral, error) {
+	deviceID := opts.DeviceID
+	if deviceID == "" {
+		deviceID = strings.ToLower(device.Name)
+	}
+
+	now := time.Now()
+	record := &models.Device{
+		ID:          deviceID,
+		Name:        device.Name,
+		Vendor:      device.Vendor,
+		Version:     device.Version,
+		Description: device.Description,
+		Width:       device.Width,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if device.CPU != nil {
+		record.ProcessorID = ProcessorID(device.CPU.Name)
+		cpu, err := json.Marshal(device.CPU)
+		if err != nil {
+			return nil, nil, err
+		}
+		record.CPU = string(cpu)
+	}
+
+	peripherals := make([]models.Peripheral, 0, len(device.Peripherals))
+	for _, p := range device.Peripherals {
+		registers, err := json.Marshal(p.Registers)
+		if err != nil {
+			return nil, nil, err
+		}
+		var interrupts []byte
+		if len(p.Interrupts) > 0 {
+			if interrupts, err = json.Marshal(p.Interrupts); err != nil {
+				return nil, nil, err
+			}
+		}
+		peripheralType := PeripheralType(p)
+		backends := append([]string(nil), opts.Backends[peripheralType]...)
+		sort.Strings(backends)
+		peripherals = append(peripherals, models.Peripheral{
+			ID:          deviceID + "." + strings.ToLower(p.Name),
+			Name:        p.Name,
+			Type:        peripheralType,
+			Description: p.Description,
+			DeviceID:    deviceID,
+			BaseAddress: p.BaseAddress,
+			Size:        p.Size,
+			Interrupts:  string(interrupts),
+			Registers:   string(registers),
+			Backends:    strings.Join(backends, ","),
+			CreatedAt:   now,
+		})
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.Device
+		err := tx.First(&existing, "id = ?", deviceID).Error
+		switch {
+		case err == nil:
+			record.CreatedAt = existing.CreatedAt
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+		if err := tx.Save(record).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("device_id = ?", deviceID).Delete(&models.Peripheral{}).Error; err != nil {
+			return err
+		}
+		if len(peripherals) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(peripherals, 100).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, peripherals, nil
+}
+
+// peripheralTypes maps SVD name prefixes to the peripheral types of the
+// backend capabilities. Longer prefixes are listed before their prefixes.
+var peripheralTypes = []struct {
+	prefix string
+	typ    string
+}{
+	{"USART", "UART"},
+	{"UART", "UART"},
+	{"LPUART", "UART"},
+	{"SERCOM", "UART"},
+	{"GPIO", "GPIO"},
+	{"PORT", "GPIO"},
+	{"PIO", "GPIO"},
+	{"SPI", "SPI"},
+	{"QSPI", "SPI"},
+	{"I2C", "I2C"},
+	{"TWI", "I2C"},
+	{"LPTIM", "Timer"},
+	{"TIM", "Timer"},
+	{"TC", "Timer"},
+	{"RTC", "RTC"},
+	{"ETH", "Ethernet"},
+	{"ENET", "Ethernet"},
+	{"USB", "USB"},
+	{"OTG", "USB"},
+	{"FDCAN", "CAN"},
+	{"CAN", "CAN"},
+	{"ADC", "ADC"},
+	{"DAC", "DAC"},
+}
+
+// PeripheralType classifies an SVD peripheral by its group name, falling
+// back to its name. Unclassified peripherals keep their group name.
+func PeripheralType(p svd.Peripheral) string {
+	for _, candidate := range []string{p.GroupName, p.Name} {
+		candidate = strings.ToUpper(candidate)
+		if candidate == "" {
+			continue
+		}
+		for _, t := range peripheralTypes {
+			if strings.HasPrefix(candidate, t.prefix) {
+				return t.typ
+			}
+		}
+	}
+	if p.GroupName != "" {
+		return p.GroupName
+	}
+	return strings.TrimRightFunc(p.Name, func(r rune) bool { return r >= '0' && r <= '9' })
+}
+
+// ProcessorID maps an SVD CPU name (CM0PLUS, CM4, CA9, ...) to the ID of
+// the processor model
+func ProcessorID(cpu string) string {
+	cpu = strings.ToUpper(strings.TrimSpace(cpu))
+	switch {
+	case strings.HasPrefix(cpu, "CM"):
+		return "cortex-m" + strings.ToLower(strings.TrimSuffix(cpu[2:], "PLUS"))
+	case strings.HasPrefix(cpu, "CA"):
+		return "cortex-a" + strings.ToLower(cpu[2:])
+	case strings.HasPrefix(cpu, "CR"):
+		return "cortex-r" + strings.ToLower(cpu[2:])
+	}
+	return strings.ToLower(cpu)
+}
@@ -0,0 +1,234 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/svd"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1) // Every connection to :memory: opens a new database
+
+	if err := db.AutoMigrate(&models.Processor{}, &models.Peripheral{}, &models.Bus{}, &models.Device{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	return NewService(db)
+}
+
+var testDevice = &svd.Device{
+	Name:   "STM32F407",
+	Vendor: "STMicroelectronics",
+	CPU:    &svd.CPU{Name: "CM4"},
+	Width:  32,
+	Peripherals: []svd.Peripheral{
+		{
+			Name:        "USART2",
+			GroupName:   "USART",
+			BaseAddress: 0x40004400,
+			Interrupts:  []svd.Interrupt{{Name: "USART2", Value: 38}},
+			Registers: []svd.Register{{
+				Name: "SR", Size: 32, Access: svd.ReadOnly, ResetValue: 0xC0, ResetMask: 0xFFFFFFFF,
+				Fields: []svd.Field{{Name: "TXE", BitOffset: 7, BitWidth: 1, Access: svd.ReadOnly}},
+			}},
+		},
+		{Name: "GPIOD", GroupName: "GPIO", BaseAddress: 0x40020C00},
+		{Name: "CRC", BaseAddress: 0x40023000},
+	},
+}
+
+func TestService_ImportSVD(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	device, peripherals, err := svc.ImportSVD(ctx, testDevice, ImportOptions{
+		Backends: map[string][]string{"UART": {"renode", "qemu"}, "GPIO": {"qemu"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if device.ID != "stm32f407" || device.ProcessorID != "cortex-m4" {
+		t.Errorf("Unexpected device %+v", device)
+	}
+	if len(peripherals) != 3 {
+		t.Fatalf("Expected 3 peripherals, got %d", len(peripherals))
+	}
+
+	usart, err := svc.GetPeripheral(ctx, "stm32f407.usart2")
+	if err != nil {
+		t.Fatalf("Failed to get peripheral: %v", err)
+	}
+	if usart.Type != "UART" || usart.Backends != "qemu,renode" || usart.DeviceID != "stm32f407" {
+		t.Errorf("Unexpected peripheral %+v", usart)
+	}
+	var registers []svd.Register
+	if err := json.Unmarshal([]byte(usart.Registers), &registers); err != nil {
+		t.Fatalf("Registers are not JSON: %v", err)
+	}
+	if len(registers) != 1 || registers[0].ResetValue != 0xC0 || registers[0].Fields[0].Access != svd.ReadOnly {
+		t.Errorf("Unexpected registers %+v", registers)
+	}
+
+	crc, _ := svc.GetPeripheral(ctx, "stm32f407.crc")
+	if crc == nil || crc.Type != "CRC" || crc.Backends != "" {
+		t.Errorf("Unexpected CRC peripheral %+v", crc)
+	}
+
+	// Reimporting replaces the peripherals of the device
+	reduced := *testDevice
+	reduced.Peripherals = reduced.Peripherals[:1]
+	if _, _, err := svc.ImportSVD(ctx, &reduced, ImportOptions{}); err != nil {
+		t.Fatalf("Failed to reimport: %v", err)
+	}
+	if _, err := svc.GetPeripheral(ctx, "stm32f407.gpiod"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	devices, _ := svc.ListDevices(ctx)
+	if len(devices) != 1 {
+		t.Errorf("Expected 1 device, got %d", len(devices))
+	}
+}
+
+func TestService_List(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	svc.db.Create(&[]models.Processor{
+		{ID: "cortex-m3", Name: "ARM Cortex-M3", Type: "ARM", Backends: "qemu,renode,skyeye"},
+		{ID: "cortex-m4", Name: "ARM Cortex-M4", Type: "ARM", Backends: "qemu, renode"},
+		{ID: "rv32", Name: "RISC-V RV32", Type: "RISC-V", Backends: "qemu,renode"},
+	})
+	if _, _, err := svc.ImportSVD(ctx, testDevice, ImportOptions{
+		Backends: map[string][]string{"UART": {"qemu", "renode"}, "GPIO": {"qemu"}},
+	}); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want int
+	}{
+		{"all", ListOptions{}, 3},
+		{"backend", ListOptions{Backend: "skyeye"}, 1},
+		{"backend with spaces", ListOptions{Backend: "renode"}, 3},
+		{"type", ListOptions{Type: "arm"}, 2},
+		{"backend and type", ListOptions{Backend: "skyeye", Type: "RISC-V"}, 0},
+		{"wildcard backend", ListOptions{Backend: "%"}, 0},
+		{"single character wildcards", ListOptions{Backend: "qem_"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processors, err := svc.ListProcessors(ctx, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to list: %v", err)
+			}
+			if len(processors) != tt.want {
+				t.Errorf("Expected %d processors, got %d", tt.want, len(processors))
+			}
+		})
+	}
+
+	peripherals, err := svc.ListPeripherals(ctx, ListOptions{Backend: "qemu", DeviceID: "stm32f407"})
+	if err != nil {
+		t.Fatalf("Failed to list peripherals: %v", err)
+	}
+	if len(peripherals) != 2 || peripherals[0].Name != "USART2" {
+		t.Errorf("Unexpected peripherals %+v", peripherals)
+	}
+	if peripherals, _ := svc.ListPeripherals(ctx, ListOptions{Type: "uart", Backend: "renode"}); len(peripherals) != 1 {
+		t.Errorf("Expected 1 renode UART, got %d", len(peripherals))
+	}
+
+	buses, err := svc.ListBuses(ctx, ListOptions{})
+	if err != nil || buses == nil || len(buses) != 0 {
+		t.Errorf("Expected empty bus list, got %v, %v", buses, err)
+	}
+}
+
+func TestService_FindPeripheral(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	if _, _, err := svc.ImportSVD(ctx, testDevice, ImportOptions{}); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	other := *testDevice
+	other.Name = "STM32F103"
+	other.Peripherals = []svd.Peripheral{{Name: "USART2", BaseAddress: 0x40004800}}
+	if _, _, err := svc.ImportSVD(ctx, &other, ImportOptions{}); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	tests := []struct {
+		model, device, name string
+		address             uint64
+		want                string
+	}{
+		{"", "", "usart2", 0x40004400, "stm32f407.usart2"},
+		{"", "", "USART2", 0x40004800, "stm32f103.usart2"},
+		{"", "STM32F407", "USART2", 0, "stm32f407.usart2"},
+		{"", "stm32f103", "USART2", 0x50000000, "stm32f103.usart2"},
+		{"stm32f407.usart2", "", "UART0", 0, "stm32f407.usart2"},
+	}
+	for _, tt := range tests {
+		p, err := svc.FindPeripheral(ctx, tt.model, tt.device, tt.name, tt.address)
+		if err != nil {
+			t.Errorf("FindPeripheral(%q, %q, %q, %#x) failed: %v", tt.model, tt.device, tt.name, tt.address, err)
+			continue
+		}
+		if p.ID != tt.want {
+			t.Errorf("FindPeripheral(%q, %q, %q, %#x) = %s, want %s", tt.model, tt.device, tt.name, tt.address, p.ID, tt.want)
+		}
+	}
+	if _, err := svc.FindPeripheral(ctx, "", "", "SPI9", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := svc.FindPeripheral(ctx, "", "stm32f103", "USART1", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for another device, got %v", err)
+	}
+	// Same-named models of other hardware are not taken by name alone
+	for _, address := range []uint64{0, 0x50000000} {
+		_, err := svc.FindPeripheral(ctx, "", "", "USART2", address)
+		if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "stm32f103.usart2 at 0x40004800, stm32f407.usart2 at 0x40004400") {
+			t.Errorf("Expected the candidates at %#x, got %v", address, err)
+		}
+	}
+
+	p, _ := svc.GetPeripheral(ctx, "stm32f407.usart2")
+	registers, err := Registers(p)
+	if err != nil || len(registers) != 1 || registers[0].Name != "SR" {
+		t.Errorf("Unexpected registers %+v, %v", registers, err)
+	}
+}
+
+func TestProcessorID(t *testing.T) {
+	tests := map[string]string{
+		"CM0PLUS": "cortex-m0",
+		"CM4":     "cortex-m4",
+		"CM33":    "cortex-m33",
+		"CA9":     "cortex-a9",
+		"other":   "other",
+	}
+	for cpu, want := range tests {
+		if got := ProcessorID(cpu); got != want {
+			t.Errorf("ProcessorID(%q) = %q, want %q", cpu, got, want)
+		}
+	}
+}
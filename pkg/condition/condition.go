@@ -0,0 +1,616 @@
+// Package condition implements the expressions of conditional breakpoints:
+// C-like integer expressions over the registers and memory of a halted
+// target, such as
+//
+//	r0 == 5 && *(u32 *)0x20000000 > 3
+//
+// Registers are named as the backend reports them (r0, sp, pc, a0, x1),
+// optionally prefixed with $. Memory is read with the dereference
+// operator: a cast to a pointer type selects the width and signedness of
+// the value read, a plain * reads an unsigned 32-bit word. Casts to integer
+// types truncate and, for signed types, extend the sign. Integer literals
+// are decimal, 0x hexadecimal, 0b binary, octal with a leading 0, or
+// character constants.
+//
+// The operators are those of C with the usual precedence, from high to low:
+//
+//	unary        - ! ~ * (type)
+//	product      * / %
+//	sum          + -
+//	shift        << >>
+//	relational   < <= > >=
+//	equality     == !=
+//	bitwise and  &
+//	bitwise xor  ^
+//	bitwise or   |
+//	logical and  &&
+//	logical or   ||
+//
+// Arithmetic is 64 bits wide. It is signed when both operands are signed:
+// literals and values of signed types are, registers are not. && and ||
+// evaluate their right operand only when needed, so a condition can guard
+// a memory read. Addresses are byte addresses. Adding an integer to, or
+// subtracting it from, a value cast to a pointer type moves by whole
+// elements as in C, so that
+//
+//	*((u8 *)r0 + 1)
+//
+// reads the byte after the one r0 points to; the difference of two pointers
+// counts elements.
+package condition
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Env gives an expression access to the halted target
+type Env struct {
+	Registers map[string]uint64
+	Memory    func(address uint64, size int) ([]byte, error)
+	Order     binary.ByteOrder // Of memory; little endian when nil
+}
+
+// Expr is a parsed condition
+type Expr struct {
+	text string
+	root node
+}
+
+// Parse parses a condition
+func Parse(text string) (*Expr, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %w", text, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.expression(1)
+	if err == nil && p.pos < len(p.tokens) {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %w", text, err)
+	}
+	return &Expr{text: text, root: root}, nil
+}
+
+// String returns the text of the condition
+func (e *Expr) String() string {
+	return e.text
+}
+
+// Eval reports whether the condition holds, that is, evaluates to a value
+// other than zero
+func (e *Expr) Eval(env *Env) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return v.n != 0, nil
+}
+
+// typ is an integer type of casts and memory reads
+type typ struct {
+	size   int // Bytes
+	signed bool
+}
+
+// types are the integer types spelled in one word, other than the C
+// keywords of cTypeWords
+var types = map[string]typ{
+	"u8": {1, false}, "uint8_t": {1, false},
+	"i8": {1, true}, "s8": {1, true}, "int8_t": {1, true},
+	"u16": {2, false}, "uint16_t": {2, false},
+	"i16": {2, true}, "s16": {2, true}, "int16_t": {2, true},
+	"u32": {4, false}, "uint32_t": {4, false},
+	"i32": {4, true}, "s32": {4, true}, "int32_t": {4, true},
+	"u64": {8, false}, "uint64_t": {8, false},
+	"i64": {8, true}, "s64": {8, true}, "int64_t": {8, true},
+}
+
+// cTypeWords are the keywords C integer types are spelled with, such as
+// unsigned char or long long int. long alone is not accepted, as its size
+// depends on the target.
+var cTypeWords = map[string]bool{"signed": true, "unsigned": true, "char": true, "short": true, "int": true, "long": true}
+
+// cType returns the type spelled by C keywords. Plain char is signed.
+func cType(words []string) (typ, bool) {
+	sign := ""
+	var base []string
+	for _, w := range words {
+		switch w {
+		case "signed", "unsigned":
+			if sign != "" {
+				return typ{}, false
+			}
+			sign = w
+		default:
+			base = append(base, w)
+		}
+	}
+	var t typ
+	switch strings.Join(base, " ") {
+	case "char":
+		t.size = 1
+	case "short", "short int":
+		t.size = 2
+	case "", "int":
+		t.size = 4
+	case "long long", "long long int":
+		t.size = 8
+	default:
+		return typ{}, false
+	}
+	t.signed = sign != "unsigned"
+	return t, true
+}
+
+// word is the type read by a plain dereference
+var word = typ{size: 4}
+
+// value is an intermediate result
+type value struct {
+	n      uint64
+	signed bool
+	elem   *typ // Pointed-to type after a cast to a pointer type
+}
+
+func boolean(b bool) value {
+	if b {
+		return value{n: 1, signed: true}
+	}
+	return value{signed: true}
+}
+
+// convert truncates n to a type, extending the sign of signed types
+func convert(n uint64, t typ) value {
+	shift := uint(64 - 8*t.size)
+	if t.signed {
+		return value{n: uint64(int64(n<<shift) >> shift), signed: true}
+	}
+	return value{n: n << shift >> shift}
+}
+
+type node interface {
+	eval(env *Env) (value, error)
+}
+
+type literal uint64
+
+func (l literal) eval(*Env) (value, error) {
+	return value{n: uint64(l), signed: true}, nil
+}
+
+type register string
+
+func (r register) eval(env *Env) (value, error) {
+	n, ok := env.Registers[string(r)]
+	if !ok {
+		return value{}, fmt.Errorf("unknown register %s", string(r))
+	}
+	return value{n: n}, nil
+}
+
+type unary struct {
+	op string
+	x  node
+}
+
+func (u *unary) eval(env *Env) (value, error) {
+	x, err := u.x.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+	switch u.op {
+	case "-":
+		return value{n: -x.n, signed: x.signed}, nil
+	case "~":
+		return value{n: ^x.n, signed: x.signed}, nil
+	case "!":
+		return boolean(x.n == 0), nil
+	}
+	return deref(env, x)
+}
+
+// deref reads the value a pointer points to
+func deref(env *Env, pointer value) (value, error) {
+	t := word
+	if pointer.elem != nil {
+		t = *pointer.elem
+	}
+	if env.Memory == nil {
+		return value{}, fmt.Errorf("memory not available")
+	}
+	data, err := env.Memory(pointer.n, t.size)
+	if err != nil {
+		return value{}, fmt.Errorf("read %d bytes at %#x: %w", t.size, pointer.n, err)
+	}
+	if len(data) < t.size {
+		return value{}, fmt.Errorf("read %d bytes at %#x: short read", t.size, pointer.n)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if env.Order != nil {
+		order = env.Order
+	}
+	var n uint64
+	switch t.size {
+	case 1:
+		n = uint64(data[0])
+	case 2:
+		n = uint64(order.Uint16(data))
+	case 4:
+		n = uint64(order.Uint32(data))
+	default:
+		n = order.Uint64(data)
+	}
+	return convert(n, t), nil
+}
+
+type cast struct {
+	t       typ
+	pointer bool
+	x       node
+}
+
+func (c *cast) eval(env *Env) (value, error) {
+	x, err := c.x.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+	if c.pointer {
+		t := c.t
+		return value{n: x.n, elem: &t}, nil
+	}
+	return convert(x.n, c.t), nil
+}
+
+type binaryOp struct {
+	op   string
+	x, y node
+}
+
+func (b *binaryOp) eval(env *Env) (value, error) {
+	x, err := b.x.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+	// Short-circuit evaluation
+	switch {
+	case b.op == "&&" && x.n == 0:
+		return boolean(false), nil
+	case b.op == "||" && x.n != 0:
+		return boolean(true), nil
+	}
+	y, err := b.y.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+
+	signed := x.signed && y.signed
+	switch {
+	case b.op == "+" && x.elem != nil && y.elem == nil:
+		return value{n: x.n + y.n*uint64(x.elem.size), elem: x.elem}, nil
+	case b.op == "+" && x.elem == nil && y.elem != nil:
+		return value{n: x.n*uint64(y.elem.size) + y.n, elem: y.elem}, nil
+	case b.op == "-" && x.elem != nil && y.elem == nil:
+		return value{n: x.n - y.n*uint64(x.elem.size), elem: x.elem}, nil
+	case b.op == "-" && x.elem != nil && y.elem != nil:
+		if x.elem.size != y.elem.size {
+			return value{}, fmt.Errorf("difference of pointers to %d and %d byte types", x.elem.size, y.elem.size)
+		}
+		return value{n: uint64((int64(x.n) - int64(y.n)) / int64(x.elem.size)), signed: true}, nil
+	}
+	switch b.op {
+	case "&&", "||":
+		return boolean(y.n != 0), nil
+	case "+":
+		return value{n: x.n + y.n, signed: signed}, nil
+	case "-":
+		return value{n: x.n - y.n, signed: signed}, nil
+	case "*":
+		return value{n: x.n * y.n, signed: signed}, nil
+	case "/", "%":
+		if y.n == 0 {
+			return value{}, fmt.Errorf("division by zero")
+		}
+		if signed {
+			if b.op == "/" {
+				return value{n: uint64(int64(x.n) / int64(y.n)), signed: true}, nil
+			}
+			return value{n: uint64(int64(x.n) % int64(y.n)), signed: true}, nil
+		}
+		if b.op == "/" {
+			return value{n: x.n / y.n}, nil
+		}
+		return value{n: x.n % y.n}, nil
+	case "&":
+		return value{n: x.n & y.n, signed: signed}, nil
+	case "|":
+		return value{n: x.n | y.n, signed: signed}, nil
+	case "^":
+		return value{n: x.n ^ y.n, signed: signed}, nil
+	case "<<":
+		return value{n: x.n << y.n, signed: x.signed}, nil
+	case ">>":
+		if x.signed {
+			return value{n: uint64(int64(x.n) >> y.n), signed: true}, nil
+		}
+		return value{n: x.n >> y.n}, nil
+	case "==":
+		return boolean(x.n == y.n), nil
+	case "!=":
+		return boolean(x.n != y.n), nil
+	}
+
+	var less, equal bool
+	if signed {
+		less = int64(x.n) < int64(y.n)
+	} else {
+		less = x.n < y.n
+	}
+	equal = x.n == y.n
+	switch b.op {
+	case "<":
+		return boolean(less), nil
+	case "<=":
+		return boolean(less || equal), nil
+	case ">":
+		return boolean(!less && !equal), nil
+	}
+	return boolean(!less), nil
+}
+
+// Token kinds
+const (
+	tokenNumber = iota
+	tokenName
+	tokenOperator
+)
+
+type token struct {
+	kind   int
+	text   string
+	number uint64
+	offset int
+}
+
+// operators lists the operator tokens, longer ones first
+var operators = []string{
+	"==", "!=", "<=", ">=", "<<", ">>", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "~", "&", "|", "^", "(", ")",
+}
+
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c):
+			start := i
+			for i < len(text) && (isDigit(text[i]) || isLetter(text[i])) {
+				i++
+			}
+			n, err := strconv.ParseUint(text[start:i], 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", text[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text[start:i], number: n, offset: start})
+		case c == '\'':
+			n, size, err := character(text[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at offset %d", err, i)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text[i : i+size], number: n, offset: i})
+			i += size
+		case isLetter(c) || c == '$':
+			start := i
+			i++
+			for i < len(text) && (isLetter(text[i]) || isDigit(text[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenName, text: text[start:i], offset: start})
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(text[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, offset: i})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// character decodes a character constant at the start of text and returns
+// its value and length
+func character(text string) (uint64, int, error) {
+	for end := 1; end < len(text); end++ {
+		switch text[end] {
+		case '\\':
+			end++
+		case '\'':
+			s, err := strconv.Unquote(text[:end+1])
+			r := []rune(s)
+			if err != nil || len(r) != 1 || r[0] > 0xff {
+				return 0, 0, fmt.Errorf("invalid character constant %s", text[:end+1])
+			}
+			return uint64(r[0]), end + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unterminated character constant")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// precedence of the binary operators
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+// parser is a precedence climbing parser
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	tok := p.peek()
+	if tok == nil {
+		return fmt.Errorf("unexpected end")
+	}
+	return fmt.Errorf("unexpected %q at offset %d", tok.text, tok.offset)
+}
+
+// expression parses binary operators of at least the given precedence
+func (p *parser) expression(min int) (node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok == nil || tok.kind != tokenOperator {
+			return x, nil
+		}
+		prec, ok := precedence[tok.text]
+		if !ok || prec < min {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.expression(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryOp{op: tok.text, x: x, y: y}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	tok := p.peek()
+	if tok == nil {
+		return nil, p.unexpected()
+	}
+	p.pos++
+	switch tok.kind {
+	case tokenNumber:
+		return literal(tok.number), nil
+	case tokenName:
+		if _, ok := types[tok.text]; ok || cTypeWords[tok.text] {
+			p.pos--
+			return nil, p.unexpected()
+		}
+		return register(strings.ToLower(strings.TrimPrefix(tok.text, "$"))), nil
+	}
+
+	switch tok.text {
+	case "-", "!", "~", "*":
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: tok.text, x: x}, nil
+	case "(":
+		t, ok, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return p.cast(t)
+		}
+		x, err := p.expression(1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	p.pos--
+	return nil, p.unexpected()
+}
+
+// typeName parses the type of a cast, if the next tokens spell one
+func (p *parser) typeName() (typ, bool, error) {
+	tok := p.peek()
+	if tok == nil || tok.kind != tokenName {
+		return typ{}, false, nil
+	}
+	if t, ok := types[tok.text]; ok {
+		p.pos++
+		return t, true, nil
+	}
+	offset := tok.offset
+	var words []string
+	for tok != nil && tok.kind == tokenName && cTypeWords[tok.text] {
+		words = append(words, tok.text)
+		p.pos++
+		tok = p.peek()
+	}
+	if len(words) == 0 {
+		return typ{}, false, nil
+	}
+	t, ok := cType(words)
+	if !ok {
+		return typ{}, false, fmt.Errorf("invalid type %q at offset %d", strings.Join(words, " "), offset)
+	}
+	return t, true, nil
+}
+
+// cast parses the rest of a cast after its type
+func (p *parser) cast(t typ) (node, error) {
+	c := &cast{t: t}
+	if next := p.peek(); next != nil && next.text == "*" {
+		p.pos++
+		c.pointer = true
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	c.x = x
+	return c, nil
+}
+
+func (p *parser) expect(text string) error {
+	if tok := p.peek(); tok == nil || tok.kind != tokenOperator || tok.text != text {
+		return p.unexpected()
+	}
+	p.pos++
+	return nil
+}
@@ -0,0 +1,126 @@
+package condition
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func testEnv() *Env {
+	memory := map[uint64][]byte{
+		0x20000000: {0x05, 0x00, 0x00, 0x00},
+		0x20000004: {0xfe, 0xff, 0xff, 0xff, 0x01, 0x00, 0x00, 0x00},
+	}
+	return &Env{
+		Registers: map[string]uint64{"r0": 5, "r1": 0xffffffff, "pc": 0x08000100, "sp": 0x20001000},
+		Memory: func(address uint64, size int) ([]byte, error) {
+			for base, data := range memory {
+				if address >= base && address+uint64(size) <= base+uint64(len(data)) {
+					return data[address-base : address-base+uint64(size)], nil
+				}
+			}
+			return nil, fmt.Errorf("unmapped")
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"r0 == 5", true},
+		{"$r0 != 5", false},
+		{"R0 == 5", true},
+		{"r0 == 5 && *(u32 *)0x20000000 > 3", true},
+		{"*(u32*)0x20000000 == 5", true},
+		{"*0x20000000 == 5", true},
+		{"*(u8 *)0x20000004 == 0xfe", true},
+		{"*(i8 *)0x20000004 == -2", true},
+		{"*(int32_t *)0x20000004 < 0", true},
+		{"*(u32 *)0x20000004 < 0", false},
+		{"*(u64 *)0x20000004 == 0x1fffffffe", true},
+		{"(i16)r1 == -1", true},
+		{"(u8)r1 == 255", true},
+		{"r1 > 0", true},
+		{"(int)r1 > 0", false},
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"-7 / 2 == -3", true},
+		{"-7 % 2 == -1", true},
+		{"2 | 1 == 2", true}, // == binds tighter than |, as in C
+		{"(1 << 4 | 1) == 17", true},
+		{"0x10 >> 2 == 4 && 0b101 == 5 && 010 == 8", true},
+		{"'A' == 65 && '\\n' == 10", true},
+		{"~0 == -1", true},
+		{"!r0", false},
+		{"!!r0", true},
+		{"pc >= 0x08000000 && pc < 0x08100000", true},
+		{"sp & 7", false},
+		{"r0 ^ 5", false},
+		{"r0 == 4 || r0 == 5", true},
+		// Pointer arithmetic moves by elements of the pointed-to type
+		{"*((u8 *)0x20000004 + 1) == 0xff", true},
+		{"*(1 + (u8 *)0x20000004) == 0xff", true},
+		{"*((u16 *)0x20000004 + 2) == 1", true},
+		{"*((u32 *)0x20000008 - 1) == 0xfffffffe", true},
+		{"(u32 *)0x20000008 - (u32 *)0x20000000 == 2", true},
+		// C types spelled in several words
+		{"(unsigned char)r1 == 255", true},
+		{"(signed char)r1 == -1 && (char)r1 == -1", true},
+		{"(unsigned short int)r1 == 0xffff", true},
+		{"(unsigned int)r1 > 0 && (unsigned)r1 > 0", true},
+		{"(long long)r1 > 0", true},
+		{"*(unsigned char *)0x20000004 == 0xfe", true},
+		// The right operand is not evaluated when the left decides
+		{"r0 == 4 && *(u32 *)0 == 1", false},
+		{"r0 == 5 || *(u32 *)0 == 1", true},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		expr, err := Parse(tt.text)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.text, err)
+			continue
+		}
+		got, err := expr.Eval(env)
+		if err != nil || got != tt.want {
+			t.Errorf("%q = %v, %v; want %v", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+func TestEvalBigEndian(t *testing.T) {
+	env := testEnv()
+	env.Order = binary.BigEndian
+	expr, err := Parse("*(u32 *)0x20000000 == 0x05000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := expr.Eval(env); err != nil || !got {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := testEnv()
+	for _, text := range []string{"r9 == 1", "*(u32 *)0 == 1", "r0 / 0", "(u8 *)r0 - (u16 *)r0"} {
+		expr, err := Parse(text)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", text, err)
+			continue
+		}
+		if _, err := expr.Eval(env); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{"", "r0 ==", "(r0", "r0)", "r0 = 5", "0x", "*(u32 *", "u32", "'a", "r0 # 1",
+		"(long)r0", "(unsigned signed)r0", "(char char)r0", "unsigned"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q): expected an error", text)
+		}
+	}
+}
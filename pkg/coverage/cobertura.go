@@ -0,0 +1,174 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// coberturaDTD is the document type of Cobertura 0.4 reports
+const coberturaDTD = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity string            `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int    `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+// WriteCobertura writes a report as Cobertura XML, which Jenkins, GitLab
+// and Azure DevOps display. Files are classes grouped in packages by
+// directory; functions are methods with the line of their declaration.
+func WriteCobertura(w io.Writer, report *Report) error {
+	doc := coberturaCoverage{
+		LineRate:        rate(report.Summary.Lines),
+		BranchRate:      rate(report.Summary.Branches),
+		LinesCovered:    report.Summary.Lines.Covered,
+		LinesValid:      report.Summary.Lines.Total,
+		BranchesCovered: report.Summary.Branches.Covered,
+		BranchesValid:   report.Summary.Branches.Total,
+		Complexity:      "0",
+		Version:         "virserver",
+		Timestamp:       time.Now().UnixMilli(),
+		Sources:         []string{"."},
+	}
+
+	packages := make(map[string]*coberturaPackage)
+	totals := make(map[string]*Summary)
+	for _, f := range report.Files {
+		dir := path.Dir(strings.ReplaceAll(f.Path, "\\", "/"))
+		pkg, ok := packages[dir]
+		if !ok {
+			pkg = &coberturaPackage{Name: strings.ReplaceAll(strings.Trim(dir, "/."), "/", "."), Complexity: "0"}
+			packages[dir] = pkg
+			totals[dir] = &Summary{}
+		}
+		totals[dir].Lines.Covered += f.Summary.Lines.Covered
+		totals[dir].Lines.Total += f.Summary.Lines.Total
+		totals[dir].Branches.Covered += f.Summary.Branches.Covered
+		totals[dir].Branches.Total += f.Summary.Branches.Total
+		pkg.Classes = append(pkg.Classes, coberturaFile(&f))
+	}
+	dirs := make([]string, 0, len(packages))
+	for dir := range packages {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		pkg := packages[dir]
+		pkg.LineRate = rate(totals[dir].Lines)
+		pkg.BranchRate = rate(totals[dir].Branches)
+		doc.Packages = append(doc.Packages, *pkg)
+	}
+
+	if _, err := io.WriteString(w, xml.Header+coberturaDTD+"\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// coberturaFile converts the coverage of a file to a class
+func coberturaFile(f *File) coberturaClass {
+	branches := make(map[int]*Counter)
+	for _, branch := range f.Branches {
+		if branches[branch.Line] == nil {
+			branches[branch.Line] = &Counter{}
+		}
+		branches[branch.Line].add(branch.Taken)
+		branches[branch.Line].add(branch.NotTaken)
+	}
+
+	class := coberturaClass{
+		Name:       strings.TrimSuffix(path.Base(f.Path), path.Ext(f.Path)),
+		Filename:   f.Path,
+		LineRate:   rate(f.Summary.Lines),
+		BranchRate: rate(f.Summary.Branches),
+		Complexity: "0",
+		Methods:    []coberturaMethod{},
+		Lines:      []coberturaLine{},
+	}
+	for _, fn := range f.Functions {
+		method := coberturaMethod{
+			Name:       fn.Name,
+			LineRate:   "0",
+			BranchRate: "1",
+			Complexity: "0",
+			Lines:      []coberturaLine{{Number: fn.Line, Hits: hits(fn.Covered)}},
+		}
+		if fn.Covered {
+			method.LineRate = "1"
+		}
+		class.Methods = append(class.Methods, method)
+	}
+	for _, line := range f.Lines {
+		entry := coberturaLine{Number: line.Line, Hits: hits(line.Covered)}
+		if counter, ok := branches[line.Line]; ok {
+			counter.finish()
+			entry.Branch = true
+			entry.ConditionCoverage = fmt.Sprintf("%.0f%% (%d/%d)", counter.Percent, counter.Covered, counter.Total)
+		}
+		class.Lines = append(class.Lines, entry)
+	}
+	return class
+}
+
+// rate formats the covered fraction of a counter, 1 without items as
+// Cobertura readers expect
+func rate(c Counter) string {
+	if c.Total == 0 {
+		return "1"
+	}
+	return fmt.Sprintf("%.4f", float64(c.Covered)/float64(c.Total))
+}
@@ -0,0 +1,295 @@
+// Package coverage maps the basic blocks a program executed to its source
+// lines, functions and branches using the DWARF line table, and writes the
+// result as LCOV, Cobertura XML and HTML reports.
+package coverage
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/forfire912/virServer/pkg/disasm"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// Block is an executed basic block. Size covers at least the first byte of
+// its last instruction.
+type Block struct {
+	Start uint64 `json:"start"`
+	Size  uint32 `json:"size"`
+}
+
+// Counter counts covered items
+type Counter struct {
+	Covered int     `json:"covered"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"` // 0 without items
+}
+
+func (c *Counter) add(covered bool) {
+	c.Total++
+	if covered {
+		c.Covered++
+	}
+}
+
+func (c *Counter) finish() {
+	if c.Total > 0 {
+		c.Percent = math.Round(10000*float64(c.Covered)/float64(c.Total)) / 100
+	}
+}
+
+// Summary holds the counters of a file or a program
+type Summary struct {
+	Lines     Counter `json:"lines"`
+	Functions Counter `json:"functions"`
+	Branches  Counter `json:"branches"` // Outcomes: each branch is taken or falls through
+}
+
+func (s *Summary) finish() {
+	s.Lines.finish()
+	s.Functions.finish()
+	s.Branches.finish()
+}
+
+// Line is a source line with code
+type Line struct {
+	Line    int  `json:"line"`
+	Covered bool `json:"covered"`
+}
+
+// Function is a function with debug information
+type Function struct {
+	Name    string `json:"name"`
+	Line    int    `json:"line"`
+	Covered bool   `json:"covered"`
+}
+
+// Branch is a conditional branch instruction
+type Branch struct {
+	Line     int    `json:"line"`
+	Address  uint64 `json:"address"`
+	Executed bool   `json:"executed"`
+	Taken    bool   `json:"taken"`
+	NotTaken bool   `json:"not_taken"` // Fell through
+}
+
+// File is the coverage of a source file
+type File struct {
+	Path      string     `json:"path"`
+	Summary   Summary    `json:"summary"`
+	Lines     []Line     `json:"lines"`
+	Functions []Function `json:"functions"`
+	Branches  []Branch   `json:"branches"`
+}
+
+// Report is the coverage of a program
+type Report struct {
+	BuildID string  `json:"build_id"`
+	Summary Summary `json:"summary"`
+	Files   []File  `json:"files"`
+}
+
+// Build maps executed blocks to the lines, functions and branches of a
+// program. Branches are found by disassembling the functions with the
+// instruction set isa, and not analysed when it is empty.
+func Build(table *symbols.Table, isa string, blocks []Block) (*Report, error) {
+	if !table.HasDebugInfo() {
+		return nil, symbols.ErrNoDebugInfo
+	}
+	executed := newRanges(blocks)
+	files := make(map[string]*File)
+	file := func(path string) *File {
+		f, ok := files[path]
+		if !ok {
+			f = &File{Path: path}
+			files[path] = f
+		}
+		return f
+	}
+
+	lines := make(map[string]map[int]bool)
+	for _, row := range table.Lines() {
+		if !row.IsStmt || row.End || row.File == "" {
+			continue
+		}
+		if lines[row.File] == nil {
+			lines[row.File] = make(map[int]bool)
+		}
+		lines[row.File][row.Line] = lines[row.File][row.Line] || executed.contains(row.Address)
+	}
+	for path, covered := range lines {
+		f := file(path)
+		for line, hit := range covered {
+			f.Lines = append(f.Lines, Line{Line: line, Covered: hit})
+		}
+	}
+
+	// Functions with several address ranges are listed once per range
+	functions := make(map[[2]string]*Function)
+	var order binary.ByteOrder = binary.LittleEndian
+	if table.ByteOrder == "big" {
+		order = binary.BigEndian
+	}
+	code := newCodeReader(table.ELF())
+	for _, fn := range table.Functions() {
+		if fn.File == "" || fn.High <= fn.Low {
+			continue
+		}
+		key := [2]string{fn.File, fn.Name}
+		if functions[key] == nil {
+			functions[key] = &Function{Name: fn.Name, Line: fn.Line}
+		}
+		functions[key].Covered = functions[key].Covered || executed.contains(fn.Low)
+
+		if isa == "" {
+			continue
+		}
+		data := code.read(fn.Low, fn.High)
+		if data == nil {
+			continue
+		}
+		instructions, err := disasm.Decode(isa, data, fn.Low, order, len(data))
+		if err != nil {
+			return nil, err
+		}
+		for _, inst := range instructions {
+			if !inst.ConditionalBranch() {
+				continue
+			}
+			line, ok := table.LineAt(inst.Address)
+			if !ok || line.File == "" {
+				continue
+			}
+			f := file(line.File)
+			f.Branches = append(f.Branches, newBranch(inst, line.Line, executed))
+		}
+	}
+	for key, fn := range functions {
+		f := file(key[0])
+		f.Functions = append(f.Functions, *fn)
+	}
+
+	report := &Report{BuildID: table.BuildID}
+	for _, f := range files {
+		f.sort()
+		f.summarize()
+		report.Files = append(report.Files, *f)
+	}
+	report.summarize()
+	return report, nil
+}
+
+// newBranch returns the outcomes of a conditional branch. An outcome counts
+// as covered when the instruction it leads to was executed, so outcomes
+// reached from elsewhere count too.
+func newBranch(inst disasm.Instruction, line int, executed ranges) Branch {
+	branch := Branch{Line: line, Address: inst.Address, Executed: executed.contains(inst.Address)}
+	if branch.Executed {
+		branch.Taken = executed.contains(inst.Target)
+		branch.NotTaken = executed.contains(inst.Address + uint64(inst.Size))
+	}
+	return branch
+}
+
+func (f *File) sort() {
+	sort.Slice(f.Lines, func(i, j int) bool { return f.Lines[i].Line < f.Lines[j].Line })
+	sort.Slice(f.Functions, func(i, j int) bool {
+		a, b := f.Functions[i], f.Functions[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Name < b.Name
+	})
+	sort.Slice(f.Branches, func(i, j int) bool { return f.Branches[i].Address < f.Branches[j].Address })
+}
+
+func (f *File) summarize() {
+	f.Summary = Summary{}
+	for _, line := range f.Lines {
+		f.Summary.Lines.add(line.Covered)
+	}
+	for _, fn := range f.Functions {
+		f.Summary.Functions.add(fn.Covered)
+	}
+	for _, branch := range f.Branches {
+		f.Summary.Branches.add(branch.Taken)
+		f.Summary.Branches.add(branch.NotTaken)
+	}
+	f.Summary.finish()
+}
+
+// summarize sorts the files and totals their counters
+func (r *Report) summarize() {
+	sort.Slice(r.Files, func(i, j int) bool { return r.Files[i].Path < r.Files[j].Path })
+	r.Summary = Summary{}
+	for _, f := range r.Files {
+		for _, counters := range [][2]*Counter{
+			{&r.Summary.Lines, &f.Summary.Lines},
+			{&r.Summary.Functions, &f.Summary.Functions},
+			{&r.Summary.Branches, &f.Summary.Branches},
+		} {
+			counters[0].Covered += counters[1].Covered
+			counters[0].Total += counters[1].Total
+		}
+	}
+	r.Summary.finish()
+}
+
+// ranges is a set of addresses, as sorted disjoint [start, end) ranges
+type ranges [][2]uint64
+
+func newRanges(blocks []Block) ranges {
+	sorted := make(ranges, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Size > 0 {
+			sorted = append(sorted, [2]uint64{block.Start, block.Start + uint64(block.Size)})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	var merged ranges
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func (r ranges) contains(address uint64) bool {
+	i := sort.Search(len(r), func(i int) bool { return r[i][1] > address })
+	return i < len(r) && r[i][0] <= address
+}
+
+// codeReader reads code from the executable sections of an ELF file
+type codeReader struct {
+	file     *elf.File
+	sections map[*elf.Section][]byte
+}
+
+func newCodeReader(file *elf.File) *codeReader {
+	return &codeReader{file: file, sections: make(map[*elf.Section][]byte)}
+}
+
+// read returns the code from low to high, or nil when no executable
+// section holds it
+func (c *codeReader) read(low, high uint64) []byte {
+	for _, section := range c.file.Sections {
+		if section.Flags&elf.SHF_EXECINSTR == 0 || section.Type == elf.SHT_NOBITS ||
+			low < section.Addr || high > section.Addr+section.Size {
+			continue
+		}
+		data, ok := c.sections[section]
+		if !ok {
+			data, _ = section.Data()
+			c.sections[section] = data
+		}
+		if uint64(len(data)) < high-section.Addr {
+			return nil
+		}
+		return data[low-section.Addr : high-section.Addr]
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/disasm"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// The fixture of pkg/symbols: scale at 0x401000, average at 0x401019 and
+// _start at 0x401070 in /build/firmware.c
+func buildFixture(t *testing.T) *Report {
+	t.Helper()
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// All of _start and average up to the loop body on line 26
+	blocks := []Block{{Start: 0x401070, Size: 0x2d}, {Start: 0x401019, Size: 0x10}, {Start: 0x401029, Size: 0xc}}
+	report, err := Build(table, "", blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestBuild(t *testing.T) {
+	report := buildFixture(t)
+	if len(report.Files) != 1 || report.Files[0].Path != "/build/firmware.c" || report.BuildID == "" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	summary := report.Summary
+	if summary.Lines != (Counter{Covered: 7, Total: 14, Percent: 50}) {
+		t.Errorf("lines %+v", summary.Lines)
+	}
+	if summary.Functions != (Counter{Covered: 2, Total: 3, Percent: 66.67}) {
+		t.Errorf("functions %+v", summary.Functions)
+	}
+	var missed []int
+	for _, line := range report.Files[0].Lines {
+		if !line.Covered {
+			missed = append(missed, line.Line)
+		}
+	}
+	if len(missed) != 7 || missed[0] != 17 || missed[3] != 20 || missed[4] != 26 {
+		t.Errorf("missed lines %v", missed)
+	}
+	if fn := report.Files[0].Functions[0]; fn.Name != "scale" || fn.Line != 16 || fn.Covered {
+		t.Errorf("first function %+v", fn)
+	}
+}
+
+func TestNewBranch(t *testing.T) {
+	executed := newRanges([]Block{{Start: 0x100, Size: 0x10}, {Start: 0x200, Size: 4}})
+	inst := disasm.Instruction{Address: 0x10e, Size: 2, Text: "beq.n 0x200", Target: 0x200}
+	if b := newBranch(inst, 7, executed); !b.Executed || !b.Taken || b.NotTaken {
+		t.Errorf("branch %+v", b)
+	}
+	inst.Address = 0x300
+	if b := newBranch(inst, 7, executed); b.Executed || b.Taken {
+		t.Errorf("branch not executed %+v", b)
+	}
+}
+
+func TestWriteLCOV(t *testing.T) {
+	report := buildFixture(t)
+	report.Files[0].Branches = []Branch{
+		{Line: 25, Address: 0x40104e, Executed: true, Taken: true},
+		{Line: 25, Address: 0x401052},
+	}
+	report.Files[0].summarize()
+	var out bytes.Buffer
+	if err := WriteLCOV(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"TN:\nSF:/build/firmware.c\nFN:16,scale\nFN:22,average\nFN:31,_start\n",
+		"FNDA:0,scale\nFNDA:1,average\nFNDA:1,_start\nFNF:3\nFNH:2\n",
+		"BRDA:25,0,0,1\nBRDA:25,0,1,0\nBRDA:25,1,0,-\nBRDA:25,1,1,-\nBRF:4\nBRH:1\n",
+		"DA:17,0\n", "DA:25,1\n", "LF:14\nLH:7\nend_of_record\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("LCOV lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteCobertura(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteCobertura(&out, buildFixture(t)); err != nil {
+		t.Fatal(err)
+	}
+	var doc coberturaCoverage
+	if err := xml.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.LineRate != "0.5000" || doc.LinesValid != 14 || len(doc.Packages) != 1 || doc.Packages[0].Name != "build" {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	class := doc.Packages[0].Classes[0]
+	if class.Name != "firmware" || class.Filename != "/build/firmware.c" || len(class.Methods) != 3 || len(class.Lines) != 14 {
+		t.Errorf("unexpected class %+v", class)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteHTML(&out, "Coverage of firmware.elf", buildFixture(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<title>Coverage of firmware.elf</title>", "50.00% (7/14)", "function scale (line 16)", "lines 17, 18, 19, 20, 26, 28, 29"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("HTML lacks %q", want)
+		}
+	}
+}
+
+func TestDrcov(t *testing.T) {
+	blocks := []Block{{Start: 0x08000200, Size: 6}, {Start: 0x08000100, Size: 12}}
+	var out bytes.Buffer
+	if err := WriteDrcov(&out, "firmware.elf", blocks); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "0, 0x8000100, 0x8000206, 0x0, firmware.elf\nBB Table: 2 bbs\n") {
+		t.Errorf("unexpected header %q", out.String())
+	}
+	read, err := ReadDrcov(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || read[0] != blocks[1] || read[1] != blocks[0] {
+		t.Errorf("read %+v", read)
+	}
+
+	// DynamoRIO files have more columns and modules
+	header := "DRCOV VERSION: 2\nDRCOV FLAVOR: drcov\nModule Table: version 2, count 2\n" +
+		"Columns: id, base, end, entry, checksum, timestamp, path\n" +
+		" 0, 0x400000, 0x401000, 0x0, 0x0, 0x0, /bin/a, b\n" +
+		" 1, 0x7f0000, 0x7f1000, 0x0, 0x0, 0x0, /lib/c\n" +
+		"BB Table: 1 bbs\n"
+	data := append([]byte(header), 0x10, 0, 0, 0, 4, 0, 1, 0)
+	read, err = ReadDrcov(bytes.NewReader(data))
+	if err != nil || len(read) != 1 || read[0] != (Block{Start: 0x7f0010, Size: 4}) {
+		t.Errorf("read %+v, %v", read, err)
+	}
+	if _, err := ReadDrcov(bytes.NewReader(data[:len(data)-2])); err == nil {
+		t.Error("truncated BB table accepted")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// scale alone, then the run of buildFixture
+	first, err := Build(table, "", []Block{{Start: 0x401000, Size: 0x19}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := Merge(first, buildFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Summary.Functions != (Counter{Covered: 3, Total: 3, Percent: 100}) || merged.Summary.Lines.Covered <= 7 {
+		t.Errorf("merged summary %+v", merged.Summary)
+	}
+	if len(merged.Files) != 1 || len(merged.Files[0].Lines) != 14 {
+		t.Errorf("merged files %+v", merged.Files)
+	}
+
+	other := &Report{BuildID: "other"}
+	if _, err := Merge(first, other); err == nil {
+		t.Error("reports of different builds merged")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	base := &Report{BuildID: "base", Files: []File{
+		{Path: "/src/a.c", Lines: []Line{{1, true}, {2, false}}, Functions: []Function{{"f", 1, true}, {"g", 2, false}}},
+		{Path: "/src/old.c", Lines: []Line{{1, true}}},
+	}}
+	head := &Report{BuildID: "head", Files: []File{
+		{Path: "/src/a.c", Lines: []Line{{1, true}, {3, true}}, Functions: []Function{{"f", 1, true}, {"g", 3, true}}},
+		{Path: "/src/new.c", Lines: []Line{{1, false}}, Functions: []Function{{"h", 1, false}}},
+	}}
+	for _, report := range []*Report{base, head} {
+		for i := range report.Files {
+			report.Files[i].summarize()
+		}
+		report.summarize()
+	}
+
+	delta := Diff(base, head)
+	if delta.Summary.Lines.Delta != 0 || delta.Summary.Functions.Delta != 16.67 {
+		t.Errorf("summary %+v", delta.Summary)
+	}
+	var files []string
+	for _, f := range delta.Files {
+		files = append(files, f.Path+" "+f.Status)
+	}
+	if strings.Join(files, ", ") != "/src/a.c modified, /src/new.c added, /src/old.c removed" {
+		t.Errorf("files %v", files)
+	}
+	want := []FunctionChange{{"/src/a.c", "g", "missed", "covered"}, {"/src/new.c", "h", "absent", "missed"}}
+	if len(delta.Functions) != 2 || delta.Functions[0] != want[0] || delta.Functions[1] != want[1] {
+		t.Errorf("functions %+v", delta.Functions)
+	}
+}
@@ -0,0 +1,128 @@
+package coverage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// drcovEntrySize is the size of a binary entry of the BB table: the start
+// offset from the module base, the size and the module ID
+const drcovEntrySize = 8
+
+// ReadDrcov reads the basic blocks of a DrCov file, as written by the QEMU
+// drcov plugin and DynamoRIO. Block starts are offsets from the base of
+// their module.
+func ReadDrcov(r io.Reader) ([]Block, error) {
+	br := bufio.NewReader(r)
+	var (
+		columns []string
+		bases   = make(map[uint16]uint64)
+		count   = -1
+	)
+	for count < 0 {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("drcov header: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Columns:"):
+			for _, column := range strings.Split(strings.TrimPrefix(line, "Columns:"), ",") {
+				columns = append(columns, strings.TrimSpace(column))
+			}
+		case strings.HasPrefix(line, "BB Table:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "BB Table:"))
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("drcov BB table without a count")
+			}
+			if count, err = strconv.Atoi(fields[0]); err != nil || count < 0 {
+				return nil, fmt.Errorf("drcov BB table count %q", fields[0])
+			}
+		case columns != nil && line != "" && line[0] >= '0' && line[0] <= '9':
+			id, base, err := drcovModule(columns, line)
+			if err != nil {
+				return nil, err
+			}
+			bases[id] = base
+		}
+	}
+
+	blocks := make([]Block, 0, count)
+	entry := make([]byte, drcovEntrySize)
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(br, entry); err != nil {
+			return nil, fmt.Errorf("drcov BB table entry %d: %w", i, err)
+		}
+		start := binary.LittleEndian.Uint32(entry[0:4])
+		size := binary.LittleEndian.Uint16(entry[4:6])
+		module := binary.LittleEndian.Uint16(entry[6:8])
+		blocks = append(blocks, Block{Start: bases[module] + uint64(start), Size: uint32(size)})
+	}
+	return blocks, nil
+}
+
+// drcovModule parses a row of the module table
+func drcovModule(columns []string, line string) (uint16, uint64, error) {
+	values := strings.SplitN(line, ",", len(columns))
+	var (
+		id   uint64
+		base uint64
+		err  error
+	)
+	for i, column := range columns {
+		if i >= len(values) {
+			break
+		}
+		value := strings.TrimSpace(values[i])
+		switch column {
+		case "id":
+			id, err = strconv.ParseUint(value, 0, 16)
+		case "base", "start":
+			base, err = strconv.ParseUint(value, 0, 64)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("drcov module %q: %w", line, err)
+		}
+	}
+	return uint16(id), base, nil
+}
+
+// WriteDrcov writes blocks as a DrCov file with one module named module,
+// based at the lowest block
+func WriteDrcov(w io.Writer, module string, blocks []Block) error {
+	sorted := append([]Block(nil), blocks...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	var base, end uint64
+	if len(sorted) > 0 {
+		base = sorted[0].Start
+		last := sorted[len(sorted)-1]
+		end = last.Start + uint64(last.Size)
+	}
+	if end-base > math.MaxUint32 {
+		return fmt.Errorf("blocks span more than 4 GiB")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "DRCOV VERSION: 2\nDRCOV FLAVOR: virserver\n")
+	fmt.Fprintf(bw, "Module Table: version 2, count 1\nColumns: id, base, end, entry, path\n")
+	fmt.Fprintf(bw, "0, %#x, %#x, 0x0, %s\n", base, end, module)
+	fmt.Fprintf(bw, "BB Table: %d bbs\n", len(sorted))
+	entry := make([]byte, drcovEntrySize)
+	for _, block := range sorted {
+		size := block.Size
+		if size > math.MaxUint16 {
+			size = math.MaxUint16
+		}
+		binary.LittleEndian.PutUint32(entry[0:4], uint32(block.Start-base))
+		binary.LittleEndian.PutUint16(entry[4:6], uint16(size))
+		binary.LittleEndian.PutUint16(entry[6:8], 0)
+		bw.Write(entry)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,103 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// htmlReport is a self-contained page with the summary of the program and
+// of each file, listing the lines, functions and branch outcomes missed
+var htmlReport = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"level":  level,
+	"missed": missed,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.high { background: #c8f0c8; }
+.medium { background: #f8f0b0; }
+.low { background: #f4c0c0; }
+code { font-size: 90%; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Build ID <code>{{.Report.BuildID}}</code></p>
+<table>
+<tr><th></th><th>Lines</th><th>Functions</th><th>Branches</th></tr>
+<tr><td>Total</td>{{template "counters" .Report.Summary}}</tr>
+{{range .Report.Files}}<tr><td><a href="#{{.Path}}">{{.Path}}</a></td>{{template "counters" .Summary}}</tr>
+{{end}}</table>
+{{range .Report.Files}}
+<h2 id="{{.Path}}">{{.Path}}</h2>
+{{with missed .}}<details>
+<summary>Missed</summary>
+<ul>
+{{range .}}<li>{{.}}</li>
+{{end}}</ul>
+</details>{{else}}<p>Fully covered</p>{{end}}
+{{end}}
+</body>
+</html>
+{{define "counters"}}{{template "counter" .Lines}}{{template "counter" .Functions}}{{template "counter" .Branches}}{{end}}
+{{define "counter"}}<td class="{{level .}}">{{if .Total}}{{printf "%.2f" .Percent}}% ({{.Covered}}/{{.Total}}){{else}}-{{end}}</td>{{end}}
+`))
+
+// WriteHTML writes a report as an HTML page
+func WriteHTML(w io.Writer, title string, report *Report) error {
+	return htmlReport.Execute(w, struct {
+		Title  string
+		Report *Report
+	}{title, report})
+}
+
+// level rates a counter for its color
+func level(c Counter) string {
+	switch {
+	case c.Total == 0:
+		return ""
+	case c.Percent >= 90:
+		return "high"
+	case c.Percent >= 75:
+		return "medium"
+	}
+	return "low"
+}
+
+// missed describes the items of a file that were not covered
+func missed(f File) []string {
+	var items []string
+	for _, fn := range f.Functions {
+		if !fn.Covered {
+			items = append(items, fmt.Sprintf("function %s (line %d)", fn.Name, fn.Line))
+		}
+	}
+	var lines []string
+	for _, line := range f.Lines {
+		if !line.Covered {
+			lines = append(lines, fmt.Sprint(line.Line))
+		}
+	}
+	if len(lines) > 0 {
+		items = append(items, "lines "+strings.Join(lines, ", "))
+	}
+	for _, branch := range f.Branches {
+		switch {
+		case !branch.Executed:
+			items = append(items, fmt.Sprintf("branch at %#x (line %d) not executed", branch.Address, branch.Line))
+		case !branch.Taken:
+			items = append(items, fmt.Sprintf("branch at %#x (line %d) never taken", branch.Address, branch.Line))
+		case !branch.NotTaken:
+			items = append(items, fmt.Sprintf("branch at %#x (line %d) always taken", branch.Address, branch.Line))
+		}
+	}
+	return items
+}
@@ -0,0 +1,54 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteLCOV writes a report in the LCOV tracefile format read by genhtml
+// and most CI services. Blocks carry no execution counts, so covered items
+// have a count of 1. Each branch is an LCOV block with two branches, taken
+// and not taken.
+func WriteLCOV(w io.Writer, report *Report) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TN:")
+	for _, f := range report.Files {
+		fmt.Fprintf(bw, "SF:%s\n", f.Path)
+		for _, fn := range f.Functions {
+			fmt.Fprintf(bw, "FN:%d,%s\n", fn.Line, fn.Name)
+		}
+		for _, fn := range f.Functions {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", hits(fn.Covered), fn.Name)
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", f.Summary.Functions.Total, f.Summary.Functions.Covered)
+
+		blocks := make(map[int]int) // Next block number of each line
+		for _, branch := range f.Branches {
+			block := blocks[branch.Line]
+			blocks[branch.Line]++
+			for outcome, covered := range []bool{branch.Taken, branch.NotTaken} {
+				taken := "-"
+				if branch.Executed {
+					taken = fmt.Sprint(hits(covered))
+				}
+				fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", branch.Line, block, outcome, taken)
+			}
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", f.Summary.Branches.Total, f.Summary.Branches.Covered)
+
+		for _, line := range f.Lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line.Line, hits(line.Covered))
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\n", f.Summary.Lines.Total, f.Summary.Lines.Covered)
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+func hits(covered bool) int {
+	if covered {
+		return 1
+	}
+	return 0
+}
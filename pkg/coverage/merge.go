@@ -0,0 +1,239 @@
+package coverage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Merge combines reports of one build: an item is covered when any of the
+// reports covers it
+func Merge(reports ...*Report) (*Report, error) {
+	if len(reports) == 0 {
+		return nil, errors.New("no reports to merge")
+	}
+	buildID := reports[0].BuildID
+	files := make(map[string]*File)
+	for _, report := range reports {
+		if report.BuildID != buildID {
+			return nil, fmt.Errorf("cannot merge coverage of builds %s and %s", buildID, report.BuildID)
+		}
+		for _, f := range report.Files {
+			merged, ok := files[f.Path]
+			if !ok {
+				merged = &File{Path: f.Path}
+				files[f.Path] = merged
+			}
+			merged.merge(&f)
+		}
+	}
+
+	merged := &Report{BuildID: buildID}
+	for _, f := range files {
+		f.sort()
+		f.summarize()
+		merged.Files = append(merged.Files, *f)
+	}
+	merged.summarize()
+	return merged, nil
+}
+
+// merge adds the items of other, covered when either file covers them
+func (f *File) merge(other *File) {
+	lines := make(map[int]int, len(f.Lines))
+	for i, line := range f.Lines {
+		lines[line.Line] = i
+	}
+	for _, line := range other.Lines {
+		if i, ok := lines[line.Line]; ok {
+			f.Lines[i].Covered = f.Lines[i].Covered || line.Covered
+			continue
+		}
+		lines[line.Line] = len(f.Lines)
+		f.Lines = append(f.Lines, line)
+	}
+
+	type functionKey struct {
+		name string
+		line int
+	}
+	functions := make(map[functionKey]int, len(f.Functions))
+	for i, fn := range f.Functions {
+		functions[functionKey{fn.Name, fn.Line}] = i
+	}
+	for _, fn := range other.Functions {
+		key := functionKey{fn.Name, fn.Line}
+		if i, ok := functions[key]; ok {
+			f.Functions[i].Covered = f.Functions[i].Covered || fn.Covered
+			continue
+		}
+		functions[key] = len(f.Functions)
+		f.Functions = append(f.Functions, fn)
+	}
+
+	branches := make(map[uint64]int, len(f.Branches))
+	for i, branch := range f.Branches {
+		branches[branch.Address] = i
+	}
+	for _, branch := range other.Branches {
+		i, ok := branches[branch.Address]
+		if !ok {
+			branches[branch.Address] = len(f.Branches)
+			f.Branches = append(f.Branches, branch)
+			continue
+		}
+		merged := &f.Branches[i]
+		merged.Executed = merged.Executed || branch.Executed
+		merged.Taken = merged.Taken || branch.Taken
+		merged.NotTaken = merged.NotTaken || branch.NotTaken
+	}
+}
+
+// Change compares a counter in two builds
+type Change struct {
+	Base  Counter `json:"base"`
+	Head  Counter `json:"head"`
+	Delta float64 `json:"delta"` // Of the percentage, in points
+}
+
+func newChange(base, head Counter) Change {
+	return Change{Base: base, Head: head, Delta: math.Round(100*(head.Percent-base.Percent)) / 100}
+}
+
+// SummaryChange compares the counters of a file or a program in two builds
+type SummaryChange struct {
+	Lines     Change `json:"lines"`
+	Functions Change `json:"functions"`
+	Branches  Change `json:"branches"`
+}
+
+func newSummaryChange(base, head Summary) SummaryChange {
+	return SummaryChange{
+		Lines:     newChange(base.Lines, head.Lines),
+		Functions: newChange(base.Functions, head.Functions),
+		Branches:  newChange(base.Branches, head.Branches),
+	}
+}
+
+// FileChange is a source file whose coverage differs between two builds
+type FileChange struct {
+	Path    string        `json:"path"`
+	Status  string        `json:"status"` // added, removed or modified
+	Summary SummaryChange `json:"summary"`
+}
+
+// FunctionChange is a function whose coverage differs between two builds.
+// Functions are matched by file and name, as their lines move.
+type FunctionChange struct {
+	File string `json:"file"`
+	Name string `json:"name"`
+	Base string `json:"base"` // covered, missed or absent
+	Head string `json:"head"`
+}
+
+// Delta is the change of coverage from a base build to a head build
+type Delta struct {
+	BaseBuildID string           `json:"base_build_id"`
+	HeadBuildID string           `json:"head_build_id"`
+	Summary     SummaryChange    `json:"summary"`
+	Files       []FileChange     `json:"files"`
+	Functions   []FunctionChange `json:"functions"`
+}
+
+// Diff compares the coverage of two builds
+func Diff(base, head *Report) *Delta {
+	delta := &Delta{
+		BaseBuildID: base.BuildID,
+		HeadBuildID: head.BuildID,
+		Summary:     newSummaryChange(base.Summary, head.Summary),
+		Files:       []FileChange{},
+		Functions:   []FunctionChange{},
+	}
+
+	baseFiles := make(map[string]*File, len(base.Files))
+	for i := range base.Files {
+		baseFiles[base.Files[i].Path] = &base.Files[i]
+	}
+	headFiles := make(map[string]*File, len(head.Files))
+	for i := range head.Files {
+		headFiles[head.Files[i].Path] = &head.Files[i]
+	}
+	paths := make([]string, 0, len(baseFiles)+len(headFiles))
+	for path := range baseFiles {
+		paths = append(paths, path)
+	}
+	for path := range headFiles {
+		if baseFiles[path] == nil {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		before, after := baseFiles[path], headFiles[path]
+		change := FileChange{Path: path, Status: "modified"}
+		var baseSummary, headSummary Summary
+		switch {
+		case before == nil:
+			change.Status = "added"
+			headSummary = after.Summary
+		case after == nil:
+			change.Status = "removed"
+			baseSummary = before.Summary
+		default:
+			baseSummary, headSummary = before.Summary, after.Summary
+		}
+		change.Summary = newSummaryChange(baseSummary, headSummary)
+		if change.Status != "modified" || baseSummary != headSummary {
+			delta.Files = append(delta.Files, change)
+		}
+		delta.Functions = append(delta.Functions, diffFunctions(path, before, after)...)
+	}
+	return delta
+}
+
+// diffFunctions lists the functions of a file whose coverage changed
+func diffFunctions(path string, base, head *File) []FunctionChange {
+	states := func(f *File) map[string]string {
+		result := make(map[string]string)
+		if f == nil {
+			return result
+		}
+		for _, fn := range f.Functions {
+			if fn.Covered {
+				result[fn.Name] = "covered"
+			} else if result[fn.Name] == "" {
+				result[fn.Name] = "missed"
+			}
+		}
+		return result
+	}
+	before, after := states(base), states(head)
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []FunctionChange
+	for _, name := range names {
+		change := FunctionChange{File: path, Name: name, Base: before[name], Head: after[name]}
+		if change.Base == change.Head {
+			continue
+		}
+		if change.Base == "" {
+			change.Base = "absent"
+		}
+		if change.Head == "" {
+			change.Head = "absent"
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
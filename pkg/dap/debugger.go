@@ -0,0 +1,1288 @@
+package dap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// cpuThreadID is the thread of the CPU, which runs the current RTOS thread
+// when there is one. Switched-out RTOS threads get IDs from 2.
+const cpuThreadID = 1
+
+// Limits of stepping and transfers
+const (
+	maxLineSteps     = 10000
+	maxMemoryRequest = 64 * 1024
+	stepOverWait     = 30 * time.Second
+)
+
+// errUnsupported answers requests the server does not implement
+var errUnsupported = errors.New("not supported")
+
+// debugger is the state of one DAP client connection
+type debugger struct {
+	server    *Server
+	conn      Conn
+	ctx       context.Context
+	cancel    context.CancelFunc
+	sessionID string
+	// authenticate requires a control token in the attach request
+	authenticate bool
+
+	wmu sync.Mutex
+	seq int
+
+	mu          sync.Mutex
+	target      Target
+	leave       func() // Gives up control of the target
+	table       *symbols.Table
+	owned       map[string][]string // Session breakpoint IDs by request key
+	ids         map[string]int      // DAP IDs of session breakpoint IDs
+	nextID      int
+	busy        bool   // A step is running; its stops are not reported
+	reportAfter uint64 // Events up to this sequence number were reported
+	unsubscribe func()
+	program     string             // Program ID given at attach; latest ELF program when empty
+	threadIDs   map[uint64]int     // DAP IDs of RTOS thread IDs
+	frames      []*frameHandle     // Frame IDs are indexes + 1
+	containers  []*variablesHandle // Variables references are indexes + 1
+}
+
+// frameHandle is a stack frame handed out to the client until the target
+// resumes
+type frameHandle struct {
+	thread uint64         // RTOS thread ID, 0 for the CPU
+	level  int            // 0 for the innermost frame
+	frame  *symbols.Frame // nil for programs without debug information
+}
+
+// Scopes of a frame
+const (
+	scopeRegisters = "registers"
+	scopeLocals    = "locals"
+	scopeGlobals   = "globals"
+)
+
+// variablesHandle is a container of variables handed out to the client
+// until the target resumes: a scope of a frame, or the members of a value
+type variablesHandle struct {
+	frame  *frameHandle
+	scope  string
+	values []symbols.Value // Members, when scope is empty
+}
+
+func newDebugger(ctx context.Context, server *Server, conn Conn, sessionID string) *debugger {
+	ctx, cancel := context.WithCancel(ctx)
+	return &debugger{
+		server:    server,
+		conn:      conn,
+		ctx:       ctx,
+		cancel:    cancel,
+		sessionID: sessionID,
+		owned:     make(map[string][]string),
+		ids:       make(map[string]int),
+		threadIDs: make(map[uint64]int),
+	}
+}
+
+func (d *debugger) run() error {
+	go func() {
+		<-d.ctx.Done()
+		d.conn.Close()
+	}()
+
+	for {
+		data, err := d.conn.ReadMessage()
+		if err != nil {
+			if d.ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil || req.Type != "request" {
+			continue
+		}
+		if done := d.handle(&req); done {
+			return nil
+		}
+	}
+}
+
+// close removes the breakpoints of the client and stops the event stream
+func (d *debugger) close() {
+	d.mu.Lock()
+	target := d.target
+	var owned []string
+	for _, ids := range d.owned {
+		owned = append(owned, ids...)
+	}
+	d.owned = make(map[string][]string)
+	unsubscribe := d.unsubscribe
+	d.unsubscribe = nil
+	leave := d.leave
+	d.leave = nil
+	d.mu.Unlock()
+
+	if target != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, id := range owned {
+			target.RemoveBreakpoint(ctx, id)
+		}
+	}
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+	if leave != nil {
+		leave()
+	}
+	d.cancel()
+}
+
+// handle answers a request and reports whether the conversation ended
+func (d *debugger) handle(req *Request) bool {
+	var (
+		body interface{}
+		err  error
+	)
+	switch req.Command {
+	case "initialize":
+		body = &Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsInstructionBreakpoints:   true,
+			SupportsReadMemoryRequest:        true,
+			SupportsWriteMemoryRequest:       true,
+			SupportsDisassembleRequest:       true,
+			SupportsSteppingGranularity:      true,
+			SupportsSetVariable:              true,
+		}
+	case "attach", "launch":
+		err = d.attach(req.Arguments)
+		if err == nil {
+			d.respond(req, nil, nil)
+			d.sendEvent("initialized", nil)
+			return false
+		}
+	case "disconnect", "terminate":
+		d.respond(req, nil, nil)
+		return true
+	case "configurationDone":
+	case "setBreakpoints":
+		body, err = d.setBreakpoints(req.Arguments)
+	case "setFunctionBreakpoints":
+		body, err = d.setFunctionBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		body, err = d.setInstructionBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []Breakpoint{}}
+	case "threads":
+		body = map[string]interface{}{"threads": d.threads()}
+	case "stackTrace":
+		body, err = d.stackTrace(req.Arguments)
+	case "scopes":
+		body, err = d.scopes(req.Arguments)
+	case "variables":
+		body, err = d.variables(req.Arguments)
+	case "setVariable":
+		body, err = d.setVariable(req.Arguments)
+	case "readMemory":
+		body, err = d.readMemory(req.Arguments)
+	case "writeMemory":
+		body, err = d.writeMemory(req.Arguments)
+	case "disassemble":
+		body, err = d.disassemble(req.Arguments)
+	case "continue":
+		body, err = d.resume()
+	case "next", "stepIn":
+		err = d.step(req, req.Command == "stepIn")
+		if err == nil {
+			return false
+		}
+	default:
+		err = fmt.Errorf("%s: %w", req.Command, errUnsupported)
+	}
+
+	d.respond(req, body, err)
+	return false
+}
+
+func (d *debugger) respond(req *Request, body interface{}, err error) {
+	resp := &Response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	d.send(func(seq int) interface{} {
+		resp.Seq = seq
+		return resp
+	})
+}
+
+func (d *debugger) sendEvent(event string, body interface{}) {
+	d.send(func(seq int) interface{} {
+		return &Event{Seq: seq, Type: "event", Event: event, Body: body}
+	})
+}
+
+func (d *debugger) send(message func(seq int) interface{}) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	d.seq++
+	data, err := json.Marshal(message(d.seq))
+	if err != nil {
+		return
+	}
+	if err := d.conn.WriteMessage(data); err != nil {
+		d.cancel()
+	}
+}
+
+// attach binds the connection to a session and loads the symbols of its
+// ELF program when there is one
+func (d *debugger) attach(raw json.RawMessage) error {
+	var args struct {
+		SessionID string `json:"sessionId"`
+		Program   string `json:"program"` // Program ID; latest ELF program when empty
+		Token     string `json:"token"`   // GDB proxy control token of TCP clients
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return err
+		}
+	}
+	if _, _, err := d.attached(); err == nil {
+		return fmt.Errorf("already attached")
+	}
+	sessionID := d.sessionID
+	if d.authenticate {
+		token, err := d.server.resolve(args.Token)
+		if err != nil {
+			return err
+		}
+		if token.Mode != session.GDBControl {
+			return fmt.Errorf("DAP clients control the session; %s tokens are not accepted", token.Mode)
+		}
+		sessionID = token.SessionID
+		if args.SessionID != "" && args.SessionID != sessionID {
+			return fmt.Errorf("token is not valid for session %s", args.SessionID)
+		}
+	}
+	if sessionID == "" {
+		sessionID = args.SessionID
+	}
+	if sessionID == "" {
+		return fmt.Errorf("sessionId required")
+	}
+	if d.sessionID != "" && args.SessionID != "" && args.SessionID != d.sessionID {
+		return fmt.Errorf("connection is bound to session %s", d.sessionID)
+	}
+
+	target, release, err := d.server.open(d.ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	table, err := target.Symbols(d.ctx, args.Program)
+	if err != nil && args.Program != "" {
+		release()
+		return err
+	}
+	seq, err := target.EventSeq()
+	if err != nil {
+		release()
+		return err
+	}
+	_, events, unsubscribe, err := target.Subscribe(seq)
+	if err != nil {
+		release()
+		return err
+	}
+
+	d.mu.Lock()
+	d.sessionID = sessionID
+	d.target = target
+	d.leave = release
+	d.table = table
+	d.program = args.Program
+	d.reportAfter = seq
+	d.unsubscribe = unsubscribe
+	d.mu.Unlock()
+
+	go d.forwardEvents(events)
+	return nil
+}
+
+func (d *debugger) attached() (Target, *symbols.Table, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.target == nil {
+		return nil, nil, fmt.Errorf("not attached")
+	}
+	return d.target, d.table, nil
+}
+
+// forwardEvents reports stops that this client did not cause itself, such
+// as breakpoint hits after continue or stops requested over the REST API
+func (d *debugger) forwardEvents(events <-chan session.DebugEvent) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// Fell behind or the session was deleted
+				if !d.resubscribe(&events) {
+					d.sendEvent("terminated", nil)
+					d.cancel()
+					return
+				}
+				continue
+			}
+			d.report(event)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *debugger) resubscribe(events *<-chan session.DebugEvent) bool {
+	d.mu.Lock()
+	target, since := d.target, d.reportAfter
+	d.mu.Unlock()
+
+	backlog, next, unsubscribe, err := target.Subscribe(since)
+	if err != nil {
+		return false
+	}
+	d.mu.Lock()
+	d.unsubscribe = unsubscribe
+	d.mu.Unlock()
+	for _, event := range backlog {
+		d.report(event)
+	}
+	*events = next
+	return true
+}
+
+func (d *debugger) report(event session.DebugEvent) {
+	d.mu.Lock()
+	if d.busy || event.Seq <= d.reportAfter {
+		d.mu.Unlock()
+		return
+	}
+	d.reportAfter = event.Seq
+	d.mu.Unlock()
+
+	if event.Type == session.EventStop && event.StopEvent != nil {
+		d.reportStop(event.StopEvent)
+	}
+}
+
+// reportStop sends the stopped event, or exited and terminated
+func (d *debugger) reportStop(stop *adapters.StopEvent) {
+	d.release()
+	body := &StoppedEvent{ThreadID: cpuThreadID, AllThreadsStopped: true}
+	switch stop.Reason {
+	case adapters.StopRunning:
+		return
+	case adapters.StopExited:
+		d.sendEvent("exited", map[string]int{"exitCode": stop.Signal})
+		d.sendEvent("terminated", nil)
+		return
+	case adapters.StopBreakpoint:
+		body.Reason = "breakpoint"
+		d.mu.Lock()
+		if id, ok := d.ids[stop.BreakpointID]; ok {
+			body.HitBreakpointIDs = []int{id}
+		}
+		d.mu.Unlock()
+	case adapters.StopWatchpoint:
+		body.Reason = "data breakpoint"
+		body.Description = fmt.Sprintf("watchpoint at %#x", stop.WatchAddress)
+	case adapters.StopStep:
+		body.Reason = "step"
+	default:
+		if stop.Signal == 2 {
+			body.Reason = "pause"
+		} else {
+			body.Reason = "exception"
+			body.Description = fmt.Sprintf("signal %d", stop.Signal)
+		}
+	}
+	d.sendEvent("stopped", body)
+}
+
+// replace swaps the breakpoints set for a request key, e.g. a source file
+func (d *debugger) replace(key string, addresses []uint64, requested []Breakpoint) ([]Breakpoint, error) {
+	target, _, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	old := d.owned[key]
+	delete(d.owned, key)
+	d.mu.Unlock()
+	for _, id := range old {
+		if err := target.RemoveBreakpoint(d.ctx, id); err != nil && !errors.Is(err, session.ErrBreakpointNotFound) {
+			return nil, err
+		}
+		d.mu.Lock()
+		delete(d.ids, id)
+		d.mu.Unlock()
+	}
+
+	var ids []string
+	for i := range requested {
+		if !requested[i].Verified {
+			continue
+		}
+		bp := &adapters.Breakpoint{Address: addresses[i], Type: "software", Enabled: true}
+		if err := target.SetBreakpoint(d.ctx, bp); err != nil {
+			requested[i].Verified = false
+			requested[i].Message = err.Error()
+			continue
+		}
+		d.mu.Lock()
+		d.nextID++
+		d.ids[bp.ID] = d.nextID
+		requested[i].ID = d.nextID
+		d.mu.Unlock()
+		ids = append(ids, bp.ID)
+	}
+
+	d.mu.Lock()
+	if len(ids) > 0 {
+		d.owned[key] = ids
+	}
+	d.mu.Unlock()
+	return requested, nil
+}
+
+func (d *debugger) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      Source             `json:"source"`
+		Breakpoints []SourceBreakpoint `json:"breakpoints"`
+		Lines       []int              `json:"lines"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.Breakpoints == nil {
+		for _, line := range args.Lines {
+			args.Breakpoints = append(args.Breakpoints, SourceBreakpoint{Line: line})
+		}
+	}
+	_, table, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	file := args.Source.Path
+	if file == "" {
+		file = args.Source.Name
+	}
+	results := make([]Breakpoint, len(args.Breakpoints))
+	addresses := make([]uint64, len(args.Breakpoints))
+	for i, requested := range args.Breakpoints {
+		results[i] = Breakpoint{Source: &args.Source, Line: requested.Line}
+		if table == nil {
+			results[i].Message = "the session has no ELF program"
+			continue
+		}
+		line, found, err := lineAddresses(table, file, requested.Line)
+		if err != nil {
+			results[i].Message = err.Error()
+			continue
+		}
+		results[i].Verified = true
+		results[i].Line = line
+		results[i].InstructionReference = formatAddress(found)
+		addresses[i] = found
+	}
+
+	breakpoints, err := d.replace("source:"+file, addresses, results)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+// lineAddresses resolves a source line, matching IDE paths against the
+// compilation paths of the program by their trailing components
+func lineAddresses(table *symbols.Table, file string, line int) (int, uint64, error) {
+	file = strings.ReplaceAll(file, "\\", "/")
+	for candidate := file; candidate != ""; {
+		found, addresses, err := table.LineAddresses(candidate, line)
+		if err == nil {
+			return found, addresses[0], nil
+		}
+		i := strings.Index(candidate, "/")
+		if i < 0 {
+			break
+		}
+		candidate = candidate[i+1:]
+	}
+	return 0, 0, fmt.Errorf("no code at %s:%d", path.Base(file), line)
+}
+
+func (d *debugger) setFunctionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []FunctionBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	_, table, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Breakpoint, len(args.Breakpoints))
+	addresses := make([]uint64, len(args.Breakpoints))
+	for i, requested := range args.Breakpoints {
+		if table == nil {
+			results[i].Message = "the session has no ELF program"
+			continue
+		}
+		fn, ok := table.Function(requested.Name)
+		if !ok {
+			results[i].Message = fmt.Sprintf("function %s not found", requested.Name)
+			continue
+		}
+		addresses[i] = table.BreakpointAddress(fn)
+		loc := table.Lookup(addresses[i])
+		results[i] = Breakpoint{Verified: true, Line: loc.Line, InstructionReference: formatAddress(addresses[i])}
+		if loc.File != "" {
+			results[i].Source = sourceOf(loc.File)
+		}
+	}
+
+	breakpoints, err := d.replace("functions", addresses, results)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func (d *debugger) setInstructionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []InstructionBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	results := make([]Breakpoint, len(args.Breakpoints))
+	addresses := make([]uint64, len(args.Breakpoints))
+	for i, requested := range args.Breakpoints {
+		address, err := parseAddress(requested.InstructionReference)
+		if err != nil {
+			results[i].Message = err.Error()
+			continue
+		}
+		addresses[i] = uint64(int64(address) + requested.Offset)
+		results[i] = Breakpoint{Verified: true, InstructionReference: formatAddress(addresses[i])}
+	}
+
+	breakpoints, err := d.replace("instructions", addresses, results)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+// registerValues reads the general registers
+func (d *debugger) registerValues(target Target) (map[string]uint64, error) {
+	regs, err := target.ReadRegisters(d.ctx, "general")
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64, len(regs))
+	for name, value := range regs {
+		switch v := value.(type) {
+		case uint64:
+			values[name] = v
+		case float64:
+			values[name] = uint64(v)
+		case int:
+			values[name] = uint64(v)
+		case string:
+			if parsed, err := strconv.ParseUint(v, 0, 64); err == nil {
+				values[name] = parsed
+			}
+		}
+	}
+	return values, nil
+}
+
+// pc reads the program counter, named after the architecture of the
+// program when there is one
+func (d *debugger) pc(target Target, table *symbols.Table) (uint64, error) {
+	regs, err := d.registerValues(target)
+	if err != nil {
+		return 0, err
+	}
+	name := "pc"
+	if table != nil {
+		name = table.PCRegister()
+	}
+	pc, ok := regs[name]
+	if !ok {
+		return 0, fmt.Errorf("no %s register", name)
+	}
+	return pc, nil
+}
+
+// threads lists the CPU and, when the program runs an RTOS, its
+// switched-out threads. The CPU is named after the thread it runs.
+func (d *debugger) threads() []Thread {
+	cpu := Thread{ID: cpuThreadID, Name: "CPU"}
+	target, table, err := d.attached()
+	if err != nil || table == nil {
+		return []Thread{cpu}
+	}
+	threads, err := target.Threads(d.ctx, d.program)
+	if err != nil {
+		// No RTOS, or its kernel data cannot be read yet
+		return []Thread{cpu}
+	}
+
+	list := []Thread{cpu}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, thread := range threads.Threads {
+		name := thread.Name
+		if name == "" {
+			name = fmt.Sprintf("thread %#x", thread.ID)
+		}
+		if thread.Current {
+			list[0].Name = name
+			continue
+		}
+		id, ok := d.threadIDs[thread.ID]
+		if !ok {
+			id = cpuThreadID + 1 + len(d.threadIDs)
+			d.threadIDs[thread.ID] = id
+		}
+		list = append(list, Thread{ID: id, Name: fmt.Sprintf("%s (%s)", name, thread.State)})
+	}
+	return list
+}
+
+// rtosThread returns the RTOS thread ID of a DAP thread, 0 for the CPU
+func (d *debugger) rtosThread(id int) (uint64, error) {
+	if id == cpuThreadID {
+		return 0, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for thread, threadID := range d.threadIDs {
+		if threadID == id {
+			return thread, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown thread %d", id)
+}
+
+// release invalidates the frames and variables handed out, as the target
+// resumes or stopped
+func (d *debugger) release() {
+	d.mu.Lock()
+	d.frames = nil
+	d.containers = nil
+	d.mu.Unlock()
+}
+
+func (d *debugger) addFrame(frame *frameHandle) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.frames = append(d.frames, frame)
+	return len(d.frames)
+}
+
+func (d *debugger) frame(id int) (*frameHandle, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id <= 0 || id > len(d.frames) {
+		return nil, fmt.Errorf("unknown frame %d", id)
+	}
+	return d.frames[id-1], nil
+}
+
+func (d *debugger) addContainer(container *variablesHandle) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.containers = append(d.containers, container)
+	return len(d.containers)
+}
+
+func (d *debugger) container(reference int) (*variablesHandle, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if reference <= 0 || reference > len(d.containers) {
+		return nil, fmt.Errorf("unknown variables reference %d", reference)
+	}
+	return d.containers[reference-1], nil
+}
+
+// stackTrace unwinds the stack of a thread with the call frame information
+// of the program. Without debug information the pc is the only frame.
+func (d *debugger) stackTrace(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		ThreadID   int `json:"threadId"`
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	target, table, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+	thread, err := d.rtosThread(args.ThreadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if table == nil {
+		pc, err := d.pc(target, nil)
+		if err != nil {
+			return nil, err
+		}
+		frame := StackFrame{
+			ID:                          d.addFrame(&frameHandle{}),
+			Name:                        formatAddress(pc),
+			InstructionPointerReference: formatAddress(pc),
+			PresentationHint:            "subtle",
+		}
+		return map[string]interface{}{"stackFrames": []StackFrame{frame}, "totalFrames": 1}, nil
+	}
+
+	innermost, err := target.Frame(d.ctx, d.program, thread)
+	if err != nil {
+		return nil, err
+	}
+	depth := symbols.DefaultBacktraceDepth
+	if args.Levels > 0 {
+		depth = args.StartFrame + args.Levels
+	}
+	trace := table.Backtrace(innermost, depth)
+
+	frames := []StackFrame{}
+	for i := args.StartFrame; i >= 0 && i < len(trace.Frames); i++ {
+		sf := &trace.Frames[i]
+		frame := StackFrame{
+			ID:                          d.addFrame(&frameHandle{thread: thread, level: sf.Level, frame: sf.Frame}),
+			Name:                        sf.Function,
+			InstructionPointerReference: formatAddress(sf.Address),
+		}
+		if frame.Name == "" {
+			frame.Name = formatAddress(sf.Address)
+		}
+		if sf.File != "" {
+			frame.Source = sourceOf(sf.File)
+			frame.Line, frame.Column = sf.Line, sf.Column
+		} else {
+			frame.PresentationHint = "subtle"
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(trace.Frames)}, nil
+}
+
+// scopes lists the registers of a frame and, with debug information, its
+// locals and the globals
+func (d *debugger) scopes(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	frame, err := d.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := func(name, hint, kind string) Scope {
+		return Scope{Name: name, PresentationHint: hint, VariablesReference: d.addContainer(&variablesHandle{frame: frame, scope: kind})}
+	}
+	scopes := []Scope{scope("Registers", "registers", scopeRegisters)}
+	if frame.frame != nil {
+		globals := scope("Globals", "", scopeGlobals)
+		globals.Expensive = true
+		scopes = append(scopes, scope("Locals", "locals", scopeLocals), globals)
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (d *debugger) variables(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	target, table, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+	container, err := d.container(args.VariablesReference)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []symbols.Value
+	switch container.scope {
+	case scopeRegisters:
+		return d.registers(target, container.frame)
+	case scopeLocals:
+		values = table.ReadLocals(container.frame.frame)
+	case scopeGlobals:
+		values = table.ReadGlobals(container.frame.frame)
+	default:
+		values = container.values
+	}
+
+	variables := make([]Variable, 0, len(values))
+	for i := range values {
+		v := &values[i]
+		variable := Variable{Name: v.Name, Value: valueText(v), Type: v.Type}
+		if v.Address != 0 {
+			variable.MemoryReference = formatAddress(v.Address)
+		}
+		if len(v.Children) > 0 {
+			variable.VariablesReference = d.addContainer(&variablesHandle{frame: container.frame, values: v.Children})
+		}
+		variables = append(variables, variable)
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+// registers lists the registers of a frame: those the CPU reports for the
+// innermost frame of the CPU, else those known for the frame
+func (d *debugger) registers(target Target, frame *frameHandle) (interface{}, error) {
+	regs := map[string]uint64{}
+	if frame.thread == 0 && frame.level == 0 {
+		var err error
+		if regs, err = d.registerValues(target); err != nil {
+			return nil, err
+		}
+	} else if frame.frame != nil {
+		regs = frame.frame.Registers
+	}
+
+	names := make([]string, 0, len(regs))
+	for name := range regs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return registerLess(names[i], names[j]) })
+	variables := make([]Variable, 0, len(names))
+	for _, name := range names {
+		variables = append(variables, Variable{Name: name, Value: formatAddress(regs[name])})
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+// valueText shows a value the way GDB prints it: 65 'A', 0x402000
+// "virServer", an enumerator, or a summary of an aggregate
+func valueText(v *symbols.Value) string {
+	switch {
+	case v.Error != "":
+		return "<" + v.Error + ">"
+	case v.Value != nil:
+		value := fmt.Sprint(v.Value)
+		if v.Text == "" {
+			return value
+		}
+		if strings.HasPrefix(v.Text, "'") {
+			return value + " " + v.Text
+		}
+		if _, pointer := v.Value.(string); pointer {
+			return value + " " + strconv.Quote(v.Text)
+		}
+		return v.Text
+	case len(v.Children) > 0:
+		if v.Text != "" {
+			return v.Text
+		}
+		if strings.HasPrefix(v.Children[0].Name, "[") {
+			return fmt.Sprintf("[%d]", len(v.Children))
+		}
+		return "{...}"
+	case v.Text == "{...}" || v.Text == "[...]" || !strings.HasSuffix(v.Type, "]"):
+		// Beyond the nesting limit, or a float that is not a number
+		return v.Text
+	}
+	return strconv.Quote(v.Text) // Character array
+}
+
+// registerLess orders numbered registers numerically (r2 before r10) and
+// named ones after them
+func registerLess(a, b string) bool {
+	prefixA, numA, okA := splitRegister(a)
+	prefixB, numB, okB := splitRegister(b)
+	switch {
+	case okA && okB && prefixA == prefixB:
+		return numA < numB
+	case okA != okB:
+		return okA
+	}
+	return a < b
+}
+
+func splitRegister(name string) (string, int, bool) {
+	i := strings.IndexFunc(name, func(r rune) bool { return r >= '0' && r <= '9' })
+	if i <= 0 {
+		return name, 0, false
+	}
+	n, err := strconv.Atoi(name[i:])
+	if err != nil {
+		return name, 0, false
+	}
+	return name[:i], n, true
+}
+
+func (d *debugger) setVariable(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	target, _, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+	// Only the registers of the CPU can be written
+	container, err := d.container(args.VariablesReference)
+	if err != nil {
+		return nil, err
+	}
+	if container.scope != scopeRegisters || container.frame.thread != 0 || container.frame.level != 0 {
+		return nil, fmt.Errorf("setVariable: %w", errUnsupported)
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(args.Value), 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", args.Value)
+	}
+	if err := target.WriteRegister(d.ctx, args.Name, value); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"value": formatAddress(value)}, nil
+}
+
+func (d *debugger) readMemory(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int64  `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	target, _, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+	base, err := parseAddress(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	if args.Count < 0 || args.Count > maxMemoryRequest {
+		return nil, fmt.Errorf("count must be between 0 and %d", maxMemoryRequest)
+	}
+
+	address := uint64(int64(base) + args.Offset)
+	body := map[string]interface{}{"address": formatAddress(address)}
+	if args.Count == 0 {
+		return body, nil
+	}
+	data, err := target.ReadMemory(d.ctx, address, uint32(args.Count))
+	if err != nil {
+		body["unreadableBytes"] = args.Count
+		return body, nil
+	}
+	body["data"] = base64.StdEncoding.EncodeToString(data)
+	return body, nil
+}
+
+func (d *debugger) writeMemory(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int64  `json:"offset"`
+		Data            string `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	target, _, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+	base, err := parseAddress(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	if len(data) > maxMemoryRequest {
+		return nil, fmt.Errorf("at most %d bytes per write", maxMemoryRequest)
+	}
+	if err := target.WriteMemory(d.ctx, uint64(int64(base)+args.Offset), data); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"bytesWritten": len(data)}, nil
+}
+
+// instructionWidth is the unit of the raw disassembly
+const instructionWidth = 4
+
+func (d *debugger) disassemble(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int64  `json:"offset"`
+		InstructionOffset int64  `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+		ResolveSymbols    bool   `json:"resolveSymbols"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	target, table, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+	base, err := parseAddress(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	if args.InstructionCount <= 0 || args.InstructionCount*instructionWidth > maxMemoryRequest {
+		return nil, fmt.Errorf("invalid instructionCount %d", args.InstructionCount)
+	}
+
+	start := uint64(int64(base)+args.Offset+args.InstructionOffset*instructionWidth) &^ (instructionWidth - 1)
+	data, err := target.ReadMemory(d.ctx, start, uint32(args.InstructionCount*instructionWidth))
+	if err != nil {
+		data = nil
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if table != nil && table.ByteOrder == "big" {
+		order = binary.BigEndian
+	}
+
+	instructions := make([]DisassembledInstruction, 0, args.InstructionCount)
+	lastLine := 0
+	for i := 0; i < args.InstructionCount; i++ {
+		address := start + uint64(i*instructionWidth)
+		inst := DisassembledInstruction{Address: formatAddress(address)}
+		if off := i * instructionWidth; off+instructionWidth <= len(data) {
+			word := data[off : off+instructionWidth]
+			inst.InstructionBytes = fmt.Sprintf("% x", word)
+			inst.Instruction = fmt.Sprintf(".word 0x%08x", order.Uint32(word))
+		} else {
+			inst.Instruction = "??"
+			inst.PresentationHint = "invalid"
+		}
+		if table != nil {
+			loc := table.Lookup(address)
+			if loc.Function != "" && (args.ResolveSymbols || loc.Offset == 0) {
+				inst.Symbol = loc.Function
+			}
+			if loc.File != "" && loc.Line != lastLine {
+				inst.Location = sourceOf(loc.File)
+				inst.Line = loc.Line
+				lastLine = loc.Line
+			}
+		}
+		instructions = append(instructions, inst)
+	}
+	return map[string]interface{}{"instructions": instructions}, nil
+}
+
+// resume continues the target. The stop is reported by forwardEvents, like
+// stops caused by other clients.
+func (d *debugger) resume() (interface{}, error) {
+	target, _, err := d.attached()
+	if err != nil {
+		return nil, err
+	}
+	d.release()
+	ctx, cancel := context.WithCancel(d.ctx)
+	cancel() // Do not wait for the stop
+	if _, err := target.Continue(ctx); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"allThreadsContinued": true}, nil
+}
+
+// step runs next and stepIn: the response is sent right away and a stopped
+// event when the step completes
+func (d *debugger) step(req *Request, into bool) error {
+	var args struct {
+		Granularity string `json:"granularity"`
+	}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return err
+		}
+	}
+	target, table, err := d.attached()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if d.busy {
+		d.mu.Unlock()
+		return fmt.Errorf("a step is already running")
+	}
+	d.busy = true
+	d.mu.Unlock()
+
+	d.release()
+	d.respond(req, nil, nil)
+	go func() {
+		var (
+			stop *adapters.StopEvent
+			err  error
+		)
+		if args.Granularity == "instruction" || table == nil {
+			stop, err = target.StepInstruction(d.ctx)
+		} else {
+			stop, err = d.stepLine(target, table, into)
+		}
+
+		seq, _ := target.EventSeq()
+		d.mu.Lock()
+		d.busy = false
+		if seq > d.reportAfter {
+			d.reportAfter = seq
+		}
+		d.mu.Unlock()
+
+		if err != nil {
+			d.sendEvent("output", map[string]string{"category": "stderr", "output": fmt.Sprintf("step failed: %v\n", err)})
+			stop = &adapters.StopEvent{Reason: adapters.StopSignal}
+		}
+		d.reportStop(stop)
+	}()
+	return nil
+}
+
+// stepLine steps instructions until the source line changes. Calls are
+// stepped over by next, and by stepIn when the callee has no line
+// information, by running to the return address.
+func (d *debugger) stepLine(target Target, table *symbols.Table, into bool) (*adapters.StopEvent, error) {
+	pc, err := d.pc(target, table)
+	if err != nil {
+		return nil, err
+	}
+	start := table.Lookup(pc)
+
+	var stop *adapters.StopEvent
+	for i := 0; i < maxLineSteps; i++ {
+		stop, err = target.StepInstruction(d.ctx)
+		if err != nil || stop.Reason != adapters.StopStep {
+			return stop, err
+		}
+		loc := table.Lookup(stop.PC)
+
+		if fn, ok := table.FunctionAt(stop.PC); ok && fn.Low == stop.PC && loc.Function != start.Function {
+			if into && loc.Line != 0 {
+				return stop, nil
+			}
+			stop, err = d.runToReturn(target)
+			if errors.Is(err, errStopped) {
+				return stop, nil
+			}
+			if err != nil || stop.Reason != adapters.StopBreakpoint {
+				return stop, err
+			}
+			loc = table.Lookup(stop.PC)
+		}
+
+		if loc.Line != 0 && (loc.Line != start.Line || loc.File != start.File) {
+			if line, ok := table.LineAt(stop.PC); ok && line.Address == stop.PC {
+				return stop, nil
+			}
+		}
+	}
+	return stop, nil
+}
+
+// runToReturn continues to the return address of a function just entered
+func (d *debugger) runToReturn(target Target) (*adapters.StopEvent, error) {
+	regs, err := d.registerValues(target)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := regs["lr"]
+	if !ok {
+		ret, ok = regs["ra"]
+	}
+	if !ok {
+		ret, ok = regs["x30"]
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot step over calls: no link register")
+	}
+	ret &^= 1 // Thumb bit
+
+	bp := &adapters.Breakpoint{Address: ret, Type: "software", Enabled: true}
+	if err := target.SetBreakpoint(d.ctx, bp); err != nil {
+		return nil, err
+	}
+	defer target.RemoveBreakpoint(context.Background(), bp.ID)
+
+	ctx, cancel := context.WithTimeout(d.ctx, stepOverWait)
+	defer cancel()
+	stop, err := target.Continue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if stop.Reason == adapters.StopBreakpoint && stop.BreakpointID != bp.ID && stop.PC != ret {
+		// Another breakpoint hit inside the callee
+		return &adapters.StopEvent{Reason: adapters.StopBreakpoint, PC: stop.PC, BreakpointID: stop.BreakpointID}, errStopped
+	}
+	return stop, nil
+}
+
+// errStopped ends a step at a breakpoint hit on the way
+var errStopped = errors.New("stopped at a breakpoint")
+
+func sourceOf(file string) *Source {
+	return &Source{Name: path.Base(file), Path: file}
+}
+
+func formatAddress(address uint64) string {
+	return fmt.Sprintf("0x%08x", address)
+}
+
+func parseAddress(text string) (uint64, error) {
+	address, err := strconv.ParseUint(strings.TrimSpace(text), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory reference %q", text)
+	}
+	return address, nil
+}
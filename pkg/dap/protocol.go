@@ -0,0 +1,194 @@
+// Package dap implements a Debug Adapter Protocol server for virServer
+// sessions, so IDEs such as VS Code can debug simulated firmware.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Conn carries DAP messages, one JSON document each
+type Conn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(data []byte) error
+	Close() error
+}
+
+// maxMessageSize bounds incoming messages
+const maxMessageSize = 16 << 20
+
+// streamConn frames messages with Content-Length headers, as DAP does over
+// stdio and TCP
+type streamConn struct {
+	r   *bufio.Reader
+	w   io.Writer
+	c   io.Closer
+	wmu sync.Mutex
+}
+
+// NewStreamConn frames messages over a byte stream such as a TCP connection
+func NewStreamConn(rwc io.ReadWriteCloser) Conn {
+	return &streamConn{r: bufio.NewReader(rwc), w: rwc, c: rwc}
+}
+
+func (c *streamConn) ReadMessage() ([]byte, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (c *streamConn) WriteMessage(data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err := c.w.Write(data)
+	return err
+}
+
+func (c *streamConn) Close() error {
+	return c.c.Close()
+}
+
+// Request is a client request
+type Request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// Response answers a request
+type Response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// Event is sent by the server without a request
+type Event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Capabilities of the server, sent in the initialize response
+type Capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsFunctionBreakpoints      bool `json:"supportsFunctionBreakpoints"`
+	SupportsInstructionBreakpoints   bool `json:"supportsInstructionBreakpoints"`
+	SupportsReadMemoryRequest        bool `json:"supportsReadMemoryRequest"`
+	SupportsWriteMemoryRequest       bool `json:"supportsWriteMemoryRequest"`
+	SupportsDisassembleRequest       bool `json:"supportsDisassembleRequest"`
+	SupportsSteppingGranularity      bool `json:"supportsSteppingGranularity"`
+	SupportsSetVariable              bool `json:"supportsSetVariable"`
+}
+
+// Source is a source file
+type Source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// SourceBreakpoint is a breakpoint requested on a source line
+type SourceBreakpoint struct {
+	Line   int `json:"line"`
+	Column int `json:"column,omitempty"`
+}
+
+// FunctionBreakpoint is a breakpoint requested on a function
+type FunctionBreakpoint struct {
+	Name string `json:"name"`
+}
+
+// InstructionBreakpoint is a breakpoint requested on an address
+type InstructionBreakpoint struct {
+	InstructionReference string `json:"instructionReference"`
+	Offset               int64  `json:"offset,omitempty"`
+}
+
+// Breakpoint reports the state of a requested breakpoint
+type Breakpoint struct {
+	ID                   int     `json:"id,omitempty"`
+	Verified             bool    `json:"verified"`
+	Message              string  `json:"message,omitempty"`
+	Source               *Source `json:"source,omitempty"`
+	Line                 int     `json:"line,omitempty"`
+	InstructionReference string  `json:"instructionReference,omitempty"`
+}
+
+// Thread is a thread of the debuggee
+type Thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// StackFrame is a frame of a stack trace
+type StackFrame struct {
+	ID                          int     `json:"id"`
+	Name                        string  `json:"name"`
+	Source                      *Source `json:"source,omitempty"`
+	Line                        int     `json:"line"`
+	Column                      int     `json:"column"`
+	InstructionPointerReference string  `json:"instructionPointerReference,omitempty"`
+	PresentationHint            string  `json:"presentationHint,omitempty"`
+}
+
+// Scope is a named container of variables
+type Scope struct {
+	Name               string `json:"name"`
+	PresentationHint   string `json:"presentationHint,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+// Variable is a named value
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+// DisassembledInstruction is a line of a disassembly
+type DisassembledInstruction struct {
+	Address          string  `json:"address"`
+	InstructionBytes string  `json:"instructionBytes,omitempty"`
+	Instruction      string  `json:"instruction"`
+	Symbol           string  `json:"symbol,omitempty"`
+	Location         *Source `json:"location,omitempty"`
+	Line             int     `json:"line,omitempty"`
+	PresentationHint string  `json:"presentationHint,omitempty"`
+}
+
+// StoppedEvent is the body of the stopped event
+type StoppedEvent struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	HitBreakpointIDs  []int  `json:"hitBreakpointIds,omitempty"`
+}
@@ -0,0 +1,194 @@
+package dap
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"runtime/debug"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/rtos"
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// Target is the debugged session as seen by a DAP client
+type Target interface {
+	SetBreakpoint(ctx context.Context, bp *adapters.Breakpoint) error
+	RemoveBreakpoint(ctx context.Context, id string) error
+	StepInstruction(ctx context.Context) (*adapters.StopEvent, error)
+	Continue(ctx context.Context) (*adapters.StopEvent, error)
+	ReadRegisters(ctx context.Context, scope string) (map[string]interface{}, error)
+	WriteRegister(ctx context.Context, name string, value interface{}) error
+	ReadMemory(ctx context.Context, address uint64, size uint32) ([]byte, error)
+	WriteMemory(ctx context.Context, address uint64, data []byte) error
+	Symbols(ctx context.Context, programID string) (*symbols.Table, error)
+	// Frame returns the innermost frame of the CPU (threadID 0) or of an
+	// RTOS thread
+	Frame(ctx context.Context, programID string, threadID uint64) (*symbols.Frame, error)
+	Threads(ctx context.Context, programID string) (*rtos.Threads, error)
+	Subscribe(since uint64) ([]session.DebugEvent, <-chan session.DebugEvent, func(), error)
+	EventSeq() (uint64, error)
+}
+
+// Server serves DAP clients of virServer sessions. A DAP client controls its
+// session, so it counts as the one controlling client a session may have.
+type Server struct {
+	// open takes control of a session; release gives it up
+	open    func(ctx context.Context, sessionID string) (target Target, release func(), err error)
+	resolve func(token string) (*session.GDBToken, error)
+}
+
+// NewServer creates a DAP server for the sessions of a session service
+func NewServer(sessions *session.Service) *Server {
+	return &Server{
+		open: func(ctx context.Context, sessionID string) (Target, func(), error) {
+			if _, _, err := sessions.GetAdapter(sessionID); err != nil {
+				return nil, nil, err
+			}
+			release, err := sessions.JoinController(sessionID)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &sessionTarget{sessions: sessions, id: sessionID}, release, nil
+		},
+		resolve: sessions.ResolveGDBToken,
+	}
+}
+
+// Serve runs a DAP conversation with an authenticated client until it
+// disconnects. When sessionID is empty the client names the session in its
+// attach or launch request ("sessionId").
+func (s *Server) Serve(ctx context.Context, conn Conn, sessionID string) error {
+	d := newDebugger(ctx, s, conn, sessionID)
+	defer d.close()
+	return d.run()
+}
+
+// ListenAndServe accepts DAP clients over TCP until ctx is done. TCP clients
+// authenticate with a control token of the GDB proxy ("token" in the attach
+// or launch request), which also names the session.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go func() {
+			// A client must not take the server down with it
+			defer func() {
+				if r := recover(); r != nil {
+					conn.Close()
+					log.Printf("DAP client %s: panic: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+				}
+			}()
+			d := newDebugger(ctx, s, NewStreamConn(conn), "")
+			d.authenticate = true
+			defer d.close()
+			if err := d.run(); err != nil {
+				log.Printf("DAP client %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// sessionTarget debugs a session through the session service
+type sessionTarget struct {
+	sessions *session.Service
+	id       string
+}
+
+func (t *sessionTarget) adapter() (adapters.BackendAdapter, string, error) {
+	return t.sessions.GetAdapter(t.id)
+}
+
+func (t *sessionTarget) SetBreakpoint(ctx context.Context, bp *adapters.Breakpoint) error {
+	return t.sessions.SetBreakpoint(ctx, t.id, bp)
+}
+
+func (t *sessionTarget) RemoveBreakpoint(ctx context.Context, id string) error {
+	return t.sessions.RemoveBreakpoint(ctx, t.id, id)
+}
+
+func (t *sessionTarget) StepInstruction(ctx context.Context) (*adapters.StopEvent, error) {
+	return t.sessions.StepInstruction(ctx, t.id)
+}
+
+func (t *sessionTarget) Continue(ctx context.Context) (*adapters.StopEvent, error) {
+	return t.sessions.Continue(ctx, t.id)
+}
+
+func (t *sessionTarget) ReadRegisters(ctx context.Context, scope string) (map[string]interface{}, error) {
+	adapter, instanceID, err := t.adapter()
+	if err != nil {
+		return nil, err
+	}
+	return adapter.ReadRegisters(ctx, instanceID, scope)
+}
+
+func (t *sessionTarget) WriteRegister(ctx context.Context, name string, value interface{}) error {
+	adapter, instanceID, err := t.adapter()
+	if err != nil {
+		return err
+	}
+	return adapter.WriteRegister(ctx, instanceID, name, value)
+}
+
+func (t *sessionTarget) ReadMemory(ctx context.Context, address uint64, size uint32) ([]byte, error) {
+	adapter, instanceID, err := t.adapter()
+	if err != nil {
+		return nil, err
+	}
+	return adapter.ReadMemory(ctx, instanceID, address, size)
+}
+
+func (t *sessionTarget) WriteMemory(ctx context.Context, address uint64, data []byte) error {
+	adapter, instanceID, err := t.adapter()
+	if err != nil {
+		return err
+	}
+	return adapter.WriteMemory(ctx, instanceID, address, data)
+}
+
+func (t *sessionTarget) Symbols(ctx context.Context, programID string) (*symbols.Table, error) {
+	table, _, err := t.sessions.Symbols(ctx, t.id, programID)
+	return table, err
+}
+
+func (t *sessionTarget) Frame(ctx context.Context, programID string, threadID uint64) (*symbols.Frame, error) {
+	if threadID == 0 {
+		_, frame, err := t.sessions.Frame(ctx, t.id, programID)
+		return frame, err
+	}
+	_, frame, err := t.sessions.ThreadFrame(ctx, t.id, programID, threadID)
+	return frame, err
+}
+
+func (t *sessionTarget) Threads(ctx context.Context, programID string) (*rtos.Threads, error) {
+	return t.sessions.Threads(ctx, t.id, programID)
+}
+
+func (t *sessionTarget) Subscribe(since uint64) ([]session.DebugEvent, <-chan session.DebugEvent, func(), error) {
+	return t.sessions.SubscribeEvents(t.id, since)
+}
+
+func (t *sessionTarget) EventSeq() (uint64, error) {
+	return t.sessions.EventSeq(t.id)
+}
@@ -0,0 +1,654 @@
+package dap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/rtos"
+	"github.com/forfire912/virServer/pkg/session"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// fakeTarget runs the firmware fixture of pkg/symbols line by line: every
+// instruction step advances the pc by one line table row
+type fakeTarget struct {
+	mu          sync.Mutex
+	table       *symbols.Table
+	pc          uint64
+	sp, fp      uint64
+	stack       map[uint64]byte // Memory read as it is, other bytes follow their address
+	threads     *rtos.Threads   // nil without an RTOS
+	breakpoints map[string]*adapters.Breakpoint
+	nextID      int
+	events      chan session.DebugEvent
+	seq         uint64
+}
+
+func newFakeTarget(t *testing.T) *fakeTarget {
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	return &fakeTarget{
+		table:       table,
+		pc:          table.Entry,
+		stack:       make(map[uint64]byte),
+		breakpoints: make(map[string]*adapters.Breakpoint),
+		events:      make(chan session.DebugEvent, 8),
+	}
+}
+
+func (f *fakeTarget) SetBreakpoint(ctx context.Context, bp *adapters.Breakpoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	bp.ID = fmt.Sprintf("bp-%d", f.nextID)
+	f.breakpoints[bp.ID] = bp
+	return nil
+}
+
+func (f *fakeTarget) RemoveBreakpoint(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.breakpoints[id]; !ok {
+		return session.ErrBreakpointNotFound
+	}
+	delete(f.breakpoints, id)
+	return nil
+}
+
+func (f *fakeTarget) StepInstruction(ctx context.Context) (*adapters.StopEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pc++
+	for {
+		if line, ok := f.table.LineAt(f.pc); ok && line.Address == f.pc {
+			break
+		}
+		f.pc++
+	}
+	return &adapters.StopEvent{Reason: adapters.StopStep, PC: f.pc}, nil
+}
+
+// Continue stops right away at the first breakpoint
+func (f *fakeTarget) Continue(ctx context.Context) (*adapters.StopEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, bp := range f.breakpoints {
+		f.pc = bp.Address
+		f.seq++
+		stop := &adapters.StopEvent{Reason: adapters.StopBreakpoint, PC: bp.Address, BreakpointID: bp.ID}
+		f.events <- session.DebugEvent{Seq: f.seq, Type: session.EventStop, StopEvent: stop}
+		return stop, nil
+	}
+	return &adapters.StopEvent{Reason: adapters.StopRunning}, nil
+}
+
+func (f *fakeTarget) ReadRegisters(ctx context.Context, scope string) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return map[string]interface{}{"rip": f.pc, "rsp": f.sp, "rbp": f.fp, "r10": uint64(10), "r2": uint64(2)}, nil
+}
+
+func (f *fakeTarget) WriteRegister(ctx context.Context, name string, value interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if name == "rip" {
+		f.pc = value.(uint64)
+	}
+	return nil
+}
+
+func (f *fakeTarget) ReadMemory(ctx context.Context, address uint64, size uint32) ([]byte, error) {
+	data := make([]byte, size)
+	for i := range data {
+		b, ok := f.stack[address+uint64(i)]
+		if !ok {
+			b = byte(address) + byte(i)
+		}
+		data[i] = b
+	}
+	return data, nil
+}
+
+// put stores a little-endian value on the stack
+func (f *fakeTarget) put(address, value uint64, size int) {
+	for i := 0; i < size; i++ {
+		f.stack[address+uint64(i)] = byte(value >> (8 * i))
+	}
+}
+
+func (f *fakeTarget) WriteMemory(ctx context.Context, address uint64, data []byte) error {
+	return nil
+}
+
+func (f *fakeTarget) Symbols(ctx context.Context, programID string) (*symbols.Table, error) {
+	return f.table, nil
+}
+
+func (f *fakeTarget) Frame(ctx context.Context, programID string, threadID uint64) (*symbols.Frame, error) {
+	f.mu.Lock()
+	regs := map[string]uint64{"rip": f.pc, "rsp": f.sp, "rbp": f.fp}
+	f.mu.Unlock()
+	if threadID != 0 {
+		thread, ok := f.threads.Find(threadID)
+		if !ok {
+			return nil, session.ErrThreadNotFound
+		}
+		regs = thread.Registers
+	}
+	return f.table.NewFrame(regs, func(address uint64, size int) ([]byte, error) {
+		return f.ReadMemory(ctx, address, uint32(size))
+	})
+}
+
+func (f *fakeTarget) Threads(ctx context.Context, programID string) (*rtos.Threads, error) {
+	if f.threads == nil {
+		return nil, rtos.ErrNoRTOS
+	}
+	return f.threads, nil
+}
+
+func (f *fakeTarget) Subscribe(since uint64) ([]session.DebugEvent, <-chan session.DebugEvent, func(), error) {
+	return nil, f.events, func() {}, nil
+}
+
+func (f *fakeTarget) EventSeq() (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seq, nil
+}
+
+// client drives a DAP conversation over an in-memory connection
+type client struct {
+	t    *testing.T
+	conn Conn
+	seq  int
+}
+
+// newTestServer serves session-1 on target. Tokens are "control" and
+// "observe", both for session-1.
+func newTestServer(target *fakeTarget) *Server {
+	var (
+		mu         sync.Mutex
+		controlled bool
+	)
+	return &Server{
+		open: func(ctx context.Context, sessionID string) (Target, func(), error) {
+			if sessionID != "session-1" {
+				return nil, nil, fmt.Errorf("session not found: %s", sessionID)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if controlled {
+				return nil, nil, session.ErrGDBControlled
+			}
+			controlled = true
+			return target, func() {
+				mu.Lock()
+				controlled = false
+				mu.Unlock()
+			}, nil
+		},
+		resolve: func(token string) (*session.GDBToken, error) {
+			if token != session.GDBControl && token != session.GDBObserve {
+				return nil, session.ErrGDBTokenInvalid
+			}
+			return &session.GDBToken{Token: token, SessionID: "session-1", Mode: token}, nil
+		},
+	}
+}
+
+func startServer(t *testing.T, target *fakeTarget) *client {
+	return connect(t, newTestServer(target), false)
+}
+
+// connect starts a conversation with server; authenticated connections
+// stand for TCP clients
+func connect(t *testing.T, server *Server, authenticate bool) *client {
+	local, remote := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d := newDebugger(ctx, server, NewStreamConn(remote), "")
+		d.authenticate = authenticate
+		d.run()
+		d.close()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		local.Close()
+		<-done
+	})
+	return &client{t: t, conn: NewStreamConn(local)}
+}
+
+// message is a response or an event
+type message struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func (c *client) next() message {
+	c.t.Helper()
+	type result struct {
+		data []byte
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		data, err := c.conn.ReadMessage()
+		read <- result{data, err}
+	}()
+	select {
+	case r := <-read:
+		if r.err != nil {
+			c.t.Fatalf("read: %v", r.err)
+		}
+		var m message
+		if err := json.Unmarshal(r.data, &m); err != nil {
+			c.t.Fatalf("decode %s: %v", r.data, err)
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for a message")
+	}
+	return message{}
+}
+
+// request sends a request and returns its response, decoding the body into
+// body when given
+func (c *client) request(command string, args interface{}, body interface{}) message {
+	c.t.Helper()
+	c.seq++
+	raw, _ := json.Marshal(args)
+	data, _ := json.Marshal(&Request{Seq: c.seq, Type: "request", Command: command, Arguments: raw})
+	if err := c.conn.WriteMessage(data); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+	for {
+		m := c.next()
+		if m.Type != "response" || m.RequestSeq != c.seq {
+			continue
+		}
+		if body != nil && m.Success {
+			if err := json.Unmarshal(m.Body, body); err != nil {
+				c.t.Fatalf("%s body: %v", command, err)
+			}
+		}
+		return m
+	}
+}
+
+func (c *client) event(name string, body interface{}) {
+	c.t.Helper()
+	for {
+		m := c.next()
+		if m.Type == "event" && m.Event == name {
+			if body != nil {
+				json.Unmarshal(m.Body, body)
+			}
+			return
+		}
+	}
+}
+
+func attach(t *testing.T, target *fakeTarget) *client {
+	c := startServer(t, target)
+	var caps Capabilities
+	if resp := c.request("initialize", map[string]string{"adapterID": "virserver"}, &caps); !resp.Success || !caps.SupportsDisassembleRequest {
+		t.Fatalf("initialize: %+v %+v", resp, caps)
+	}
+	if resp := c.request("attach", map[string]string{"sessionId": "session-1"}, nil); !resp.Success {
+		t.Fatalf("attach: %s", resp.Message)
+	}
+	c.event("initialized", nil)
+	return c
+}
+
+func TestStreamConn(t *testing.T) {
+	local, remote := net.Pipe()
+	a, b := NewStreamConn(local), NewStreamConn(remote)
+	defer a.Close()
+	defer b.Close()
+
+	go a.WriteMessage([]byte(`{"seq":1}`))
+	data, err := b.ReadMessage()
+	if err != nil || string(data) != `{"seq":1}` {
+		t.Fatalf("ReadMessage = %q, %v", data, err)
+	}
+
+	go local.Write([]byte("Content-Length: nope\r\n\r\n"))
+	if _, err := b.ReadMessage(); err == nil {
+		t.Error("expected error for an invalid Content-Length")
+	}
+}
+
+func TestAttach(t *testing.T) {
+	c := startServer(t, newFakeTarget(t))
+	if resp := c.request("threads", nil, nil); !resp.Success {
+		t.Fatalf("threads: %s", resp.Message)
+	}
+	if resp := c.request("stackTrace", map[string]int{"threadId": 1}, nil); resp.Success {
+		t.Error("stackTrace succeeded before attach")
+	}
+	if resp := c.request("attach", map[string]string{"sessionId": "missing"}, nil); resp.Success {
+		t.Error("attach to an unknown session succeeded")
+	}
+	if resp := c.request("pause", nil, nil); resp.Success {
+		t.Error("unsupported request succeeded")
+	}
+}
+
+func TestAttachControl(t *testing.T) {
+	server := newTestServer(newFakeTarget(t))
+
+	// TCP clients need a control token, which names the session
+	tcp := connect(t, server, true)
+	for _, args := range []map[string]string{
+		{"sessionId": "session-1"},
+		{"token": "nope"},
+		{"token": session.GDBObserve},
+		{"token": session.GDBControl, "sessionId": "session-2"},
+	} {
+		if resp := tcp.request("attach", args, nil); resp.Success {
+			t.Errorf("attach %v succeeded", args)
+		}
+	}
+	if resp := tcp.request("attach", map[string]string{"token": session.GDBControl}, nil); !resp.Success {
+		t.Fatalf("attach with a token: %s", resp.Message)
+	}
+	tcp.event("initialized", nil)
+	if resp := tcp.request("attach", map[string]string{"token": session.GDBControl}, nil); resp.Success {
+		t.Error("second attach on a connection succeeded")
+	}
+
+	// A session has one controlling client
+	other := connect(t, server, false)
+	if resp := other.request("attach", map[string]string{"sessionId": "session-1"}, nil); resp.Success {
+		t.Error("second controller attached")
+	}
+	tcp.request("disconnect", nil, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := other.request("attach", map[string]string{"sessionId": "session-1"}, nil)
+		if resp.Success {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("attach after the controller left: %s", resp.Message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	target := newFakeTarget(t)
+	c := attach(t, target)
+
+	var result struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      Source{Path: "C:\\work\\firmware.c"},
+		"breakpoints": []SourceBreakpoint{{Line: 26}, {Line: 34}, {Line: 500}},
+	}, &result)
+	bps := result.Breakpoints
+	if len(bps) != 3 || !bps[0].Verified || bps[0].InstructionReference != "0x00401035" {
+		t.Fatalf("unexpected breakpoints %+v", bps)
+	}
+	if !bps[1].Verified || bps[1].Line != 35 || bps[2].Verified {
+		t.Errorf("unexpected breakpoints %+v", bps)
+	}
+	if len(target.breakpoints) != 2 {
+		t.Errorf("target has %d breakpoints, want 2", len(target.breakpoints))
+	}
+
+	c.request("setFunctionBreakpoints", map[string]interface{}{
+		"breakpoints": []FunctionBreakpoint{{Name: "scale"}, {Name: "missing"}},
+	}, &result)
+	if bps := result.Breakpoints; len(bps) != 2 || !bps[0].Verified || bps[0].Line != 18 || bps[1].Verified {
+		t.Errorf("unexpected function breakpoints %+v", bps)
+	}
+
+	// Replacing the breakpoints of a source removes the old ones
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      Source{Path: "/src/firmware.c"},
+		"breakpoints": []SourceBreakpoint{},
+	}, nil)
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      Source{Path: "C:\\work\\firmware.c"},
+		"breakpoints": []SourceBreakpoint{},
+	}, nil)
+	if len(target.breakpoints) != 1 {
+		t.Errorf("target has %d breakpoints, want 1", len(target.breakpoints))
+	}
+
+	// Continue reports the hit through the event stream
+	if resp := c.request("continue", map[string]int{"threadId": 1}, nil); !resp.Success {
+		t.Fatalf("continue: %s", resp.Message)
+	}
+	var stopped StoppedEvent
+	c.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" || len(stopped.HitBreakpointIDs) != 1 {
+		t.Errorf("unexpected stopped event %+v", stopped)
+	}
+
+	c.request("disconnect", nil, nil)
+}
+
+// stackFrames requests the stack trace of a thread
+func (c *client) stackFrames(threadID int) []StackFrame {
+	c.t.Helper()
+	var trace struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	if resp := c.request("stackTrace", map[string]int{"threadId": threadID}, &trace); !resp.Success {
+		c.t.Fatalf("stackTrace: %s", resp.Message)
+	}
+	return trace.StackFrames
+}
+
+// scopes requests the variables references of the scopes of a frame by name
+func (c *client) scopes(frameID int) map[string]int {
+	c.t.Helper()
+	var result struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	if resp := c.request("scopes", map[string]int{"frameId": frameID}, &result); !resp.Success {
+		c.t.Fatalf("scopes: %s", resp.Message)
+	}
+	references := make(map[string]int)
+	for _, scope := range result.Scopes {
+		references[scope.Name] = scope.VariablesReference
+	}
+	return references
+}
+
+func (c *client) variables(reference int) []Variable {
+	c.t.Helper()
+	var result struct {
+		Variables []Variable `json:"variables"`
+	}
+	if resp := c.request("variables", map[string]int{"variablesReference": reference}, &result); !resp.Success {
+		c.t.Fatalf("variables: %s", resp.Message)
+	}
+	return result.Variables
+}
+
+func TestInspect(t *testing.T) {
+	// _start -> average -> scale, stopped in scale after its prologue, as
+	// in the backtrace test of pkg/symbols
+	target := newFakeTarget(t)
+	target.pc, target.sp, target.fp = 0x40100a, 0x5fc0, 0x5fc0
+	target.put(0x5fc0, 0x6000, 8)
+	target.put(0x5fc8, 0x401063, 8)
+	target.put(0x6000, 0x6100, 8)
+	target.put(0x6008, 0x401098, 8)
+	target.put(0x6100, 0, 16)
+	target.put(0x6010-20, 60, 4) // sum in average
+	c := attach(t, target)
+
+	frames := c.stackFrames(cpuThreadID)
+	want := []struct {
+		name string
+		line int
+	}{{"scale", 18}, {"average", 28}, {"_start", 36}}
+	if len(frames) != len(want) {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+	for i, w := range want {
+		if f := frames[i]; f.Name != w.name || f.Line != w.line || f.Source == nil || f.Source.Path != "/build/firmware.c" {
+			t.Errorf("frame %d = %+v", i, f)
+		}
+	}
+	if frames[1].InstructionPointerReference != "0x00401063" {
+		t.Errorf("caller frame at %s", frames[1].InstructionPointerReference)
+	}
+
+	top := c.scopes(frames[0].ID)
+	if len(top) != 3 || top["Registers"] == 0 || top["Locals"] == 0 || top["Globals"] == 0 {
+		t.Fatalf("unexpected scopes %+v", top)
+	}
+	regs := c.variables(top["Registers"])
+	if len(regs) != 5 || regs[0].Name != "r2" || regs[1].Value != "0x0000000a" || regs[3].Name != "rip" || regs[3].Value != "0x0040100a" {
+		t.Errorf("unexpected registers %+v", regs)
+	}
+
+	// Locals of callers are read in their frame
+	caller := c.scopes(frames[1].ID)
+	locals := c.variables(caller["Locals"])
+	sum := Variable{}
+	for _, v := range locals {
+		if v.Name == "sum" {
+			sum = v
+		}
+	}
+	if sum.Value != "60" || sum.Type != "int" || sum.MemoryReference != "0x00005ffc" {
+		t.Errorf("unexpected locals %+v", locals)
+	}
+
+	// Aggregates expand into their members
+	var sensors Variable
+	for _, v := range c.variables(top["Globals"]) {
+		if v.Name == "sensors" {
+			sensors = v
+		}
+	}
+	if sensors.Value != "[2]" || sensors.VariablesReference == 0 {
+		t.Fatalf("unexpected sensors %+v", sensors)
+	}
+	elements := c.variables(sensors.VariablesReference)
+	if len(elements) != 2 || elements[1].Name != "[1]" || elements[1].Value != "{...}" {
+		t.Fatalf("unexpected elements %+v", elements)
+	}
+	if members := c.variables(elements[1].VariablesReference); len(members) != 3 || members[0].Name != "id" {
+		t.Errorf("unexpected members %+v", members)
+	}
+
+	// Only the registers of the CPU can be written
+	if resp := c.request("setVariable", map[string]interface{}{"variablesReference": caller["Registers"], "name": "rbp", "value": "0"}, nil); resp.Success {
+		t.Error("set a register of a caller")
+	}
+	if resp := c.request("setVariable", map[string]interface{}{"variablesReference": top["Locals"], "name": "result", "value": "0"}, nil); resp.Success {
+		t.Error("set a local")
+	}
+	if resp := c.request("setVariable", map[string]interface{}{"variablesReference": top["Registers"], "name": "rip", "value": "0x401035"}, nil); !resp.Success || target.pc != 0x401035 {
+		t.Errorf("setVariable rip: %s", resp.Message)
+	}
+
+	var memory struct {
+		Address string `json:"address"`
+		Data    string `json:"data"`
+	}
+	c.request("readMemory", map[string]interface{}{"memoryReference": "0x1000", "offset": 2, "count": 3}, &memory)
+	if memory.Address != "0x00001002" || memory.Data != "AgME" {
+		t.Errorf("unexpected memory %+v", memory)
+	}
+
+	var disassembly struct {
+		Instructions []DisassembledInstruction `json:"instructions"`
+	}
+	c.request("disassemble", map[string]interface{}{"memoryReference": "0x401019", "instructionCount": 2}, &disassembly)
+	if inst := disassembly.Instructions; len(inst) != 2 || inst[0].Address != "0x00401018" {
+		t.Errorf("unexpected disassembly %+v", inst)
+	}
+}
+
+func TestStep(t *testing.T) {
+	target := newFakeTarget(t)
+	target.pc = 0x40102c // average, line 25
+	c := attach(t, target)
+
+	if resp := c.request("next", map[string]int{"threadId": 1}, nil); !resp.Success {
+		t.Fatalf("next: %s", resp.Message)
+	}
+	var stopped StoppedEvent
+	c.event("stopped", &stopped)
+	if stopped.Reason != "step" {
+		t.Errorf("unexpected stopped event %+v", stopped)
+	}
+	if loc := target.table.Lookup(target.pc); loc.Line == 25 {
+		t.Errorf("next stayed on line 25 at %#x", target.pc)
+	}
+
+	pc := target.pc
+	c.request("stepIn", map[string]interface{}{"threadId": 1, "granularity": "instruction"}, nil)
+	c.event("stopped", nil)
+	if target.pc <= pc {
+		t.Errorf("instruction step did not advance from %#x", pc)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	target := newFakeTarget(t)
+	target.threads = &rtos.Threads{RTOS: "freertos", Current: 0x100, Threads: []rtos.Thread{
+		{ID: 0x100, Name: "main", State: "running", Current: true},
+		{ID: 0x200, Name: "idle", State: "ready", Registers: map[string]uint64{"rip": 0x401035, "rsp": 0x5fe8, "rbp": 0x6000}},
+	}}
+	target.put(0x6000, 0, 16) // No caller of the switched-out thread
+	c := attach(t, target)
+
+	var result struct {
+		Threads []Thread `json:"threads"`
+	}
+	c.request("threads", nil, &result)
+	threads := result.Threads
+	if len(threads) != 2 || threads[0].ID != cpuThreadID || threads[0].Name != "main" || threads[1].Name != "idle (ready)" {
+		t.Fatalf("unexpected threads %+v", threads)
+	}
+
+	// Switched-out threads unwind from their saved registers
+	frames := c.stackFrames(threads[1].ID)
+	if len(frames) == 0 || frames[0].Name != "average" || frames[0].Line != 26 {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+	var names []string
+	for _, v := range c.variables(c.scopes(frames[0].ID)["Locals"]) {
+		names = append(names, v.Name)
+	}
+	if fmt.Sprint(names) != "[s sum i]" {
+		t.Errorf("locals of the thread: %v", names)
+	}
+	if resp := c.request("stackTrace", map[string]int{"threadId": 9}, nil); resp.Success {
+		t.Error("stackTrace of an unknown thread succeeded")
+	}
+
+	// Frames and references end with the stop
+	c.request("stepIn", map[string]interface{}{"threadId": 1, "granularity": "instruction"}, nil)
+	c.event("stopped", nil)
+	if resp := c.request("scopes", map[string]int{"frameId": frames[0].ID}, nil); resp.Success {
+		t.Error("frame still valid after a step")
+	}
+}
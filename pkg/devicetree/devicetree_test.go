@@ -0,0 +1,217 @@
+package devicetree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+const testDTS = `/dts-v1/;
+/memreserve/ 0x80000000 0x1000;
+
+/ {
+	#address-cells = <1>;
+	#size-cells = <1>;
+	model = "test board";
+
+	intc: interrupt-controller@8000000 {
+		compatible = "arm,cortex-a15-gic";
+		#interrupt-cells = <3>;
+		interrupt-controller;
+		reg = <0x8000000 0x10000>;
+	};
+
+	soc {
+		compatible = "simple-bus";
+		#address-cells = <1>;
+		#size-cells = <1>;
+		ranges = <0x0 0x10000000 0x100000>;
+		interrupt-parent = <&intc>;
+
+		uart0: serial@9000 {
+			compatible = "arm,pl011", "arm,primecell";
+			reg = <0x9000 (0x800 * 2)>; // 4 KiB window
+			interrupts = <0 1 4>;
+		};
+	};
+
+	chosen {
+		stdout-path = &uart0;
+	};
+};
+
+&uart0 {
+	status = "okay";
+};
+`
+
+func TestParseDTS(t *testing.T) {
+	tree, err := ParseDTS(testDTS)
+	if err != nil {
+		t.Fatalf("ParseDTS: %v", err)
+	}
+
+	if len(tree.Reservations) != 1 || tree.Reservations[0].Address != 0x80000000 {
+		t.Errorf("unexpected reservations %+v", tree.Reservations)
+	}
+
+	uart := tree.Lookup("/soc/serial@9000")
+	if uart == nil {
+		t.Fatal("uart node not found")
+	}
+	regs, err := uart.Reg()
+	if err != nil || len(regs) != 1 || regs[0].Address != 0x9000 || regs[0].Size != 0x1000 {
+		t.Errorf("unexpected reg %+v, %v", regs, err)
+	}
+	if address, ok := uart.TranslateAddress(0x9000); !ok || address != 0x10009000 {
+		t.Errorf("translated address %#x, %v", address, ok)
+	}
+	if status, _ := uart.String("status"); status != "okay" {
+		t.Errorf("overlay not applied, status %q", status)
+	}
+	if compatible, _ := uart.Strings("compatible"); len(compatible) != 2 {
+		t.Errorf("compatible %v", compatible)
+	}
+
+	handle, ok := tree.Lookup("/soc").Uint32("interrupt-parent")
+	if !ok || tree.PHandle(handle) != tree.Lookup("/interrupt-controller@8000000") {
+		t.Errorf("interrupt-parent does not resolve to the GIC")
+	}
+	if path, _ := tree.Lookup("/chosen").String("stdout-path"); path != "/soc/serial@9000" {
+		t.Errorf("stdout-path %q", path)
+	}
+}
+
+func TestParseDTSErrors(t *testing.T) {
+	for name, source := range map[string]string{
+		"no header":     "/ { };",
+		"include":       "/dts-v1/;\n#include \"foo.dtsi\"\n/ { };",
+		"unknown label": "/dts-v1/;\n/ { a = <&missing>; };",
+		"unterminated":  "/dts-v1/;\n/ { a = <1 2;",
+	} {
+		if _, err := ParseDTS(source); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestEvalExpression(t *testing.T) {
+	for text, want := range map[string]uint64{
+		"(1 + 2 * 3)":       7,
+		"((1 << 4) | 0x3)":  0x13,
+		"(0x100-1)":         0xff,
+		"(~0 & 0xf)":        0xf,
+		"(10 / 3 + 10 % 3)": 4,
+		"(2 > 1 && 1 != 1)": 0,
+	} {
+		got, err := evalExpression(text)
+		if err != nil || got != want {
+			t.Errorf("%s = %d, %v; want %d", text, got, err, want)
+		}
+	}
+}
+
+// buildDTB assembles a minimal blob: a root with one child carrying a reg
+func buildDTB() []byte {
+	var structs, strs bytes.Buffer
+	u32 := func(v uint32) { binary.Write(&structs, binary.BigEndian, v) }
+	name := func(s string) {
+		structs.WriteString(s)
+		structs.WriteByte(0)
+		for structs.Len()%4 != 0 {
+			structs.WriteByte(0)
+		}
+	}
+	prop := func(key string, value []byte) {
+		u32(fdtProp)
+		u32(uint32(len(value)))
+		u32(uint32(strs.Len()))
+		strs.WriteString(key)
+		strs.WriteByte(0)
+		structs.Write(value)
+		for structs.Len()%4 != 0 {
+			structs.WriteByte(0)
+		}
+	}
+
+	u32(fdtBeginNode)
+	name("")
+	prop("#address-cells", EncodeCells(1))
+	prop("#size-cells", EncodeCells(1))
+	u32(fdtBeginNode)
+	name("memory@40000000")
+	prop("device_type", EncodeStrings("memory"))
+	prop("reg", EncodeCells(0x40000000, 0x8000000))
+	u32(fdtEndNode)
+	u32(fdtEndNode)
+	u32(fdtEnd)
+
+	reserve := make([]byte, fdtReserveEntry)
+	offReserve := fdtHeaderSize
+	offStruct := offReserve + len(reserve)
+	offStrings := offStruct + structs.Len()
+	total := offStrings + strs.Len()
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, fdtHeader{
+		Magic: fdtMagic, TotalSize: uint32(total),
+		OffDtStruct: uint32(offStruct), OffDtStrings: uint32(offStrings), OffMemRsvmap: uint32(offReserve),
+		Version: fdtVersion, LastCompVersion: fdtLastCompat,
+		SizeDtStrings: uint32(strs.Len()), SizeDtStruct: uint32(structs.Len()),
+	})
+	out.Write(reserve)
+	out.Write(structs.Bytes())
+	out.Write(strs.Bytes())
+	return out.Bytes()
+}
+
+func TestParseDTB(t *testing.T) {
+	tree, err := Parse(buildDTB())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	memory := tree.Lookup("/memory@40000000")
+	if memory == nil {
+		t.Fatal("memory node not found")
+	}
+	regs, err := memory.Reg()
+	if err != nil || len(regs) != 1 || regs[0].Address != 0x40000000 || regs[0].Size != 0x8000000 {
+		t.Errorf("unexpected reg %+v, %v", regs, err)
+	}
+
+	if _, err := Parse([]byte("not a device tree blob, definitely not")); err != ErrNotDTB {
+		t.Errorf("expected ErrNotDTB, got %v", err)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tree, err := ParseDTS(testDTS)
+	if err != nil {
+		t.Fatalf("ParseDTS: %v", err)
+	}
+	tree.BootCPUIDPhys = 1
+
+	decoded, err := Parse(Encode(tree))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if decoded.BootCPUIDPhys != 1 || len(decoded.Reservations) != 1 || decoded.Reservations[0] != tree.Reservations[0] {
+		t.Errorf("header mismatch: %+v", decoded)
+	}
+
+	var compare func(want, got *Node)
+	compare = func(want, got *Node) {
+		if want.Name != got.Name || len(want.Properties) != len(got.Properties) || len(want.Children) != len(got.Children) {
+			t.Fatalf("%s: node mismatch", want.Path())
+		}
+		for i, prop := range want.Properties {
+			if got.Properties[i].Name != prop.Name || !bytes.Equal(got.Properties[i].Value, prop.Value) {
+				t.Errorf("%s: property %s mismatch", want.Path(), prop.Name)
+			}
+		}
+		for i := range want.Children {
+			compare(want.Children[i], got.Children[i])
+		}
+	}
+	compare(tree.Root, decoded.Root)
+}
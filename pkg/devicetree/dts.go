@@ -0,0 +1,812 @@
+package devicetree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseDTS parses device tree source. The input must already be preprocessed:
+// #include and macros are not expanded. Labels, phandle references (&label),
+// path references, /bits/, /memreserve/, /delete-node/, /delete-property/ and
+// integer expressions in parentheses are supported.
+func ParseDTS(source string) (*Tree, error) {
+	p := &dtsParser{src: source, labels: make(map[string]*Node)}
+	tree, err := p.parse()
+	if err != nil {
+		line := 1 + strings.Count(source[:p.pos], "\n")
+		return nil, fmt.Errorf("dts line %d: %w", line, err)
+	}
+	return tree, nil
+}
+
+// dtsParser is a recursive-descent parser working directly on the source text
+type dtsParser struct {
+	src    string
+	pos    int
+	labels map[string]*Node
+	refs   []phandleRef
+	paths  []pathRef
+}
+
+// phandleRef is a &label inside a cell list, patched once phandles are assigned
+type phandleRef struct {
+	node   *Node
+	prop   string
+	offset int
+	label  string
+}
+
+// pathRef is a &label outside a cell list, replaced by the node path
+type pathRef struct {
+	node   *Node
+	prop   string
+	offset int
+	label  string
+}
+
+func (p *dtsParser) parse() (*Tree, error) {
+	tree := &Tree{}
+
+	p.skipSpace()
+	if !p.consume("/dts-v1/") {
+		return nil, fmt.Errorf("missing /dts-v1/ header")
+	}
+	if err := p.expect(';'); err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpace()
+		switch {
+		case p.pos >= len(p.src):
+			if tree.Root == nil {
+				return nil, fmt.Errorf("no root node")
+			}
+			if err := p.resolve(tree); err != nil {
+				return nil, err
+			}
+			return tree, nil
+
+		case p.peek() == '#':
+			return nil, fmt.Errorf("preprocessor directive found; run the source through cpp first")
+
+		case p.consume("/plugin/"):
+			if err := p.expect(';'); err != nil {
+				return nil, err
+			}
+
+		case p.consume("/memreserve/"):
+			address, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			size, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			tree.Reservations = append(tree.Reservations, Reservation{Address: address, Size: size})
+			if err := p.expect(';'); err != nil {
+				return nil, err
+			}
+
+		case p.consume("/delete-node/"):
+			p.skipSpace()
+			target, err := p.reference(tree)
+			if err != nil {
+				return nil, err
+			}
+			if target.Parent != nil {
+				target.Parent.removeChild(target)
+			}
+			if err := p.expect(';'); err != nil {
+				return nil, err
+			}
+
+		case p.peek() == '/':
+			p.pos++
+			if tree.Root == nil {
+				tree.Root = NewNode("")
+			}
+			if err := p.nodeBody(tree.Root); err != nil {
+				return nil, err
+			}
+
+		case p.peek() == '&':
+			target, err := p.reference(tree)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.nodeBody(target); err != nil {
+				return nil, err
+			}
+
+		default:
+			// Labels on the root node, e.g. "root: / { ... };"
+			labels := p.labelList()
+			if len(labels) == 0 {
+				return nil, fmt.Errorf("unexpected %q", p.peek())
+			}
+			p.skipSpace()
+			if p.peek() != '/' {
+				return nil, fmt.Errorf("expected root node after labels")
+			}
+			p.pos++
+			if tree.Root == nil {
+				tree.Root = NewNode("")
+			}
+			for _, label := range labels {
+				p.labels[label] = tree.Root
+			}
+			tree.Root.Labels = append(tree.Root.Labels, labels...)
+			if err := p.nodeBody(tree.Root); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// reference parses &label or &{/path} and returns the node. Top-level
+// references can only name nodes defined earlier in the file.
+func (p *dtsParser) reference(tree *Tree) (*Node, error) {
+	if err := p.expect('&'); err != nil {
+		return nil, err
+	}
+	if p.peek() == '{' {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated path reference")
+		}
+		path := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		if tree.Root == nil {
+			return nil, fmt.Errorf("reference to %s before the root node", path)
+		}
+		node := tree.Lookup(path)
+		if node == nil {
+			return nil, fmt.Errorf("unknown path %s", path)
+		}
+		return node, nil
+	}
+
+	label := p.name()
+	node, ok := p.labels[label]
+	if !ok {
+		return nil, fmt.Errorf("unknown label %s", label)
+	}
+	return node, nil
+}
+
+// nodeBody parses "{ ... };" into node, merging with existing content
+func (p *dtsParser) nodeBody(node *Node) error {
+	if err := p.expect('{'); err != nil {
+		return err
+	}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return fmt.Errorf("unterminated node %s", node.Path())
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return p.expect(';')
+		}
+
+		if p.consume("/delete-node/") {
+			p.skipSpace()
+			name := p.name()
+			if child := node.Child(name); child != nil {
+				node.removeChild(child)
+			}
+			if err := p.expect(';'); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.consume("/delete-property/") {
+			p.skipSpace()
+			node.removeProperty(p.name())
+			if err := p.expect(';'); err != nil {
+				return err
+			}
+			continue
+		}
+
+		labels := p.labelList()
+		p.skipSpace()
+		name := p.name()
+		if name == "" {
+			return fmt.Errorf("expected property or node name, found %q", p.peek())
+		}
+		p.skipSpace()
+
+		switch p.peek() {
+		case '{':
+			child := node.Child(name)
+			if child == nil {
+				child = node.AddChild(NewNode(name))
+			}
+			for _, label := range labels {
+				p.labels[label] = child
+			}
+			child.Labels = append(child.Labels, labels...)
+			if err := p.nodeBody(child); err != nil {
+				return err
+			}
+		case '=':
+			p.pos++
+			value, err := p.propertyValue(node, name)
+			if err != nil {
+				return err
+			}
+			node.SetProperty(name, value)
+		case ';':
+			p.pos++
+			node.SetProperty(name, nil)
+		default:
+			return fmt.Errorf("unexpected %q after %s", p.peek(), name)
+		}
+	}
+}
+
+// propertyValue parses a comma separated list of values up to ';'
+func (p *dtsParser) propertyValue(node *Node, name string) ([]byte, error) {
+	var value []byte
+	for {
+		p.skipSpace()
+		p.labelList()
+		p.skipSpace()
+
+		switch {
+		case p.peek() == '"':
+			text, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, text...)
+			value = append(value, 0)
+
+		case p.peek() == '<':
+			cells, err := p.cellList(node, name, len(value), 32)
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, cells...)
+
+		case p.consume("/bits/"):
+			bits, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			if bits != 8 && bits != 16 && bits != 32 && bits != 64 {
+				return nil, fmt.Errorf("/bits/ %d is not supported", bits)
+			}
+			p.skipSpace()
+			cells, err := p.cellList(node, name, len(value), int(bits))
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, cells...)
+
+		case p.peek() == '[':
+			data, err := p.byteString()
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, data...)
+
+		case p.peek() == '&':
+			p.pos++
+			label := p.name()
+			p.paths = append(p.paths, pathRef{node: node, prop: name, offset: len(value), label: label})
+
+		default:
+			return nil, fmt.Errorf("unexpected %q in value of %s", p.peek(), name)
+		}
+
+		p.skipSpace()
+		p.labelList()
+		p.skipSpace()
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if err := p.expect(';'); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+// cellList parses <...> with cells of the given bit width. offset is the
+// position of the list within the property value, used to patch phandles.
+func (p *dtsParser) cellList(node *Node, prop string, offset, bits int) ([]byte, error) {
+	if err := p.expect('<'); err != nil {
+		return nil, err
+	}
+	width := bits / 8
+	var out []byte
+	for {
+		p.skipSpace()
+		p.labelList()
+		p.skipSpace()
+		switch c := p.peek(); {
+		case c == '>':
+			p.pos++
+			return out, nil
+		case c == '&':
+			if bits != 32 {
+				return nil, fmt.Errorf("phandle references require 32-bit cells")
+			}
+			p.pos++
+			var label string
+			if p.peek() == '{' {
+				end := strings.IndexByte(p.src[p.pos:], '}')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated path reference")
+				}
+				label = p.src[p.pos : p.pos+end+1]
+				p.pos += end + 1
+			} else {
+				label = p.name()
+			}
+			p.refs = append(p.refs, phandleRef{node: node, prop: prop, offset: offset + len(out), label: label})
+			out = append(out, 0, 0, 0, 0)
+		case c == 0:
+			return nil, fmt.Errorf("unterminated cell list")
+		default:
+			value, err := p.cellValue()
+			if err != nil {
+				return nil, err
+			}
+			cell := make([]byte, 8)
+			binary.BigEndian.PutUint64(cell, value)
+			out = append(out, cell[8-width:]...)
+		}
+	}
+}
+
+// cellValue parses a number, a character literal or a parenthesized expression
+func (p *dtsParser) cellValue() (uint64, error) {
+	if p.peek() == '(' {
+		start := p.pos
+		depth := 0
+		for ; p.pos < len(p.src); p.pos++ {
+			switch p.src[p.pos] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+		if depth != 0 {
+			return 0, fmt.Errorf("unbalanced parentheses")
+		}
+		p.pos++
+		return evalExpression(p.src[start:p.pos])
+	}
+	if p.peek() == '\'' {
+		end := strings.IndexByte(p.src[p.pos+1:], '\'')
+		if end < 0 {
+			return 0, fmt.Errorf("unterminated character literal")
+		}
+		literal, err := strconv.Unquote(p.src[p.pos : p.pos+end+2])
+		if err != nil || len(literal) != 1 {
+			return 0, fmt.Errorf("invalid character literal")
+		}
+		p.pos += end + 2
+		return uint64(literal[0]), nil
+	}
+	return p.integer()
+}
+
+// byteString parses [hex bytes]
+func (p *dtsParser) byteString() ([]byte, error) {
+	if err := p.expect('['); err != nil {
+		return nil, err
+	}
+	end := strings.IndexByte(p.src[p.pos:], ']')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated byte string")
+	}
+	hexText := strings.Join(strings.Fields(p.src[p.pos:p.pos+end]), "")
+	p.pos += end + 1
+	if len(hexText)%2 != 0 {
+		return nil, fmt.Errorf("odd number of hex digits in byte string")
+	}
+	out := make([]byte, len(hexText)/2)
+	for i := range out {
+		b, err := strconv.ParseUint(hexText[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte string: %w", err)
+		}
+		out[i] = byte(b)
+	}
+	return out, nil
+}
+
+// labelList consumes any number of "label:" prefixes
+func (p *dtsParser) labelList() []string {
+	var labels []string
+	for {
+		p.skipSpace()
+		start := p.pos
+		name := p.name()
+		if name != "" && p.peek() == ':' && isLabel(name) {
+			p.pos++
+			labels = append(labels, name)
+			continue
+		}
+		p.pos = start
+		return labels
+	}
+}
+
+func isLabel(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+// name consumes a node, property or label name
+func (p *dtsParser) name() string {
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte(",._+*#?@-", c) >= 0
+}
+
+func (p *dtsParser) quoted() (string, error) {
+	for end := p.pos + 1; end < len(p.src); end++ {
+		switch p.src[end] {
+		case '\\':
+			end++
+		case '"':
+			text, err := strconv.Unquote(p.src[p.pos : end+1])
+			if err != nil {
+				return "", fmt.Errorf("invalid string literal: %w", err)
+			}
+			p.pos = end + 1
+			return text, nil
+		}
+	}
+	return "", fmt.Errorf("unterminated string literal")
+}
+
+func (p *dtsParser) integer() (uint64, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (isNameChar(p.src[p.pos]) && p.src[p.pos] != ',') {
+		p.pos++
+	}
+	text := strings.TrimRight(strings.ToLower(p.src[start:p.pos]), "ul")
+	value, err := strconv.ParseUint(text, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", p.src[start:p.pos])
+	}
+	return value, nil
+}
+
+func (p *dtsParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *dtsParser) consume(keyword string) bool {
+	if strings.HasPrefix(p.src[p.pos:], keyword) {
+		p.pos += len(keyword)
+		return true
+	}
+	return false
+}
+
+func (p *dtsParser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return fmt.Errorf("expected %q, found %q", c, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// skipSpace skips whitespace and comments
+func (p *dtsParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch {
+		case unicode.IsSpace(rune(p.src[p.pos])):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 4
+			}
+		default:
+			return
+		}
+	}
+}
+
+// resolve assigns phandles to referenced nodes and patches references
+func (p *dtsParser) resolve(tree *Tree) error {
+	lookup := func(label string) (*Node, error) {
+		if strings.HasPrefix(label, "{") {
+			node := tree.Lookup(strings.Trim(label, "{}"))
+			if node == nil {
+				return nil, fmt.Errorf("unknown path %s", label)
+			}
+			return node, nil
+		}
+		node, ok := p.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("unknown label %s", label)
+		}
+		return node, nil
+	}
+
+	var next uint32
+	tree.Root.Walk(func(n *Node) bool {
+		if handle, ok := n.Uint32("phandle"); ok && handle >= next {
+			next = handle
+		}
+		return true
+	})
+
+	for _, ref := range p.refs {
+		target, err := lookup(ref.label)
+		if err != nil {
+			return err
+		}
+		handle, ok := target.Uint32("phandle")
+		if !ok {
+			next++
+			handle = next
+			target.SetCells("phandle", handle)
+		}
+		prop, ok := ref.node.Property(ref.prop)
+		if !ok {
+			continue // Deleted later in the source
+		}
+		binary.BigEndian.PutUint32(prop.Value[ref.offset:], handle)
+	}
+
+	// Insert path strings from the end so earlier offsets stay valid
+	for i := len(p.paths) - 1; i >= 0; i-- {
+		ref := p.paths[i]
+		target, err := lookup(ref.label)
+		if err != nil {
+			return err
+		}
+		prop, ok := ref.node.Property(ref.prop)
+		if !ok {
+			continue
+		}
+		path := EncodeStrings(target.Path())
+		value := append(append(append([]byte(nil), prop.Value[:ref.offset]...), path...), prop.Value[ref.offset:]...)
+		prop.Value = value
+	}
+	return nil
+}
+
+func (n *Node) removeChild(child *Node) {
+	for i, c := range n.Children {
+		if c == child {
+			n.Children = append(n.Children[:i], n.Children[i+1:]...)
+			child.Parent = nil
+			return
+		}
+	}
+}
+
+func (n *Node) removeProperty(name string) {
+	for i, prop := range n.Properties {
+		if prop.Name == name {
+			n.Properties = append(n.Properties[:i], n.Properties[i+1:]...)
+			return
+		}
+	}
+}
+
+// evalExpression evaluates a C-style integer expression such as
+// "(0x1000 + 4 * 2)" as used in preprocessed device tree sources
+func evalExpression(text string) (uint64, error) {
+	e := &exprParser{src: text}
+	value, err := e.parse(0)
+	if err != nil {
+		return 0, err
+	}
+	e.skipSpace()
+	if e.pos != len(e.src) {
+		return 0, fmt.Errorf("unexpected %q in expression", e.src[e.pos:])
+	}
+	return value, nil
+}
+
+type exprParser struct {
+	src string
+	pos int
+}
+
+// binaryOperators in increasing precedence
+var binaryOperators = [][]string{
+	{"||"}, {"&&"}, {"|"}, {"^"}, {"&"}, {"==", "!="},
+	{"<=", ">=", "<", ">"}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%"},
+}
+
+func (e *exprParser) parse(level int) (uint64, error) {
+	if level == len(binaryOperators) {
+		return e.unary()
+	}
+	left, err := e.parse(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		e.skipSpace()
+		op := ""
+		for _, candidate := range binaryOperators[level] {
+			if strings.HasPrefix(e.src[e.pos:], candidate) && !e.longerOperator(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		e.pos += len(op)
+		right, err := e.parse(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		if left, err = applyOperator(op, left, right); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// longerOperator avoids matching "<" in "<<" or "&" in "&&"
+func (e *exprParser) longerOperator(op string) bool {
+	rest := e.src[e.pos+len(op):]
+	if rest == "" {
+		return false
+	}
+	next := rest[0]
+	switch op {
+	case "<", ">":
+		return next == op[0] || next == '='
+	case "&", "|":
+		return next == op[0]
+	}
+	return false
+}
+
+func applyOperator(op string, a, b uint64) (uint64, error) {
+	boolean := func(v bool) uint64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return boolean(a != 0 || b != 0), nil
+	case "&&":
+		return boolean(a != 0 && b != 0), nil
+	case "|":
+		return a | b, nil
+	case "^":
+		return a ^ b, nil
+	case "&":
+		return a & b, nil
+	case "==":
+		return boolean(a == b), nil
+	case "!=":
+		return boolean(a != b), nil
+	case "<":
+		return boolean(a < b), nil
+	case ">":
+		return boolean(a > b), nil
+	case "<=":
+		return boolean(a <= b), nil
+	case ">=":
+		return boolean(a >= b), nil
+	case "<<":
+		return a << b, nil
+	case ">>":
+		return a >> b, nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+func (e *exprParser) unary() (uint64, error) {
+	e.skipSpace()
+	if e.pos >= len(e.src) {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	switch c := e.src[e.pos]; c {
+	case '-', '~', '!':
+		e.pos++
+		value, err := e.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case '-':
+			return -value, nil
+		case '~':
+			return ^value, nil
+		default:
+			if value == 0 {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case '(':
+		e.pos++
+		value, err := e.parse(0)
+		if err != nil {
+			return 0, err
+		}
+		e.skipSpace()
+		if e.pos >= len(e.src) || e.src[e.pos] != ')' {
+			return 0, fmt.Errorf("missing ')'")
+		}
+		e.pos++
+		return value, nil
+	}
+
+	start := e.pos
+	for e.pos < len(e.src) && (unicode.IsLetter(rune(e.src[e.pos])) || unicode.IsDigit(rune(e.src[e.pos]))) {
+		e.pos++
+	}
+	text := strings.TrimRight(strings.ToLower(e.src[start:e.pos]), "ul")
+	value, err := strconv.ParseUint(text, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q in expression", e.src[start:e.pos])
+	}
+	return value, nil
+}
+
+func (e *exprParser) skipSpace() {
+	for e.pos < len(e.src) && unicode.IsSpace(rune(e.src[e.pos])) {
+		e.pos++
+	}
+}
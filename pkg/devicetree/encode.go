@@ -0,0 +1,93 @@
+package devicetree
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Encode serializes the tree as a version 17 flattened device tree blob
+func Encode(tree *Tree) []byte {
+	e := &fdtEncoder{offsets: make(map[string]uint32)}
+	e.node(tree.Root)
+	e.u32(fdtEnd)
+
+	var reserve bytes.Buffer
+	for _, r := range tree.Reservations {
+		binary.Write(&reserve, binary.BigEndian, r.Address)
+		binary.Write(&reserve, binary.BigEndian, r.Size)
+	}
+	reserve.Write(make([]byte, fdtReserveEntry)) // Terminating entry
+
+	// The reservation block must be 8-byte aligned; the header is 40 bytes
+	offReserve := (fdtHeaderSize + 7) &^ 7
+	offStruct := offReserve + reserve.Len()
+	offStrings := offStruct + e.structs.Len()
+	total := offStrings + e.strings.Len()
+
+	out := bytes.NewBuffer(make([]byte, 0, total))
+	binary.Write(out, binary.BigEndian, fdtHeader{
+		Magic:           fdtMagic,
+		TotalSize:       uint32(total),
+		OffDtStruct:     uint32(offStruct),
+		OffDtStrings:    uint32(offStrings),
+		OffMemRsvmap:    uint32(offReserve),
+		Version:         fdtVersion,
+		LastCompVersion: fdtLastCompat,
+		BootCPUIDPhys:   tree.BootCPUIDPhys,
+		SizeDtStrings:   uint32(e.strings.Len()),
+		SizeDtStruct:    uint32(e.structs.Len()),
+	})
+	out.Write(make([]byte, offReserve-fdtHeaderSize))
+	out.Write(reserve.Bytes())
+	out.Write(e.structs.Bytes())
+	out.Write(e.strings.Bytes())
+	return out.Bytes()
+}
+
+type fdtEncoder struct {
+	structs bytes.Buffer
+	strings bytes.Buffer
+	offsets map[string]uint32
+}
+
+func (e *fdtEncoder) node(n *Node) {
+	e.u32(fdtBeginNode)
+	e.structs.WriteString(n.Name)
+	e.structs.WriteByte(0)
+	e.pad()
+
+	for _, prop := range n.Properties {
+		e.u32(fdtProp)
+		e.u32(uint32(len(prop.Value)))
+		e.u32(e.stringOffset(prop.Name))
+		e.structs.Write(prop.Value)
+		e.pad()
+	}
+	for _, child := range n.Children {
+		e.node(child)
+	}
+	e.u32(fdtEndNode)
+}
+
+// stringOffset returns the offset of a property name in the strings block,
+// adding it on first use
+func (e *fdtEncoder) stringOffset(name string) uint32 {
+	if offset, ok := e.offsets[name]; ok {
+		return offset
+	}
+	offset := uint32(e.strings.Len())
+	e.strings.WriteString(name)
+	e.strings.WriteByte(0)
+	e.offsets[name] = offset
+	return offset
+}
+
+func (e *fdtEncoder) u32(value uint32) {
+	binary.Write(&e.structs, binary.BigEndian, value)
+}
+
+func (e *fdtEncoder) pad() {
+	for e.structs.Len()%4 != 0 {
+		e.structs.WriteByte(0)
+	}
+}
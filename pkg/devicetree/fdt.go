@@ -0,0 +1,199 @@
+package devicetree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// FDT format constants
+const (
+	fdtMagic        = 0xd00dfeed
+	fdtBeginNode    = 0x1
+	fdtEndNode      = 0x2
+	fdtProp         = 0x3
+	fdtNop          = 0x4
+	fdtEnd          = 0x9
+	fdtHeaderSize   = 40
+	fdtVersion      = 17
+	fdtLastCompat   = 16
+	fdtMinReadable  = 16
+	fdtReserveEntry = 16
+)
+
+// ErrNotDTB is returned when data does not start with the FDT magic number
+var ErrNotDTB = errors.New("not a flattened device tree")
+
+type fdtHeader struct {
+	Magic           uint32
+	TotalSize       uint32
+	OffDtStruct     uint32
+	OffDtStrings    uint32
+	OffMemRsvmap    uint32
+	Version         uint32
+	LastCompVersion uint32
+	BootCPUIDPhys   uint32
+	SizeDtStrings   uint32
+	SizeDtStruct    uint32
+}
+
+// Parse decodes a flattened device tree blob
+func Parse(data []byte) (*Tree, error) {
+	if len(data) < fdtHeaderSize {
+		return nil, ErrNotDTB
+	}
+	var header fdtHeader
+	if err := binary.Read(bytes.NewReader(data[:fdtHeaderSize]), binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != fdtMagic {
+		return nil, ErrNotDTB
+	}
+	if header.LastCompVersion > fdtVersion || header.Version < fdtMinReadable {
+		return nil, fmt.Errorf("unsupported FDT version %d (compatible with %d)", header.Version, header.LastCompVersion)
+	}
+	if int(header.TotalSize) > len(data) {
+		return nil, fmt.Errorf("truncated FDT: header declares %d bytes, got %d", header.TotalSize, len(data))
+	}
+	data = data[:header.TotalSize]
+
+	structEnd := uint64(header.OffDtStruct) + uint64(header.SizeDtStruct)
+	stringsEnd := uint64(header.OffDtStrings) + uint64(header.SizeDtStrings)
+	if structEnd > uint64(len(data)) || stringsEnd > uint64(len(data)) || header.OffMemRsvmap >= header.TotalSize {
+		return nil, errors.New("FDT blocks exceed total size")
+	}
+
+	tree := &Tree{BootCPUIDPhys: header.BootCPUIDPhys}
+	for offset := int(header.OffMemRsvmap); offset+fdtReserveEntry <= len(data); offset += fdtReserveEntry {
+		address := binary.BigEndian.Uint64(data[offset:])
+		size := binary.BigEndian.Uint64(data[offset+8:])
+		if address == 0 && size == 0 {
+			break
+		}
+		tree.Reservations = append(tree.Reservations, Reservation{Address: address, Size: size})
+	}
+
+	p := &fdtParser{
+		structs: data[header.OffDtStruct:structEnd],
+		strings: data[header.OffDtStrings:stringsEnd],
+	}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	tree.Root = root
+	return tree, nil
+}
+
+type fdtParser struct {
+	structs []byte
+	strings []byte
+	offset  int
+}
+
+func (p *fdtParser) parse() (*Node, error) {
+	var (
+		root    *Node
+		current *Node
+	)
+	for {
+		token, err := p.u32()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token {
+		case fdtBeginNode:
+			name, err := p.cstring()
+			if err != nil {
+				return nil, err
+			}
+			node := NewNode(name)
+			if current == nil {
+				if root != nil {
+					return nil, errors.New("FDT has more than one root node")
+				}
+				root = node
+			} else {
+				current.AddChild(node)
+			}
+			current = node
+
+		case fdtEndNode:
+			if current == nil {
+				return nil, errors.New("unbalanced FDT_END_NODE")
+			}
+			current = current.Parent
+
+		case fdtProp:
+			if current == nil {
+				return nil, errors.New("FDT property outside of a node")
+			}
+			length, err := p.u32()
+			if err != nil {
+				return nil, err
+			}
+			nameOffset, err := p.u32()
+			if err != nil {
+				return nil, err
+			}
+			if p.offset+int(length) > len(p.structs) {
+				return nil, errors.New("FDT property exceeds structure block")
+			}
+			name, err := p.propertyName(nameOffset)
+			if err != nil {
+				return nil, err
+			}
+			value := append([]byte(nil), p.structs[p.offset:p.offset+int(length)]...)
+			current.Properties = append(current.Properties, Property{Name: name, Value: value})
+			p.offset = align4(p.offset + int(length))
+
+		case fdtNop:
+
+		case fdtEnd:
+			if current != nil || root == nil {
+				return nil, errors.New("FDT_END inside a node")
+			}
+			root.Name = ""
+			return root, nil
+
+		default:
+			return nil, fmt.Errorf("unknown FDT token %#x at offset %d", token, p.offset-4)
+		}
+	}
+}
+
+func (p *fdtParser) u32() (uint32, error) {
+	if p.offset+4 > len(p.structs) {
+		return 0, errors.New("unexpected end of FDT structure block")
+	}
+	value := binary.BigEndian.Uint32(p.structs[p.offset:])
+	p.offset += 4
+	return value, nil
+}
+
+func (p *fdtParser) cstring() (string, error) {
+	end := bytes.IndexByte(p.structs[p.offset:], 0)
+	if end < 0 {
+		return "", errors.New("unterminated FDT node name")
+	}
+	name := string(p.structs[p.offset : p.offset+end])
+	p.offset = align4(p.offset + end + 1)
+	return name, nil
+}
+
+func (p *fdtParser) propertyName(offset uint32) (string, error) {
+	if int(offset) >= len(p.strings) {
+		return "", fmt.Errorf("FDT property name offset %d out of range", offset)
+	}
+	end := bytes.IndexByte(p.strings[offset:], 0)
+	if end < 0 {
+		return "", errors.New("unterminated FDT property name")
+	}
+	return string(p.strings[offset : int(offset)+end]), nil
+}
+
+func align4(offset int) int {
+	return (offset + 3) &^ 3
+}
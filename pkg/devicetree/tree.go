@@ -0,0 +1,335 @@
+// Package devicetree reads and writes flattened device trees (DTB) and parses
+// the device tree source (DTS) format.
+package devicetree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Tree is a parsed device tree
+type Tree struct {
+	Root          *Node
+	Reservations  []Reservation
+	BootCPUIDPhys uint32
+}
+
+// Reservation is an entry of the memory reservation block
+type Reservation struct {
+	Address uint64
+	Size    uint64
+}
+
+// Node is a device tree node. Name includes the unit address, e.g. "uart@9000000".
+type Node struct {
+	Name       string
+	Labels     []string
+	Properties []Property
+	Children   []*Node
+	Parent     *Node
+}
+
+// Property is a raw device tree property
+type Property struct {
+	Name  string
+	Value []byte
+}
+
+// NewNode creates a detached node
+func NewNode(name string) *Node {
+	return &Node{Name: name}
+}
+
+// AddChild appends a child node and returns it
+func (n *Node) AddChild(child *Node) *Node {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// Child returns the direct child with the given name
+func (n *Node) Child(name string) *Node {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// Path returns the absolute path of the node
+func (n *Node) Path() string {
+	if n.Parent == nil {
+		return "/"
+	}
+	parent := n.Parent.Path()
+	if parent == "/" {
+		return "/" + n.Name
+	}
+	return parent + "/" + n.Name
+}
+
+// BaseName returns the node name without the unit address
+func (n *Node) BaseName() string {
+	name, _, _ := strings.Cut(n.Name, "@")
+	return name
+}
+
+// Property returns the property with the given name
+func (n *Node) Property(name string) (*Property, bool) {
+	for i := range n.Properties {
+		if n.Properties[i].Name == name {
+			return &n.Properties[i], true
+		}
+	}
+	return nil, false
+}
+
+// SetProperty adds or replaces a property
+func (n *Node) SetProperty(name string, value []byte) {
+	if prop, ok := n.Property(name); ok {
+		prop.Value = value
+		return
+	}
+	n.Properties = append(n.Properties, Property{Name: name, Value: value})
+}
+
+// SetString sets a string property
+func (n *Node) SetString(name, value string) {
+	n.SetProperty(name, EncodeStrings(value))
+}
+
+// SetStrings sets a string list property
+func (n *Node) SetStrings(name string, values ...string) {
+	n.SetProperty(name, EncodeStrings(values...))
+}
+
+// SetCells sets a property of 32-bit cells
+func (n *Node) SetCells(name string, cells ...uint32) {
+	n.SetProperty(name, EncodeCells(cells...))
+}
+
+// String returns the first string of a property
+func (n *Node) String(name string) (string, bool) {
+	values, ok := n.Strings(name)
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// Strings returns a string list property
+func (n *Node) Strings(name string) ([]string, bool) {
+	prop, ok := n.Property(name)
+	if !ok {
+		return nil, false
+	}
+	return prop.Strings(), true
+}
+
+// Cells returns a property as 32-bit cells
+func (n *Node) Cells(name string) ([]uint32, bool) {
+	prop, ok := n.Property(name)
+	if !ok {
+		return nil, false
+	}
+	return prop.Cells(), true
+}
+
+// Uint32 returns the first cell of a property
+func (n *Node) Uint32(name string) (uint32, bool) {
+	cells, ok := n.Cells(name)
+	if !ok || len(cells) == 0 {
+		return 0, false
+	}
+	return cells[0], true
+}
+
+// Strings decodes a NUL separated string list
+func (p *Property) Strings() []string {
+	value := bytes.TrimSuffix(p.Value, []byte{0})
+	if len(value) == 0 {
+		return nil
+	}
+	parts := bytes.Split(value, []byte{0})
+	out := make([]string, len(parts))
+	for i, part := range parts {
+		out[i] = string(part)
+	}
+	return out
+}
+
+// Cells decodes big-endian 32-bit cells; trailing bytes are ignored
+func (p *Property) Cells() []uint32 {
+	cells := make([]uint32, len(p.Value)/4)
+	for i := range cells {
+		cells[i] = binary.BigEndian.Uint32(p.Value[i*4:])
+	}
+	return cells
+}
+
+// Walk visits the node and all descendants depth-first
+func (n *Node) Walk(visit func(*Node) bool) {
+	if !visit(n) {
+		return
+	}
+	for _, child := range n.Children {
+		child.Walk(visit)
+	}
+}
+
+// Lookup finds a node by absolute path
+func (t *Tree) Lookup(path string) *Node {
+	if t.Root == nil || !strings.HasPrefix(path, "/") {
+		return nil
+	}
+	node := t.Root
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if node = node.Child(name); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// PHandle finds the node carrying the given phandle
+func (t *Tree) PHandle(handle uint32) *Node {
+	var found *Node
+	t.Root.Walk(func(n *Node) bool {
+		if found != nil {
+			return false
+		}
+		for _, name := range []string{"phandle", "linux,phandle"} {
+			if value, ok := n.Uint32(name); ok && value == handle {
+				found = n
+				return false
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// AddressCells returns #address-cells of a node, defaulting to 2 per the
+// device tree specification
+func (n *Node) AddressCells() int {
+	if value, ok := n.Uint32("#address-cells"); ok {
+		return int(value)
+	}
+	return 2
+}
+
+// SizeCells returns #size-cells of a node, defaulting to 1
+func (n *Node) SizeCells() int {
+	if value, ok := n.Uint32("#size-cells"); ok {
+		return int(value)
+	}
+	return 1
+}
+
+// Region is an address range from a reg property
+type Region struct {
+	Address uint64
+	Size    uint64
+}
+
+// Reg decodes the reg property of a node using its parent's cell sizes
+func (n *Node) Reg() ([]Region, error) {
+	cells, ok := n.Cells("reg")
+	if !ok {
+		return nil, nil
+	}
+	if n.Parent == nil {
+		return nil, fmt.Errorf("%s: reg on root node", n.Path())
+	}
+	addressCells, sizeCells := n.Parent.AddressCells(), n.Parent.SizeCells()
+	stride := addressCells + sizeCells
+	if stride == 0 || len(cells)%stride != 0 {
+		return nil, fmt.Errorf("%s: reg has %d cells, not a multiple of %d", n.Path(), len(cells), stride)
+	}
+
+	regions := make([]Region, 0, len(cells)/stride)
+	for i := 0; i < len(cells); i += stride {
+		regions = append(regions, Region{
+			Address: joinCells(cells[i : i+addressCells]),
+			Size:    joinCells(cells[i+addressCells : i+stride]),
+		})
+	}
+	return regions, nil
+}
+
+// TranslateAddress maps an address in the node's parent bus to a CPU physical
+// address by walking the ranges properties up to the root. It reports false
+// when a bus on the way has no ranges, i.e. the address is not memory mapped.
+func (n *Node) TranslateAddress(address uint64) (uint64, bool) {
+	for bus := n.Parent; bus != nil && bus.Parent != nil; bus = bus.Parent {
+		prop, ok := bus.Property("ranges")
+		if !ok {
+			return 0, false
+		}
+		if len(prop.Value) == 0 {
+			continue // Identity mapping
+		}
+
+		cells := prop.Cells()
+		childCells, parentCells, sizeCells := bus.AddressCells(), bus.Parent.AddressCells(), bus.SizeCells()
+		stride := childCells + parentCells + sizeCells
+		translated := false
+		for i := 0; i+stride <= len(cells); i += stride {
+			child := joinCells(cells[i : i+childCells])
+			parent := joinCells(cells[i+childCells : i+childCells+parentCells])
+			size := joinCells(cells[i+childCells+parentCells : i+stride])
+			if address >= child && address-child < size {
+				address = parent + (address - child)
+				translated = true
+				break
+			}
+		}
+		if !translated {
+			return 0, false
+		}
+	}
+	return address, true
+}
+
+func joinCells(cells []uint32) uint64 {
+	var value uint64
+	for _, cell := range cells {
+		value = value<<32 | uint64(cell)
+	}
+	return value
+}
+
+// EncodeStrings encodes a NUL terminated string list
+func EncodeStrings(values ...string) []byte {
+	var buf bytes.Buffer
+	for _, value := range values {
+		buf.WriteString(value)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// EncodeCells encodes big-endian 32-bit cells
+func EncodeCells(cells ...uint32) []byte {
+	out := make([]byte, 4*len(cells))
+	for i, cell := range cells {
+		binary.BigEndian.PutUint32(out[i*4:], cell)
+	}
+	return out
+}
+
+// SplitCells splits a 64-bit value into count cells, most significant first
+func SplitCells(value uint64, count int) []uint32 {
+	cells := make([]uint32, count)
+	for i := count - 1; i >= 0; i-- {
+		cells[i] = uint32(value)
+		value >>= 32
+	}
+	return cells
+}
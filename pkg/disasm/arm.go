@@ -0,0 +1,74 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/arch/arm/armasm"
+	"golang.org/x/arch/arm64/arm64asm"
+)
+
+// armDecoder decodes the 32-bit ARM instruction set
+type armDecoder struct {
+	order binary.ByteOrder
+}
+
+func (d armDecoder) decode(code []byte, address uint64) (string, int, uint64) {
+	if len(code) < 4 {
+		return "", 0, 0
+	}
+	word := d.order.Uint32(code)
+	var src [4]byte
+	binary.LittleEndian.PutUint32(src[:], word)
+	inst, err := armasm.Decode(src[:], armasm.ModeARM)
+	if err != nil {
+		return fmt.Sprintf(".word 0x%08x", word), 4, 0
+	}
+
+	text := armasm.GNUSyntax(inst)
+	// The pc reads as the address of the instruction plus 8
+	pc := address + 8
+	for _, arg := range inst.Args {
+		switch arg := arg.(type) {
+		case armasm.PCRel:
+			target := uint64(int64(pc) + int64(arg))
+			text = strings.Replace(text, fmt.Sprintf(".%+#x", int32(arg)+4), fmt.Sprintf("0x%x", target), 1)
+			return text, 4, target
+		case armasm.Mem:
+			if arg.Base == armasm.PC && arg.Sign == 0 && arg.Mode == armasm.AddrOffset {
+				return text, 4, uint64(int64(pc) + int64(arg.Offset))
+			}
+		}
+	}
+	return text, 4, 0
+}
+
+// arm64Decoder decodes the AArch64 instruction set
+type arm64Decoder struct{}
+
+func (arm64Decoder) decode(code []byte, address uint64) (string, int, uint64) {
+	if len(code) < 4 {
+		return "", 0, 0
+	}
+	inst, err := arm64asm.Decode(code[:4])
+	if err != nil {
+		return fmt.Sprintf(".word 0x%08x", binary.LittleEndian.Uint32(code)), 4, 0
+	}
+
+	text := arm64asm.GNUSyntax(inst)
+	for _, arg := range inst.Args {
+		rel, ok := arg.(arm64asm.PCRel)
+		if !ok {
+			continue
+		}
+		base := address
+		if inst.Op == arm64asm.ADRP {
+			base &^= 0xfff
+		}
+		target := uint64(int64(base) + int64(rel))
+		text = strings.Replace(text, strings.ToLower(rel.String()), fmt.Sprintf("0x%x", target), 1)
+		return text, 4, target
+	}
+	return text, 4, 0
+}
@@ -0,0 +1,144 @@
+// Package disasm decodes machine code of the instruction sets virServer
+// emulates: ARM, Thumb (including Thumb-2 and the VFP of Cortex-M), AArch64
+// and RISC-V with the C extension. ARM and AArch64 use golang.org/x/arch;
+// Thumb and RISC-V have their own decoders. The text follows the GNU
+// assembler syntax with absolute branch targets.
+package disasm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Instruction sets
+const (
+	ARM     = "arm"
+	Thumb   = "thumb"
+	ARM64   = "arm64"
+	RISCV32 = "riscv32"
+	RISCV64 = "riscv64"
+)
+
+// Instruction is a decoded instruction
+type Instruction struct {
+	Address uint64 `json:"address"`
+	Size    int    `json:"size"`
+	Bytes   string `json:"bytes"` // In memory order
+	Text    string `json:"text"`
+	Target  uint64 `json:"target,omitempty"` // Branch target or PC-relative data address
+
+	// Symbols, when known
+	Symbol       string `json:"symbol,omitempty"` // function+offset
+	File         string `json:"file,omitempty"`
+	Line         int    `json:"line,omitempty"`
+	TargetSymbol string `json:"target_symbol,omitempty"`
+}
+
+// compareBranches are the conditional branches that test registers rather
+// than condition flags
+var compareBranches = map[string]bool{
+	"cbz": true, "cbnz": true, "tbz": true, "tbnz": true,
+	"beq": true, "bne": true, "blt": true, "bge": true, "bltu": true, "bgeu": true,
+	"bgt": true, "ble": true, "bgtu": true, "bleu": true,
+	"beqz": true, "bnez": true, "blez": true, "bgez": true, "bltz": true, "bgtz": true,
+}
+
+// ConditionalBranch reports whether the instruction branches to its Target
+// or falls through depending on a condition
+func (i Instruction) ConditionalBranch() bool {
+	name, _, _ := strings.Cut(i.Text, " ")
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".n"), ".w")
+	if compareBranches[name] {
+		return true
+	}
+	if cond, ok := strings.CutPrefix(name, "b."); ok {
+		return cond != "al"
+	}
+	if len(name) != 3 || name[0] != 'b' {
+		return false
+	}
+	for _, cond := range conditions {
+		if cond != "" && name[1:] == cond {
+			return true
+		}
+	}
+	return name[1:] == "hs" || name[1:] == "lo"
+}
+
+// decoder decodes the instruction at the start of code. It returns the
+// size, 0 when code is too short for the instruction.
+type decoder interface {
+	decode(code []byte, address uint64) (text string, size int, target uint64)
+}
+
+// Valid reports whether isa is a known instruction set
+func Valid(isa string) bool {
+	switch isa {
+	case ARM, Thumb, ARM64, RISCV32, RISCV64:
+		return true
+	}
+	return false
+}
+
+// Alignment returns the alignment of instructions of an instruction set
+func Alignment(isa string) uint64 {
+	switch isa {
+	case ARM, ARM64:
+		return 4
+	}
+	return 2
+}
+
+// MaxSize is the size of the longest instruction of the supported sets
+const MaxSize = 4
+
+// Decode decodes up to count instructions from code, which starts at
+// address. Decoding stops early at the end of code. Encodings that are not
+// recognised are shown as data (.word or .short) and decoding continues.
+func Decode(isa string, code []byte, address uint64, order binary.ByteOrder, count int) ([]Instruction, error) {
+	var d decoder
+	switch isa {
+	case ARM:
+		d = armDecoder{order: order}
+	case Thumb:
+		d = &thumbDecoder{order: order}
+	case ARM64:
+		d = arm64Decoder{}
+	case RISCV32:
+		d = riscvDecoder{xlen: 32}
+	case RISCV64:
+		d = riscvDecoder{xlen: 64}
+	default:
+		return nil, fmt.Errorf("unknown instruction set %q", isa)
+	}
+
+	var instructions []Instruction
+	for offset := 0; len(instructions) < count && offset < len(code); {
+		text, size, target := d.decode(code[offset:], address+uint64(offset))
+		if size == 0 {
+			break
+		}
+		instructions = append(instructions, Instruction{
+			Address: address + uint64(offset),
+			Size:    size,
+			Bytes:   hex.EncodeToString(code[offset : offset+size]),
+			Text:    text,
+			Target:  target,
+		})
+		offset += size
+	}
+	return instructions, nil
+}
+
+// signExtend extends the sign of the low bits of value
+func signExtend(value uint32, bits uint) int32 {
+	shift := 32 - bits
+	return int32(value<<shift) >> shift
+}
+
+// immediate formats an ARM immediate operand
+func immediate(value int64) string {
+	return fmt.Sprintf("#%d", value)
+}
@@ -0,0 +1,156 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		isa     string
+		code    string
+		address uint64
+		text    string
+		target  uint64
+	}{
+		// Encodings and texts checked against llvm-mc and llvm-objdump
+		{Thumb, "b0b5", 0, "push {r4, r5, r7, lr}", 0},
+		{Thumb, "02af", 0, "add r7, sp, #8", 0},
+		{Thumb, "8818", 0, "adds r0, r1, r2", 0},
+		{Thumb, "0808", 0, "lsrs r0, r1, #32", 0},
+		{Thumb, "4843", 0, "muls r0, r1, r0", 0},
+		{Thumb, "c846", 0, "mov r8, r9", 0},
+		{Thumb, "0248", 0x26, "ldr r0, [pc, #8]", 0x30},
+		{Thumb, "d15e", 0, "ldrsh r1, [r2, r3]", 0},
+		{Thumb, "78b1", 0x34, "cbz r0, 0x56", 0x56},
+		{Thumb, "72b6", 0, "cpsid i", 0},
+		{Thumb, "fed0", 0x56, "beq.n 0x56", 0x56},
+		{Thumb, "03c8", 0, "ldmia r0, {r0, r1}", 0},
+		{Thumb, "fff7ceff", 0x60, "bl 0x0", 0},
+		{Thumb, "7ff4caaf", 0x68, "bne.w 0x0", 0},
+		{Thumb, "2de91041", 0, "push.w {r4, r8, lr}", 0},
+		{Thumb, "62e90201", 0, "strd r0, r1, [r2, #-8]!", 0},
+		{Thumb, "d1e810f0", 0, "tbh [r1, r0, lsl #1]", 0},
+		{Thumb, "01ebc200", 0, "add.w r0, r1, r2, lsl #3", 0},
+		{Thumb, "4ff05620", 0, "mov.w r0, #1442862592", 0},
+		{Thumb, "b0eba10f", 0, "cmp.w r0, r1, asr #2", 0},
+		{Thumb, "41f23420", 0, "movw r0, #4660", 0},
+		{Thumb, "c1f30710", 0, "ubfx r0, r1, #4, #8", 0},
+		{Thumb, "80f31088", 0, "msr primask, r0", 0},
+		{Thumb, "eff30881", 0, "mrs r1, msp", 0},
+		{Thumb, "bff35b8f", 0, "dmb ish", 0},
+		{Thumb, "4df8040d", 0, "str.w r0, [sp, #-4]!", 0},
+		{Thumb, "5ff81000", 0x12c, "ldr.w r0, [pc, #-16]", 0x120},
+		{Thumb, "b1fbf2f0", 0, "udiv r0, r1, r2", 0},
+		{Thumb, "a2fb0301", 0, "umull r0, r1, r2, r3", 0},
+		{Thumb, "9fed041a", 0x188, "vldr s2, [pc, #16]", 0x19c},
+		{Thumb, "2ded108a", 0, "vpush {s16-s31}", 0},
+		{Thumb, "30ee810a", 0, "vadd.f32 s0, s1, s2", 0},
+		{Thumb, "beee000a", 0, "vmov.f32 s0, #-0.5", 0},
+		{Thumb, "bdeee00a", 0, "vcvt.s32.f32 s0, s1", 0},
+		{Thumb, "f1ee10fa", 0, "vmrs APSR_nzcv, fpscr", 0},
+		{Thumb, "ffde", 0, "udf #255", 0},
+		{Thumb, "ffffffff", 0, ".inst.w 0xffffffff", 0},
+
+		{ARM, "04009fe5", 0x1004, "ldr r0, [pc, #4]", 0x1010},
+		{ARM, "fbffff1a", 0x100c, "bne 0x1000", 0x1000},
+		{ARM, "1eff2fe1", 0, "bx lr", 0},
+		{ARM64, "00000090", 0x1008, "adrp x0, 0x1000", 0x1000},
+		{ARM64, "fcffff97", 0x1010, "bl 0x1000", 0x1000},
+		{ARM64, "c0035fd6", 0, "ret", 0},
+
+		{RISCV32, "0111", 0, "addi sp, sp, -32", 0},
+		{RISCV32, "06ce", 0, "sw ra, 28(sp)", 0},
+		{RISCV32, "930580c1", 0, "li a1, -1000", 0},
+		{RISCV32, "37563412", 0, "lui a2, 0x12345", 0},
+		{RISCV32, "dd37", 0x1a, "jal 0x0", 0},
+		{RISCV32, "8280", 0, "ret", 0},
+		{RISCV32, "61f9", 0x30, "bnez a0, 0x0", 0},
+		{RISCV32, "e367b5fc", 0x32, "bltu a0, a1, 0x0", 0},
+		{RISCV32, "3305b040", 0, "neg a0, a1", 0},
+		{RISCV32, "0f001003", 0, "fence rw, w", 0},
+		{RISCV32, "73250030", 0, "csrr a0, mstatus", 0},
+		{RISCV32, "73500430", 0, "csrwi mstatus, 8", 0},
+		{RISCV32, "2fa6d51a", 0, "sc.w.rl a2, a3, (a1)", 0},
+		{RISCV32, "5395c50a", 0, "fsub.d fa0, fa1, fa2, rtz", 0},
+		{RISCV32, "531505c0", 0, "fcvt.w.s a0, fa0, rtz", 0},
+		{RISCV32, "3971", 0, "addi sp, sp, -64", 0},
+		{RISCV32, "0575", 0, "lui a0, 0xfffe1", 0},
+		{RISCV32, "0d8d", 0, "sub a0, a0, a1", 0},
+		{RISCV32, "2e85", 0, "mv a0, a1", 0},
+		{RISCV32, "0290", 0, "ebreak", 0},
+		{RISCV32, "0000", 0, "unimp", 0},
+		{RISCV32, "ffffffff", 0, ".word 0xffffffff", 0},
+		{RISCV64, "8865", 0, "ld a0, 8(a1)", 0},
+		{RISCV64, "7d35", 0, "addiw a0, a0, -1", 0},
+		{RISCV64, "1bd53540", 0, "sraiw a0, a1, 3", 0},
+		{RISCV64, "13d5f543", 0, "srai a0, a1, 63", 0},
+		{RISCV64, "2f35b6e4", 0, "amomaxu.d.aq a0, a1, (a2)", 0},
+	}
+	for _, tt := range tests {
+		code, _ := hex.DecodeString(tt.code)
+		instructions, err := Decode(tt.isa, code, tt.address, binary.LittleEndian, 1)
+		if err != nil || len(instructions) != 1 {
+			t.Errorf("%s %s: %+v, %v", tt.isa, tt.code, instructions, err)
+			continue
+		}
+		inst := instructions[0]
+		if inst.Text != tt.text || inst.Target != tt.target || inst.Size != len(code) || inst.Bytes != tt.code {
+			t.Errorf("%s %s = %+v; want %q, target %#x", tt.isa, tt.code, inst, tt.text, tt.target)
+		}
+	}
+}
+
+func TestConditionalBranch(t *testing.T) {
+	for text, want := range map[string]bool{
+		"beq.n 0x56":       true,
+		"bne.w 0x0":        true,
+		"bls 0x1000":       true,
+		"cbz r0, 0x56":     true,
+		"b.ne 0x1000":      true,
+		"tbnz w0, #3, 0x0": true,
+		"bltu a0, a1, 0x0": true,
+		"bnez a0, 0x0":     true,
+		"b.n 0x10":         false,
+		"bl 0x0":           false,
+		"blx r3":           false,
+		"bx lr":            false,
+		"b.al 0x0":         false,
+		"moveq r0, #1":     false,
+	} {
+		if got := (Instruction{Text: text}).ConditionalBranch(); got != want {
+			t.Errorf("ConditionalBranch(%q) = %v", text, got)
+		}
+	}
+}
+
+func TestDecodeITBlock(t *testing.T) {
+	// itte eq; moveq r0, #1; addeq r0, r1; movne r0, #2; movs r0, #3
+	code, _ := hex.DecodeString("06bf012008440220032000bf")
+	instructions, err := Decode(Thumb, code, 0x44, binary.LittleEndian, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"itte eq", "moveq r0, #1", "addeq r0, r1", "movne r0, #2", "movs r0, #3"}
+	if len(instructions) != len(want) {
+		t.Fatalf("unexpected instructions %+v", instructions)
+	}
+	for i, inst := range instructions {
+		if inst.Text != want[i] || inst.Address != 0x44+uint64(2*i) {
+			t.Errorf("instruction %d = %+v; want %q", i, inst, want[i])
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	// A 32-bit instruction cut short ends decoding
+	code, _ := hex.DecodeString("00bffff7")
+	instructions, err := Decode(Thumb, code, 0, binary.LittleEndian, 10)
+	if err != nil || len(instructions) != 1 || instructions[0].Text != "nop" {
+		t.Errorf("unexpected instructions %+v, %v", instructions, err)
+	}
+	if _, err := Decode("mips", code, 0, binary.LittleEndian, 1); err == nil {
+		t.Error("expected an error for an unknown instruction set")
+	}
+}
@@ -0,0 +1,646 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// riscvDecoder decodes RV32 and RV64 with the I, M, A, F, D, Zicsr and
+// Zifencei extensions, and C whose instructions show as their expansion.
+// Common pseudo-instructions (nop, li, mv, ret, j, ...) replace the
+// instructions they stand for.
+type riscvDecoder struct {
+	xlen int
+}
+
+var xRegisters = [32]string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+var fRegisters = [32]string{
+	"ft0", "ft1", "ft2", "ft3", "ft4", "ft5", "ft6", "ft7",
+	"fs0", "fs1", "fa0", "fa1", "fa2", "fa3", "fa4", "fa5",
+	"fa6", "fa7", "fs2", "fs3", "fs4", "fs5", "fs6", "fs7",
+	"fs8", "fs9", "fs10", "fs11", "ft8", "ft9", "ft10", "ft11",
+}
+
+var roundingModes = [8]string{"rne", "rtz", "rdn", "rup", "rmm", "", "", ""}
+
+// csrNames are the names of the common control and status registers
+var csrNames = map[uint32]string{
+	0x001: "fflags", 0x002: "frm", 0x003: "fcsr",
+	0x100: "sstatus", 0x104: "sie", 0x105: "stvec", 0x106: "scounteren",
+	0x140: "sscratch", 0x141: "sepc", 0x142: "scause", 0x143: "stval", 0x144: "sip", 0x180: "satp",
+	0x300: "mstatus", 0x301: "misa", 0x302: "medeleg", 0x303: "mideleg", 0x304: "mie", 0x305: "mtvec", 0x306: "mcounteren",
+	0x340: "mscratch", 0x341: "mepc", 0x342: "mcause", 0x343: "mtval", 0x344: "mip",
+	0x3a0: "pmpcfg0", 0x3a1: "pmpcfg1", 0x3a2: "pmpcfg2", 0x3a3: "pmpcfg3", 0x3b0: "pmpaddr0",
+	0x7b0: "dcsr", 0x7b1: "dpc",
+	0xb00: "mcycle", 0xb02: "minstret", 0xb80: "mcycleh", 0xb82: "minstreth",
+	0xc00: "cycle", 0xc01: "time", 0xc02: "instret", 0xc80: "cycleh", 0xc81: "timeh", 0xc82: "instreth",
+	0xf11: "mvendorid", 0xf12: "marchid", 0xf13: "mimpid", 0xf14: "mhartid",
+}
+
+func (d riscvDecoder) decode(code []byte, address uint64) (string, int, uint64) {
+	if len(code) < 2 {
+		return "", 0, 0
+	}
+	low := uint32(binary.LittleEndian.Uint16(code))
+	if low == 0 {
+		return "unimp", 2, 0
+	}
+	if low&3 != 3 {
+		if word, ok := d.expand(low); ok {
+			if text, target := d.decode32(word, address); text != "" {
+				return text, 2, target
+			}
+		}
+		return fmt.Sprintf(".short 0x%04x", low), 2, 0
+	}
+	if len(code) < 4 {
+		return "", 0, 0
+	}
+	word := binary.LittleEndian.Uint32(code)
+	text, target := d.decode32(word, address)
+	if text == "" {
+		text = fmt.Sprintf(".word 0x%08x", word)
+	}
+	return text, 4, target
+}
+
+func xreg(r uint32) string {
+	return xRegisters[r&31]
+}
+
+func freg(r uint32) string {
+	return fRegisters[r&31]
+}
+
+func memOffset(imm int32, rs1 uint32) string {
+	return fmt.Sprintf("%d(%s)", imm, xreg(rs1))
+}
+
+func (d riscvDecoder) decode32(w uint32, address uint64) (string, uint64) {
+	rd, funct3, rs1, rs2, funct7 := w>>7&31, w>>12&7, w>>15&31, w>>20&31, w>>25
+	immI := signExtend(w>>20, 12)
+	immS := signExtend(w>>25<<5|w>>7&31, 12)
+	rv64 := d.xlen == 64
+
+	switch w & 0x7f {
+	case 0x37:
+		return instruction("lui", xreg(rd), fmt.Sprintf("0x%x", w>>12)), 0
+	case 0x17:
+		return instruction("auipc", xreg(rd), fmt.Sprintf("0x%x", w>>12)), 0
+
+	case 0x6f:
+		imm := signExtend(w>>31<<20|w>>12&0xff<<12|w>>20&1<<11|w>>21&0x3ff<<1, 21)
+		target := d.address(address, imm)
+		switch rd {
+		case 0:
+			return instruction("j", fmt.Sprintf("0x%x", target)), target
+		case 1:
+			return instruction("jal", fmt.Sprintf("0x%x", target)), target
+		}
+		return instruction("jal", xreg(rd), fmt.Sprintf("0x%x", target)), target
+
+	case 0x67:
+		if funct3 != 0 {
+			return "", 0
+		}
+		switch {
+		case rd == 0 && rs1 == 1 && immI == 0:
+			return "ret", 0
+		case rd == 0 && immI == 0:
+			return instruction("jr", xreg(rs1)), 0
+		case rd == 1 && immI == 0:
+			return instruction("jalr", xreg(rs1)), 0
+		}
+		return instruction("jalr", xreg(rd), memOffset(immI, rs1)), 0
+
+	case 0x63:
+		name := [8]string{"beq", "bne", "", "", "blt", "bge", "bltu", "bgeu"}[funct3]
+		if name == "" {
+			return "", 0
+		}
+		imm := signExtend(w>>31<<12|w>>7&1<<11|w>>25&0x3f<<5|w>>8&0xf<<1, 13)
+		target := d.address(address, imm)
+		if rs2 == 0 && funct3 < 6 {
+			return instruction(name+"z", xreg(rs1), fmt.Sprintf("0x%x", target)), target
+		}
+		return instruction(name, xreg(rs1), xreg(rs2), fmt.Sprintf("0x%x", target)), target
+
+	case 0x03:
+		name := [8]string{"lb", "lh", "lw", "ld", "lbu", "lhu", "lwu", ""}[funct3]
+		if name == "" || !rv64 && (funct3 == 3 || funct3 == 6) {
+			return "", 0
+		}
+		return instruction(name, xreg(rd), memOffset(immI, rs1)), 0
+
+	case 0x23:
+		if funct3 > 3 || !rv64 && funct3 == 3 {
+			return "", 0
+		}
+		return instruction([4]string{"sb", "sh", "sw", "sd"}[funct3], xreg(rs2), memOffset(immS, rs1)), 0
+
+	case 0x13:
+		return d.opImm(w, rd, funct3, rs1, immI), 0
+	case 0x1b:
+		if !rv64 {
+			return "", 0
+		}
+		return d.opImm32(w, rd, funct3, rs1, immI), 0
+	case 0x33:
+		return d.op(rd, funct3, rs1, rs2, funct7, false), 0
+	case 0x3b:
+		if !rv64 {
+			return "", 0
+		}
+		return d.op(rd, funct3, rs1, rs2, funct7, true), 0
+
+	case 0x0f:
+		switch {
+		case funct3 == 1:
+			return "fence.i", 0
+		case funct3 != 0:
+			return "", 0
+		case w == 0x8330000f:
+			return "fence.tso", 0
+		}
+		pred, succ := w>>24&15, w>>20&15
+		if pred == 15 && succ == 15 {
+			return "fence", 0
+		}
+		return instruction("fence", fenceSet(pred), fenceSet(succ)), 0
+
+	case 0x73:
+		return d.system(w, rd, funct3, rs1, rs2, funct7), 0
+
+	case 0x2f:
+		return d.atomic(w, rd, funct3, rs1, rs2), 0
+
+	case 0x07, 0x27:
+		name := map[uint32]string{2: "w", 3: "d"}[funct3]
+		if name == "" {
+			return "", 0
+		}
+		if w&0x7f == 0x07 {
+			return instruction("fl"+name, freg(rd), memOffset(immI, rs1)), 0
+		}
+		return instruction("fs"+name, freg(rs2), memOffset(immS, rs1)), 0
+
+	case 0x43, 0x47, 0x4b, 0x4f:
+		format := fpFormat(funct7 & 3)
+		if format == "" {
+			return "", 0
+		}
+		name := map[uint32]string{0x43: "fmadd", 0x47: "fmsub", 0x4b: "fnmsub", 0x4f: "fnmadd"}[w&0x7f]
+		return instruction(name+format, roundingMode([]string{freg(rd), freg(rs1), freg(rs2), freg(w >> 27)}, funct3)...), 0
+
+	case 0x53:
+		return d.opFP(rd, funct3, rs1, rs2, funct7), 0
+	}
+	return "", 0
+}
+
+// address adds a pc-relative offset, wrapping at the address size
+func (d riscvDecoder) address(pc uint64, offset int32) uint64 {
+	target := uint64(int64(pc) + int64(offset))
+	if d.xlen == 32 {
+		target &= 0xffffffff
+	}
+	return target
+}
+
+func (d riscvDecoder) opImm(w, rd, funct3, rs1 uint32, imm int32) string {
+	shamt := w >> 20 & 0x1f
+	shiftOK := w>>25 == 0 || w>>25 == 0x20 && funct3 == 5
+	if d.xlen == 64 {
+		shamt = w >> 20 & 0x3f
+		shiftOK = w>>26 == 0 || w>>26 == 0x10 && funct3 == 5
+	}
+	switch funct3 {
+	case 0:
+		switch {
+		case rd == 0 && rs1 == 0 && imm == 0:
+			return "nop"
+		case rs1 == 0:
+			return instruction("li", xreg(rd), fmt.Sprint(imm))
+		case imm == 0:
+			return instruction("mv", xreg(rd), xreg(rs1))
+		}
+		return instruction("addi", xreg(rd), xreg(rs1), fmt.Sprint(imm))
+	case 1, 5:
+		if !shiftOK {
+			return ""
+		}
+		name := "slli"
+		if funct3 == 5 {
+			name = "srli"
+			if w>>30&1 != 0 {
+				name = "srai"
+			}
+		}
+		return instruction(name, xreg(rd), xreg(rs1), fmt.Sprint(shamt))
+	case 3:
+		if imm == 1 {
+			return instruction("seqz", xreg(rd), xreg(rs1))
+		}
+	case 4:
+		if imm == -1 {
+			return instruction("not", xreg(rd), xreg(rs1))
+		}
+	}
+	name := [8]string{2: "slti", 3: "sltiu", 4: "xori", 6: "ori", 7: "andi"}[funct3]
+	return instruction(name, xreg(rd), xreg(rs1), fmt.Sprint(imm))
+}
+
+func (d riscvDecoder) opImm32(w, rd, funct3, rs1 uint32, imm int32) string {
+	switch funct3 {
+	case 0:
+		if imm == 0 {
+			return instruction("sext.w", xreg(rd), xreg(rs1))
+		}
+		return instruction("addiw", xreg(rd), xreg(rs1), fmt.Sprint(imm))
+	case 1, 5:
+		name := map[uint32]string{0x001: "slliw", 0x005: "srliw", 0x205: "sraiw"}[w>>25<<4|funct3]
+		if name == "" {
+			return ""
+		}
+		return instruction(name, xreg(rd), xreg(rs1), fmt.Sprint(w>>20&0x1f))
+	}
+	return ""
+}
+
+// Register operations by funct7 and funct3
+var (
+	opNames = map[uint32]string{
+		0x000: "add", 0x001: "sll", 0x002: "slt", 0x003: "sltu", 0x004: "xor", 0x005: "srl", 0x006: "or", 0x007: "and",
+		0x200: "sub", 0x205: "sra",
+		0x010: "mul", 0x011: "mulh", 0x012: "mulhsu", 0x013: "mulhu", 0x014: "div", 0x015: "divu", 0x016: "rem", 0x017: "remu",
+	}
+	op32Names = map[uint32]string{
+		0x000: "addw", 0x001: "sllw", 0x005: "srlw", 0x200: "subw", 0x205: "sraw",
+		0x010: "mulw", 0x014: "divw", 0x015: "divuw", 0x016: "remw", 0x017: "remuw",
+	}
+)
+
+func (d riscvDecoder) op(rd, funct3, rs1, rs2, funct7 uint32, word bool) string {
+	names := opNames
+	if word {
+		names = op32Names
+	}
+	name := names[funct7<<4|funct3]
+	switch {
+	case name == "":
+		return ""
+	case name == "add" && rs1 == 0:
+		return instruction("mv", xreg(rd), xreg(rs2))
+	case (name == "sub" || name == "subw") && rs1 == 0:
+		return instruction("neg"+strings.TrimPrefix(name, "sub"), xreg(rd), xreg(rs2))
+	case name == "sltu" && rs1 == 0:
+		return instruction("snez", xreg(rd), xreg(rs2))
+	}
+	return instruction(name, xreg(rd), xreg(rs1), xreg(rs2))
+}
+
+func fenceSet(set uint32) string {
+	text := ""
+	for i, c := range "iorw" {
+		if set&(8>>uint(i)) != 0 {
+			text += string(c)
+		}
+	}
+	return text
+}
+
+func csrName(csr uint32) string {
+	if name, ok := csrNames[csr]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", csr)
+}
+
+func (d riscvDecoder) system(w, rd, funct3, rs1, rs2, funct7 uint32) string {
+	if funct3 == 0 {
+		switch w {
+		case 0x00000073:
+			return "ecall"
+		case 0x00100073:
+			return "ebreak"
+		case 0x00200073:
+			return "uret"
+		case 0x10200073:
+			return "sret"
+		case 0x30200073:
+			return "mret"
+		case 0x7b200073:
+			return "dret"
+		case 0x10500073:
+			return "wfi"
+		}
+		if funct7 == 0x09 && rd == 0 {
+			if rs1 == 0 && rs2 == 0 {
+				return "sfence.vma"
+			}
+			return instruction("sfence.vma", xreg(rs1), xreg(rs2))
+		}
+		return ""
+	}
+	if funct3 == 4 {
+		return ""
+	}
+
+	csr := csrName(w >> 20)
+	name := [8]string{1: "csrrw", 2: "csrrs", 3: "csrrc", 5: "csrrwi", 6: "csrrsi", 7: "csrrci"}[funct3]
+	source := xreg(rs1)
+	if funct3 >= 5 {
+		source = fmt.Sprint(rs1)
+	}
+	switch {
+	case funct3 == 2 && rs1 == 0:
+		return instruction("csrr", xreg(rd), csr)
+	case rd == 0:
+		// Writes that discard the old value
+		return instruction("csr"+name[4:], csr, source)
+	}
+	return instruction(name, xreg(rd), csr, source)
+}
+
+func (d riscvDecoder) atomic(w, rd, funct3, rs1, rs2 uint32) string {
+	width := map[uint32]string{2: ".w", 3: ".d"}[funct3]
+	if width == "" || width == ".d" && d.xlen != 64 {
+		return ""
+	}
+	name := map[uint32]string{
+		0x00: "amoadd", 0x01: "amoswap", 0x02: "lr", 0x03: "sc", 0x04: "amoxor", 0x08: "amoor", 0x0c: "amoand",
+		0x10: "amomin", 0x14: "amomax", 0x18: "amominu", 0x1c: "amomaxu",
+	}[w>>27]
+	if name == "" {
+		return ""
+	}
+	name += width + [4]string{"", ".rl", ".aq", ".aqrl"}[w>>25&3]
+	address := fmt.Sprintf("(%s)", xreg(rs1))
+	if name[:2] == "lr" {
+		if rs2 != 0 {
+			return ""
+		}
+		return instruction(name, xreg(rd), address)
+	}
+	return instruction(name, xreg(rd), xreg(rs2), address)
+}
+
+func fpFormat(format uint32) string {
+	switch format {
+	case 0:
+		return ".s"
+	case 1:
+		return ".d"
+	}
+	return ""
+}
+
+// roundingMode appends a static rounding mode; the dynamic one is implied
+func roundingMode(operands []string, rm uint32) []string {
+	if rm == 7 || roundingModes[rm] == "" {
+		return operands
+	}
+	return append(operands, roundingModes[rm])
+}
+
+// Integer formats of conversions by rs2
+var intFormats = [4]string{".w", ".wu", ".l", ".lu"}
+
+func (d riscvDecoder) opFP(rd, rm, rs1, rs2, funct7 uint32) string {
+	format := fpFormat(funct7 & 3)
+	if format == "" {
+		return ""
+	}
+	switch op := funct7 >> 2; op {
+	case 0x00, 0x01, 0x02, 0x03:
+		name := [4]string{"fadd", "fsub", "fmul", "fdiv"}[op]
+		return instruction(name+format, roundingMode([]string{freg(rd), freg(rs1), freg(rs2)}, rm)...)
+	case 0x0b:
+		if rs2 == 0 {
+			return instruction("fsqrt"+format, roundingMode([]string{freg(rd), freg(rs1)}, rm)...)
+		}
+	case 0x04:
+		if rm > 2 {
+			break
+		}
+		if rs1 == rs2 {
+			return instruction([3]string{"fmv", "fneg", "fabs"}[rm]+format, freg(rd), freg(rs1))
+		}
+		return instruction([3]string{"fsgnj", "fsgnjn", "fsgnjx"}[rm]+format, freg(rd), freg(rs1), freg(rs2))
+	case 0x05:
+		if rm < 2 {
+			return instruction([2]string{"fmin", "fmax"}[rm]+format, freg(rd), freg(rs1), freg(rs2))
+		}
+	case 0x08:
+		if format == ".s" && rs2 == 1 {
+			return instruction("fcvt.s.d", roundingMode([]string{freg(rd), freg(rs1)}, rm)...)
+		}
+		if format == ".d" && rs2 == 0 {
+			return instruction("fcvt.d.s", freg(rd), freg(rs1))
+		}
+	case 0x14:
+		if rm < 3 {
+			return instruction([3]string{"fle", "flt", "feq"}[rm]+format, xreg(rd), freg(rs1), freg(rs2))
+		}
+	case 0x18:
+		if rs2 < 2 || rs2 < 4 && d.xlen == 64 {
+			return instruction("fcvt"+intFormats[rs2]+format, roundingMode([]string{xreg(rd), freg(rs1)}, rm)...)
+		}
+	case 0x1a:
+		if format == ".d" && rs2 < 2 {
+			// Exact, whatever the rounding mode
+			return instruction("fcvt"+format+intFormats[rs2], freg(rd), xreg(rs1))
+		}
+		if rs2 < 2 || rs2 < 4 && d.xlen == 64 {
+			return instruction("fcvt"+format+intFormats[rs2], roundingMode([]string{freg(rd), xreg(rs1)}, rm)...)
+		}
+	case 0x1c:
+		switch {
+		case rs2 != 0:
+		case rm == 0 && format == ".s":
+			return instruction("fmv.x.w", xreg(rd), freg(rs1))
+		case rm == 0 && d.xlen == 64:
+			return instruction("fmv.x.d", xreg(rd), freg(rs1))
+		case rm == 1:
+			return instruction("fclass"+format, xreg(rd), freg(rs1))
+		}
+	case 0x1e:
+		switch {
+		case rs2 != 0 || rm != 0:
+		case format == ".s":
+			return instruction("fmv.w.x", freg(rd), xreg(rs1))
+		case d.xlen == 64:
+			return instruction("fmv.d.x", freg(rd), xreg(rs1))
+		}
+	}
+	return ""
+}
+
+// 32-bit encodings, for expanding compressed instructions
+func encodeI(opcode, rd, funct3, rs1 uint32, imm int32) uint32 {
+	return uint32(imm)&0xfff<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func encodeS(opcode, funct3, rs1, rs2 uint32, imm int32) uint32 {
+	u := uint32(imm)
+	return u>>5&0x7f<<25 | rs2<<20 | rs1<<15 | funct3<<12 | u&31<<7 | opcode
+}
+
+func encodeR(opcode, rd, funct3, rs1, rs2, funct7 uint32) uint32 {
+	return funct7<<25 | rs2<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func encodeB(funct3, rs1, rs2 uint32, imm int32) uint32 {
+	u := uint32(imm)
+	return u>>12&1<<31 | u>>5&0x3f<<25 | rs2<<20 | rs1<<15 | funct3<<12 | u>>1&0xf<<8 | u>>11&1<<7 | 0x63
+}
+
+func encodeJ(rd uint32, imm int32) uint32 {
+	u := uint32(imm)
+	return u>>20&1<<31 | u>>1&0x3ff<<21 | u>>11&1<<20 | u>>12&0xff<<12 | rd<<7 | 0x6f
+}
+
+// expand converts a compressed instruction to the instruction it stands for
+func (d riscvDecoder) expand(c uint32) (uint32, bool) {
+	rv64 := d.xlen == 64
+	funct3 := c >> 13
+	rdc, rs1c := c>>2&7+8, c>>7&7+8 // The registers x8-x15 of 3-bit fields
+	rd, rs2 := c>>7&31, c>>2&31
+	imm6 := signExtend(c>>7&0x20|c>>2&0x1f, 6)
+	// Unsigned offsets of word and doubleword loads and stores
+	wordOffset := int32(c>>7&0x38 | c>>4&4 | c<<1&0x40)
+	doubleOffset := int32(c>>7&0x38 | c<<1&0xc0)
+
+	switch c&3<<3 | funct3 {
+	case 0x00: // c.addi4spn
+		imm := int32(c>>7&0x30 | c>>1&0x3c0 | c>>4&4 | c>>2&8)
+		if imm == 0 {
+			return 0, false
+		}
+		return encodeI(0x13, rdc, 0, 2, imm), true
+	case 0x01: // c.fld
+		return encodeI(0x07, rdc, 3, rs1c, doubleOffset), true
+	case 0x02: // c.lw
+		return encodeI(0x03, rdc, 2, rs1c, wordOffset), true
+	case 0x03: // c.flw, c.ld
+		if rv64 {
+			return encodeI(0x03, rdc, 3, rs1c, doubleOffset), true
+		}
+		return encodeI(0x07, rdc, 2, rs1c, wordOffset), true
+	case 0x05: // c.fsd
+		return encodeS(0x27, 3, rs1c, rdc, doubleOffset), true
+	case 0x06: // c.sw
+		return encodeS(0x23, 2, rs1c, rdc, wordOffset), true
+	case 0x07: // c.fsw, c.sd
+		if rv64 {
+			return encodeS(0x23, 3, rs1c, rdc, doubleOffset), true
+		}
+		return encodeS(0x27, 2, rs1c, rdc, wordOffset), true
+
+	case 0x08: // c.addi, c.nop
+		return encodeI(0x13, rd, 0, rd, imm6), true
+	case 0x09: // c.jal, c.addiw
+		if rv64 {
+			if rd == 0 {
+				return 0, false
+			}
+			return encodeI(0x1b, rd, 0, rd, imm6), true
+		}
+		return encodeJ(1, jumpOffset(c)), true
+	case 0x0a: // c.li
+		return encodeI(0x13, rd, 0, 0, imm6), true
+	case 0x0b: // c.addi16sp, c.lui
+		if rd == 2 {
+			imm := signExtend(c>>3&0x200|c>>2&0x10|c<<1&0x40|c<<4&0x180|c<<3&0x20, 10)
+			if imm == 0 {
+				return 0, false
+			}
+			return encodeI(0x13, 2, 0, 2, imm), true
+		}
+		if imm6 == 0 {
+			return 0, false
+		}
+		return uint32(imm6)<<12 | rd<<7 | 0x37, true
+	case 0x0c: // Arithmetic on x8-x15
+		shamt := c>>7&0x20 | c>>2&0x1f
+		switch c >> 10 & 3 {
+		case 0: // c.srli
+			return encodeI(0x13, rs1c, 5, rs1c, int32(shamt)), true
+		case 1: // c.srai
+			return encodeI(0x13, rs1c, 5, rs1c, int32(shamt|0x400)), true
+		case 2: // c.andi
+			return encodeI(0x13, rs1c, 7, rs1c, imm6), true
+		}
+		op := c >> 5 & 3
+		if c>>12&1 == 0 {
+			funct3 := [4]uint32{0, 4, 6, 7}[op]
+			funct7 := [4]uint32{0x20, 0, 0, 0}[op]
+			return encodeR(0x33, rs1c, funct3, rs1c, rdc, funct7), true
+		}
+		if !rv64 || op > 1 {
+			return 0, false
+		}
+		return encodeR(0x3b, rs1c, 0, rs1c, rdc, [2]uint32{0x20, 0}[op]), true
+	case 0x0d: // c.j
+		return encodeJ(0, jumpOffset(c)), true
+	case 0x0e, 0x0f: // c.beqz, c.bnez
+		imm := signExtend(c>>4&0x100|c>>7&0x18|c<<1&0xc0|c>>2&6|c<<3&0x20, 9)
+		return encodeB(funct3&1, rs1c, 0, imm), true
+
+	case 0x10: // c.slli
+		return encodeI(0x13, rd, 1, rd, int32(c>>7&0x20|c>>2&0x1f)), true
+	case 0x11: // c.fldsp
+		return encodeI(0x07, rd, 3, 2, int32(c>>7&0x20|c>>2&0x18|c<<4&0x1c0)), true
+	case 0x12: // c.lwsp
+		if rd == 0 {
+			return 0, false
+		}
+		return encodeI(0x03, rd, 2, 2, int32(c>>7&0x20|c>>2&0x1c|c<<4&0xc0)), true
+	case 0x13: // c.flwsp, c.ldsp
+		if rv64 {
+			if rd == 0 {
+				return 0, false
+			}
+			return encodeI(0x03, rd, 3, 2, int32(c>>7&0x20|c>>2&0x18|c<<4&0x1c0)), true
+		}
+		return encodeI(0x07, rd, 2, 2, int32(c>>7&0x20|c>>2&0x1c|c<<4&0xc0)), true
+	case 0x14:
+		switch {
+		case c>>12&1 == 0 && rs2 == 0: // c.jr
+			if rd == 0 {
+				return 0, false
+			}
+			return encodeI(0x67, 0, 0, rd, 0), true
+		case c>>12&1 == 0: // c.mv
+			return encodeR(0x33, rd, 0, 0, rs2, 0), true
+		case rd == 0 && rs2 == 0: // c.ebreak
+			return 0x00100073, true
+		case rs2 == 0: // c.jalr
+			return encodeI(0x67, 1, 0, rd, 0), true
+		}
+		// c.add
+		return encodeR(0x33, rd, 0, rd, rs2, 0), true
+	case 0x15: // c.fsdsp
+		return encodeS(0x27, 3, 2, rs2, int32(c>>7&0x38|c>>1&0x1c0)), true
+	case 0x16: // c.swsp
+		return encodeS(0x23, 2, 2, rs2, int32(c>>7&0x3c|c>>1&0xc0)), true
+	case 0x17: // c.fswsp, c.sdsp
+		if rv64 {
+			return encodeS(0x23, 3, 2, rs2, int32(c>>7&0x38|c>>1&0x1c0)), true
+		}
+		return encodeS(0x27, 2, 2, rs2, int32(c>>7&0x3c|c>>1&0xc0)), true
+	}
+	return 0, false
+}
+
+// jumpOffset decodes the offset of c.j and c.jal
+func jumpOffset(c uint32) int32 {
+	return signExtend(c>>1&0x800|c>>7&0x10|c>>1&0x300|c<<2&0x400|c>>1&0x40|c<<1&0x80|c>>2&0xe|c<<3&0x20, 12)
+}
@@ -0,0 +1,1061 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// thumbDecoder decodes the Thumb instruction set of ARMv7-M and the Thumb
+// state of ARMv7-A: all 16-bit encodings and the 32-bit encodings of
+// Thumb-2 and VFP that compilers emit. It follows IT blocks so the
+// instructions they make conditional carry their condition.
+type thumbDecoder struct {
+	order binary.ByteOrder
+	it    uint32 // ITSTATE: the condition in bits 7:4, the mask in bits 3:0
+	setIT bool
+}
+
+var conditions = [16]string{"eq", "ne", "cs", "cc", "mi", "pl", "vs", "vc", "hi", "ls", "ge", "lt", "gt", "le", "", ""}
+
+var thumbRegisters = [16]string{"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8", "r9", "sl", "fp", "ip", "sp", "lr", "pc"}
+
+func (d *thumbDecoder) decode(code []byte, address uint64) (string, int, uint64) {
+	if len(code) < 2 {
+		return "", 0, 0
+	}
+	cond, inIT := "", d.it&0xf != 0
+	if inIT {
+		cond = conditions[d.it>>4]
+	}
+	d.setIT = false
+
+	hw1 := uint32(d.order.Uint16(code))
+	var (
+		text   string
+		size   int
+		target uint64
+	)
+	if hw1>>11 >= 0x1d {
+		if len(code) < 4 {
+			return "", 0, 0
+		}
+		hw2 := uint32(d.order.Uint16(code[2:]))
+		text, target = d.decode32(hw1, hw2, address, cond)
+		if text == "" {
+			text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		}
+		size = 4
+	} else {
+		text, target = d.decode16(hw1, address, cond, inIT)
+		if text == "" {
+			text = fmt.Sprintf(".inst.n 0x%04x", hw1)
+		}
+		size = 2
+	}
+
+	if inIT && !d.setIT {
+		if d.it&0x7 == 0 {
+			d.it = 0
+		} else {
+			d.it = d.it&0xe0 | d.it<<1&0x1f
+		}
+	}
+	return text, size, target
+}
+
+// mnemonic builds a mnemonic from its name, the flag setting suffix, the
+// condition of an IT block and the .w qualifier of 32-bit encodings of
+// instructions that also have a 16-bit encoding
+func mnemonic(name string, setFlags bool, cond string, wide bool) string {
+	if setFlags {
+		name += "s"
+	}
+	name += cond
+	if wide {
+		name += ".w"
+	}
+	return name
+}
+
+func instruction(mnemonic string, operands ...string) string {
+	if len(operands) == 0 {
+		return mnemonic
+	}
+	return mnemonic + " " + strings.Join(operands, ", ")
+}
+
+func reg(r uint32) string {
+	return thumbRegisters[r&15]
+}
+
+func regList(list uint32) string {
+	var names []string
+	for r := uint32(0); r < 16; r++ {
+		if list&(1<<r) != 0 {
+			names = append(names, reg(r))
+		}
+	}
+	return "{" + strings.Join(names, ", ") + "}"
+}
+
+// memory formats an immediate offset addressing mode
+func memory(rn uint32, offset int64, index, writeback bool) string {
+	switch {
+	case !index:
+		return fmt.Sprintf("[%s], %s", reg(rn), immediate(offset))
+	case writeback:
+		return fmt.Sprintf("[%s, %s]!", reg(rn), immediate(offset))
+	case offset == 0:
+		return fmt.Sprintf("[%s]", reg(rn))
+	}
+	return fmt.Sprintf("[%s, %s]", reg(rn), immediate(offset))
+}
+
+// literal returns the address of pc-relative data
+func literal(address uint64, offset int64) uint64 {
+	return uint64(int64((address+4)&^3) + offset)
+}
+
+func branchTarget(address uint64, offset int32) uint64 {
+	return uint64(int64(address) + 4 + int64(offset))
+}
+
+func (d *thumbDecoder) decode16(hw uint32, address uint64, cond string, inIT bool) (string, uint64) {
+	setFlags := !inIT
+	rd, rn, rm := hw&7, hw>>3&7, hw>>6&7
+	switch {
+	case hw>>11 < 3: // Shift by immediate
+		op, imm := hw>>11, int64(hw>>6&31)
+		if op == 0 && imm == 0 {
+			return instruction(mnemonic("mov", setFlags, cond, false), reg(rd), reg(rn)), 0
+		}
+		if op != 0 && imm == 0 {
+			imm = 32
+		}
+		name := [...]string{"lsl", "lsr", "asr"}[op]
+		return instruction(mnemonic(name, setFlags, cond, false), reg(rd), reg(rn), immediate(imm)), 0
+
+	case hw>>11 == 3: // Add and subtract
+		name := "add"
+		if hw>>9&1 != 0 {
+			name = "sub"
+		}
+		if hw>>10&1 == 0 {
+			return instruction(mnemonic(name, setFlags, cond, false), reg(rd), reg(rn), reg(rm)), 0
+		}
+		return instruction(mnemonic(name, setFlags, cond, false), reg(rd), reg(rn), immediate(int64(rm))), 0
+
+	case hw>>13 == 1: // Move, compare, add and subtract immediate
+		rdn, imm := hw>>8&7, immediate(int64(hw&0xff))
+		switch hw >> 11 & 3 {
+		case 0:
+			return instruction(mnemonic("mov", setFlags, cond, false), reg(rdn), imm), 0
+		case 1:
+			return instruction(mnemonic("cmp", false, cond, false), reg(rdn), imm), 0
+		case 2:
+			return instruction(mnemonic("add", setFlags, cond, false), reg(rdn), imm), 0
+		}
+		return instruction(mnemonic("sub", setFlags, cond, false), reg(rdn), imm), 0
+
+	case hw>>10 == 0x10: // Data processing
+		op := hw >> 6 & 15
+		names := [...]string{"and", "eor", "lsl", "lsr", "asr", "adc", "sbc", "ror", "tst", "rsb", "cmp", "cmn", "orr", "mul", "bic", "mvn"}
+		switch op {
+		case 8, 10, 11:
+			return instruction(mnemonic(names[op], false, cond, false), reg(rd), reg(rn)), 0
+		case 9:
+			return instruction(mnemonic("rsb", setFlags, cond, false), reg(rd), reg(rn), "#0"), 0
+		case 13:
+			return instruction(mnemonic("mul", setFlags, cond, false), reg(rd), reg(rn), reg(rd)), 0
+		}
+		return instruction(mnemonic(names[op], setFlags, cond, false), reg(rd), reg(rn)), 0
+
+	case hw>>10 == 0x11: // Special data processing and branch and exchange
+		rdn, rm := hw>>4&8|hw&7, hw>>3&15
+		switch hw >> 8 & 3 {
+		case 0:
+			return instruction(mnemonic("add", false, cond, false), reg(rdn), reg(rm)), 0
+		case 1:
+			return instruction(mnemonic("cmp", false, cond, false), reg(rdn), reg(rm)), 0
+		case 2:
+			return instruction(mnemonic("mov", false, cond, false), reg(rdn), reg(rm)), 0
+		}
+		if hw>>7&1 != 0 {
+			return instruction(mnemonic("blx", false, cond, false), reg(rm)), 0
+		}
+		return instruction(mnemonic("bx", false, cond, false), reg(rm)), 0
+
+	case hw>>11 == 9: // Load literal
+		offset := int64(hw&0xff) << 2
+		return instruction(mnemonic("ldr", false, cond, false), reg(hw>>8&7), fmt.Sprintf("[pc, %s]", immediate(offset))), literal(address, offset)
+
+	case hw>>12 == 5: // Load and store with a register offset
+		name := [...]string{"str", "strh", "strb", "ldrsb", "ldr", "ldrh", "ldrb", "ldrsh"}[hw>>9&7]
+		return instruction(mnemonic(name, false, cond, false), reg(rd), fmt.Sprintf("[%s, %s]", reg(rn), reg(rm))), 0
+
+	case hw>>13 == 3, hw>>12 == 8: // Load and store with an immediate offset
+		name, scale := "str", int64(4)
+		if hw>>12 == 8 {
+			name, scale = "strh", 2
+		} else if hw>>12&1 != 0 {
+			name, scale = "strb", 1
+		}
+		if hw>>11&1 != 0 {
+			name = "ldr" + name[3:]
+		}
+		return instruction(mnemonic(name, false, cond, false), reg(rd), memory(rn, int64(hw>>6&31)*scale, true, false)), 0
+
+	case hw>>12 == 9: // Load and store relative to sp
+		name := "str"
+		if hw>>11&1 != 0 {
+			name = "ldr"
+		}
+		return instruction(mnemonic(name, false, cond, false), reg(hw>>8&7), memory(13, int64(hw&0xff)<<2, true, false)), 0
+
+	case hw>>12 == 0xa: // Address relative to pc or sp
+		offset := int64(hw&0xff) << 2
+		if hw>>11&1 != 0 {
+			return instruction(mnemonic("add", false, cond, false), reg(hw>>8&7), "sp", immediate(offset)), 0
+		}
+		return instruction(mnemonic("add", false, cond, false), reg(hw>>8&7), "pc", immediate(offset)), literal(address, offset)
+
+	case hw>>12 == 0xb:
+		return d.misc16(hw, address, cond)
+
+	case hw>>12 == 0xc: // Load and store multiple
+		rn, list := hw>>8&7, hw&0xff
+		if hw>>11&1 == 0 {
+			return instruction(mnemonic("stmia", false, cond, false), reg(rn)+"!", regList(list)), 0
+		}
+		base := reg(rn)
+		if list&(1<<rn) == 0 {
+			base += "!"
+		}
+		return instruction(mnemonic("ldmia", false, cond, false), base, regList(list)), 0
+
+	case hw>>12 == 0xd: // Conditional branch, supervisor call
+		switch c := hw >> 8 & 15; c {
+		case 14:
+			return instruction(mnemonic("udf", false, cond, false), immediate(int64(hw&0xff))), 0
+		case 15:
+			return instruction(mnemonic("svc", false, cond, false), fmt.Sprintf("%#08x", hw&0xff)), 0
+		default:
+			target := branchTarget(address, signExtend(hw&0xff<<1, 9))
+			return instruction("b"+conditions[c]+".n", fmt.Sprintf("0x%x", target)), target
+		}
+
+	case hw>>11 == 0x1c: // Unconditional branch
+		target := branchTarget(address, signExtend(hw&0x7ff<<1, 12))
+		return instruction("b"+cond+".n", fmt.Sprintf("0x%x", target)), target
+	}
+	return "", 0
+}
+
+// misc16 decodes the miscellaneous 16-bit instructions
+func (d *thumbDecoder) misc16(hw uint32, address uint64, cond string) (string, uint64) {
+	switch {
+	case hw>>8 == 0xb0:
+		name := "add"
+		if hw&0x80 != 0 {
+			name = "sub"
+		}
+		return instruction(mnemonic(name, false, cond, false), "sp", immediate(int64(hw&0x7f)<<2)), 0
+
+	case hw&0xf500 == 0xb100:
+		name := "cbz"
+		if hw>>11&1 != 0 {
+			name = "cbnz"
+		}
+		target := address + 4 + uint64(hw>>3&0x40|hw>>2&0x3e)
+		return instruction(name, reg(hw&7), fmt.Sprintf("0x%x", target)), target
+
+	case hw>>8 == 0xb2:
+		name := [...]string{"sxth", "sxtb", "uxth", "uxtb"}[hw>>6&3]
+		return instruction(mnemonic(name, false, cond, false), reg(hw&7), reg(hw>>3&7)), 0
+
+	case hw>>9 == 0x5a:
+		return instruction(mnemonic("push", false, cond, false), regList(hw&0xff|hw>>8&1<<14)), 0
+
+	case hw>>9 == 0x5e:
+		return instruction(mnemonic("pop", false, cond, false), regList(hw&0xff|hw>>8&1<<15)), 0
+
+	case hw&0xffe8 == 0xb660:
+		name := "cpsie"
+		if hw&0x10 != 0 {
+			name = "cpsid"
+		}
+		flags := ""
+		for i, flag := range "aif" {
+			if hw&(4>>uint(i)) != 0 {
+				flags += string(flag)
+			}
+		}
+		return instruction(name, flags), 0
+
+	case hw>>8 == 0xba:
+		if op := hw >> 6 & 3; op != 2 {
+			name := [...]string{"rev", "rev16", "", "revsh"}[op]
+			return instruction(mnemonic(name, false, cond, false), reg(hw&7), reg(hw>>3&7)), 0
+		}
+
+	case hw>>8 == 0xbe:
+		return instruction("bkpt", fmt.Sprintf("%#04x", hw&0xff)), 0
+
+	case hw>>8 == 0xbf:
+		firstcond, mask := hw>>4&15, hw&15
+		if mask == 0 {
+			hints := [...]string{"nop", "yield", "wfe", "wfi", "sev"}
+			if firstcond < uint32(len(hints)) {
+				return instruction(mnemonic(hints[firstcond], false, cond, false)), 0
+			}
+			return "", 0
+		}
+		d.it, d.setIT = hw&0xff, true
+		name := "it"
+		for i := 3; i > bits.TrailingZeros32(mask); i-- {
+			if mask>>uint(i)&1 == firstcond&1 {
+				name += "t"
+			} else {
+				name += "e"
+			}
+		}
+		c := conditions[firstcond]
+		if c == "" {
+			c = "al"
+		}
+		return instruction(name, c), 0
+	}
+	return "", 0
+}
+
+func (d *thumbDecoder) decode32(hw1, hw2 uint32, address uint64, cond string) (string, uint64) {
+	op2 := hw1 >> 4 & 0x7f
+	switch hw1 >> 11 & 3 {
+	case 1:
+		switch {
+		case op2&0x64 == 0x00:
+			return loadStoreMultiple(hw1, hw2, cond), 0
+		case op2&0x64 == 0x04:
+			return loadStoreDual(hw1, hw2, address, cond)
+		case op2&0x60 == 0x20:
+			return dataProcessingShifted(hw1, hw2, cond), 0
+		}
+		return coprocessor(hw1, hw2, address, cond)
+
+	case 2:
+		if hw2>>15 != 0 {
+			return branchMisc(hw1, hw2, address, cond)
+		}
+		if hw1>>9&1 == 0 {
+			return dataProcessingImmediate(hw1, hw2, cond), 0
+		}
+		return binaryImmediate(hw1, hw2, address, cond)
+
+	case 3:
+		switch {
+		case op2&0x71 == 0x00:
+			return storeSingle(hw1, hw2, cond), 0
+		case op2&0x67 == 0x01, op2&0x67 == 0x03, op2&0x67 == 0x05:
+			return loadSingle(hw1, hw2, address, cond)
+		case op2&0x70 == 0x20:
+			return dataProcessingRegister(hw1, hw2, cond), 0
+		case op2&0x78 == 0x30:
+			return multiply(hw1, hw2, cond), 0
+		case op2&0x78 == 0x38:
+			return longMultiply(hw1, hw2, cond), 0
+		case op2&0x40 != 0:
+			return coprocessor(hw1, hw2, address, cond)
+		}
+	}
+	return "", 0
+}
+
+func loadStoreMultiple(hw1, hw2 uint32, cond string) string {
+	load, writeback, rn := hw1>>4&1 != 0, hw1>>5&1 != 0, hw1&15
+	base := reg(rn)
+	if writeback {
+		base += "!"
+	}
+	switch hw1 >> 7 & 3 {
+	case 1:
+		if load && writeback && rn == 13 {
+			return instruction(mnemonic("pop", false, cond, true), regList(hw2))
+		}
+		if load {
+			return instruction(mnemonic("ldmia", false, cond, true), base, regList(hw2))
+		}
+		return instruction(mnemonic("stmia", false, cond, true), base, regList(hw2))
+	case 2:
+		if !load && writeback && rn == 13 {
+			return instruction(mnemonic("push", false, cond, true), regList(hw2))
+		}
+		if load {
+			return instruction(mnemonic("ldmdb", false, cond, false), base, regList(hw2))
+		}
+		return instruction(mnemonic("stmdb", false, cond, false), base, regList(hw2))
+	}
+	return ""
+}
+
+func loadStoreDual(hw1, hw2 uint32, address uint64, cond string) (string, uint64) {
+	rn, rt, rd := hw1&15, hw2>>12, hw2>>8&15
+	load := hw1>>4&1 != 0
+	switch hw1 >> 5 & 0xf {
+	case 0x2: // Exclusive
+		offset := int64(hw2&0xff) << 2
+		if load {
+			return instruction(mnemonic("ldrex", false, cond, false), reg(rt), memory(rn, offset, true, false)), 0
+		}
+		return instruction(mnemonic("strex", false, cond, false), reg(rd), reg(rt), memory(rn, offset, true, false)), 0
+	case 0x6: // Table branch, exclusive byte and halfword
+		op3 := hw2 >> 4 & 15
+		switch {
+		case load && op3 == 0:
+			return instruction(mnemonic("tbb", false, cond, false), fmt.Sprintf("[%s, %s]", reg(rn), reg(hw2&15))), 0
+		case load && op3 == 1:
+			return instruction(mnemonic("tbh", false, cond, false), fmt.Sprintf("[%s, %s, lsl #1]", reg(rn), reg(hw2&15))), 0
+		case op3 == 4 || op3 == 5:
+			name := [...]string{"b", "h"}[op3-4]
+			if load {
+				return instruction(mnemonic("ldrex"+name, false, cond, false), reg(rt), fmt.Sprintf("[%s]", reg(rn))), 0
+			}
+			return instruction(mnemonic("strex"+name, false, cond, false), reg(hw2&15), reg(rt), fmt.Sprintf("[%s]", reg(rn))), 0
+		}
+		return "", 0
+	}
+
+	// Load and store dual
+	index, up, writeback := hw1>>8&1 != 0, hw1>>7&1 != 0, hw1>>5&1 != 0
+	if !index && !writeback {
+		return "", 0
+	}
+	offset := int64(hw2&0xff) << 2
+	if !up {
+		offset = -offset
+	}
+	name := "strd"
+	if load {
+		name = "ldrd"
+	}
+	var target uint64
+	if rn == 15 {
+		target = literal(address, offset)
+	}
+	return instruction(mnemonic(name, false, cond, false), reg(rt), reg(rd), memory(rn, offset, index, writeback)), target
+}
+
+// shiftedRegister formats a register operand with an immediate shift
+func shiftedRegister(rm, typ, amount uint32) string {
+	switch {
+	case typ == 0 && amount == 0:
+		return reg(rm)
+	case typ == 3 && amount == 0:
+		return reg(rm) + ", rrx"
+	case amount == 0:
+		amount = 32
+	}
+	return fmt.Sprintf("%s, %s #%d", reg(rm), [...]string{"lsl", "lsr", "asr", "ror"}[typ], amount)
+}
+
+var dataProcessingNames = [16]string{0: "and", 1: "bic", 2: "orr", 3: "orn", 4: "eor", 8: "add", 10: "adc", 11: "sbc", 13: "sub", 14: "rsb"}
+
+// dataProcessing formats the data processing instructions that share the
+// operation numbers of the immediate and shifted register encodings
+func dataProcessing(op uint32, setFlags bool, rd, rn uint32, operand, cond string) string {
+	switch {
+	case op == 0 && rd == 15 && setFlags:
+		return instruction(mnemonic("tst", false, cond, true), reg(rn), operand)
+	case op == 4 && rd == 15 && setFlags:
+		return instruction(mnemonic("teq", false, cond, false), reg(rn), operand)
+	case op == 8 && rd == 15 && setFlags:
+		return instruction(mnemonic("cmn", false, cond, true), reg(rn), operand)
+	case op == 13 && rd == 15 && setFlags:
+		return instruction(mnemonic("cmp", false, cond, true), reg(rn), operand)
+	case op == 2 && rn == 15:
+		return instruction(mnemonic("mov", setFlags, cond, true), reg(rd), operand)
+	case op == 3 && rn == 15:
+		return instruction(mnemonic("mvn", setFlags, cond, true), reg(rd), operand)
+	case dataProcessingNames[op] == "":
+		return ""
+	}
+	return instruction(mnemonic(dataProcessingNames[op], setFlags, cond, op != 3), reg(rd), reg(rn), operand)
+}
+
+func dataProcessingShifted(hw1, hw2 uint32, cond string) string {
+	op, setFlags, rn := hw1>>5&15, hw1>>4&1 != 0, hw1&15
+	rd, rm, typ := hw2>>8&15, hw2&15, hw2>>4&3
+	amount := hw2>>10&0x1c | hw2>>6&3
+	switch {
+	case op == 2 && rn == 15 && (typ != 0 || amount != 0):
+		// Shifts are moves of a shifted register
+		if typ == 3 && amount == 0 {
+			return instruction(mnemonic("rrx", setFlags, cond, false), reg(rd), reg(rm))
+		}
+		if typ != 0 && amount == 0 {
+			amount = 32
+		}
+		return instruction(mnemonic([...]string{"lsl", "lsr", "asr", "ror"}[typ], setFlags, cond, true), reg(rd), reg(rm), immediate(int64(amount)))
+	case op == 6:
+		if typ&1 != 0 {
+			return ""
+		}
+		if typ == 0 {
+			return instruction(mnemonic("pkhbt", false, cond, false), reg(rd), reg(rn), shiftedRegister(rm, 0, amount))
+		}
+		return instruction(mnemonic("pkhtb", false, cond, false), reg(rd), reg(rn), shiftedRegister(rm, 2, amount))
+	}
+	return dataProcessing(op, setFlags, rd, rn, shiftedRegister(rm, typ, amount), cond)
+}
+
+// thumbExpandImm decodes a modified immediate constant
+func thumbExpandImm(imm12 uint32) uint32 {
+	if imm12>>10 == 0 {
+		b := imm12 & 0xff
+		switch imm12 >> 8 & 3 {
+		case 0:
+			return b
+		case 1:
+			return b<<16 | b
+		case 2:
+			return b<<24 | b<<8
+		}
+		return b * 0x01010101
+	}
+	return bits.RotateLeft32(0x80|imm12&0x7f, -int(imm12>>7&0x1f))
+}
+
+func dataProcessingImmediate(hw1, hw2 uint32, cond string) string {
+	op, setFlags, rn, rd := hw1>>5&15, hw1>>4&1 != 0, hw1&15, hw2>>8&15
+	value := thumbExpandImm(hw1>>10&1<<11 | hw2>>4&0x700 | hw2&0xff)
+	return dataProcessing(op, setFlags, rd, rn, immediate(int64(int32(value))), cond)
+}
+
+func binaryImmediate(hw1, hw2 uint32, address uint64, cond string) (string, uint64) {
+	rn, rd := hw1&15, hw2>>8&15
+	imm12 := int64(hw1>>10&1<<11 | hw2>>4&0x700 | hw2&0xff)
+	imm16 := int64(hw1&15)<<12 | imm12
+	lsb, msb := hw2>>10&0x1c|hw2>>6&3, hw2&31
+	switch hw1 >> 4 & 0x1f {
+	case 0x00:
+		if rn == 15 {
+			return instruction(mnemonic("addw", false, cond, false), reg(rd), "pc", immediate(imm12)), literal(address, imm12)
+		}
+		return instruction(mnemonic("addw", false, cond, false), reg(rd), reg(rn), immediate(imm12)), 0
+	case 0x04:
+		return instruction(mnemonic("movw", false, cond, false), reg(rd), immediate(imm16)), 0
+	case 0x0a:
+		if rn == 15 {
+			return instruction(mnemonic("subw", false, cond, false), reg(rd), "pc", immediate(imm12)), literal(address, -imm12)
+		}
+		return instruction(mnemonic("subw", false, cond, false), reg(rd), reg(rn), immediate(imm12)), 0
+	case 0x0c:
+		return instruction(mnemonic("movt", false, cond, false), reg(rd), immediate(imm16)), 0
+	case 0x10, 0x12, 0x18, 0x1a:
+		name, saturate := "ssat", int64(hw2&31)+1
+		if hw1>>7&1 != 0 {
+			name, saturate = "usat", int64(hw2&31)
+		}
+		operand := shiftedRegister(rn, hw1>>4&2, lsb)
+		if hw1>>5&1 != 0 && lsb == 0 {
+			return "", 0
+		}
+		return instruction(mnemonic(name, false, cond, false), reg(rd), immediate(saturate), operand), 0
+	case 0x14, 0x1c:
+		name := "sbfx"
+		if hw1>>7&1 != 0 {
+			name = "ubfx"
+		}
+		return instruction(mnemonic(name, false, cond, false), reg(rd), reg(rn), immediate(int64(lsb)), immediate(int64(msb)+1)), 0
+	case 0x16:
+		if msb < lsb {
+			return "", 0
+		}
+		width := immediate(int64(msb - lsb + 1))
+		if rn == 15 {
+			return instruction(mnemonic("bfc", false, cond, false), reg(rd), immediate(int64(lsb)), width), 0
+		}
+		return instruction(mnemonic("bfi", false, cond, false), reg(rd), reg(rn), immediate(int64(lsb)), width), 0
+	}
+	return "", 0
+}
+
+// specialRegisters are the M-profile special registers by SYSm
+var specialRegisters = map[uint32]string{
+	0: "apsr", 1: "iapsr", 2: "eapsr", 3: "xpsr", 5: "ipsr", 6: "epsr", 7: "iepsr",
+	8: "msp", 9: "psp", 10: "msplim", 11: "psplim",
+	16: "primask", 17: "basepri", 18: "basepri_max", 19: "faultmask", 20: "control",
+}
+
+var barrierOptions = map[uint32]string{
+	15: "sy", 14: "st", 13: "ld", 11: "ish", 10: "ishst", 9: "ishld",
+	7: "nsh", 6: "nshst", 5: "nshld", 3: "osh", 2: "oshst", 1: "oshld",
+}
+
+func specialRegister(sysm uint32) string {
+	if name, ok := specialRegisters[sysm]; ok {
+		return name
+	}
+	return strconv.Itoa(int(sysm))
+}
+
+func branchMisc(hw1, hw2 uint32, address uint64, cond string) (string, uint64) {
+	s := hw1 >> 10 & 1
+	j1, j2 := hw2>>13&1, hw2>>11&1
+	switch op1 := hw2 >> 12 & 7; {
+	case op1&5 == 0:
+		if hw1>>7&7 != 7 {
+			offset := signExtend(s<<20|j2<<19|j1<<18|hw1&0x3f<<12|hw2&0x7ff<<1, 21)
+			target := branchTarget(address, offset)
+			return instruction(mnemonic("b"+conditions[hw1>>6&15], false, "", true), fmt.Sprintf("0x%x", target)), target
+		}
+		return miscControl(hw1, hw2, cond), 0
+
+	default:
+		i1, i2 := ^(j1^s)&1, ^(j2^s)&1
+		offset := signExtend(s<<24|i1<<23|i2<<22|hw1&0x3ff<<12|hw2&0x7ff<<1, 25)
+		target := branchTarget(address, offset)
+		switch op1 & 5 {
+		case 1:
+			return instruction(mnemonic("b", false, cond, true), fmt.Sprintf("0x%x", target)), target
+		case 4:
+			// To the ARM state, at a word aligned address
+			target = uint64(int64((address+4)&^3) + int64(offset))
+			return instruction(mnemonic("blx", false, cond, false), fmt.Sprintf("0x%x", target)), target
+		}
+		return instruction(mnemonic("bl", false, cond, false), fmt.Sprintf("0x%x", target)), target
+	}
+}
+
+func miscControl(hw1, hw2 uint32, cond string) string {
+	switch op := hw1 >> 4 & 0x7f; {
+	case op == 0x38 || op == 0x39:
+		name := specialRegister(hw2 & 0xff)
+		if hw2&0xff < 8 {
+			name += [...]string{"", "_g", "_nzcvq", "_nzcvqg"}[hw2>>10&3]
+		}
+		return instruction(mnemonic("msr", false, cond, false), name, reg(hw1&15))
+	case op == 0x3a:
+		hints := [...]string{"nop", "yield", "wfe", "wfi", "sev"}
+		if hint := hw2 & 0x7ff; hint < uint32(len(hints)) {
+			return instruction(mnemonic(hints[hint], false, cond, true))
+		} else if hint&0xf0 == 0xf0 {
+			return instruction(mnemonic("dbg", false, cond, false), immediate(int64(hint&15)))
+		}
+	case op == 0x3b:
+		option, ok := barrierOptions[hw2&15]
+		if !ok {
+			option = immediate(int64(hw2 & 15))
+		}
+		switch hw2 >> 4 & 15 {
+		case 2:
+			return instruction(mnemonic("clrex", false, cond, false))
+		case 4:
+			return instruction(mnemonic("dsb", false, cond, false), option)
+		case 5:
+			return instruction(mnemonic("dmb", false, cond, false), option)
+		case 6:
+			return instruction(mnemonic("isb", false, cond, false), option)
+		}
+	case op == 0x3e || op == 0x3f:
+		return instruction(mnemonic("mrs", false, cond, false), reg(hw2>>8&15), specialRegister(hw2&0xff))
+	case op == 0x7f && hw2>>12&7 == 2:
+		return instruction("udf.w", immediate(int64(hw1&15)<<12|int64(hw2&0xfff)))
+	}
+	return ""
+}
+
+var singleNames = [3]string{"strb", "strh", "str"}
+
+// singleAddress formats the addressing modes of single loads and stores
+// other than literals. It returns whether the instruction is an
+// unprivileged access.
+func singleAddress(hw1, hw2 uint32) (string, bool, bool) {
+	rn := hw1 & 15
+	switch {
+	case hw1&0x80 != 0:
+		return memory(rn, int64(hw2&0xfff), true, false), false, true
+	case hw2&0x800 != 0:
+		index, up, writeback := hw2>>10&1 != 0, hw2>>9&1 != 0, hw2>>8&1 != 0
+		if !index && !writeback {
+			return "", false, false
+		}
+		offset := int64(hw2 & 0xff)
+		if !up {
+			offset = -offset
+		}
+		return memory(rn, offset, index, writeback), index && up && !writeback, true
+	case hw2>>6&0x3f == 0:
+		if shift := hw2 >> 4 & 3; shift != 0 {
+			return fmt.Sprintf("[%s, %s, lsl #%d]", reg(rn), reg(hw2&15), shift), false, true
+		}
+		return fmt.Sprintf("[%s, %s]", reg(rn), reg(hw2&15)), false, true
+	}
+	return "", false, false
+}
+
+func storeSingle(hw1, hw2 uint32, cond string) string {
+	size := hw1 >> 5 & 3
+	if size == 3 {
+		return ""
+	}
+	operand, unprivileged, ok := singleAddress(hw1, hw2)
+	if !ok {
+		return ""
+	}
+	if unprivileged {
+		return instruction(mnemonic(singleNames[size]+"t", false, cond, false), reg(hw2>>12), operand)
+	}
+	return instruction(mnemonic(singleNames[size], false, cond, true), reg(hw2>>12), operand)
+}
+
+func loadSingle(hw1, hw2 uint32, address uint64, cond string) (string, uint64) {
+	rn, rt := hw1&15, hw2>>12
+	size, signed := hw1>>5&3, hw1>>8&1 != 0
+	name := "ldr" + singleNames[size][3:]
+	if signed {
+		name = "ldrs" + singleNames[size][3:]
+	}
+	wide := true
+	if rt == 15 && size < 2 {
+		// Preload hints
+		name, wide = "pld", false
+		if signed {
+			name = "pli"
+		}
+	}
+
+	if rn == 15 {
+		offset := int64(hw2 & 0xfff)
+		if hw1>>7&1 == 0 {
+			offset = -offset
+		}
+		operand := fmt.Sprintf("[pc, %s]", immediate(offset))
+		if name == "pld" || name == "pli" {
+			return instruction(mnemonic(name, false, cond, false), operand), literal(address, offset)
+		}
+		return instruction(mnemonic(name, false, cond, wide), reg(rt), operand), literal(address, offset)
+	}
+	operand, unprivileged, ok := singleAddress(hw1, hw2)
+	if !ok {
+		return "", 0
+	}
+	if name == "pld" || name == "pli" {
+		return instruction(mnemonic(name, false, cond, false), operand), 0
+	}
+	if unprivileged {
+		return instruction(mnemonic(name+"t", false, cond, false), reg(rt), operand), 0
+	}
+	return instruction(mnemonic(name, false, cond, wide), reg(rt), operand), 0
+}
+
+func dataProcessingRegister(hw1, hw2 uint32, cond string) string {
+	if hw2>>12 != 15 {
+		return ""
+	}
+	op1, op2 := hw1>>4&15, hw2>>4&15
+	rn, rd, rm := hw1&15, hw2>>8&15, hw2&15
+	switch {
+	case op1 < 8 && op2 == 0:
+		name := [...]string{"lsl", "lsr", "asr", "ror"}[op1>>1]
+		return instruction(mnemonic(name, op1&1 != 0, cond, true), reg(rd), reg(rn), reg(rm))
+
+	case op1 < 6 && op2&8 != 0:
+		name := [...]string{"sxth", "uxth", "sxtb16", "uxtb16", "sxtb", "uxtb"}[op1]
+		operand := reg(rm)
+		if rotate := hw2 >> 4 & 3; rotate != 0 {
+			operand += fmt.Sprintf(", ror #%d", rotate*8)
+		}
+		if rn == 15 {
+			return instruction(mnemonic(name, false, cond, op1 != 2 && op1 != 3), reg(rd), operand)
+		}
+		name = name[:3] + "a" + name[3:]
+		return instruction(mnemonic(name, false, cond, false), reg(rd), reg(rn), operand)
+
+	case op1&0xc == 8 && op2&0xc == 8:
+		switch op1&3<<2 | op2&3 {
+		case 0x0, 0x1, 0x2, 0x3:
+			name := [...]string{"qadd", "qdadd", "qsub", "qdsub"}[op2&3]
+			return instruction(mnemonic(name, false, cond, false), reg(rd), reg(rm), reg(rn))
+		case 0x4:
+			return instruction(mnemonic("rev", false, cond, true), reg(rd), reg(rm))
+		case 0x5:
+			return instruction(mnemonic("rev16", false, cond, true), reg(rd), reg(rm))
+		case 0x6:
+			return instruction(mnemonic("rbit", false, cond, false), reg(rd), reg(rm))
+		case 0x7:
+			return instruction(mnemonic("revsh", false, cond, true), reg(rd), reg(rm))
+		case 0x8:
+			return instruction(mnemonic("sel", false, cond, false), reg(rd), reg(rn), reg(rm))
+		case 0xc:
+			return instruction(mnemonic("clz", false, cond, false), reg(rd), reg(rm))
+		}
+	}
+	return ""
+}
+
+func multiply(hw1, hw2 uint32, cond string) string {
+	rn, ra, rd, rm := hw1&15, hw2>>12, hw2>>8&15, hw2&15
+	op1, op2 := hw1>>4&7, hw2>>4&3
+	switch {
+	case op1 == 0 && op2 == 0 && ra == 15:
+		return instruction(mnemonic("mul", false, cond, true), reg(rd), reg(rn), reg(rm))
+	case op1 == 0 && op2 == 0:
+		return instruction(mnemonic("mla", false, cond, false), reg(rd), reg(rn), reg(rm), reg(ra))
+	case op1 == 0 && op2 == 1:
+		return instruction(mnemonic("mls", false, cond, false), reg(rd), reg(rn), reg(rm), reg(ra))
+	case op1 == 1:
+		halves := [...]string{"bb", "bt", "tb", "tt"}[op2]
+		if ra == 15 {
+			return instruction(mnemonic("smul"+halves, false, cond, false), reg(rd), reg(rn), reg(rm))
+		}
+		return instruction(mnemonic("smla"+halves, false, cond, false), reg(rd), reg(rn), reg(rm), reg(ra))
+	case op1 == 5 && op2&2 == 0:
+		round := ""
+		if op2 != 0 {
+			round = "r"
+		}
+		if ra == 15 {
+			return instruction(mnemonic("smmul"+round, false, cond, false), reg(rd), reg(rn), reg(rm))
+		}
+		return instruction(mnemonic("smmla"+round, false, cond, false), reg(rd), reg(rn), reg(rm), reg(ra))
+	}
+	return ""
+}
+
+func longMultiply(hw1, hw2 uint32, cond string) string {
+	rn, rdLo, rdHi, rm := hw1&15, hw2>>12, hw2>>8&15, hw2&15
+	switch hw1>>4&7<<4 | hw2>>4&15 {
+	case 0x00:
+		return instruction(mnemonic("smull", false, cond, false), reg(rdLo), reg(rdHi), reg(rn), reg(rm))
+	case 0x1f:
+		return instruction(mnemonic("sdiv", false, cond, false), reg(rdHi), reg(rn), reg(rm))
+	case 0x20:
+		return instruction(mnemonic("umull", false, cond, false), reg(rdLo), reg(rdHi), reg(rn), reg(rm))
+	case 0x3f:
+		return instruction(mnemonic("udiv", false, cond, false), reg(rdHi), reg(rn), reg(rm))
+	case 0x40:
+		return instruction(mnemonic("smlal", false, cond, false), reg(rdLo), reg(rdHi), reg(rn), reg(rm))
+	case 0x60:
+		return instruction(mnemonic("umlal", false, cond, false), reg(rdLo), reg(rdHi), reg(rn), reg(rm))
+	case 0x66:
+		return instruction(mnemonic("umaal", false, cond, false), reg(rdLo), reg(rdHi), reg(rn), reg(rm))
+	}
+	return ""
+}
+
+// vfpRegister names a single precision register from its 4-bit field and
+// extra bit, or a double precision one
+func vfpRegister(v, x uint32, double bool) string {
+	if double {
+		return fmt.Sprintf("d%d", x<<4|v)
+	}
+	return fmt.Sprintf("s%d", v<<1|x)
+}
+
+// coprocessor decodes the floating-point instructions; other coprocessor
+// instructions are not decoded
+func coprocessor(hw1, hw2 uint32, address uint64, cond string) (string, uint64) {
+	coproc := hw2 >> 8 & 15
+	if hw1>>12&1 != 0 || coproc&0xe != 0xa {
+		return "", 0
+	}
+	double := coproc == 11
+	op1 := hw1 >> 4 & 0x3f
+	switch {
+	case op1&0x3e == 0x04: // Transfers between two core registers and extension registers
+		rt, rt2 := reg(hw2>>12), reg(hw1&15)
+		if double {
+			dm := vfpRegister(hw2&15, hw2>>5&1, true)
+			if op1&1 != 0 {
+				return instruction(mnemonic("vmov", false, cond, false), rt, rt2, dm), 0
+			}
+			return instruction(mnemonic("vmov", false, cond, false), dm, rt, rt2), 0
+		}
+		m := hw2&15<<1 | hw2>>5&1
+		sm, sm1 := fmt.Sprintf("s%d", m), fmt.Sprintf("s%d", m+1)
+		if op1&1 != 0 {
+			return instruction(mnemonic("vmov", false, cond, false), rt, rt2, sm, sm1), 0
+		}
+		return instruction(mnemonic("vmov", false, cond, false), sm, sm1, rt, rt2), 0
+
+	case op1&0x20 == 0 && op1&0x3a != 0: // Extension register loads and stores
+		return extensionLoadStore(hw1, hw2, address, cond, double)
+
+	case op1&0x30 == 0x20 && hw2&0x10 == 0:
+		return vfpDataProcessing(hw1, hw2, cond, double), 0
+
+	case op1&0x30 == 0x20: // Transfers between a core register and an extension register
+		rt := hw2 >> 12
+		load := hw1>>4&1 != 0
+		switch a := hw1 >> 5 & 7; {
+		case !double && a == 0:
+			sn := vfpRegister(hw1&15, hw2>>7&1, false)
+			if load {
+				return instruction(mnemonic("vmov", false, cond, false), reg(rt), sn), 0
+			}
+			return instruction(mnemonic("vmov", false, cond, false), sn, reg(rt)), 0
+		case !double && a == 7:
+			system := map[uint32]string{0: "fpsid", 1: "fpscr", 8: "fpexc"}[hw1&15]
+			if system == "" {
+				return "", 0
+			}
+			if !load {
+				return instruction(mnemonic("vmsr", false, cond, false), system, reg(rt)), 0
+			}
+			if rt == 15 {
+				return instruction(mnemonic("vmrs", false, cond, false), "APSR_nzcv", system), 0
+			}
+			return instruction(mnemonic("vmrs", false, cond, false), reg(rt), system), 0
+		case double && a < 2 && hw2&0x60 == 0:
+			scalar := fmt.Sprintf("%s[%d]", vfpRegister(hw1&15, hw2>>7&1, true), a)
+			if load {
+				return instruction(mnemonic("vmov", false, cond, false)+".32", reg(rt), scalar), 0
+			}
+			return instruction(mnemonic("vmov", false, cond, false)+".32", scalar, reg(rt)), 0
+		}
+	}
+	return "", 0
+}
+
+func extensionLoadStore(hw1, hw2 uint32, address uint64, cond string, double bool) (string, uint64) {
+	index, up, writeback, load := hw1>>8&1 != 0, hw1>>7&1 != 0, hw1>>5&1 != 0, hw1>>4&1 != 0
+	rn, vd, imm8 := hw1&15, hw2>>12, hw2&0xff
+	first := vfpRegister(vd, hw1>>6&1, double)
+
+	if index && !writeback {
+		offset := int64(imm8) << 2
+		if !up {
+			offset = -offset
+		}
+		name := "vstr"
+		if load {
+			name = "vldr"
+		}
+		if rn == 15 {
+			return instruction(mnemonic(name, false, cond, false), first, fmt.Sprintf("[pc, %s]", immediate(offset))), literal(address, offset)
+		}
+		return instruction(mnemonic(name, false, cond, false), first, memory(rn, offset, true, false)), 0
+	}
+	if index == up {
+		return "", 0
+	}
+
+	count := imm8
+	if double {
+		count /= 2
+	}
+	if count == 0 {
+		return "", 0
+	}
+	list := "{" + first + "}"
+	if count > 1 {
+		last := first[:1] + strconv.Itoa(mustAtoi(first[1:])+int(count)-1)
+		list = "{" + first + "-" + last + "}"
+	}
+	switch {
+	case rn == 13 && writeback && load && !index:
+		return instruction(mnemonic("vpop", false, cond, false), list), 0
+	case rn == 13 && writeback && !load && index:
+		return instruction(mnemonic("vpush", false, cond, false), list), 0
+	}
+	name := "vstm"
+	if load {
+		name = "vldm"
+	}
+	if index {
+		name += "db"
+	} else {
+		name += "ia"
+	}
+	base := reg(rn)
+	if writeback {
+		base += "!"
+	}
+	return instruction(mnemonic(name, false, cond, false), base, list), 0
+}
+
+func mustAtoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// vfpExpandImm decodes the 8-bit floating-point constant of vmov
+func vfpExpandImm(imm8 uint32) float64 {
+	value := float64(16+imm8&15) / 16 * math.Pow(2, float64(int(imm8>>4&7^4)-3))
+	if imm8&0x80 != 0 {
+		value = -value
+	}
+	return value
+}
+
+func floatImmediate(value float64) string {
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if !strings.Contains(text, ".") {
+		text += ".0"
+	}
+	return "#" + text
+}
+
+func vfpDataProcessing(hw1, hw2 uint32, cond string, double bool) string {
+	d, n, m := hw1>>6&1, hw2>>7&1, hw2>>5&1
+	vn, vd, vm := hw1&15, hw2>>12, hw2&15
+	dd, dn, dm := vfpRegister(vd, d, double), vfpRegister(vn, n, double), vfpRegister(vm, m, double)
+	op := hw2 >> 6 & 1
+	f := ".f32"
+	if double {
+		f = ".f64"
+	}
+	name := func(names ...string) string {
+		return mnemonic(names[op], false, cond, false) + f
+	}
+
+	switch hw1>>5&4 | hw1>>4&3 {
+	case 0:
+		return instruction(name("vmla", "vmls"), dd, dn, dm)
+	case 1:
+		return instruction(name("vnmls", "vnmla"), dd, dn, dm)
+	case 2:
+		return instruction(name("vmul", "vnmul"), dd, dn, dm)
+	case 3:
+		return instruction(name("vadd", "vsub"), dd, dn, dm)
+	case 4:
+		if op == 0 {
+			return instruction(name("vdiv"), dd, dn, dm)
+		}
+	case 5:
+		return instruction(name("vfnms", "vfnma"), dd, dn, dm)
+	case 6:
+		return instruction(name("vfma", "vfms"), dd, dn, dm)
+	case 7:
+		if op == 0 {
+			return instruction(name("vmov"), dd, floatImmediate(vfpExpandImm(vn<<4|vm)))
+		}
+		single := func(v, x uint32) string { return vfpRegister(v, x, false) }
+		switch opc2, high := vn, hw2>>7&1; {
+		case opc2 == 0:
+			return instruction([...]string{"vmov", "vabs"}[high]+cond+f, dd, dm)
+		case opc2 == 1:
+			return instruction([...]string{"vneg", "vsqrt"}[high]+cond+f, dd, dm)
+		case opc2 == 4:
+			return instruction([...]string{"vcmp", "vcmpe"}[high]+cond+f, dd, dm)
+		case opc2 == 5:
+			return instruction([...]string{"vcmp", "vcmpe"}[high]+cond+f, dd, "#0.0")
+		case opc2 == 7 && high == 1:
+			if double {
+				return instruction("vcvt"+cond+".f32.f64", single(vd, d), dm)
+			}
+			return instruction("vcvt"+cond+".f64.f32", vfpRegister(vd, d, true), dm)
+		case opc2 == 8:
+			return instruction("vcvt"+cond+f+[...]string{".u32", ".s32"}[high], dd, single(vm, m))
+		case opc2 == 12 || opc2 == 13:
+			// Without the high bit, rounding follows fpscr
+			convert := [...]string{"vcvtr", "vcvt"}[high]
+			return instruction(convert+cond+[...]string{".u32", ".s32"}[opc2&1]+f, single(vd, d), dm)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,614 @@
+// Package gdb implements a client for the GDB Remote Serial Protocol spoken
+// by the gdbstubs of QEMU and Renode.
+package gdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRunning is returned for commands that need a halted target
+	ErrRunning = errors.New("target is running")
+	// ErrClosed is returned once the connection is closed
+	ErrClosed = errors.New("connection closed")
+	// ErrUnsupported is returned when the stub does not implement a packet
+	ErrUnsupported = errors.New("packet not supported by the stub")
+)
+
+// DefaultTimeout bounds the wait for the reply of a command
+const DefaultTimeout = 10 * time.Second
+
+// maxMemoryChunk is the largest memory transfer of a single packet
+const maxMemoryChunk = 1024
+
+// replyBuffer holds the packets of multi-packet replies such as qRcmd output
+const replyBuffer = 16
+
+// BreakpointType selects the Z packet type
+type BreakpointType int
+
+// Breakpoint and watchpoint types of Z/z packets
+const (
+	SoftwareBreakpoint BreakpointType = iota
+	HardwareBreakpoint
+	WriteWatchpoint
+	ReadWatchpoint
+	AccessWatchpoint
+)
+
+// StopReply is a decoded stop reply packet (T, S, W or X)
+type StopReply struct {
+	Kind         byte           // 'T', 'S', 'W' (exited) or 'X' (terminated)
+	Signal       int            // Signal number, or the exit status for 'W'
+	Thread       string         // Thread ID of T replies
+	Core         int            // Core of T replies, -1 when not reported
+	Reason       string         // swbreak, hwbreak, watch, rwatch, awatch, replaylog, ...
+	WatchAddress uint64         // Data address of watchpoint hits
+	Registers    map[int][]byte // Expedited registers by number
+	Packet       string         // The reply as received
+}
+
+// Exited reports whether the process has exited or was terminated
+func (r *StopReply) Exited() bool {
+	return r.Kind == 'W' || r.Kind == 'X'
+}
+
+// ParseStopReply decodes a stop reply packet
+func ParseStopReply(packet string) (*StopReply, error) {
+	if len(packet) < 3 {
+		return nil, fmt.Errorf("invalid stop reply %q", packet)
+	}
+	reply := &StopReply{Kind: packet[0], Core: -1, Packet: packet}
+	switch reply.Kind {
+	case 'S', 'T', 'W', 'X':
+	default:
+		return nil, fmt.Errorf("invalid stop reply %q", packet)
+	}
+	signal, err := strconv.ParseUint(packet[1:3], 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stop reply %q", packet)
+	}
+	reply.Signal = int(signal)
+	if reply.Kind != 'T' {
+		return reply, nil
+	}
+
+	for _, item := range strings.Split(packet[3:], ";") {
+		key, value, ok := strings.Cut(item, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "thread":
+			reply.Thread = value
+		case "core":
+			if core, err := strconv.ParseUint(value, 16, 32); err == nil {
+				reply.Core = int(core)
+			}
+		case "watch", "rwatch", "awatch":
+			reply.Reason = key
+			reply.WatchAddress, _ = strconv.ParseUint(value, 16, 64)
+		case "swbreak", "hwbreak", "library", "replaylog", "exec", "fork", "vfork", "create":
+			reply.Reason = key
+		default:
+			regnum, err := strconv.ParseUint(key, 16, 16)
+			if err != nil {
+				continue
+			}
+			data, err := hex.DecodeString(value)
+			if err != nil {
+				continue
+			}
+			if reply.Registers == nil {
+				reply.Registers = make(map[int][]byte)
+			}
+			reply.Registers[int(regnum)] = data
+		}
+	}
+	return reply, nil
+}
+
+// Client is a connection to a gdbstub. Commands are serialised; while the
+// target runs only Interrupt and Wait are permitted.
+type Client struct {
+	conn    net.Conn
+	timeout time.Duration
+
+	mu  sync.Mutex // Serialises commands
+	wmu sync.Mutex // Serialises writes
+
+	stateMu  sync.Mutex
+	running  bool
+	noAck    bool
+	lastStop *StopReply
+	onStop   func(*StopReply)
+
+	replies chan string
+	stops   chan *StopReply
+	closed  chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// Dial connects to a gdbstub
+func Dial(ctx context.Context, address string) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewClient performs the protocol handshake over an established connection.
+// The target is assumed to be halted, as gdbstubs stop it on attach.
+func NewClient(conn net.Conn) (*Client, error) {
+	c := &Client{
+		conn:    conn,
+		timeout: DefaultTimeout,
+		replies: make(chan string, replyBuffer),
+		stops:   make(chan *StopReply, 1),
+		closed:  make(chan struct{}),
+	}
+	go c.readLoop()
+
+	if _, err := c.exchange("qSupported:swbreak+;hwbreak+"); err != nil && !errors.Is(err, ErrUnsupported) {
+		c.Close()
+		return nil, err
+	}
+	if reply, err := c.exchange("QStartNoAckMode"); err == nil && reply == "OK" {
+		c.stateMu.Lock()
+		c.noAck = true
+		c.stateMu.Unlock()
+	}
+
+	reply, err := c.exchange("?")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if stop, err := ParseStopReply(reply); err == nil {
+		c.lastStop = stop
+	}
+	return c, nil
+}
+
+// OnStop registers a callback for stop replies of resumed targets. It runs
+// on the connection's reader goroutine and must not issue commands.
+func (c *Client) OnStop(fn func(*StopReply)) {
+	c.stateMu.Lock()
+	c.onStop = fn
+	c.stateMu.Unlock()
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.shutdown(ErrClosed)
+	return nil
+}
+
+// Done is closed when the connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns why the connection was closed
+func (c *Client) Err() error {
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Running reports whether the target was resumed and has not stopped since
+func (c *Client) Running() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.running
+}
+
+// LastStop returns the most recent stop reply
+func (c *Client) LastStop() *StopReply {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.lastStop
+}
+
+// ReadRegister reads a register by number in target byte order
+func (c *Client) ReadRegister(regnum int) ([]byte, error) {
+	reply, err := c.command(fmt.Sprintf("p%x", regnum))
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(reply, "x") {
+		return nil, fmt.Errorf("register %d unavailable", regnum)
+	}
+	return hex.DecodeString(reply)
+}
+
+// WriteRegister writes a register by number in target byte order
+func (c *Client) WriteRegister(regnum int, value []byte) error {
+	return c.expectOK(fmt.Sprintf("P%x=%s", regnum, hex.EncodeToString(value)))
+}
+
+// ReadMemory reads target memory
+func (c *Client) ReadMemory(address uint64, size int) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for size > 0 {
+		chunk := size
+		if chunk > maxMemoryChunk {
+			chunk = maxMemoryChunk
+		}
+		reply, err := c.command(fmt.Sprintf("m%x,%x", address, chunk))
+		if err != nil {
+			return nil, fmt.Errorf("read %#x: %w", address, err)
+		}
+		part, err := hex.DecodeString(reply)
+		if err != nil {
+			return nil, fmt.Errorf("read %#x: invalid reply %q", address, reply)
+		}
+		if len(part) == 0 {
+			return nil, fmt.Errorf("read %#x: no data", address)
+		}
+		data = append(data, part...)
+		address += uint64(len(part))
+		size -= len(part)
+	}
+	return data, nil
+}
+
+// WriteMemory writes target memory
+func (c *Client) WriteMemory(address uint64, data []byte) error {
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > maxMemoryChunk {
+			chunk = chunk[:maxMemoryChunk]
+		}
+		if err := c.expectOK(fmt.Sprintf("M%x,%x:%s", address, len(chunk), hex.EncodeToString(chunk))); err != nil {
+			return fmt.Errorf("write %#x: %w", address, err)
+		}
+		address += uint64(len(chunk))
+		data = data[len(chunk):]
+	}
+	return nil
+}
+
+// InsertBreakpoint inserts a breakpoint or watchpoint. kind is the
+// breakpoint size (e.g. 2 for Thumb) or the watched length.
+func (c *Client) InsertBreakpoint(t BreakpointType, address uint64, kind int) error {
+	return c.expectOK(fmt.Sprintf("Z%d,%x,%x", t, address, kind))
+}
+
+// RemoveBreakpoint removes a breakpoint or watchpoint
+func (c *Client) RemoveBreakpoint(t BreakpointType, address uint64, kind int) error {
+	return c.expectOK(fmt.Sprintf("z%d,%x,%x", t, address, kind))
+}
+
+// Step resumes the target for a single instruction
+func (c *Client) Step() error {
+	return c.resume("s")
+}
+
+// Continue resumes the target
+func (c *Client) Continue() error {
+	return c.resume("c")
+}
+
+// ReverseStep executes one instruction backwards. Stubs support it while
+// they replay a recording, such as QEMU with -icount rr=replay.
+func (c *Client) ReverseStep() error {
+	return c.resume("bs")
+}
+
+// ReverseContinue runs the target backwards until it reaches a breakpoint
+// or the beginning of the recording
+func (c *Client) ReverseContinue() error {
+	return c.resume("bc")
+}
+
+// Wait blocks until the resumed target stops or ctx is done
+func (c *Client) Wait(ctx context.Context) (*StopReply, error) {
+	select {
+	case stop := <-c.stops:
+		return stop, nil
+	default:
+	}
+	if !c.Running() {
+		return c.LastStop(), nil
+	}
+	select {
+	case stop := <-c.stops:
+		return stop, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, c.err
+	}
+}
+
+// Interrupt asks a running target to stop; the stop reply is delivered to
+// Wait and OnStop
+func (c *Client) Interrupt() error {
+	if !c.Running() {
+		return nil
+	}
+	return c.write([]byte{0x03})
+}
+
+// Monitor sends a monitor command (qRcmd) and returns its output
+func (c *Client) Monitor(command string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Running() {
+		return "", ErrRunning
+	}
+
+	c.dropReplies()
+	if err := c.send("qRcmd," + hex.EncodeToString([]byte(command))); err != nil {
+		return "", err
+	}
+	var output strings.Builder
+	for {
+		reply, err := c.receive()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case reply == "OK":
+			return output.String(), nil
+		case reply == "":
+			return "", ErrUnsupported
+		case strings.HasPrefix(reply, "O") && reply != "OK":
+			text, err := hex.DecodeString(reply[1:])
+			if err != nil {
+				return "", fmt.Errorf("invalid monitor output %q", reply)
+			}
+			output.Write(text)
+		case isError(reply):
+			return "", fmt.Errorf("monitor %q: error %s", command, reply[1:])
+		default:
+			text, err := hex.DecodeString(reply)
+			if err != nil {
+				return "", fmt.Errorf("invalid monitor output %q", reply)
+			}
+			output.Write(text)
+			return output.String(), nil
+		}
+	}
+}
+
+// Forward sends a raw packet to the halted target and returns the reply
+// packets unchanged, for proxying another client. Empty and error replies
+// are returned as they are; qRcmd yields its output packets and the final
+// reply.
+func (c *Client) Forward(packet string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Running() {
+		return nil, ErrRunning
+	}
+
+	c.dropReplies()
+	if err := c.send(packet); err != nil {
+		return nil, err
+	}
+	var replies []string
+	for {
+		reply, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+		if !strings.HasPrefix(packet, "qRcmd,") || !strings.HasPrefix(reply, "O") || reply == "OK" {
+			return replies, nil
+		}
+	}
+}
+
+func (c *Client) resume(action string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stateMu.Lock()
+	if c.running {
+		c.stateMu.Unlock()
+		return ErrRunning
+	}
+	c.running = true
+	c.stateMu.Unlock()
+
+	// Drop a stop nobody waited for
+	select {
+	case <-c.stops:
+	default:
+	}
+
+	if err := c.send(action); err != nil {
+		c.stateMu.Lock()
+		c.running = false
+		c.stateMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (c *Client) expectOK(packet string) error {
+	reply, err := c.command(packet)
+	if err != nil {
+		return err
+	}
+	if reply != "OK" {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+// command sends a packet to the halted target and returns its reply
+func (c *Client) command(packet string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Running() {
+		return "", ErrRunning
+	}
+	return c.exchangeLocked(packet)
+}
+
+// exchange sends a packet during the handshake
+func (c *Client) exchange(packet string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exchangeLocked(packet)
+}
+
+func (c *Client) exchangeLocked(packet string) (string, error) {
+	c.dropReplies()
+	if err := c.send(packet); err != nil {
+		return "", err
+	}
+	reply, err := c.receive()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case reply == "":
+		return "", ErrUnsupported
+	case isError(reply):
+		return "", fmt.Errorf("%s: error %s", packetName(packet), reply[1:])
+	}
+	return reply, nil
+}
+
+// dropReplies discards late replies of commands that timed out
+func (c *Client) dropReplies() {
+	for {
+		select {
+		case <-c.replies:
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) receive() (string, error) {
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-c.replies:
+		return reply, nil
+	case <-timer.C:
+		return "", fmt.Errorf("timeout waiting for reply")
+	case <-c.closed:
+		return "", c.err
+	}
+}
+
+func (c *Client) send(packet string) error {
+	return c.write(Frame(packet))
+}
+
+func (c *Client) write(data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		c.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) shutdown(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// readLoop routes stop replies of a resumed target to Wait and OnStop and
+// all other packets to the pending command
+func (c *Client) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		packet, err := ReadPacket(r)
+		if err != nil {
+			c.shutdown(fmt.Errorf("connection lost: %w", err))
+			return
+		}
+
+		c.stateMu.Lock()
+		noAck := c.noAck
+		c.stateMu.Unlock()
+		if !noAck {
+			c.write([]byte{'+'})
+		}
+
+		c.stateMu.Lock()
+		running := c.running
+		c.stateMu.Unlock()
+		if running {
+			if strings.HasPrefix(packet, "O") && packet != "OK" {
+				continue // Console output of the target
+			}
+			if stop, err := ParseStopReply(packet); err == nil {
+				c.stateMu.Lock()
+				c.running = false
+				c.lastStop = stop
+				onStop := c.onStop
+				c.stateMu.Unlock()
+				select {
+				case c.stops <- stop:
+				default:
+				}
+				if onStop != nil {
+					onStop(stop)
+				}
+				continue
+			}
+		}
+
+		select {
+		case c.replies <- packet:
+		default:
+			// Unsolicited packet without a pending command
+		}
+	}
+}
+
+func isError(reply string) bool {
+	if len(reply) != 3 || reply[0] != 'E' {
+		return false
+	}
+	_, err := strconv.ParseUint(reply[1:], 16, 8)
+	return err == nil
+}
+
+func packetName(packet string) string {
+	if i := strings.IndexAny(packet, ":,="); i > 0 {
+		return packet[:i]
+	}
+	if len(packet) > 1 && strings.ContainsRune("mMpPzZ", rune(packet[0])) {
+		return packet[:1]
+	}
+	return packet
+}
@@ -0,0 +1,454 @@
+package gdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, data := range []string{"", "OK", "m20000000,4", "X$#}*y", strings.Repeat("a", 300)} {
+		packet, err := ReadPacket(bufio.NewReader(bytes.NewReader(append([]byte("+-"), Frame(data)...))))
+		if err != nil {
+			t.Fatalf("ReadPacket(Frame(%q)): %v", data, err)
+		}
+		if packet != data {
+			t.Errorf("round trip of %q gave %q", data, packet)
+		}
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	// "0* " is '0' repeated 3 more times
+	packet, err := ReadPacket(bufio.NewReader(strings.NewReader("$0* #" + checksum("0* "))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet != "0000" {
+		t.Errorf("run-length decoding gave %q", packet)
+	}
+
+	packet, err = ReadPacket(bufio.NewReader(strings.NewReader("+\x03")))
+	if err != nil || packet != InterruptPacket {
+		t.Errorf("interrupt gave %q, %v", packet, err)
+	}
+
+	if _, err := ReadPacket(bufio.NewReader(strings.NewReader("$OK#00"))); err == nil {
+		t.Error("expected checksum error")
+	}
+}
+
+func TestParseStopReply(t *testing.T) {
+	reply, err := ParseStopReply("T05thread:p01.01;core:1;swbreak:;0f:00010008;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Kind != 'T' || reply.Signal != 5 || reply.Thread != "p01.01" || reply.Core != 1 || reply.Reason != "swbreak" {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+	if !bytes.Equal(reply.Registers[15], []byte{0x00, 0x01, 0x00, 0x08}) {
+		t.Errorf("unexpected pc %x", reply.Registers[15])
+	}
+
+	reply, err = ParseStopReply("T05watch:20000004;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Reason != "watch" || reply.WatchAddress != 0x20000004 || reply.Core != -1 {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+
+	reply, err = ParseStopReply("W00")
+	if err != nil || !reply.Exited() {
+		t.Errorf("W00 gave %+v, %v", reply, err)
+	}
+
+	for _, packet := range []string{"", "OK", "Q05", "Tzz"} {
+		if _, err := ParseStopReply(packet); err == nil {
+			t.Errorf("ParseStopReply(%q) should fail", packet)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	stub := newFakeStub()
+	client := stub.connect(t)
+	defer client.Close()
+
+	if stop := client.LastStop(); stop == nil || stop.Signal != 5 {
+		t.Fatalf("unexpected initial stop %+v", stop)
+	}
+
+	// Memory transfers larger than a packet are split
+	data := make([]byte, maxMemoryChunk+16)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := client.WriteMemory(0x20000000, data); err != nil {
+		t.Fatal(err)
+	}
+	read, err := client.ReadMemory(0x20000000, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Error("memory read back differs")
+	}
+	if _, err := client.ReadMemory(0x90000000, 4); err == nil {
+		t.Error("expected error reading unmapped memory")
+	}
+
+	if err := client.WriteRegister(15, []byte{0x00, 0x01, 0x00, 0x08}); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := client.ReadRegister(15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pc, []byte{0x00, 0x01, 0x00, 0x08}) {
+		t.Errorf("unexpected pc %x", pc)
+	}
+
+	// Step advances the pc by one instruction
+	if err := client.Step(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := client.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Signal != 5 || stop.Reason != "" || !bytes.Equal(stop.Registers[15], []byte{0x02, 0x01, 0x00, 0x08}) {
+		t.Errorf("unexpected step stop %+v", stop)
+	}
+
+	// Continue runs into the breakpoint
+	if err := client.InsertBreakpoint(SoftwareBreakpoint, 0x08000200, 2); err != nil {
+		t.Fatal(err)
+	}
+	stops := make(chan *StopReply, 1)
+	client.OnStop(func(stop *StopReply) { stops <- stop })
+	if err := client.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err = client.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != "swbreak" || !bytes.Equal(stop.Registers[15], []byte{0x00, 0x02, 0x00, 0x08}) {
+		t.Errorf("unexpected breakpoint stop %+v", stop)
+	}
+	select {
+	case <-stops:
+	case <-time.After(time.Second):
+		t.Error("OnStop was not called")
+	}
+	if err := client.RemoveBreakpoint(SoftwareBreakpoint, 0x08000200, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without breakpoints the target runs until interrupted
+	if err := client.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline, got %v", err)
+	}
+	if _, err := client.ReadMemory(0x20000000, 4); !errors.Is(err, ErrRunning) {
+		t.Errorf("expected ErrRunning, got %v", err)
+	}
+	if err := client.Interrupt(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err = client.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Signal != 2 || client.Running() {
+		t.Errorf("unexpected interrupt stop %+v", stop)
+	}
+
+	output, err := client.Monitor("info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "fake stub\n" {
+		t.Errorf("unexpected monitor output %q", output)
+	}
+
+	if _, err := client.command("vUnknown"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestClientReverse(t *testing.T) {
+	stub := newFakeStub()
+	client := stub.connect(t)
+	defer client.Close()
+
+	// A step back undoes a step
+	if err := client.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ReverseStep(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := client.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stop.Registers[15], []byte{0x00, 0x00, 0x00, 0x08}) {
+		t.Errorf("unexpected reverse step stop %+v", stop)
+	}
+
+	if err := client.ReverseContinue(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err = client.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != "replaylog" || !bytes.Equal(stop.Registers[15], []byte{0x00, 0x00, 0x00, 0x08}) {
+		t.Errorf("unexpected reverse continue stop %+v", stop)
+	}
+}
+
+func TestClientConnectionLost(t *testing.T) {
+	stub := newFakeStub()
+	client := stub.connect(t)
+
+	if err := client.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	stub.conn.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection loss not detected")
+	}
+	if _, err := client.Wait(context.Background()); err == nil {
+		t.Error("expected error waiting on a lost connection")
+	}
+	if client.Err() == nil {
+		t.Error("expected Err after connection loss")
+	}
+}
+
+// fakeStub is a minimal ARM gdbstub: 4-byte pc in register 15, a single
+// RAM region at 0x20000000 and 2-byte instructions
+type fakeStub struct {
+	conn        net.Conn
+	memory      []byte
+	pc          uint32
+	breakpoints map[uint32]bool
+	running     bool
+}
+
+func newFakeStub() *fakeStub {
+	return &fakeStub{
+		memory:      make([]byte, 4096),
+		pc:          0x08000000,
+		breakpoints: make(map[uint32]bool),
+	}
+}
+
+func (s *fakeStub) connect(t *testing.T) *Client {
+	t.Helper()
+	server, conn := net.Pipe()
+	s.conn = server
+	go s.serve()
+	client, err := NewClient(conn)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+func (s *fakeStub) serve() {
+	r := bufio.NewReader(s.conn)
+	for {
+		packet, err := ReadPacket(r)
+		if err != nil {
+			return
+		}
+		for _, reply := range s.handle(packet) {
+			if _, err := s.conn.Write(Frame(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeStub) handle(packet string) []string {
+	switch {
+	case packet == InterruptPacket:
+		if !s.running {
+			return nil
+		}
+		s.running = false
+		return []string{"T02" + s.expedited()}
+	case strings.HasPrefix(packet, "qSupported"):
+		return []string{"PacketSize=1000;swbreak+;hwbreak+"}
+	case packet == "QStartNoAckMode":
+		return []string{"OK"}
+	case packet == "?":
+		return []string{"T05" + s.expedited()}
+	case packet == "s":
+		s.pc += 2
+		return []string{"T05" + s.expedited()}
+	case packet == "bs":
+		s.pc -= 2
+		return []string{"T05" + s.expedited()}
+	case packet == "bc":
+		// Runs back to the beginning of the recording
+		s.pc = 0x08000000
+		return []string{"T05replaylog:begin;" + s.expedited()}
+	case packet == "c":
+		for addr := range s.breakpoints {
+			if addr > s.pc {
+				s.pc = addr
+				return []string{"O" + hex.EncodeToString([]byte("hit\n")), "T05swbreak:;" + s.expedited()}
+			}
+		}
+		s.running = true
+		return nil
+	case strings.HasPrefix(packet, "qRcmd,"):
+		return []string{"O" + hex.EncodeToString([]byte("fake stub\n")), "OK"}
+	case strings.HasPrefix(packet, "p"):
+		if packet != "pf" {
+			return []string{"E01"}
+		}
+		return []string{s.pcHex()}
+	case strings.HasPrefix(packet, "Pf="):
+		pc, _ := hex.DecodeString(packet[3:])
+		if len(pc) != 4 {
+			return []string{"E01"}
+		}
+		s.pc = binary.LittleEndian.Uint32(pc)
+		return []string{"OK"}
+	case strings.HasPrefix(packet, "m"):
+		addr, size := s.parseRange(packet[1:])
+		data, ok := s.region(addr, size)
+		if !ok {
+			return []string{"E14"}
+		}
+		return []string{hex.EncodeToString(data)}
+	case strings.HasPrefix(packet, "M"):
+		header, payload, _ := strings.Cut(packet[1:], ":")
+		addr, size := s.parseRange(header)
+		data, ok := s.region(addr, size)
+		if !ok {
+			return []string{"E14"}
+		}
+		decoded, _ := hex.DecodeString(payload)
+		copy(data, decoded)
+		return []string{"OK"}
+	case strings.HasPrefix(packet, "Z0,"), strings.HasPrefix(packet, "z0,"):
+		addr, _ := s.parseRange(packet[3:])
+		if packet[0] == 'Z' {
+			s.breakpoints[uint32(addr)] = true
+		} else {
+			delete(s.breakpoints, uint32(addr))
+		}
+		return []string{"OK"}
+	}
+	return []string{""}
+}
+
+func (s *fakeStub) expedited() string {
+	return "0f:" + s.pcHex() + ";"
+}
+
+func (s *fakeStub) pcHex() string {
+	return hex.EncodeToString(binary.LittleEndian.AppendUint32(nil, s.pc))
+}
+
+func (s *fakeStub) parseRange(text string) (uint64, int) {
+	addrText, sizeText, _ := strings.Cut(text, ",")
+	addr, _ := strconv.ParseUint(addrText, 16, 64)
+	size, _ := strconv.ParseUint(sizeText, 16, 32)
+	return addr, int(size)
+}
+
+func (s *fakeStub) region(addr uint64, size int) ([]byte, bool) {
+	const base = 0x20000000
+	if addr < base || addr+uint64(size) > base+uint64(len(s.memory)) {
+		return nil, false
+	}
+	return s.memory[addr-base : addr-base+uint64(size)], true
+}
+
+func checksum(data string) string {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return fmt.Sprintf("%02x", sum)
+}
+
+func TestServerConn(t *testing.T) {
+	// Acknowledgements need a buffered connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	local, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	remote, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewServerConn(remote)
+	defer conn.Close()
+	r := bufio.NewReader(local)
+
+	local.Write(Frame("qSupported"))
+	packet, err := conn.ReadPacket()
+	if err != nil || packet != "qSupported" {
+		t.Fatalf("ReadPacket = %q, %v", packet, err)
+	}
+	if ack, _ := r.ReadByte(); ack != '+' {
+		t.Errorf("expected ack, got %q", ack)
+	}
+
+	local.Write(Frame("QStartNoAckMode"))
+	if packet, _ := conn.ReadPacket(); packet != "QStartNoAckMode" {
+		t.Fatalf("unexpected packet %q", packet)
+	}
+	r.ReadByte()
+	conn.StartNoAck()
+	if reply, err := ReadPacket(r); err != nil || reply != "OK" {
+		t.Fatalf("StartNoAck reply %q, %v", reply, err)
+	}
+
+	// No acknowledgements after no-ack mode started
+	local.Write(Frame("g"))
+	local.Write([]byte{0x03})
+	if packet, _ := conn.ReadPacket(); packet != "g" {
+		t.Errorf("unexpected packet %q", packet)
+	}
+	if packet, _ := conn.ReadPacket(); packet != InterruptPacket {
+		t.Errorf("expected interrupt, got %q", packet)
+	}
+	conn.WritePacket("S05")
+	if reply, err := ReadPacket(r); err != nil || reply != "S05" {
+		t.Errorf("reply after no-ack %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,109 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+)
+
+// InterruptPacket is returned by ReadPacket for an out-of-band interrupt
+// request (a lone 0x03 byte)
+const InterruptPacket = "\x03"
+
+// Frame encodes a packet as $data#checksum, escaping special characters
+func Frame(data string) []byte {
+	out := make([]byte, 0, len(data)+4)
+	out = append(out, '$')
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b == '$' || b == '#' || b == '}' || b == '*' {
+			out = append(out, '}')
+			sum += '}'
+			b ^= 0x20
+		}
+		out = append(out, b)
+		sum += b
+	}
+	return append(out, '#', hexDigit(sum>>4), hexDigit(sum&0xf))
+}
+
+// ReadPacket reads the next packet, skipping acknowledgements. Escapes and
+// run-length encoding are decoded and the checksum is verified.
+func ReadPacket(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '$':
+			return readPacketBody(r)
+		case 0x03:
+			return InterruptPacket, nil
+		}
+		// '+', '-' and line noise
+	}
+}
+
+func readPacketBody(r *bufio.Reader) (string, error) {
+	var raw []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '#' {
+			break
+		}
+		raw = append(raw, b)
+	}
+
+	var checksum [2]byte
+	for i := range checksum {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		checksum[i] = b
+	}
+	want, err := strconv.ParseUint(string(checksum[:]), 16, 8)
+	if err != nil {
+		return "", fmt.Errorf("invalid checksum %q", checksum[:])
+	}
+	var sum byte
+	for _, b := range raw {
+		sum += b
+	}
+	if sum != byte(want) {
+		return "", fmt.Errorf("checksum mismatch: got %02x, want %02x", sum, want)
+	}
+
+	data := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '}':
+			if i+1 < len(raw) {
+				i++
+				data = append(data, raw[i]^0x20)
+			}
+		case '*':
+			// Run-length encoding repeats the previous byte
+			if i+1 < len(raw) && len(data) > 0 {
+				i++
+				count := int(raw[i]) - 29
+				last := data[len(data)-1]
+				for j := 0; j < count; j++ {
+					data = append(data, last)
+				}
+			}
+		default:
+			data = append(data, raw[i])
+		}
+	}
+	return string(data), nil
+}
+
+func hexDigit(v byte) byte {
+	return "0123456789abcdef"[v&0xf]
+}
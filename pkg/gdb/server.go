@@ -0,0 +1,67 @@
+package gdb
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// ServerConn is the stub side of a connection from a GDB client
+type ServerConn struct {
+	r     *bufio.Reader
+	w     io.Writer
+	c     io.Closer
+	wmu   sync.Mutex
+	noAck bool
+}
+
+// NewServerConn serves a GDB client over a byte stream
+func NewServerConn(rwc io.ReadWriteCloser) *ServerConn {
+	return &ServerConn{r: bufio.NewReader(rwc), w: rwc, c: rwc}
+}
+
+// ReadPacket reads the next packet of the client and acknowledges it unless
+// no-ack mode was started. Interrupt requests are returned as
+// InterruptPacket.
+func (c *ServerConn) ReadPacket() (string, error) {
+	packet, err := ReadPacket(c.r)
+	if err != nil {
+		return "", err
+	}
+	if packet == InterruptPacket {
+		return packet, nil
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if !c.noAck {
+		if _, err := c.w.Write([]byte{'+'}); err != nil {
+			return "", err
+		}
+	}
+	return packet, nil
+}
+
+// WritePacket sends a packet to the client
+func (c *ServerConn) WritePacket(data string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.w.Write(Frame(data))
+	return err
+}
+
+// StartNoAck answers QStartNoAckMode and stops acknowledging packets
+func (c *ServerConn) StartNoAck() error {
+	if err := c.WritePacket("OK"); err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	c.noAck = true
+	c.wmu.Unlock()
+	return nil
+}
+
+// Close closes the connection
+func (c *ServerConn) Close() error {
+	return c.c.Close()
+}
@@ -0,0 +1,202 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNotFound is returned for unknown job IDs
+	ErrNotFound = errors.New("job not found")
+	// ErrBusy is returned when the session already runs a job
+	ErrBusy = errors.New("session busy")
+)
+
+// Runner runs the jobs of one type
+type Runner interface {
+	// Check validates the options of a job before it is queued
+	Check(ctx context.Context, job *models.Job) error
+	// Run executes a job, reporting its progress in percent, and returns
+	// the result. It stops when ctx is cancelled.
+	Run(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error)
+}
+
+// ArtifactResult is implemented by results that are saved as an artifact,
+// whose URL the job then records
+type ArtifactResult interface {
+	ArtifactURL() string
+}
+
+// Service runs asynchronous jobs. A session runs one job at a time, as jobs
+// drive its target.
+type Service struct {
+	db *gorm.DB
+
+	mu      sync.Mutex
+	runners map[models.JobType]Runner
+	cancels map[string]context.CancelFunc // Of the jobs not finished
+	busy    map[string]string             // Job of each session with one in progress
+}
+
+// NewService creates a new job service
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		db:      db,
+		runners: make(map[models.JobType]Runner),
+		cancels: make(map[string]context.CancelFunc),
+		busy:    make(map[string]string),
+	}
+}
+
+// Register sets the runner of a job type
+func (s *Service) Register(jobType models.JobType, runner Runner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runners[jobType] = runner
+}
+
+// CreateRequest describes a job to run
+type CreateRequest struct {
+	SessionID string          `json:"session_id" binding:"required"`
+	Type      string          `json:"type" binding:"required"`
+	Options   json.RawMessage `json:"options,omitempty" swaggertype:"object"`
+}
+
+// Create validates and starts a job
+func (s *Service) Create(ctx context.Context, req *CreateRequest) (*models.Job, error) {
+	s.mu.Lock()
+	runner, ok := s.runners[models.JobType(req.Type)]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported job type: %s", req.Type)
+	}
+
+	now := time.Now()
+	job := &models.Job{
+		ID:        uuid.New().String(),
+		SessionID: req.SessionID,
+		Type:      req.Type,
+		Status:    string(models.JobPending),
+		Options:   string(req.Options),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := runner.Check(ctx, job); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if running, ok := s.busy[job.SessionID]; ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: session %s runs job %s", ErrBusy, job.SessionID, running)
+	}
+	if err := s.db.WithContext(ctx).Create(job).Error; err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to save job: %w", err)
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.cancels[job.ID] = cancel
+	s.busy[job.SessionID] = job.ID
+	s.mu.Unlock()
+
+	go s.run(runCtx, runner, job)
+	return job, nil
+}
+
+// run executes a job and records its outcome
+func (s *Service) run(ctx context.Context, runner Runner, job *models.Job) {
+	defer func() {
+		s.mu.Lock()
+		s.cancels[job.ID]()
+		delete(s.cancels, job.ID)
+		delete(s.busy, job.SessionID)
+		s.mu.Unlock()
+	}()
+
+	s.update(job.ID, map[string]interface{}{"status": string(models.JobRunning)})
+	result, err := runner.Run(ctx, job, func(percent int) {
+		s.update(job.ID, map[string]interface{}{"progress": percent})
+	})
+
+	now := time.Now()
+	updates := map[string]interface{}{"completed_at": &now}
+	switch {
+	case ctx.Err() != nil:
+		updates["status"] = string(models.JobCancelled)
+	case err != nil:
+		updates["status"] = string(models.JobFailed)
+		updates["error"] = err.Error()
+	default:
+		updates["status"] = string(models.JobCompleted)
+		updates["progress"] = 100
+	}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("Warning: failed to encode result of job %s: %v", job.ID, err)
+		}
+		updates["result"] = string(data)
+		if artifact, ok := result.(ArtifactResult); ok && err == nil {
+			updates["artifact_url"] = artifact.ArtifactURL()
+		}
+	}
+	s.update(job.ID, updates)
+}
+
+func (s *Service) update(jobID string, updates map[string]interface{}) {
+	updates["updated_at"] = time.Now()
+	if err := s.db.Model(&models.Job{}).Where("id = ?", jobID).Updates(updates).Error; err != nil {
+		log.Printf("Warning: failed to update job %s: %v", jobID, err)
+	}
+}
+
+// Get returns a job
+func (s *Service) Get(ctx context.Context, jobID string) (*models.Job, error) {
+	var job models.Job
+	err := s.db.WithContext(ctx).Where("id = ?", jobID).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, jobID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List lists jobs newest first, of one session when sessionID is set
+func (s *Service) List(ctx context.Context, sessionID string) ([]models.Job, error) {
+	query := s.db.WithContext(ctx).Order("created_at DESC")
+	if sessionID != "" {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	var jobs []models.Job
+	if err := query.Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Cancel stops a job that has not finished. Its status becomes cancelled
+// once the runner returns.
+func (s *Service) Cancel(ctx context.Context, jobID string) error {
+	if _, err := s.Get(ctx, jobID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	cancel, ok := s.cancels[jobID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("job %s has finished", jobID)
+	}
+	cancel()
+	return nil
+}
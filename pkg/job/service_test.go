@@ -0,0 +1,133 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// blockingRunner runs until released or cancelled
+type blockingRunner struct {
+	release chan struct{}
+}
+
+func (r *blockingRunner) Check(ctx context.Context, job *models.Job) error {
+	if job.Options == `{"bad":true}` {
+		return errors.New("bad options")
+	}
+	return nil
+}
+
+func (r *blockingRunner) Run(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error) {
+	progress(50)
+	select {
+	case <-r.release:
+		return map[string]int{"answer": 42}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newTestService(t *testing.T) (*Service, *blockingRunner) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Jobs run concurrently; every connection would open its own database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db)
+	runner := &blockingRunner{release: make(chan struct{})}
+	s.Register("test", runner)
+	return s, runner
+}
+
+// waitStatus polls a job until it has the status
+func waitStatus(t *testing.T, s *Service, jobID string, status models.JobStatus) *models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := s.Get(context.Background(), jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == string(status) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", jobID, job.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCreateAndComplete(t *testing.T) {
+	s, runner := newTestService(t)
+	ctx := context.Background()
+
+	if _, err := s.Create(ctx, &CreateRequest{SessionID: "session-1", Type: "unknown"}); err == nil {
+		t.Error("unknown job type accepted")
+	}
+	if _, err := s.Create(ctx, &CreateRequest{SessionID: "session-1", Type: "test", Options: []byte(`{"bad":true}`)}); err == nil {
+		t.Error("invalid options accepted")
+	}
+
+	job, err := s.Create(ctx, &CreateRequest{SessionID: "session-1", Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(ctx, &CreateRequest{SessionID: "session-1", Type: "test"}); !errors.Is(err, ErrBusy) {
+		t.Errorf("second job of a session: %v", err)
+	}
+	if _, err := s.Create(ctx, &CreateRequest{SessionID: "session-2", Type: "test"}); err != nil {
+		t.Errorf("job of another session: %v", err)
+	}
+
+	waitStatus(t, s, job.ID, models.JobRunning)
+	close(runner.release)
+	done := waitStatus(t, s, job.ID, models.JobCompleted)
+	if done.Progress != 100 || done.Result != `{"answer":42}` || done.CompletedAt == nil {
+		t.Errorf("unexpected completed job %+v", done)
+	}
+
+	jobs, err := s.List(ctx, "session-1")
+	if err != nil || len(jobs) != 1 {
+		t.Errorf("got jobs %v, %v", jobs, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	job, err := s.Create(ctx, &CreateRequest{SessionID: "session-1", Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cancel(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, job.ID, models.JobCancelled)
+	if err := s.Cancel(ctx, job.ID); err == nil {
+		t.Error("finished job cancelled")
+	}
+	if err := s.Cancel(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("cancel of unknown job: %v", err)
+	}
+
+	// The session accepts jobs again
+	if _, err := s.Create(ctx, &CreateRequest{SessionID: "session-1", Type: "test"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,15 +6,21 @@ import (
 
 // Session represents a simulation session
 type Session struct {
-	ID          string    `json:"id" gorm:"primaryKey"`
-	Name        string    `json:"name"`
-	Backend     string    `json:"backend"`
-	Status      string    `json:"status"`
-	BoardConfig string    `json:"board_config" gorm:"type:text"`
-	InstanceID  string    `json:"instance_id,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	UserID      string    `json:"user_id"`
+	ID              string    `json:"id" gorm:"primaryKey"`
+	Name            string    `json:"name"`
+	Backend         string    `json:"backend"`
+	Status          string    `json:"status"`
+	BoardConfig     string    `json:"board_config" gorm:"type:text"`
+	InstanceID      string    `json:"instance_id,omitempty"`
+	TemplateID      string    `json:"template_id,omitempty"`
+	TemplateVersion int       `json:"template_version,omitempty"`
+	Mode            string    `json:"mode,omitempty"`      // "replay" for sessions replaying a recording
+	ReplayID        string    `json:"replay_id,omitempty"` // Artifact of the replayed recording
+	Deterministic   bool      `json:"deterministic,omitempty"`
+	Seed            uint64    `json:"seed,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	UserID          string    `json:"user_id"`
 }
 
 // SessionStatus represents session status
@@ -39,6 +45,8 @@ type Program struct {
 	Size       int64     `json:"size"`
 	EntryPoint uint64    `json:"entry_point,omitempty"`
 	LoadAddr   uint64    `json:"load_addr,omitempty"`
+	BuildID    string    `json:"build_id,omitempty" gorm:"index"` // GNU build ID or file hash of ELF programs
+	SHA256     string    `json:"sha256"`
 	Status     string    `json:"status"`
 	CreatedAt  time.Time `json:"created_at"`
 }
@@ -54,6 +62,22 @@ type Snapshot struct {
 	Metadata   string    `json:"metadata" gorm:"type:text"`
 }
 
+// Artifact represents a file a session produced, such as the recording of
+// a record/replay run. Artifacts outlive their session.
+type Artifact struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	SessionID  string    `json:"session_id" gorm:"index"`
+	Type       string    `json:"type"`                 // replay or coverage
+	Status     string    `json:"status"`               // recording, ready
+	ProgramID  string    `json:"program_id,omitempty"` // Program the artifact was produced with
+	BuildID    string    `json:"build_id,omitempty" gorm:"index"` // Of the program, for coverage artifacts
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	Metadata   string    `json:"metadata" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
 // Job represents an async job
 type Job struct {
 	ID          string    `json:"id" gorm:"primaryKey"`
@@ -61,7 +85,9 @@ type Job struct {
 	Type        string    `json:"type"`
 	Status      string    `json:"status"`
 	Progress    int       `json:"progress"`
+	Options     string    `json:"options,omitempty" gorm:"type:text"` // JSON options of the job type
 	Result      string    `json:"result" gorm:"type:text"`
+	Error       string    `json:"error,omitempty"`
 	ArtifactURL string    `json:"artifact_url,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -84,8 +110,10 @@ type JobType string
 
 const (
 	JobCoverage JobType = "coverage"
+	JobProfile  JobType = "profile"
 	JobTrace    JobType = "trace"
 	JobTest     JobType = "test"
+	JobVerify   JobType = "verify"
 )
 
 // Processor represents a processor model
@@ -107,11 +135,29 @@ type Peripheral struct {
 	Name        string    `json:"name"`
 	Type        string    `json:"type"`
 	Description string    `json:"description"`
-	Registers   string    `json:"registers" gorm:"type:text"`
+	DeviceID    string    `json:"device_id,omitempty" gorm:"index"`
+	BaseAddress uint64    `json:"base_address,omitempty"`
+	Size        uint64    `json:"size,omitempty"`
+	Interrupts  string    `json:"interrupts,omitempty" gorm:"type:text"` // JSON interrupt list
+	Registers   string    `json:"registers" gorm:"type:text"`            // JSON register list with fields
 	Backends    string    `json:"backends"` // Comma-separated list
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// Device represents a microcontroller imported from a vendor description
+type Device struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name"`
+	Vendor      string    `json:"vendor"`
+	Version     string    `json:"version"`
+	Description string    `json:"description"`
+	ProcessorID string    `json:"processor_id,omitempty"`
+	CPU         string    `json:"cpu,omitempty" gorm:"type:text"` // JSON CPU description
+	Width       int       `json:"width"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 // Bus represents a bus model
 type Bus struct {
 	ID          string    `json:"id" gorm:"primaryKey"`
@@ -129,12 +175,26 @@ type BoardTemplate struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Backend     string    `json:"backend"`
+	Version     int       `json:"version"`                               // Latest version
 	Config      string    `json:"config" gorm:"type:text"`
+	Extends     string    `json:"extends,omitempty"`                     // Base template ID, optionally "id@version"
+	Parameters  string    `json:"parameters,omitempty" gorm:"type:text"` // JSON parameter declarations
 	Tags        string    `json:"tags"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// BoardTemplateVersion represents an immutable revision of a board template
+type BoardTemplateVersion struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	TemplateID string    `json:"template_id" gorm:"uniqueIndex:idx_template_version"`
+	Version    int       `json:"version" gorm:"uniqueIndex:idx_template_version"`
+	Config     string    `json:"config" gorm:"type:text"`
+	Extends    string    `json:"extends,omitempty"`
+	Parameters string    `json:"parameters,omitempty" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 // User represents a user in the system
 type User struct {
 	ID        string    `json:"id" gorm:"primaryKey"`
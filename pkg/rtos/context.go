@@ -0,0 +1,169 @@
+package rtos
+
+import (
+	"fmt"
+)
+
+// Cortex-M exception frames, stacked by the core on exception entry
+const (
+	basicFrame    = 8 * 4  // r0-r3, r12, lr, pc, xpsr
+	extendedFrame = 26 * 4 // Also s0-s15, fpscr and a reserved word
+	calleeSaved   = 8 * 4  // r4-r11, saved by the context switch
+	fpCalleeSaved = 16 * 4 // s16-s31
+)
+
+// Thread mode EXC_RETURN values of ARMv7-M, which FreeRTOS ports for cores
+// with an FPU save after r4-r11. Bit 4 is clear when the exception frame is
+// extended with floating point registers.
+const (
+	excReturnBasic    = 0xfffffffd
+	excReturnExtended = 0xffffffed
+)
+
+// freeRTOSContextWords is the size of the context the RISC-V port saves
+const freeRTOSContextWords = 30
+
+var basicFrameRegisters = []string{"r0", "r1", "r2", "r3", "r12", "lr", "pc", "xpsr"}
+
+var riscvRegisters = []string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// liveRegisters copies the registers of the CPU
+func (r *reader) liveRegisters() map[string]uint64 {
+	if len(r.Registers) == 0 {
+		return nil
+	}
+	regs := make(map[string]uint64, len(r.Registers))
+	for name, value := range r.Registers {
+		regs[name] = value
+	}
+	return regs
+}
+
+// freeRTOSContext decodes the context a task saved at the top of its stack
+func (r *reader) freeRTOSContext(top uint64) (map[string]uint64, error) {
+	switch r.ISA {
+	case "thumb":
+		return r.freeRTOSCortexM(top)
+	case "riscv32", "riscv64":
+		return r.freeRTOSRISCV(top)
+	}
+	return nil, nil
+}
+
+// freeRTOSCortexM decodes the context of the ARM_CM3, ARM_CM4F and ARM_CM7
+// ports: r4-r11, with an FPU EXC_RETURN and possibly s16-s31, then the
+// exception frame
+func (r *reader) freeRTOSCortexM(top uint64) (map[string]uint64, error) {
+	data, err := r.Memory(top, calleeSaved+4)
+	if err != nil {
+		return nil, fmt.Errorf("read saved context at %#x: %w", top, err)
+	}
+	regs := make(map[string]uint64)
+	for i := 0; i < 8; i++ {
+		regs[fmt.Sprintf("r%d", i+4)] = uint64(r.order.Uint32(data[4*i:]))
+	}
+	frame := top + calleeSaved
+	extended := false
+	switch r.order.Uint32(data[calleeSaved:]) {
+	case excReturnBasic:
+		frame += 4
+	case excReturnExtended:
+		frame += 4 + fpCalleeSaved
+		extended = true
+	}
+	return regs, r.exceptionFrame(regs, frame, extended)
+}
+
+// exceptionFrame decodes the frame a Cortex-M core stacked at sp and sets
+// sp to where it was before the exception
+func (r *reader) exceptionFrame(regs map[string]uint64, sp uint64, extended bool) error {
+	data, err := r.Memory(sp, basicFrame)
+	if err != nil {
+		return fmt.Errorf("read exception frame at %#x: %w", sp, err)
+	}
+	for i, name := range basicFrameRegisters {
+		regs[name] = uint64(r.order.Uint32(data[4*i:]))
+	}
+	size := uint64(basicFrame)
+	if extended {
+		size = extendedFrame
+	}
+	// Bit 9 of the stacked xPSR tells the core aligned the stack
+	regs["sp"] = sp + size
+	if regs["xpsr"]&(1<<9) != 0 {
+		regs["sp"] += 4
+	}
+	return nil
+}
+
+// freeRTOSRISCV decodes the context of the RISC-V port: mepc, x1, x5-x31
+// and mstatus, each a register wide
+func (r *reader) freeRTOSRISCV(top uint64) (map[string]uint64, error) {
+	data, err := r.Memory(top, freeRTOSContextWords*r.pointer)
+	if err != nil {
+		return nil, fmt.Errorf("read saved context at %#x: %w", top, err)
+	}
+	word := func(i int) uint64 {
+		return decode(data[i*r.pointer:(i+1)*r.pointer], r.order)
+	}
+	regs := map[string]uint64{"pc": word(0), "ra": word(1)}
+	for i := 5; i < 32; i++ {
+		regs[riscvRegisters[i]] = word(i - 3)
+	}
+	regs["sp"] = top + uint64(freeRTOSContextWords*r.pointer)
+	return regs, nil
+}
+
+// zephyrContext decodes the registers a thread saved in callee_saved
+func (r *reader) zephyrContext(thread *object) (map[string]uint64, error) {
+	switch r.ISA {
+	case "thumb":
+		return r.zephyrCortexM(thread)
+	case "riscv32", "riscv64":
+		return r.zephyrRISCV(thread)
+	}
+	return nil, nil
+}
+
+// zephyrCortexM decodes v1-v8 (r4-r11) of callee_saved and the exception
+// frame at psp. Threads that used the FPU keep EXC_RETURN in
+// arch.mode_exc_return.
+func (r *reader) zephyrCortexM(thread *object) (map[string]uint64, error) {
+	regs := make(map[string]uint64)
+	for i := 1; i <= 8; i++ {
+		value, err := thread.uint(fmt.Sprintf("callee_saved.v%d", i))
+		if err != nil {
+			return nil, err
+		}
+		regs[fmt.Sprintf("r%d", i+3)] = value
+	}
+	psp, err := thread.uint("callee_saved.psp")
+	if err != nil {
+		return nil, err
+	}
+	extended := false
+	if excReturn, err := thread.uint("arch.mode_exc_return"); err == nil {
+		extended = excReturn&0x10 == 0
+	}
+	return regs, r.exceptionFrame(regs, psp, extended)
+}
+
+// zephyrRISCV decodes sp, ra and s0-s11 of callee_saved. The thread
+// resumes at ra, from where it called the scheduler.
+func (r *reader) zephyrRISCV(thread *object) (map[string]uint64, error) {
+	regs := make(map[string]uint64)
+	for _, name := range []string{"sp", "ra", "s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11"} {
+		value, err := thread.uint("callee_saved." + name)
+		if err != nil {
+			return nil, err
+		}
+		regs[name] = value
+	}
+	regs["pc"] = regs["ra"]
+	return regs, nil
+}
@@ -0,0 +1,181 @@
+package rtos
+
+import (
+	"debug/dwarf"
+	"fmt"
+)
+
+// freeRTOSFill is the byte FreeRTOS fills new stacks with
+const freeRTOSFill = 0xa5
+
+// freeRTOSList is a kernel list of tasks and the state of the tasks on it
+type freeRTOSList struct {
+	symbol string
+	state  string
+}
+
+// freeRTOSLists are the task lists after the ready lists, in the order a
+// task found on several of them is reported from the first
+var freeRTOSLists = []freeRTOSList{
+	{"xPendingReadyList", "ready"},
+	{"xDelayedTaskList1", "blocked"},
+	{"xDelayedTaskList2", "blocked"},
+	{"xSuspendedTaskList", "suspended"},
+	{"xTasksWaitingTermination", "deleted"},
+}
+
+// freeRTOSTypes are the kernel structures
+type freeRTOSTypes struct {
+	tcb, list, item *dwarf.StructType
+}
+
+// freeRTOS lists the tasks of FreeRTOS from its task lists
+func (r *reader) freeRTOS() (*Threads, error) {
+	var types freeRTOSTypes
+	var err error
+	if types.tcb, err = r.structType("tskTaskControlBlock", "tskTCB", "TCB_t"); err != nil {
+		return nil, err
+	}
+	if types.list, err = r.structType("xLIST", "List_t"); err != nil {
+		return nil, err
+	}
+	if types.item, err = r.structType("xLIST_ITEM", "ListItem_t"); err != nil {
+		return nil, err
+	}
+
+	address, err := r.variable("pxCurrentTCB")
+	if err != nil {
+		return nil, err
+	}
+	current, err := r.readPointer(address)
+	if err != nil {
+		return nil, fmt.Errorf("read pxCurrentTCB: %w", err)
+	}
+
+	threads := &Threads{RTOS: "freertos", Current: current, Threads: []Thread{}}
+	seen := make(map[uint64]bool)
+	add := func(tcb uint64, state string) error {
+		if seen[tcb] || len(threads.Threads) >= maxThreads {
+			return nil
+		}
+		seen[tcb] = true
+		thread, err := r.freeRTOSTask(&types, tcb, state, tcb == current)
+		if err != nil {
+			return err
+		}
+		threads.Threads = append(threads.Threads, *thread)
+		return nil
+	}
+
+	// pxReadyTasksLists holds one list per priority
+	ready, size, ok := r.Table.Symbol("pxReadyTasksLists")
+	if !ok {
+		return nil, fmt.Errorf("no symbol pxReadyTasksLists")
+	}
+	for i := uint64(0); i < size/uint64(types.list.ByteSize); i++ {
+		if err := r.walkList(&types, ready+i*uint64(types.list.ByteSize), "ready", add); err != nil {
+			return nil, err
+		}
+	}
+	for _, list := range freeRTOSLists {
+		address, _, ok := r.Table.Symbol(list.symbol)
+		if !ok {
+			continue // Compiled out, like the suspended list without vTaskSuspend
+		}
+		if err := r.walkList(&types, address, list.state, add); err != nil {
+			return nil, err
+		}
+	}
+	// The running task is on a ready list, unless it is about to block
+	if current != 0 && !seen[current] {
+		if err := add(current, "running"); err != nil {
+			return nil, err
+		}
+	}
+	return threads, nil
+}
+
+// walkList calls add for the owner of each item on a list
+func (r *reader) walkList(types *freeRTOSTypes, address uint64, state string, add func(uint64, string) error) error {
+	list, err := r.read(address, types.list)
+	if err != nil {
+		return err
+	}
+	count, err := list.uint("uxNumberOfItems")
+	if err != nil {
+		return err
+	}
+	endOffset, _, ok := member(types.list, "xListEnd")
+	if !ok {
+		return fmt.Errorf("%s has no member xListEnd", types.list.StructName)
+	}
+	end := address + uint64(endOffset)
+	next, err := list.uint("xListEnd.pxNext")
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count && i < maxThreads && next != end; i++ {
+		item, err := r.read(next, types.item)
+		if err != nil {
+			return err
+		}
+		owner, err := item.uint("pvOwner")
+		if err != nil {
+			return err
+		}
+		if err := add(owner, state); err != nil {
+			return err
+		}
+		if next, err = item.uint("pxNext"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeRTOSTask reads a task control block
+func (r *reader) freeRTOSTask(types *freeRTOSTypes, address uint64, state string, current bool) (*Thread, error) {
+	tcb, err := r.read(address, types.tcb)
+	if err != nil {
+		return nil, err
+	}
+	priority, err := tcb.int("uxPriority")
+	if err != nil {
+		return nil, err
+	}
+	top, err := tcb.uint("pxTopOfStack")
+	if err != nil {
+		return nil, err
+	}
+	start, err := tcb.uint("pxStack")
+	if err != nil {
+		return nil, err
+	}
+	// pxEndOfStack points to the last word of the stack, where kept
+	var size uint64
+	if end, err := tcb.uint("pxEndOfStack"); err == nil && end >= start {
+		size = end + uint64(r.pointer) - start
+	}
+	// A suspended task waiting on an event without a timeout is blocked
+	if state == "suspended" {
+		if container, err := tcb.uint("xEventListItem.pvContainer|pxContainer"); err == nil && container != 0 {
+			state = "blocked"
+		}
+	}
+
+	thread := &Thread{ID: address, Name: tcb.string("pcTaskName"), State: state, Priority: priority, Current: current}
+	sp := top
+	if current {
+		thread.State = "running"
+		thread.Registers = r.liveRegisters()
+		if live, ok := thread.Registers["sp"]; ok {
+			sp = live
+		}
+	} else if regs, err := r.freeRTOSContext(top); err != nil {
+		thread.Error = err.Error()
+	} else {
+		thread.Registers = regs
+	}
+	thread.Stack = r.stack(start, size, sp, freeRTOSFill)
+	return thread, nil
+}
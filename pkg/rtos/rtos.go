@@ -0,0 +1,317 @@
+// Package rtos lists the threads of programs running FreeRTOS or Zephyr.
+// It walks the kernel's data structures in the memory of the halted target,
+// using the structure layouts of the program's debug information, and
+// decodes the registers each thread saved when it was switched out, so a
+// thread can be inspected and unwound like the running one.
+package rtos
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// ErrNoRTOS is returned for programs without a supported RTOS
+var ErrNoRTOS = errors.New("program runs no supported RTOS")
+
+// maxThreads bounds walking kernel lists, which may be corrupt
+const maxThreads = 1024
+
+// Target is the halted target whose threads are listed
+type Target struct {
+	Table     *symbols.Table
+	Memory    symbols.Memory
+	Registers map[string]uint64 // Of the CPU, which runs the current thread
+	ISA       string            // Saved registers are decoded for thumb (Cortex-M), riscv32 and riscv64
+}
+
+// Threads are the threads of an RTOS
+type Threads struct {
+	RTOS    string   `json:"rtos"`              // "freertos" or "zephyr"
+	Current uint64   `json:"current,omitempty"` // ID of the running thread
+	Threads []Thread `json:"threads"`
+}
+
+// Thread is a thread of an RTOS
+type Thread struct {
+	ID        uint64            `json:"id"` // Address of the thread's control block
+	Name      string            `json:"name"`
+	State     string            `json:"state"`
+	Priority  int64             `json:"priority"` // As the kernel counts it: FreeRTOS runs higher numbers first, Zephyr lower ones
+	Current   bool              `json:"current,omitempty"`
+	Stack     Stack             `json:"stack"`
+	Registers map[string]uint64 `json:"registers,omitempty"` // Of the CPU for the current thread, else from the saved context
+	Error     string            `json:"error,omitempty"`     // Why the registers could not be read
+}
+
+// Stack describes the stack of a thread, which grows down
+type Stack struct {
+	Start   uint64 `json:"start"`            // Lowest address
+	Size    uint64 `json:"size,omitempty"`   // 0 when the kernel does not record it
+	Pointer uint64 `json:"pointer"`          // Current or saved stack pointer
+	Used    uint64 `json:"used,omitempty"`   // Bytes above the stack pointer, when the size is known
+	Unused  uint64 `json:"unused,omitempty"` // Bytes at the bottom still holding the fill pattern: the stack never grew into them
+}
+
+// Find returns the thread with an ID
+func (t *Threads) Find(id uint64) (*Thread, bool) {
+	for i := range t.Threads {
+		if t.Threads[i].ID == id {
+			return &t.Threads[i], true
+		}
+	}
+	return nil, false
+}
+
+// List reads the threads of the RTOS the program runs
+func List(target *Target) (*Threads, error) {
+	r := newReader(target)
+	if _, _, ok := target.Table.Symbol("pxCurrentTCB"); ok {
+		return r.freeRTOS()
+	}
+	if _, _, ok := target.Table.Symbol("_kernel"); ok {
+		return r.zephyr()
+	}
+	return nil, ErrNoRTOS
+}
+
+// reader reads kernel data structures from target memory
+type reader struct {
+	*Target
+	order   binary.ByteOrder
+	pointer int // Bytes
+}
+
+func newReader(target *Target) *reader {
+	r := &reader{Target: target, order: binary.LittleEndian, pointer: 8}
+	if target.Table.ByteOrder == "big" {
+		r.order = binary.BigEndian
+	}
+	if target.Table.Class == elf.ELFCLASS32 {
+		r.pointer = 4
+	}
+	return r
+}
+
+// structType returns the first structure found under one of its names
+func (r *reader) structType(names ...string) (*dwarf.StructType, error) {
+	for _, name := range names {
+		if st, ok := r.Table.StructType(name); ok {
+			return st, nil
+		}
+	}
+	return nil, fmt.Errorf("no type %s in the debug information", names[0])
+}
+
+// variable returns the address of a kernel variable
+func (r *reader) variable(name string) (uint64, error) {
+	address, _, ok := r.Table.Symbol(name)
+	if !ok {
+		return 0, fmt.Errorf("no symbol %s", name)
+	}
+	return address, nil
+}
+
+func (r *reader) readPointer(address uint64) (uint64, error) {
+	data, err := r.Memory(address, r.pointer)
+	if err != nil {
+		return 0, err
+	}
+	return decode(data, r.order), nil
+}
+
+// read reads a structure
+func (r *reader) read(address uint64, typ *dwarf.StructType) (*object, error) {
+	if address == 0 {
+		return nil, fmt.Errorf("null %s pointer", typ.StructName)
+	}
+	data, err := r.Memory(address, int(typ.ByteSize))
+	if err != nil {
+		return nil, fmt.Errorf("read %s at %#x: %w", typ.StructName, address, err)
+	}
+	if len(data) < int(typ.ByteSize) {
+		return nil, fmt.Errorf("read %s at %#x: short read", typ.StructName, address)
+	}
+	return &object{r: r, address: address, typ: typ, data: data}, nil
+}
+
+// fillChunk is the size of the reads scanning for the stack fill pattern
+const fillChunk = 256
+
+// stack describes a stack. The fill pattern is searched from its bottom
+// up to the stack pointer.
+func (r *reader) stack(start, size, sp uint64, fill byte) Stack {
+	stack := Stack{Start: start, Size: size, Pointer: sp}
+	if size > 0 && sp >= start && sp <= start+size {
+		stack.Used = start + size - sp
+	}
+	limit := sp
+	if limit < start || (size > 0 && limit > start+size) {
+		limit = start + size
+	}
+	for address := start; address < limit; {
+		n := limit - address
+		if n > fillChunk {
+			n = fillChunk
+		}
+		data, err := r.Memory(address, int(n))
+		if err != nil {
+			break
+		}
+		for _, b := range data {
+			if b != fill {
+				return stack
+			}
+			stack.Unused++
+		}
+		address += n
+	}
+	return stack
+}
+
+// object is a structure read from target memory
+type object struct {
+	r       *reader
+	address uint64
+	typ     *dwarf.StructType
+	data    []byte
+}
+
+// has reports whether the structure has a member
+func (o *object) has(path string) bool {
+	_, _, ok := member(o.typ, path)
+	return ok
+}
+
+// field returns the bytes and type of a member
+func (o *object) field(path string) ([]byte, dwarf.Type, error) {
+	offset, typ, ok := member(o.typ, path)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s has no member %s", o.typ.StructName, path)
+	}
+	size := typ.Size()
+	if offset < 0 || size <= 0 || offset+size > int64(len(o.data)) {
+		return nil, nil, fmt.Errorf("member %s of %s is out of bounds", path, o.typ.StructName)
+	}
+	return o.data[offset : offset+size], typ, nil
+}
+
+// uint reads an integer or pointer member
+func (o *object) uint(path string) (uint64, error) {
+	data, _, err := o.field(path)
+	if err != nil {
+		return 0, err
+	}
+	return decode(data, o.r.order), nil
+}
+
+// int reads an integer member, extending the sign of signed types
+func (o *object) int(path string) (int64, error) {
+	data, typ, err := o.field(path)
+	if err != nil {
+		return 0, err
+	}
+	value := decode(data, o.r.order)
+	if _, signed := underlying(typ).(*dwarf.IntType); signed && len(data) < 8 {
+		shift := uint(64 - 8*len(data))
+		return int64(value<<shift) >> shift, nil
+	}
+	return int64(value), nil
+}
+
+// string reads a character array member
+func (o *object) string(path string) string {
+	data, _, err := o.field(path)
+	if err != nil {
+		return ""
+	}
+	if end := strings.IndexByte(string(data), 0); end >= 0 {
+		data = data[:end]
+	}
+	return string(data)
+}
+
+// member finds a member by a dotted path. Members of anonymous structures
+// and unions are found as if they were direct members, an array stands for
+// its first element, and a path component may list alternative names
+// separated by |, for members renamed between kernel versions.
+func member(typ *dwarf.StructType, path string) (int64, dwarf.Type, bool) {
+	var offset int64
+	var current dwarf.Type = typ
+	for _, name := range strings.Split(path, ".") {
+		st, ok := elementOf(current).(*dwarf.StructType)
+		if !ok {
+			return 0, nil, false
+		}
+		var found bool
+		for _, alternative := range strings.Split(name, "|") {
+			var fieldOffset int64
+			if fieldOffset, current, found = findField(st, alternative); found {
+				offset += fieldOffset
+				break
+			}
+		}
+		if !found {
+			return 0, nil, false
+		}
+	}
+	return offset, current, true
+}
+
+func findField(st *dwarf.StructType, name string) (int64, dwarf.Type, bool) {
+	for _, field := range st.Field {
+		if field.Name == name {
+			return field.ByteOffset, field.Type, true
+		}
+	}
+	for _, field := range st.Field {
+		if inner, ok := underlying(field.Type).(*dwarf.StructType); ok && field.Name == "" {
+			if offset, typ, found := findField(inner, name); found {
+				return field.ByteOffset + offset, typ, true
+			}
+		}
+	}
+	return 0, nil, false
+}
+
+// elementOf strips typedefs, qualifiers and arrays
+func elementOf(typ dwarf.Type) dwarf.Type {
+	for {
+		typ = underlying(typ)
+		array, ok := typ.(*dwarf.ArrayType)
+		if !ok {
+			return typ
+		}
+		typ = array.Type
+	}
+}
+
+// underlying strips typedefs and qualifiers
+func underlying(typ dwarf.Type) dwarf.Type {
+	for {
+		switch ty := typ.(type) {
+		case *dwarf.TypedefType:
+			typ = ty.Type
+		case *dwarf.QualType:
+			typ = ty.Type
+		default:
+			return typ
+		}
+	}
+}
+
+// decode decodes an unsigned integer of up to eight bytes
+func decode(data []byte, order binary.ByteOrder) uint64 {
+	var buf [8]byte
+	if order == binary.BigEndian {
+		copy(buf[8-len(data):], data)
+		return binary.BigEndian.Uint64(buf[:])
+	}
+	copy(buf[:], data)
+	return binary.LittleEndian.Uint64(buf[:])
+}
@@ -0,0 +1,217 @@
+package rtos
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// openFixture opens an ELF file of testdata, built from the C file of the
+// same name, with its data sections as the memory of the target
+func openFixture(t *testing.T, name string) *Target {
+	t.Helper()
+	table, err := symbols.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := elf.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	memory := make(map[uint64][]byte)
+	for _, section := range file.Sections {
+		if section.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		data := make([]byte, section.Size)
+		if section.Type != elf.SHT_NOBITS {
+			if data, err = section.Data(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		memory[section.Addr] = data
+	}
+	return &Target{Table: table, Memory: testMemory(memory)}
+}
+
+func testMemory(memory map[uint64][]byte) symbols.Memory {
+	return func(address uint64, size int) ([]byte, error) {
+		for base, data := range memory {
+			if address >= base && address+uint64(size) <= base+uint64(len(data)) {
+				return data[address-base : address-base+uint64(size)], nil
+			}
+		}
+		return nil, fmt.Errorf("unmapped address %#x", address)
+	}
+}
+
+func symbol(t *testing.T, target *Target, name string) uint64 {
+	t.Helper()
+	address, _, ok := target.Table.Symbol(name)
+	if !ok {
+		t.Fatalf("no symbol %s", name)
+	}
+	return address
+}
+
+func TestListFreeRTOS(t *testing.T) {
+	target := openFixture(t, "freertos.elf")
+	mainStack := symbol(t, target, "stack_main")
+	target.Registers = map[string]uint64{"sp": mainStack + 16*8, "pc": 0x401000}
+
+	threads, err := List(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threads.RTOS != "freertos" || threads.Current != symbol(t, target, "main_tcb") {
+		t.Errorf("unexpected threads %+v", threads)
+	}
+	want := []struct {
+		name     string
+		state    string
+		priority int64
+		stack    Stack
+	}{
+		{"IDLE", "ready", 0, Stack{Start: symbol(t, target, "stack_idle"), Size: 256, Used: 32, Unused: 192}},
+		{"main", "running", 2, Stack{Start: mainStack, Size: 256, Used: 128, Unused: 64}},
+		{"worker", "blocked", 1, Stack{Start: symbol(t, target, "stack_worker"), Size: 256, Used: 64, Unused: 128}},
+		{"waiter", "blocked", 1, Stack{Start: symbol(t, target, "stack_waiter"), Size: 256, Used: 64}},
+	}
+	if len(threads.Threads) != len(want) {
+		t.Fatalf("got %d threads, want %d: %+v", len(threads.Threads), len(want), threads.Threads)
+	}
+	for i, w := range want {
+		got := threads.Threads[i]
+		w.stack.Pointer = w.stack.Start + w.stack.Size - w.stack.Used
+		if got.Name != w.name || got.State != w.state || got.Priority != w.priority || got.Stack != w.stack {
+			t.Errorf("thread %d = %+v, want %+v", i, got, w)
+		}
+		if got.Current != (w.name == "main") {
+			t.Errorf("thread %s: current %v", got.Name, got.Current)
+		}
+	}
+	if regs := threads.Threads[1].Registers; regs["pc"] != 0x401000 {
+		t.Errorf("current thread has registers %v, want those of the CPU", regs)
+	}
+	if regs := threads.Threads[0].Registers; regs != nil {
+		t.Errorf("registers %v decoded for an unknown instruction set", regs)
+	}
+	if thread, ok := threads.Find(symbol(t, target, "worker_tcb")); !ok || thread.Name != "worker" {
+		t.Errorf("Find(worker_tcb) = %+v, %v", thread, ok)
+	}
+}
+
+func TestListZephyr(t *testing.T) {
+	target := openFixture(t, "zephyr.elf")
+	threads, err := List(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threads.RTOS != "zephyr" || threads.Current != symbol(t, target, "main_thread") {
+		t.Errorf("unexpected threads %+v", threads)
+	}
+	want := []struct {
+		name     string
+		state    string
+		priority int64
+		unused   uint64
+	}{
+		{"main", "running", 0, 0},
+		{"worker", "pending", 5, 160},
+		{"idle", "ready", 15, 224},
+	}
+	if len(threads.Threads) != len(want) {
+		t.Fatalf("got %d threads, want %d: %+v", len(threads.Threads), len(want), threads.Threads)
+	}
+	for i, w := range want {
+		got := threads.Threads[i]
+		if got.Name != w.name || got.State != w.state || got.Priority != w.priority || got.Stack.Size != 256 || got.Stack.Unused != w.unused {
+			t.Errorf("thread %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestListNoRTOS(t *testing.T) {
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := List(&Target{Table: table}); err != ErrNoRTOS {
+		t.Errorf("got %v, want ErrNoRTOS", err)
+	}
+}
+
+func words(values ...uint32) []byte {
+	var data []byte
+	for _, value := range values {
+		data = binary.LittleEndian.AppendUint32(data, value)
+	}
+	return data
+}
+
+func TestFreeRTOSCortexM(t *testing.T) {
+	frame := []uint32{0, 1, 2, 3, 12, 0x08000201, 0x08000300, 0x01000000}
+	tests := []struct {
+		name    string
+		context []uint32
+		sp      uint64
+	}{
+		{"ARM_CM3", []uint32{4, 5, 6, 7, 8, 9, 10, 11}, 0x20000000 + 16*4},
+		{"ARM_CM4F", []uint32{4, 5, 6, 7, 8, 9, 10, 11, excReturnBasic}, 0x20000000 + 17*4},
+		{"ARM_CM4F with FPU", append([]uint32{4, 5, 6, 7, 8, 9, 10, 11, excReturnExtended}, make([]uint32, 16)...), 0x20000000 + 17*4 + 16*4 + 18*4},
+	}
+	for _, tt := range tests {
+		data := words(append(append([]uint32{}, tt.context...), frame...)...)
+		data = append(data, make([]byte, 18*4)...)
+		r := newReader(&Target{
+			Table:  &symbols.Table{Class: elf.ELFCLASS32, ByteOrder: "little"},
+			Memory: testMemory(map[uint64][]byte{0x20000000: data}),
+			ISA:    "thumb",
+		})
+		regs, err := r.freeRTOSContext(0x20000000)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if regs["r4"] != 4 || regs["r11"] != 11 || regs["r12"] != 12 || regs["lr"] != 0x08000201 || regs["pc"] != 0x08000300 || regs["sp"] != tt.sp {
+			t.Errorf("%s: got %v", tt.name, regs)
+		}
+	}
+}
+
+func TestExceptionFrameAligned(t *testing.T) {
+	r := newReader(&Target{
+		Table:  &symbols.Table{Class: elf.ELFCLASS32, ByteOrder: "little"},
+		Memory: testMemory(map[uint64][]byte{0x20000000: words(0, 0, 0, 0, 0, 0, 0x08000300, 0x01000200)}),
+	})
+	regs := make(map[string]uint64)
+	if err := r.exceptionFrame(regs, 0x20000000, false); err != nil {
+		t.Fatal(err)
+	}
+	if regs["sp"] != 0x20000024 {
+		t.Errorf("sp %#x, want 0x20000024", regs["sp"])
+	}
+}
+
+func TestFreeRTOSRISCV(t *testing.T) {
+	context := make([]uint32, freeRTOSContextWords)
+	for i := range context {
+		context[i] = uint32(0x100 + i)
+	}
+	r := newReader(&Target{
+		Table:  &symbols.Table{Class: elf.ELFCLASS32, ByteOrder: "little"},
+		Memory: testMemory(map[uint64][]byte{0x80001000: words(context...)}),
+		ISA:    "riscv32",
+	})
+	regs, err := r.freeRTOSContext(0x80001000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regs["pc"] != 0x100 || regs["ra"] != 0x101 || regs["t0"] != 0x102 || regs["s0"] != 0x105 || regs["t6"] != 0x11c || regs["sp"] != 0x80001000+30*4 {
+		t.Errorf("got %v", regs)
+	}
+}
@@ -0,0 +1,80 @@
+/* Test fixture for pkg/rtos with the task structures of FreeRTOS 10 and a
+ * halted kernel: main runs, idle is ready, worker is delayed and waiter
+ * waits on a queue without a timeout. Rebuild with
+ *   gcc -g -O0 -fno-pie -no-pie -nostdlib -static \
+ *       -fdebug-prefix-map=$PWD=/build -o freertos.elf freertos.c
+ */
+
+typedef unsigned long UBaseType_t;
+typedef unsigned long StackType_t;
+
+struct xLIST;
+
+struct xLIST_ITEM {
+	UBaseType_t xItemValue;
+	struct xLIST_ITEM *pxNext;
+	struct xLIST_ITEM *pxPrevious;
+	void *pvOwner;
+	struct xLIST *pxContainer;
+};
+typedef struct xLIST_ITEM ListItem_t;
+
+struct xMINI_LIST_ITEM {
+	UBaseType_t xItemValue;
+	struct xLIST_ITEM *pxNext;
+	struct xLIST_ITEM *pxPrevious;
+};
+typedef struct xMINI_LIST_ITEM MiniListItem_t;
+
+typedef struct xLIST {
+	volatile UBaseType_t uxNumberOfItems;
+	ListItem_t *pxIndex;
+	MiniListItem_t xListEnd;
+} List_t;
+
+typedef struct tskTaskControlBlock {
+	volatile StackType_t *pxTopOfStack;
+	ListItem_t xStateListItem;
+	ListItem_t xEventListItem;
+	UBaseType_t uxPriority;
+	StackType_t *pxStack;
+	char pcTaskName[16];
+	StackType_t *pxEndOfStack;
+} TCB_t;
+
+#define FILL 0xa5a5a5a5a5a5a5a5UL
+#define END(list) ((ListItem_t *)&(list).xListEnd)
+#define EMPTY(list) {0, END(list), {~0UL, END(list), END(list)}}
+#define ONE(tcb) {1, &(tcb).xStateListItem, {~0UL, &(tcb).xStateListItem, &(tcb).xStateListItem}}
+#define ITEM(list, tcb) {0, END(list), END(list), &(tcb), &(list)}
+
+StackType_t stack_main[32] = {[0 ... 7] = FILL};
+StackType_t stack_idle[32] = {[0 ... 23] = FILL};
+StackType_t stack_worker[32] = {[0 ... 15] = FILL};
+StackType_t stack_waiter[32];
+
+extern List_t pxReadyTasksLists[3];
+extern List_t xDelayedTaskList1, xSuspendedTaskList, queue;
+
+TCB_t main_tcb = {&stack_main[20], ITEM(pxReadyTasksLists[2], main_tcb), {0}, 2, stack_main, "main", &stack_main[31]};
+TCB_t idle_tcb = {&stack_idle[28], ITEM(pxReadyTasksLists[0], idle_tcb), {0}, 0, stack_idle, "IDLE", &stack_idle[31]};
+TCB_t worker_tcb = {&stack_worker[24], ITEM(xDelayedTaskList1, worker_tcb), {0}, 1, stack_worker, "worker", &stack_worker[31]};
+TCB_t waiter_tcb = {&stack_waiter[24], ITEM(xSuspendedTaskList, waiter_tcb), {0, 0, 0, &waiter_tcb, &queue}, 1, stack_waiter, "waiter", &stack_waiter[31]};
+
+List_t pxReadyTasksLists[3] = {
+	ONE(idle_tcb),
+	EMPTY(pxReadyTasksLists[1]),
+	ONE(main_tcb),
+};
+List_t xDelayedTaskList1 = ONE(worker_tcb);
+List_t xDelayedTaskList2 = EMPTY(xDelayedTaskList2);
+List_t xPendingReadyList = EMPTY(xPendingReadyList);
+List_t xSuspendedTaskList = ONE(waiter_tcb);
+List_t xTasksWaitingTermination = EMPTY(xTasksWaitingTermination);
+List_t queue = EMPTY(queue);
+
+TCB_t *volatile pxCurrentTCB = &main_tcb;
+
+void _start(void)
+{
+}
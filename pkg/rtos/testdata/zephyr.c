@@ -0,0 +1,100 @@
+/* Test fixture for pkg/rtos with the thread structures of Zephyr 3 built
+ * with CONFIG_THREAD_MONITOR, CONFIG_THREAD_NAME and
+ * CONFIG_THREAD_STACK_INFO: main runs, worker pends and idle is ready.
+ * Rebuild with
+ *   gcc -g -O0 -fno-pie -no-pie -nostdlib -static \
+ *       -fdebug-prefix-map=$PWD=/build -o zephyr.elf zephyr.c
+ */
+
+typedef unsigned char uint8_t;
+typedef signed char int8_t;
+typedef unsigned short uint16_t;
+typedef unsigned int uint32_t;
+typedef unsigned long uintptr_t;
+
+struct _thread_base {
+	void *qnode[2];
+	void *pended_on;
+	uint8_t user_options;
+	uint8_t thread_state;
+	union {
+		struct {
+			int8_t prio;
+			uint8_t sched_locked;
+		};
+		uint16_t preempt;
+	};
+};
+
+struct _callee_saved {
+	uint32_t v1, v2, v3, v4, v5, v6, v7, v8;
+	uint32_t psp;
+};
+
+struct _thread_stack_info {
+	uintptr_t start;
+	uintptr_t size;
+	uintptr_t delta;
+};
+
+struct _thread_arch {
+	uint32_t basepri;
+	uint32_t swap_return_value;
+	int8_t mode_exc_return;
+};
+
+struct k_thread {
+	struct _thread_base base;
+	struct _callee_saved callee_saved;
+	struct k_thread *next_thread;
+	char name[32];
+	struct _thread_stack_info stack_info;
+	struct _thread_arch arch;
+};
+
+struct _cpu {
+	uint32_t nested;
+	void *irq_stack;
+	struct k_thread *current;
+	struct k_thread *idle_thread;
+};
+
+struct z_kernel {
+	struct _cpu cpus[1];
+	struct k_thread *threads;
+};
+
+#define FILL 0xaaaaaaaaaaaaaaaaUL
+
+uintptr_t stack_main[32];
+uintptr_t stack_worker[32] = {[0 ... 19] = FILL};
+uintptr_t stack_idle[32] = {[0 ... 27] = FILL};
+
+extern struct k_thread worker, idle;
+
+struct k_thread main_thread = {
+	.base = {.thread_state = 0, .prio = 0},
+	.next_thread = &worker,
+	.name = "main",
+	.stack_info = {(uintptr_t)stack_main, sizeof(stack_main)},
+};
+struct k_thread worker = {
+	.base = {.thread_state = 0x02, .prio = 5},
+	.next_thread = &idle,
+	.name = "worker",
+	.stack_info = {(uintptr_t)stack_worker, sizeof(stack_worker)},
+};
+struct k_thread idle = {
+	.base = {.thread_state = 0x80, .prio = 15},
+	.name = "idle",
+	.stack_info = {(uintptr_t)stack_idle, sizeof(stack_idle)},
+};
+
+struct z_kernel _kernel = {
+	.cpus = {{.current = &main_thread, .idle_thread = &idle}},
+	.threads = &main_thread,
+};
+
+void _start(void)
+{
+}
@@ -0,0 +1,135 @@
+package rtos
+
+import (
+	"debug/dwarf"
+)
+
+// zephyrFill is the byte Zephyr fills new stacks with under
+// CONFIG_INIT_STACKS
+const zephyrFill = 0xaa
+
+// Bits of base.thread_state
+const (
+	zephyrDummy     = 1 << 0
+	zephyrPending   = 1 << 1
+	zephyrPrestart  = 1 << 2
+	zephyrDead      = 1 << 3
+	zephyrSuspended = 1 << 4
+	zephyrAborting  = 1 << 5
+	zephyrQueued    = 1 << 7
+)
+
+// zephyr lists the threads of Zephyr. All threads are found only with
+// CONFIG_THREAD_MONITOR, which links them through _kernel.threads;
+// otherwise the current thread is listed alone.
+func (r *reader) zephyr() (*Threads, error) {
+	kernelType, err := r.structType("z_kernel", "_kernel")
+	if err != nil {
+		return nil, err
+	}
+	threadType, err := r.structType("k_thread")
+	if err != nil {
+		return nil, err
+	}
+	address, err := r.variable("_kernel")
+	if err != nil {
+		return nil, err
+	}
+	kernel, err := r.read(address, kernelType)
+	if err != nil {
+		return nil, err
+	}
+	current, err := kernel.uint("cpus.current")
+	if err != nil {
+		if current, err = kernel.uint("current"); err != nil {
+			return nil, err
+		}
+	}
+
+	threads := &Threads{RTOS: "zephyr", Current: current, Threads: []Thread{}}
+	if !kernel.has("threads") {
+		if current != 0 {
+			thread, _, err := r.zephyrThread(threadType, current, true)
+			if err != nil {
+				return nil, err
+			}
+			threads.Threads = append(threads.Threads, *thread)
+		}
+		return threads, nil
+	}
+	next, err := kernel.uint("threads")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint64]bool)
+	for next != 0 && !seen[next] && len(threads.Threads) < maxThreads {
+		seen[next] = true
+		thread, following, err := r.zephyrThread(threadType, next, next == current)
+		if err != nil {
+			return nil, err
+		}
+		threads.Threads = append(threads.Threads, *thread)
+		next = following
+	}
+	return threads, nil
+}
+
+// zephyrThread reads a thread and the address of the next one
+func (r *reader) zephyrThread(typ *dwarf.StructType, address uint64, current bool) (*Thread, uint64, error) {
+	kthread, err := r.read(address, typ)
+	if err != nil {
+		return nil, 0, err
+	}
+	state, err := kthread.uint("base.thread_state")
+	if err != nil {
+		return nil, 0, err
+	}
+	priority, err := kthread.int("base.prio")
+	if err != nil {
+		return nil, 0, err
+	}
+	next, _ := kthread.uint("next_thread")
+
+	thread := &Thread{ID: address, Name: kthread.string("name"), State: zephyrState(state), Priority: priority, Current: current}
+	if current {
+		thread.State = "running"
+		thread.Registers = r.liveRegisters()
+	} else if regs, err := r.zephyrContext(kthread); err != nil {
+		thread.Error = err.Error()
+	} else {
+		thread.Registers = regs
+	}
+
+	// Without CONFIG_THREAD_STACK_INFO the stack is unknown
+	start, err := kthread.uint("stack_info.start")
+	if err == nil {
+		size, _ := kthread.uint("stack_info.size")
+		sp := thread.Registers["sp"]
+		if sp == 0 {
+			sp, _ = kthread.uint("callee_saved.psp|sp")
+		}
+		thread.Stack = r.stack(start, size, sp, zephyrFill)
+	}
+	return thread, next, nil
+}
+
+// zephyrState names the state of a thread that is not running
+func zephyrState(state uint64) string {
+	switch {
+	case state&zephyrDead != 0:
+		return "dead"
+	case state&zephyrAborting != 0:
+		return "aborting"
+	case state&zephyrSuspended != 0:
+		return "suspended"
+	case state&zephyrPending != 0:
+		return "pending"
+	case state&zephyrPrestart != 0:
+		return "prestart"
+	case state&zephyrDummy != 0:
+		return "dummy"
+	case state&zephyrQueued != 0:
+		return "ready"
+	}
+	return "sleeping" // Waiting for a timeout only
+}
@@ -0,0 +1,253 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/condition"
+)
+
+// ErrBreakpointNotFound is returned for unknown breakpoint IDs
+var ErrBreakpointNotFound = errors.New("breakpoint not found")
+
+// BreakpointRegistry holds the breakpoints of a session. It is the source of
+// truth across power cycles and backend reconnects: breakpoints set while
+// the instance is off are installed when it powers on, and all breakpoints
+// are installed again after a reset.
+type BreakpointRegistry struct {
+	mu    sync.Mutex
+	next  int
+	items map[string]*adapters.Breakpoint
+	order []string
+}
+
+func newBreakpointRegistry() *BreakpointRegistry {
+	return &BreakpointRegistry{items: make(map[string]*adapters.Breakpoint)}
+}
+
+// List returns the breakpoints in creation order
+func (r *BreakpointRegistry) List() []adapters.Breakpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]adapters.Breakpoint, 0, len(r.order))
+	for _, id := range r.order {
+		list = append(list, *r.items[id])
+	}
+	return list
+}
+
+// Get returns a breakpoint by ID
+func (r *BreakpointRegistry) Get(id string) (adapters.Breakpoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bp, ok := r.items[id]
+	if !ok {
+		return adapters.Breakpoint{}, false
+	}
+	return *bp, true
+}
+
+func (r *BreakpointRegistry) assignID(bp *adapters.Breakpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if bp.ID == "" {
+		r.next++
+		bp.ID = fmt.Sprintf("bp-%d", r.next)
+		for r.items[bp.ID] != nil {
+			r.next++
+			bp.ID = fmt.Sprintf("bp-%d", r.next)
+		}
+	}
+}
+
+func (r *BreakpointRegistry) put(bp adapters.Breakpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.items[bp.ID]; !exists {
+		r.order = append(r.order, bp.ID)
+	}
+	r.items[bp.ID] = &bp
+}
+
+// hit counts a hit of a breakpoint whose condition holds and reports
+// whether it stops the target, which it does once the ignore count is used
+// up
+func (r *BreakpointRegistry) hit(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bp, ok := r.items[id]
+	if !ok {
+		return true
+	}
+	bp.HitCount++
+	return bp.HitCount > bp.IgnoreCount
+}
+
+func (r *BreakpointRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.items, id)
+	for i, other := range r.order {
+		if other == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// SetBreakpoint creates a breakpoint, or replaces the one with the same ID.
+// It is installed right away when the instance is powered on. A location
+// is resolved with the symbols of the session's latest ELF program; when a
+// source line has code in several places, the first one is used. The
+// location of a watchpoint is a variable, whose size is watched unless a
+// length is given, or an address. Hit counts start at zero.
+func (s *Service) SetBreakpoint(ctx context.Context, sessionID string, bp *adapters.Breakpoint) error {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkBreakpoint(ctx, sessionID, bp); err != nil {
+		return err
+	}
+
+	runtime.Breakpoints.assignID(bp)
+	if runtime.powered() {
+		if err := runtime.Adapter.SetBreakpoint(ctx, runtime.InstanceID, bp); err != nil {
+			return err
+		}
+	}
+	runtime.Breakpoints.put(*bp)
+	return nil
+}
+
+// checkBreakpoint validates a breakpoint, resolves its location and fills
+// in defaults
+func (s *Service) checkBreakpoint(ctx context.Context, sessionID string, bp *adapters.Breakpoint) error {
+	switch bp.Type {
+	case "":
+		bp.Type = adapters.BreakpointSoftware
+	case adapters.BreakpointSoftware, adapters.BreakpointHardware, adapters.WatchWrite, adapters.WatchRead, adapters.WatchAccess:
+	default:
+		return fmt.Errorf("invalid breakpoint type %q", bp.Type)
+	}
+	if bp.IgnoreCount < 0 {
+		return fmt.Errorf("ignore count must not be negative")
+	}
+	if bp.Condition != "" {
+		if _, err := condition.Parse(bp.Condition); err != nil {
+			return err
+		}
+	}
+	bp.HitCount = 0
+
+	if !bp.IsWatchpoint() {
+		bp.Length = 0
+		if bp.Location != "" {
+			addresses, err := s.ResolveLocation(ctx, sessionID, "", bp.Location)
+			if err != nil {
+				return err
+			}
+			bp.Address = addresses[0]
+		}
+		return nil
+	}
+
+	if bp.Location != "" {
+		address, size, err := s.resolveData(ctx, sessionID, bp.Location)
+		if err != nil {
+			return err
+		}
+		bp.Address = address
+		if bp.Length == 0 {
+			bp.Length = size
+		}
+	}
+	if bp.Length == 0 {
+		bp.Length = adapters.DefaultWatchLength
+	}
+	return nil
+}
+
+// resolveData resolves an address or the name of a variable of the
+// session's latest ELF program to its address and size
+func (s *Service) resolveData(ctx context.Context, sessionID, location string) (uint64, uint64, error) {
+	if address, err := strconv.ParseUint(location, 0, 64); err == nil {
+		return address, 0, nil
+	}
+	table, _, err := s.Symbols(ctx, sessionID, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	address, size, ok := table.Symbol(location)
+	if !ok {
+		return 0, 0, fmt.Errorf("no variable %q", location)
+	}
+	return address, size, nil
+}
+
+// ListBreakpoints returns the breakpoints of a session
+func (s *Service) ListBreakpoints(sessionID string) ([]adapters.Breakpoint, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.Breakpoints.List(), nil
+}
+
+// GetBreakpoint returns a breakpoint of a session
+func (s *Service) GetBreakpoint(sessionID, id string) (*adapters.Breakpoint, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	bp, ok := runtime.Breakpoints.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBreakpointNotFound, id)
+	}
+	return &bp, nil
+}
+
+// RemoveBreakpoint deletes a breakpoint, removing it from the instance when
+// it is powered on
+func (s *Service) RemoveBreakpoint(ctx context.Context, sessionID, id string) error {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return err
+	}
+	if _, ok := runtime.Breakpoints.Get(id); !ok {
+		return fmt.Errorf("%w: %s", ErrBreakpointNotFound, id)
+	}
+	if runtime.powered() {
+		if err := runtime.Adapter.RemoveBreakpoint(ctx, runtime.InstanceID, id); err != nil {
+			return err
+		}
+	}
+	runtime.Breakpoints.remove(id)
+	return nil
+}
+
+// installBreakpoints installs all registered breakpoints in the instance.
+// Failures are logged; the breakpoints stay registered.
+func (s *Service) installBreakpoints(ctx context.Context, runtime *SessionRuntime) {
+	for _, bp := range runtime.Breakpoints.List() {
+		bp := bp
+		if err := runtime.Adapter.SetBreakpoint(ctx, runtime.InstanceID, &bp); err != nil {
+			log.Printf("Warning: failed to install breakpoint %s in session %s: %v", bp.ID, runtime.Session.ID, err)
+		}
+	}
+}
+
+func (s *Service) runtime(sessionID string) (*SessionRuntime, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	runtime, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+	return runtime, nil
+}
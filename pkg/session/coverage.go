@@ -0,0 +1,401 @@
+package session
+
+import (
+	"context"
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/coverage"
+	"github.com/forfire912/virServer/pkg/disasm"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// ArtifactCoverage is the type of coverage report artifacts
+const ArtifactCoverage = "coverage"
+
+// Files of coverage report artifacts
+const (
+	CoverageDrcovFile     = "coverage.drcov" // Executed blocks
+	CoverageReportFile    = "coverage.json"  // The report as JSON
+	CoverageLCOVFile      = "lcov.info"
+	CoverageCoberturaFile = "coverage.xml"
+	CoverageHTMLFile      = "index.html"
+)
+
+// Limits of coverage jobs
+const (
+	defaultCoverageTimeout = 60 * time.Second
+	maxCoverageTimeout     = 10 * time.Minute
+)
+
+// CoverageOptions are the options of a coverage job
+type CoverageOptions struct {
+	ProgramID  string `json:"program_id"`            // Most recently uploaded ELF program when empty
+	Until      string `json:"until,omitempty"`       // Location at which the run ends; at its first stop otherwise
+	TimeoutSec int    `json:"timeout_sec,omitempty"` // Time after which the run ends, default 60
+}
+
+// ErrCoverageNotFound is returned when a build has no coverage reports to
+// merge or compare
+var ErrCoverageNotFound = errors.New("coverage not found")
+
+// CoverageMetadata is the metadata of coverage report artifacts
+type CoverageMetadata struct {
+	JobID   string   `json:"job_id,omitempty"` // Job that ran the program
+	Jobs    []string `json:"jobs,omitempty"`   // Jobs whose reports were merged
+	BuildID string   `json:"build_id"`
+	Files   []string `json:"files"`
+}
+
+// CoverageFile is the summary of a source file in a coverage result
+type CoverageFile struct {
+	Path    string           `json:"path"`
+	Summary coverage.Summary `json:"summary"`
+}
+
+// CoverageResult is the result of a coverage job. The lines of each file
+// are in the report artifact.
+type CoverageResult struct {
+	ProgramID  string              `json:"program_id"`
+	BuildID    string              `json:"build_id"`
+	ArtifactID string              `json:"artifact_id"`
+	Stop       *adapters.StopEvent `json:"stop,omitempty"` // Absent when the run ended at the timeout
+	Summary    coverage.Summary    `json:"summary"`
+	Files      []CoverageFile      `json:"files"`
+}
+
+// ArtifactURL returns the API path of the report artifact
+func (r *CoverageResult) ArtifactURL() string {
+	return "/api/v1/artifacts/" + r.ArtifactID
+}
+
+// CoverageRunner runs coverage jobs, which run an ELF program of a session
+// once from power on and map the blocks it executed to its source lines,
+// functions and branches
+type CoverageRunner struct {
+	sessions *Service
+}
+
+// NewCoverageRunner creates the runner of coverage jobs
+func NewCoverageRunner(sessions *Service) *CoverageRunner {
+	return &CoverageRunner{sessions: sessions}
+}
+
+// Check validates the options of a coverage job and that the backend
+// collects coverage of programs with debug information
+func (r *CoverageRunner) Check(ctx context.Context, job *models.Job) error {
+	runtime, err := r.sessions.runtime(job.SessionID)
+	if err != nil {
+		return err
+	}
+	if !runtime.Adapter.GetCapabilities().Features["coverage"] {
+		return fmt.Errorf("backend %s does not support coverage", runtime.Session.Backend)
+	}
+	options, err := coverageOptions(job)
+	if err != nil {
+		return err
+	}
+	table, _, err := r.sessions.Symbols(ctx, job.SessionID, options.ProgramID)
+	if err != nil {
+		return err
+	}
+	if !table.HasDebugInfo() {
+		return symbols.ErrNoDebugInfo
+	}
+	return nil
+}
+
+// Run executes a coverage job. The session is powered off afterwards.
+func (r *CoverageRunner) Run(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error) {
+	options, err := coverageOptions(job)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.sessions.Coverage(ctx, job.SessionID, job.ID, options, progress)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func coverageOptions(job *models.Job) (*CoverageOptions, error) {
+	options := &CoverageOptions{}
+	if job.Options != "" {
+		if err := json.Unmarshal([]byte(job.Options), options); err != nil {
+			return nil, fmt.Errorf("invalid coverage options: %w", err)
+		}
+	}
+	if options.TimeoutSec < 0 || time.Duration(options.TimeoutSec)*time.Second > maxCoverageTimeout {
+		return nil, fmt.Errorf("timeout_sec must be at most %d", int(maxCoverageTimeout.Seconds()))
+	}
+	return options, nil
+}
+
+// Coverage runs an ELF program with coverage collection until it stops or
+// the timeout expires, and saves the reports as an artifact of the session
+func (s *Service) Coverage(ctx context.Context, sessionID, jobID string, options *CoverageOptions, progress func(int)) (*CoverageResult, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	table, program, err := s.Symbols(ctx, sessionID, options.ProgramID)
+	if err != nil {
+		return nil, err
+	}
+	isa, err := s.instructionSet(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultCoverageTimeout
+	if options.TimeoutSec > 0 {
+		timeout = time.Duration(options.TimeoutSec) * time.Second
+	}
+
+	if options.Until != "" {
+		remove, err := s.setUntil(ctx, sessionID, options.Until)
+		if err != nil {
+			return nil, err
+		}
+		defer remove()
+	}
+
+	defer s.leavePoweredOff(runtime)
+	stop, err := s.runProgram(ctx, runtime, program.ID, &adapters.StartOptions{Coverage: true}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	progress(50)
+	// The backend writes the executed blocks when it exits
+	if err := s.PowerControl(ctx, sessionID, "off"); err != nil {
+		return nil, err
+	}
+	path, err := runtime.Adapter.ExportCoverage(ctx, runtime.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("export coverage: %w", err)
+	}
+	blocks, err := readDrcov(path)
+	if err != nil {
+		return nil, err
+	}
+	report, err := coverage.Build(table, branchSet(isa, table.Machine), blocks)
+	if err != nil {
+		return nil, err
+	}
+	progress(75)
+
+	artifact := &models.Artifact{SessionID: sessionID, ProgramID: program.ID}
+	if err := s.saveCoverage(ctx, artifact, &CoverageMetadata{JobID: jobID}, program.Name, blocks, report); err != nil {
+		return nil, err
+	}
+	result := &CoverageResult{
+		ProgramID:  program.ID,
+		BuildID:    report.BuildID,
+		ArtifactID: artifact.ID,
+		Summary:    report.Summary,
+		Files:      make([]CoverageFile, 0, len(report.Files)),
+	}
+	if stop.Reason != adapters.StopRunning {
+		result.Stop = stop
+	}
+	for _, f := range report.Files {
+		result.Files = append(result.Files, CoverageFile{Path: f.Path, Summary: f.Summary})
+	}
+	progress(100)
+	return result, nil
+}
+
+// branchSet returns the instruction set in which the branches of a program
+// are found, or "" when its code cannot be disassembled in that of the node
+func branchSet(isa string, machine elf.Machine) string {
+	switch {
+	case machine == elf.EM_ARM && (isa == disasm.ARM || isa == disasm.Thumb),
+		machine == elf.EM_AARCH64 && isa == disasm.ARM64,
+		machine == elf.EM_RISCV && (isa == disasm.RISCV32 || isa == disasm.RISCV64):
+		return isa
+	}
+	return ""
+}
+
+func readDrcov(path string) ([]coverage.Block, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read coverage: %w", err)
+	}
+	defer f.Close()
+	return coverage.ReadDrcov(f)
+}
+
+// saveCoverage writes the executed blocks and the reports of a build as a
+// coverage artifact, completing artifact and its metadata
+func (s *Service) saveCoverage(ctx context.Context, artifact *models.Artifact, metadata *CoverageMetadata, title string, blocks []coverage.Block, report *coverage.Report) error {
+	metadata.BuildID = report.BuildID
+	metadata.Files = []string{CoverageDrcovFile, CoverageReportFile, CoverageLCOVFile, CoverageCoberturaFile, CoverageHTMLFile}
+	artifact.Type = ArtifactCoverage
+	artifact.BuildID = report.BuildID
+	return s.saveArtifact(ctx, artifact, s.coverageDir, metadata, map[string]func(io.Writer) error{
+		CoverageDrcovFile:     func(w io.Writer) error { return coverage.WriteDrcov(w, title, blocks) },
+		CoverageReportFile:    func(w io.Writer) error { return writeJSON(w, report) },
+		CoverageLCOVFile:      func(w io.Writer) error { return coverage.WriteLCOV(w, report) },
+		CoverageCoberturaFile: func(w io.Writer) error { return coverage.WriteCobertura(w, report) },
+		CoverageHTMLFile: func(w io.Writer) error {
+			return coverage.WriteHTML(w, "Coverage of "+title, report)
+		},
+	})
+}
+
+// MergeCoverageRequest selects the coverage jobs of a build to merge
+type MergeCoverageRequest struct {
+	BuildID string   `json:"build_id" binding:"required"`
+	JobIDs  []string `json:"job_ids,omitempty"` // All coverage jobs of the build when empty
+}
+
+// MergedCoverage is the aggregated coverage of several jobs. The lines of
+// each file are in the report artifact.
+type MergedCoverage struct {
+	BuildID    string           `json:"build_id"`
+	ArtifactID string           `json:"artifact_id"`
+	JobIDs     []string         `json:"job_ids"`
+	Summary    coverage.Summary `json:"summary"`
+	Files      []CoverageFile   `json:"files"`
+}
+
+// coverageSource is the report artifact of a coverage job
+type coverageSource struct {
+	jobID    string
+	artifact models.Artifact
+}
+
+// MergeCoverage merges the coverage of several jobs that ran one build,
+// possibly in different sessions, into an aggregated report. The report
+// is saved as a coverage artifact that belongs to no session.
+func (s *Service) MergeCoverage(ctx context.Context, req *MergeCoverageRequest) (*MergedCoverage, error) {
+	sources, err := s.coverageSources(ctx, req.BuildID, req.JobIDs)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*coverage.Report, 0, len(sources))
+	executed := make(map[coverage.Block]bool)
+	var blocks []coverage.Block
+	jobIDs := make([]string, 0, len(sources))
+	for _, source := range sources {
+		report, err := readCoverageReport(source.artifact.Path)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+		jobBlocks, err := readDrcov(filepath.Join(source.artifact.Path, CoverageDrcovFile))
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range jobBlocks {
+			if !executed[block] {
+				executed[block] = true
+				blocks = append(blocks, block)
+			}
+		}
+		jobIDs = append(jobIDs, source.jobID)
+	}
+	report, err := coverage.Merge(reports...)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact := &models.Artifact{}
+	if err := s.saveCoverage(ctx, artifact, &CoverageMetadata{Jobs: jobIDs}, "build "+req.BuildID, blocks, report); err != nil {
+		return nil, err
+	}
+	merged := &MergedCoverage{
+		BuildID:    report.BuildID,
+		ArtifactID: artifact.ID,
+		JobIDs:     jobIDs,
+		Summary:    report.Summary,
+		Files:      make([]CoverageFile, 0, len(report.Files)),
+	}
+	for _, f := range report.Files {
+		merged.Files = append(merged.Files, CoverageFile{Path: f.Path, Summary: f.Summary})
+	}
+	return merged, nil
+}
+
+// CoverageDelta compares the coverage of two builds, each merged over all
+// its coverage jobs
+func (s *Service) CoverageDelta(ctx context.Context, baseBuildID, headBuildID string) (*coverage.Delta, error) {
+	var reports [2]*coverage.Report
+	for i, buildID := range []string{baseBuildID, headBuildID} {
+		sources, err := s.coverageSources(ctx, buildID, nil)
+		if err != nil {
+			return nil, err
+		}
+		var build []*coverage.Report
+		for _, source := range sources {
+			report, err := readCoverageReport(source.artifact.Path)
+			if err != nil {
+				return nil, err
+			}
+			build = append(build, report)
+		}
+		if reports[i], err = coverage.Merge(build...); err != nil {
+			return nil, err
+		}
+	}
+	return coverage.Diff(reports[0], reports[1]), nil
+}
+
+// coverageSources returns the report artifacts of the coverage jobs of a
+// build, oldest first, or of the given jobs only
+func (s *Service) coverageSources(ctx context.Context, buildID string, jobIDs []string) ([]coverageSource, error) {
+	var artifacts []models.Artifact
+	err := s.db.WithContext(ctx).
+		Where("type = ? AND status = ? AND build_id = ?", ArtifactCoverage, ArtifactReady, buildID).
+		Order("created_at").Find(&artifacts).Error
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(jobIDs))
+	for _, id := range jobIDs {
+		wanted[id] = true
+	}
+
+	var sources []coverageSource
+	found := make(map[string]bool)
+	for _, artifact := range artifacts {
+		var metadata CoverageMetadata
+		if err := json.Unmarshal([]byte(artifact.Metadata), &metadata); err != nil || metadata.JobID == "" {
+			continue // Merged reports
+		}
+		if len(wanted) > 0 && !wanted[metadata.JobID] {
+			continue
+		}
+		sources = append(sources, coverageSource{jobID: metadata.JobID, artifact: artifact})
+		found[metadata.JobID] = true
+	}
+	for _, id := range jobIDs {
+		if !found[id] {
+			return nil, fmt.Errorf("%w: job %s has no coverage of build %s", ErrCoverageNotFound, id, buildID)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w: build %s", ErrCoverageNotFound, buildID)
+	}
+	return sources, nil
+}
+
+func readCoverageReport(dir string) (*coverage.Report, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CoverageReportFile))
+	if err != nil {
+		return nil, fmt.Errorf("read coverage report: %w", err)
+	}
+	var report coverage.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("read coverage report: %w", err)
+	}
+	return &report, nil
+}
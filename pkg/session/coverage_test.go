@@ -0,0 +1,199 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/coverage"
+	"github.com/forfire912/virServer/pkg/job"
+	"github.com/forfire912/virServer/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// coverageAdapter runs the _start and average functions of the symbols
+// fixture
+type coverageAdapter struct {
+	adapters.BackendAdapter
+
+	dir      string
+	blocks   []coverage.Block
+	on       bool
+	coverage bool
+}
+
+func (a *coverageAdapter) GetCapabilities() *adapters.BackendCapabilities {
+	return &adapters.BackendCapabilities{Features: map[string]bool{"coverage": true}}
+}
+
+func (a *coverageAdapter) PowerOn(ctx context.Context, instanceID string) error {
+	a.on = true
+	return nil
+}
+
+func (a *coverageAdapter) PowerOff(ctx context.Context, instanceID string) error {
+	a.on = false
+	return nil
+}
+
+func (a *coverageAdapter) StartProgram(ctx context.Context, instanceID, programID string, options *adapters.StartOptions) error {
+	a.coverage = options.Coverage
+	return nil
+}
+
+func (a *coverageAdapter) Continue(ctx context.Context, instanceID string) (*adapters.StopEvent, error) {
+	return &adapters.StopEvent{Reason: adapters.StopExited}, nil
+}
+
+func (a *coverageAdapter) ExportCoverage(ctx context.Context, instanceID string) (string, error) {
+	if a.on || !a.coverage {
+		return "", os.ErrNotExist
+	}
+	path := filepath.Join(a.dir, "coverage.drcov")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return path, coverage.WriteDrcov(f, "firmware.elf", a.blocks)
+}
+
+// newCoverageTest creates a session running the symbols fixture
+func newCoverageTest(t *testing.T) (*Service, context.Context) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Session{}, &models.Program{}, &models.Artifact{}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db, nil, t.TempDir())
+	adapter := &coverageAdapter{
+		dir:    t.TempDir(),
+		blocks: []coverage.Block{{Start: 0x401070, Size: 0x2d}, {Start: 0x401019, Size: 0x10}, {Start: 0x401029, Size: 0xc}},
+	}
+	session := &models.Session{ID: "session-1", Backend: "qemu", BoardConfig: `{"name":"board"}`}
+	if err := db.Create(session).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.sessions[session.ID] = &SessionRuntime{
+		Session:     session,
+		Adapter:     adapter,
+		Breakpoints: newBreakpointRegistry(),
+		Events:      newEventLog(),
+		stops:       newStopFilter(),
+	}
+	return s, context.Background()
+}
+
+func uploadFixture(t *testing.T, s *Service, ctx context.Context) {
+	t.Helper()
+	elf, err := os.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+	if _, err := s.UploadProgram(ctx, "session-1", ProgramUpload{Name: "firmware.elf"}, elf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	s, ctx := newCoverageTest(t)
+	adapter := s.sessions["session-1"].Adapter.(*coverageAdapter)
+	runner := NewCoverageRunner(s)
+
+	j := &models.Job{ID: "job-1", SessionID: "session-1"}
+	if err := runner.Check(ctx, j); err == nil {
+		t.Error("job accepted without an ELF program")
+	}
+	uploadFixture(t, s, ctx)
+	if err := runner.Check(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := runner.Run(ctx, j, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := value.(*CoverageResult)
+	if result.Summary.Lines != (coverage.Counter{Covered: 7, Total: 14, Percent: 50}) || len(result.Files) != 1 || adapter.on {
+		t.Fatalf("unexpected result %+v, powered %v", result, adapter.on)
+	}
+	if result.Stop == nil || result.Stop.Reason != adapters.StopExited {
+		t.Errorf("stop %+v", result.Stop)
+	}
+	if _, ok := value.(job.ArtifactResult); !ok || result.ArtifactURL() != "/api/v1/artifacts/"+result.ArtifactID {
+		t.Errorf("artifact URL %q", result.ArtifactURL())
+	}
+
+	path, err := s.ArtifactFile(ctx, result.ArtifactID, CoverageLCOVFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), "LF:14\nLH:7\n") {
+		t.Errorf("LCOV report %q, %v", data, err)
+	}
+	for _, name := range []string{CoverageDrcovFile, CoverageReportFile, CoverageCoberturaFile, CoverageHTMLFile} {
+		if _, err := s.ArtifactFile(ctx, result.ArtifactID, name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := s.ArtifactFile(ctx, result.ArtifactID, "../coverage.drcov"); err == nil {
+		t.Error("file outside the artifact served")
+	}
+}
+
+func TestMergeCoverage(t *testing.T) {
+	s, ctx := newCoverageTest(t)
+	uploadFixture(t, s, ctx)
+	runner := NewCoverageRunner(s)
+	adapter := s.sessions["session-1"].Adapter.(*coverageAdapter)
+
+	var buildID string
+	for i, blocks := range [][]coverage.Block{
+		{{Start: 0x401070, Size: 0x2d}, {Start: 0x401019, Size: 0x10}},
+		{{Start: 0x401000, Size: 0x19}},
+	} {
+		adapter.blocks = blocks
+		result, err := runner.Run(ctx, &models.Job{ID: fmt.Sprintf("job-%d", i+1), SessionID: "session-1"}, func(int) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buildID = result.(*CoverageResult).BuildID
+	}
+
+	merged, err := s.MergeCoverage(ctx, &MergeCoverageRequest{BuildID: buildID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(merged.JobIDs) != "[job-1 job-2]" || merged.Summary.Functions.Percent != 100 {
+		t.Errorf("unexpected merge %+v", merged)
+	}
+	if _, err := s.ArtifactFile(ctx, merged.ArtifactID, CoverageLCOVFile); err != nil {
+		t.Error(err)
+	}
+	// Merged reports are not merged again
+	again, err := s.MergeCoverage(ctx, &MergeCoverageRequest{BuildID: buildID, JobIDs: []string{"job-2"}})
+	if err != nil || fmt.Sprint(again.JobIDs) != "[job-2]" || again.Summary.Functions.Covered != 1 {
+		t.Errorf("merge of job-2 %+v, %v", again, err)
+	}
+	if _, err := s.MergeCoverage(ctx, &MergeCoverageRequest{BuildID: buildID, JobIDs: []string{"job-3"}}); !errors.Is(err, ErrCoverageNotFound) {
+		t.Errorf("merge of an unknown job: %v", err)
+	}
+
+	delta, err := s.CoverageDelta(ctx, buildID, buildID)
+	if err != nil || delta.Summary.Lines.Delta != 0 || len(delta.Files) != 0 || len(delta.Functions) != 0 {
+		t.Errorf("delta of a build with itself %+v, %v", delta, err)
+	}
+	if _, err := s.CoverageDelta(ctx, buildID, "unknown"); !errors.Is(err, ErrCoverageNotFound) {
+		t.Errorf("delta with an unknown build: %v", err)
+	}
+}
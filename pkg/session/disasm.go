@@ -0,0 +1,129 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/disasm"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// DisassembleRequest selects the code to disassemble
+type DisassembleRequest struct {
+	Address   *uint64 // Start address, by default the pc
+	Count     int     // Number of instructions
+	ISA       string  // Instruction set, by default the one of the node's processor
+	ProgramID string  // Program annotating the code, by default the latest ELF program
+}
+
+// Disassembly is decoded code of a session
+type Disassembly struct {
+	ISA          string               `json:"isa"`
+	Address      uint64               `json:"address"`
+	ProgramID    string               `json:"program_id,omitempty"` // Program whose symbols annotate the code
+	Instructions []disasm.Instruction `json:"instructions"`
+}
+
+// Disassemble reads code from the target and decodes it. Thumb or ARM is
+// chosen from the processor of the session's node. Instructions are
+// annotated with functions and source lines when the session has an ELF
+// program.
+func (s *Service) Disassemble(ctx context.Context, sessionID string, req DisassembleRequest) (*Disassembly, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	isa := req.ISA
+	if isa == "" {
+		if isa, err = s.instructionSet(ctx, sessionID); err != nil {
+			return nil, err
+		}
+	}
+	if !disasm.Valid(isa) {
+		return nil, fmt.Errorf("unknown instruction set %q", isa)
+	}
+
+	// Symbols are optional unless a program was asked for
+	table, program, err := s.Symbols(ctx, sessionID, req.ProgramID)
+	if err != nil && (req.ProgramID != "" || !errors.Is(err, ErrProgramNotFound)) {
+		return nil, err
+	}
+
+	var address uint64
+	if req.Address != nil {
+		address = *req.Address
+	} else {
+		regs, err := runtime.Adapter.ReadRegisters(ctx, runtime.InstanceID, "general")
+		if err != nil {
+			return nil, err
+		}
+		pc, ok := registerValues(regs)["pc"]
+		if !ok {
+			return nil, fmt.Errorf("backend did not report the pc")
+		}
+		address = pc
+	}
+	// Clears the Thumb bit of function addresses too
+	address &^= disasm.Alignment(isa) - 1
+
+	code, err := runtime.Adapter.ReadMemory(ctx, runtime.InstanceID, address, uint32(req.Count*disasm.MaxSize))
+	if err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if table != nil && table.ByteOrder == "big" {
+		order = binary.BigEndian
+	}
+	instructions, err := disasm.Decode(isa, code, address, order, req.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Disassembly{ISA: isa, Address: address, Instructions: instructions}
+	if table != nil {
+		result.ProgramID = program.ID
+		annotate(table, instructions)
+	}
+	return result, nil
+}
+
+// instructionSet returns the instruction set of the processor of the
+// session's node
+func (s *Service) instructionSet(ctx context.Context, sessionID string) (string, error) {
+	config, err := s.GetBoardConfig(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	var processor *adapters.ProcessorConfig
+	if len(config.Nodes) > 0 {
+		processor = config.Nodes[0].Processor
+	}
+	return adapters.InstructionSet(processor), nil
+}
+
+// annotate adds the function, source line and branch target symbol of each
+// instruction
+func annotate(table *symbols.Table, instructions []disasm.Instruction) {
+	for i := range instructions {
+		inst := &instructions[i]
+		location := table.Lookup(inst.Address)
+		inst.Symbol = symbolOffset(location)
+		inst.File = location.File
+		inst.Line = location.Line
+		if inst.Target != 0 {
+			inst.TargetSymbol = symbolOffset(table.Lookup(inst.Target))
+		}
+	}
+}
+
+// symbolOffset formats a location as function+offset
+func symbolOffset(location symbols.Location) string {
+	if location.Function == "" || location.Offset == 0 {
+		return location.Function
+	}
+	return fmt.Sprintf("%s+%#x", location.Function, location.Offset)
+}
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+)
+
+// Event history and subscriber limits
+const (
+	eventHistory = 256 // Events kept for clients resuming after a reconnect
+	eventBuffer  = 64  // Undelivered events before a subscriber is dropped
+)
+
+// Debug event types
+const (
+	EventStop            = "stop"
+	EventMemoryViolation = "memory_violation"
+)
+
+// DebugEvent is a debug event of a session. Seq increases by one per event
+// of the session, so clients can resume after a reconnect and detect gaps.
+type DebugEvent struct {
+	Seq    uint64           `json:"seq"`
+	Type   string           `json:"type"`
+	Time   time.Time        `json:"time"`
+	Memory *MemoryViolation `json:"memory,omitempty"` // Of memory_violation events
+	*adapters.StopEvent
+}
+
+// EventLog keeps the recent debug events of a session and fans them out to
+// subscribers
+type EventLog struct {
+	mu          sync.Mutex
+	seq         uint64
+	history     []DebugEvent
+	subscribers map[chan DebugEvent]struct{}
+	closed      bool
+}
+
+func newEventLog() *EventLog {
+	return &EventLog{subscribers: make(map[chan DebugEvent]struct{})}
+}
+
+// Publish appends an event and delivers it to the subscribers. Subscribers
+// that fall behind are dropped; they resume from the history.
+func (l *EventLog) Publish(eventType string, stop *adapters.StopEvent) DebugEvent {
+	return l.publish(DebugEvent{Type: eventType, StopEvent: stop})
+}
+
+// PublishMemoryViolation appends a memory_violation event
+func (l *EventLog) PublishMemoryViolation(violation *MemoryViolation) DebugEvent {
+	return l.publish(DebugEvent{Type: EventMemoryViolation, Memory: violation})
+}
+
+func (l *EventLog) publish(event DebugEvent) DebugEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	event.Seq = l.seq
+	event.Time = time.Now()
+	if l.closed {
+		return event
+	}
+	l.history = append(l.history, event)
+	if len(l.history) > eventHistory {
+		l.history = l.history[len(l.history)-eventHistory:]
+	}
+	for ch := range l.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Subscribe returns the events after since and a channel for later events.
+// The channel is closed when the subscriber falls behind or the session is
+// deleted. cancel must be called when the subscriber is done.
+func (l *EventLog) Subscribe(since uint64) (backlog []DebugEvent, events <-chan DebugEvent, cancel func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, event := range l.history {
+		if event.Seq > since {
+			backlog = append(backlog, event)
+		}
+	}
+	ch := make(chan DebugEvent, eventBuffer)
+	if l.closed {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	l.subscribers[ch] = struct{}{}
+	return backlog, ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.subscribers[ch]; ok {
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Seq returns the sequence number of the latest event
+func (l *EventLog) Seq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+func (l *EventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for ch := range l.subscribers {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// SubscribeEvents subscribes to the debug events of a session
+func (s *Service) SubscribeEvents(sessionID string, since uint64) ([]DebugEvent, <-chan DebugEvent, func(), error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	backlog, events, cancel := runtime.Events.Subscribe(since)
+	return backlog, events, cancel, nil
+}
+
+// EventSeq returns the sequence number of the latest debug event of a session
+func (s *Service) EventSeq(sessionID string) (uint64, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	return runtime.Events.Seq(), nil
+}
+
+// watchStops publishes the stops of the session's instance after applying
+// the conditions and ignore counts of breakpoints
+func (s *Service) watchStops(ctx context.Context, runtime *SessionRuntime) {
+	sessionID := runtime.Session.ID
+	err := runtime.Adapter.WatchStops(ctx, runtime.InstanceID, func(stop *adapters.StopEvent) {
+		if !runtime.stops.push(stop) {
+			runtime.Events.Publish(EventStop, stop)
+		}
+	})
+	if err != nil {
+		log.Printf("Warning: no debug events for session %s: %v", sessionID, err)
+		return
+	}
+	runtime.stops.activate()
+	go s.filterStops(runtime)
+}
@@ -0,0 +1,65 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+)
+
+func TestEventLog(t *testing.T) {
+	log := newEventLog()
+	log.Publish(EventStop, &adapters.StopEvent{Reason: adapters.StopStep, PC: 0x100})
+
+	backlog, events, cancel := log.Subscribe(0)
+	defer cancel()
+	if len(backlog) != 1 || backlog[0].Seq != 1 || backlog[0].PC != 0x100 {
+		t.Fatalf("unexpected backlog %+v", backlog)
+	}
+
+	log.Publish(EventStop, &adapters.StopEvent{Reason: adapters.StopBreakpoint, BreakpointID: "bp-1"})
+	event := <-events
+	if event.Seq != 2 || event.Type != EventStop || event.BreakpointID != "bp-1" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// A reconnecting client gets what it missed
+	backlog, _, cancelResumed := log.Subscribe(1)
+	defer cancelResumed()
+	if len(backlog) != 1 || backlog[0].Seq != 2 {
+		t.Errorf("unexpected backlog after reconnect %+v", backlog)
+	}
+	if log.Seq() != 2 {
+		t.Errorf("Seq = %d", log.Seq())
+	}
+}
+
+func TestEventLog_Limits(t *testing.T) {
+	log := newEventLog()
+	_, events, cancel := log.Subscribe(0)
+	defer cancel()
+
+	for i := 0; i < eventHistory+10; i++ {
+		log.Publish(EventStop, &adapters.StopEvent{Reason: adapters.StopStep})
+	}
+
+	// The subscriber fell behind and was dropped
+	received := 0
+	for range events {
+		received++
+	}
+	if received != eventBuffer {
+		t.Errorf("received %d events before being dropped, want %d", received, eventBuffer)
+	}
+
+	backlog, _, cancelAll := log.Subscribe(0)
+	defer cancelAll()
+	if len(backlog) != eventHistory || backlog[0].Seq != 11 {
+		t.Errorf("history holds %d events starting at %d", len(backlog), backlog[0].Seq)
+	}
+
+	log.close()
+	_, closed, _ := log.Subscribe(0)
+	if _, ok := <-closed; ok {
+		t.Error("subscription of a closed log should be closed")
+	}
+}
@@ -0,0 +1,272 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrGDBTokenInvalid is returned for unknown or expired GDB tokens
+	ErrGDBTokenInvalid = errors.New("invalid or expired GDB token")
+	// ErrGDBControlled is returned when a second controlling client connects
+	ErrGDBControlled = errors.New("session already has a controlling debug client")
+)
+
+// GDB client modes
+const (
+	GDBControl = "control" // May resume, step and modify the target
+	GDBObserve = "observe" // May only read registers and memory
+)
+
+// Lifetimes of GDB tokens
+const (
+	DefaultGDBTokenTTL = time.Hour
+	MaxGDBTokenTTL     = 24 * time.Hour
+)
+
+// gdbHandshakeTimeout bounds reading the token line of TCP clients
+const gdbHandshakeTimeout = 10 * time.Second
+
+// GDBToken grants a GDB client access to a session. It may be used for any
+// number of connections until it expires.
+type GDBToken struct {
+	Token     string    `json:"token"`
+	SessionID string    `json:"session_id"`
+	Mode      string    `json:"mode"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GDBClients tracks the tokens and connected GDB clients of a session
+type GDBClients struct {
+	mu         sync.Mutex
+	tokens     map[string]*GDBToken
+	controller bool
+	observers  int
+}
+
+func newGDBClients() *GDBClients {
+	return &GDBClients{tokens: make(map[string]*GDBToken)}
+}
+
+// Counts returns whether a controlling client is connected and the number
+// of observers
+func (g *GDBClients) Counts() (bool, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.controller, g.observers
+}
+
+// lookup returns the unexpired token with the given value
+func (g *GDBClients) lookup(value string) *GDBToken {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for key, token := range g.tokens {
+		if now.After(token.ExpiresAt) {
+			delete(g.tokens, key)
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(value)) == 1 {
+			return token
+		}
+	}
+	return nil
+}
+
+// join registers a connecting client
+func (g *GDBClients) join(mode string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if mode == GDBControl {
+		if g.controller {
+			return ErrGDBControlled
+		}
+		g.controller = true
+		return nil
+	}
+	g.observers++
+	return nil
+}
+
+func (g *GDBClients) leave(mode string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if mode == GDBControl {
+		g.controller = false
+	} else {
+		g.observers--
+	}
+}
+
+// CreateGDBToken issues a token for connecting GDB to a session through the
+// proxy. ttl 0 selects DefaultGDBTokenTTL.
+func (s *Service) CreateGDBToken(sessionID, mode string, ttl time.Duration) (*GDBToken, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case "":
+		mode = GDBControl
+	case GDBControl, GDBObserve:
+	default:
+		return nil, fmt.Errorf("invalid GDB mode: %s", mode)
+	}
+	if ttl == 0 {
+		ttl = DefaultGDBTokenTTL
+	}
+	if ttl < 0 || ttl > MaxGDBTokenTTL {
+		return nil, fmt.Errorf("token lifetime must be at most %s", MaxGDBTokenTTL)
+	}
+
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, err
+	}
+	token := &GDBToken{
+		Token:     hex.EncodeToString(raw[:]),
+		SessionID: sessionID,
+		Mode:      mode,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+	runtime.GDB.mu.Lock()
+	runtime.GDB.tokens[token.Token] = token
+	runtime.GDB.mu.Unlock()
+	return token, nil
+}
+
+// ResolveGDBToken returns the session and mode a token grants
+func (s *Service) ResolveGDBToken(value string) (*GDBToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, runtime := range s.sessions {
+		if token := runtime.GDB.lookup(value); token != nil {
+			return token, nil
+		}
+	}
+	return nil, ErrGDBTokenInvalid
+}
+
+// ServeGDB proxies a GDB client authorised by a token onto the debug
+// connection of its session. A session has at most one controlling client;
+// observers are not limited.
+func (s *Service) ServeGDB(ctx context.Context, token *GDBToken, conn io.ReadWriteCloser) error {
+	runtime, err := s.runtime(token.SessionID)
+	if err != nil {
+		return err
+	}
+	if err := runtime.GDB.join(token.Mode); err != nil {
+		return err
+	}
+	defer runtime.GDB.leave(token.Mode)
+	return runtime.Adapter.ServeGDB(ctx, runtime.InstanceID, conn, token.Mode != GDBControl)
+}
+
+// JoinController registers a controlling debug client that does not go
+// through the GDB proxy, such as a DAP client. It fails with
+// ErrGDBControlled while another client controls the session; the returned
+// function gives up control.
+func (s *Service) JoinController(sessionID string) (func(), error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := runtime.GDB.join(GDBControl); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { runtime.GDB.leave(GDBControl) }) }, nil
+}
+
+// GDBProxyAddress returns the address of the TCP listener for GDB clients,
+// or "" when it is not running
+func (s *Service) GDBProxyAddress() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gdbAddress
+}
+
+// ListenAndServeGDB accepts GDB clients over TCP until ctx is done. A client
+// sends its token on a line of its own before the first packet.
+func (s *Service) ListenAndServeGDB(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.gdbAddress = listener.Addr().String()
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			// A client must not take the server down with it
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("GDB client %s: panic: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+				}
+			}()
+			if err := s.serveGDBConn(ctx, conn); err != nil {
+				log.Printf("GDB client %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Service) serveGDBConn(ctx context.Context, conn net.Conn) error {
+	r := bufio.NewReader(io.LimitReader(conn, 1<<10))
+	conn.SetReadDeadline(time.Now().Add(gdbHandshakeTimeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("read token: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	token, err := s.ResolveGDBToken(strings.TrimSpace(line))
+	if err != nil {
+		return err
+	}
+	// Packets the client sent along with the token are still buffered
+	stream := &gdbStream{Reader: io.MultiReader(bufferedReader(r), conn), Writer: conn, Closer: conn}
+	return s.ServeGDB(ctx, token, stream)
+}
+
+// bufferedReader returns the data buffered by r
+func bufferedReader(r *bufio.Reader) io.Reader {
+	data, _ := r.Peek(r.Buffered())
+	return strings.NewReader(string(data))
+}
+
+// gdbStream joins the parts of a client connection
+type gdbStream struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGDBTokens(t *testing.T) {
+	s := &Service{sessions: map[string]*SessionRuntime{
+		"session-1": {GDB: newGDBClients()},
+	}}
+
+	token, err := s.CreateGDBToken("session-1", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Mode != GDBControl || len(token.Token) != 32 || time.Until(token.ExpiresAt) <= 59*time.Minute {
+		t.Errorf("unexpected token %+v", token)
+	}
+	if resolved, err := s.ResolveGDBToken(token.Token); err != nil || resolved.SessionID != "session-1" {
+		t.Errorf("ResolveGDBToken = %+v, %v", resolved, err)
+	}
+	if _, err := s.ResolveGDBToken("nope"); !errors.Is(err, ErrGDBTokenInvalid) {
+		t.Errorf("expected ErrGDBTokenInvalid, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		mode string
+		ttl  time.Duration
+	}{
+		{"admin", 0},
+		{GDBObserve, -time.Second},
+		{GDBObserve, MaxGDBTokenTTL + time.Second},
+	} {
+		if _, err := s.CreateGDBToken("session-1", tt.mode, tt.ttl); err == nil {
+			t.Errorf("CreateGDBToken(%q, %s) succeeded", tt.mode, tt.ttl)
+		}
+	}
+	if _, err := s.CreateGDBToken("session-2", GDBObserve, 0); err == nil {
+		t.Error("token issued for an unknown session")
+	}
+
+	// Expired tokens are dropped
+	clients := s.sessions["session-1"].GDB
+	clients.tokens[token.Token].ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := s.ResolveGDBToken(token.Token); err == nil {
+		t.Error("expired token accepted")
+	}
+}
+
+func TestGDBClients(t *testing.T) {
+	clients := newGDBClients()
+	if err := clients.join(GDBControl); err != nil {
+		t.Fatal(err)
+	}
+	if err := clients.join(GDBControl); !errors.Is(err, ErrGDBControlled) {
+		t.Errorf("second controller: %v", err)
+	}
+	clients.join(GDBObserve)
+	clients.join(GDBObserve)
+	if controller, observers := clients.Counts(); !controller || observers != 2 {
+		t.Errorf("Counts = %v, %d", controller, observers)
+	}
+
+	clients.leave(GDBControl)
+	if err := clients.join(GDBControl); err != nil {
+		t.Errorf("controller after the previous one left: %v", err)
+	}
+}
+
+func TestJoinController(t *testing.T) {
+	s := &Service{sessions: map[string]*SessionRuntime{
+		"session-1": {GDB: newGDBClients()},
+	}}
+
+	release, err := s.JoinController("session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.JoinController("session-1"); !errors.Is(err, ErrGDBControlled) {
+		t.Errorf("second controller: %v", err)
+	}
+	if err := s.sessions["session-1"].GDB.join(GDBControl); !errors.Is(err, ErrGDBControlled) {
+		t.Errorf("GDB controller beside a DAP client: %v", err)
+	}
+	release()
+	release()
+	if controller, _ := s.sessions["session-1"].GDB.Counts(); controller {
+		t.Error("control kept after release")
+	}
+	if _, err := s.JoinController("session-2"); err == nil {
+		t.Error("joined an unknown session")
+	}
+}
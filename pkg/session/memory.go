@@ -0,0 +1,100 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// maxMemoryViolations limits the violations reported per run, below
+// eventBuffer so that they cannot drop subscribers on their own
+const maxMemoryViolations = 32
+
+// MemoryViolation is an access of a program outside the memory map of its
+// board, or against the access attribute of a region
+type MemoryViolation struct {
+	Kind    string `json:"kind"` // unmapped, read_only or write_only
+	PC      uint64 `json:"pc"`
+	Address uint64 `json:"address"`
+	Size    int    `json:"size"` // In bytes, 0 when unknown
+	Write   bool   `json:"write"`
+	Symbol  string `json:"symbol,omitempty"` // Function and line of the PC
+	Region  string `json:"region,omitempty"` // Region whose access attribute is violated
+}
+
+// memoryMonitor checks the memory accesses of a run against the memory map
+// and publishes the violations, once per instruction and kind of violation
+type memoryMonitor struct {
+	memory *adapters.MemoryMap
+	table  *symbols.Table // nil for programs without symbols
+	events *EventLog
+
+	mu       sync.Mutex
+	reported map[memoryViolationKey]bool
+}
+
+type memoryViolationKey struct {
+	pc   uint64
+	kind string
+}
+
+// newMemoryMonitor creates the monitor of a run of program. Violations are
+// symbolized when the program is an ELF file.
+func (s *Service) newMemoryMonitor(ctx context.Context, runtime *SessionRuntime, program *models.Program) (*memoryMonitor, error) {
+	if !runtime.Adapter.GetCapabilities().Features["memory_monitor"] {
+		return nil, fmt.Errorf("backend %s does not support memory monitoring", runtime.Session.Backend)
+	}
+	config, err := s.GetBoardConfig(ctx, runtime.Session.ID)
+	if err != nil {
+		return nil, err
+	}
+	m := &memoryMonitor{
+		memory:   adapters.NewMemoryMap(config),
+		events:   runtime.Events,
+		reported: make(map[memoryViolationKey]bool),
+	}
+	if len(m.memory.Regions) == 0 {
+		return nil, fmt.Errorf("the board config declares no memory")
+	}
+	if table, _, err := s.Symbols(ctx, runtime.Session.ID, program.ID); err == nil {
+		m.table = table
+	}
+	return m, nil
+}
+
+// access checks an access. It runs on a backend goroutine.
+func (m *memoryMonitor) access(access adapters.MemoryAccess) {
+	kind, region := m.memory.Check(access)
+	if kind == "" {
+		return
+	}
+	key := memoryViolationKey{pc: access.PC, kind: kind}
+	m.mu.Lock()
+	if m.reported[key] || len(m.reported) >= maxMemoryViolations {
+		m.mu.Unlock()
+		return
+	}
+	m.reported[key] = true
+	m.mu.Unlock()
+
+	violation := &MemoryViolation{
+		Kind:    kind,
+		PC:      access.PC,
+		Address: access.Address,
+		Size:    access.Size,
+		Write:   access.Write,
+	}
+	if region != nil {
+		violation.Region = region.Name
+	}
+	if m.table != nil {
+		if location := m.table.Lookup(access.PC); location.Function != "" {
+			violation.Symbol = location.String()
+		}
+	}
+	m.events.PublishMemoryViolation(violation)
+}
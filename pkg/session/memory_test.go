@@ -0,0 +1,87 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+)
+
+// memoryAdapter reports the accesses of scale in the symbols fixture
+type memoryAdapter struct {
+	*coverageAdapter
+
+	accesses []adapters.MemoryAccess
+}
+
+func (a *memoryAdapter) GetCapabilities() *adapters.BackendCapabilities {
+	return &adapters.BackendCapabilities{Features: map[string]bool{"memory_monitor": true}}
+}
+
+func (a *memoryAdapter) StartProgram(ctx context.Context, instanceID, programID string, options *adapters.StartOptions) error {
+	if options.MonitorMemory {
+		for _, access := range a.accesses {
+			options.OnMemoryAccess(access)
+		}
+	}
+	return nil
+}
+
+func TestMemoryMonitor(t *testing.T) {
+	s, ctx := newCoverageTest(t)
+	runtime := s.sessions["session-1"]
+	adapter := &memoryAdapter{coverageAdapter: runtime.Adapter.(*coverageAdapter)}
+	runtime.Adapter = adapter
+	uploadFixture(t, s, ctx)
+	programs, err := s.ListPrograms(ctx, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	programID := programs[0].ID
+
+	options := &adapters.StartOptions{MonitorMemory: true}
+	if err := s.StartProgram(ctx, "session-1", programID, options); err == nil {
+		t.Error("monitoring accepted without memory in the board config")
+	}
+	config := `{"name":"board","nodes":[{"id":"cpu0","memory":[{"type":"Flash","address":4194304,"size":1048576,"access":"RX"},{"type":"RAM","address":536870912,"size":4096,"access":"RW"}]}]}`
+	if err := s.db.Model(&models.Session{}).Where("id = ?", "session-1").Update("board_config", config).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	adapter.accesses = []adapters.MemoryAccess{
+		{PC: 0x401004, Address: 0x20000010, Size: 4, Write: true},
+		{PC: 0x401008, Address: 0x20001000, Size: 4, Write: true},
+		{PC: 0x401008, Address: 0x20001004, Size: 4, Write: true},
+		{PC: 0x40100c, Address: 0x401000, Size: 2, Write: true},
+		{PC: 0x10, Address: 0x30000000, Size: 1},
+	}
+	_, events, cancel := runtime.Events.Subscribe(runtime.Events.Seq())
+	defer cancel()
+	if err := s.StartProgram(ctx, "session-1", programID, options); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for len(got) < 3 {
+		event := <-events
+		if event.Type != EventMemoryViolation || event.Memory == nil {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		v := event.Memory
+		got = append(got, v.Kind+"@"+strings.SplitN(v.Symbol, " ", 2)[0]+":"+v.Region)
+		if v.PC == 0x401008 && (v.Address != 0x20001000 || v.Size != 4 || !v.Write) {
+			t.Errorf("unmapped store %+v", v)
+		}
+	}
+	want := "unmapped@scale+0x8: read_only@scale+0xc:Flash@0x400000 unmapped@:"
+	if strings.Join(got, " ") != want {
+		t.Errorf("violations %s, want %s", strings.Join(got, " "), want)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	default:
+	}
+}
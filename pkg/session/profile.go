@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/trace"
+)
+
+// ArtifactProfile is the type of profile artifacts
+const ArtifactProfile = "profile"
+
+// Files of profile artifacts
+const (
+	ProfilePprofFile = "profile.pb.gz" // pprof profile
+	ProfileFlatFile  = "profile.json"  // Flat profile and call stacks
+)
+
+// defaultProfileTop is the number of functions in the result of profile jobs
+const defaultProfileTop = 20
+
+// ProfileOptions are the options of a profile job
+type ProfileOptions struct {
+	ProgramID  string `json:"program_id"`            // Most recently uploaded ELF program when empty
+	Until      string `json:"until,omitempty"`       // Location at which the run ends; at its first stop otherwise
+	TimeoutSec int    `json:"timeout_sec,omitempty"` // Time after which the run ends, default 60
+	Top        int    `json:"top,omitempty"`         // Functions in the result, default 20
+}
+
+// ProfileResult is the result of a profile job. The full profile is in the
+// profile artifact.
+type ProfileResult struct {
+	ProgramID    string                  `json:"program_id"`
+	BuildID      string                  `json:"build_id"`
+	ArtifactID   string                  `json:"artifact_id"`
+	Stop         *adapters.StopEvent     `json:"stop,omitempty"` // Absent when the run ended at the timeout
+	Instructions uint64                  `json:"instructions"`   // Executed in the run
+	Functions    []trace.FunctionProfile `json:"functions"`      // Most self instructions first
+	Truncated    bool                    `json:"truncated,omitempty"`
+}
+
+// ArtifactURL returns the API path of the profile artifact
+func (r *ProfileResult) ArtifactURL() string {
+	return "/api/v1/artifacts/" + r.ArtifactID
+}
+
+// ProfileRunner runs profile jobs, which run an ELF program of a session
+// once from power on and count the instructions it executed in each
+// function from its execution trace
+type ProfileRunner struct {
+	sessions *Service
+}
+
+// NewProfileRunner creates the runner of profile jobs
+func NewProfileRunner(sessions *Service) *ProfileRunner {
+	return &ProfileRunner{sessions: sessions}
+}
+
+// Check validates the options of a profile job and that the backend traces
+// programs
+func (r *ProfileRunner) Check(ctx context.Context, job *models.Job) error {
+	options, err := profileOptions(job)
+	if err != nil {
+		return err
+	}
+	return r.sessions.checkTracing(ctx, job.SessionID, options.ProgramID)
+}
+
+// Run executes a profile job. The session is powered off afterwards.
+func (r *ProfileRunner) Run(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error) {
+	options, err := profileOptions(job)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.sessions.Profile(ctx, job.SessionID, job.ID, options, progress)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func profileOptions(job *models.Job) (*ProfileOptions, error) {
+	options := &ProfileOptions{}
+	if job.Options != "" {
+		if err := json.Unmarshal([]byte(job.Options), options); err != nil {
+			return nil, fmt.Errorf("invalid profile options: %w", err)
+		}
+	}
+	if err := checkTraceTimeout(options.TimeoutSec); err != nil {
+		return nil, err
+	}
+	if options.Top < 0 {
+		return nil, fmt.Errorf("top must not be negative")
+	}
+	if options.Top == 0 {
+		options.Top = defaultProfileTop
+	}
+	return options, nil
+}
+
+// Profile runs an ELF program with tracing until it stops or the timeout
+// expires, and saves the instructions it executed by function and call
+// stack as an artifact of the session
+func (s *Service) Profile(ctx context.Context, sessionID, jobID string, options *ProfileOptions, progress func(int)) (*ProfileResult, error) {
+	table, program, err := s.Symbols(ctx, sessionID, options.ProgramID)
+	if err != nil {
+		return nil, err
+	}
+	stop, records, err := s.runTraced(ctx, sessionID, program.ID, options.Until, options.TimeoutSec)
+	if err != nil {
+		return nil, err
+	}
+	progress(50)
+	builder := trace.NewProfileBuilder(table)
+	if err := scanRecords(records, builder.Add); err != nil {
+		return nil, err
+	}
+	profile := builder.Profile()
+	progress(75)
+
+	artifact := &models.Artifact{SessionID: sessionID, ProgramID: program.ID, Type: ArtifactProfile, BuildID: table.BuildID}
+	metadata := &TraceMetadata{JobID: jobID, BuildID: table.BuildID, Files: []string{ProfilePprofFile, ProfileFlatFile}}
+	err = s.saveArtifact(ctx, artifact, s.profileDir, metadata, map[string]func(io.Writer) error{
+		ProfilePprofFile: func(w io.Writer) error { return trace.WritePprof(w, program.Name, table.BuildID, profile) },
+		ProfileFlatFile:  func(w io.Writer) error { return writeJSON(w, profile) },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProfileResult{
+		ProgramID:    program.ID,
+		BuildID:      table.BuildID,
+		ArtifactID:   artifact.ID,
+		Instructions: profile.Instructions,
+		Functions:    profile.Functions,
+		Truncated:    profile.Truncated,
+	}
+	if len(result.Functions) > options.Top {
+		result.Functions = result.Functions[:options.Top]
+	}
+	if stop.Reason != adapters.StopRunning {
+		result.Stop = stop
+	}
+	progress(100)
+	return result, nil
+}
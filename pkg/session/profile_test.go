@@ -0,0 +1,57 @@
+package session
+
+import (
+	"compress/gzip"
+	"os"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/models"
+)
+
+func TestProfile(t *testing.T) {
+	s, ctx := newCoverageTest(t)
+	runtime := s.sessions["session-1"]
+	adapter := &traceAdapter{coverageAdapter: runtime.Adapter.(*coverageAdapter)}
+	runtime.Adapter = adapter
+	runner := NewProfileRunner(s)
+
+	j := &models.Job{ID: "job-1", SessionID: "session-1", Options: `{"top":2}`}
+	if err := runner.Check(ctx, j); err == nil {
+		t.Error("job accepted without an ELF program")
+	}
+	uploadFixture(t, s, ctx)
+	if err := runner.Check(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Check(ctx, &models.Job{SessionID: "session-1", Options: `{"top":-1}`}); err == nil {
+		t.Error("negative top accepted")
+	}
+
+	value, err := runner.Run(ctx, j, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := value.(*ProfileResult)
+	if result.Instructions != 17 || len(result.Functions) != 2 || adapter.on {
+		t.Fatalf("unexpected result %+v, powered %v", result, adapter.on)
+	}
+	if f := result.Functions[0]; f.Name != "average" || f.Self != 8 || f.Total != 13 {
+		t.Errorf("first function %+v", f)
+	}
+
+	path, err := s.ArtifactFile(ctx, result.ArtifactID, ProfilePprofFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := gzip.NewReader(f); err != nil {
+		t.Errorf("pprof profile: %v", err)
+	}
+	if _, err := s.ArtifactFile(ctx, result.ArtifactID, ProfileFlatFile); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,227 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/symbols"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrProgramNotFound is returned for unknown program IDs
+var ErrProgramNotFound = errors.New("program not found")
+
+// maxProgramSize bounds uploaded program files
+const maxProgramSize = 64 << 20
+
+// Program types
+const (
+	ProgramELF = "ELF"
+	ProgramBIN = "BIN"
+	ProgramHEX = "HEX"
+)
+
+// ProgramUpload describes an uploaded program file
+type ProgramUpload struct {
+	Name     string
+	Type     string // ELF, BIN or HEX; detected when empty
+	LoadAddr uint64
+}
+
+// symbolCache keeps the symbol tables of ELF programs by program ID
+type symbolCache struct {
+	mu     sync.Mutex
+	tables map[string]*symbols.Table
+}
+
+// UploadProgram stores a program file of a session. The entry point and
+// build ID of ELF programs are read from the file.
+func (s *Service) UploadProgram(ctx context.Context, sessionID string, upload ProgramUpload, file io.Reader) (*models.Program, error) {
+	if _, err := s.runtime(sessionID); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxProgramSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read program: %w", err)
+	}
+	if len(data) > maxProgramSize {
+		return nil, fmt.Errorf("program larger than %d bytes", maxProgramSize)
+	}
+
+	programType := strings.ToUpper(upload.Type)
+	isELF := bytes.HasPrefix(data, []byte("\x7fELF"))
+	switch {
+	case programType == "" && isELF:
+		programType = ProgramELF
+	case programType == "":
+		programType = ProgramBIN
+	case programType == ProgramELF && !isELF:
+		return nil, fmt.Errorf("program is not an ELF file")
+	case programType != ProgramELF && programType != ProgramBIN && programType != ProgramHEX:
+		return nil, fmt.Errorf("invalid program type: %s", upload.Type)
+	}
+
+	sum := sha256.Sum256(data)
+	program := &models.Program{
+		ID:        uuid.New().String(),
+		SessionID: sessionID,
+		Name:      upload.Name,
+		Type:      programType,
+		Size:      int64(len(data)),
+		LoadAddr:  upload.LoadAddr,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Status:    "uploaded",
+		CreatedAt: time.Now(),
+	}
+
+	var table *symbols.Table
+	if programType == ProgramELF {
+		table, err = symbols.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		program.EntryPoint = table.Entry
+		program.BuildID = table.BuildID
+	}
+
+	dir := filepath.Join(s.programDir, sessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("store program: %w", err)
+	}
+	program.Path = filepath.Join(dir, program.ID)
+	if err := os.WriteFile(program.Path, data, 0644); err != nil {
+		return nil, fmt.Errorf("store program: %w", err)
+	}
+	if err := s.db.WithContext(ctx).Create(program).Error; err != nil {
+		os.Remove(program.Path)
+		return nil, fmt.Errorf("failed to save program: %w", err)
+	}
+
+	if table != nil {
+		s.symbols.put(program.ID, table)
+	}
+	return program, nil
+}
+
+// ListPrograms lists the programs of a session, newest first
+func (s *Service) ListPrograms(ctx context.Context, sessionID string) ([]models.Program, error) {
+	var programs []models.Program
+	if err := s.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("created_at DESC").Find(&programs).Error; err != nil {
+		return nil, err
+	}
+	return programs, nil
+}
+
+// GetProgram returns a program of a session
+func (s *Service) GetProgram(ctx context.Context, sessionID, programID string) (*models.Program, error) {
+	var program models.Program
+	err := s.db.WithContext(ctx).Where("id = ? AND session_id = ?", programID, sessionID).First(&program).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrProgramNotFound, programID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &program, nil
+}
+
+// latestProgram returns the most recently uploaded program of a session
+func (s *Service) latestProgram(ctx context.Context, sessionID string) (*models.Program, error) {
+	var program models.Program
+	err := s.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("created_at DESC").First(&program).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: session %s has no program", ErrProgramNotFound, sessionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &program, nil
+}
+
+// Symbols returns the symbol table of an ELF program of the session, or of
+// its most recently uploaded ELF program when programID is empty
+func (s *Service) Symbols(ctx context.Context, sessionID, programID string) (*symbols.Table, *models.Program, error) {
+	var program *models.Program
+	if programID != "" {
+		found, err := s.GetProgram(ctx, sessionID, programID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if found.Type != ProgramELF {
+			return nil, nil, fmt.Errorf("program %s is not an ELF file", programID)
+		}
+		program = found
+	} else {
+		var latest models.Program
+		err := s.db.WithContext(ctx).Where("session_id = ? AND type = ?", sessionID, ProgramELF).Order("created_at DESC").First(&latest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("%w: session %s has no ELF program", ErrProgramNotFound, sessionID)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		program = &latest
+	}
+
+	if table := s.symbols.get(program.ID); table != nil {
+		return table, program, nil
+	}
+	table, err := symbols.Open(program.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load symbols of %s: %w", program.ID, err)
+	}
+	s.symbols.put(program.ID, table)
+	return table, program, nil
+}
+
+// deletePrograms removes the program files and records of a session
+func (s *Service) deletePrograms(sessionID string) {
+	programs, err := s.ListPrograms(context.Background(), sessionID)
+	if err != nil {
+		return
+	}
+	for _, program := range programs {
+		s.symbols.remove(program.ID)
+	}
+	if err := s.db.Where("session_id = ?", sessionID).Delete(&models.Program{}).Error; err != nil {
+		log.Printf("Warning: failed to delete programs of session %s: %v", sessionID, err)
+	}
+	if s.programDir != "" {
+		os.RemoveAll(filepath.Join(s.programDir, sessionID))
+	}
+}
+
+func (c *symbolCache) get(programID string) *symbols.Table {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tables[programID]
+}
+
+func (c *symbolCache) put(programID string, table *symbols.Table) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tables == nil {
+		c.tables = make(map[string]*symbols.Table)
+	}
+	c.tables[programID] = table
+}
+
+func (c *symbolCache) remove(programID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tables, programID)
+}
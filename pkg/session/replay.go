@@ -0,0 +1,351 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrArtifactNotFound is returned for unknown artifact IDs
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// ModeReplay marks sessions that replay a recording
+const ModeReplay = "replay"
+
+// Artifact types and states
+const (
+	ArtifactReplay    = "replay"
+	ArtifactRecording = "recording"
+	ArtifactReady     = "ready"
+)
+
+// replayProgramFile is the copy of the recorded program in a recording
+const replayProgramFile = "program"
+
+// ReplayMetadata is kept with a recording to recreate its session
+type ReplayMetadata struct {
+	Backend     string               `json:"backend"`
+	BoardConfig adapters.BoardConfig `json:"board_config"`
+	Program     ReplayProgram        `json:"program"`
+	Args        []string             `json:"args,omitempty"`
+}
+
+// ReplayProgram describes the recorded program
+type ReplayProgram struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	LoadAddr uint64 `json:"load_addr,omitempty"`
+	SHA256   string `json:"sha256"`
+}
+
+// StartProgram starts a program of a session. Record writes the run to a
+// replay artifact; replay sessions replay their recording instead of
+// running live and stay halted at its beginning. With MonitorMemory the
+// accesses outside the memory map of the board are published as
+// memory_violation events.
+func (s *Service) StartProgram(ctx context.Context, sessionID, programID string, options *adapters.StartOptions) error {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return err
+	}
+	program, err := s.GetProgram(ctx, sessionID, programID)
+	if err != nil {
+		return err
+	}
+	options.Program = program.Path
+	if options.MonitorMemory {
+		monitor, err := s.newMemoryMonitor(ctx, runtime, program)
+		if err != nil {
+			return err
+		}
+		options.OnMemoryAccess = monitor.access
+	}
+
+	var recording *models.Artifact
+	switch {
+	case runtime.Session.Mode == ModeReplay:
+		if options.Record {
+			return fmt.Errorf("replay sessions cannot record")
+		}
+		artifact, err := s.GetArtifact(ctx, runtime.Session.ReplayID)
+		if err != nil {
+			return err
+		}
+		options.Replay = true
+		options.ReplayDir = artifact.Path
+	case options.Record:
+		if runtime.Adapter.GetBackendType() != adapters.BackendQEMU {
+			return fmt.Errorf("recording requires the qemu backend")
+		}
+		if recording, err = s.beginRecording(ctx, runtime, program, options.Args); err != nil {
+			return err
+		}
+		options.ReplayDir = recording.Path
+	}
+
+	if err := runtime.Adapter.StartProgram(ctx, runtime.InstanceID, programID, options); err != nil {
+		if recording != nil {
+			s.discardArtifact(recording)
+		}
+		return err
+	}
+	// The earlier run, and with it its recording, ended with the restart
+	s.finishRecording(runtime)
+	if recording != nil {
+		runtime.mu.Lock()
+		runtime.recording = recording.ID
+		runtime.mu.Unlock()
+	}
+	s.installBreakpoints(ctx, runtime)
+	return nil
+}
+
+// beginRecording creates the replay artifact of a run of program. The
+// program is copied into it, so that replays do not depend on the session.
+func (s *Service) beginRecording(ctx context.Context, runtime *SessionRuntime, program *models.Program, args []string) (*models.Artifact, error) {
+	config, err := s.GetBoardConfig(ctx, runtime.Session.ID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := json.Marshal(ReplayMetadata{
+		Backend:     runtime.Session.Backend,
+		BoardConfig: *config,
+		Program:     ReplayProgram{Name: program.Name, Type: program.Type, LoadAddr: program.LoadAddr, SHA256: program.SHA256},
+		Args:        args,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	artifact := &models.Artifact{
+		ID:        uuid.New().String(),
+		SessionID: runtime.Session.ID,
+		Type:      ArtifactReplay,
+		Status:    ArtifactRecording,
+		ProgramID: program.ID,
+		Metadata:  string(metadata),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	artifact.Path = filepath.Join(s.replayDir, artifact.ID)
+	if err := os.MkdirAll(artifact.Path, 0755); err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	data, err := os.ReadFile(program.Path)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(artifact.Path, replayProgramFile), data, 0644)
+	}
+	if err != nil {
+		os.RemoveAll(artifact.Path)
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	if err := s.db.WithContext(ctx).Create(artifact).Error; err != nil {
+		os.RemoveAll(artifact.Path)
+		return nil, fmt.Errorf("failed to save artifact: %w", err)
+	}
+	return artifact, nil
+}
+
+// finishRecording marks the recording of a session ready once the run
+// that wrote it ended
+func (s *Service) finishRecording(runtime *SessionRuntime) {
+	runtime.mu.Lock()
+	id := runtime.recording
+	runtime.recording = ""
+	runtime.mu.Unlock()
+	if id == "" {
+		return
+	}
+
+	var artifact models.Artifact
+	if err := s.db.Where("id = ?", id).First(&artifact).Error; err != nil {
+		return
+	}
+	err := s.db.Model(&artifact).Updates(map[string]interface{}{
+		"status":     ArtifactReady,
+		"size":       dirSize(artifact.Path),
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		log.Printf("Warning: failed to finish recording %s: %v", id, err)
+	}
+}
+
+// discardArtifact removes an artifact that was never written
+func (s *Service) discardArtifact(artifact *models.Artifact) {
+	s.db.Where("id = ?", artifact.ID).Delete(&models.Artifact{})
+	os.RemoveAll(artifact.Path)
+}
+
+// GetArtifact returns an artifact
+func (s *Service) GetArtifact(ctx context.Context, artifactID string) (*models.Artifact, error) {
+	var artifact models.Artifact
+	err := s.db.WithContext(ctx).Where("id = ?", artifactID).First(&artifact).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrArtifactNotFound, artifactID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// ArtifactFile returns the path of a file of a ready artifact, such as the
+// reports of a coverage artifact
+func (s *Service) ArtifactFile(ctx context.Context, artifactID, name string) (string, error) {
+	artifact, err := s.GetArtifact(ctx, artifactID)
+	if err != nil {
+		return "", err
+	}
+	if artifact.Status != ArtifactReady {
+		return "", fmt.Errorf("artifact %s is not ready", artifactID)
+	}
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("%w: %s has no file %s", ErrArtifactNotFound, artifactID, name)
+	}
+	path := filepath.Join(artifact.Path, name)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%w: %s has no file %s", ErrArtifactNotFound, artifactID, name)
+	}
+	return path, nil
+}
+
+// saveArtifact writes the files of a ready artifact below dir and saves
+// it with its metadata
+func (s *Service) saveArtifact(ctx context.Context, artifact *models.Artifact, dir string, metadata interface{}, files map[string]func(io.Writer) error) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	artifact.ID = uuid.New().String()
+	artifact.Status = ArtifactReady
+	artifact.Metadata = string(data)
+	artifact.CreatedAt = time.Now()
+	artifact.UpdatedAt = time.Now()
+	artifact.Path = filepath.Join(dir, artifact.ID)
+	if err := os.MkdirAll(artifact.Path, 0755); err != nil {
+		return fmt.Errorf("create %s artifact: %w", artifact.Type, err)
+	}
+	for name, write := range files {
+		if err := writeArtifactFile(filepath.Join(artifact.Path, name), write); err != nil {
+			os.RemoveAll(artifact.Path)
+			return fmt.Errorf("create %s artifact: %w", artifact.Type, err)
+		}
+	}
+
+	artifact.Size = dirSize(artifact.Path)
+	if err := s.db.WithContext(ctx).Create(artifact).Error; err != nil {
+		os.RemoveAll(artifact.Path)
+		return fmt.Errorf("failed to save artifact: %w", err)
+	}
+	return nil
+}
+
+func writeArtifactFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeJSON writes a value as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// ListArtifacts lists the artifacts a session produced, newest first
+func (s *Service) ListArtifacts(ctx context.Context, sessionID string) ([]models.Artifact, error) {
+	var artifacts []models.Artifact
+	if err := s.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("created_at DESC").Find(&artifacts).Error; err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// replaySource returns a finished recording to replay and its metadata
+func (s *Service) replaySource(ctx context.Context, artifactID string) (*models.Artifact, *ReplayMetadata, error) {
+	artifact, err := s.GetArtifact(ctx, artifactID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if artifact.Type != ArtifactReplay {
+		return nil, nil, fmt.Errorf("artifact %s is not a recording", artifactID)
+	}
+	if artifact.Status != ArtifactReady {
+		return nil, nil, fmt.Errorf("recording %s is not finished", artifactID)
+	}
+	var metadata ReplayMetadata
+	if err := json.Unmarshal([]byte(artifact.Metadata), &metadata); err != nil {
+		return nil, nil, fmt.Errorf("invalid recording metadata: %w", err)
+	}
+	return artifact, &metadata, nil
+}
+
+// addReplayProgram uploads the recorded program to a replay session
+func (s *Service) addReplayProgram(ctx context.Context, sessionID string, artifact *models.Artifact, metadata *ReplayMetadata) error {
+	file, err := os.Open(filepath.Join(artifact.Path, replayProgramFile))
+	if err != nil {
+		return fmt.Errorf("open recorded program: %w", err)
+	}
+	defer file.Close()
+	upload := ProgramUpload{Name: metadata.Program.Name, Type: metadata.Program.Type, LoadAddr: metadata.Program.LoadAddr}
+	_, err = s.UploadProgram(ctx, sessionID, upload, file)
+	return err
+}
+
+// ReverseStep executes one instruction backwards in a replay session
+func (s *Service) ReverseStep(ctx context.Context, sessionID string) (*adapters.StopEvent, error) {
+	return s.reverse(ctx, sessionID, true)
+}
+
+// ReverseContinue runs a replay session backwards until a breakpoint
+// whose condition holds, or the beginning of the recording. Hit and
+// ignore counts only apply to forward execution.
+func (s *Service) ReverseContinue(ctx context.Context, sessionID string) (*adapters.StopEvent, error) {
+	return s.reverse(ctx, sessionID, false)
+}
+
+func (s *Service) reverse(ctx context.Context, sessionID string, step bool) (*adapters.StopEvent, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.Session.Mode != ModeReplay {
+		return nil, fmt.Errorf("reverse execution requires a replay session")
+	}
+	return s.resume(ctx, sessionID, step, true)
+}
+
+// dirSize sums the sizes of the files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,129 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// recordAdapter writes a replay log for recorded runs
+type recordAdapter struct {
+	adapters.BackendAdapter
+
+	started *adapters.StartOptions
+}
+
+func (a *recordAdapter) GetBackendType() adapters.BackendType {
+	return adapters.BackendQEMU
+}
+
+func (a *recordAdapter) StartProgram(ctx context.Context, instanceID, programID string, options *adapters.StartOptions) error {
+	a.started = options
+	if options.Record {
+		return os.WriteFile(filepath.Join(options.ReplayDir, adapters.ReplayLogFile), make([]byte, 100), 0644)
+	}
+	return nil
+}
+
+func (a *recordAdapter) DestroyInstance(ctx context.Context, instanceID string) error {
+	return nil
+}
+
+func newReplayService(t *testing.T) (*Service, *recordAdapter) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Session{}, &models.Program{}, &models.Artifact{}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db, nil, t.TempDir())
+	adapter := &recordAdapter{}
+	for _, session := range []*models.Session{
+		{ID: "session-1", Backend: "qemu", BoardConfig: `{"name":"board"}`},
+		{ID: "session-2", Backend: "qemu", BoardConfig: `{"name":"board"}`},
+	} {
+		if err := db.Create(session).Error; err != nil {
+			t.Fatal(err)
+		}
+		s.sessions[session.ID] = &SessionRuntime{
+			Session:     session,
+			Adapter:     adapter,
+			Breakpoints: newBreakpointRegistry(),
+			Events:      newEventLog(),
+			stops:       newStopFilter(),
+		}
+	}
+	return s, adapter
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	s, adapter := newReplayService(t)
+	ctx := context.Background()
+	program, err := s.UploadProgram(ctx, "session-1", ProgramUpload{Name: "fw.bin", LoadAddr: 0x08000000}, bytes.NewReader([]byte("firmware")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StartProgram(ctx, "session-1", program.ID, &adapters.StartOptions{Record: true}); err != nil {
+		t.Fatal(err)
+	}
+	artifacts, err := s.ListArtifacts(ctx, "session-1")
+	if err != nil || len(artifacts) != 1 {
+		t.Fatalf("got artifacts %v, %v", artifacts, err)
+	}
+	recording := artifacts[0]
+	if recording.Status != ArtifactRecording || adapter.started.ReplayDir != recording.Path || adapter.started.Program != program.Path {
+		t.Errorf("unexpected recording %+v started with %+v", recording, adapter.started)
+	}
+	if _, _, err := s.replaySource(ctx, recording.ID); err == nil {
+		t.Error("unfinished recording accepted for replay")
+	}
+
+	// Deleting the session finishes the recording, which outlives it
+	if err := s.DeleteSession(ctx, "session-1"); err != nil {
+		t.Fatal(err)
+	}
+	finished, err := s.GetArtifact(ctx, recording.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished.Status != ArtifactReady || finished.Size != 100+int64(len("firmware")) {
+		t.Errorf("unexpected finished recording %+v", finished)
+	}
+
+	// A replay session gets the recorded program and replays the log
+	artifact, metadata, err := s.replaySource(ctx, recording.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.BoardConfig.Name != "board" || metadata.Program.LoadAddr != 0x08000000 {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if err := s.addReplayProgram(ctx, "session-2", artifact, metadata); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := s.ListPrograms(ctx, "session-2")
+	if err != nil || len(replayed) != 1 || replayed[0].SHA256 != program.SHA256 {
+		t.Fatalf("got programs %v, %v", replayed, err)
+	}
+	runtime := s.sessions["session-2"]
+	runtime.Session.Mode, runtime.Session.ReplayID = ModeReplay, recording.ID
+	if err := s.StartProgram(ctx, "session-2", replayed[0].ID, &adapters.StartOptions{Record: true}); err == nil {
+		t.Error("replay session started recording")
+	}
+	if err := s.StartProgram(ctx, "session-2", replayed[0].ID, &adapters.StartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !adapter.started.Replay || adapter.started.ReplayDir != recording.Path {
+		t.Errorf("replay started with %+v", adapter.started)
+	}
+}
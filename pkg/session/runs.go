@@ -0,0 +1,59 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+)
+
+// untilBreakpointID is the breakpoint at which the runs of a job end
+const untilBreakpointID = "job-until"
+
+// setUntil sets the breakpoint ending the runs of a job at a location and
+// returns the function removing it
+func (s *Service) setUntil(ctx context.Context, sessionID, location string) (func(), error) {
+	bp := &adapters.Breakpoint{ID: untilBreakpointID, Location: location, Enabled: true}
+	if err := s.SetBreakpoint(ctx, sessionID, bp); err != nil {
+		return nil, err
+	}
+	return func() { s.RemoveBreakpoint(context.Background(), sessionID, untilBreakpointID) }, nil
+}
+
+// runProgram starts a program from power on, halted for the breakpoints to
+// be installed, and waits up to timeout for it to stop. The session stays
+// powered; when the program still runs after the timeout, the stop is a
+// running event.
+func (s *Service) runProgram(ctx context.Context, runtime *SessionRuntime, programID string, options *adapters.StartOptions, timeout time.Duration) (*adapters.StopEvent, error) {
+	sessionID := runtime.Session.ID
+	if runtime.powered() {
+		if err := s.PowerControl(ctx, sessionID, "off"); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.PowerControl(ctx, sessionID, "on"); err != nil {
+		return nil, err
+	}
+	options.WaitForGDB = true
+	if err := s.StartProgram(ctx, sessionID, programID, options); err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stop, err := s.Continue(runCtx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return stop, nil
+}
+
+// leavePoweredOff powers off a session that a job left powered
+func (s *Service) leavePoweredOff(runtime *SessionRuntime) {
+	if runtime.powered() {
+		s.PowerControl(context.Background(), runtime.Session.ID, "off")
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/forfire912/virServer/pkg/adapters"
 	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/template"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -16,24 +18,60 @@ import (
 // Service manages simulation sessions
 type Service struct {
 	db          *gorm.DB
+	templates   *template.Service
 	mu          sync.RWMutex
 	adapters    map[adapters.BackendType]adapters.BackendAdapter
 	sessions    map[string]*SessionRuntime
+	programDir  string
+	replayDir   string
+	coverageDir string
+	traceDir    string
+	profileDir  string
+	symbols     symbolCache
+	gdbAddress  string
 }
 
 // SessionRuntime holds runtime information for a session
 type SessionRuntime struct {
-	Session    *models.Session
-	Adapter    adapters.BackendAdapter
-	InstanceID string
+	Session     *models.Session
+	Adapter     adapters.BackendAdapter
+	InstanceID  string
+	Breakpoints *BreakpointRegistry
+	Events      *EventLog
+	GDB         *GDBClients
+	stops       *stopFilter
+	
+	mu        sync.Mutex
+	on        bool
+	recording string // Artifact of the recording in progress
+}
+
+func (r *SessionRuntime) powered() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.on
+}
+
+func (r *SessionRuntime) setPowered(on bool) {
+	r.mu.Lock()
+	r.on = on
+	r.mu.Unlock()
 }
 
-// NewService creates a new session service
-func NewService(db *gorm.DB) *Service {
+// NewService creates a new session service. Uploaded programs,
+// recordings, coverage reports, traces and profiles are stored below
+// artifactDir.
+func NewService(db *gorm.DB, templates *template.Service, artifactDir string) *Service {
 	return &Service{
-		db:       db,
-		adapters: make(map[adapters.BackendType]adapters.BackendAdapter),
-		sessions: make(map[string]*SessionRuntime),
+		db:          db,
+		templates:   templates,
+		adapters:    make(map[adapters.BackendType]adapters.BackendAdapter),
+		sessions:    make(map[string]*SessionRuntime),
+		programDir:  filepath.Join(artifactDir, "programs"),
+		replayDir:   filepath.Join(artifactDir, "recordings"),
+		coverageDir: filepath.Join(artifactDir, "coverage"),
+		traceDir:    filepath.Join(artifactDir, "traces"),
+		profileDir:  filepath.Join(artifactDir, "profiles"),
 	}
 }
 
@@ -46,10 +84,50 @@ func (s *Service) RegisterAdapter(backend adapters.BackendType, adapter adapters
 
 // CreateSession creates a new simulation session
 func (s *Service) CreateSession(ctx context.Context, req *CreateSessionRequest) (*models.Session, error) {
-	// Validate backend
-	backend := adapters.BackendType(req.Backend)
-	if backend == "" {
-		backend = adapters.BackendQEMU // Default
+	// Parse BoardConfig
+	var (
+		boardConfig     adapters.BoardConfig
+		templateID      string
+		templateVersion int
+		requested       = adapters.BackendType(req.Backend)
+		replay          *models.Artifact
+		recorded        *ReplayMetadata
+	)
+	if req.Replay != "" {
+		// Replays run on the board and backend of the recording
+		artifact, metadata, err := s.replaySource(ctx, req.Replay)
+		if err != nil {
+			return nil, err
+		}
+		boardConfig = metadata.BoardConfig
+		requested = adapters.BackendType(metadata.Backend)
+		replay, recorded = artifact, metadata
+	} else if len(req.BoardConfig) > 0 {
+		parsed, err := req.BoardConfig.Parse()
+		if err != nil {
+			return nil, err
+		}
+		boardConfig = *parsed
+	} else if req.BoardTemplate != "" {
+		// Render template with its base templates and parameters
+		ref := req.BoardTemplate
+		if req.TemplateVersion > 0 {
+			ref = fmt.Sprintf("%s@%d", req.BoardTemplate, req.TemplateVersion)
+		}
+		rendered, tmpl, err := s.templates.Render(ctx, ref, req.TemplateParams)
+		if err != nil {
+			return nil, err
+		}
+		boardConfig = *rendered
+		templateID, templateVersion = tmpl.ID, tmpl.Version
+	} else {
+		return nil, fmt.Errorf("either board_config, board_template or replay required")
+	}
+	
+	// Resolve and validate the backend of every node
+	backend, err := s.resolveBackends(requested, &boardConfig)
+	if err != nil {
+		return nil, err
 	}
 	
 	s.mu.RLock()
@@ -59,35 +137,27 @@ func (s *Service) CreateSession(ctx context.Context, req *CreateSessionRequest)
 	if !exists {
 		return nil, fmt.Errorf("backend not supported: %s", backend)
 	}
-	
-	// Parse BoardConfig
-	var boardConfig adapters.BoardConfig
-	if req.BoardConfig != "" {
-		if err := json.Unmarshal([]byte(req.BoardConfig), &boardConfig); err != nil {
-			return nil, fmt.Errorf("invalid board config: %w", err)
-		}
-	} else if req.BoardTemplate != "" {
-		// Load template
-		var template models.BoardTemplate
-		if err := s.db.Where("id = ?", req.BoardTemplate).First(&template).Error; err != nil {
-			return nil, fmt.Errorf("template not found: %w", err)
-		}
-		if err := json.Unmarshal([]byte(template.Config), &boardConfig); err != nil {
-			return nil, fmt.Errorf("invalid template config: %w", err)
-		}
-	} else {
-		return nil, fmt.Errorf("either board_config or board_template required")
+	if req.Deterministic && !adapter.GetCapabilities().Features["deterministic"] {
+		return nil, fmt.Errorf("backend %s does not support deterministic execution", backend)
 	}
 	
 	// Create session record
 	session := &models.Session{
-		ID:      uuid.New().String(),
-		Name:    req.Name,
-		Backend: string(backend),
-		Status:  string(models.SessionCreated),
-		UserID:  getUserIDFromContext(ctx),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:              uuid.New().String(),
+		Name:            req.Name,
+		Backend:         string(backend),
+		Status:          string(models.SessionCreated),
+		UserID:          getUserIDFromContext(ctx),
+		TemplateID:      templateID,
+		TemplateVersion: templateVersion,
+		Deterministic:   req.Deterministic,
+		Seed:            req.Seed,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	if replay != nil {
+		session.Mode = ModeReplay
+		session.ReplayID = replay.ID
 	}
 	
 	configBytes, _ := json.Marshal(boardConfig)
@@ -99,6 +169,8 @@ func (s *Service) CreateSession(ctx context.Context, req *CreateSessionRequest)
 		MemoryMB:   req.Resources.MemoryMB,
 		DiskGB:     req.Resources.DiskGB,
 		TimeoutSec: req.Resources.TimeoutSec,
+		Deterministic: req.Deterministic,
+		Seed:          req.Seed,
 	}
 	
 	instanceID, err := adapter.CreateInstance(ctx, session.ID, &boardConfig, resources)
@@ -117,13 +189,27 @@ func (s *Service) CreateSession(ctx context.Context, req *CreateSessionRequest)
 	
 	// Store runtime info
 	s.mu.Lock()
-	s.sessions[session.ID] = &SessionRuntime{
-		Session:    session,
-		Adapter:    adapter,
-		InstanceID: instanceID,
+	runtime := &SessionRuntime{
+		Session:     session,
+		Adapter:     adapter,
+		InstanceID:  instanceID,
+		Breakpoints: newBreakpointRegistry(),
+		Events:      newEventLog(),
+		GDB:         newGDBClients(),
+		stops:       newStopFilter(),
 	}
+	s.sessions[session.ID] = runtime
 	s.mu.Unlock()
 	
+	s.watchStops(ctx, runtime)
+	
+	if replay != nil {
+		if err := s.addReplayProgram(ctx, session.ID, replay, recorded); err != nil {
+			s.DeleteSession(ctx, session.ID)
+			return nil, err
+		}
+	}
+	
 	return session, nil
 }
 
@@ -159,6 +245,9 @@ func (s *Service) DeleteSession(ctx context.Context, sessionID string) error {
 	if exists {
 		// Destroy backend instance
 		runtime.Adapter.DestroyInstance(ctx, runtime.InstanceID)
+		s.finishRecording(runtime)
+		runtime.Events.close()
+		runtime.stops.close()
 		delete(s.sessions, sessionID)
 	}
 	s.mu.Unlock()
@@ -167,6 +256,7 @@ func (s *Service) DeleteSession(ctx context.Context, sessionID string) error {
 	if err := s.db.Where("id = ?", sessionID).Delete(&models.Session{}).Error; err != nil {
 		return err
 	}
+	s.deletePrograms(sessionID)
 	
 	return nil
 }
@@ -186,15 +276,22 @@ func (s *Service) PowerControl(ctx context.Context, sessionID string, action str
 	case "on":
 		err = runtime.Adapter.PowerOn(ctx, runtime.InstanceID)
 		if err == nil {
+			runtime.setPowered(true)
 			s.updateSessionStatus(sessionID, models.SessionRunning)
+			s.installBreakpoints(ctx, runtime)
 		}
 	case "off":
 		err = runtime.Adapter.PowerOff(ctx, runtime.InstanceID)
 		if err == nil {
+			runtime.setPowered(false)
+			s.finishRecording(runtime)
 			s.updateSessionStatus(sessionID, models.SessionStopped)
 		}
 	case "reset":
 		err = runtime.Adapter.Reset(ctx, runtime.InstanceID)
+		if err == nil && runtime.powered() {
+			s.installBreakpoints(ctx, runtime)
+		}
 	default:
 		return fmt.Errorf("invalid power action: %s", action)
 	}
@@ -202,6 +299,20 @@ func (s *Service) PowerControl(ctx context.Context, sessionID string, action str
 	return err
 }
 
+// GetBoardConfig returns the board configuration of a session
+func (s *Service) GetBoardConfig(ctx context.Context, sessionID string) (*adapters.BoardConfig, error) {
+	session, err := s.GetSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	
+	var config adapters.BoardConfig
+	if err := json.Unmarshal([]byte(session.BoardConfig), &config); err != nil {
+		return nil, fmt.Errorf("invalid board config: %w", err)
+	}
+	return &config, nil
+}
+
 // GetAdapter returns the adapter for a session
 func (s *Service) GetAdapter(sessionID string) (adapters.BackendAdapter, string, error) {
 	s.mu.RLock()
@@ -215,6 +326,70 @@ func (s *Service) GetAdapter(sessionID string) (adapters.BackendAdapter, string,
 	return runtime.Adapter, runtime.InstanceID, nil
 }
 
+// resolveBackends picks the backend that hosts the session instance and
+// checks every node against its capabilities. Nodes without a backend
+// inherit the requested one. A single instance runs every node, so the
+// backends named by the request or the nodes must agree; when none is
+// named, "auto" selects the best registered adapter that can run all nodes.
+func (s *Service) resolveBackends(requested adapters.BackendType, config *adapters.BoardConfig) (adapters.BackendType, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	
+	if requested == "" {
+		requested = adapters.BackendQEMU // Default
+	}
+	if len(config.Nodes) == 0 {
+		if requested == adapters.BackendAuto {
+			return adapters.BackendQEMU, nil
+		}
+		return requested, nil
+	}
+	
+	// The hosting backend is the requested one, else the first one a node names
+	var backend adapters.BackendType
+	if requested != adapters.BackendAuto {
+		backend = requested
+	}
+	for _, node := range config.Nodes {
+		if backend == "" && node.Backend != "" && node.Backend != adapters.BackendAuto {
+			backend = node.Backend
+		}
+	}
+	if backend == "" {
+		selected, err := adapters.SelectBoardBackend(config.Nodes, config.Interconnect, s.adapters)
+		if err != nil {
+			return "", err
+		}
+		backend = selected
+	}
+	
+	adapter, exists := s.adapters[backend]
+	if !exists {
+		return "", fmt.Errorf("backend not supported: %s", backend)
+	}
+	var problems adapters.CompatibilityErrors
+	for i := range config.Nodes {
+		node := &config.Nodes[i]
+		if node.Backend != "" && node.Backend != adapters.BackendAuto && node.Backend != backend {
+			problems = append(problems, &adapters.CompatibilityError{
+				NodeID:  node.ID,
+				Backend: backend,
+				Reasons: []string{fmt.Sprintf("node names backend %s; all nodes of a session run on one backend", node.Backend)},
+			})
+			continue
+		}
+		if reasons := adapters.CheckCompatibility(node, config.Interconnect, adapter.GetCapabilities()); len(reasons) > 0 {
+			problems = append(problems, &adapters.CompatibilityError{NodeID: node.ID, Backend: backend, Reasons: reasons})
+			continue
+		}
+		node.Backend = backend
+	}
+	if len(problems) > 0 {
+		return "", problems
+	}
+	return backend, nil
+}
+
 // Helper function to update session status
 func (s *Service) updateSessionStatus(sessionID string, status models.SessionStatus) {
 	s.db.Model(&models.Session{}).Where("id = ?", sessionID).Updates(map[string]interface{}{
@@ -234,11 +409,16 @@ func getUserIDFromContext(ctx context.Context) string {
 
 // CreateSessionRequest represents a request to create a session
 type CreateSessionRequest struct {
-	Name          string         `json:"name" binding:"required"`
-	Backend       string         `json:"backend"`
-	BoardConfig   string         `json:"board_config"`
-	BoardTemplate string         `json:"board_template"`
-	Resources     ResourceConfig `json:"resources"`
+	Name            string                  `json:"name" binding:"required"`
+	Backend         string                  `json:"backend"`
+	BoardConfig     adapters.RawBoardConfig `json:"board_config" swaggertype:"string"` // JSON/YAML document or object
+	BoardTemplate   string                  `json:"board_template"`                    // Template ID, optionally "id@version"
+	TemplateVersion int                     `json:"template_version,omitempty"`        // Latest when omitted
+	TemplateParams  map[string]interface{}  `json:"template_params,omitempty"`
+	Replay          string                  `json:"replay,omitempty"` // Recording artifact ID to replay
+	Deterministic   bool                    `json:"deterministic,omitempty"` // Reproducible timing and randomness
+	Seed            uint64                  `json:"seed,omitempty"`          // Of the backend's randomness in deterministic mode
+	Resources       ResourceConfig          `json:"resources"`
 }
 
 // ResourceConfig represents resource configuration
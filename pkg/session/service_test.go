@@ -0,0 +1,89 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+)
+
+// capsAdapter is a backend supporting a fixed list of processors
+type capsAdapter struct {
+	adapters.BackendAdapter
+
+	processors []string
+}
+
+func (a *capsAdapter) GetCapabilities() *adapters.BackendCapabilities {
+	return &adapters.BackendCapabilities{Processors: a.processors}
+}
+
+func TestResolveBackends(t *testing.T) {
+	s := NewService(nil, nil, t.TempDir())
+	s.RegisterAdapter(adapters.BackendQEMU, &capsAdapter{processors: []string{"ARM Cortex-M4"}})
+	s.RegisterAdapter(adapters.BackendRenode, &capsAdapter{processors: []string{"ARM Cortex-M4", "RISC-V"}})
+	node := func(id, processor string, backend adapters.BackendType) adapters.NodeConfig {
+		return adapters.NodeConfig{ID: id, Backend: backend, Processor: &adapters.ProcessorConfig{Type: processor}}
+	}
+
+	config := &adapters.BoardConfig{Nodes: []adapters.NodeConfig{node("a", "ARM Cortex-M4", ""), node("b", "ARM Cortex-M4", "")}}
+	if backend, err := s.resolveBackends(adapters.BackendRenode, config); err != nil || backend != adapters.BackendRenode {
+		t.Errorf("resolveBackends = %s, %v", backend, err)
+	}
+
+	// Nodes naming different backends cannot share the instance
+	config = &adapters.BoardConfig{Nodes: []adapters.NodeConfig{node("a", "ARM Cortex-M4", ""), node("b", "ARM Cortex-M4", adapters.BackendRenode)}}
+	_, err := s.resolveBackends(adapters.BackendQEMU, config)
+	problems, ok := err.(adapters.CompatibilityErrors)
+	if !ok || len(problems) != 1 || problems[0].NodeID != "b" || problems[0].Backend != adapters.BackendQEMU || !strings.Contains(err.Error(), "names backend renode") {
+		t.Errorf("mixed backends: %v", err)
+	}
+
+	// Auto nodes are checked against the backend named by the others
+	config = &adapters.BoardConfig{Nodes: []adapters.NodeConfig{node("a", "ARM Cortex-M4", adapters.BackendQEMU), node("b", "RISC-V", adapters.BackendAuto)}}
+	_, err = s.resolveBackends(adapters.BackendAuto, config)
+	if problems, ok := err.(adapters.CompatibilityErrors); !ok || len(problems) != 1 || problems[0].NodeID != "b" || problems[0].Backend != adapters.BackendQEMU {
+		t.Errorf("auto node on qemu: %v", err)
+	}
+
+	// Auto picks one backend that runs every node, even if another one
+	// would be preferred for some of them
+	config = &adapters.BoardConfig{Nodes: []adapters.NodeConfig{node("a", "ARM Cortex-M4", ""), node("b", "RISC-V", adapters.BackendAuto)}}
+	if backend, err := s.resolveBackends(adapters.BackendAuto, config); err != nil || backend != adapters.BackendRenode {
+		t.Errorf("resolveBackends(auto) = %s, %v", backend, err)
+	}
+	for _, n := range config.Nodes {
+		if n.Backend != adapters.BackendRenode {
+			t.Errorf("node %s resolved to %s", n.ID, n.Backend)
+		}
+	}
+
+	// Auto fails only when no backend runs them all
+	s.RegisterAdapter(adapters.BackendQEMU, &capsAdapter{processors: []string{"ARM Cortex-M4", "x86"}})
+	config = &adapters.BoardConfig{Nodes: []adapters.NodeConfig{node("a", "x86", ""), node("b", "RISC-V", "")}}
+	_, err = s.resolveBackends(adapters.BackendAuto, config)
+	if problems, ok := err.(adapters.CompatibilityErrors); !ok || len(problems) != 2 || problems[0].Backend != adapters.BackendAuto {
+		t.Errorf("no common backend: %v", err)
+	}
+}
+
+func TestResolveBackendsExamples(t *testing.T) {
+	s := NewService(nil, nil, t.TempDir())
+	s.RegisterAdapter(adapters.BackendQEMU, adapters.NewQEMUAdapter(t.TempDir()))
+	s.RegisterAdapter(adapters.BackendRenode, adapters.NewRenodeAdapter(t.TempDir()))
+	for _, name := range []string{"stm32f4-disco.json", "multi-node-system.json"} {
+		data, err := os.ReadFile(filepath.Join("..", "..", "examples", "configs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := adapters.ParseBoardConfig(data, adapters.FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.resolveBackends(adapters.BackendAuto, config); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
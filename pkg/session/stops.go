@@ -0,0 +1,275 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/condition"
+)
+
+// Stop filter limits
+const (
+	stopQueue   = 64              // Stops waiting to be filtered
+	stopTimeout = 5 * time.Second // Evaluating a condition of one stop
+)
+
+// stopFilter applies the conditions and ignore counts of breakpoints to the
+// stops of a session. Stops that should not halt the target are resumed
+// right away; the others are published to the event log. Callers resuming
+// the target therefore wait for the event log rather than the backend.
+type stopFilter struct {
+	mu       sync.Mutex
+	idle     *sync.Cond // Signalled when no stop is pending
+	active   bool       // The backend reports stops
+	pending  int        // Stops queued or being filtered
+	stepping bool       // The next stop ends a step and is reported as is
+	reverse  bool       // The target runs backwards through a recording
+	closed   bool
+
+	stops chan *adapters.StopEvent
+	done  chan struct{}
+}
+
+func newStopFilter() *stopFilter {
+	f := &stopFilter{
+		stops: make(chan *adapters.StopEvent, stopQueue),
+		done:  make(chan struct{}),
+	}
+	f.idle = sync.NewCond(&f.mu)
+	return f
+}
+
+// push queues a stop. It reports false when the stop cannot be filtered
+// and must be published as it is.
+func (f *stopFilter) push(stop *adapters.StopEvent) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.active || f.closed {
+		return false
+	}
+	select {
+	case f.stops <- stop:
+		f.pending++
+		return true
+	default:
+		return false
+	}
+}
+
+// finish marks a queued stop as handled
+func (f *stopFilter) finish() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending--
+	if f.pending == 0 {
+		f.idle.Broadcast()
+	}
+}
+
+// begin waits until earlier stops are handled before the target is
+// resumed, so their events are not taken for the stop of this resume. It
+// reports whether stops are filtered; end must be called when they are.
+func (f *stopFilter) begin(step, reverse bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.pending > 0 && !f.closed {
+		f.idle.Wait()
+	}
+	if !f.active || f.closed {
+		return false
+	}
+	f.stepping = step
+	f.reverse = reverse
+	return true
+}
+
+func (f *stopFilter) end() {
+	f.mu.Lock()
+	f.stepping = false
+	f.reverse = false
+	f.mu.Unlock()
+}
+
+func (f *stopFilter) isStepping() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stepping
+}
+
+func (f *stopFilter) isReverse() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reverse
+}
+
+func (f *stopFilter) activate() {
+	f.mu.Lock()
+	f.active = true
+	f.mu.Unlock()
+}
+
+func (f *stopFilter) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.done)
+		f.idle.Broadcast()
+	}
+}
+
+// filterStops handles the stops of a session until it is deleted
+func (s *Service) filterStops(runtime *SessionRuntime) {
+	f := runtime.stops
+	for {
+		select {
+		case stop := <-f.stops:
+			if s.keepStopped(runtime, stop) {
+				runtime.Events.Publish(EventStop, stop)
+			}
+			f.finish()
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// keepStopped reports whether a stop halts the target, resuming it when
+// not. Breakpoint hits count when the condition holds and stop the target
+// once the ignore count is used up. A condition that cannot be evaluated
+// stops the target with the error. Running backwards, hits are not counted
+// and only the condition applies. Steps are not filtered, nor stops while
+// a GDB client controls the target.
+func (s *Service) keepStopped(runtime *SessionRuntime, stop *adapters.StopEvent) bool {
+	if stop.BreakpointID == "" || runtime.stops.isStepping() {
+		return true
+	}
+	if controlled, _ := runtime.GDB.Counts(); controlled {
+		return true
+	}
+	bp, ok := runtime.Breakpoints.Get(stop.BreakpointID)
+	if !ok {
+		return true
+	}
+
+	if bp.Condition != "" {
+		holds, err := s.testCondition(runtime, bp.Condition)
+		if err != nil {
+			stop.ConditionError = err.Error()
+			return true
+		}
+		if !holds {
+			return !s.resumeStopped(runtime)
+		}
+	}
+	if runtime.stops.isReverse() || runtime.Breakpoints.hit(bp.ID) {
+		return true
+	}
+	return !s.resumeStopped(runtime)
+}
+
+// testCondition evaluates a breakpoint condition on the halted target
+func (s *Service) testCondition(runtime *SessionRuntime, text string) (bool, error) {
+	expr, err := condition.Parse(text)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	regs, err := runtime.Adapter.ReadRegisters(ctx, runtime.InstanceID, "general")
+	if err != nil {
+		return false, err
+	}
+	env := &condition.Env{
+		Registers: registerValues(regs),
+		Memory: func(address uint64, size int) ([]byte, error) {
+			return runtime.Adapter.ReadMemory(ctx, runtime.InstanceID, address, uint32(size))
+		},
+		Order: binary.LittleEndian,
+	}
+	if table, _, err := s.Symbols(ctx, runtime.Session.ID, ""); err == nil && table.ByteOrder == "big" {
+		env.Order = binary.BigEndian
+	}
+	return expr.Eval(env)
+}
+
+// resumeStopped continues the target, in the direction it ran, without
+// waiting for its next stop. It reports false when the target could not be
+// resumed.
+func (s *Service) resumeStopped(runtime *SessionRuntime) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // Do not wait for the stop
+	resume := runtime.Adapter.Continue
+	if runtime.stops.isReverse() {
+		resume = runtime.Adapter.ReverseContinue
+	}
+	if _, err := resume(ctx, runtime.InstanceID); err != nil {
+		log.Printf("Warning: failed to resume session %s after a breakpoint hit: %v", runtime.Session.ID, err)
+		return false
+	}
+	return true
+}
+
+// Continue resumes the target and waits until ctx is done for a stop that
+// halts it: breakpoint hits whose condition does not hold or that are
+// ignored resume the target again. When ctx ends first, a running event is
+// returned and the target keeps running.
+func (s *Service) Continue(ctx context.Context, sessionID string) (*adapters.StopEvent, error) {
+	return s.resume(ctx, sessionID, false, false)
+}
+
+// StepInstruction executes one instruction and reports where the target
+// stopped. Breakpoints reached by the step are reported without counting
+// as hits.
+func (s *Service) StepInstruction(ctx context.Context, sessionID string) (*adapters.StopEvent, error) {
+	return s.resume(ctx, sessionID, true, false)
+}
+
+func (s *Service) resume(ctx context.Context, sessionID string, step, reverse bool) (*adapters.StopEvent, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	run := runtime.Adapter.Continue
+	switch {
+	case step && reverse:
+		run = runtime.Adapter.ReverseStep
+	case step:
+		run = runtime.Adapter.StepInstruction
+	case reverse:
+		run = runtime.Adapter.ReverseContinue
+	}
+	if !runtime.stops.begin(step, reverse) {
+		// The backend does not report stops; there is nothing to filter
+		return run(ctx, runtime.InstanceID)
+	}
+	defer runtime.stops.end()
+
+	// Events before the resume are not of interest
+	_, events, cancel := runtime.Events.Subscribe(runtime.Events.Seq())
+	defer cancel()
+
+	stop, err := run(ctx, runtime.InstanceID)
+	if err != nil || stop.Reason == adapters.StopRunning {
+		return stop, err
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil, errors.New("debug event stream closed")
+			}
+			if event.Type == EventStop {
+				return event.StopEvent, nil
+			}
+		case <-ctx.Done():
+			return &adapters.StopEvent{Reason: adapters.StopRunning}, nil
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// stopAdapter stops at a breakpoint on every resume. The word at
+// 0x20000000 counts the resumes.
+type stopAdapter struct {
+	adapters.BackendAdapter
+
+	mu       sync.Mutex
+	resumes  uint32
+	reverses uint32 // Resumes that ran backwards
+	handler  func(*adapters.StopEvent)
+}
+
+func (a *stopAdapter) WatchStops(ctx context.Context, instanceID string, handler func(*adapters.StopEvent)) error {
+	a.handler = handler
+	return nil
+}
+
+func (a *stopAdapter) Continue(ctx context.Context, instanceID string) (*adapters.StopEvent, error) {
+	a.mu.Lock()
+	a.resumes++
+	a.mu.Unlock()
+	stop := &adapters.StopEvent{Reason: adapters.StopBreakpoint, PC: 0x08000100, BreakpointID: "bp-1"}
+	a.handler(stop)
+	if ctx.Err() != nil {
+		return &adapters.StopEvent{Reason: adapters.StopRunning}, nil
+	}
+	return stop, nil
+}
+
+func (a *stopAdapter) StepInstruction(ctx context.Context, instanceID string) (*adapters.StopEvent, error) {
+	return a.Continue(ctx, instanceID)
+}
+
+func (a *stopAdapter) ReverseContinue(ctx context.Context, instanceID string) (*adapters.StopEvent, error) {
+	a.mu.Lock()
+	a.reverses++
+	a.mu.Unlock()
+	return a.Continue(ctx, instanceID)
+}
+
+func (a *stopAdapter) ReadRegisters(ctx context.Context, instanceID string, scope string) (map[string]interface{}, error) {
+	return map[string]interface{}{"r0": uint64(5), "pc": uint64(0x08000100)}, nil
+}
+
+func (a *stopAdapter) ReadMemory(ctx context.Context, instanceID string, address uint64, size uint32) ([]byte, error) {
+	if address != 0x20000000 || size != 4 {
+		return nil, fmt.Errorf("unmapped")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return binary.LittleEndian.AppendUint32(nil, a.resumes), nil
+}
+
+func newStopService(t *testing.T) (*Service, *stopAdapter) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Program{}); err != nil {
+		t.Fatal(err)
+	}
+	adapter := &stopAdapter{}
+	runtime := &SessionRuntime{
+		Session:     &models.Session{ID: "session-1"},
+		Adapter:     adapter,
+		Breakpoints: newBreakpointRegistry(),
+		Events:      newEventLog(),
+		GDB:         newGDBClients(),
+		stops:       newStopFilter(),
+	}
+	s := &Service{db: db, sessions: map[string]*SessionRuntime{"session-1": runtime}}
+	s.watchStops(context.Background(), runtime)
+	t.Cleanup(runtime.stops.close)
+	return s, adapter
+}
+
+func TestContinue_ConditionAndIgnoreCount(t *testing.T) {
+	s, adapter := newStopService(t)
+	ctx := context.Background()
+	bp := &adapters.Breakpoint{ID: "bp-1", Address: 0x08000100, Condition: "r0 == 5 && *(u32 *)0x20000000 >= 2", IgnoreCount: 1, Enabled: true}
+	if err := s.SetBreakpoint(ctx, "session-1", bp); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first hit fails the condition, the second is ignored
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	stop, err := s.Continue(waitCtx, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != adapters.StopBreakpoint || stop.BreakpointID != "bp-1" || adapter.resumes != 3 {
+		t.Errorf("stopped with %+v after %d resumes", stop, adapter.resumes)
+	}
+	if got, _ := s.GetBreakpoint("session-1", "bp-1"); got.HitCount != 2 {
+		t.Errorf("hit count %d, want 2", got.HitCount)
+	}
+
+	// Only the reported stop reaches the event log
+	backlog, _, unsubscribe, _ := s.SubscribeEvents("session-1", 0)
+	unsubscribe()
+	if len(backlog) != 1 {
+		t.Errorf("event log holds %d events, want 1", len(backlog))
+	}
+
+	// Steps onto a breakpoint do not count as hits
+	if _, err := s.StepInstruction(ctx, "session-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetBreakpoint("session-1", "bp-1"); got.HitCount != 2 || adapter.resumes != 4 {
+		t.Errorf("step changed the hit count to %d, %d resumes", got.HitCount, adapter.resumes)
+	}
+}
+
+func TestContinue_ConditionError(t *testing.T) {
+	s, _ := newStopService(t)
+	ctx := context.Background()
+	if err := s.SetBreakpoint(ctx, "session-1", &adapters.Breakpoint{ID: "bp-1", Condition: "r9 == 1", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	stop, err := s.Continue(waitCtx, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.ConditionError == "" {
+		t.Errorf("expected a condition error, got %+v", stop)
+	}
+}
+
+func TestSetBreakpoint_Validation(t *testing.T) {
+	s, _ := newStopService(t)
+	ctx := context.Background()
+	for _, bp := range []adapters.Breakpoint{
+		{Type: "temporary"},
+		{Condition: "r0 =="},
+		{IgnoreCount: -1},
+	} {
+		bp := bp
+		if err := s.SetBreakpoint(ctx, "session-1", &bp); err == nil {
+			t.Errorf("SetBreakpoint(%+v) succeeded", bp)
+		}
+	}
+
+	wp := &adapters.Breakpoint{Type: adapters.WatchWrite, Location: "0x20000000", Enabled: true}
+	if err := s.SetBreakpoint(ctx, "session-1", wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Address != 0x20000000 || wp.Length != adapters.DefaultWatchLength {
+		t.Errorf("unexpected watchpoint %+v", wp)
+	}
+}
+
+func TestReverseContinue(t *testing.T) {
+	s, adapter := newStopService(t)
+	ctx := context.Background()
+	bp := &adapters.Breakpoint{ID: "bp-1", Condition: "*(u32 *)0x20000000 >= 2", IgnoreCount: 5, Enabled: true}
+	if err := s.SetBreakpoint(ctx, "session-1", bp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReverseContinue(ctx, "session-1"); err == nil {
+		t.Fatal("reverse continue outside a replay session succeeded")
+	}
+
+	// The first hit fails the condition; the second stops without using
+	// up the ignore count
+	s.sessions["session-1"].Session.Mode = ModeReplay
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	stop, err := s.ReverseContinue(waitCtx, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.BreakpointID != "bp-1" || adapter.reverses != 2 || adapter.resumes != 2 {
+		t.Errorf("stopped with %+v after %d reverses, %d resumes", stop, adapter.reverses, adapter.resumes)
+	}
+	if got, _ := s.GetBreakpoint("session-1", "bp-1"); got.HitCount != 0 {
+		t.Errorf("reverse hits counted: %d", got.HitCount)
+	}
+}
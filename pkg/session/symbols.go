@@ -0,0 +1,73 @@
+package session
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// ResolveLocation resolves a function name, file:line or address to code
+// addresses of a program of the session, by default its latest ELF program
+func (s *Service) ResolveLocation(ctx context.Context, sessionID, programID, location string) ([]uint64, error) {
+	table, _, err := s.Symbols(ctx, sessionID, programID)
+	if err != nil {
+		return nil, err
+	}
+	return table.Resolve(location)
+}
+
+// Frame returns the symbols of a program of the session together with the
+// innermost stack frame of the halted target, for reading variables
+func (s *Service) Frame(ctx context.Context, sessionID, programID string) (*symbols.Table, *symbols.Frame, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	table, _, err := s.Symbols(ctx, sessionID, programID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	regs, err := runtime.Adapter.ReadRegisters(ctx, runtime.InstanceID, "general")
+	if err != nil {
+		return nil, nil, err
+	}
+	frame, err := table.NewFrame(registerValues(regs), s.memory(ctx, runtime))
+	if err != nil {
+		return nil, nil, err
+	}
+	return table, frame, nil
+}
+
+// memory reads the memory of a session's target
+func (s *Service) memory(ctx context.Context, runtime *SessionRuntime) symbols.Memory {
+	return func(address uint64, size int) ([]byte, error) {
+		return runtime.Adapter.ReadMemory(ctx, runtime.InstanceID, address, uint32(size))
+	}
+}
+
+// registerValues converts the registers reported by an adapter to numbers
+func registerValues(regs map[string]interface{}) map[string]uint64 {
+	values := make(map[string]uint64, len(regs))
+	for name, value := range regs {
+		switch v := value.(type) {
+		case uint64:
+			values[name] = v
+		case uint32:
+			values[name] = uint64(v)
+		case int:
+			values[name] = uint64(v)
+		case int64:
+			values[name] = uint64(v)
+		case float64:
+			values[name] = uint64(v)
+		case string:
+			if parsed, err := strconv.ParseUint(strings.TrimSpace(v), 0, 64); err == nil {
+				values[name] = parsed
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/forfire912/virServer/pkg/rtos"
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// ErrThreadNotFound is returned for thread IDs the RTOS does not list
+var ErrThreadNotFound = errors.New("thread not found")
+
+// Threads lists the threads of the RTOS a program of the session runs, by
+// default its latest ELF program. The target should be halted.
+func (s *Service) Threads(ctx context.Context, sessionID, programID string) (*rtos.Threads, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	table, _, err := s.Symbols(ctx, sessionID, programID)
+	if err != nil {
+		return nil, err
+	}
+	isa, err := s.instructionSet(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	regs, err := runtime.Adapter.ReadRegisters(ctx, runtime.InstanceID, "general")
+	if err != nil {
+		return nil, err
+	}
+	return rtos.List(&rtos.Target{
+		Table:     table,
+		Memory:    s.memory(ctx, runtime),
+		Registers: registerValues(regs),
+		ISA:       isa,
+	})
+}
+
+// ThreadFrame is Frame for a thread of the RTOS: the innermost frame of a
+// thread that is switched out is built from the registers it saved
+func (s *Service) ThreadFrame(ctx context.Context, sessionID, programID string, threadID uint64) (*symbols.Table, *symbols.Frame, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	threads, err := s.Threads(ctx, sessionID, programID)
+	if err != nil {
+		return nil, nil, err
+	}
+	thread, ok := threads.Find(threadID)
+	if !ok {
+		return nil, nil, ErrThreadNotFound
+	}
+	if thread.Registers == nil {
+		if thread.Error != "" {
+			return nil, nil, fmt.Errorf("registers of thread %s: %s", thread.Name, thread.Error)
+		}
+		return nil, nil, fmt.Errorf("registers of thread %s cannot be read on this processor", thread.Name)
+	}
+	table, _, err := s.Symbols(ctx, sessionID, programID)
+	if err != nil {
+		return nil, nil, err
+	}
+	frame, err := table.NewFrame(thread.Registers, s.memory(ctx, runtime))
+	if err != nil {
+		return nil, nil, err
+	}
+	return table, frame, nil
+}
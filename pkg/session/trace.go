@@ -0,0 +1,246 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/trace"
+)
+
+// ArtifactTrace is the type of execution trace artifacts
+const ArtifactTrace = "trace"
+
+// Files of execution trace artifacts
+const (
+	TraceRecordsFile  = "trace.records"        // Execution records of the backend
+	TraceChromeFile   = "trace.json"           // Chrome Trace Event JSON
+	TracePerfettoFile = "trace.perfetto-trace" // Perfetto protobuf
+)
+
+// Limits of trace and profile jobs
+const (
+	defaultTraceTimeout = 60 * time.Second
+	maxTraceTimeout     = 10 * time.Minute
+)
+
+// TraceOptions are the options of a trace job
+type TraceOptions struct {
+	ProgramID  string        `json:"program_id"`            // Most recently uploaded ELF program when empty
+	Until      string        `json:"until,omitempty"`       // Location at which the run ends; at its first stop otherwise
+	TimeoutSec int           `json:"timeout_sec,omitempty"` // Time after which the run ends, default 60
+	Ranges     []trace.Range `json:"ranges,omitempty"`      // Functions kept, by address; all when empty
+	Start      uint64        `json:"start,omitempty"`       // Window kept, in instructions from the start of the run
+	End        uint64        `json:"end,omitempty"`         // 0 for the end of the run
+}
+
+// TraceMetadata is the metadata of trace artifacts
+type TraceMetadata struct {
+	JobID   string   `json:"job_id"`
+	BuildID string   `json:"build_id"`
+	Files   []string `json:"files"`
+}
+
+// TraceResult is the result of a trace job. The events are in the trace
+// artifact.
+type TraceResult struct {
+	ProgramID    string              `json:"program_id"`
+	BuildID      string              `json:"build_id"`
+	ArtifactID   string              `json:"artifact_id"`
+	Stop         *adapters.StopEvent `json:"stop,omitempty"` // Absent when the run ended at the timeout
+	Instructions uint64              `json:"instructions"`   // Executed in the run
+	Calls        int                 `json:"calls"`          // Function calls and exception handlers kept
+	Exceptions   int                 `json:"exceptions"`     // Exceptions kept
+	Truncated    bool                `json:"truncated,omitempty"`
+}
+
+// ArtifactURL returns the API path of the trace artifact
+func (r *TraceResult) ArtifactURL() string {
+	return "/api/v1/artifacts/" + r.ArtifactID
+}
+
+// TraceRunner runs trace jobs, which run an ELF program of a session once
+// from power on and reconstruct its function calls and exceptions from the
+// instructions it executed
+type TraceRunner struct {
+	sessions *Service
+}
+
+// NewTraceRunner creates the runner of trace jobs
+func NewTraceRunner(sessions *Service) *TraceRunner {
+	return &TraceRunner{sessions: sessions}
+}
+
+// Check validates the options of a trace job and that the backend traces
+// programs
+func (r *TraceRunner) Check(ctx context.Context, job *models.Job) error {
+	options, err := traceOptions(job)
+	if err != nil {
+		return err
+	}
+	return r.sessions.checkTracing(ctx, job.SessionID, options.ProgramID)
+}
+
+// Run executes a trace job. The session is powered off afterwards.
+func (r *TraceRunner) Run(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error) {
+	options, err := traceOptions(job)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.sessions.Trace(ctx, job.SessionID, job.ID, options, progress)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func traceOptions(job *models.Job) (*TraceOptions, error) {
+	options := &TraceOptions{}
+	if job.Options != "" {
+		if err := json.Unmarshal([]byte(job.Options), options); err != nil {
+			return nil, fmt.Errorf("invalid trace options: %w", err)
+		}
+	}
+	if err := checkTraceTimeout(options.TimeoutSec); err != nil {
+		return nil, err
+	}
+	for _, r := range options.Ranges {
+		if r.End <= r.Start {
+			return nil, fmt.Errorf("range %#x-%#x is empty", r.Start, r.End)
+		}
+	}
+	if options.End != 0 && options.End <= options.Start {
+		return nil, fmt.Errorf("end must be after start")
+	}
+	return options, nil
+}
+
+// Trace runs an ELF program with tracing until it stops or the timeout
+// expires, and saves its calls and exceptions as an artifact of the session
+func (s *Service) Trace(ctx context.Context, sessionID, jobID string, options *TraceOptions, progress func(int)) (*TraceResult, error) {
+	table, program, err := s.Symbols(ctx, sessionID, options.ProgramID)
+	if err != nil {
+		return nil, err
+	}
+	stop, records, err := s.runTraced(ctx, sessionID, program.ID, options.Until, options.TimeoutSec)
+	if err != nil {
+		return nil, err
+	}
+	progress(50)
+	builder := trace.NewBuilder(table, &trace.Options{Ranges: options.Ranges, Start: options.Start, End: options.End})
+	if err := scanRecords(records, builder.Add); err != nil {
+		return nil, err
+	}
+	execution := builder.Trace()
+	progress(75)
+
+	artifact := &models.Artifact{SessionID: sessionID, ProgramID: program.ID, Type: ArtifactTrace, BuildID: table.BuildID}
+	metadata := &TraceMetadata{JobID: jobID, BuildID: table.BuildID, Files: []string{TraceRecordsFile, TraceChromeFile, TracePerfettoFile}}
+	err = s.saveArtifact(ctx, artifact, s.traceDir, metadata, map[string]func(io.Writer) error{
+		TraceRecordsFile:  func(w io.Writer) error { return copyFile(w, records) },
+		TraceChromeFile:   func(w io.Writer) error { return trace.WriteChrome(w, program.Name, execution) },
+		TracePerfettoFile: func(w io.Writer) error { return trace.WritePerfetto(w, program.Name, execution) },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TraceResult{
+		ProgramID:    program.ID,
+		BuildID:      table.BuildID,
+		ArtifactID:   artifact.ID,
+		Instructions: execution.Instructions,
+		Calls:        len(execution.Slices),
+		Exceptions:   len(execution.Instants),
+		Truncated:    execution.Truncated,
+	}
+	if stop.Reason != adapters.StopRunning {
+		result.Stop = stop
+	}
+	progress(100)
+	return result, nil
+}
+
+// checkTracing validates that the backend of a session traces programs and
+// that the program is an ELF file
+func (s *Service) checkTracing(ctx context.Context, sessionID, programID string) error {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return err
+	}
+	if !runtime.Adapter.GetCapabilities().Features["trace"] {
+		return fmt.Errorf("backend %s does not support tracing", runtime.Session.Backend)
+	}
+	_, _, err = s.Symbols(ctx, sessionID, programID)
+	return err
+}
+
+func checkTraceTimeout(timeoutSec int) error {
+	if timeoutSec < 0 || time.Duration(timeoutSec)*time.Second > maxTraceTimeout {
+		return fmt.Errorf("timeout_sec must be at most %d", int(maxTraceTimeout.Seconds()))
+	}
+	return nil
+}
+
+// runTraced runs a program with tracing from power on until it stops at
+// until, or the timeout expires, and returns the path of its execution
+// records. The session is powered off afterwards.
+func (s *Service) runTraced(ctx context.Context, sessionID, programID, until string, timeoutSec int) (*adapters.StopEvent, string, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	timeout := defaultTraceTimeout
+	if timeoutSec > 0 {
+		timeout = time.Duration(timeoutSec) * time.Second
+	}
+
+	if until != "" {
+		remove, err := s.setUntil(ctx, sessionID, until)
+		if err != nil {
+			return nil, "", err
+		}
+		defer remove()
+	}
+
+	defer s.leavePoweredOff(runtime)
+	stop, err := s.runProgram(ctx, runtime, programID, &adapters.StartOptions{EnableTrace: true}, timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	// The backend has logged all instructions once it exited
+	if err := s.PowerControl(ctx, sessionID, "off"); err != nil {
+		return nil, "", err
+	}
+	path, err := runtime.Adapter.ExportTrace(ctx, runtime.InstanceID)
+	if err != nil {
+		return nil, "", fmt.Errorf("export trace: %w", err)
+	}
+	return stop, path, nil
+}
+
+// scanRecords passes the execution records in the file at path to fn,
+// without reading them all into memory
+func scanRecords(path string, fn func(trace.Record) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read trace: %w", err)
+	}
+	defer f.Close()
+	return trace.ScanRecords(f, fn)
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,125 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/forfire912/virServer/pkg/trace"
+)
+
+// traceAdapter runs the symbols fixture with tracing: _start calls average,
+// which calls scale and is interrupted by an exception it handles
+type traceAdapter struct {
+	*coverageAdapter
+
+	trace bool
+}
+
+func (a *traceAdapter) GetCapabilities() *adapters.BackendCapabilities {
+	return &adapters.BackendCapabilities{Features: map[string]bool{"trace": true}}
+}
+
+func (a *traceAdapter) StartProgram(ctx context.Context, instanceID, programID string, options *adapters.StartOptions) error {
+	a.trace = options.EnableTrace
+	return nil
+}
+
+func (a *traceAdapter) ExportTrace(ctx context.Context, instanceID string) (string, error) {
+	if a.on || !a.trace {
+		return "", os.ErrNotExist
+	}
+	path := filepath.Join(a.dir, "trace.records")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return path, trace.WriteRecords(f, []trace.Record{
+		{Kind: trace.BlockRecord, PC: 0x401070, Instructions: 3},
+		{Kind: trace.BlockRecord, PC: 0x401019, Instructions: 4},
+		{Kind: trace.BlockRecord, PC: 0x401000, Instructions: 5},
+		{Kind: trace.BlockRecord, PC: 0x401029, Instructions: 2},
+		{Kind: trace.ExceptionRecord, Number: 15, Name: "IRQ"},
+		{Kind: trace.BlockRecord, PC: 0x401030, Instructions: 2},
+		{Kind: trace.BlockRecord, PC: 0x401080, Instructions: 1},
+	})
+}
+
+func TestTrace(t *testing.T) {
+	s, ctx := newCoverageTest(t)
+	runtime := s.sessions["session-1"]
+	adapter := &traceAdapter{coverageAdapter: runtime.Adapter.(*coverageAdapter)}
+	runtime.Adapter = adapter
+	runner := NewTraceRunner(s)
+
+	j := &models.Job{ID: "job-1", SessionID: "session-1"}
+	if err := runner.Check(ctx, j); err == nil {
+		t.Error("job accepted without an ELF program")
+	}
+	uploadFixture(t, s, ctx)
+	if err := runner.Check(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	for _, options := range []string{`{"ranges":[{"start":16,"end":16}]}`, `{"start":10,"end":5}`, `{"timeout_sec":-1}`} {
+		if err := runner.Check(ctx, &models.Job{SessionID: "session-1", Options: options}); err == nil {
+			t.Errorf("options %s accepted", options)
+		}
+	}
+
+	value, err := runner.Run(ctx, j, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := value.(*TraceResult)
+	if result.Instructions != 17 || result.Calls != 4 || result.Exceptions != 1 || adapter.on {
+		t.Fatalf("unexpected result %+v, powered %v", result, adapter.on)
+	}
+	if result.Stop == nil || result.Stop.Reason != adapters.StopExited {
+		t.Errorf("stop %+v", result.Stop)
+	}
+
+	path, err := s.ArtifactFile(ctx, result.ArtifactID, TraceChromeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chrome struct {
+		TraceEvents []struct {
+			Name  string `json:"name"`
+			Phase string `json:"ph"`
+		} `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(data, &chrome); err != nil || len(chrome.TraceEvents) != 7 {
+		t.Errorf("Chrome trace %s, %v", data, err)
+	}
+	for _, name := range []string{TraceRecordsFile, TracePerfettoFile} {
+		if _, err := s.ArtifactFile(ctx, result.ArtifactID, name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// A window and a range keep the call of scale only
+	value, err = runner.Run(ctx, &models.Job{ID: "job-2", SessionID: "session-1", Options: `{"ranges":[{"start":4198400,"end":4198425}],"start":5}`}, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := value.(*TraceResult); result.Calls != 1 || result.Exceptions != 1 {
+		t.Errorf("filtered result %+v", result)
+	}
+
+	var artifact models.Artifact
+	if err := s.db.First(&artifact, "id = ?", result.ArtifactID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if artifact.Type != ArtifactTrace || artifact.BuildID != result.BuildID || artifact.BuildID == "" {
+		t.Errorf("artifact %+v", artifact)
+	}
+}
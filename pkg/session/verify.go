@@ -0,0 +1,303 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+)
+
+// Limits of verify jobs
+const (
+	defaultVerifyRuns    = 3
+	maxVerifyRuns        = 10
+	defaultVerifyTimeout = 60 * time.Second
+	maxVerifyTimeout     = 10 * time.Minute
+	maxDivergenceExcerpt = 120
+)
+
+// VerifyOptions are the options of a verify job
+type VerifyOptions struct {
+	ProgramID  string `json:"program_id"`            // Most recently uploaded program when empty
+	Runs       int    `json:"runs,omitempty"`        // 2 to 10, default 3
+	Until      string `json:"until,omitempty"`       // Location at which runs end; at their first stop otherwise
+	TimeoutSec int    `json:"timeout_sec,omitempty"` // For each run to stop, default 60
+}
+
+// VerifyRun is the outcome of one run of a verify job
+type VerifyRun struct {
+	Run              int                 `json:"run"` // From 1
+	Stop             *adapters.StopEvent `json:"stop"`
+	Registers        map[string]uint64   `json:"registers,omitempty"`
+	RegisterError    string              `json:"register_error,omitempty"`
+	Instructions     *uint64             `json:"instructions,omitempty"`
+	InstructionError string              `json:"instruction_error,omitempty"`
+	ConsoleBytes     int                 `json:"console_bytes"`
+	ConsoleSHA256    string              `json:"console_sha256"`
+
+	console []byte
+}
+
+// Divergence is where a run first differs from the first run
+type Divergence struct {
+	Run      int    `json:"run"`
+	Field    string `json:"field"`              // console, stop, instructions or registers
+	Offset   int    `json:"offset,omitempty"`   // First differing byte of the console output
+	Line     int    `json:"line,omitempty"`     // Console line of that byte, from 1
+	Register string `json:"register,omitempty"` // First differing register, by name
+	Expected string `json:"expected"`           // Value of the first run
+	Actual   string `json:"actual"`
+}
+
+// VerifyReport is the result of a verify job
+type VerifyReport struct {
+	ProgramID     string       `json:"program_id"`
+	Deterministic bool         `json:"deterministic"` // The session runs in deterministic mode
+	Reproducible  bool         `json:"reproducible"`
+	Runs          []VerifyRun  `json:"runs"`
+	Divergences   []Divergence `json:"divergences,omitempty"` // The first of each diverging run
+}
+
+// VerifyRunner runs verify jobs, which run a program of a session several
+// times from power on and compare the console output, the stop, the
+// registers and the instruction count of the runs
+type VerifyRunner struct {
+	sessions *Service
+}
+
+// NewVerifyRunner creates the runner of verify jobs
+func NewVerifyRunner(sessions *Service) *VerifyRunner {
+	return &VerifyRunner{sessions: sessions}
+}
+
+// Check validates the options of a verify job
+func (r *VerifyRunner) Check(ctx context.Context, job *models.Job) error {
+	if _, err := r.sessions.runtime(job.SessionID); err != nil {
+		return err
+	}
+	options, err := verifyOptions(job)
+	if err != nil {
+		return err
+	}
+	if options.ProgramID != "" {
+		_, err = r.sessions.GetProgram(ctx, job.SessionID, options.ProgramID)
+	} else {
+		_, err = r.sessions.latestProgram(ctx, job.SessionID)
+	}
+	return err
+}
+
+// Run executes a verify job. The session is powered off afterwards.
+func (r *VerifyRunner) Run(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error) {
+	options, err := verifyOptions(job)
+	if err != nil {
+		return nil, err
+	}
+	return r.sessions.Verify(ctx, job.SessionID, options, progress)
+}
+
+func verifyOptions(job *models.Job) (*VerifyOptions, error) {
+	options := &VerifyOptions{}
+	if job.Options != "" {
+		if err := json.Unmarshal([]byte(job.Options), options); err != nil {
+			return nil, fmt.Errorf("invalid verify options: %w", err)
+		}
+	}
+	if options.Runs == 0 {
+		options.Runs = defaultVerifyRuns
+	}
+	if options.Runs < 2 || options.Runs > maxVerifyRuns {
+		return nil, fmt.Errorf("runs must be between 2 and %d", maxVerifyRuns)
+	}
+	if options.TimeoutSec < 0 || time.Duration(options.TimeoutSec)*time.Second > maxVerifyTimeout {
+		return nil, fmt.Errorf("timeout_sec must be at most %d", int(maxVerifyTimeout.Seconds()))
+	}
+	return options, nil
+}
+
+// Verify runs a program several times and compares the runs with the first
+func (s *Service) Verify(ctx context.Context, sessionID string, options *VerifyOptions, progress func(int)) (*VerifyReport, error) {
+	runtime, err := s.runtime(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	programID := options.ProgramID
+	if programID == "" {
+		program, err := s.latestProgram(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		programID = program.ID
+	}
+	timeout := defaultVerifyTimeout
+	if options.TimeoutSec > 0 {
+		timeout = time.Duration(options.TimeoutSec) * time.Second
+	}
+
+	if options.Until != "" {
+		remove, err := s.setUntil(ctx, sessionID, options.Until)
+		if err != nil {
+			return nil, err
+		}
+		defer remove()
+	}
+
+	report := &VerifyReport{ProgramID: programID, Deterministic: runtime.Session.Deterministic}
+	for i := 1; i <= options.Runs; i++ {
+		run, err := s.verifyRun(ctx, runtime, programID, timeout)
+		if err != nil {
+			return report, fmt.Errorf("run %d: %w", i, err)
+		}
+		run.Run = i
+		report.Runs = append(report.Runs, *run)
+		if i > 1 {
+			if divergence := diverge(&report.Runs[0], run); divergence != nil {
+				report.Divergences = append(report.Divergences, *divergence)
+			}
+		}
+		progress(100 * i / options.Runs)
+	}
+	report.Reproducible = len(report.Divergences) == 0
+	return report, nil
+}
+
+// verifyRun runs the program from power on until it stops and powers the
+// session off again
+func (s *Service) verifyRun(ctx context.Context, runtime *SessionRuntime, programID string, timeout time.Duration) (*VerifyRun, error) {
+	defer s.leavePoweredOff(runtime)
+	stop, err := s.runProgram(ctx, runtime, programID, &adapters.StartOptions{}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if stop.Reason == adapters.StopRunning {
+		return nil, fmt.Errorf("the program did not stop within %s", timeout)
+	}
+
+	run := &VerifyRun{Stop: stop}
+	if stop.Reason != adapters.StopExited {
+		regs, err := runtime.Adapter.ReadRegisters(ctx, runtime.InstanceID, "general")
+		if err != nil {
+			run.RegisterError = err.Error()
+		} else {
+			run.Registers = registerValues(regs)
+		}
+	}
+	if err := s.PowerControl(ctx, runtime.Session.ID, "off"); err != nil {
+		return nil, err
+	}
+
+	// Both are complete once the backend has shut down
+	run.console, err = runtime.Adapter.ReadConsoleLog(ctx, runtime.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("read console output: %w", err)
+	}
+	sum := sha256.Sum256(run.console)
+	run.ConsoleBytes = len(run.console)
+	run.ConsoleSHA256 = hex.EncodeToString(sum[:])
+	if count, err := runtime.Adapter.InstructionCount(ctx, runtime.InstanceID); err != nil {
+		run.InstructionError = err.Error()
+	} else {
+		run.Instructions = &count
+	}
+	return run, nil
+}
+
+// diverge returns where run first differs from the reference run: in the
+// console output, which is the earliest observable point, then at the stop
+// and in the final state
+func diverge(reference, run *VerifyRun) *Divergence {
+	if !bytes.Equal(reference.console, run.console) {
+		return consoleDivergence(run.Run, reference.console, run.console)
+	}
+	if stopText(reference.Stop) != stopText(run.Stop) {
+		return &Divergence{Run: run.Run, Field: "stop", Expected: stopText(reference.Stop), Actual: stopText(run.Stop)}
+	}
+	if reference.Instructions != nil && run.Instructions != nil && *reference.Instructions != *run.Instructions {
+		return &Divergence{
+			Run:      run.Run,
+			Field:    "instructions",
+			Expected: fmt.Sprint(*reference.Instructions),
+			Actual:   fmt.Sprint(*run.Instructions),
+		}
+	}
+	return registerDivergence(run.Run, reference.Registers, run.Registers)
+}
+
+// consoleDivergence locates the first differing byte of two outputs and
+// quotes the line it is on in both
+func consoleDivergence(run int, expected, actual []byte) *Divergence {
+	offset := 0
+	for offset < len(expected) && offset < len(actual) && expected[offset] == actual[offset] {
+		offset++
+	}
+	start := bytes.LastIndexByte(expected[:offset], '\n') + 1
+	return &Divergence{
+		Run:      run,
+		Field:    "console",
+		Offset:   offset,
+		Line:     bytes.Count(expected[:offset], []byte("\n")) + 1,
+		Expected: excerpt(expected[start:]),
+		Actual:   excerpt(actual[start:]),
+	}
+}
+
+// excerpt returns the first line of data, shortened
+func excerpt(data []byte) string {
+	if end := bytes.IndexByte(data, '\n'); end >= 0 {
+		data = data[:end]
+	}
+	if len(data) > maxDivergenceExcerpt {
+		data = data[:maxDivergenceExcerpt]
+	}
+	return string(data)
+}
+
+// registerDivergence returns the first register, by name, whose values
+// differ. Registers that could not be read are not compared.
+func registerDivergence(run int, expected, actual map[string]uint64) *Divergence {
+	if expected == nil || actual == nil {
+		return nil
+	}
+	names := make(map[string]bool)
+	for name := range expected {
+		names[name] = true
+	}
+	for name := range actual {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		want, inExpected := expected[name]
+		got, inActual := actual[name]
+		if want != got || inExpected != inActual {
+			return &Divergence{Run: run, Field: "registers", Register: name, Expected: registerText(want, inExpected), Actual: registerText(got, inActual)}
+		}
+	}
+	return nil
+}
+
+func registerText(value uint64, ok bool) string {
+	if !ok {
+		return "missing"
+	}
+	return fmt.Sprintf("%#x", value)
+}
+
+func stopText(stop *adapters.StopEvent) string {
+	text := fmt.Sprintf("%s at %#x", stop.Reason, stop.PC)
+	if stop.Signal != 0 {
+		text += fmt.Sprintf(", signal %d", stop.Signal)
+	}
+	return text
+}
@@ -0,0 +1,145 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// verifyAdapter runs a program that prints the number of its run from the
+// third run on
+type verifyAdapter struct {
+	adapters.BackendAdapter
+
+	runs int
+	on   bool
+}
+
+func (a *verifyAdapter) PowerOn(ctx context.Context, instanceID string) error {
+	a.on = true
+	return nil
+}
+
+func (a *verifyAdapter) PowerOff(ctx context.Context, instanceID string) error {
+	a.on = false
+	return nil
+}
+
+func (a *verifyAdapter) StartProgram(ctx context.Context, instanceID, programID string, options *adapters.StartOptions) error {
+	if !a.on {
+		return fmt.Errorf("powered off")
+	}
+	a.runs++
+	return nil
+}
+
+func (a *verifyAdapter) Continue(ctx context.Context, instanceID string) (*adapters.StopEvent, error) {
+	return &adapters.StopEvent{Reason: adapters.StopBreakpoint, PC: 0x08000200}, nil
+}
+
+func (a *verifyAdapter) ReadRegisters(ctx context.Context, instanceID string, scope string) (map[string]interface{}, error) {
+	return map[string]interface{}{"r0": uint64(1), "pc": uint64(0x08000200)}, nil
+}
+
+func (a *verifyAdapter) ReadConsoleLog(ctx context.Context, instanceID string) ([]byte, error) {
+	if a.on {
+		return nil, fmt.Errorf("read while running")
+	}
+	if a.runs >= 3 {
+		return []byte(fmt.Sprintf("boot\nrun %d\n", a.runs)), nil
+	}
+	return []byte("boot\nready\n"), nil
+}
+
+func (a *verifyAdapter) InstructionCount(ctx context.Context, instanceID string) (uint64, error) {
+	return 1000, nil
+}
+
+func TestVerify(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Session{}, &models.Program{}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db, nil, t.TempDir())
+	adapter := &verifyAdapter{}
+	session := &models.Session{ID: "session-1", Deterministic: true}
+	if err := db.Create(session).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.sessions[session.ID] = &SessionRuntime{
+		Session:     session,
+		Adapter:     adapter,
+		Breakpoints: newBreakpointRegistry(),
+		Events:      newEventLog(),
+		stops:       newStopFilter(),
+	}
+	ctx := context.Background()
+	runner := NewVerifyRunner(s)
+
+	job := &models.Job{SessionID: "session-1", Options: `{"runs":4}`}
+	if err := runner.Check(ctx, job); err == nil {
+		t.Error("job accepted without a program")
+	}
+	if _, err := s.UploadProgram(ctx, "session-1", ProgramUpload{Name: "fw.bin"}, bytes.NewReader([]byte("firmware"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Check(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Check(ctx, &models.Job{SessionID: "session-1", Options: `{"runs":1}`}); err == nil {
+		t.Error("job accepted with one run")
+	}
+
+	var progress []int
+	result, err := runner.Run(ctx, job, func(percent int) { progress = append(progress, percent) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.(*VerifyReport)
+	if len(report.Runs) != 4 || report.Reproducible || !report.Deterministic || adapter.on {
+		t.Fatalf("unexpected report %+v, powered %v", report, adapter.on)
+	}
+	if first := report.Runs[0]; first.Registers["r0"] != 1 || *first.Instructions != 1000 || first.ConsoleBytes != 11 {
+		t.Errorf("unexpected first run %+v", first)
+	}
+	want := []Divergence{
+		{Run: 3, Field: "console", Offset: 6, Line: 2, Expected: "ready", Actual: "run 3"},
+		{Run: 4, Field: "console", Offset: 6, Line: 2, Expected: "ready", Actual: "run 4"},
+	}
+	if fmt.Sprint(report.Divergences) != fmt.Sprint(want) {
+		t.Errorf("divergences %+v, want %+v", report.Divergences, want)
+	}
+	if fmt.Sprint(progress) != "[25 50 75 100]" {
+		t.Errorf("progress %v", progress)
+	}
+}
+
+func TestDiverge(t *testing.T) {
+	count := uint64(10)
+	other := uint64(11)
+	stop := &adapters.StopEvent{Reason: adapters.StopExited}
+	reference := &VerifyRun{Stop: stop, Instructions: &count, Registers: map[string]uint64{"r0": 1, "r1": 2}}
+
+	if d := diverge(reference, &VerifyRun{Run: 2, Stop: stop, Instructions: &count, Registers: map[string]uint64{"r0": 1, "r1": 2}}); d != nil {
+		t.Errorf("identical runs diverge at %+v", d)
+	}
+	if d := diverge(reference, &VerifyRun{Run: 2, Stop: &adapters.StopEvent{Reason: adapters.StopBreakpoint, PC: 4}}); d == nil || d.Field != "stop" {
+		t.Errorf("stop divergence %+v", d)
+	}
+	if d := diverge(reference, &VerifyRun{Run: 2, Stop: stop, Instructions: &other}); d == nil || d.Field != "instructions" || d.Actual != "11" {
+		t.Errorf("instruction divergence %+v", d)
+	}
+	d := diverge(reference, &VerifyRun{Run: 2, Stop: stop, Registers: map[string]uint64{"r0": 1, "r1": 3}})
+	if d == nil || d.Field != "registers" || d.Register != "r1" || d.Expected != "0x2" || d.Actual != "0x3" {
+		t.Errorf("register divergence %+v", d)
+	}
+}
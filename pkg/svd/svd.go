@@ -0,0 +1,816 @@
+// Package svd parses CMSIS-SVD System View Description files into a flat
+// model of peripherals, registers and fields. Inheritance (derivedFrom),
+// register properties inherited from enclosing elements, clusters and dim
+// arrays are resolved during parsing.
+package svd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Access types of registers and fields
+const (
+	ReadOnly      = "read-only"
+	WriteOnly     = "write-only"
+	ReadWrite     = "read-write"
+	WriteOnce     = "writeOnce"
+	ReadWriteOnce = "read-writeOnce"
+)
+
+// maxDim bounds the elements of a dim array
+const maxDim = 4096
+
+// Device is a parsed SVD device
+type Device struct {
+	Name            string       `json:"name"`
+	Vendor          string       `json:"vendor,omitempty"`
+	Version         string       `json:"version,omitempty"`
+	Description     string       `json:"description,omitempty"`
+	CPU             *CPU         `json:"cpu,omitempty"`
+	AddressUnitBits int          `json:"address_unit_bits"`
+	Width           int          `json:"width"`
+	Peripherals     []Peripheral `json:"peripherals"`
+}
+
+// CPU describes the processor core of a device
+type CPU struct {
+	Name         string `json:"name"`
+	Revision     string `json:"revision,omitempty"`
+	Endian       string `json:"endian,omitempty"`
+	MPUPresent   bool   `json:"mpu_present"`
+	FPUPresent   bool   `json:"fpu_present"`
+	NVICPrioBits int    `json:"nvic_prio_bits,omitempty"`
+}
+
+// Peripheral is a memory mapped peripheral instance
+type Peripheral struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	GroupName   string      `json:"group_name,omitempty"`
+	BaseAddress uint64      `json:"base_address"`
+	Size        uint64      `json:"size,omitempty"` // Extent of the address blocks
+	Interrupts  []Interrupt `json:"interrupts,omitempty"`
+	Registers   []Register  `json:"registers"`
+}
+
+// Interrupt is an interrupt line of a peripheral
+type Interrupt struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Value       int    `json:"value"`
+}
+
+// Register is a register with its inherited properties resolved
+type Register struct {
+	Name          string  `json:"name"`
+	Description   string  `json:"description,omitempty"`
+	AddressOffset uint64  `json:"address_offset"`
+	Size          int     `json:"size"`
+	Access        string  `json:"access,omitempty"`
+	ResetValue    uint64  `json:"reset_value"`
+	ResetMask     uint64  `json:"reset_mask"`
+	ReadAction    string  `json:"read_action,omitempty"` // Side effect of reads, e.g. "clear"
+	Fields        []Field `json:"fields,omitempty"`
+}
+
+// Field is a bit field of a register
+type Field struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	BitOffset   int               `json:"bit_offset"`
+	BitWidth    int               `json:"bit_width"`
+	Access      string            `json:"access,omitempty"`
+	ReadAction  string            `json:"read_action,omitempty"`
+	Values      []EnumeratedValue `json:"values,omitempty"`
+}
+
+// EnumeratedValue names a value of a field
+type EnumeratedValue struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Value       uint64 `json:"value"`
+}
+
+// XML document structure
+
+type xmlRegisterProperties struct {
+	Size       string `xml:"size"`
+	Access     string `xml:"access"`
+	ResetValue string `xml:"resetValue"`
+	ResetMask  string `xml:"resetMask"`
+}
+
+type xmlDim struct {
+	Dim          string `xml:"dim"`
+	DimIncrement string `xml:"dimIncrement"`
+	DimIndex     string `xml:"dimIndex"`
+}
+
+type xmlDevice struct {
+	Name            string `xml:"name"`
+	Vendor          string `xml:"vendor"`
+	Version         string `xml:"version"`
+	Description     string `xml:"description"`
+	AddressUnitBits string `xml:"addressUnitBits"`
+	Width           string `xml:"width"`
+	CPU             *struct {
+		Name         string `xml:"name"`
+		Revision     string `xml:"revision"`
+		Endian       string `xml:"endian"`
+		MPUPresent   string `xml:"mpuPresent"`
+		FPUPresent   string `xml:"fpuPresent"`
+		NVICPrioBits string `xml:"nvicPrioBits"`
+	} `xml:"cpu"`
+	xmlRegisterProperties
+	Peripherals []xmlPeripheral `xml:"peripherals>peripheral"`
+}
+
+type xmlPeripheral struct {
+	DerivedFrom string `xml:"derivedFrom,attr"`
+	xmlDim
+	Name          string `xml:"name"`
+	Description   string `xml:"description"`
+	GroupName     string `xml:"groupName"`
+	PrependToName string `xml:"prependToName"`
+	AppendToName  string `xml:"appendToName"`
+	BaseAddress   string `xml:"baseAddress"`
+	xmlRegisterProperties
+	AddressBlocks []struct {
+		Offset string `xml:"offset"`
+		Size   string `xml:"size"`
+	} `xml:"addressBlock"`
+	Interrupts []struct {
+		Name        string `xml:"name"`
+		Description string `xml:"description"`
+		Value       string `xml:"value"`
+	} `xml:"interrupt"`
+	Registers *xmlRegisterBlock `xml:"registers"`
+}
+
+// xmlRegisterBlock holds registers and clusters in document order
+type xmlRegisterBlock struct {
+	Items []xmlRegisterItem
+}
+
+// xmlRegisterItem is either a register or a cluster
+type xmlRegisterItem struct {
+	Register *xmlRegister
+	Cluster  *xmlCluster
+}
+
+func (b *xmlRegisterBlock) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "register":
+				var reg xmlRegister
+				if err := d.DecodeElement(&reg, &t); err != nil {
+					return err
+				}
+				b.Items = append(b.Items, xmlRegisterItem{Register: &reg})
+			case "cluster":
+				var cluster xmlCluster
+				if err := d.DecodeElement(&cluster, &t); err != nil {
+					return err
+				}
+				b.Items = append(b.Items, xmlRegisterItem{Cluster: &cluster})
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type xmlCluster struct {
+	DerivedFrom string `xml:"derivedFrom,attr"`
+	xmlDim
+	Name          string `xml:"name"`
+	Description   string `xml:"description"`
+	AddressOffset string `xml:"addressOffset"`
+	xmlRegisterProperties
+	xmlRegisterBlock
+}
+
+func (c *xmlCluster) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "derivedFrom" {
+			c.DerivedFrom = attr.Value
+		}
+	}
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var target *string
+			switch t.Name.Local {
+			case "register":
+				var reg xmlRegister
+				if err := d.DecodeElement(&reg, &t); err != nil {
+					return err
+				}
+				c.Items = append(c.Items, xmlRegisterItem{Register: &reg})
+				continue
+			case "cluster":
+				var cluster xmlCluster
+				if err := d.DecodeElement(&cluster, &t); err != nil {
+					return err
+				}
+				c.Items = append(c.Items, xmlRegisterItem{Cluster: &cluster})
+				continue
+			case "dim":
+				target = &c.Dim
+			case "dimIncrement":
+				target = &c.DimIncrement
+			case "dimIndex":
+				target = &c.DimIndex
+			case "name":
+				target = &c.Name
+			case "description":
+				target = &c.Description
+			case "addressOffset":
+				target = &c.AddressOffset
+			case "size":
+				target = &c.Size
+			case "access":
+				target = &c.Access
+			case "resetValue":
+				target = &c.ResetValue
+			case "resetMask":
+				target = &c.ResetMask
+			}
+			if target == nil {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.DecodeElement(target, &t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type xmlRegister struct {
+	DerivedFrom string `xml:"derivedFrom,attr"`
+	xmlDim
+	Name          string `xml:"name"`
+	Description   string `xml:"description"`
+	AddressOffset string `xml:"addressOffset"`
+	ReadAction    string `xml:"readAction"`
+	xmlRegisterProperties
+	Fields []xmlField `xml:"fields>field"`
+}
+
+type xmlField struct {
+	DerivedFrom string `xml:"derivedFrom,attr"`
+	xmlDim
+	Name             string `xml:"name"`
+	Description      string `xml:"description"`
+	BitOffset        string `xml:"bitOffset"`
+	BitWidth         string `xml:"bitWidth"`
+	LSB              string `xml:"lsb"`
+	MSB              string `xml:"msb"`
+	BitRange         string `xml:"bitRange"`
+	Access           string `xml:"access"`
+	ReadAction       string `xml:"readAction"`
+	EnumeratedValues []struct {
+		Values []struct {
+			Name        string `xml:"name"`
+			Description string `xml:"description"`
+			Value       string `xml:"value"`
+			IsDefault   string `xml:"isDefault"`
+		} `xml:"enumeratedValue"`
+	} `xml:"enumeratedValues"`
+}
+
+// Parse reads an SVD document
+func Parse(r io.Reader) (*Device, error) {
+	var doc xmlDevice
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid SVD document: %w", err)
+	}
+	if doc.Name == "" {
+		return nil, fmt.Errorf("invalid SVD document: device name missing")
+	}
+
+	device := &Device{
+		Name:            doc.Name,
+		Vendor:          strings.TrimSpace(doc.Vendor),
+		Version:         strings.TrimSpace(doc.Version),
+		Description:     cleanText(doc.Description),
+		AddressUnitBits: 8,
+		Width:           32,
+	}
+	if value, err := parseInt(doc.AddressUnitBits); err == nil && value > 0 {
+		device.AddressUnitBits = int(value)
+	}
+	if value, err := parseInt(doc.Width); err == nil && value > 0 {
+		device.Width = int(value)
+	}
+	if doc.CPU != nil {
+		prioBits, _ := parseInt(doc.CPU.NVICPrioBits)
+		device.CPU = &CPU{
+			Name:         strings.TrimSpace(doc.CPU.Name),
+			Revision:     strings.TrimSpace(doc.CPU.Revision),
+			Endian:       strings.TrimSpace(doc.CPU.Endian),
+			MPUPresent:   parseBool(doc.CPU.MPUPresent),
+			FPUPresent:   parseBool(doc.CPU.FPUPresent),
+			NVICPrioBits: int(prioBits),
+		}
+	}
+
+	defaults := properties{size: 32, access: ReadWrite, resetMask: ^uint64(0)}
+	defaults, err := defaults.inherit(doc.xmlRegisterProperties)
+	if err != nil {
+		return nil, fmt.Errorf("device %s: %w", doc.Name, err)
+	}
+
+	byName := make(map[string]*xmlPeripheral, len(doc.Peripherals))
+	for i := range doc.Peripherals {
+		byName[doc.Peripherals[i].Name] = &doc.Peripherals[i]
+	}
+
+	for i := range doc.Peripherals {
+		xp := doc.Peripherals[i]
+		if xp.DerivedFrom != "" {
+			base, ok := byName[xp.DerivedFrom]
+			if !ok {
+				return nil, fmt.Errorf("peripheral %s: derivedFrom unknown peripheral %s", xp.Name, xp.DerivedFrom)
+			}
+			xp = derivePeripheral(xp, *base)
+		}
+
+		peripherals, err := convertPeripheral(xp, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("peripheral %s: %w", xp.Name, err)
+		}
+		device.Peripherals = append(device.Peripherals, peripherals...)
+	}
+
+	sort.SliceStable(device.Peripherals, func(i, j int) bool {
+		return device.Peripherals[i].BaseAddress < device.Peripherals[j].BaseAddress
+	})
+	return device, nil
+}
+
+// derivePeripheral copies the unset elements of a derived peripheral from its base
+func derivePeripheral(p, base xmlPeripheral) xmlPeripheral {
+	if p.Description == "" {
+		p.Description = base.Description
+	}
+	if p.GroupName == "" {
+		p.GroupName = base.GroupName
+	}
+	if p.PrependToName == "" {
+		p.PrependToName = base.PrependToName
+	}
+	if p.AppendToName == "" {
+		p.AppendToName = base.AppendToName
+	}
+	if p.Size == "" {
+		p.Size = base.Size
+	}
+	if p.Access == "" {
+		p.Access = base.Access
+	}
+	if p.ResetValue == "" {
+		p.ResetValue = base.ResetValue
+	}
+	if p.ResetMask == "" {
+		p.ResetMask = base.ResetMask
+	}
+	if len(p.AddressBlocks) == 0 {
+		p.AddressBlocks = base.AddressBlocks
+	}
+	if p.Registers == nil {
+		p.Registers = base.Registers
+	}
+	return p
+}
+
+// properties are the register properties inherited down the hierarchy
+type properties struct {
+	size       int
+	access     string
+	resetValue uint64
+	resetMask  uint64
+}
+
+func (p properties) inherit(x xmlRegisterProperties) (properties, error) {
+	if x.Size != "" {
+		size, err := parseInt(x.Size)
+		if err != nil || size > 64 {
+			return p, fmt.Errorf("invalid size %q: registers have at most 64 bits", x.Size)
+		}
+		p.size = int(size)
+	}
+	if x.Access != "" {
+		p.access = strings.TrimSpace(x.Access)
+	}
+	if x.ResetValue != "" {
+		value, err := parseInt(x.ResetValue)
+		if err != nil {
+			return p, fmt.Errorf("invalid resetValue %q", x.ResetValue)
+		}
+		p.resetValue = value
+	}
+	if x.ResetMask != "" {
+		value, err := parseInt(x.ResetMask)
+		if err != nil {
+			return p, fmt.Errorf("invalid resetMask %q", x.ResetMask)
+		}
+		p.resetMask = value
+	}
+	return p, nil
+}
+
+func convertPeripheral(xp xmlPeripheral, defaults properties) ([]Peripheral, error) {
+	base, err := parseInt(xp.BaseAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid baseAddress %q", xp.BaseAddress)
+	}
+	props, err := defaults.inherit(xp.xmlRegisterProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	var size uint64
+	for _, block := range xp.AddressBlocks {
+		offset, err1 := parseInt(block.Offset)
+		length, err2 := parseInt(block.Size)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid addressBlock")
+		}
+		if offset+length > size {
+			size = offset + length
+		}
+	}
+
+	var registers []Register
+	if xp.Registers != nil {
+		registers, err = convertRegisters(xp.Registers.Items, props, 0, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range registers {
+		registers[i].Name = xp.PrependToName + registers[i].Name + xp.AppendToName
+	}
+	sort.SliceStable(registers, func(i, j int) bool { return registers[i].AddressOffset < registers[j].AddressOffset })
+
+	var interrupts []Interrupt
+	for _, irq := range xp.Interrupts {
+		value, err := parseInt(irq.Value)
+		if err != nil {
+			return nil, fmt.Errorf("interrupt %s: invalid value %q", irq.Name, irq.Value)
+		}
+		interrupts = append(interrupts, Interrupt{Name: irq.Name, Description: cleanText(irq.Description), Value: int(value)})
+	}
+
+	names, offsets, err := expandDim(xp.Name, xp.xmlDim)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Peripheral, len(names))
+	for i, name := range names {
+		out[i] = Peripheral{
+			Name:        name,
+			Description: cleanText(xp.Description),
+			GroupName:   xp.GroupName,
+			BaseAddress: base + offsets[i],
+			Size:        size,
+			Interrupts:  interrupts,
+			Registers:   registers,
+		}
+	}
+	return out, nil
+}
+
+// convertRegisters flattens registers and clusters. offset is the address
+// offset of the enclosing cluster and prefix its name.
+func convertRegisters(items []xmlRegisterItem, props properties, offset uint64, prefix string) ([]Register, error) {
+	registersByName := make(map[string]*xmlRegister)
+	clustersByName := make(map[string]*xmlCluster)
+	for _, item := range items {
+		if item.Register != nil {
+			registersByName[item.Register.Name] = item.Register
+		} else {
+			clustersByName[item.Cluster.Name] = item.Cluster
+		}
+	}
+
+	var out []Register
+	for _, item := range items {
+		if item.Cluster != nil {
+			cluster := *item.Cluster
+			if cluster.DerivedFrom != "" {
+				base, ok := clustersByName[cluster.DerivedFrom]
+				if !ok {
+					return nil, fmt.Errorf("cluster %s: derivedFrom unknown cluster %s", cluster.Name, cluster.DerivedFrom)
+				}
+				if len(cluster.Items) == 0 {
+					cluster.Items = base.Items
+				}
+			}
+			clusterProps, err := props.inherit(cluster.xmlRegisterProperties)
+			if err != nil {
+				return nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+			}
+			clusterOffset, err := parseInt(cluster.AddressOffset)
+			if err != nil {
+				return nil, fmt.Errorf("cluster %s: invalid addressOffset %q", cluster.Name, cluster.AddressOffset)
+			}
+			names, offsets, err := expandDim(cluster.Name, cluster.xmlDim)
+			if err != nil {
+				return nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+			}
+			for i, name := range names {
+				registers, err := convertRegisters(cluster.Items, clusterProps, offset+clusterOffset+offsets[i], prefix+name+"_")
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, registers...)
+			}
+			continue
+		}
+
+		xr := *item.Register
+		if xr.DerivedFrom != "" {
+			base, ok := registersByName[xr.DerivedFrom]
+			if !ok {
+				return nil, fmt.Errorf("register %s: derivedFrom unknown register %s", xr.Name, xr.DerivedFrom)
+			}
+			xr = deriveRegister(xr, *base)
+		}
+		registers, err := convertRegister(xr, props, offset, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("register %s: %w", xr.Name, err)
+		}
+		out = append(out, registers...)
+	}
+	return out, nil
+}
+
+func deriveRegister(r, base xmlRegister) xmlRegister {
+	if r.Description == "" {
+		r.Description = base.Description
+	}
+	if r.Size == "" {
+		r.Size = base.Size
+	}
+	if r.Access == "" {
+		r.Access = base.Access
+	}
+	if r.ResetValue == "" {
+		r.ResetValue = base.ResetValue
+	}
+	if r.ResetMask == "" {
+		r.ResetMask = base.ResetMask
+	}
+	if r.ReadAction == "" {
+		r.ReadAction = base.ReadAction
+	}
+	if len(r.Fields) == 0 {
+		r.Fields = base.Fields
+	}
+	return r
+}
+
+func convertRegister(xr xmlRegister, props properties, offset uint64, prefix string) ([]Register, error) {
+	props, err := props.inherit(xr.xmlRegisterProperties)
+	if err != nil {
+		return nil, err
+	}
+	addressOffset, err := parseInt(xr.AddressOffset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid addressOffset %q", xr.AddressOffset)
+	}
+
+	var fields []Field
+	for _, xf := range xr.Fields {
+		converted, err := convertField(xf, props.access)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", xf.Name, err)
+		}
+		fields = append(fields, converted...)
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].BitOffset < fields[j].BitOffset })
+
+	names, offsets, err := expandDim(xr.Name, xr.xmlDim)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Register, len(names))
+	for i, name := range names {
+		out[i] = Register{
+			Name:          prefix + name,
+			Description:   cleanText(xr.Description),
+			AddressOffset: offset + addressOffset + offsets[i],
+			Size:          props.size,
+			Access:        props.access,
+			ResetValue:    props.resetValue,
+			ResetMask:     props.resetMask,
+			ReadAction:    strings.TrimSpace(xr.ReadAction),
+			Fields:        fields,
+		}
+	}
+	return out, nil
+}
+
+func convertField(xf xmlField, access string) ([]Field, error) {
+	var offset, width uint64
+	var err error
+	switch {
+	case xf.BitOffset != "":
+		if offset, err = parseInt(xf.BitOffset); err != nil {
+			return nil, fmt.Errorf("invalid bitOffset %q", xf.BitOffset)
+		}
+		width = 1
+		if xf.BitWidth != "" {
+			if width, err = parseInt(xf.BitWidth); err != nil {
+				return nil, fmt.Errorf("invalid bitWidth %q", xf.BitWidth)
+			}
+		}
+	case xf.LSB != "" && xf.MSB != "":
+		lsb, err1 := parseInt(xf.LSB)
+		msb, err2 := parseInt(xf.MSB)
+		if err1 != nil || err2 != nil || msb < lsb {
+			return nil, fmt.Errorf("invalid lsb/msb")
+		}
+		offset, width = lsb, msb-lsb+1
+	case xf.BitRange != "":
+		var msb, lsb uint64
+		if _, err := fmt.Sscanf(strings.TrimSpace(xf.BitRange), "[%d:%d]", &msb, &lsb); err != nil || msb < lsb {
+			return nil, fmt.Errorf("invalid bitRange %q", xf.BitRange)
+		}
+		offset, width = lsb, msb-lsb+1
+	default:
+		return nil, fmt.Errorf("bit position missing")
+	}
+
+	if xf.Access != "" {
+		access = strings.TrimSpace(xf.Access)
+	}
+
+	var values []EnumeratedValue
+	for _, group := range xf.EnumeratedValues {
+		for _, v := range group.Values {
+			if parseBool(v.IsDefault) || v.Value == "" {
+				continue
+			}
+			value, err := parseInt(v.Value)
+			if err != nil {
+				continue // "#1x0" style don't-care patterns cannot be listed
+			}
+			values = append(values, EnumeratedValue{Name: v.Name, Description: cleanText(v.Description), Value: value})
+		}
+	}
+
+	names, offsets, err := expandDim(xf.Name, xf.xmlDim)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Field, len(names))
+	for i, name := range names {
+		out[i] = Field{
+			Name:        name,
+			Description: cleanText(xf.Description),
+			BitOffset:   int(offset + offsets[i]),
+			BitWidth:    int(width),
+			Access:      access,
+			ReadAction:  strings.TrimSpace(xf.ReadAction),
+			Values:      values,
+		}
+	}
+	return out, nil
+}
+
+// expandDim expands a dim array into element names and offsets. Names use
+// %s for the index, or "[%s]" for plain arrays.
+func expandDim(name string, dim xmlDim) ([]string, []uint64, error) {
+	if dim.Dim == "" {
+		return []string{name}, []uint64{0}, nil
+	}
+	count, err := parseInt(dim.Dim)
+	if err != nil || count == 0 || count > maxDim {
+		return nil, nil, fmt.Errorf("invalid dim %q", dim.Dim)
+	}
+	increment, err := parseInt(dim.DimIncrement)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dimIncrement %q", dim.DimIncrement)
+	}
+
+	indices, err := dimIndices(dim.DimIndex, int(count))
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, count)
+	offsets := make([]uint64, count)
+	for i := range names {
+		switch {
+		case strings.Contains(name, "[%s]"):
+			names[i] = strings.Replace(name, "[%s]", indices[i], 1)
+		default:
+			names[i] = strings.Replace(name, "%s", indices[i], 1)
+		}
+		offsets[i] = uint64(i) * increment
+	}
+	return names, offsets, nil
+}
+
+// dimIndices decodes dimIndex: "0-3", "A-D" or "A,B,C". Ranges are checked
+// against count before they are expanded.
+func dimIndices(text string, count int) ([]string, error) {
+	if count <= 0 || count > maxDim {
+		return nil, fmt.Errorf("invalid dim %d", count)
+	}
+	text = strings.TrimSpace(text)
+	mismatch := fmt.Errorf("dimIndex %q does not have %d entries", text, count)
+	indices := make([]string, 0, count)
+	switch {
+	case text == "":
+		for i := 0; i < count; i++ {
+			indices = append(indices, strconv.Itoa(i))
+		}
+	case strings.Contains(text, ","):
+		for _, item := range strings.Split(text, ",") {
+			indices = append(indices, strings.TrimSpace(item))
+		}
+	case strings.Contains(text, "-"):
+		from, to, _ := strings.Cut(text, "-")
+		if start, err := strconv.Atoi(from); err == nil {
+			end, err := strconv.Atoi(to)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid dimIndex %q", text)
+			}
+			if end < start || end-start != count-1 {
+				return nil, mismatch
+			}
+			for i := 0; i < count; i++ {
+				indices = append(indices, strconv.Itoa(start+i))
+			}
+		} else if len(from) == 1 && len(to) == 1 {
+			if to[0] < from[0] || int(to[0]-from[0]) != count-1 {
+				return nil, mismatch
+			}
+			for i := 0; i < count; i++ {
+				indices = append(indices, string(from[0]+byte(i)))
+			}
+		}
+	default:
+		indices = append(indices, text)
+	}
+	if len(indices) != count {
+		return nil, mismatch
+	}
+	return indices, nil
+}
+
+// parseInt accepts decimal, 0x hexadecimal and #binary SVD integers
+func parseInt(text string) (uint64, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return 0, fmt.Errorf("empty number")
+	case strings.HasPrefix(text, "#"):
+		return strconv.ParseUint(text[1:], 2, 64)
+	case strings.HasPrefix(text, "0b"), strings.HasPrefix(text, "0B"):
+		return strconv.ParseUint(text[2:], 2, 64)
+	case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+		return strconv.ParseUint(text[2:], 16, 64)
+	}
+	return strconv.ParseUint(text, 10, 64)
+}
+
+func parseBool(text string) bool {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "true", "1":
+		return true
+	}
+	return false
+}
+
+// cleanText collapses the whitespace of multi-line descriptions
+func cleanText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
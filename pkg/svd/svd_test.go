@@ -0,0 +1,229 @@
+package svd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testSVD = `<?xml version="1.0" encoding="utf-8"?>
+<device schemaVersion="1.1">
+  <vendor>STMicroelectronics</vendor>
+  <name>STM32F407</name>
+  <version>1.0</version>
+  <description>STM32F407
+    microcontroller</description>
+  <cpu>
+    <name>CM4</name>
+    <revision>r0p1</revision>
+    <endian>little</endian>
+    <mpuPresent>true</mpuPresent>
+    <fpuPresent>true</fpuPresent>
+    <nvicPrioBits>4</nvicPrioBits>
+  </cpu>
+  <addressUnitBits>8</addressUnitBits>
+  <width>32</width>
+  <size>0x20</size>
+  <resetValue>0x0</resetValue>
+  <resetMask>0xFFFFFFFF</resetMask>
+  <peripherals>
+    <peripheral>
+      <name>USART1</name>
+      <groupName>USART</groupName>
+      <baseAddress>0x40011000</baseAddress>
+      <addressBlock><offset>0x0</offset><size>0x400</size><usage>registers</usage></addressBlock>
+      <interrupt><name>USART1</name><value>37</value></interrupt>
+      <registers>
+        <register>
+          <name>SR</name>
+          <description>Status register</description>
+          <addressOffset>0x0</addressOffset>
+          <access>read-only</access>
+          <resetValue>0x00C0</resetValue>
+          <fields>
+            <field><name>TXE</name><bitOffset>7</bitOffset><bitWidth>1</bitWidth></field>
+            <field><name>RXNE</name><lsb>5</lsb><msb>5</msb></field>
+          </fields>
+        </register>
+        <register>
+          <name>DR</name>
+          <addressOffset>0x4</addressOffset>
+          <size>16</size>
+          <fields>
+            <field><name>DR</name><bitRange>[8:0]</bitRange></field>
+          </fields>
+        </register>
+        <register>
+          <name>CR1</name>
+          <addressOffset>0xC</addressOffset>
+          <fields>
+            <field>
+              <name>M</name><bitOffset>12</bitOffset><bitWidth>1</bitWidth><access>write-only</access>
+              <enumeratedValues>
+                <enumeratedValue><name>Bits8</name><value>0</value></enumeratedValue>
+                <enumeratedValue><name>Bits9</name><value>#1</value></enumeratedValue>
+              </enumeratedValues>
+            </field>
+          </fields>
+        </register>
+        <register derivedFrom="CR1">
+          <name>CR2</name>
+          <addressOffset>0x10</addressOffset>
+        </register>
+      </registers>
+    </peripheral>
+    <peripheral derivedFrom="USART1">
+      <name>USART2</name>
+      <baseAddress>0x40004400</baseAddress>
+      <interrupt><name>USART2</name><value>38</value></interrupt>
+    </peripheral>
+    <peripheral>
+      <name>DMA1</name>
+      <baseAddress>0x40026000</baseAddress>
+      <registers>
+        <register>
+          <dim>2</dim>
+          <dimIncrement>4</dimIncrement>
+          <dimIndex>L,H</dimIndex>
+          <name>%sISR</name>
+          <addressOffset>0x0</addressOffset>
+        </register>
+        <cluster>
+          <dim>2</dim>
+          <dimIncrement>0x18</dimIncrement>
+          <name>S[%s]</name>
+          <addressOffset>0x10</addressOffset>
+          <register><name>CR</name><addressOffset>0x0</addressOffset></register>
+          <register><name>NDTR</name><addressOffset>0x4</addressOffset></register>
+        </cluster>
+      </registers>
+    </peripheral>
+  </peripherals>
+</device>`
+
+func TestParse(t *testing.T) {
+	device, err := Parse(strings.NewReader(testSVD))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if device.Name != "STM32F407" || device.Vendor != "STMicroelectronics" {
+		t.Errorf("Unexpected device %s/%s", device.Vendor, device.Name)
+	}
+	if device.Description != "STM32F407 microcontroller" {
+		t.Errorf("Expected collapsed description, got %q", device.Description)
+	}
+	if device.CPU == nil || device.CPU.Name != "CM4" || !device.CPU.FPUPresent || device.CPU.NVICPrioBits != 4 {
+		t.Errorf("Unexpected CPU %+v", device.CPU)
+	}
+	if len(device.Peripherals) != 3 {
+		t.Fatalf("Expected 3 peripherals, got %d", len(device.Peripherals))
+	}
+
+	// Sorted by base address
+	usart2, usart1, dma := device.Peripherals[0], device.Peripherals[1], device.Peripherals[2]
+	if usart2.Name != "USART2" || usart1.Name != "USART1" || dma.Name != "DMA1" {
+		t.Fatalf("Unexpected peripheral order %s, %s, %s", usart2.Name, usart1.Name, dma.Name)
+	}
+	if usart1.Size != 0x400 || len(usart1.Interrupts) != 1 || usart1.Interrupts[0].Value != 37 {
+		t.Errorf("Unexpected USART1 %+v", usart1)
+	}
+
+	sr := usart1.Registers[0]
+	if sr.Name != "SR" || sr.Size != 32 || sr.Access != ReadOnly || sr.ResetValue != 0xC0 || sr.ResetMask != 0xFFFFFFFF {
+		t.Errorf("Unexpected SR %+v", sr)
+	}
+	if len(sr.Fields) != 2 || sr.Fields[0].Name != "RXNE" || sr.Fields[0].BitOffset != 5 || sr.Fields[1].Name != "TXE" {
+		t.Errorf("Unexpected SR fields %+v", sr.Fields)
+	}
+	if sr.Fields[1].Access != ReadOnly {
+		t.Errorf("Expected fields to inherit register access, got %q", sr.Fields[1].Access)
+	}
+
+	dr := usart1.Registers[1]
+	if dr.Size != 16 || dr.Access != ReadWrite || dr.Fields[0].BitWidth != 9 || dr.Fields[0].Mask() != 0x1FF {
+		t.Errorf("Unexpected DR %+v", dr)
+	}
+
+	cr1, cr2 := usart1.Registers[2], usart1.Registers[3]
+	m := cr1.Fields[0]
+	if m.Access != WriteOnly || len(m.Values) != 2 || m.Values[1].Name != "Bits9" || m.Values[1].Value != 1 {
+		t.Errorf("Unexpected CR1.M %+v", m)
+	}
+	if cr2.Name != "CR2" || cr2.AddressOffset != 0x10 || len(cr2.Fields) != 1 || cr2.Fields[0].Name != "M" {
+		t.Errorf("Expected CR2 derived from CR1, got %+v", cr2)
+	}
+
+	if len(usart2.Registers) != 4 || usart2.GroupName != "USART" || usart2.Interrupts[0].Value != 38 {
+		t.Errorf("Expected USART2 derived from USART1, got %+v", usart2)
+	}
+
+	var names []string
+	offsets := make(map[string]uint64)
+	for _, reg := range dma.Registers {
+		names = append(names, reg.Name)
+		offsets[reg.Name] = reg.AddressOffset
+	}
+	if got := strings.Join(names, ","); got != "LISR,HISR,S0_CR,S0_NDTR,S1_CR,S1_NDTR" {
+		t.Errorf("Unexpected DMA registers %s", got)
+	}
+	if offsets["HISR"] != 4 || offsets["S1_NDTR"] != 0x10+0x18+4 {
+		t.Errorf("Unexpected DMA offsets %v", offsets)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		svd  string
+	}{
+		{"not xml", "device"},
+		{"no name", "<device><peripherals/></device>"},
+		{"unknown base", `<device><name>X</name><peripherals><peripheral derivedFrom="A"><name>B</name><baseAddress>0</baseAddress></peripheral></peripherals></device>`},
+		{"bad address", `<device><name>X</name><peripherals><peripheral><name>A</name><baseAddress>zz</baseAddress></peripheral></peripherals></device>`},
+		{"no bit position", `<device><name>X</name><peripherals><peripheral><name>A</name><baseAddress>0</baseAddress><registers><register><name>R</name><addressOffset>0</addressOffset><fields><field><name>F</name></field></fields></register></registers></peripheral></peripherals></device>`},
+		{"dim index mismatch", `<device><name>X</name><peripherals><peripheral><name>A</name><baseAddress>0</baseAddress><registers><register><dim>3</dim><dimIncrement>4</dimIncrement><dimIndex>A,B</dimIndex><name>R%s</name><addressOffset>0</addressOffset></register></registers></peripheral></peripherals></device>`},
+		{"register too wide", `<device><name>X</name><peripherals><peripheral><name>A</name><baseAddress>0</baseAddress><registers><register><name>R</name><addressOffset>0</addressOffset><size>128</size></register></registers></peripheral></peripherals></device>`},
+		{"dim index range too long", `<device><name>X</name><peripherals><peripheral><name>A</name><baseAddress>0</baseAddress><registers><register><dim>3</dim><dimIncrement>4</dimIncrement><dimIndex>0-30000000</dimIndex><name>R%s</name><addressOffset>0</addressOffset></register></registers></peripheral></peripherals></device>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.svd)); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestDimIndices(t *testing.T) {
+	tests := []struct {
+		text  string
+		count int
+		want  string
+	}{
+		{"", 3, "[0 1 2]"},
+		{"4-6", 3, "[4 5 6]"},
+		{"A-C", 3, "[A B C]"},
+		{"L, H", 2, "[L H]"},
+		{"0-30000000", 3, ""},
+		{"-5-0", 6, ""},
+		{"C-A", 3, ""},
+		{"A-C", 5000, ""},
+	}
+	for _, tt := range tests {
+		indices, err := dimIndices(tt.text, tt.count)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("dimIndices(%q, %d) = %v", tt.text, tt.count, indices)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(indices) != tt.want {
+			t.Errorf("dimIndices(%q, %d) = %v, %v", tt.text, tt.count, indices, err)
+		}
+	}
+	// A range up to the last byte ends
+	if indices, err := dimIndices(string([]byte{0, '-', 0xff}), 256); err != nil || len(indices) != 256 {
+		t.Errorf("full byte range: %d indices, %v", len(indices), err)
+	}
+}
@@ -0,0 +1,142 @@
+package svd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// FieldValue is the decoded value of a field
+type FieldValue struct {
+	Name      string `json:"name"`
+	BitOffset int    `json:"bit_offset"`
+	BitWidth  int    `json:"bit_width"`
+	Access    string `json:"access,omitempty"`
+	Value     uint64 `json:"value"`
+	Enum      string `json:"enum,omitempty"` // Name of the matching enumerated value
+}
+
+// Readable reports whether an access type permits reads
+func Readable(access string) bool {
+	return access != WriteOnly && access != WriteOnce
+}
+
+// Writable reports whether an access type permits writes
+func Writable(access string) bool {
+	return access != ReadOnly
+}
+
+// Bytes returns the register width in bytes
+func (r *Register) Bytes() int {
+	if r.Size <= 0 {
+		return 4
+	}
+	return (r.Size + 7) / 8
+}
+
+// HasReadSideEffects reports whether reading the register or one of its
+// fields modifies the peripheral state
+func (r *Register) HasReadSideEffects() bool {
+	if r.ReadAction != "" {
+		return true
+	}
+	for _, f := range r.Fields {
+		if f.ReadAction != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Field returns the field with the given name, ignoring case
+func (r *Register) Field(name string) (*Field, bool) {
+	for i := range r.Fields {
+		if strings.EqualFold(r.Fields[i].Name, name) {
+			return &r.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// Decode splits a register value into its fields
+func (r *Register) Decode(value uint64) []FieldValue {
+	out := make([]FieldValue, len(r.Fields))
+	for i := range r.Fields {
+		f := &r.Fields[i]
+		v := f.Extract(value)
+		out[i] = FieldValue{
+			Name:      f.Name,
+			BitOffset: f.BitOffset,
+			BitWidth:  f.BitWidth,
+			Access:    f.Access,
+			Value:     v,
+		}
+		for _, e := range f.Values {
+			if e.Value == v {
+				out[i].Enum = e.Name
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Mask returns the field mask within the register
+func (f *Field) Mask() uint64 {
+	if f.BitWidth >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1)<<uint(f.BitWidth) - 1) << uint(f.BitOffset)
+}
+
+// Extract returns the field value of a register value
+func (f *Field) Extract(value uint64) uint64 {
+	return (value & f.Mask()) >> uint(f.BitOffset)
+}
+
+// Insert returns the register value with the field replaced
+func (f *Field) Insert(register, value uint64) (uint64, error) {
+	if f.BitWidth < 64 && value>>uint(f.BitWidth) != 0 {
+		return 0, fmt.Errorf("value %#x does not fit in %d-bit field %s", value, f.BitWidth, f.Name)
+	}
+	return register&^f.Mask() | value<<uint(f.BitOffset), nil
+}
+
+// EnumValue resolves the name of an enumerated value, ignoring case
+func (f *Field) EnumValue(name string) (uint64, bool) {
+	for _, e := range f.Values {
+		if strings.EqualFold(e.Name, name) {
+			return e.Value, true
+		}
+	}
+	return 0, false
+}
+
+// DecodeBytes converts register memory of at most 8 bytes into a value
+func DecodeBytes(data []byte, bigEndian bool) (uint64, error) {
+	if len(data) > 8 {
+		return 0, fmt.Errorf("%d-byte registers are not supported", len(data))
+	}
+	var buf [8]byte
+	if bigEndian {
+		copy(buf[8-len(data):], data)
+		return binary.BigEndian.Uint64(buf[:]), nil
+	}
+	copy(buf[:], data)
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// EncodeBytes converts a value into register memory of the given width, at
+// most 8 bytes
+func EncodeBytes(value uint64, width int, bigEndian bool) ([]byte, error) {
+	if width < 0 || width > 8 {
+		return nil, fmt.Errorf("%d-byte registers are not supported", width)
+	}
+	var buf [8]byte
+	if bigEndian {
+		binary.BigEndian.PutUint64(buf[:], value)
+		return append([]byte(nil), buf[8-width:]...), nil
+	}
+	binary.LittleEndian.PutUint64(buf[:], value)
+	return append([]byte(nil), buf[:width]...), nil
+}
@@ -0,0 +1,77 @@
+package svd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegister_Decode(t *testing.T) {
+	reg := Register{
+		Name: "SR",
+		Size: 32,
+		Fields: []Field{
+			{Name: "RXNE", BitOffset: 5, BitWidth: 1},
+			{Name: "TXE", BitOffset: 7, BitWidth: 1},
+			{Name: "M", BitOffset: 12, BitWidth: 2, Values: []EnumeratedValue{{Name: "Bits8", Value: 0}, {Name: "Bits9", Value: 1}}},
+		},
+	}
+
+	fields := reg.Decode(0x10A0)
+	if fields[0].Value != 1 || fields[1].Value != 1 || fields[2].Value != 1 || fields[2].Enum != "Bits9" {
+		t.Errorf("Unexpected fields %+v", fields)
+	}
+
+	m, ok := reg.Field("m")
+	if !ok {
+		t.Fatal("Expected field M")
+	}
+	value, err := m.Insert(0xFFFFFFFF, 0)
+	if err != nil || value != 0xFFFFCFFF {
+		t.Errorf("Insert returned %#x, %v", value, err)
+	}
+	if _, err := m.Insert(0, 4); err == nil {
+		t.Error("Expected error for a value wider than the field")
+	}
+	if v, ok := m.EnumValue("bits9"); !ok || v != 1 {
+		t.Errorf("EnumValue returned %d, %v", v, ok)
+	}
+}
+
+func TestRegister_ReadSideEffects(t *testing.T) {
+	reg := Register{Name: "DR", Fields: []Field{{Name: "DR", BitWidth: 9}}}
+	if reg.HasReadSideEffects() {
+		t.Error("Expected no side effects")
+	}
+	reg.Fields[0].ReadAction = "modify"
+	if !reg.HasReadSideEffects() {
+		t.Error("Expected field read action to count as a side effect")
+	}
+	if Readable(WriteOnly) || !Readable(ReadWriteOnce) || Writable(ReadOnly) || !Writable(WriteOnce) {
+		t.Error("Unexpected access checks")
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	if data, err := EncodeBytes(0x1234, 2, false); err != nil || !bytes.Equal(data, []byte{0x34, 0x12}) {
+		t.Errorf("Unexpected little-endian bytes % x, %v", data, err)
+	}
+	if data, err := EncodeBytes(0x1234, 4, true); err != nil || !bytes.Equal(data, []byte{0, 0, 0x12, 0x34}) {
+		t.Errorf("Unexpected big-endian bytes % x, %v", data, err)
+	}
+	if v, err := DecodeBytes([]byte{0x34, 0x12}, false); err != nil || v != 0x1234 {
+		t.Errorf("Unexpected little-endian value %#x, %v", v, err)
+	}
+	if v, err := DecodeBytes([]byte{0, 0, 0x12, 0x34}, true); err != nil || v != 0x1234 {
+		t.Errorf("Unexpected big-endian value %#x, %v", v, err)
+	}
+
+	// Registers wider than 64 bits are rejected rather than sliced
+	for _, bigEndian := range []bool{false, true} {
+		if data, err := EncodeBytes(1, 16, bigEndian); err == nil {
+			t.Errorf("Encoded a 16-byte register: % x", data)
+		}
+		if v, err := DecodeBytes(make([]byte, 16), bigEndian); err == nil {
+			t.Errorf("Decoded a 16-byte register: %#x", v)
+		}
+	}
+}
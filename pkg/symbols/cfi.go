@@ -0,0 +1,560 @@
+package symbols
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNoFrameInfo is returned for addresses without call frame information
+var ErrNoFrameInfo = errors.New("no call frame information")
+
+// Register rule kinds of a CFI row
+const (
+	ruleUndefined = iota
+	ruleSameValue
+	ruleOffset    // Saved at CFA+offset
+	ruleValOffset // Value is CFA+offset
+	ruleRegister  // Saved in another register
+	ruleExpression
+	ruleValExpression
+)
+
+// frameRule tells how to recover a register of the caller
+type frameRule struct {
+	kind   int
+	offset int64
+	reg    int
+	expr   []byte
+}
+
+// frameRow is a row of the CFI table: how to compute the canonical frame
+// address at an address and where the caller's registers are saved
+type frameRow struct {
+	cfaReg    int
+	cfaOffset int64
+	cfaExpr   []byte
+	rules     map[int]frameRule
+}
+
+func (r *frameRow) clone() *frameRow {
+	copied := *r
+	copied.rules = make(map[int]frameRule, len(r.rules))
+	for reg, rule := range r.rules {
+		copied.rules[reg] = rule
+	}
+	return &copied
+}
+
+// cie is a common information entry
+type cie struct {
+	codeAlign    uint64
+	dataAlign    int64
+	returnReg    int
+	encoding     byte // Pointer encoding of FDEs in .eh_frame
+	hasAugData   bool
+	addressSize  int
+	instructions []byte
+}
+
+// fde is a frame description entry covering [low, high)
+type fde struct {
+	low, high    uint64
+	cie          *cie
+	instructions []byte
+}
+
+// frameInfo is the call frame information of a program
+type frameInfo struct {
+	fdes  []fde // Sorted by low
+	order binary.ByteOrder
+}
+
+// DWARF pointer encodings of .eh_frame
+const (
+	pointerAbsolute = 0x00
+	pointerULEB     = 0x01
+	pointerUdata2   = 0x02
+	pointerUdata4   = 0x03
+	pointerUdata8   = 0x04
+	pointerSLEB     = 0x09
+	pointerSdata2   = 0x0a
+	pointerSdata4   = 0x0b
+	pointerSdata8   = 0x0c
+	pointerPCRel    = 0x10
+	pointerOmit     = 0xff
+)
+
+// loadFrameInfo parses .debug_frame, or .eh_frame when there is none
+func (t *Table) loadFrameInfo() {
+	info := &frameInfo{order: t.elf.ByteOrder}
+	addressSize := 4
+	if t.Class == elf.ELFCLASS64 {
+		addressSize = 8
+	}
+	for _, name := range []string{".debug_frame", ".eh_frame"} {
+		section := t.elf.Section(name)
+		if section == nil {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			continue
+		}
+		if err := info.parse(data, section.Addr, name == ".eh_frame", addressSize); err != nil || len(info.fdes) > 0 {
+			break
+		}
+	}
+	sort.Slice(info.fdes, func(i, j int) bool { return info.fdes[i].low < info.fdes[j].low })
+	t.frames = info
+}
+
+// parse reads the entries of a frame section loaded at base
+func (f *frameInfo) parse(data []byte, base uint64, eh bool, addressSize int) error {
+	cies := make(map[uint64]*cie)
+	for offset := uint64(0); offset+4 <= uint64(len(data)); {
+		start := offset
+		length := uint64(f.order.Uint32(data[offset:]))
+		offset += 4
+		idSize := uint64(4)
+		if length == 0xffffffff {
+			if offset+8 > uint64(len(data)) {
+				return fmt.Errorf("truncated frame entry at %#x", start)
+			}
+			length = f.order.Uint64(data[offset:])
+			offset += 8
+			idSize = 8
+		}
+		if length == 0 {
+			if eh {
+				break // Terminator of .eh_frame
+			}
+			continue
+		}
+		end := offset + length
+		if end > uint64(len(data)) || length < idSize {
+			return fmt.Errorf("truncated frame entry at %#x", start)
+		}
+
+		idOffset := offset
+		var id uint64
+		if idSize == 8 {
+			id = f.order.Uint64(data[offset:])
+		} else {
+			id = uint64(f.order.Uint32(data[offset:]))
+		}
+		body := &reader{data: data[:end], offset: int(offset + idSize), order: f.order}
+
+		isCIE := id == 0
+		if !eh {
+			isCIE = id == 0xffffffff || id == 0xffffffffffffffff
+		}
+		if isCIE {
+			c, err := parseCIE(body, eh, addressSize)
+			if err != nil {
+				return err
+			}
+			cies[start] = c
+		} else {
+			cieOffset := id
+			if eh {
+				cieOffset = idOffset - id
+			}
+			c, ok := cies[cieOffset]
+			if !ok {
+				return fmt.Errorf("frame entry at %#x refers to unknown CIE %#x", start, cieOffset)
+			}
+			entry, err := parseFDE(body, c, eh, base)
+			if err != nil {
+				return err
+			}
+			if entry.high > entry.low {
+				f.fdes = append(f.fdes, entry)
+			}
+		}
+		offset = end
+	}
+	return nil
+}
+
+func parseCIE(r *reader, eh bool, addressSize int) (*cie, error) {
+	c := &cie{encoding: pointerAbsolute, addressSize: addressSize}
+	version := r.u8()
+	augmentation := r.cstring()
+	if version >= 4 && !eh {
+		c.addressSize = int(r.u8())
+		r.u8() // Segment selector size
+	}
+	if augmentation == "eh" {
+		r.skip(c.addressSize)
+	}
+	c.codeAlign = r.uleb()
+	c.dataAlign = r.sleb()
+	if version == 1 {
+		c.returnReg = int(r.u8())
+	} else {
+		c.returnReg = int(r.uleb())
+	}
+
+	if len(augmentation) > 0 && augmentation[0] == 'z' {
+		c.hasAugData = true
+		length := r.uleb()
+		end := r.offset + int(length)
+		for _, ch := range augmentation[1:] {
+			switch ch {
+			case 'R':
+				c.encoding = r.u8()
+			case 'P':
+				encoding := r.u8()
+				r.pointer(encoding, 0, c.addressSize)
+			case 'L':
+				r.u8()
+			}
+		}
+		r.offset = end
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid CIE: %w", r.err)
+	}
+	c.instructions = r.rest()
+	return c, nil
+}
+
+func parseFDE(r *reader, c *cie, eh bool, base uint64) (fde, error) {
+	entry := fde{cie: c}
+	if eh {
+		entry.low = r.pointer(c.encoding, base, c.addressSize)
+		entry.high = entry.low + r.pointer(c.encoding&0x0f, 0, c.addressSize)
+		if c.hasAugData {
+			r.skip(int(r.uleb()))
+		}
+	} else {
+		entry.low = r.address(c.addressSize)
+		entry.high = entry.low + r.address(c.addressSize)
+	}
+	if r.err != nil {
+		return fde{}, fmt.Errorf("invalid FDE: %w", r.err)
+	}
+	entry.instructions = r.rest()
+	return entry, nil
+}
+
+// find returns the FDE covering an address
+func (f *frameInfo) find(pc uint64) (*fde, bool) {
+	i := sort.Search(len(f.fdes), func(i int) bool { return f.fdes[i].low > pc }) - 1
+	if i < 0 || pc >= f.fdes[i].high {
+		return nil, false
+	}
+	return &f.fdes[i], true
+}
+
+// row executes the CFI instructions of the FDE covering pc up to pc
+func (f *frameInfo) row(pc uint64) (*frameRow, *cie, error) {
+	entry, ok := f.find(pc)
+	if !ok {
+		return nil, nil, ErrNoFrameInfo
+	}
+	initial := &frameRow{rules: make(map[int]frameRule)}
+	if err := f.execute(entry.cie, initial, nil, entry.cie.instructions, entry.low, ^uint64(0)); err != nil {
+		return nil, nil, err
+	}
+	row := initial.clone()
+	if err := f.execute(entry.cie, row, initial, entry.instructions, entry.low, pc); err != nil {
+		return nil, nil, err
+	}
+	return row, entry.cie, nil
+}
+
+// execute runs CFI instructions starting at loc until the location passes
+// pc. initial is the row restored by DW_CFA_restore.
+func (f *frameInfo) execute(c *cie, row, initial *frameRow, instructions []byte, loc, pc uint64) error {
+	r := &reader{data: instructions, order: f.order}
+	var stack []*frameRow
+	restore := func(reg int) {
+		if initial != nil {
+			if rule, ok := initial.rules[reg]; ok {
+				row.rules[reg] = rule
+				return
+			}
+		}
+		delete(row.rules, reg)
+	}
+
+	for r.offset < len(r.data) && r.err == nil {
+		op := r.u8()
+		switch op & 0xc0 {
+		case 0x40: // DW_CFA_advance_loc
+			loc += uint64(op&0x3f) * c.codeAlign
+			if loc > pc {
+				return nil
+			}
+			continue
+		case 0x80: // DW_CFA_offset
+			row.rules[int(op&0x3f)] = frameRule{kind: ruleOffset, offset: int64(r.uleb()) * c.dataAlign}
+			continue
+		case 0xc0: // DW_CFA_restore
+			restore(int(op & 0x3f))
+			continue
+		}
+
+		switch op {
+		case 0x00: // DW_CFA_nop
+		case 0x01: // DW_CFA_set_loc
+			loc = r.address(c.addressSize)
+		case 0x02, 0x03, 0x04: // DW_CFA_advance_loc1/2/4
+			var delta uint64
+			switch op {
+			case 0x02:
+				delta = uint64(r.u8())
+			case 0x03:
+				delta = uint64(r.u16())
+			default:
+				delta = uint64(r.u32())
+			}
+			loc += delta * c.codeAlign
+		case 0x05: // DW_CFA_offset_extended
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleOffset, offset: int64(r.uleb()) * c.dataAlign}
+		case 0x06: // DW_CFA_restore_extended
+			restore(int(r.uleb()))
+		case 0x07: // DW_CFA_undefined
+			row.rules[int(r.uleb())] = frameRule{kind: ruleUndefined}
+		case 0x08: // DW_CFA_same_value
+			row.rules[int(r.uleb())] = frameRule{kind: ruleSameValue}
+		case 0x09: // DW_CFA_register
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleRegister, reg: int(r.uleb())}
+		case 0x0a: // DW_CFA_remember_state
+			stack = append(stack, row.clone())
+		case 0x0b: // DW_CFA_restore_state
+			if len(stack) == 0 {
+				return fmt.Errorf("DW_CFA_restore_state without remembered state")
+			}
+			*row = *stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case 0x0c: // DW_CFA_def_cfa
+			row.cfaReg = int(r.uleb())
+			row.cfaOffset = int64(r.uleb())
+			row.cfaExpr = nil
+		case 0x0d: // DW_CFA_def_cfa_register
+			row.cfaReg = int(r.uleb())
+			row.cfaExpr = nil
+		case 0x0e: // DW_CFA_def_cfa_offset
+			row.cfaOffset = int64(r.uleb())
+		case 0x0f: // DW_CFA_def_cfa_expression
+			row.cfaExpr = r.block()
+		case 0x10: // DW_CFA_expression
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleExpression, expr: r.block()}
+		case 0x11: // DW_CFA_offset_extended_sf
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleOffset, offset: r.sleb() * c.dataAlign}
+		case 0x12: // DW_CFA_def_cfa_sf
+			row.cfaReg = int(r.uleb())
+			row.cfaOffset = r.sleb() * c.dataAlign
+			row.cfaExpr = nil
+		case 0x13: // DW_CFA_def_cfa_offset_sf
+			row.cfaOffset = r.sleb() * c.dataAlign
+		case 0x14: // DW_CFA_val_offset
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleValOffset, offset: int64(r.uleb()) * c.dataAlign}
+		case 0x15: // DW_CFA_val_offset_sf
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleValOffset, offset: r.sleb() * c.dataAlign}
+		case 0x16: // DW_CFA_val_expression
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleValExpression, expr: r.block()}
+		case 0x2e: // DW_CFA_GNU_args_size
+			r.uleb()
+		case 0x2f: // DW_CFA_GNU_negative_offset_extended
+			reg := int(r.uleb())
+			row.rules[reg] = frameRule{kind: ruleOffset, offset: -int64(r.uleb()) * c.dataAlign}
+		default:
+			return fmt.Errorf("unsupported CFI instruction %#x", op)
+		}
+		if loc > pc {
+			return nil
+		}
+	}
+	return r.err
+}
+
+// reader decodes DWARF encoded data
+type reader struct {
+	data   []byte
+	offset int
+	order  binary.ByteOrder
+	err    error
+}
+
+var errTruncated = errors.New("truncated data")
+
+func (r *reader) need(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if n < 0 || r.offset+n > len(r.data) {
+		r.err = errTruncated
+		return false
+	}
+	return true
+}
+
+func (r *reader) skip(n int) {
+	if r.need(n) {
+		r.offset += n
+	}
+}
+
+func (r *reader) u8() byte {
+	if !r.need(1) {
+		return 0
+	}
+	r.offset++
+	return r.data[r.offset-1]
+}
+
+func (r *reader) u16() uint16 {
+	if !r.need(2) {
+		return 0
+	}
+	r.offset += 2
+	return r.order.Uint16(r.data[r.offset-2:])
+}
+
+func (r *reader) u32() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	r.offset += 4
+	return r.order.Uint32(r.data[r.offset-4:])
+}
+
+func (r *reader) u64() uint64 {
+	if !r.need(8) {
+		return 0
+	}
+	r.offset += 8
+	return r.order.Uint64(r.data[r.offset-8:])
+}
+
+func (r *reader) address(size int) uint64 {
+	switch size {
+	case 2:
+		return uint64(r.u16())
+	case 4:
+		return uint64(r.u32())
+	case 8:
+		return r.u64()
+	}
+	r.err = fmt.Errorf("unsupported address size %d", size)
+	return 0
+}
+
+func (r *reader) uleb() uint64 {
+	var value uint64
+	var shift uint
+	for {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			value |= uint64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 {
+			return value
+		}
+	}
+}
+
+func (r *reader) sleb() int64 {
+	var value int64
+	var shift uint
+	for {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			value |= int64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				value |= -1 << shift
+			}
+			return value
+		}
+	}
+}
+
+func (r *reader) cstring() string {
+	start := r.offset
+	for r.offset < len(r.data) {
+		if r.data[r.offset] == 0 {
+			s := string(r.data[start:r.offset])
+			r.offset++
+			return s
+		}
+		r.offset++
+	}
+	r.err = errTruncated
+	return ""
+}
+
+func (r *reader) block() []byte {
+	n := int(r.uleb())
+	if !r.need(n) {
+		return nil
+	}
+	r.offset += n
+	return r.data[r.offset-n : r.offset]
+}
+
+func (r *reader) rest() []byte {
+	if r.offset >= len(r.data) {
+		return nil
+	}
+	return r.data[r.offset:]
+}
+
+// pointer reads an .eh_frame encoded pointer; base is the address of the
+// section start for pc-relative values
+func (r *reader) pointer(encoding byte, base uint64, addressSize int) uint64 {
+	if encoding == pointerOmit {
+		return 0
+	}
+	position := base + uint64(r.offset)
+	var value uint64
+	switch encoding & 0x0f {
+	case pointerAbsolute:
+		value = r.address(addressSize)
+	case pointerULEB:
+		value = r.uleb()
+	case pointerUdata2:
+		value = uint64(r.u16())
+	case pointerUdata4:
+		value = uint64(r.u32())
+	case pointerUdata8:
+		value = r.u64()
+	case pointerSLEB:
+		value = uint64(r.sleb())
+	case pointerSdata2:
+		value = uint64(int64(int16(r.u16())))
+	case pointerSdata4:
+		value = uint64(int64(int32(r.u32())))
+	case pointerSdata8:
+		value = r.u64()
+	default:
+		r.err = fmt.Errorf("unsupported pointer encoding %#x", encoding)
+		return 0
+	}
+	if encoding&0x70 == pointerPCRel {
+		value += position
+	}
+	return value
+}
@@ -0,0 +1,423 @@
+package symbols
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Memory reads target memory
+type Memory func(address uint64, size int) ([]byte, error)
+
+// Frame is the state of the halted program as seen from one stack frame:
+// its registers, named as the debug backend reports them, and memory
+type Frame struct {
+	PC        uint64
+	Registers map[string]uint64
+	Memory    Memory
+
+	cfa    uint64
+	hasCFA bool
+	caller bool // PC is a return address
+}
+
+// lookupPC is the address to look up debug information for: return
+// addresses of callers may already belong to the next line or block
+func (f *Frame) lookupPC() uint64 {
+	if f.caller && f.PC > 0 {
+		return f.PC - 1
+	}
+	return f.PC
+}
+
+// NewFrame returns the innermost frame for the registers of a halted target
+func (t *Table) NewFrame(registers map[string]uint64, memory Memory) (*Frame, error) {
+	pc, ok := registers[t.PCRegister()]
+	if !ok {
+		return nil, fmt.Errorf("no %s register", t.PCRegister())
+	}
+	return &Frame{PC: t.codeAddress(pc), Registers: registers, Memory: memory}, nil
+}
+
+var riscvRegisterNames = []string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+var x86RegisterNames = []string{
+	"rax", "rdx", "rcx", "rbx", "rsi", "rdi", "rbp", "rsp",
+	"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15", "rip",
+}
+
+var i386RegisterNames = []string{"eax", "ecx", "edx", "ebx", "esp", "ebp", "esi", "edi", "eip"}
+
+// RegisterName maps a DWARF register number of the program's architecture
+// to the register name of the debug backends, or "" when it has none
+func (t *Table) RegisterName(regnum int) string {
+	switch t.Machine {
+	case elf.EM_ARM:
+		switch {
+		case regnum >= 0 && regnum <= 12:
+			return fmt.Sprintf("r%d", regnum)
+		case regnum == 13:
+			return "sp"
+		case regnum == 14:
+			return "lr"
+		case regnum == 15:
+			return "pc"
+		}
+	case elf.EM_AARCH64:
+		switch {
+		case regnum >= 0 && regnum <= 30:
+			return fmt.Sprintf("x%d", regnum)
+		case regnum == 31:
+			return "sp"
+		}
+	case elf.EM_RISCV:
+		if regnum >= 0 && regnum < len(riscvRegisterNames) {
+			return riscvRegisterNames[regnum]
+		}
+	case elf.EM_X86_64:
+		if regnum >= 0 && regnum < len(x86RegisterNames) {
+			return x86RegisterNames[regnum]
+		}
+	case elf.EM_386:
+		if regnum >= 0 && regnum < len(i386RegisterNames) {
+			return i386RegisterNames[regnum]
+		}
+	}
+	return ""
+}
+
+// PCRegister returns the name of the program counter register
+func (t *Table) PCRegister() string {
+	switch t.Machine {
+	case elf.EM_X86_64:
+		return "rip"
+	case elf.EM_386:
+		return "eip"
+	}
+	return "pc"
+}
+
+// register reads a register of a frame by DWARF number
+func (t *Table) register(frame *Frame, regnum int) (uint64, error) {
+	name := t.RegisterName(regnum)
+	if name == "" {
+		return 0, fmt.Errorf("unknown DWARF register %d", regnum)
+	}
+	value, ok := frame.Registers[name]
+	if !ok {
+		return 0, fmt.Errorf("register %s not available", name)
+	}
+	return value, nil
+}
+
+// CFA returns the canonical frame address of a frame: the stack pointer
+// before the call that created it
+func (t *Table) CFA(frame *Frame) (uint64, error) {
+	if frame.hasCFA {
+		return frame.cfa, nil
+	}
+	if t.frames == nil {
+		return 0, ErrNoFrameInfo
+	}
+	row, _, err := t.frames.row(frame.lookupPC())
+	if err != nil {
+		return 0, err
+	}
+	return t.frameCFA(frame, row)
+}
+
+// frameCFA computes the CFA of a frame by its CFI row
+func (t *Table) frameCFA(frame *Frame, row *frameRow) (uint64, error) {
+	if frame.hasCFA {
+		return frame.cfa, nil
+	}
+	var cfa uint64
+	if row.cfaExpr != nil {
+		loc, err := t.evaluate(row.cfaExpr, frame, nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		cfa = loc.address
+	} else {
+		base, err := t.register(frame, row.cfaReg)
+		if err != nil {
+			return 0, err
+		}
+		cfa = uint64(int64(base) + row.cfaOffset)
+	}
+	frame.cfa, frame.hasCFA = cfa, true
+	return cfa, nil
+}
+
+// Kinds of places an object can be located at
+const (
+	placeMemory   = iota
+	placeRegister // The object is the value of a register
+	placeValue    // The object has no location, only a value
+)
+
+// place is the result of evaluating a DWARF location expression
+type place struct {
+	kind    int
+	address uint64 // placeMemory
+	reg     int    // placeRegister
+	data    []byte // placeValue
+}
+
+var errOptimizedOut = errors.New("optimized out")
+
+// evaluate runs a DWARF location expression for a frame. frameBase is the
+// DW_AT_frame_base of the enclosing function; initial values are pushed on
+// the stack first.
+func (t *Table) evaluate(expr []byte, frame *Frame, frameBase []byte, initial []uint64) (place, error) {
+	if len(expr) == 0 {
+		return place{}, errOptimizedOut
+	}
+	order := t.elf.ByteOrder
+	addressSize := 4
+	if t.Class == elf.ELFCLASS64 {
+		addressSize = 8
+	}
+	r := &reader{data: expr, order: order}
+	stack := append([]uint64(nil), initial...)
+	push := func(v uint64) { stack = append(stack, v) }
+	pop := func() (uint64, error) {
+		if len(stack) == 0 {
+			return 0, fmt.Errorf("DWARF expression stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	readAddress := func(address uint64, size int) (uint64, error) {
+		if frame == nil || frame.Memory == nil {
+			return 0, fmt.Errorf("no memory access")
+		}
+		data, err := frame.Memory(address, size)
+		if err != nil {
+			return 0, err
+		}
+		if len(data) < size {
+			return 0, fmt.Errorf("short read at %#x", address)
+		}
+		return decodeUint(data[:size], order), nil
+	}
+	regValue := func(regnum int) (uint64, error) {
+		if frame == nil {
+			return 0, fmt.Errorf("no frame for register %d", regnum)
+		}
+		return t.register(frame, regnum)
+	}
+
+	for r.offset < len(r.data) {
+		op := r.u8()
+		switch {
+		case op >= 0x30 && op <= 0x4f: // DW_OP_lit0..31
+			push(uint64(op - 0x30))
+			continue
+		case op >= 0x50 && op <= 0x6f: // DW_OP_reg0..31
+			return place{kind: placeRegister, reg: int(op - 0x50)}, nil
+		case op >= 0x70 && op <= 0x8f: // DW_OP_breg0..31
+			offset := r.sleb()
+			value, err := regValue(int(op - 0x70))
+			if err != nil {
+				return place{}, err
+			}
+			push(uint64(int64(value) + offset))
+			continue
+		}
+
+		switch op {
+		case 0x03: // DW_OP_addr
+			push(r.address(addressSize))
+		case 0x06: // DW_OP_deref
+			address, err := pop()
+			if err != nil {
+				return place{}, err
+			}
+			value, err := readAddress(address, addressSize)
+			if err != nil {
+				return place{}, err
+			}
+			push(value)
+		case 0x08: // DW_OP_const1u
+			push(uint64(r.u8()))
+		case 0x09: // DW_OP_const1s
+			push(uint64(int64(int8(r.u8()))))
+		case 0x0a: // DW_OP_const2u
+			push(uint64(r.u16()))
+		case 0x0b: // DW_OP_const2s
+			push(uint64(int64(int16(r.u16()))))
+		case 0x0c: // DW_OP_const4u
+			push(uint64(r.u32()))
+		case 0x0d: // DW_OP_const4s
+			push(uint64(int64(int32(r.u32()))))
+		case 0x0e, 0x0f: // DW_OP_const8u, DW_OP_const8s
+			push(r.u64())
+		case 0x10: // DW_OP_constu
+			push(r.uleb())
+		case 0x11: // DW_OP_consts
+			push(uint64(r.sleb()))
+		case 0x12: // DW_OP_dup
+			if len(stack) == 0 {
+				return place{}, fmt.Errorf("DWARF expression stack underflow")
+			}
+			push(stack[len(stack)-1])
+		case 0x13: // DW_OP_drop
+			if _, err := pop(); err != nil {
+				return place{}, err
+			}
+		case 0x14: // DW_OP_over
+			if len(stack) < 2 {
+				return place{}, fmt.Errorf("DWARF expression stack underflow")
+			}
+			push(stack[len(stack)-2])
+		case 0x16: // DW_OP_swap
+			if len(stack) < 2 {
+				return place{}, fmt.Errorf("DWARF expression stack underflow")
+			}
+			n := len(stack)
+			stack[n-1], stack[n-2] = stack[n-2], stack[n-1]
+		case 0x1a, 0x1c, 0x1e, 0x21, 0x22, 0x24, 0x25, 0x26, 0x27: // Binary operators
+			b, err := pop()
+			if err != nil {
+				return place{}, err
+			}
+			a, err := pop()
+			if err != nil {
+				return place{}, err
+			}
+			switch op {
+			case 0x1a: // DW_OP_and
+				push(a & b)
+			case 0x1c: // DW_OP_minus
+				push(a - b)
+			case 0x1e: // DW_OP_mul
+				push(a * b)
+			case 0x21: // DW_OP_or
+				push(a | b)
+			case 0x22: // DW_OP_plus
+				push(a + b)
+			case 0x24: // DW_OP_shl
+				push(a << b)
+			case 0x25: // DW_OP_shr
+				push(a >> b)
+			case 0x26: // DW_OP_shra
+				push(uint64(int64(a) >> b))
+			case 0x27: // DW_OP_xor
+				push(a ^ b)
+			}
+		case 0x1f, 0x20: // DW_OP_neg, DW_OP_not
+			a, err := pop()
+			if err != nil {
+				return place{}, err
+			}
+			if op == 0x1f {
+				push(uint64(-int64(a)))
+			} else {
+				push(^a)
+			}
+		case 0x23: // DW_OP_plus_uconst
+			a, err := pop()
+			if err != nil {
+				return place{}, err
+			}
+			push(a + r.uleb())
+		case 0x90: // DW_OP_regx
+			return place{kind: placeRegister, reg: int(r.uleb())}, nil
+		case 0x91: // DW_OP_fbreg
+			offset := r.sleb()
+			if frameBase == nil {
+				return place{}, fmt.Errorf("DW_OP_fbreg outside a function")
+			}
+			base, err := t.evaluate(frameBase, frame, nil, nil)
+			if err != nil {
+				return place{}, fmt.Errorf("frame base: %w", err)
+			}
+			address := base.address
+			if base.kind == placeRegister {
+				if address, err = regValue(base.reg); err != nil {
+					return place{}, err
+				}
+			}
+			push(uint64(int64(address) + offset))
+		case 0x92: // DW_OP_bregx
+			regnum := int(r.uleb())
+			offset := r.sleb()
+			value, err := regValue(regnum)
+			if err != nil {
+				return place{}, err
+			}
+			push(uint64(int64(value) + offset))
+		case 0x94: // DW_OP_deref_size
+			size := int(r.u8())
+			address, err := pop()
+			if err != nil {
+				return place{}, err
+			}
+			value, err := readAddress(address, size)
+			if err != nil {
+				return place{}, err
+			}
+			push(value)
+		case 0x96: // DW_OP_nop
+		case 0x9c: // DW_OP_call_frame_cfa
+			if frame == nil {
+				return place{}, fmt.Errorf("no frame for DW_OP_call_frame_cfa")
+			}
+			cfa, err := t.CFA(frame)
+			if err != nil {
+				return place{}, err
+			}
+			push(cfa)
+		case 0x9e: // DW_OP_implicit_value
+			return place{kind: placeValue, data: r.block()}, r.err
+		case 0x9f: // DW_OP_stack_value
+			value, err := pop()
+			if err != nil {
+				return place{}, err
+			}
+			data := make([]byte, 8)
+			order.PutUint64(data, value)
+			if order == binary.BigEndian {
+				data = data[8-addressSize:]
+			}
+			return place{kind: placeValue, data: data}, nil
+		case 0x93: // DW_OP_piece
+			return place{}, fmt.Errorf("composite locations are not supported")
+		default:
+			return place{}, fmt.Errorf("unsupported DWARF operation %#x", op)
+		}
+		if r.err != nil {
+			return place{}, r.err
+		}
+	}
+	if r.err != nil {
+		return place{}, r.err
+	}
+	address, err := pop()
+	if err != nil {
+		return place{}, err
+	}
+	return place{kind: placeMemory, address: address}, nil
+}
+
+// decodeUint decodes an unsigned integer of up to 8 bytes
+func decodeUint(data []byte, order binary.ByteOrder) uint64 {
+	var value uint64
+	for i := range data {
+		b := data[i]
+		if order == binary.BigEndian {
+			value = value<<8 | uint64(b)
+		} else {
+			value |= uint64(b) << (8 * uint(i))
+		}
+	}
+	return value
+}
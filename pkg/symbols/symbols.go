@@ -0,0 +1,584 @@
+// Package symbols resolves addresses of ELF programs to functions and source
+// lines and back, using the DWARF debug information and the symbol table,
+// and reads typed variables of a halted program.
+package symbols
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoDebugInfo is returned for lookups that need DWARF in stripped programs
+var ErrNoDebugInfo = errors.New("program has no debug information")
+
+// Function is a function of the program. File and Line are the declaration
+// and are empty for functions only known from the symbol table.
+type Function struct {
+	Name string `json:"name"`
+	Low  uint64 `json:"low"`  // First address
+	High uint64 `json:"high"` // Address after the last instruction
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+// Line is a row of the DWARF line table
+type Line struct {
+	Address uint64
+	File    string
+	Line    int
+	Column  int
+	IsStmt  bool
+	End     bool // First address after a sequence
+}
+
+// Location is an address resolved to its function and source line
+type Location struct {
+	Address  uint64 `json:"address"`
+	Function string `json:"function,omitempty"`
+	Offset   uint64 `json:"offset,omitempty"` // Offset into the function
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+// String formats the location as function+offset (file:line)
+func (l Location) String() string {
+	s := fmt.Sprintf("%#x", l.Address)
+	if l.Function != "" {
+		s = l.Function
+		if l.Offset != 0 {
+			s += fmt.Sprintf("+%#x", l.Offset)
+		}
+	}
+	if l.File != "" {
+		s += fmt.Sprintf(" (%s:%d)", l.File, l.Line)
+	}
+	return s
+}
+
+// Table holds the symbols of a program
+type Table struct {
+	Machine   elf.Machine
+	Class     elf.Class
+	ByteOrder string // "little" or "big"
+	Entry     uint64
+	BuildID   string // GNU build ID, or the SHA-256 of the file without one
+
+	elf       *elf.File
+	dwarf     *dwarf.Data
+	functions []Function // Sorted by Low
+	lines     []Line     // Sorted by Address
+	objects   []elf.Symbol
+	globals   []Variable
+	locals    []Variable // In declaration order
+	types     map[string]dwarf.Offset
+	frames    *frameInfo
+	loc       []byte // .debug_loc
+	loclists  []byte // .debug_loclists
+}
+
+// Open loads the symbols of an ELF file. The file is read into memory.
+func Open(name string) (*Table, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse loads the symbols of an ELF image
+func Parse(data []byte) (*Table, error) {
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not an ELF file: %w", err)
+	}
+	table, err := New(file)
+	if err != nil {
+		return nil, err
+	}
+	if table.BuildID == "" {
+		sum := sha256.Sum256(data)
+		table.BuildID = hex.EncodeToString(sum[:])
+	}
+	return table, nil
+}
+
+// New loads the symbols of a parsed ELF file. The file must stay readable
+// while the table is used.
+func New(file *elf.File) (*Table, error) {
+	t := &Table{
+		Machine:   file.Machine,
+		Class:     file.Class,
+		ByteOrder: "little",
+		Entry:     file.Entry,
+		BuildID:   buildID(file),
+		elf:       file,
+	}
+	if file.Data == elf.ELFDATA2MSB {
+		t.ByteOrder = "big"
+	}
+
+	if data, err := file.DWARF(); err == nil {
+		t.dwarf = data
+		t.loc = sectionData(file, ".debug_loc")
+		t.loclists = sectionData(file, ".debug_loclists")
+		if err := t.loadDWARF(); err != nil {
+			return nil, fmt.Errorf("read debug information: %w", err)
+		}
+	}
+	t.loadSymbols()
+	t.loadFrameInfo()
+	sort.Slice(t.functions, func(i, j int) bool { return t.functions[i].Low < t.functions[j].Low })
+	sort.SliceStable(t.lines, func(i, j int) bool { return t.lines[i].Address < t.lines[j].Address })
+	return t, nil
+}
+
+// ELF returns the underlying ELF file
+func (t *Table) ELF() *elf.File {
+	return t.elf
+}
+
+// DWARF returns the debug information, or nil for stripped programs
+func (t *Table) DWARF() *dwarf.Data {
+	return t.dwarf
+}
+
+// HasDebugInfo reports whether the program has DWARF line information
+func (t *Table) HasDebugInfo() bool {
+	return len(t.lines) > 0
+}
+
+func (t *Table) loadDWARF() error {
+	info := sectionData(t.elf, ".debug_info")
+	r := t.dwarf.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+
+		lr, err := t.dwarf.LineReader(entry)
+		if err != nil {
+			return err
+		}
+		var files []*dwarf.LineFile
+		if lr != nil {
+			files = lr.Files()
+			var le dwarf.LineEntry
+			for {
+				if err := lr.Next(&le); err != nil {
+					if err == io.EOF {
+						break
+					}
+					return err
+				}
+				line := Line{Address: le.Address, Line: le.Line, Column: le.Column, IsStmt: le.IsStmt, End: le.EndSequence}
+				if le.File != nil {
+					line.File = le.File.Name
+				}
+				t.lines = append(t.lines, line)
+			}
+		}
+		if !entry.Children {
+			continue
+		}
+		u := &unit{version: unitVersion(info, entry.Offset), addressSize: r.AddressSize(), files: files}
+		u.base, _ = entry.Val(dwarf.AttrLowpc).(uint64)
+		if err := t.loadFunctions(r, u); err != nil {
+			return err
+		}
+	}
+}
+
+// loadFunctions reads the subprograms and global variables of the compile
+// unit r is positioned in
+func (t *Table) loadFunctions(r *dwarf.Reader, u *unit) error {
+	depth := 1
+	for depth > 0 {
+		entry, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		if entry.Tag == 0 {
+			depth--
+			continue
+		}
+		switch entry.Tag {
+		case dwarf.TagSubprogram:
+			name, ranges := t.addFunction(entry, u.files)
+			if entry.Children && len(ranges) > 0 {
+				frameBase, _ := entry.Val(dwarf.AttrFrameBase).([]byte)
+				scope := &localScope{function: name, frameBase: frameBase, ranges: ranges}
+				if err := t.loadLocals(r, u, scope); err != nil {
+					return err
+				}
+				continue
+			}
+		case dwarf.TagVariable:
+			t.addVariable(entry, u, nil, false)
+		case dwarf.TagStructType, dwarf.TagUnionType, dwarf.TagTypedef:
+			t.addType(entry)
+		}
+		if entry.Children {
+			// Nested functions are rare in C; skip the bodies
+			r.SkipChildren()
+		}
+	}
+	return nil
+}
+
+// addFunction adds a subprogram with code and returns its name and ranges
+func (t *Table) addFunction(entry *dwarf.Entry, files []*dwarf.LineFile) (string, [][2]uint64) {
+	ranges, err := t.dwarf.Ranges(entry)
+	if err != nil || len(ranges) == 0 {
+		return "", nil
+	}
+	name, _ := entry.Val(dwarf.AttrName).(string)
+	if name == "" {
+		if origin, ok := entry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset); ok {
+			name = t.entryName(origin)
+		} else if spec, ok := entry.Val(dwarf.AttrSpecification).(dwarf.Offset); ok {
+			name = t.entryName(spec)
+		}
+	}
+	fn := Function{Name: name}
+	if index, ok := entry.Val(dwarf.AttrDeclFile).(int64); ok && index >= 0 && int(index) < len(files) && files[index] != nil {
+		fn.File = files[index].Name
+	}
+	if line, ok := entry.Val(dwarf.AttrDeclLine).(int64); ok {
+		fn.Line = int(line)
+	}
+	for _, rng := range ranges {
+		fn.Low, fn.High = rng[0], rng[1]
+		t.functions = append(t.functions, fn)
+	}
+	return name, ranges
+}
+
+// addType records a named type at file scope. Declarations of incomplete
+// types are skipped.
+func (t *Table) addType(entry *dwarf.Entry) {
+	name, _ := entry.Val(dwarf.AttrName).(string)
+	if declaration, _ := entry.Val(dwarf.AttrDeclaration).(bool); name == "" || declaration {
+		return
+	}
+	if t.types == nil {
+		t.types = make(map[string]dwarf.Offset)
+	}
+	if _, exists := t.types[name]; !exists {
+		t.types[name] = entry.Offset
+	}
+}
+
+// StructType returns the structure or union with a tag or typedef name,
+// for reading data structures of the program such as those of an RTOS
+func (t *Table) StructType(name string) (*dwarf.StructType, bool) {
+	offset, ok := t.types[name]
+	if !ok {
+		return nil, false
+	}
+	typ, err := t.dwarf.Type(offset)
+	if err != nil {
+		return nil, false
+	}
+	st, ok := underlying(typ).(*dwarf.StructType)
+	if !ok || st.Incomplete {
+		return nil, false
+	}
+	return st, true
+}
+
+func (t *Table) entryName(offset dwarf.Offset) string {
+	entry := t.entryAt(offset)
+	if entry == nil {
+		return ""
+	}
+	name, _ := entry.Val(dwarf.AttrName).(string)
+	return name
+}
+
+func (t *Table) entryAt(offset dwarf.Offset) *dwarf.Entry {
+	r := t.dwarf.Reader()
+	r.Seek(offset)
+	entry, err := r.Next()
+	if err != nil {
+		return nil
+	}
+	return entry
+}
+
+// loadSymbols adds the function symbols DWARF did not describe and keeps
+// the data objects
+func (t *Table) loadSymbols() {
+	syms, err := t.elf.Symbols()
+	if err != nil {
+		return
+	}
+	known := make(map[uint64]bool, len(t.functions))
+	for _, fn := range t.functions {
+		known[fn.Low] = true
+	}
+	for _, sym := range syms {
+		if sym.Name == "" || sym.Section == elf.SHN_UNDEF {
+			continue
+		}
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_FUNC:
+			low := t.codeAddress(sym.Value)
+			if known[low] {
+				continue
+			}
+			known[low] = true
+			high := low + sym.Size
+			if sym.Size == 0 {
+				high = low + 1
+			}
+			t.functions = append(t.functions, Function{Name: sym.Name, Low: low, High: high})
+		case elf.STT_OBJECT:
+			t.objects = append(t.objects, sym)
+		}
+	}
+}
+
+// codeAddress strips the Thumb bit of ARM function symbols
+func (t *Table) codeAddress(address uint64) uint64 {
+	if t.Machine == elf.EM_ARM {
+		return address &^ 1
+	}
+	return address
+}
+
+// Functions returns the functions sorted by address
+func (t *Table) Functions() []Function {
+	return t.functions
+}
+
+// FunctionAt returns the function containing an address
+func (t *Table) FunctionAt(address uint64) (Function, bool) {
+	i := sort.Search(len(t.functions), func(i int) bool { return t.functions[i].Low > address }) - 1
+	// Functions rarely overlap; look at a few candidates below the address
+	for j := i; j >= 0 && j > i-4; j-- {
+		if fn := t.functions[j]; address >= fn.Low && address < fn.High {
+			return fn, true
+		}
+	}
+	return Function{}, false
+}
+
+// Function returns a function by name
+func (t *Table) Function(name string) (Function, bool) {
+	for _, fn := range t.functions {
+		if fn.Name == name {
+			return fn, true
+		}
+	}
+	return Function{}, false
+}
+
+// Lines returns the rows of the line table sorted by address
+func (t *Table) Lines() []Line {
+	return t.lines
+}
+
+// LineAt returns the line table row covering an address
+func (t *Table) LineAt(address uint64) (Line, bool) {
+	i := sort.Search(len(t.lines), func(i int) bool { return t.lines[i].Address > address }) - 1
+	if i < 0 || t.lines[i].End {
+		return Line{}, false
+	}
+	return t.lines[i], true
+}
+
+// Lookup resolves an address to its function and source line
+func (t *Table) Lookup(address uint64) Location {
+	loc := Location{Address: address}
+	if fn, ok := t.FunctionAt(address); ok {
+		loc.Function = fn.Name
+		loc.Offset = address - fn.Low
+	}
+	if line, ok := t.LineAt(address); ok {
+		loc.File, loc.Line, loc.Column = line.File, line.Line, line.Column
+	}
+	return loc
+}
+
+// Files returns the source files of the line table
+func (t *Table) Files() []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, line := range t.lines {
+		if line.File != "" && !seen[line.File] {
+			seen[line.File] = true
+			files = append(files, line.File)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// LineAddresses returns the statement addresses of a source line. File
+// matches the full path or its trailing components, so "main.c" and
+// "src/main.c" both find "/build/src/main.c". When the line has no code,
+// the next line that has is used and returned.
+func (t *Table) LineAddresses(file string, line int) (int, []uint64, error) {
+	if !t.HasDebugInfo() {
+		return 0, nil, ErrNoDebugInfo
+	}
+
+	best := 0
+	var addresses []uint64
+	for _, row := range t.lines {
+		if !row.IsStmt || row.End || row.Line < line || !sameFile(row.File, file) {
+			continue
+		}
+		switch {
+		case best == 0 || row.Line < best:
+			best = row.Line
+			addresses = []uint64{row.Address}
+		case row.Line == best:
+			addresses = append(addresses, row.Address)
+		}
+	}
+	if best == 0 {
+		return 0, nil, fmt.Errorf("no code at %s:%d", file, line)
+	}
+
+	// A line split over several rows of one function needs one breakpoint,
+	// at its lowest address
+	var distinct []uint64
+	seen := make(map[string]bool)
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	for _, address := range addresses {
+		fn, _ := t.FunctionAt(address)
+		key := fmt.Sprintf("%s@%#x", fn.Name, fn.Low)
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, address)
+		}
+	}
+	return best, distinct, nil
+}
+
+// BreakpointAddress returns the address to break at for a function: the
+// first statement after the prologue when there is line information
+func (t *Table) BreakpointAddress(fn Function) uint64 {
+	i := sort.Search(len(t.lines), func(i int) bool { return t.lines[i].Address > fn.Low })
+	first := -1
+	for ; i < len(t.lines) && t.lines[i].Address < fn.High; i++ {
+		row := t.lines[i]
+		if row.End || !row.IsStmt {
+			continue
+		}
+		if first < 0 {
+			if start, ok := t.LineAt(fn.Low); ok && row.Line != start.Line {
+				return row.Address
+			}
+			first = row.Line
+			continue
+		}
+		if row.Line != first {
+			return row.Address
+		}
+	}
+	return fn.Low
+}
+
+// Resolve resolves a breakpoint location to addresses: a function name
+// (after its prologue), file:line, or an address such as "0x08000120",
+// optionally prefixed with "*"
+func (t *Table) Resolve(location string) ([]uint64, error) {
+	location = strings.TrimSpace(location)
+	if text := strings.TrimPrefix(location, "*"); text != "" && text[0] >= '0' && text[0] <= '9' {
+		address, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", text)
+		}
+		return []uint64{address}, nil
+	}
+	if i := strings.LastIndexByte(location, ':'); i > 0 {
+		if line, err := strconv.Atoi(location[i+1:]); err == nil && line > 0 {
+			_, addresses, err := t.LineAddresses(location[:i], line)
+			return addresses, err
+		}
+	}
+	fn, ok := t.Function(location)
+	if !ok {
+		return nil, fmt.Errorf("no function %q", location)
+	}
+	return []uint64{t.BreakpointAddress(fn)}, nil
+}
+
+// Symbol returns the address and size of a data or function symbol
+func (t *Table) Symbol(name string) (uint64, uint64, bool) {
+	for _, sym := range t.objects {
+		if sym.Name == name {
+			return sym.Value, sym.Size, true
+		}
+	}
+	if fn, ok := t.Function(name); ok {
+		return fn.Low, fn.High - fn.Low, true
+	}
+	return 0, 0, false
+}
+
+func sameFile(full, name string) bool {
+	full, name = path.Clean(strings.ReplaceAll(full, "\\", "/")), path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if full == name {
+		return true
+	}
+	return strings.HasSuffix(full, "/"+strings.TrimPrefix(name, "./"))
+}
+
+// sectionData returns the contents of a section, or nil
+func sectionData(file *elf.File, name string) []byte {
+	section := file.Section(name)
+	if section == nil || section.Type == elf.SHT_NOBITS {
+		return nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// buildID returns the GNU build ID note of the file
+func buildID(file *elf.File) string {
+	section := file.Section(".note.gnu.build-id")
+	if section == nil {
+		return ""
+	}
+	data, err := section.Data()
+	if err != nil || len(data) < 16 {
+		return ""
+	}
+	order := file.ByteOrder
+	nameSize := order.Uint32(data[0:4])
+	descSize := order.Uint32(data[4:8])
+	offset := 12 + (nameSize+3)&^3
+	if uint64(offset)+uint64(descSize) > uint64(len(data)) {
+		return ""
+	}
+	return hex.EncodeToString(data[offset : offset+descSize])
+}
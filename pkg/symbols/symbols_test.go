@@ -0,0 +1,146 @@
+package symbols
+
+import (
+	"debug/elf"
+	"testing"
+)
+
+// testdata/firmware.elf is built from testdata/firmware.c, see there
+func openFixture(t *testing.T) *Table {
+	t.Helper()
+	table, err := Open("testdata/firmware.elf")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return table
+}
+
+func TestOpen(t *testing.T) {
+	table := openFixture(t)
+	if table.Machine != elf.EM_X86_64 || table.ByteOrder != "little" || table.Entry != 0x401070 {
+		t.Errorf("unexpected header %v %s %#x", table.Machine, table.ByteOrder, table.Entry)
+	}
+	if !table.HasDebugInfo() || table.DWARF() == nil {
+		t.Fatal("expected debug information")
+	}
+	if table.BuildID == "" {
+		t.Error("expected a build ID")
+	}
+	if files := table.Files(); len(files) != 1 || files[0] != "/build/firmware.c" {
+		t.Errorf("unexpected files %v", files)
+	}
+
+	var names []string
+	for _, fn := range table.Functions() {
+		names = append(names, fn.Name)
+	}
+	if len(names) != 3 || names[0] != "scale" || names[1] != "average" || names[2] != "_start" {
+		t.Errorf("unexpected functions %v", names)
+	}
+
+	if _, err := Parse([]byte("not an elf")); err == nil {
+		t.Error("expected error for non-ELF data")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := openFixture(t)
+
+	loc := table.Lookup(0x401035)
+	if loc.Function != "average" || loc.Offset != 0x1c || loc.File != "/build/firmware.c" || loc.Line != 26 {
+		t.Errorf("unexpected location %+v", loc)
+	}
+	if got := loc.String(); got != "average+0x1c (/build/firmware.c:26)" {
+		t.Errorf("String() = %q", got)
+	}
+
+	fn, ok := table.Function("scale")
+	if !ok || fn.Low != 0x401000 || fn.High != 0x401019 || fn.File != "/build/firmware.c" || fn.Line != 16 {
+		t.Errorf("unexpected function %+v", fn)
+	}
+	if _, ok := table.FunctionAt(0x500000); ok {
+		t.Error("address outside the program resolved to a function")
+	}
+	if loc := table.Lookup(0x500000); loc.Function != "" || loc.Line != 0 {
+		t.Errorf("unexpected location %+v", loc)
+	}
+}
+
+func TestLineAddresses(t *testing.T) {
+	table := openFixture(t)
+
+	tests := []struct {
+		file      string
+		line      int
+		wantLine  int
+		addresses []uint64
+	}{
+		{"firmware.c", 26, 26, []uint64{0x401035}},
+		{"src/../firmware.c", 18, 18, []uint64{0x40100a}},
+		{"firmware.c", 34, 35, []uint64{0x40107f}}, // No code on "for (;;)"
+		{"firmware.c", 25, 25, []uint64{0x40102c}}, // Loop head split over several rows
+	}
+	for _, tt := range tests {
+		line, addresses, err := table.LineAddresses(tt.file, tt.line)
+		if err != nil {
+			t.Errorf("%s:%d: %v", tt.file, tt.line, err)
+			continue
+		}
+		if line != tt.wantLine || len(addresses) != len(tt.addresses) || addresses[0] != tt.addresses[0] {
+			t.Errorf("%s:%d = line %d at %x, want line %d at %x", tt.file, tt.line, line, addresses, tt.wantLine, tt.addresses)
+		}
+	}
+
+	if _, _, err := table.LineAddresses("firmware.c", 100); err == nil {
+		t.Error("expected error past the end of the file")
+	}
+	if _, _, err := table.LineAddresses("other.c", 1); err == nil {
+		t.Error("expected error for an unknown file")
+	}
+}
+
+func TestBreakpointAddress(t *testing.T) {
+	table := openFixture(t)
+	for name, want := range map[string]uint64{"scale": 0x40100a, "average": 0x401025, "_start": 0x401078} {
+		fn, _ := table.Function(name)
+		if got := table.BreakpointAddress(fn); got != want {
+			t.Errorf("BreakpointAddress(%s) = %#x, want %#x", name, got, want)
+		}
+	}
+
+	addr, size, ok := table.Symbol("sensors")
+	if !ok || addr == 0 || size != 32 {
+		t.Errorf("Symbol(sensors) = %#x, %d, %v", addr, size, ok)
+	}
+}
+
+func TestStructType(t *testing.T) {
+	table := openFixture(t)
+	st, ok := table.StructType("sensor")
+	if !ok || st.ByteSize != 16 || len(st.Field) != 3 || st.Field[2].Name != "scale" || st.Field[2].ByteOffset != 12 {
+		t.Errorf("StructType(sensor) = %+v, %v", st, ok)
+	}
+	if _, ok := table.StructType("missing"); ok {
+		t.Error("StructType found a missing type")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	table := openFixture(t)
+	for location, want := range map[string]uint64{
+		"average":        0x401025,
+		" firmware.c:26": 0x401035,
+		"0x401019":       0x401019,
+		"*0x401019":      0x401019,
+	} {
+		addresses, err := table.Resolve(location)
+		if err != nil || len(addresses) != 1 || addresses[0] != want {
+			t.Errorf("Resolve(%q) = %x, %v; want %#x", location, addresses, err, want)
+		}
+	}
+	for _, location := range []string{"main", "firmware.c:100", "*0xzz", ""} {
+		if _, err := table.Resolve(location); err == nil {
+			t.Errorf("Resolve(%q) succeeded", location)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+/* Test fixture for pkg/symbols; rebuild with
+ *   gcc -g -O0 -fno-pie -no-pie -nostdlib -static \
+ *       -fdebug-prefix-map=$PWD=/build -o firmware.elf firmware.c
+ */
+
+struct sensor {
+	int id;
+	unsigned short samples[4];
+	float scale;
+};
+
+volatile unsigned int ticks;
+struct sensor sensors[2] = {{1, {10, 20, 30, 40}, 0.5f}, {2, {0}, 1.0f}};
+const char *banner = "virServer";
+
+static int scale(int value, int factor)
+{
+	int result = value * factor;
+	return result;
+}
+
+int average(const struct sensor *s)
+{
+	int sum = 0;
+	for (int i = 0; i < 4; i++) {
+		sum += s->samples[i];
+	}
+	return scale(sum, 1) / 4;
+}
+
+void _start(void)
+{
+	int total = 0;
+	for (;;) {
+		ticks++;
+		total += average(&sensors[0]);
+	}
+}
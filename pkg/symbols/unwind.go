@@ -0,0 +1,311 @@
+package symbols
+
+import (
+	"debug/elf"
+	"fmt"
+)
+
+// Backtrace limits
+const (
+	DefaultBacktraceDepth = 64
+	MaxBacktraceDepth     = 256
+)
+
+// Ways a frame was recovered from the frame it called
+const (
+	UnwindCFI          = "cfi"           // Call frame information
+	UnwindException    = "exception"     // Cortex-M exception stack frame
+	UnwindLinkRegister = "link-register" // Return address still in lr/ra, assuming a leaf function
+)
+
+// StackFrame is a frame of a backtrace. Address is the frame's pc: where
+// the innermost frame stopped, the return address in its callers.
+type StackFrame struct {
+	Level int `json:"level"`
+	Location
+	SP     uint64 `json:"sp"`
+	CFA    uint64 `json:"cfa,omitempty"` // Canonical frame address, with call frame information
+	Unwind string `json:"unwind,omitempty"`
+
+	Frame *Frame `json:"-"` // For reading the frame's variables
+}
+
+// Backtrace is the call stack of a halted program
+type Backtrace struct {
+	Frames []StackFrame `json:"frames"`
+	Stop   string       `json:"stop"` // Why unwinding ended
+}
+
+// stackPointer returns the DWARF number of the stack pointer
+func (t *Table) stackPointer() int {
+	switch t.Machine {
+	case elf.EM_ARM:
+		return 13
+	case elf.EM_AARCH64:
+		return 31
+	case elf.EM_RISCV:
+		return 2
+	case elf.EM_X86_64:
+		return 7
+	case elf.EM_386:
+		return 4
+	}
+	return -1
+}
+
+// linkRegister returns the name of the register holding the return address
+// on entry to a function, or "" when the call stores it on the stack
+func (t *Table) linkRegister() string {
+	switch t.Machine {
+	case elf.EM_ARM:
+		return "lr"
+	case elf.EM_AARCH64:
+		return "x30"
+	case elf.EM_RISCV:
+		return "ra"
+	}
+	return ""
+}
+
+// Backtrace unwinds the stack from the innermost frame, using the call
+// frame information of .debug_frame or .eh_frame. On Cortex-M, exception
+// handlers are unwound through the exception stack frame into the code they
+// interrupted. Code without call frame information is only unwound when
+// its return address is still in the link register.
+func (t *Table) Backtrace(frame *Frame, depth int) *Backtrace {
+	if depth <= 0 {
+		depth = DefaultBacktraceDepth
+	}
+	if depth > MaxBacktraceDepth {
+		depth = MaxBacktraceDepth
+	}
+	trace := &Backtrace{}
+	unwind := ""
+	for len(trace.Frames) < depth {
+		sf := StackFrame{Level: len(trace.Frames), Location: t.Lookup(frame.lookupPC()), Unwind: unwind, Frame: frame}
+		if frame.caller {
+			sf.Address = frame.PC
+			if sf.Function != "" {
+				sf.Offset++
+			}
+		}
+		sf.SP = frame.Registers[t.RegisterName(t.stackPointer())]
+
+		caller, how, err := t.unwindFrame(frame, len(trace.Frames) == 0 || unwind == UnwindException)
+		if frame.hasCFA {
+			sf.CFA = frame.cfa
+		}
+		trace.Frames = append(trace.Frames, sf)
+		if err != nil {
+			trace.Stop = err.Error()
+			return trace
+		}
+		if caller.PC == 0 {
+			trace.Stop = "outermost frame"
+			return trace
+		}
+		// The stack grows down: a caller's frame lies above its callee's,
+		// except across exceptions which may switch stacks
+		callerSP := caller.Registers[t.RegisterName(t.stackPointer())]
+		if how != UnwindException && (callerSP < sf.SP || callerSP == sf.SP && caller.PC == frame.PC) {
+			trace.Stop = fmt.Sprintf("stack pointer %#x of the caller does not increase; corrupt stack?", callerSP)
+			return trace
+		}
+		frame, unwind = caller, how
+	}
+	trace.Stop = fmt.Sprintf("frame limit %d reached", depth)
+	return trace
+}
+
+// unwindFrame recovers the calling frame. leaf allows the link register
+// fallback for frames that may not have saved their return address yet.
+func (t *Table) unwindFrame(frame *Frame, leaf bool) (*Frame, string, error) {
+	var row *frameRow
+	var c *cie
+	err := ErrNoFrameInfo
+	if t.frames != nil {
+		row, c, err = t.frames.row(frame.lookupPC())
+	}
+	if err != nil {
+		if t.Machine == elf.EM_ARM && isExcReturn(frame.Registers["lr"]) && leaf {
+			// Handlers without call frame information, assuming nothing
+			// was pushed yet
+			caller, err := t.exceptionFrame(frame, frame.Registers["sp"], frame.Registers["lr"])
+			return caller, UnwindException, err
+		}
+		lr := t.linkRegister()
+		if value, ok := frame.Registers[lr]; ok && leaf && lr != "" {
+			caller := t.callerFrame(frame, frame.Registers)
+			caller.PC = t.codeAddress(value)
+			caller.Registers[t.PCRegister()] = caller.PC
+			return caller, UnwindLinkRegister, nil
+		}
+		return nil, "", fmt.Errorf("no call frame information at %#x", frame.PC)
+	}
+
+	cfa, err := t.frameCFA(frame, row)
+	if err != nil {
+		return nil, "", err
+	}
+	registers := make(map[string]uint64, len(frame.Registers))
+	for name, value := range frame.Registers {
+		registers[name] = value
+	}
+	registers[t.RegisterName(t.stackPointer())] = cfa
+	returnAddress, hasReturn := uint64(0), true
+	if _, ok := row.rules[c.returnReg]; !ok {
+		returnAddress, err = t.register(frame, c.returnReg)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	for regnum, rule := range row.rules {
+		value, defined, err := t.applyRule(frame, cfa, regnum, rule)
+		if err != nil {
+			return nil, "", fmt.Errorf("recover register %d: %w", regnum, err)
+		}
+		if regnum == c.returnReg {
+			returnAddress, hasReturn = value, defined
+		}
+		name := t.RegisterName(regnum)
+		if name == "" {
+			continue
+		}
+		if defined {
+			registers[name] = value
+		} else {
+			delete(registers, name)
+		}
+	}
+
+	if !hasReturn {
+		return &Frame{Registers: registers, Memory: frame.Memory, caller: true}, UnwindCFI, nil
+	}
+	if t.Machine == elf.EM_ARM && isExcReturn(returnAddress) {
+		caller, err := t.exceptionFrame(frame, cfa, returnAddress)
+		return caller, UnwindException, err
+	}
+	caller := t.callerFrame(frame, registers)
+	caller.PC = t.codeAddress(returnAddress)
+	caller.Registers[t.PCRegister()] = caller.PC
+	return caller, UnwindCFI, nil
+}
+
+func (t *Table) callerFrame(frame *Frame, registers map[string]uint64) *Frame {
+	copied := make(map[string]uint64, len(registers))
+	for name, value := range registers {
+		copied[name] = value
+	}
+	return &Frame{Registers: copied, Memory: frame.Memory, caller: true}
+}
+
+// applyRule recovers a register of the caller by a CFI rule
+func (t *Table) applyRule(frame *Frame, cfa uint64, regnum int, rule frameRule) (uint64, bool, error) {
+	switch rule.kind {
+	case ruleUndefined:
+		return 0, false, nil
+	case ruleSameValue:
+		value, err := t.register(frame, regnum)
+		return value, err == nil, nil
+	case ruleOffset:
+		value, err := t.readWord(frame, uint64(int64(cfa)+rule.offset))
+		return value, err == nil, err
+	case ruleValOffset:
+		return uint64(int64(cfa) + rule.offset), true, nil
+	case ruleRegister:
+		value, err := t.register(frame, rule.reg)
+		return value, err == nil, err
+	case ruleExpression, ruleValExpression:
+		loc, err := t.evaluate(rule.expr, frame, nil, []uint64{cfa})
+		if err != nil {
+			return 0, false, err
+		}
+		if rule.kind == ruleValExpression {
+			return loc.address, true, nil
+		}
+		value, err := t.readWord(frame, loc.address)
+		return value, err == nil, err
+	}
+	return 0, false, fmt.Errorf("unknown rule %d", rule.kind)
+}
+
+// readWord reads an address-sized value
+func (t *Table) readWord(frame *Frame, address uint64) (uint64, error) {
+	size := 4
+	if t.Class == elf.ELFCLASS64 {
+		size = 8
+	}
+	if frame.Memory == nil {
+		return 0, fmt.Errorf("no memory access")
+	}
+	data, err := frame.Memory(address, size)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < size {
+		return 0, fmt.Errorf("short read at %#x", address)
+	}
+	return decodeUint(data[:size], t.elf.ByteOrder), nil
+}
+
+// isExcReturn reports whether a Cortex-M return address is an EXC_RETURN
+// value, which returns from an exception
+func isExcReturn(address uint64) bool {
+	return address&0xffffff00 == 0xffffff00 && address <= 0xffffffff
+}
+
+// Cortex-M exception stack frame layout
+const (
+	excFrameSize   = 0x20 // r0-r3, r12, lr, pc, xpsr
+	excFrameSizeFP = 0x68 // With s0-s15, fpscr and a reserved word
+	excReturnPSP   = 1 << 2
+	excReturnNoFP  = 1 << 4
+	xpsrAligned    = 1 << 9 // The stack was realigned on entry
+)
+
+// exceptionFrame recovers the code interrupted by a Cortex-M exception
+// from the frame the processor stacked at sp. EXC_RETURN tells whether it
+// is on the process stack and whether it has floating-point registers.
+func (t *Table) exceptionFrame(frame *Frame, sp, excReturn uint64) (*Frame, error) {
+	if excReturn&excReturnPSP != 0 {
+		psp, ok := frame.Registers["psp"]
+		if !ok {
+			return nil, fmt.Errorf("exception frame on the process stack, psp not available")
+		}
+		sp = psp
+	}
+	if frame.Memory == nil {
+		return nil, fmt.Errorf("no memory access")
+	}
+	data, err := frame.Memory(sp, excFrameSize)
+	if err != nil {
+		return nil, fmt.Errorf("read exception frame at %#x: %w", sp, err)
+	}
+	if len(data) < excFrameSize {
+		return nil, fmt.Errorf("short read of the exception frame at %#x", sp)
+	}
+	word := func(i int) uint64 { return decodeUint(data[4*i:4*i+4], t.elf.ByteOrder) }
+
+	registers := make(map[string]uint64, len(frame.Registers))
+	for name, value := range frame.Registers {
+		registers[name] = value
+	}
+	for i, name := range []string{"r0", "r1", "r2", "r3", "r12", "lr", "pc", "xpsr"} {
+		registers[name] = word(i)
+	}
+	size := uint64(excFrameSize)
+	if excReturn&excReturnNoFP == 0 {
+		size = excFrameSizeFP
+	}
+	if registers["xpsr"]&xpsrAligned != 0 {
+		size += 4
+	}
+	registers["sp"] = sp + size
+	if excReturn&excReturnPSP != 0 {
+		registers["psp"] = sp + size
+	}
+	registers["pc"] = t.codeAddress(registers["pc"])
+	// The interrupted instruction has not executed: it is not a return
+	// address
+	return &Frame{PC: registers["pc"], Registers: registers, Memory: frame.Memory}, nil
+}
@@ -0,0 +1,115 @@
+package symbols
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBacktrace(t *testing.T) {
+	table := openFixture(t)
+
+	// _start -> average -> scale, stopped in scale after its prologue. Each
+	// frame holds the return address at CFA-8 and the caller's rbp at
+	// CFA-16.
+	memory := &fixtureMemory{file: table.ELF(), stack: make(map[uint64]byte)}
+	memory.put(0x5fc0, 0x6000, 8)   // rbp of average
+	memory.put(0x5fc8, 0x401063, 8) // Return into average
+	memory.put(0x6000, 0x6100, 8)   // rbp of _start
+	memory.put(0x6008, 0x401098, 8) // Return into _start
+	memory.put(0x6100, 0, 8)
+	memory.put(0x6108, 0, 8) // No caller of _start
+
+	frame, err := table.NewFrame(map[string]uint64{"rip": 0x40100a, "rsp": 0x5fc0, "rbp": 0x5fc0}, memory.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := table.Backtrace(frame, 0)
+	want := []struct {
+		function string
+		address  uint64
+		line     int
+		sp, cfa  uint64
+		unwind   string
+	}{
+		{"scale", 0x40100a, 18, 0x5fc0, 0x5fd0, ""},
+		{"average", 0x401063, 28, 0x5fd0, 0x6010, UnwindCFI},
+		{"_start", 0x401098, 36, 0x6010, 0x6110, UnwindCFI},
+	}
+	if len(trace.Frames) != len(want) || trace.Stop != "outermost frame" {
+		t.Fatalf("unexpected backtrace %+v", trace)
+	}
+	for i, w := range want {
+		f := trace.Frames[i]
+		if f.Level != i || f.Function != w.function || f.Address != w.address || f.Line != w.line || f.SP != w.sp || f.CFA != w.cfa || f.Unwind != w.unwind {
+			t.Errorf("frame %d = %+v", i, f)
+		}
+	}
+	if f := trace.Frames[1]; f.Offset != 0x4a {
+		t.Errorf("offset of the return address = %#x", f.Offset)
+	}
+
+	// Variables of callers are read in their frame
+	memory.put(0x6010-20, 60, 4)
+	if value, err := table.ReadVariable(trace.Frames[1].Frame, "sum"); err != nil || value.Value != int64(60) {
+		t.Errorf("sum in average = %+v, %v", value, err)
+	}
+
+	if trace := table.Backtrace(frame, 2); len(trace.Frames) != 2 || trace.Stop != "frame limit 2 reached" {
+		t.Errorf("unexpected limited backtrace %+v", trace)
+	}
+
+	// A frame pointer below the stack pointer means a corrupt stack
+	memory.put(0x5018, 0x401063, 8)
+	frame, _ = table.NewFrame(map[string]uint64{"rip": 0x40100a, "rsp": 0x6000, "rbp": 0x5010}, memory.read)
+	if trace := table.Backtrace(frame, 0); len(trace.Frames) != 1 || trace.Stop == "" {
+		t.Errorf("unwound a corrupt stack: %+v", trace)
+	}
+}
+
+func TestBacktraceCortexM(t *testing.T) {
+	// A Cortex-M program without call frame information, stopped at the
+	// start of a fault handler that interrupted a leaf function
+	table := &Table{Machine: elf.EM_ARM, Class: elf.ELFCLASS32, elf: &elf.File{FileHeader: elf.FileHeader{ByteOrder: binary.LittleEndian}}}
+	table.functions = []Function{
+		{Name: "caller", Low: 0x08000180, High: 0x08000280},
+		{Name: "leaf", Low: 0x08000280, High: 0x08000380},
+		{Name: "HardFault_Handler", Low: 0x08000400, High: 0x08000420},
+	}
+	memory := &fixtureMemory{stack: make(map[uint64]byte)}
+	for i, value := range []uint64{1, 2, 3, 4, 12, 0x08000201, 0x08000300, 0x01000000} {
+		memory.put(0x20001000+uint64(4*i), value, 4)
+	}
+	registers := map[string]uint64{"pc": 0x08000400, "sp": 0x20001000, "lr": 0xfffffff9}
+	frame, err := table.NewFrame(registers, memory.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace := table.Backtrace(frame, 0)
+	if len(trace.Frames) != 3 {
+		t.Fatalf("unexpected backtrace %+v", trace)
+	}
+	if f := trace.Frames[1]; f.Function != "leaf" || f.Address != 0x08000300 || f.Offset != 0x80 || f.SP != 0x20001020 || f.Unwind != UnwindException {
+		t.Errorf("interrupted frame = %+v", f)
+	}
+	if f := trace.Frames[2]; f.Function != "caller" || f.Address != 0x08000200 || f.Unwind != UnwindLinkRegister {
+		t.Errorf("caller frame = %+v", f)
+	}
+	if trace.Stop != "no call frame information at 0x8000200" {
+		t.Errorf("unexpected stop %q", trace.Stop)
+	}
+
+	// Exceptions from thread mode stack on the process stack
+	registers["lr"] = 0xfffffffd
+	frame, _ = table.NewFrame(registers, memory.read)
+	if trace := table.Backtrace(frame, 0); len(trace.Frames) != 1 || trace.Stop == "" {
+		t.Errorf("unwound without psp: %+v", trace)
+	}
+	registers["psp"] = 0x20001000
+	registers["sp"] = 0x20002000
+	frame, _ = table.NewFrame(registers, memory.read)
+	if trace := table.Backtrace(frame, 0); len(trace.Frames) != 3 || trace.Frames[1].SP != 0x20001020 {
+		t.Errorf("unexpected backtrace from the process stack %+v", trace)
+	}
+}
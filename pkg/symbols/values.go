@@ -0,0 +1,542 @@
+package symbols
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits of reading values
+const (
+	maxValueDepth   = 4   // Nesting of aggregates read
+	maxElements     = 100 // Array elements read
+	maxStringLength = 256 // Bytes of C strings
+)
+
+// Value is a variable, or a part of one, read from the target. Scalars have
+// a Value; structures and arrays have Children. Text is a readable form of
+// characters, enumerators and strings.
+type Value struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Address  uint64      `json:"address,omitempty"`  // In memory
+	Register string      `json:"register,omitempty"` // Held in a register
+	Value    interface{} `json:"value,omitempty"`
+	Text     string      `json:"text,omitempty"`
+	Children []Value     `json:"children,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// object is a typed object of the target: in memory, or given by its bytes.
+// Objects in memory may carry their bytes when they were read already.
+type object struct {
+	typ      dwarf.Type
+	inMemory bool
+	address  uint64
+	register string
+	data     []byte
+}
+
+// ReadVariable reads a variable by expression: a name followed by .field,
+// ->field and [index] selectors, optionally prefixed with * to dereference.
+// Locals in scope at the frame's address shadow globals.
+func (t *Table) ReadVariable(frame *Frame, expression string) (Value, error) {
+	p, err := parsePath(expression)
+	if err != nil {
+		return Value{}, err
+	}
+	v, ok := t.LookupVariable(p.name, frame.lookupPC())
+	if !ok {
+		return Value{}, fmt.Errorf("no variable %s in scope", p.name)
+	}
+	obj, err := t.objectOf(frame, &v)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %w", p.name, err)
+	}
+	for _, sel := range p.selectors {
+		if obj, err = t.selectMember(frame, obj, sel); err != nil {
+			return Value{}, fmt.Errorf("%s: %w", expression, err)
+		}
+	}
+	for i := 0; i < p.derefs; i++ {
+		if obj, err = t.deref(frame, obj); err != nil {
+			return Value{}, fmt.Errorf("%s: %w", expression, err)
+		}
+	}
+	return t.readValue(frame, strings.TrimSpace(expression), obj, 0), nil
+}
+
+// ReadLocals reads the parameters and local variables in scope at the
+// frame's address
+func (t *Table) ReadLocals(frame *Frame) []Value {
+	return t.readVariables(frame, t.Locals(frame.lookupPC()))
+}
+
+// ReadGlobals reads the global variables
+func (t *Table) ReadGlobals(frame *Frame) []Value {
+	return t.readVariables(frame, t.globals)
+}
+
+func (t *Table) readVariables(frame *Frame, variables []Variable) []Value {
+	values := make([]Value, 0, len(variables))
+	for i := range variables {
+		v := &variables[i]
+		obj, err := t.objectOf(frame, v)
+		if err != nil {
+			values = append(values, Value{Name: v.Name, Type: v.Type, Error: err.Error()})
+			continue
+		}
+		values = append(values, t.readValue(frame, v.Name, obj, 0))
+	}
+	return values
+}
+
+// objectOf locates a variable in a frame
+func (t *Table) objectOf(frame *Frame, v *Variable) (object, error) {
+	obj := object{typ: v.typ}
+	if v.constant != nil {
+		switch c := v.constant.(type) {
+		case []byte:
+			obj.data = c
+		case int64:
+			obj.data = t.encode(uint64(c), int(v.Size))
+		default:
+			return obj, fmt.Errorf("unsupported constant %T", c)
+		}
+		return obj, nil
+	}
+
+	expr, err := t.locationAt(v, frame.lookupPC())
+	if err != nil {
+		return obj, err
+	}
+	loc, err := t.evaluate(expr, frame, v.frameBase, nil)
+	if err != nil {
+		return obj, err
+	}
+	switch loc.kind {
+	case placeMemory:
+		obj.inMemory, obj.address = true, loc.address
+	case placeRegister:
+		value, err := t.register(frame, loc.reg)
+		if err != nil {
+			return obj, err
+		}
+		obj.register = t.RegisterName(loc.reg)
+		obj.data = t.encode(value, int(v.Size))
+	case placeValue:
+		obj.data = loc.data
+		if t.elf.ByteOrder == binary.BigEndian && int64(len(obj.data)) > v.Size {
+			obj.data = obj.data[int64(len(obj.data))-v.Size:]
+		}
+	}
+	return obj, nil
+}
+
+// encode stores a register or constant value in the bytes of an object of
+// size bytes
+func (t *Table) encode(value uint64, size int) []byte {
+	if size <= 0 || size > 8 {
+		size = 8
+	}
+	data := make([]byte, 8)
+	t.elf.ByteOrder.PutUint64(data, value)
+	if t.elf.ByteOrder == binary.BigEndian {
+		return data[8-size:]
+	}
+	return data[:size]
+}
+
+// bytes returns size bytes of an object starting at offset
+func (t *Table) bytes(frame *Frame, obj object, offset, size int64) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("object of unknown size")
+	}
+	if obj.data != nil && offset+size <= int64(len(obj.data)) {
+		return obj.data[offset : offset+size], nil
+	}
+	if obj.inMemory {
+		if frame.Memory == nil {
+			return nil, fmt.Errorf("no memory access")
+		}
+		data, err := frame.Memory(obj.address+uint64(offset), int(size))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) < size {
+			return nil, fmt.Errorf("short read at %#x", obj.address+uint64(offset))
+		}
+		return data[:size], nil
+	}
+	return nil, errOptimizedOut
+}
+
+// member returns the part of an object at offset
+func member(obj object, typ dwarf.Type, offset int64) object {
+	part := object{typ: typ, inMemory: obj.inMemory, register: obj.register}
+	if obj.inMemory {
+		part.address = obj.address + uint64(offset)
+	}
+	if obj.data != nil && offset <= int64(len(obj.data)) {
+		part.data = obj.data[offset:]
+	}
+	return part
+}
+
+// deref follows a pointer object
+func (t *Table) deref(frame *Frame, obj object) (object, error) {
+	ptr, ok := underlying(obj.typ).(*dwarf.PtrType)
+	if !ok {
+		return object{}, fmt.Errorf("%s is not a pointer", typeName(obj.typ))
+	}
+	data, err := t.bytes(frame, obj, 0, ptr.Size())
+	if err != nil {
+		return object{}, err
+	}
+	address := decodeUint(data, t.elf.ByteOrder)
+	if address == 0 {
+		return object{}, fmt.Errorf("null pointer")
+	}
+	if _, ok := ptr.Type.(*dwarf.VoidType); ok || ptr.Type == nil {
+		return object{}, fmt.Errorf("cannot dereference void *")
+	}
+	return object{typ: ptr.Type, inMemory: true, address: address}, nil
+}
+
+func (t *Table) selectMember(frame *Frame, obj object, sel selector) (object, error) {
+	var err error
+	if sel.arrow {
+		if obj, err = t.deref(frame, obj); err != nil {
+			return object{}, err
+		}
+	}
+	switch ty := underlying(obj.typ).(type) {
+	case *dwarf.StructType:
+		if sel.field == "" {
+			return object{}, fmt.Errorf("cannot index %s", typeName(obj.typ))
+		}
+		for _, field := range ty.Field {
+			if field.Name == sel.field {
+				if field.BitSize > 0 {
+					return object{}, fmt.Errorf("cannot select bit field %s", field.Name)
+				}
+				return member(obj, field.Type, field.ByteOffset), nil
+			}
+		}
+		return object{}, fmt.Errorf("%s has no member %s", typeName(obj.typ), sel.field)
+	case *dwarf.ArrayType:
+		if sel.field != "" {
+			return object{}, fmt.Errorf("%s has no members", typeName(obj.typ))
+		}
+		if sel.index < 0 || (ty.Count >= 0 && sel.index >= ty.Count) {
+			return object{}, fmt.Errorf("index %d out of range [0, %d)", sel.index, ty.Count)
+		}
+		return member(obj, ty.Type, sel.index*ty.Type.Size()), nil
+	case *dwarf.PtrType:
+		if sel.field != "" {
+			return object{}, fmt.Errorf("%s is a pointer; use ->", typeName(obj.typ))
+		}
+		target, err := t.deref(frame, obj)
+		if err != nil {
+			return object{}, err
+		}
+		return member(target, target.typ, sel.index*target.typ.Size()), nil
+	}
+	if sel.field != "" {
+		return object{}, fmt.Errorf("%s has no members", typeName(obj.typ))
+	}
+	return object{}, fmt.Errorf("cannot index %s", typeName(obj.typ))
+}
+
+// readValue reads an object and, within the depth limit, its members
+func (t *Table) readValue(frame *Frame, name string, obj object, depth int) Value {
+	v := Value{Name: name, Type: typeName(obj.typ), Register: obj.register}
+	if obj.inMemory {
+		v.Address = obj.address
+	}
+
+	switch ty := underlying(obj.typ).(type) {
+	case *dwarf.StructType:
+		if ty.Incomplete {
+			v.Error = "incomplete type"
+			return v
+		}
+		if depth >= maxValueDepth {
+			v.Text = "{...}"
+			return v
+		}
+		for _, field := range ty.Field {
+			if field.BitSize > 0 {
+				v.Children = append(v.Children, t.readBitField(frame, obj, field))
+				continue
+			}
+			v.Children = append(v.Children, t.readValue(frame, field.Name, member(obj, field.Type, field.ByteOffset), depth+1))
+		}
+		return v
+	case *dwarf.ArrayType:
+		count := ty.Count
+		if count < 0 {
+			count = 0
+		}
+		if isChar(ty.Type) {
+			n := count
+			if n > maxStringLength {
+				n = maxStringLength
+			}
+			data, err := t.bytes(frame, obj, 0, n)
+			if err != nil {
+				v.Error = err.Error()
+				return v
+			}
+			v.Text = cString(data)
+			return v
+		}
+		if depth >= maxValueDepth {
+			v.Text = "[...]"
+			return v
+		}
+		n := count
+		if n > maxElements {
+			n = maxElements
+			v.Text = fmt.Sprintf("first %d of %d elements", n, count)
+		}
+		size := ty.Type.Size()
+		// Read the elements at once rather than one by one
+		if obj.inMemory && obj.data == nil && n > 0 && size > 0 {
+			if data, err := t.bytes(frame, obj, 0, n*size); err == nil {
+				obj.data = data
+			}
+		}
+		for i := int64(0); i < n; i++ {
+			child := t.readValue(frame, fmt.Sprintf("[%d]", i), member(obj, ty.Type, i*size), depth+1)
+			v.Children = append(v.Children, child)
+		}
+		return v
+	}
+
+	size := obj.typ.Size()
+	if size <= 0 || size > 16 {
+		v.Error = fmt.Sprintf("unsupported type %s", v.Type)
+		return v
+	}
+	data, err := t.bytes(frame, obj, 0, size)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Value, v.Text, err = t.scalar(frame, obj.typ, data)
+	if err != nil {
+		v.Error = err.Error()
+	}
+	return v
+}
+
+// scalar decodes a value of a base, enumeration or pointer type
+func (t *Table) scalar(frame *Frame, typ dwarf.Type, data []byte) (interface{}, string, error) {
+	order := t.elf.ByteOrder
+	raw := uint64(0)
+	if len(data) <= 8 {
+		raw = decodeUint(data, order)
+	}
+	bits := uint(8 * len(data))
+
+	switch ty := underlying(typ).(type) {
+	case *dwarf.IntType:
+		return signExtend(raw, bits), "", nil
+	case *dwarf.CharType:
+		value := signExtend(raw, bits)
+		return value, charText(uint64(value)), nil
+	case *dwarf.UcharType:
+		return raw, charText(raw), nil
+	case *dwarf.UintType, *dwarf.AddrType, *dwarf.UnspecifiedType:
+		return raw, "", nil
+	case *dwarf.BoolType:
+		return raw != 0, "", nil
+	case *dwarf.FloatType:
+		var f float64
+		switch len(data) {
+		case 4:
+			f = float64(math.Float32frombits(uint32(raw)))
+		case 8:
+			f = math.Float64frombits(raw)
+		default:
+			return nil, "", fmt.Errorf("unsupported float size %d", len(data))
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// Not representable in JSON
+			return nil, strconv.FormatFloat(f, 'g', -1, 64), nil
+		}
+		return f, "", nil
+	case *dwarf.EnumType:
+		value := signExtend(raw, bits)
+		for _, enumerator := range ty.Val {
+			if enumerator.Val == value {
+				return value, enumerator.Name, nil
+			}
+		}
+		return value, "", nil
+	case *dwarf.PtrType:
+		text := ""
+		if raw != 0 && isChar(ty.Type) && frame.Memory != nil {
+			if data, err := frame.Memory(raw, maxStringLength); err == nil {
+				text = cString(data)
+			}
+		}
+		return fmt.Sprintf("%#x", raw), text, nil
+	}
+	return nil, "", fmt.Errorf("unsupported type %s", typeName(typ))
+}
+
+// readBitField reads a bit field of a structure
+func (t *Table) readBitField(frame *Frame, obj object, field *dwarf.StructField) Value {
+	v := Value{Name: field.Name, Type: typeName(field.Type)}
+	bigEndian := t.elf.ByteOrder == binary.BigEndian
+	offset := field.DataBitOffset
+	if offset == 0 && field.BitOffset != 0 {
+		// DWARF 2 and 3 count from the most significant bit of the storage
+		offset = field.ByteOffset * 8
+		if bigEndian {
+			offset += field.BitOffset
+		} else {
+			offset += field.ByteSize*8 - field.BitOffset - field.BitSize
+		}
+	}
+	first := offset / 8
+	size := (offset+field.BitSize+7)/8 - first
+	if size > 8 {
+		v.Error = "unsupported bit field"
+		return v
+	}
+	data, err := t.bytes(frame, obj, first, size)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	raw := decodeUint(data, t.elf.ByteOrder)
+	shift := uint(offset % 8)
+	if bigEndian {
+		shift = uint(size*8 - offset%8 - field.BitSize)
+	}
+	raw = raw >> shift & (1<<uint(field.BitSize) - 1)
+
+	switch underlying(field.Type).(type) {
+	case *dwarf.IntType, *dwarf.CharType, *dwarf.EnumType:
+		v.Value = signExtend(raw, uint(field.BitSize))
+	case *dwarf.BoolType:
+		v.Value = raw != 0
+	default:
+		v.Value = raw
+	}
+	return v
+}
+
+func signExtend(value uint64, bits uint) int64 {
+	if bits == 0 || bits >= 64 {
+		return int64(value)
+	}
+	shift := 64 - bits
+	return int64(value<<shift) >> shift
+}
+
+func isChar(typ dwarf.Type) bool {
+	switch underlying(typ).(type) {
+	case *dwarf.CharType, *dwarf.UcharType:
+		return true
+	}
+	return false
+}
+
+func charText(c uint64) string {
+	if c >= 0x20 && c < 0x7f {
+		return strconv.QuoteRune(rune(c))
+	}
+	return ""
+}
+
+// cString returns the text up to the first NUL
+func cString(data []byte) string {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
+
+// varPath is a parsed variable expression
+type varPath struct {
+	derefs    int
+	name      string
+	selectors []selector
+}
+
+// selector is .field, ->field or [index]
+type selector struct {
+	field string
+	arrow bool
+	index int64
+}
+
+var errInvalidExpression = errors.New("invalid variable expression")
+
+func parsePath(expression string) (varPath, error) {
+	var p varPath
+	s := strings.TrimSpace(expression)
+	for strings.HasPrefix(s, "*") {
+		p.derefs++
+		s = strings.TrimSpace(s[1:])
+	}
+	name, s := identifier(s)
+	if name == "" {
+		return p, fmt.Errorf("%w: %q", errInvalidExpression, expression)
+	}
+	p.name = name
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return p, nil
+		}
+		var sel selector
+		switch {
+		case strings.HasPrefix(s, "."):
+			sel.field, s = identifier(strings.TrimSpace(s[1:]))
+		case strings.HasPrefix(s, "->"):
+			sel.arrow = true
+			sel.field, s = identifier(strings.TrimSpace(s[2:]))
+		case strings.HasPrefix(s, "["):
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return p, fmt.Errorf("%w: %q", errInvalidExpression, expression)
+			}
+			index, err := strconv.ParseInt(strings.TrimSpace(s[1:end]), 0, 64)
+			if err != nil {
+				return p, fmt.Errorf("%w: %q", errInvalidExpression, expression)
+			}
+			sel.index, s = index, s[end+1:]
+			p.selectors = append(p.selectors, sel)
+			continue
+		}
+		if sel.field == "" {
+			return p, fmt.Errorf("%w: %q", errInvalidExpression, expression)
+		}
+		p.selectors = append(p.selectors, sel)
+	}
+}
+
+// identifier splits a C identifier off the front of s
+func identifier(s string) (string, string) {
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			i++
+			continue
+		}
+		break
+	}
+	return s[:i], s[i:]
+}
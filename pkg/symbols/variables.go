@@ -0,0 +1,367 @@
+package symbols
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+)
+
+// Variable is a variable described by the debug information. Locals and
+// parameters are only visible inside their function or lexical block.
+type Variable struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Size      int64  `json:"size"`
+	Function  string `json:"function,omitempty"` // Empty for globals
+	Parameter bool   `json:"parameter,omitempty"`
+	Address   uint64 `json:"address,omitempty"` // Of variables with static storage
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+
+	typ       dwarf.Type
+	location  []byte // Location expression, unless loclist is set
+	loclist   int64  // Offset of the location list, or -1
+	constant  interface{}
+	unit      *unit
+	scope     [][2]uint64
+	frameBase []byte
+}
+
+// unit holds what reading locations needs from a compile unit
+type unit struct {
+	base        uint64 // DW_AT_low_pc, the base of location lists
+	version     int
+	addressSize int
+	files       []*dwarf.LineFile
+}
+
+// localScope is a function or lexical block whose variables are read
+type localScope struct {
+	function  string
+	frameBase []byte
+	ranges    [][2]uint64
+}
+
+// unitVersion reads the DWARF version from the header of the compile unit
+// whose first entry is at offset in .debug_info
+func unitVersion(info []byte, offset dwarf.Offset) int {
+	off := int(offset)
+	if off < 11 || off > len(info) {
+		return 4
+	}
+	// The version is 8 bytes before the entry in DWARF 5 headers and 7
+	// bytes before it in earlier ones
+	for _, order := range [][2]byte{{info[off-8], info[off-7]}, {info[off-7], info[off-8]}} {
+		if order == [2]byte{5, 0} {
+			return 5
+		}
+	}
+	return 4
+}
+
+// loadLocals reads the parameters and variables of a function or lexical
+// block whose children r is positioned at
+func (t *Table) loadLocals(r *dwarf.Reader, u *unit, scope *localScope) error {
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil || entry.Tag == 0 {
+			return nil
+		}
+		switch entry.Tag {
+		case dwarf.TagFormalParameter, dwarf.TagVariable:
+			t.addVariable(entry, u, scope, entry.Tag == dwarf.TagFormalParameter)
+		case dwarf.TagLexDwarfBlock:
+			if entry.Children {
+				block := *scope
+				if ranges, err := t.dwarf.Ranges(entry); err == nil && len(ranges) > 0 {
+					block.ranges = ranges
+				}
+				if err := t.loadLocals(r, u, &block); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if entry.Children {
+			r.SkipChildren()
+		}
+	}
+}
+
+// addVariable adds a global, or a local of scope when it is not nil
+func (t *Table) addVariable(entry *dwarf.Entry, u *unit, scope *localScope, parameter bool) {
+	decl := entry
+	for _, attr := range []dwarf.Attr{dwarf.AttrSpecification, dwarf.AttrAbstractOrigin} {
+		if offset, ok := entry.Val(attr).(dwarf.Offset); ok {
+			if origin := t.entryAt(offset); origin != nil {
+				decl = origin
+			}
+			break
+		}
+	}
+	name, _ := decl.Val(dwarf.AttrName).(string)
+	typeOffset, ok := decl.Val(dwarf.AttrType).(dwarf.Offset)
+	if name == "" || !ok {
+		return
+	}
+	typ, err := t.dwarf.Type(typeOffset)
+	if err != nil {
+		return
+	}
+
+	v := Variable{Name: name, Type: typeName(typ), Size: typ.Size(), typ: typ, loclist: -1, unit: u}
+	if index, ok := decl.Val(dwarf.AttrDeclFile).(int64); ok && index >= 0 && int(index) < len(u.files) && u.files[index] != nil {
+		v.File = u.files[index].Name
+	}
+	if line, ok := decl.Val(dwarf.AttrDeclLine).(int64); ok {
+		v.Line = int(line)
+	}
+
+	field := entry.AttrField(dwarf.AttrLocation)
+	switch {
+	case field == nil:
+		v.constant = entry.Val(dwarf.AttrConstValue)
+		if v.constant == nil && scope == nil {
+			return // Declaration of a global defined elsewhere
+		}
+	case field.Class == dwarf.ClassLocListPtr || field.Class == dwarf.ClassLocList:
+		v.loclist, _ = field.Val.(int64)
+	default:
+		v.location, _ = field.Val.([]byte)
+		if len(v.location) == 1+u.addressSize && v.location[0] == 0x03 { // DW_OP_addr
+			v.Address = decodeUint(v.location[1:], t.elf.ByteOrder)
+		}
+	}
+
+	if scope == nil {
+		t.globals = append(t.globals, v)
+		return
+	}
+	v.Function, v.Parameter = scope.function, parameter
+	v.scope, v.frameBase = scope.ranges, scope.frameBase
+	t.locals = append(t.locals, v)
+}
+
+// Globals returns the global and file-scope variables
+func (t *Table) Globals() []Variable {
+	return t.globals
+}
+
+// Locals returns the parameters and variables in scope at an address, in
+// declaration order. Variables of inner blocks shadow those of outer ones.
+func (t *Table) Locals(pc uint64) []Variable {
+	var visible []Variable
+	width := make(map[string]uint64)
+	index := make(map[string]int)
+	for _, v := range t.locals {
+		w, ok := v.scopeWidth(pc)
+		if !ok {
+			continue
+		}
+		if i, seen := index[v.Name]; seen {
+			if w < width[v.Name] {
+				visible[i], width[v.Name] = v, w
+			}
+			continue
+		}
+		index[v.Name], width[v.Name] = len(visible), w
+		visible = append(visible, v)
+	}
+	return visible
+}
+
+// scopeWidth returns the size of the range of the variable's scope that
+// contains pc
+func (v *Variable) scopeWidth(pc uint64) (uint64, bool) {
+	for _, rng := range v.scope {
+		if pc >= rng[0] && pc < rng[1] {
+			return rng[1] - rng[0], true
+		}
+	}
+	return 0, false
+}
+
+// LookupVariable finds a variable by name, preferring the locals in scope
+// at an address over globals
+func (t *Table) LookupVariable(name string, pc uint64) (Variable, bool) {
+	for _, v := range t.Locals(pc) {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	for _, v := range t.globals {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variable{}, false
+}
+
+// locationAt returns the location expression of a variable at an address
+func (t *Table) locationAt(v *Variable, pc uint64) ([]byte, error) {
+	if v.loclist < 0 {
+		return v.location, nil
+	}
+	if v.unit.version >= 5 {
+		return t.loclistsEntry(v, pc)
+	}
+	return t.locEntry(v, pc)
+}
+
+// locEntry searches a DWARF 2-4 location list in .debug_loc
+func (t *Table) locEntry(v *Variable, pc uint64) ([]byte, error) {
+	if v.loclist >= int64(len(t.loc)) {
+		return nil, fmt.Errorf("location list %#x out of range", v.loclist)
+	}
+	r := &reader{data: t.loc, offset: int(v.loclist), order: t.elf.ByteOrder}
+	size := v.unit.addressSize
+	maxAddress := ^uint64(0) >> (64 - 8*uint(size))
+	base := v.unit.base
+	for r.err == nil {
+		begin, end := r.address(size), r.address(size)
+		switch {
+		case r.err != nil:
+			return nil, r.err
+		case begin == 0 && end == 0:
+			return nil, errOptimizedOut
+		case begin == maxAddress:
+			base = end
+			continue
+		}
+		expr := r.data[r.offset:]
+		n := int(r.u16())
+		r.skip(n)
+		if r.err == nil && pc >= base+begin && pc < base+end {
+			return expr[2 : 2+n], nil
+		}
+	}
+	return nil, r.err
+}
+
+// DWARF 5 location list entry kinds
+const (
+	lleEndOfList       = 0x00
+	lleBaseAddressx    = 0x01
+	lleStartxEndx      = 0x02
+	lleStartxLength    = 0x03
+	lleOffsetPair      = 0x04
+	lleDefaultLocation = 0x05
+	lleBaseAddress     = 0x06
+	lleStartEnd        = 0x07
+	lleStartLength     = 0x08
+)
+
+// loclistsEntry searches a DWARF 5 location list in .debug_loclists
+func (t *Table) loclistsEntry(v *Variable, pc uint64) ([]byte, error) {
+	if v.loclist >= int64(len(t.loclists)) {
+		return nil, fmt.Errorf("location list %#x out of range", v.loclist)
+	}
+	r := &reader{data: t.loclists, offset: int(v.loclist), order: t.elf.ByteOrder}
+	size := v.unit.addressSize
+	base := v.unit.base
+	var fallback []byte
+	for r.err == nil {
+		var low, high uint64
+		switch kind := r.u8(); kind {
+		case lleEndOfList:
+			if fallback != nil {
+				return fallback, nil
+			}
+			return nil, errOptimizedOut
+		case lleBaseAddress:
+			base = r.address(size)
+			continue
+		case lleOffsetPair:
+			low = base + r.uleb()
+			high = base + r.uleb()
+		case lleStartEnd:
+			low = r.address(size)
+			high = r.address(size)
+		case lleStartLength:
+			low = r.address(size)
+			high = low + r.uleb()
+		case lleDefaultLocation:
+			fallback = r.block()
+			continue
+		case lleBaseAddressx, lleStartxEndx, lleStartxLength:
+			return nil, fmt.Errorf("indexed location lists are not supported")
+		default:
+			return nil, fmt.Errorf("invalid location list entry %#x", kind)
+		}
+		expr := r.block()
+		if r.err == nil && pc >= low && pc < high {
+			return expr, nil
+		}
+	}
+	return nil, r.err
+}
+
+// typeName formats a type in C syntax
+func typeName(typ dwarf.Type) string {
+	switch ty := typ.(type) {
+	case nil:
+		return "void"
+	case *dwarf.VoidType:
+		return "void"
+	case *dwarf.TypedefType:
+		return ty.Name
+	case *dwarf.StructType:
+		if ty.StructName == "" {
+			return ty.Kind + " {...}"
+		}
+		return ty.Kind + " " + ty.StructName
+	case *dwarf.EnumType:
+		if ty.EnumName == "" {
+			return "enum {...}"
+		}
+		return "enum " + ty.EnumName
+	case *dwarf.QualType:
+		return ty.Qual + " " + typeName(ty.Type)
+	case *dwarf.PtrType:
+		if fn, ok := ty.Type.(*dwarf.FuncType); ok {
+			params := make([]string, len(fn.ParamType))
+			for i, param := range fn.ParamType {
+				params[i] = typeName(param)
+			}
+			return typeName(fn.ReturnType) + " (*)(" + strings.Join(params, ", ") + ")"
+		}
+		return typeName(ty.Type) + " *"
+	case *dwarf.ArrayType:
+		dims := ""
+		var elem dwarf.Type = ty
+		for {
+			array, ok := elem.(*dwarf.ArrayType)
+			if !ok {
+				break
+			}
+			if array.Count < 0 {
+				dims += "[]"
+			} else {
+				dims += fmt.Sprintf("[%d]", array.Count)
+			}
+			elem = array.Type
+		}
+		return typeName(elem) + dims
+	}
+	if name := typ.Common().Name; name != "" {
+		return name
+	}
+	return typ.String()
+}
+
+// underlying strips typedefs and qualifiers
+func underlying(typ dwarf.Type) dwarf.Type {
+	for {
+		switch ty := typ.(type) {
+		case *dwarf.TypedefType:
+			typ = ty.Type
+		case *dwarf.QualType:
+			typ = ty.Type
+		default:
+			return typ
+		}
+	}
+}
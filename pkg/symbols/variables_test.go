@@ -0,0 +1,227 @@
+package symbols
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// fixtureMemory serves the sections of the fixture and a stack
+type fixtureMemory struct {
+	file  *elf.File
+	stack map[uint64]byte
+}
+
+func (m *fixtureMemory) read(address uint64, size int) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for i := uint64(0); i < uint64(size); i++ {
+		b, ok := m.byteAt(address + i)
+		if !ok {
+			if i == 0 {
+				return nil, fmt.Errorf("cannot access memory at %#x", address)
+			}
+			break
+		}
+		data = append(data, b)
+	}
+	return data, nil
+}
+
+func (m *fixtureMemory) byteAt(address uint64) (byte, bool) {
+	if b, ok := m.stack[address]; ok {
+		return b, true
+	}
+	if m.file == nil {
+		return 0, false
+	}
+	for _, section := range m.file.Sections {
+		if section.Flags&elf.SHF_ALLOC == 0 || address < section.Addr || address >= section.Addr+section.Size {
+			continue
+		}
+		if section.Type == elf.SHT_NOBITS {
+			return 0, true
+		}
+		data, _ := section.Data()
+		return data[address-section.Addr], true
+	}
+	return 0, false
+}
+
+func (m *fixtureMemory) put(address uint64, value uint64, size int) {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, value)
+	for i := 0; i < size; i++ {
+		m.stack[address+uint64(i)] = data[i]
+	}
+}
+
+func TestCFA(t *testing.T) {
+	table := openFixture(t)
+
+	tests := []struct {
+		pc   uint64
+		want uint64
+	}{
+		{0x401000, 0x7008}, // rsp+8 on entry
+		{0x401001, 0x7010}, // rsp+16 after push %rbp
+		{0x401035, 0x6010}, // rbp+16 in the body
+		{0x40106f, 0x7008}, // rsp+8 after leave
+	}
+	for _, tt := range tests {
+		frame := &Frame{PC: tt.pc, Registers: map[string]uint64{"rip": tt.pc, "rsp": 0x7000, "rbp": 0x6000}}
+		if cfa, err := table.CFA(frame); err != nil || cfa != tt.want {
+			t.Errorf("CFA at %#x = %#x, %v; want %#x", tt.pc, cfa, err, tt.want)
+		}
+	}
+	frame := &Frame{PC: 0x500000, Registers: map[string]uint64{"rsp": 0x7000}}
+	if _, err := table.CFA(frame); !errors.Is(err, ErrNoFrameInfo) {
+		t.Errorf("expected ErrNoFrameInfo, got %v", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	table := openFixture(t)
+
+	want := map[string]struct {
+		typ     string
+		address uint64
+	}{
+		"ticks":   {"volatile unsigned int", 0x403028},
+		"sensors": {"struct sensor[2]", 0x403000},
+		"banner":  {"const char *", 0x403020},
+	}
+	globals := table.Globals()
+	if len(globals) != len(want) {
+		t.Fatalf("unexpected globals %+v", globals)
+	}
+	for _, v := range globals {
+		if w := want[v.Name]; v.Type != w.typ || v.Address != w.address || v.File != "/build/firmware.c" {
+			t.Errorf("unexpected global %+v", v)
+		}
+	}
+
+	memory := &fixtureMemory{file: table.ELF()}
+	frame, err := table.NewFrame(map[string]uint64{"rip": 0x40107f}, memory.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := table.ReadGlobals(frame)
+	if len(values) != 3 {
+		t.Fatalf("unexpected values %+v", values)
+	}
+	for _, value := range values {
+		if value.Error != "" {
+			t.Errorf("%s: %s", value.Name, value.Error)
+		}
+	}
+
+	tests := []struct {
+		expression string
+		value      interface{}
+		text       string
+	}{
+		{"ticks", uint64(0), ""},
+		{"sensors[0].samples[2]", uint64(30), ""},
+		{"sensors [1] . scale", 1.0, ""},
+		{"sensors[1].id", int64(2), ""},
+		{"banner", "0x402000", "virServer"},
+		{"*banner", int64('v'), "'v'"},
+		{"banner[3]", int64('S'), "'S'"},
+	}
+	for _, tt := range tests {
+		value, err := table.ReadVariable(frame, tt.expression)
+		if err != nil {
+			t.Errorf("ReadVariable(%q): %v", tt.expression, err)
+			continue
+		}
+		if value.Value != tt.value || value.Text != tt.text || value.Error != "" {
+			t.Errorf("ReadVariable(%q) = %+v", tt.expression, value)
+		}
+	}
+
+	sensor, err := table.ReadVariable(frame, "sensors[0]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sensor.Type != "struct sensor" || sensor.Address != 0x403000 || len(sensor.Children) != 3 {
+		t.Fatalf("unexpected value %+v", sensor)
+	}
+	samples := sensor.Children[1]
+	if samples.Name != "samples" || samples.Type != "short unsigned int[4]" || samples.Address != 0x403004 || len(samples.Children) != 4 {
+		t.Fatalf("unexpected samples %+v", samples)
+	}
+	if last := samples.Children[3]; last.Name != "[3]" || last.Value != uint64(40) || last.Address != 0x40300a {
+		t.Errorf("unexpected element %+v", last)
+	}
+
+	for _, expression := range []string{"", "1x", "sensors[", "sensors.", "sensors->id", "sensors[2]", "sensors[0].nope", "ticks.x", "nope"} {
+		if _, err := table.ReadVariable(frame, expression); err == nil {
+			t.Errorf("ReadVariable(%q) succeeded", expression)
+		}
+	}
+}
+
+func TestLocals(t *testing.T) {
+	table := openFixture(t)
+
+	names := func(pc uint64) string {
+		var names []string
+		for _, v := range table.Locals(pc) {
+			names = append(names, v.Name)
+		}
+		return fmt.Sprint(names)
+	}
+	if got := names(0x401035); got != "[s sum i]" {
+		t.Errorf("locals in the loop: %s", got)
+	}
+	if got := names(0x401054); got != "[s sum]" {
+		t.Errorf("locals after the loop: %s", got)
+	}
+	if got := names(0x40100a); got != "[value factor result]" {
+		t.Errorf("locals of scale: %s", got)
+	}
+
+	// average(&sensors[0]) at sum = 30, i = 2 with rbp 0x6000: the CFA is
+	// 0x6010 and the frame base is the CFA
+	memory := &fixtureMemory{file: table.ELF(), stack: make(map[uint64]byte)}
+	memory.put(0x6010-40, 0x403000, 8)
+	memory.put(0x6010-20, 30, 4)
+	memory.put(0x6010-24, 2, 4)
+	frame, err := table.NewFrame(map[string]uint64{"rip": 0x401035, "rsp": 0x5fe8, "rbp": 0x6000}, memory.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := table.ReadLocals(frame)
+	if len(values) != 3 {
+		t.Fatalf("unexpected locals %+v", values)
+	}
+	if s := values[0]; s.Type != "const struct sensor *" || s.Value != "0x403000" || s.Address != 0x5fe8 {
+		t.Errorf("unexpected s %+v", s)
+	}
+	if sum := values[1]; sum.Value != int64(30) || sum.Address != 0x5ffc {
+		t.Errorf("unexpected sum %+v", sum)
+	}
+	if i := values[2]; i.Value != int64(2) {
+		t.Errorf("unexpected i %+v", i)
+	}
+
+	if value, err := table.ReadVariable(frame, "s->samples[i]"); err == nil {
+		t.Errorf("index expressions are not supported, got %+v", value)
+	}
+	if value, err := table.ReadVariable(frame, "s->samples[1]"); err != nil || value.Value != uint64(20) {
+		t.Errorf("s->samples[1] = %+v, %v", value, err)
+	}
+	if value, err := table.ReadVariable(frame, "*s"); err != nil || len(value.Children) != 3 || value.Children[0].Value != int64(1) {
+		t.Errorf("*s = %+v, %v", value, err)
+	}
+	if value, err := table.ReadVariable(frame, "s[1].id"); err != nil || value.Value != int64(2) {
+		t.Errorf("s[1].id = %+v, %v", value, err)
+	}
+	// Globals stay visible
+	if value, err := table.ReadVariable(frame, "sensors[1].samples[0]"); err != nil || value.Value != uint64(0) {
+		t.Errorf("sensors[1].samples[0] = %+v, %v", value, err)
+	}
+}
@@ -0,0 +1,387 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+)
+
+// maxExtendsDepth bounds template inheritance chains
+const maxExtendsDepth = 8
+
+// deleteKey marks a node or peripheral override that removes the inherited entry
+const deleteKey = "_delete"
+
+// mergeKeys lists the arrays whose items are merged by identity instead of
+// being replaced wholesale
+var mergeKeys = map[string]string{
+	"nodes":         "id",
+	"peripherals":   "name",
+	"shared_memory": "id",
+}
+
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Parameter types
+const (
+	ParamInt    = "int"
+	ParamNumber = "number"
+	ParamString = "string"
+	ParamBool   = "bool"
+)
+
+// Parameter declares a typed template parameter. Parameters are referenced in
+// the template config as "${name}"; a string that consists of a single
+// placeholder is replaced by the typed value, otherwise the value is
+// interpolated as text.
+type Parameter struct {
+	Name        string      `json:"name" yaml:"name"`
+	Type        string      `json:"type" yaml:"type"`
+	Default     interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+	Required    bool        `json:"required,omitempty" yaml:"required,omitempty"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// ParseParameters decodes the parameter declarations stored on a template
+func ParseParameters(tmpl *models.BoardTemplate) ([]Parameter, error) {
+	if strings.TrimSpace(tmpl.Parameters) == "" {
+		return nil, nil
+	}
+	var params []Parameter
+	if err := json.Unmarshal([]byte(tmpl.Parameters), &params); err != nil {
+		return nil, fmt.Errorf("template %s: invalid parameters: %w", tmpl.ID, err)
+	}
+	for _, param := range params {
+		if param.Name == "" {
+			return nil, fmt.Errorf("template %s: parameter name required", tmpl.ID)
+		}
+		switch param.Type {
+		case ParamInt, ParamNumber, ParamString, ParamBool:
+		default:
+			return nil, fmt.Errorf("template %s: parameter %s has unknown type %q", tmpl.ID, param.Name, param.Type)
+		}
+		if param.Default != nil {
+			if _, err := coerce(param, param.Default); err != nil {
+				return nil, fmt.Errorf("template %s: default of %w", tmpl.ID, err)
+			}
+		}
+	}
+	return params, nil
+}
+
+// render resolves an inheritance chain, ordered from the root base template to
+// the requested template, into a BoardConfig
+func render(chain []*models.BoardTemplate, values map[string]interface{}) (*adapters.BoardConfig, error) {
+	params := make(map[string]Parameter)
+	var merged interface{}
+	for _, tmpl := range chain {
+		declared, err := ParseParameters(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range declared {
+			params[param.Name] = param
+		}
+
+		var doc interface{}
+		if err := json.Unmarshal([]byte(tmpl.Config), &doc); err != nil {
+			return nil, fmt.Errorf("template %s: invalid config: %w", tmpl.ID, err)
+		}
+		merged = deepMerge(merged, doc, "")
+	}
+
+	resolved, err := resolveValues(params, values)
+	if err != nil {
+		return nil, err
+	}
+
+	substituted, err := substitute(merged, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(substituted)
+	if err != nil {
+		return nil, err
+	}
+	config, err := adapters.ParseBoardConfig(data, adapters.FormatJSON)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", chain[len(chain)-1].ID, err)
+	}
+	return config, nil
+}
+
+// sampleValues returns stand-in values for the required parameters of a chain
+// that have no default, so that the structure of a template can be checked
+// before any values are supplied
+func sampleValues(chain []*models.BoardTemplate) (map[string]interface{}, error) {
+	params := make(map[string]Parameter)
+	for _, tmpl := range chain {
+		declared, err := ParseParameters(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range declared {
+			params[param.Name] = param
+		}
+	}
+
+	values := make(map[string]interface{})
+	for name, param := range params {
+		if !param.Required || param.Default != nil {
+			continue
+		}
+		switch param.Type {
+		case ParamInt:
+			values[name] = int64(1)
+		case ParamNumber:
+			values[name] = float64(1)
+		case ParamBool:
+			values[name] = false
+		case ParamString:
+			values[name] = name
+		}
+	}
+	return values, nil
+}
+
+// resolveValues applies defaults and checks the supplied values against the
+// declared parameters
+func resolveValues(params map[string]Parameter, values map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(params))
+
+	var unknown []string
+	for name := range values {
+		if _, ok := params[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown template parameters: %s", strings.Join(unknown, ", "))
+	}
+
+	for name, param := range params {
+		value, ok := values[name]
+		if !ok {
+			value = param.Default
+		}
+		if value == nil {
+			if param.Required {
+				return nil, fmt.Errorf("template parameter %s is required", name)
+			}
+			continue
+		}
+		typed, err := coerce(param, value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = typed
+	}
+	return resolved, nil
+}
+
+// coerce converts a value to the declared parameter type. Integers may be
+// given as strings in any base accepted by strconv.ParseInt, e.g. "0x80000".
+func coerce(param Parameter, value interface{}) (interface{}, error) {
+	switch param.Type {
+	case ParamInt:
+		switch v := value.(type) {
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("parameter %s: %v is not an integer", param.Name, v)
+			}
+			return int64(v), nil
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case string:
+			n, err := strconv.ParseInt(v, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %q is not an integer", param.Name, v)
+			}
+			return n, nil
+		}
+	case ParamNumber:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %q is not a number", param.Name, v)
+			}
+			return f, nil
+		}
+	case ParamBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %q is not a boolean", param.Name, v)
+			}
+			return b, nil
+		}
+	case ParamString:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("parameter %s: expected %s, got %v", param.Name, param.Type, value)
+}
+
+// deepMerge overlays override onto base. Maps are merged recursively, arrays
+// listed in mergeKeys are merged item by item using their identity field and
+// everything else in override replaces the base value.
+func deepMerge(base, override interface{}, key string) interface{} {
+	if base == nil {
+		return stripDeleted(override)
+	}
+
+	switch o := override.(type) {
+	case map[string]interface{}:
+		b, ok := base.(map[string]interface{})
+		if !ok {
+			return stripDeleted(o)
+		}
+		out := make(map[string]interface{}, len(b)+len(o))
+		for k, v := range b {
+			out[k] = v
+		}
+		for k, v := range o {
+			out[k] = deepMerge(b[k], v, k)
+		}
+		return out
+	case []interface{}:
+		idField, keyed := mergeKeys[key]
+		b, ok := base.([]interface{})
+		if !keyed || !ok {
+			return stripDeleted(o)
+		}
+		return mergeList(b, o, idField)
+	}
+	return override
+}
+
+// mergeList merges override items into base items that share the same
+// identity, appends new items and drops items marked with _delete
+func mergeList(base, override []interface{}, idField string) []interface{} {
+	out := make([]interface{}, len(base))
+	copy(out, base)
+
+	for _, item := range override {
+		itemMap, ok := item.(map[string]interface{})
+		id, hasID := itemMap[idField]
+		if !ok || !hasID {
+			out = append(out, item)
+			continue
+		}
+
+		index := -1
+		for i, existing := range out {
+			if existingMap, ok := existing.(map[string]interface{}); ok && existingMap[idField] == id {
+				index = i
+				break
+			}
+		}
+
+		switch {
+		case itemMap[deleteKey] == true:
+			if index >= 0 {
+				out = append(out[:index], out[index+1:]...)
+			}
+		case index >= 0:
+			out[index] = deepMerge(out[index], itemMap, "")
+		default:
+			out = append(out, stripDeleted(itemMap))
+		}
+	}
+	return out
+}
+
+// stripDeleted removes entries marked with _delete from a document that has
+// no base to delete from
+func stripDeleted(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if k == deleteKey {
+				continue
+			}
+			out[k] = stripDeleted(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok && m[deleteKey] == true {
+				continue
+			}
+			out = append(out, stripDeleted(item))
+		}
+		return out
+	}
+	return value
+}
+
+// substitute replaces ${name} placeholders in all strings of a document
+func substitute(value interface{}, values map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			substituted, err := substitute(item, values)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = substituted
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			substituted, err := substitute(item, values)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = substituted
+		}
+		return out, nil
+	case string:
+		if match := placeholderPattern.FindStringSubmatch(v); match != nil && match[0] == v {
+			typed, ok := values[match[1]]
+			if !ok {
+				return nil, fmt.Errorf("template parameter %s has no value", match[1])
+			}
+			return typed, nil
+		}
+
+		var missing error
+		out := placeholderPattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+			typed, ok := values[name]
+			if !ok {
+				missing = fmt.Errorf("template parameter %s has no value", name)
+				return placeholder
+			}
+			return fmt.Sprint(typed)
+		})
+		return out, missing
+	}
+	return value, nil
+}
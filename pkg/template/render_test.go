@@ -0,0 +1,103 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/models"
+)
+
+var stm32f4Base = &models.BoardTemplate{
+	ID:         "stm32f4",
+	Parameters: `[{"name":"flash_size","type":"int","default":524288},{"name":"baudrate","type":"int","default":115200},{"name":"variant","type":"string","default":"stm32f405"}]`,
+	Config: `{
+		"system_id": "${variant}",
+		"name": "STM32F4 (${variant})",
+		"nodes": [{
+			"id": "mcu",
+			"backend": "qemu",
+			"processor": {"type": "ARM Cortex-M4", "cores": 1},
+			"memory": [
+				{"type": "Flash", "address": 134217728, "size": "${flash_size}", "access": "RX"},
+				{"type": "RAM", "address": 536870912, "size": 131072, "access": "RW"}
+			],
+			"peripherals": [
+				{"type": "UART", "name": "USART1", "address": 1073811456, "properties": {"baudrate": "${baudrate}"}},
+				{"type": "UART", "name": "USART2", "address": 1073759232},
+				{"type": "Timer", "name": "TIM2", "address": 1073741824}
+			]
+		}]
+	}`,
+}
+
+func TestRender_Parameters(t *testing.T) {
+	config, err := render([]*models.BoardTemplate{stm32f4Base}, map[string]interface{}{
+		"flash_size": "0x100000",
+		"variant":    "stm32f407",
+	})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	if config.SystemID != "stm32f407" || config.Name != "STM32F4 (stm32f407)" {
+		t.Errorf("Unexpected identity: %s / %s", config.SystemID, config.Name)
+	}
+	if size := config.Nodes[0].Memory[0].Size; size != 0x100000 {
+		t.Errorf("Expected flash size 0x100000, got %#x", size)
+	}
+	if baud := config.Nodes[0].Peripherals[0].Properties["baudrate"]; baud != float64(115200) {
+		t.Errorf("Expected default baudrate, got %v", baud)
+	}
+}
+
+func TestRender_InvalidParameters(t *testing.T) {
+	chain := []*models.BoardTemplate{stm32f4Base}
+
+	if _, err := render(chain, map[string]interface{}{"flash_size": "large"}); err == nil {
+		t.Error("Expected an error for a non-integer flash_size")
+	}
+	if _, err := render(chain, map[string]interface{}{"uart_count": 3}); err == nil {
+		t.Error("Expected an error for an undeclared parameter")
+	}
+}
+
+func TestRender_Extends(t *testing.T) {
+	derived := &models.BoardTemplate{
+		ID:         "stm32f4-1m",
+		Extends:    "stm32f4",
+		Parameters: `[{"name":"flash_size","type":"int","default":1048576}]`,
+		Config: `{
+			"nodes": [{
+				"id": "mcu",
+				"peripherals": [
+					{"name": "USART2", "irq": [38]},
+					{"type": "UART", "name": "USART3", "address": 1073760256},
+					{"name": "TIM2", "_delete": true}
+				]
+			}]
+		}`,
+	}
+
+	config, err := render([]*models.BoardTemplate{stm32f4Base, derived}, nil)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	node := config.Nodes[0]
+	if node.Processor == nil || node.Processor.Type != "ARM Cortex-M4" {
+		t.Errorf("Processor should be inherited from the base template")
+	}
+	if node.Memory[0].Size != 1048576 {
+		t.Errorf("Expected overridden default flash size, got %d", node.Memory[0].Size)
+	}
+
+	names := make([]string, len(node.Peripherals))
+	for i, periph := range node.Peripherals {
+		names[i] = periph.Name
+	}
+	if len(names) != 3 || names[0] != "USART1" || names[1] != "USART2" || names[2] != "USART3" {
+		t.Fatalf("Unexpected peripherals: %v", names)
+	}
+	if usart2 := node.Peripherals[1]; usart2.Type != "UART" || usart2.Address != 1073759232 || len(usart2.IRQ) != 1 {
+		t.Errorf("USART2 should be merged with its base definition: %+v", usart2)
+	}
+}
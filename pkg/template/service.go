@@ -0,0 +1,416 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forfire912/virServer/pkg/adapters"
+	"github.com/forfire912/virServer/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNotFound is returned when a template or template version does not exist
+	ErrNotFound = errors.New("template not found")
+	// ErrConflict is returned when a template cannot be created or deleted
+	ErrConflict = errors.New("template conflict")
+)
+
+// likeEscaper escapes the wildcards of user input in LIKE patterns, which
+// declare \ as their escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Default and maximum page sizes for ListTemplates
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Service manages board templates. Every write creates an immutable
+// BoardTemplateVersion; the BoardTemplate row mirrors the latest version.
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService creates a new template service
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// ListOptions filters and paginates ListTemplates
+type ListOptions struct {
+	Tags     []string // Templates must carry all tags
+	Backend  string
+	Query    string // Substring of name or description
+	Page     int    // 1-based
+	PageSize int
+}
+
+// ListTemplates returns one page of templates matching the options together
+// with the total number of matches
+func (s *Service) ListTemplates(ctx context.Context, opts ListOptions) ([]models.BoardTemplate, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.BoardTemplate{})
+
+	for _, tag := range opts.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		query = query.Where(`(',' || LOWER(REPLACE(tags, ' ', '')) || ',') LIKE ? ESCAPE '\'`, "%,"+likeEscaper.Replace(tag)+",%")
+	}
+	if opts.Backend != "" {
+		query = query.Where("backend = ?", opts.Backend)
+	}
+	if opts.Query != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(opts.Query)) + "%"
+		query = query.Where(`(LOWER(name) LIKE ? ESCAPE '\' OR LOWER(description) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	page, pageSize := NormalizePage(opts.Page, opts.PageSize)
+	var templates []models.BoardTemplate
+	if err := query.Order("id").Offset((page - 1) * pageSize).Limit(pageSize).Find(&templates).Error; err != nil {
+		return nil, 0, err
+	}
+	return templates, total, nil
+}
+
+// GetTemplate retrieves the latest version of a template
+func (s *Service) GetTemplate(ctx context.Context, id string) (*models.BoardTemplate, error) {
+	return s.getTemplate(s.db.WithContext(ctx), id)
+}
+
+// GetTemplateVersion retrieves a template as it was at the given version. The
+// returned record carries the metadata of the template and the content of the
+// version.
+func (s *Service) GetTemplateVersion(ctx context.Context, id string, version int) (*models.BoardTemplate, error) {
+	tmpl, err := s.GetTemplate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 || version == tmpl.Version {
+		return tmpl, nil
+	}
+
+	var rev models.BoardTemplateVersion
+	if err := s.db.WithContext(ctx).Where("template_id = ? AND version = ?", id, version).First(&rev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s version %d", ErrNotFound, id, version)
+		}
+		return nil, err
+	}
+
+	tmpl.Version = rev.Version
+	tmpl.Config = rev.Config
+	tmpl.Extends = rev.Extends
+	tmpl.Parameters = rev.Parameters
+	tmpl.UpdatedAt = rev.CreatedAt
+	return tmpl, nil
+}
+
+// ListVersions returns all versions of a template, newest first
+func (s *Service) ListVersions(ctx context.Context, id string) ([]models.BoardTemplateVersion, error) {
+	tmpl, err := s.GetTemplate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []models.BoardTemplateVersion
+	if err := s.db.WithContext(ctx).Where("template_id = ?", id).Order("version DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 || versions[0].Version != tmpl.Version {
+		// Templates seeded directly into the table have no version rows yet
+		versions = append([]models.BoardTemplateVersion{versionRecord(tmpl)}, versions...)
+	}
+	return versions, nil
+}
+
+// CreateTemplate stores version 1 of a new template. Config holds the JSON
+// document of the template, which for derived templates may be a partial
+// BoardConfig with ${param} placeholders; it is validated by rendering it with
+// the parameter defaults and sample values for required parameters.
+func (s *Service) CreateTemplate(ctx context.Context, tmpl *models.BoardTemplate) error {
+	if tmpl.ID == "" {
+		return fmt.Errorf("template id required")
+	}
+	if strings.Contains(tmpl.ID, "@") {
+		return fmt.Errorf("template id must not contain '@'")
+	}
+	if err := s.validate(ctx, tmpl); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tmpl.Version = 1
+	tmpl.CreatedAt = now
+	tmpl.UpdatedAt = now
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.BoardTemplate{}).Where("id = ?", tmpl.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: template %s already exists", ErrConflict, tmpl.ID)
+		}
+		if err := tx.Create(tmpl).Error; err != nil {
+			return err
+		}
+		rev := versionRecord(tmpl)
+		return tx.Create(&rev).Error
+	})
+}
+
+// UpdateTemplate creates a new version of an existing template. Metadata
+// (name, description, backend, tags) is updated in place, while config,
+// extends and parameters become a new immutable version.
+func (s *Service) UpdateTemplate(ctx context.Context, update *models.BoardTemplate) (*models.BoardTemplate, error) {
+	if err := s.validate(ctx, update); err != nil {
+		return nil, err
+	}
+
+	var updated *models.BoardTemplate
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Concurrent updates wait for the row so that each gets its own version
+		current, err := s.getTemplate(tx.Clauses(clause.Locking{Strength: "UPDATE"}), update.ID)
+		if err != nil {
+			return err
+		}
+
+		// Preserve the content of templates that predate versioning
+		latest := current.Version
+		var count int64
+		if err := tx.Model(&models.BoardTemplateVersion{}).Where("template_id = ? AND version = ?", current.ID, latest).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			rev := versionRecord(current)
+			if err := tx.Create(&rev).Error; err != nil {
+				return err
+			}
+		}
+
+		current.Name = update.Name
+		current.Description = update.Description
+		current.Backend = update.Backend
+		current.Tags = update.Tags
+		current.Config = update.Config
+		current.Extends = update.Extends
+		current.Parameters = update.Parameters
+		current.Version = latest + 1
+		current.UpdatedAt = time.Now()
+
+		if err := tx.Save(current).Error; err != nil {
+			return err
+		}
+		rev := versionRecord(current)
+		if err := tx.Create(&rev).Error; err != nil {
+			return err
+		}
+		updated = current
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteTemplate removes a template and all of its versions. Templates that
+// other templates extend cannot be deleted.
+func (s *Service) DeleteTemplate(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := s.getTemplate(tx, id); err != nil {
+			return err
+		}
+
+		var children []string
+		if err := tx.Model(&models.BoardTemplate{}).
+			Where(`(extends = ? OR extends LIKE ? ESCAPE '\')`, id, likeEscaper.Replace(id)+"@%").
+			Pluck("id", &children).Error; err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return fmt.Errorf("%w: template %s is extended by %s", ErrConflict, id, strings.Join(children, ", "))
+		}
+
+		if err := tx.Where("template_id = ?", id).Delete(&models.BoardTemplateVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&models.BoardTemplate{}).Error
+	})
+}
+
+// Render resolves a template reference, its base templates and the given
+// parameter values into a complete BoardConfig. The reference is a template ID,
+// optionally pinned to a version as "id@version"; the version that was
+// rendered is returned alongside the config.
+func (s *Service) Render(ctx context.Context, ref string, params map[string]interface{}) (*adapters.BoardConfig, *models.BoardTemplate, error) {
+	id, version, err := ParseRef(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := s.GetTemplateVersion(ctx, id, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := s.renderTemplate(ctx, tmpl, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, tmpl, nil
+}
+
+// ParseRef splits a template reference of the form "id" or "id@version"
+func ParseRef(ref string) (string, int, error) {
+	id, versionText, pinned := strings.Cut(ref, "@")
+	if !pinned {
+		return ref, 0, nil
+	}
+	version, err := strconv.Atoi(versionText)
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("invalid template version in %q", ref)
+	}
+	return id, version, nil
+}
+
+// validate normalizes the config document, pins an unversioned extends to
+// the current version of the base and checks that the template renders to a
+// valid BoardConfig with its default parameters. Required parameters without
+// a default are only supplied at render time, so they are replaced by sample
+// values of their type.
+func (s *Service) validate(ctx context.Context, tmpl *models.BoardTemplate) error {
+	config, err := compactJSON(tmpl.Config)
+	if err != nil {
+		return fmt.Errorf("invalid template config: %w", err)
+	}
+	tmpl.Config = config
+
+	chain, err := s.resolveChain(ctx, tmpl)
+	if err != nil {
+		return err
+	}
+	if len(chain) > 1 && !strings.Contains(tmpl.Extends, "@") {
+		// Versions are immutable, so they render on the base they were written against
+		base := chain[len(chain)-2]
+		tmpl.Extends = fmt.Sprintf("%s@%d", base.ID, base.Version)
+	}
+	values, err := sampleValues(chain)
+	if err != nil {
+		return err
+	}
+	rendered, err := render(chain, values)
+	if err != nil {
+		return err
+	}
+	return rendered.Validate()
+}
+
+// renderTemplate renders a template that may not be stored yet
+func (s *Service) renderTemplate(ctx context.Context, tmpl *models.BoardTemplate, params map[string]interface{}) (*adapters.BoardConfig, error) {
+	chain, err := s.resolveChain(ctx, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return render(chain, params)
+}
+
+// resolveChain follows Extends links and returns the templates ordered from the
+// root base template to tmpl
+func (s *Service) resolveChain(ctx context.Context, tmpl *models.BoardTemplate) ([]*models.BoardTemplate, error) {
+	chain := []*models.BoardTemplate{tmpl}
+	seen := map[string]bool{tmpl.ID: true}
+
+	for current := tmpl; current.Extends != ""; {
+		if len(chain) > maxExtendsDepth {
+			return nil, fmt.Errorf("template %s: inheritance deeper than %d levels", tmpl.ID, maxExtendsDepth)
+		}
+
+		id, version, err := ParseRef(current.Extends)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", current.ID, err)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("template %s: inheritance cycle through %s", tmpl.ID, id)
+		}
+
+		base, err := s.GetTemplateVersion(ctx, id, version)
+		if err != nil {
+			return nil, fmt.Errorf("template %s extends %s: %w", current.ID, current.Extends, err)
+		}
+		seen[base.ID] = true
+		chain = append([]*models.BoardTemplate{base}, chain...)
+		current = base
+	}
+	return chain, nil
+}
+
+func (s *Service) getTemplate(db *gorm.DB, id string) (*models.BoardTemplate, error) {
+	var tmpl models.BoardTemplate
+	if err := db.Where("id = ?", id).First(&tmpl).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, err
+	}
+	tmpl.Version = currentVersion(&tmpl)
+	return &tmpl, nil
+}
+
+// currentVersion treats templates stored without a version as version 1
+func currentVersion(tmpl *models.BoardTemplate) int {
+	if tmpl.Version < 1 {
+		return 1
+	}
+	return tmpl.Version
+}
+
+func versionRecord(tmpl *models.BoardTemplate) models.BoardTemplateVersion {
+	return models.BoardTemplateVersion{
+		ID:         uuid.New().String(),
+		TemplateID: tmpl.ID,
+		Version:    currentVersion(tmpl),
+		Config:     tmpl.Config,
+		Extends:    tmpl.Extends,
+		Parameters: tmpl.Parameters,
+		CreatedAt:  tmpl.UpdatedAt,
+	}
+}
+
+// NormalizePage applies the default page and clamps the page size
+func NormalizePage(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	return page, pageSize
+}
+
+func compactJSON(doc string) (string, error) {
+	if strings.TrimSpace(doc) == "" {
+		return "", errors.New("config required")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(doc)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,210 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1) // Every connection to :memory: opens a new database
+
+	if err := db.AutoMigrate(&models.BoardTemplate{}, &models.BoardTemplateVersion{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	return NewService(db)
+}
+
+func TestService_Versions(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	base := *stm32f4Base
+	base.Name = "STM32F4"
+	base.Tags = "stm32, arm,cortex-m4"
+	if err := svc.CreateTemplate(ctx, &base); err != nil {
+		t.Fatalf("Failed to create template: %v", err)
+	}
+	if base.Version != 1 {
+		t.Errorf("Expected version 1, got %d", base.Version)
+	}
+
+	update := base
+	update.Parameters = `[{"name":"flash_size","type":"int","default":1048576},{"name":"baudrate","type":"int","default":9600},{"name":"variant","type":"string","default":"stm32f407"}]`
+	updated, err := svc.UpdateTemplate(ctx, &update)
+	if err != nil {
+		t.Fatalf("Failed to update template: %v", err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("Expected version 2, got %d", updated.Version)
+	}
+
+	v1, _, err := svc.Render(ctx, "stm32f4@1", nil)
+	if err != nil {
+		t.Fatalf("Failed to render version 1: %v", err)
+	}
+	latest, tmpl, err := svc.Render(ctx, "stm32f4", nil)
+	if err != nil {
+		t.Fatalf("Failed to render latest version: %v", err)
+	}
+	if tmpl.Version != 2 {
+		t.Errorf("Expected latest version 2, got %d", tmpl.Version)
+	}
+	if v1.Nodes[0].Memory[0].Size != 524288 || latest.Nodes[0].Memory[0].Size != 1048576 {
+		t.Errorf("Versions should keep their own content: v1=%d latest=%d", v1.Nodes[0].Memory[0].Size, latest.Nodes[0].Memory[0].Size)
+	}
+
+	versions, err := svc.ListVersions(ctx, "stm32f4")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d (%v)", len(versions), err)
+	}
+
+	if _, err := svc.GetTemplateVersion(ctx, "stm32f4", 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing version, got %v", err)
+	}
+}
+
+func TestService_ListAndDelete(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	base := *stm32f4Base
+	base.Tags = "stm32,arm"
+	if err := svc.CreateTemplate(ctx, &base); err != nil {
+		t.Fatalf("Failed to create template: %v", err)
+	}
+	derived := &models.BoardTemplate{
+		ID:      "stm32f4-can",
+		Extends: "stm32f4@1",
+		Tags:    "stm32,can",
+		Config:  `{"nodes":[{"id":"mcu","peripherals":[{"type":"CAN","name":"CAN1","address":1073767424}]}]}`,
+	}
+	if err := svc.CreateTemplate(ctx, derived); err != nil {
+		t.Fatalf("Failed to create derived template: %v", err)
+	}
+	if err := svc.CreateTemplate(ctx, &models.BoardTemplate{ID: "broken", Config: `{"nodes":[]}`}); err == nil {
+		t.Error("Expected an invalid config to be rejected")
+	}
+
+	items, total, err := svc.ListTemplates(ctx, ListOptions{Tags: []string{"stm32"}})
+	if err != nil || total != 2 || len(items) != 2 {
+		t.Fatalf("Expected 2 stm32 templates, got %d (%v)", total, err)
+	}
+	items, total, _ = svc.ListTemplates(ctx, ListOptions{Tags: []string{"stm32", "can"}})
+	if total != 1 || items[0].ID != "stm32f4-can" {
+		t.Errorf("Expected only the CAN template, got %+v", items)
+	}
+	// Wildcards in filters match themselves
+	if _, total, err = svc.ListTemplates(ctx, ListOptions{Query: "%"}); err != nil || total != 0 {
+		t.Errorf("Expected no template matching %%, got %d (%v)", total, err)
+	}
+	if _, total, err = svc.ListTemplates(ctx, ListOptions{Tags: []string{"stm__"}}); err != nil || total != 0 {
+		t.Errorf("Expected no template tagged stm__, got %d (%v)", total, err)
+	}
+	items, total, _ = svc.ListTemplates(ctx, ListOptions{PageSize: 1, Page: 2})
+	if total != 2 || len(items) != 1 || items[0].ID != "stm32f4-can" {
+		t.Errorf("Unexpected second page: total=%d items=%+v", total, items)
+	}
+
+	if err := svc.DeleteTemplate(ctx, "stm32f4"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict deleting an extended template, got %v", err)
+	}
+	if err := svc.DeleteTemplate(ctx, "stm32f4-can"); err != nil {
+		t.Fatalf("Failed to delete template: %v", err)
+	}
+	if err := svc.DeleteTemplate(ctx, "stm32f4"); err != nil {
+		t.Fatalf("Failed to delete base template: %v", err)
+	}
+}
+
+func TestService_RequiredParameters(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	base := *stm32f4Base
+	base.Parameters = `[{"name":"flash_size","type":"int","required":true},{"name":"baudrate","type":"int","default":115200},{"name":"variant","type":"string","required":true}]`
+	if err := svc.CreateTemplate(ctx, &base); err != nil {
+		t.Fatalf("Failed to create template with required parameters: %v", err)
+	}
+	if _, err := svc.UpdateTemplate(ctx, &base); err != nil {
+		t.Fatalf("Failed to update template with required parameters: %v", err)
+	}
+
+	if _, _, err := svc.Render(ctx, "stm32f4", nil); err == nil {
+		t.Error("Expected rendering without the required values to fail")
+	}
+	config, _, err := svc.Render(ctx, "stm32f4", map[string]interface{}{"flash_size": "0x100000", "variant": "stm32f407"})
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if config.Nodes[0].Memory[0].Size != 0x100000 || config.SystemID != "stm32f407" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	// Sample values do not hide structural errors
+	broken := &models.BoardTemplate{ID: "broken", Parameters: `[{"name":"count","type":"int","required":true}]`, Config: `{"nodes":[{"id":"mcu","memory":[{"size":"${count}"}],"cores":"${missing}"}]}`}
+	if err := svc.CreateTemplate(ctx, broken); err == nil {
+		t.Error("Expected an undeclared placeholder to be rejected")
+	}
+}
+
+func TestService_ExtendsPinned(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	base := *stm32f4Base
+	if err := svc.CreateTemplate(ctx, &base); err != nil {
+		t.Fatalf("Failed to create template: %v", err)
+	}
+	derived := &models.BoardTemplate{
+		ID:      "stm32f4-can",
+		Extends: "stm32f4",
+		Config:  `{"nodes":[{"id":"mcu","peripherals":[{"type":"CAN","name":"CAN1","address":1073767424}]}]}`,
+	}
+	if err := svc.CreateTemplate(ctx, derived); err != nil {
+		t.Fatalf("Failed to create derived template: %v", err)
+	}
+	if derived.Extends != "stm32f4@1" {
+		t.Errorf("Expected extends pinned to stm32f4@1, got %s", derived.Extends)
+	}
+
+	update := base
+	update.Parameters = `[{"name":"flash_size","type":"int","default":1048576},{"name":"baudrate","type":"int","default":9600},{"name":"variant","type":"string","default":"stm32f407"}]`
+	if _, err := svc.UpdateTemplate(ctx, &update); err != nil {
+		t.Fatalf("Failed to update base template: %v", err)
+	}
+
+	// The stored version keeps rendering on the base it was written against
+	config, _, err := svc.Render(ctx, "stm32f4-can@1", nil)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if config.Nodes[0].Memory[0].Size != 524288 {
+		t.Errorf("Expected the flash size of base version 1, got %d", config.Nodes[0].Memory[0].Size)
+	}
+
+	// Writing a new version picks up the current base
+	next := *derived
+	next.Extends = "stm32f4"
+	updated, err := svc.UpdateTemplate(ctx, &next)
+	if err != nil {
+		t.Fatalf("Failed to update derived template: %v", err)
+	}
+	if updated.Extends != "stm32f4@2" {
+		t.Errorf("Expected extends pinned to stm32f4@2, got %s", updated.Extends)
+	}
+}
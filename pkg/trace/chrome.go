@@ -0,0 +1,94 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// chromeEvent is an event of the Chrome Trace Event format. Times are in
+// microseconds.
+type chromeEvent struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat,omitempty"`
+	Phase    string            `json:"ph"`
+	Scope    string            `json:"s,omitempty"`
+	Time     float64           `json:"ts"`
+	Duration float64           `json:"dur,omitempty"`
+	PID      int               `json:"pid"`
+	TID      int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+// WriteChrome writes a trace in the Chrome Trace Event JSON format, which
+// chrome://tracing and ui.perfetto.dev open. Each executed instruction
+// takes a nanosecond of the trace.
+func WriteChrome(w io.Writer, title string, t *Trace) error {
+	bw := bufio.NewWriter(w)
+	if _, err := io.WriteString(bw, `{"displayTimeUnit":"ns","traceEvents":[`+"\n"); err != nil {
+		return err
+	}
+	first := true
+	write := func(event chromeEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if !first {
+			bw.WriteString(",\n")
+		}
+		first = false
+		_, err = bw.Write(data)
+		return err
+	}
+
+	metadata := []chromeEvent{
+		{Name: "process_name", Phase: "M", PID: 1, TID: 1, Args: map[string]string{"name": title}},
+		{Name: "thread_name", Phase: "M", PID: 1, TID: 1, Args: map[string]string{"name": "cpu"}},
+	}
+	for _, event := range metadata {
+		if err := write(event); err != nil {
+			return err
+		}
+	}
+	for _, slice := range t.Slices {
+		err := write(chromeEvent{
+			Name:     slice.Name,
+			Category: slice.Category,
+			Phase:    "X",
+			Time:     microseconds(slice.Start),
+			Duration: microseconds(slice.Duration),
+			PID:      1,
+			TID:      1,
+			Args:     map[string]string{"address": fmt.Sprintf("%#x", slice.Address)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, instant := range t.Instants {
+		err := write(chromeEvent{
+			Name:     instant.Name,
+			Category: InterruptCategory,
+			Phase:    "i",
+			Scope:    "t",
+			Time:     microseconds(instant.Time),
+			PID:      1,
+			TID:      1,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(bw, "\n]}\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// microseconds converts instructions, a nanosecond each, to microseconds
+func microseconds(instructions uint64) float64 {
+	return float64(instructions) / 1000
+}
@@ -0,0 +1,141 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// Fields of the Perfetto trace protos used
+const (
+	traceFieldPacket = 1 // Trace.packet
+
+	packetFieldTimestamp       = 8
+	packetFieldSequenceID      = 10 // trusted_packet_sequence_id
+	packetFieldTrackEvent      = 11
+	packetFieldSequenceFlags   = 13
+	packetFieldTrackDescriptor = 60
+
+	eventFieldType       = 9
+	eventFieldTrackUUID  = 11
+	eventFieldCategories = 22
+	eventFieldName       = 23
+
+	descriptorFieldUUID = 1
+	descriptorFieldName = 2
+)
+
+// Values of the trace protos
+const (
+	eventTypeSliceBegin    = 1
+	eventTypeSliceEnd      = 2
+	eventTypeInstant       = 3
+	sequenceStateCleared   = 1 // SEQ_INCREMENTAL_STATE_CLEARED
+	perfettoSequenceID     = 1
+	perfettoTrackUUID      = 1
+	protoWireVarint        = 0
+	protoWireLengthDelimit = 2
+)
+
+// protoMessage is an encoded protobuf message
+type protoMessage []byte
+
+func (m *protoMessage) varint(field int, value uint64) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|protoWireVarint)
+	*m = binary.AppendUvarint(*m, value)
+}
+
+func (m *protoMessage) bytes(field int, value []byte) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|protoWireLengthDelimit)
+	*m = binary.AppendUvarint(*m, uint64(len(value)))
+	*m = append(*m, value...)
+}
+
+func (m *protoMessage) string(field int, value string) {
+	m.bytes(field, []byte(value))
+}
+
+// WritePerfetto writes a trace as a Perfetto protobuf trace, with the
+// calls and exceptions as track events of one track named title. Each
+// executed instruction takes a nanosecond of the trace.
+func WritePerfetto(w io.Writer, title string, t *Trace) error {
+	bw := bufio.NewWriter(w)
+	writePacket := func(packet protoMessage) error {
+		var trace protoMessage
+		trace.bytes(traceFieldPacket, packet)
+		_, err := bw.Write(trace)
+		return err
+	}
+
+	var descriptor protoMessage
+	descriptor.varint(descriptorFieldUUID, perfettoTrackUUID)
+	descriptor.string(descriptorFieldName, title)
+	var packet protoMessage
+	packet.varint(packetFieldSequenceID, perfettoSequenceID)
+	packet.varint(packetFieldSequenceFlags, sequenceStateCleared)
+	packet.bytes(packetFieldTrackDescriptor, descriptor)
+	if err := writePacket(packet); err != nil {
+		return err
+	}
+
+	event := func(time uint64, eventType uint64, name, category string) error {
+		var event protoMessage
+		event.varint(eventFieldType, eventType)
+		event.varint(eventFieldTrackUUID, perfettoTrackUUID)
+		if category != "" {
+			event.string(eventFieldCategories, category)
+		}
+		if name != "" {
+			event.string(eventFieldName, name)
+		}
+		var packet protoMessage
+		packet.varint(packetFieldTimestamp, time)
+		packet.varint(packetFieldSequenceID, perfettoSequenceID)
+		packet.bytes(packetFieldTrackEvent, event)
+		return writePacket(packet)
+	}
+
+	// Slices nest, so the open ones end innermost first
+	var open []uint64
+	endUntil := func(time uint64) error {
+		for n := len(open); n > 0 && open[n-1] <= time; n = len(open) {
+			if err := event(open[n-1], eventTypeSliceEnd, "", ""); err != nil {
+				return err
+			}
+			open = open[:n-1]
+		}
+		return nil
+	}
+	instants := t.Instants
+	instantsUntil := func(time uint64) error {
+		for len(instants) > 0 && instants[0].Time <= time {
+			if err := endUntil(instants[0].Time); err != nil {
+				return err
+			}
+			if err := event(instants[0].Time, eventTypeInstant, instants[0].Name, InterruptCategory); err != nil {
+				return err
+			}
+			instants = instants[1:]
+		}
+		return nil
+	}
+	for _, slice := range t.Slices {
+		if err := instantsUntil(slice.Start); err != nil {
+			return err
+		}
+		if err := endUntil(slice.Start); err != nil {
+			return err
+		}
+		if err := event(slice.Start, eventTypeSliceBegin, slice.Name, slice.Category); err != nil {
+			return err
+		}
+		open = append(open, slice.Start+slice.Duration)
+	}
+	if err := instantsUntil(^uint64(0)); err != nil {
+		return err
+	}
+	if err := endUntil(^uint64(0)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
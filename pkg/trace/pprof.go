@@ -0,0 +1,163 @@
+package trace
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// Fields of the pprof profile.proto messages used
+const (
+	profileFieldSampleType  = 1
+	profileFieldSample      = 2
+	profileFieldMapping     = 3
+	profileFieldLocation    = 4
+	profileFieldFunction    = 5
+	profileFieldStringTable = 6
+	profileFieldPeriodType  = 11
+	profileFieldPeriod      = 12
+
+	valueTypeFieldType = 1
+	valueTypeFieldUnit = 2
+
+	sampleFieldLocationID = 1
+	sampleFieldValue      = 2
+
+	mappingFieldID           = 1
+	mappingFieldMemoryStart  = 2
+	mappingFieldMemoryLimit  = 3
+	mappingFieldFilename     = 5
+	mappingFieldBuildID      = 6
+	mappingFieldHasFunctions = 7
+
+	locationFieldID        = 1
+	locationFieldMappingID = 2
+	locationFieldAddress   = 3
+	locationFieldLine      = 4
+
+	lineFieldFunctionID = 1
+	lineFieldLine       = 2
+
+	functionFieldID        = 1
+	functionFieldName      = 2
+	functionFieldFilename  = 4
+	functionFieldStartLine = 5
+)
+
+func (m *protoMessage) packed(field int, values []uint64) {
+	var data []byte
+	for _, value := range values {
+		data = binary.AppendUvarint(data, value)
+	}
+	m.bytes(field, data)
+}
+
+// WritePprof writes a profile in the gzipped protobuf format of pprof, with
+// a sample per call stack valued in instructions. Each function has one
+// location, at its first address, in a mapping of the program.
+func WritePprof(w io.Writer, program, buildID string, p *Profile) error {
+	stringTable := []string{""}
+	index := map[string]uint64{"": 0}
+	str := func(s string) uint64 {
+		i, ok := index[s]
+		if !ok {
+			i = uint64(len(stringTable))
+			stringTable = append(stringTable, s)
+			index[s] = i
+		}
+		return i
+	}
+
+	var profile protoMessage
+	var valueType protoMessage
+	valueType.varint(valueTypeFieldType, str("instructions"))
+	valueType.varint(valueTypeFieldUnit, str("count"))
+	profile.bytes(profileFieldSampleType, valueType)
+	profile.bytes(profileFieldPeriodType, valueType)
+	profile.varint(profileFieldPeriod, 1)
+
+	// The mapping spans the functions profiled
+	start, limit := ^uint64(0), uint64(0)
+	for _, f := range p.Functions {
+		if f.Address < start {
+			start = f.Address
+		}
+		if f.Address+1 > limit {
+			limit = f.Address + 1
+		}
+	}
+	if start > limit {
+		start = 0
+	}
+	var mapping protoMessage
+	mapping.varint(mappingFieldID, 1)
+	mapping.varint(mappingFieldMemoryStart, start)
+	mapping.varint(mappingFieldMemoryLimit, limit)
+	mapping.varint(mappingFieldFilename, str(program))
+	mapping.varint(mappingFieldBuildID, str(buildID))
+	mapping.varint(mappingFieldHasFunctions, 1)
+	profile.bytes(profileFieldMapping, mapping)
+
+	ids := map[symbols.Function]uint64{}
+	var functions, locations []protoMessage
+	id := func(fn symbols.Function) uint64 {
+		if id, ok := ids[fn]; ok {
+			return id
+		}
+		id := uint64(len(ids) + 1)
+		ids[fn] = id
+
+		var function protoMessage
+		function.varint(functionFieldID, id)
+		function.varint(functionFieldName, str(fn.Name))
+		if fn.File != "" {
+			function.varint(functionFieldFilename, str(fn.File))
+		}
+		if fn.Line != 0 {
+			function.varint(functionFieldStartLine, uint64(fn.Line))
+		}
+		functions = append(functions, function)
+
+		var line protoMessage
+		line.varint(lineFieldFunctionID, id)
+		if fn.Line != 0 {
+			line.varint(lineFieldLine, uint64(fn.Line))
+		}
+		var location protoMessage
+		location.varint(locationFieldID, id)
+		location.varint(locationFieldMappingID, 1)
+		location.varint(locationFieldAddress, fn.Low)
+		location.bytes(locationFieldLine, line)
+		locations = append(locations, location)
+		return id
+	}
+
+	for _, stack := range p.Stacks {
+		// Samples list the innermost function first
+		locationIDs := make([]uint64, len(stack.Functions))
+		for i, fn := range stack.Functions {
+			locationIDs[len(stack.Functions)-1-i] = id(fn)
+		}
+		var sample protoMessage
+		sample.packed(sampleFieldLocationID, locationIDs)
+		sample.packed(sampleFieldValue, []uint64{stack.Instructions})
+		profile.bytes(profileFieldSample, sample)
+	}
+	for _, location := range locations {
+		profile.bytes(profileFieldLocation, location)
+	}
+	for _, function := range functions {
+		profile.bytes(profileFieldFunction, function)
+	}
+	for _, s := range stringTable {
+		profile.string(profileFieldStringTable, s)
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile); err != nil {
+		return err
+	}
+	return gz.Close()
+}
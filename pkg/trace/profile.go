@@ -0,0 +1,173 @@
+package trace
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// unknownFunction holds instructions executed outside of any function
+var unknownFunction = symbols.Function{Name: "[unknown]"}
+
+// FunctionProfile is the time a program spent in a function
+type FunctionProfile struct {
+	Name         string  `json:"name"`
+	Address      uint64  `json:"address"`
+	File         string  `json:"file,omitempty"`
+	Self         uint64  `json:"self"`  // Instructions executed in the function
+	Total        uint64  `json:"total"` // Including the functions it called
+	SelfPercent  float64 `json:"self_percent"`
+	TotalPercent float64 `json:"total_percent"`
+}
+
+// Stack is a call stack and the instructions executed with it
+type Stack struct {
+	Functions    []symbols.Function `json:"functions"` // Outermost first
+	Instructions uint64             `json:"instructions"`
+}
+
+// Profile is where a program spent its instructions. Unlike a sampling
+// profiler, it counts every instruction of the run.
+type Profile struct {
+	Instructions uint64            `json:"instructions"`
+	Functions    []FunctionProfile `json:"functions"`           // Most self instructions first
+	Stacks       []Stack           `json:"stacks"`              // By call stack
+	Truncated    bool              `json:"truncated,omitempty"` // Records or calls beyond the limits were left out
+}
+
+// BuildProfile attributes the instructions of each block of the execution
+// records to the call stack Build would have at the block
+func BuildProfile(table *symbols.Table, records []Record) *Profile {
+	b := NewProfileBuilder(table)
+	for _, record := range records {
+		if !b.Add(record) {
+			break
+		}
+	}
+	return b.Profile()
+}
+
+// ProfileBuilder builds a profile from execution records added in the
+// order of the run, without keeping them
+type ProfileBuilder struct {
+	builder
+	stacks    map[int]*profileStack // By call stack ID, 0 outside of any call
+	truncated bool
+}
+
+// profileStack is a call stack of a profile, with the key it is sorted by
+type profileStack struct {
+	key string
+	Stack
+}
+
+// NewProfileBuilder creates a profile builder
+func NewProfileBuilder(table *symbols.Table) *ProfileBuilder {
+	return &ProfileBuilder{
+		builder: builder{table: table, options: &Options{}, nodes: map[stackNode]int{}},
+		stacks:  map[int]*profileStack{},
+	}
+}
+
+// Add adds the next record. It returns false once the records reach
+// maxRecords; the profile is truncated and later records are not needed.
+func (b *ProfileBuilder) Add(record Record) bool {
+	if !b.count() {
+		b.truncated = true
+		return false
+	}
+	b.add(record)
+	if record.Kind != BlockRecord {
+		return true
+	}
+	id := 0
+	if n := len(b.stack); n > 0 {
+		id = b.stack[n-1].node
+	}
+	stack, ok := b.stacks[id]
+	if !ok {
+		stack = &profileStack{key: b.stackKey(), Stack: Stack{Functions: b.stackFunctions()}}
+		b.stacks[id] = stack
+	}
+	stack.Instructions += uint64(record.Instructions)
+	return true
+}
+
+// Profile returns the profile of the records added
+func (b *ProfileBuilder) Profile() *Profile {
+	now := b.now
+	p := &Profile{Instructions: now, Truncated: b.truncated || b.deep}
+	functions := map[symbols.Function]*FunctionProfile{}
+	stacks := make([]*profileStack, 0, len(b.stacks))
+	for _, stack := range b.stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].key < stacks[j].key })
+	for _, stack := range stacks {
+		p.Stacks = append(p.Stacks, stack.Stack)
+		// Recursive functions count once in the total of a stack
+		seen := map[symbols.Function]bool{}
+		for i, fn := range stack.Functions {
+			f, ok := functions[fn]
+			if !ok {
+				f = &FunctionProfile{Name: fn.Name, Address: fn.Low, File: fn.File}
+				functions[fn] = f
+			}
+			if i == len(stack.Functions)-1 {
+				f.Self += stack.Instructions
+			}
+			if !seen[fn] {
+				seen[fn] = true
+				f.Total += stack.Instructions
+			}
+		}
+	}
+	for _, f := range functions {
+		f.SelfPercent = percent(f.Self, now)
+		f.TotalPercent = percent(f.Total, now)
+		p.Functions = append(p.Functions, *f)
+	}
+	sort.Slice(p.Functions, func(i, j int) bool {
+		a, b := p.Functions[i], p.Functions[j]
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Address < b.Address
+	})
+	return p
+}
+
+// stackKey names the call stack by the functions on it, in the order
+// profiles list stacks
+func (b *builder) stackKey() string {
+	var key strings.Builder
+	for _, f := range b.stack {
+		key.WriteString(strconv.FormatUint(f.fn.Low, 16))
+		key.WriteByte('/')
+	}
+	return key.String()
+}
+
+func (b *builder) stackFunctions() []symbols.Function {
+	if len(b.stack) == 0 {
+		return []symbols.Function{unknownFunction}
+	}
+	functions := make([]symbols.Function, len(b.stack))
+	for i, f := range b.stack {
+		functions[i] = f.fn
+	}
+	return functions
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(10000*float64(n)/float64(total)) / 100
+}
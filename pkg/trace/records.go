@@ -0,0 +1,112 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// recordsHeader starts a file of execution records
+const recordsHeader = "# virserver execution trace 1"
+
+// Record kinds
+const (
+	BlockRecord     = 'B' // Instructions executed from PC on
+	ExceptionRecord = 'X' // Exception taken before the next block
+)
+
+// Record is an entry of the execution of a program as backends report it:
+// a run of instructions, or an exception
+type Record struct {
+	Kind         byte
+	PC           uint64
+	Instructions uint32
+	Number       int    // Of an exception, as the backend numbers them
+	Name         string // Kind of an exception, such as IRQ
+}
+
+// WriteRecords writes execution records with a RecordWriter
+func WriteRecords(w io.Writer, records []Record) error {
+	rw := NewRecordWriter(w)
+	for _, record := range records {
+		if err := rw.Write(record); err != nil {
+			return err
+		}
+	}
+	return rw.Flush()
+}
+
+// RecordWriter writes execution records, one per line: "B <pc> <count>"
+// for blocks and "X <number> <name>" for exceptions
+type RecordWriter struct {
+	w *bufio.Writer
+}
+
+// NewRecordWriter creates a writer of execution records to w
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, recordsHeader)
+	return &RecordWriter{w: bw}
+}
+
+// Write writes a record
+func (rw *RecordWriter) Write(record Record) error {
+	var err error
+	switch record.Kind {
+	case BlockRecord:
+		_, err = fmt.Fprintf(rw.w, "B %#x %d\n", record.PC, record.Instructions)
+	case ExceptionRecord:
+		_, err = fmt.Fprintf(rw.w, "X %d %s\n", record.Number, record.Name)
+	}
+	return err
+}
+
+// Flush writes the buffered records
+func (rw *RecordWriter) Flush() error {
+	return rw.w.Flush()
+}
+
+// ScanRecords reads execution records written by a RecordWriter and passes
+// them to fn in order, until fn returns false
+func ScanRecords(r io.Reader, fn func(Record) bool) error {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || scanner.Text() != recordsHeader {
+		return fmt.Errorf("not an execution trace")
+	}
+	for n := 2; scanner.Scan(); n++ {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			return fmt.Errorf("execution trace line %d: invalid record", n)
+		}
+		var record Record
+		switch fields[0] {
+		case "B":
+			pc, err := strconv.ParseUint(fields[1], 0, 64)
+			if err != nil {
+				return fmt.Errorf("execution trace line %d: %w", n, err)
+			}
+			count, err := strconv.ParseUint(fields[2], 10, 32)
+			if err != nil {
+				return fmt.Errorf("execution trace line %d: %w", n, err)
+			}
+			record = Record{Kind: BlockRecord, PC: pc, Instructions: uint32(count)}
+		case "X":
+			number, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return fmt.Errorf("execution trace line %d: %w", n, err)
+			}
+			record = Record{Kind: ExceptionRecord, Number: number, Name: fields[2]}
+		default:
+			return fmt.Errorf("execution trace line %d: unknown record %q", n, fields[0])
+		}
+		if !fn(record) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read execution trace: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Package trace reconstructs the function calls and interrupts of a program
+// from the blocks it executed, using the symbols of its ELF file, and writes
+// them in the Chrome Trace Event and Perfetto formats, or as pprof profiles.
+package trace
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// Event categories
+const (
+	FunctionCategory  = "function"
+	InterruptCategory = "interrupt"
+)
+
+// Limits of traces and profiles
+const (
+	maxEvents     = 1000000  // Slices and instants of a trace
+	maxRecords    = 50000000 // Execution records a trace or profile is built from
+	maxStackDepth = 256      // Calls in progress; deeper calls replace the innermost one
+)
+
+// Range is an address range [Start, End)
+type Range struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// Options filter the events of a trace
+type Options struct {
+	Ranges []Range // Functions kept, by their first address; all when empty
+	Start  uint64  // Window kept, in instructions from the start of the run
+	End    uint64  // 0 for the end of the run
+}
+
+// Slice is a call of a function, or the handling of an exception
+type Slice struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Address  uint64 `json:"address"` // Of the function
+	Start    uint64 `json:"start"`
+	Duration uint64 `json:"duration"`
+	Depth    int    `json:"depth"` // In the call stack, from 0
+}
+
+// Instant is an exception taken
+type Instant struct {
+	Name string `json:"name"`
+	Time uint64 `json:"time"`
+}
+
+// Trace is the execution of a program. Time is counted in instructions
+// executed since the start of the run.
+type Trace struct {
+	Instructions uint64    // Executed in the run, up to the record limit
+	Slices       []Slice   // By start, enclosing slices first
+	Instants     []Instant // By time
+	Truncated    bool      // Events, records or calls beyond the limits were left out
+}
+
+// Build reconstructs the calls of a program from its execution records
+func Build(table *symbols.Table, records []Record, options *Options) *Trace {
+	b := NewBuilder(table, options)
+	for _, record := range records {
+		if !b.Add(record) {
+			break
+		}
+	}
+	return b.Trace()
+}
+
+// Builder reconstructs the calls of a program from its execution records,
+// added in the order of the run without keeping them. A block at the first
+// address of a function enters it, unless the previous block ran in the
+// same function: backends start blocks at branch targets, so that is a loop
+// whose head is the first instruction. A block inside a function of the
+// call stack returns to it, and a block inside another function replaces
+// the current call, as tail calls do. The first block after an exception
+// enters its handler. Code without symbols belongs to the current call.
+type Builder struct {
+	builder
+}
+
+// NewBuilder creates a builder keeping the events the options select
+func NewBuilder(table *symbols.Table, options *Options) *Builder {
+	if options == nil {
+		options = &Options{}
+	}
+	return &Builder{builder{table: table, options: options, trace: &Trace{}}}
+}
+
+// Add adds the next record. Past the end of the window, records only count
+// instructions. It returns false once the records reach maxRecords; the
+// trace is truncated and later records are not needed.
+func (b *Builder) Add(record Record) bool {
+	if !b.count() {
+		b.trace.Truncated = true
+		return false
+	}
+	if b.options.End > 0 && b.now >= b.options.End {
+		if record.Kind == BlockRecord {
+			b.now += uint64(record.Instructions)
+		}
+		return true
+	}
+	if record.Kind == ExceptionRecord {
+		b.instant(exceptionName(record), b.now)
+	}
+	b.add(record)
+	return true
+}
+
+// Trace ends the calls in progress and returns the trace
+func (b *Builder) Trace() *Trace {
+	b.popTo(0, b.now)
+
+	t := b.trace
+	t.Instructions = b.now
+	t.Truncated = t.Truncated || b.deep
+	sort.Slice(t.Slices, func(i, j int) bool {
+		a, b := t.Slices[i], t.Slices[j]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		if a.Duration != b.Duration {
+			return a.Duration > b.Duration
+		}
+		return a.Depth < b.Depth
+	})
+	return t
+}
+
+func exceptionName(record Record) string {
+	if record.Name == "" {
+		return fmt.Sprintf("exception %d", record.Number)
+	}
+	return fmt.Sprintf("%s %d", record.Name, record.Number)
+}
+
+// frame is a call in progress
+type frame struct {
+	fn       symbols.Function
+	start    uint64
+	category string
+	node     int // Of the call stack up to this call, for profiles
+}
+
+// stackNode identifies a call stack by the call stack of its caller and
+// its innermost function, so that stacks are keyed as calls are pushed
+type stackNode struct {
+	parent int // 0 for the outermost call
+	fn     uint64
+}
+
+type builder struct {
+	table   *symbols.Table
+	options *Options
+	trace   *Trace // nil for profiles
+	stack   []frame
+	nodes   map[stackNode]int // IDs of the call stacks, from 1; nil for traces
+
+	now       uint64 // Instructions of the records added
+	exception bool   // The last record is an exception
+	records   int
+	last      uint64 // First address of the function of the last block
+	inFunc    bool   // The last block ran in a function
+	deep      bool   // Calls beyond maxStackDepth were left out
+}
+
+// count counts a record and tells whether it is within maxRecords
+func (b *builder) count() bool {
+	if b.records >= maxRecords {
+		return false
+	}
+	b.records++
+	return true
+}
+
+// add follows the call stack through a record
+func (b *builder) add(record Record) {
+	switch record.Kind {
+	case ExceptionRecord:
+		b.exception = true
+	case BlockRecord:
+		b.block(record.PC, b.now, b.exception)
+		b.exception = false
+		b.now += uint64(record.Instructions)
+	}
+}
+
+func (b *builder) block(pc, now uint64, exception bool) {
+	fn, ok := b.table.FunctionAt(pc)
+	loop := b.inFunc && ok && b.last == fn.Low
+	b.last, b.inFunc = fn.Low, ok
+	if !ok {
+		return
+	}
+	switch {
+	case exception:
+		b.push(frame{fn: fn, start: now, category: InterruptCategory})
+	case pc == fn.Low && !loop:
+		b.push(frame{fn: fn, start: now, category: FunctionCategory})
+	default:
+		for i := len(b.stack) - 1; i >= 0; i-- {
+			if b.stack[i].fn.Low == fn.Low {
+				b.popTo(i+1, now)
+				return
+			}
+		}
+		category := FunctionCategory
+		if n := len(b.stack); n > 0 {
+			category = b.stack[n-1].category
+			b.popTo(n-1, now)
+		}
+		b.push(frame{fn: fn, start: now, category: category})
+	}
+}
+
+// push enters a call. Beyond maxStackDepth it replaces the innermost call.
+func (b *builder) push(f frame) {
+	if n := len(b.stack); n >= maxStackDepth {
+		b.deep = true
+		b.popTo(n-1, f.start)
+	}
+	if b.nodes != nil {
+		key := stackNode{fn: f.fn.Low}
+		if n := len(b.stack); n > 0 {
+			key.parent = b.stack[n-1].node
+		}
+		id, ok := b.nodes[key]
+		if !ok {
+			id = len(b.nodes) + 1
+			b.nodes[key] = id
+		}
+		f.node = id
+	}
+	b.stack = append(b.stack, f)
+}
+
+// popTo ends the calls above depth n of the stack
+func (b *builder) popTo(n int, now uint64) {
+	for len(b.stack) > n {
+		top := b.stack[len(b.stack)-1]
+		b.stack = b.stack[:len(b.stack)-1]
+		b.slice(top, len(b.stack), now)
+	}
+}
+
+// slice adds a call at depth ended at end, clipped to the window. Builders
+// of profiles keep no slices.
+func (b *builder) slice(f frame, depth int, end uint64) {
+	if b.trace == nil {
+		return
+	}
+	start := f.start
+	if start < b.options.Start {
+		start = b.options.Start
+	}
+	if b.options.End > 0 && end > b.options.End {
+		end = b.options.End
+	}
+	if end <= start || !b.kept(f.fn.Low) || !b.room() {
+		return
+	}
+	b.trace.Slices = append(b.trace.Slices, Slice{
+		Name:     f.fn.Name,
+		Category: f.category,
+		Address:  f.fn.Low,
+		Start:    start,
+		Duration: end - start,
+		Depth:    depth,
+	})
+}
+
+func (b *builder) instant(name string, now uint64) {
+	if now < b.options.Start || b.options.End > 0 && now >= b.options.End || !b.room() {
+		return
+	}
+	b.trace.Instants = append(b.trace.Instants, Instant{Name: name, Time: now})
+}
+
+// kept tells whether the address ranges of the options hold an address
+func (b *builder) kept(address uint64) bool {
+	if len(b.options.Ranges) == 0 {
+		return true
+	}
+	for _, r := range b.options.Ranges {
+		if address >= r.Start && address < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *builder) room() bool {
+	if len(b.trace.Slices)+len(b.trace.Instants) >= maxEvents {
+		b.trace.Truncated = true
+		return false
+	}
+	return true
+}
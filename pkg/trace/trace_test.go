@@ -0,0 +1,373 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/forfire912/virServer/pkg/symbols"
+)
+
+// A run of the fixture of pkg/symbols, with scale at 0x401000, average at
+// 0x401019 and _start at 0x401070: _start calls average, which calls scale
+// and is interrupted by an exception handled by scale
+var fixtureRecords = []Record{
+	{Kind: BlockRecord, PC: 0x401070, Instructions: 3},
+	{Kind: BlockRecord, PC: 0x401019, Instructions: 4},
+	{Kind: BlockRecord, PC: 0x401000, Instructions: 5},
+	{Kind: BlockRecord, PC: 0x401029, Instructions: 2},
+	{Kind: ExceptionRecord, Number: 15, Name: "IRQ"},
+	{Kind: BlockRecord, PC: 0x401000, Instructions: 3},
+	{Kind: BlockRecord, PC: 0x401030, Instructions: 2},
+	{Kind: BlockRecord, PC: 0x401080, Instructions: 1},
+}
+
+func buildFixture(t *testing.T, options *Options) *Trace {
+	t.Helper()
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Build(table, fixtureRecords, options)
+}
+
+func slices(t *Trace) string {
+	var s []string
+	for _, slice := range t.Slices {
+		s = append(s, fmt.Sprintf("%s/%s@%d+%d", slice.Name, slice.Category, slice.Start, slice.Duration))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestBuild(t *testing.T) {
+	trace := buildFixture(t, nil)
+	if trace.Instructions != 20 || trace.Truncated {
+		t.Errorf("instructions %d, truncated %v", trace.Instructions, trace.Truncated)
+	}
+	want := "_start/function@0+20 average/function@3+16 scale/function@7+5 scale/interrupt@14+3"
+	if got := slices(trace); got != want {
+		t.Errorf("slices %s, want %s", got, want)
+	}
+	if len(trace.Instants) != 1 || trace.Instants[0] != (Instant{Name: "IRQ 15", Time: 14}) {
+		t.Errorf("instants %+v", trace.Instants)
+	}
+}
+
+func TestBuildFilters(t *testing.T) {
+	trace := buildFixture(t, &Options{Ranges: []Range{{Start: 0x401000, End: 0x401019}}})
+	if got := slices(trace); got != "scale/function@7+5 scale/interrupt@14+3" {
+		t.Errorf("slices in range %s", got)
+	}
+	trace = buildFixture(t, &Options{Start: 10, End: 14})
+	if got := slices(trace); got != "_start/function@10+4 average/function@10+4 scale/function@10+2" {
+		t.Errorf("slices in window %s", got)
+	}
+	if len(trace.Instants) != 0 {
+		t.Errorf("instants after the window %+v", trace.Instants)
+	}
+	// Records after the window still count
+	if trace.Instructions != 20 {
+		t.Errorf("instructions %d with a window", trace.Instructions)
+	}
+}
+
+// entryLoop runs _start and then n blocks of a loop whose head is the
+// first instruction of scale
+func entryLoop(n int) []Record {
+	records := []Record{{Kind: BlockRecord, PC: 0x401070, Instructions: 3}}
+	for i := 0; i < n; i++ {
+		records = append(records, Record{Kind: BlockRecord, PC: 0x401000, Instructions: 2})
+	}
+	return records
+}
+
+func TestBuildLoops(t *testing.T) {
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Blocks at the first address of the function running are a loop
+	trace := Build(table, entryLoop(20000), nil)
+	if got := slices(trace); got != "_start/function@0+40003 scale/function@3+40000" {
+		t.Errorf("slices of a loop at function entry %s", got)
+	}
+
+	// Calls beyond maxStackDepth replace the innermost one
+	var records []Record
+	for i := 0; i < 2*maxStackDepth; i++ {
+		records = append(records, Record{Kind: BlockRecord, PC: []uint64{0x401000, 0x401019}[i%2], Instructions: 1})
+	}
+	trace = Build(table, records, nil)
+	depth := 0
+	for _, slice := range trace.Slices {
+		if slice.Depth > depth {
+			depth = slice.Depth
+		}
+	}
+	if depth != maxStackDepth-1 || !trace.Truncated {
+		t.Errorf("depth %d, truncated %v", depth, trace.Truncated)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteRecords(&out, fixtureRecords); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "B 0x401000 5\nB 0x401029 2\nX 15 IRQ\n") {
+		t.Errorf("unexpected records %q", out.String())
+	}
+	data := out.String()
+	var read []Record
+	if err := ScanRecords(strings.NewReader(data), func(record Record) bool {
+		read = append(read, record)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(read) != fmt.Sprint(fixtureRecords) {
+		t.Errorf("read %+v", read)
+	}
+	n := 0
+	if err := ScanRecords(strings.NewReader(data), func(Record) bool {
+		n++
+		return n < 2
+	}); err != nil || n != 2 {
+		t.Errorf("scan went on after fn returned false: %d records, %v", n, err)
+	}
+	if err := ScanRecords(strings.NewReader(recordsHeader+"\nB zz 1\n"), func(Record) bool { return true }); err == nil {
+		t.Error("invalid record accepted")
+	}
+}
+
+func TestWriteChrome(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteChrome(&out, "firmware.elf", buildFixture(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		TraceEvents []chromeEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	phases := ""
+	for _, event := range doc.TraceEvents {
+		phases += event.Phase
+	}
+	if phases != "MMXXXXi" {
+		t.Fatalf("phases %s", phases)
+	}
+	if e := doc.TraceEvents[3]; e.Name != "average" || e.Time != 0.003 || e.Duration != 0.016 || e.Args["address"] != "0x401019" {
+		t.Errorf("average %+v", e)
+	}
+	if e := doc.TraceEvents[6]; e.Name != "IRQ 15" || e.Scope != "t" || e.Time != 0.014 {
+		t.Errorf("instant %+v", e)
+	}
+}
+
+type protoField struct {
+	field  int
+	number uint64
+	bytes  []byte
+}
+
+// protoFields splits an encoded message into its fields
+func protoFields(t *testing.T, data []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		data = data[n:]
+		value, n := binary.Uvarint(data)
+		data = data[n:]
+		f := protoField{field: int(tag >> 3), number: value}
+		switch tag & 7 {
+		case protoWireVarint:
+		case protoWireLengthDelimit:
+			f.bytes, data = data[:value], data[value:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func TestWritePerfetto(t *testing.T) {
+	var out bytes.Buffer
+	if err := WritePerfetto(&out, "firmware.elf", buildFixture(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	var last uint64
+	for i, packet := range protoFields(t, out.Bytes()) {
+		if packet.field != traceFieldPacket {
+			t.Fatalf("field %d in trace", packet.field)
+		}
+		var time uint64
+		for _, field := range protoFields(t, packet.bytes) {
+			switch field.field {
+			case packetFieldTimestamp:
+				time = field.number
+			case packetFieldTrackDescriptor:
+				if i != 0 {
+					t.Errorf("track descriptor in packet %d", i)
+				}
+			case packetFieldTrackEvent:
+				event := ""
+				for _, f := range protoFields(t, field.bytes) {
+					switch f.field {
+					case eventFieldType:
+						event += fmt.Sprint(f.number)
+					case eventFieldName:
+						event += string(f.bytes)
+					}
+				}
+				events = append(events, fmt.Sprintf("%s@%d", event, time))
+			}
+		}
+		if time < last {
+			t.Errorf("packet %d goes back in time", i)
+		}
+		last = time
+	}
+	want := "1_start@0 1average@3 1scale@7 2@12 3IRQ 15@14 1scale@14 2@17 2@19 2@20"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("events %s, want %s", got, want)
+	}
+}
+
+func TestBuildProfile(t *testing.T) {
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := BuildProfile(table, fixtureRecords)
+	if profile.Instructions != 20 {
+		t.Errorf("instructions %d", profile.Instructions)
+	}
+	var functions []string
+	for _, f := range profile.Functions {
+		functions = append(functions, fmt.Sprintf("%s:%d/%d:%v%%", f.Name, f.Self, f.Total, f.SelfPercent))
+	}
+	if got := strings.Join(functions, " "); got != "average:8/16:40% scale:8/8:40% _start:4/20:20%" {
+		t.Errorf("functions %s", got)
+	}
+	var stacks []string
+	for _, stack := range profile.Stacks {
+		var names []string
+		for _, fn := range stack.Functions {
+			names = append(names, fn.Name)
+		}
+		stacks = append(stacks, fmt.Sprintf("%s:%d", strings.Join(names, ";"), stack.Instructions))
+	}
+	if got := strings.Join(stacks, " "); got != "_start:4 _start;average:8 _start;average;scale:8" {
+		t.Errorf("stacks %s", got)
+	}
+
+	profile = BuildProfile(table, []Record{{Kind: BlockRecord, PC: 0x10, Instructions: 2}})
+	if len(profile.Functions) != 1 || profile.Functions[0].Name != "[unknown]" || profile.Functions[0].Self != 2 {
+		t.Errorf("profile outside of functions %+v", profile.Functions)
+	}
+}
+
+func TestBuildProfileLoops(t *testing.T) {
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := BuildProfile(table, entryLoop(20000))
+	if len(profile.Stacks) != 2 || profile.Truncated {
+		t.Fatalf("stacks of a loop at function entry %+v", profile.Stacks)
+	}
+	if stack := profile.Stacks[1]; len(stack.Functions) != 2 || stack.Functions[1].Name != "scale" || stack.Instructions != 40000 {
+		t.Errorf("stack of the loop %+v", stack)
+	}
+
+	// Mutual recursion stops growing the stacks at maxStackDepth
+	var records []Record
+	for i := 0; i < 4*maxStackDepth; i++ {
+		records = append(records, Record{Kind: BlockRecord, PC: []uint64{0x401000, 0x401019}[i%2], Instructions: 1})
+	}
+	profile = BuildProfile(table, records)
+	deepest := 0
+	for _, stack := range profile.Stacks {
+		if len(stack.Functions) > deepest {
+			deepest = len(stack.Functions)
+		}
+	}
+	if deepest != maxStackDepth || !profile.Truncated || profile.Instructions != uint64(len(records)) {
+		t.Errorf("deepest stack %d, truncated %v", deepest, profile.Truncated)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	table, err := symbols.Open("../symbols/testdata/firmware.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := WritePprof(&out, "firmware.elf", "4f2a", BuildProfile(table, fixtureRecords)); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stringTable []string
+	var samples []protoField
+	functions := map[uint64]uint64{} // Name by id
+	for _, field := range protoFields(t, data) {
+		switch field.field {
+		case profileFieldStringTable:
+			stringTable = append(stringTable, string(field.bytes))
+		case profileFieldSample:
+			samples = append(samples, field)
+		case profileFieldFunction:
+			var id, name uint64
+			for _, f := range protoFields(t, field.bytes) {
+				switch f.field {
+				case functionFieldID:
+					id = f.number
+				case functionFieldName:
+					name = f.number
+				}
+			}
+			functions[id] = name
+		}
+	}
+	if len(stringTable) == 0 || stringTable[0] != "" {
+		t.Fatalf("string table %q", stringTable)
+	}
+	var got []string
+	for _, sample := range samples {
+		var names []string
+		var value uint64
+		for _, f := range protoFields(t, sample.bytes) {
+			switch f.field {
+			case sampleFieldLocationID:
+				for ids := f.bytes; len(ids) > 0; {
+					id, n := binary.Uvarint(ids)
+					ids = ids[n:]
+					names = append(names, stringTable[functions[id]])
+				}
+			case sampleFieldValue:
+				value, _ = binary.Uvarint(f.bytes)
+			}
+		}
+		got = append(got, fmt.Sprintf("%s:%d", strings.Join(names, ";"), value))
+	}
+	if s := strings.Join(got, " "); s != "_start:4 average;_start:8 scale;average;_start:8" {
+		t.Errorf("samples %s", s)
+	}
+}